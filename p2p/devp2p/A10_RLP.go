@@ -0,0 +1,233 @@
+// what rlp makes of go values, and how to take control of it
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	demo "./common"
+)
+
+// rlp knows two things, strings of bytes and lists
+// a struct is a list of its exported fields, in the order they are declared
+// unsigned integers are big endian bytes without leading zeros, and bools are 0 or 1
+// there are no signed integers, floats or maps
+type Point struct {
+	X, Y   uint
+	Label  string
+	Hidden bool   `rlp:"-"` // left out
+	secret string // so are unexported fields
+}
+
+// a message that got a field in a later version
+// the old decoder only accepts it if it has a tail field to take what it doesn't know
+type PointV1 struct {
+	X, Y  uint
+	Label string
+	Rest  []rlp.RawValue `rlp:"tail"`
+}
+
+type PointV2 struct {
+	X, Y  uint
+	Label string
+	Color string
+}
+
+// a nil pointer is encoded as an empty value of the type it points to
+// without the nil tag it decodes as a pointer to the zero value, with it as nil
+type Reply struct {
+	To   *uint64 `rlp:"nil"`
+	Text string
+}
+
+// time.Time has no exported fields, so rlp would make it an empty list, and the time would be gone
+// as our own type it's encoded as the unix time in nanoseconds
+type Timestamp time.Time
+
+func (self Timestamp) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, uint64(time.Time(self).UnixNano()))
+}
+
+func (self *Timestamp) DecodeRLP(s *rlp.Stream) error {
+	n, err := s.Uint()
+	if err != nil {
+		return err
+	}
+	*self = Timestamp(time.Unix(0, int64(n)).UTC())
+	return nil
+}
+
+func (self Timestamp) String() string {
+	return time.Time(self).Format(time.RFC3339Nano)
+}
+
+// rlp encodes an interface by the value in it, but can't decode into one
+// the value goes in a list with a tag telling its type, so the decoder knows what to make of it
+type Body interface {
+	tag() uint64
+}
+
+type TextBody struct {
+	Text string
+}
+
+func (self *TextBody) tag() uint64 { return 1 }
+
+type PointBody struct {
+	Points []Point
+}
+
+func (self *PointBody) tag() uint64 { return 2 }
+
+var errUnknownBody = errors.New("unknown body tag")
+
+func newBody(tag uint64) (Body, error) {
+	switch tag {
+	case 1:
+		return &TextBody{}, nil
+	case 2:
+		return &PointBody{}, nil
+	}
+	return nil, errUnknownBody
+}
+
+type TaggedBody struct {
+	Body
+}
+
+func (self TaggedBody) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, []interface{}{self.tag(), self.Body})
+}
+
+func (self *TaggedBody) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
+	tag, err := s.Uint()
+	if err != nil {
+		return err
+	}
+	body, err := newBody(tag)
+	if err != nil {
+		return err
+	}
+	if err := s.Decode(body); err != nil {
+		return err
+	}
+	self.Body = body
+	return s.ListEnd()
+}
+
+// the protocol message with all of the above
+// the tail lets a newer peer add fields without breaking this one
+type NoteMsg struct {
+	Id      uint64
+	Created Timestamp
+	ReplyTo *uint64 `rlp:"nil"`
+	Body    TaggedBody
+	Rest    []rlp.RawValue `rlp:"tail"`
+}
+
+func show(name string, v interface{}) []byte {
+	b, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		demo.Log.Crit("encode fail", "value", name, "err", err)
+	}
+	fmt.Printf("%-22s %s\n", name, hexutil.Encode(b))
+	return b
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// the basics
+	// short strings get a prefix byte of 0x80 plus the length, single bytes below 0x80 are themselves
+	// lists get 0xc0 plus the length of what's in them
+	fmt.Println("values")
+	show("uint 0", uint(0))
+	show("uint 127", uint(127))
+	show("uint 1024", uint(1024))
+	show("string dog", "dog")
+	show("empty list", []uint{})
+	show("list [1 2 3]", []uint{1, 2, 3})
+
+	fmt.Println("\nstructs")
+	b := show("point", Point{X: 1, Y: 2, Label: "a", Hidden: true, secret: "s"})
+	var point Point
+	if err := rlp.DecodeBytes(b, &point); err != nil {
+		demo.Log.Crit("decode fail", "err", err)
+	}
+	fmt.Printf("%-22s %+v\n", "decoded", point)
+
+	// a newer version of a message, with a field added at the end
+	fmt.Println("\nversions")
+	b = show("point v2", PointV2{X: 1, Y: 2, Label: "a", Color: "red"})
+	err := rlp.DecodeBytes(b, &point)
+	fmt.Printf("%-22s %v\n", "as point", err)
+	var pointv1 PointV1
+	if err := rlp.DecodeBytes(b, &pointv1); err != nil {
+		demo.Log.Crit("decode fail", "err", err)
+	}
+	fmt.Printf("%-22s %d %d %s, and %d more\n", "as point v1", pointv1.X, pointv1.Y, pointv1.Label, len(pointv1.Rest))
+
+	// optional values
+	fmt.Println("\noptional")
+	to := uint64(7)
+	show("reply to 7", Reply{To: &to, Text: "yes"})
+	b = show("reply to nobody", Reply{Text: "hi"})
+	var reply Reply
+	if err := rlp.DecodeBytes(b, &reply); err != nil {
+		demo.Log.Crit("decode fail", "err", err)
+	}
+	fmt.Printf("%-22s to %v\n", "decoded", reply.To)
+
+	// our own encoders
+	fmt.Println("\ncustom")
+	created := Timestamp(time.Date(2018, 11, 1, 12, 0, 0, 0, time.UTC))
+	show("time.Time", time.Time(created))
+	show("timestamp", created)
+	show("text body", TaggedBody{&TextBody{Text: "hi"}})
+	show("point body", TaggedBody{&PointBody{Points: []Point{{X: 1, Y: 2}}}})
+	err = rlp.DecodeBytes(hexutil.MustDecode("0xc403c28080"), &TaggedBody{})
+	fmt.Printf("%-22s %v\n", "body with tag 3", err)
+
+	// the message goes over a pipe, the way a protocol sends it to a peer
+	// p2p.Send encodes it with rlp, and msg.Decode decodes it
+	fmt.Println("\nmessage")
+	rw, peer := p2p.MsgPipe()
+	defer rw.Close()
+	notes := []NoteMsg{
+		{Id: 1, Created: created, Body: TaggedBody{&TextBody{Text: "where are we?"}}},
+		{Id: 2, Created: created, ReplyTo: &to, Body: TaggedBody{&PointBody{Points: []Point{{X: 3, Y: 4, Label: "here"}}}}},
+	}
+	go func() {
+		for _, note := range notes {
+			if err := p2p.Send(rw, 0, note); err != nil {
+				demo.Log.Error("send fail", "err", err)
+				return
+			}
+		}
+	}()
+	for range notes {
+		msg, err := peer.ReadMsg()
+		if err != nil {
+			demo.Log.Crit("read fail", "err", err)
+		}
+		var note NoteMsg
+		err = msg.Decode(&note)
+		if err != nil {
+			demo.Log.Crit("decode fail", "err", err)
+		}
+		replyTo := "-"
+		if note.ReplyTo != nil {
+			replyTo = fmt.Sprintf("%d", *note.ReplyTo)
+		}
+		fmt.Printf("note %d, %d bytes, created %s, reply to %s, body %T %+v\n", note.Id, msg.Size, note.Created, replyTo, note.Body.Body, note.Body.Body)
+	}
+}
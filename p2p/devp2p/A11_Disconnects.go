@@ -0,0 +1,386 @@
+// losing the peer in the middle of an exchange, and picking up where it left off
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	demo "./common"
+)
+
+const (
+	chunkCode = iota
+	ackCode
+)
+
+const (
+	chunkCount = 10
+	ackTimeout = time.Millisecond * 500
+	downTime   = time.Second // how long the server is away after its second connection
+	minBackoff = time.Millisecond * 100
+	maxBackoff = time.Second * 2
+)
+
+var (
+	// the disconnect reasons that won't change by dialing again
+	permanentReasons = map[string]bool{
+		p2p.DiscUselessPeer.Error():         true,
+		p2p.DiscIncompatibleVersion.Error(): true,
+		p2p.DiscProtocolError.Error():       true,
+		p2p.DiscSelf.Error():                true,
+	}
+)
+
+type ChunkMsg struct {
+	Seq  uint64
+	Data []byte
+}
+
+type AckMsg struct {
+	Seq uint64
+}
+
+// the server adds up the chunks it's sent
+// a chunk it already has is answered again, but not added again, so sending a chunk twice does no harm
+// it misbehaves on purpose: on the first connection it leaves after taking chunk 4 and before answering,
+// and on the second it stops answering after chunk 6, and goes away when the client gives up on it
+type server struct {
+	mu          sync.Mutex
+	applied     map[uint64]bool
+	sum         uint64
+	duplicates  int
+	connections int
+	stalledC    chan struct{} // closed when the connection it stopped answering on has ended
+}
+
+func newServerState() *server {
+	return &server{
+		applied:  make(map[uint64]bool),
+		stalledC: make(chan struct{}),
+	}
+}
+
+// adds the chunk, and tells if it's new
+func (self *server) apply(chunk *ChunkMsg) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.applied[chunk.Seq] {
+		self.duplicates++
+		return false
+	}
+	self.applied[chunk.Seq] = true
+	self.sum += chunk.Seq
+	return true
+}
+
+func (self *server) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    "chunks",
+		Version: 1,
+		Length:  2,
+		Run:     self.run,
+	}
+}
+
+func (self *server) run(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	self.mu.Lock()
+	self.connections++
+	conn := self.connections
+	self.mu.Unlock()
+	stalled := false
+	for {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			if stalled {
+				close(self.stalledC)
+			}
+			return err
+		}
+		if msg.Code != chunkCode {
+			return fmt.Errorf("unexpected message code %d", msg.Code)
+		}
+		var chunk ChunkMsg
+		err = msg.Decode(&chunk)
+		if err != nil {
+			return fmt.Errorf("decode chunk fail: %v", err)
+		}
+		if self.apply(&chunk) {
+			demo.Log.Info("server got chunk", "seq", chunk.Seq)
+		} else {
+			demo.Log.Info("server got chunk again, answering without adding it", "seq", chunk.Seq)
+		}
+		if conn == 1 && chunk.Seq == 4 {
+			p.Disconnect(p2p.DiscRequested)
+			continue
+		}
+		if conn == 2 && chunk.Seq == 6 {
+			stalled = true
+		}
+		if stalled {
+			continue
+		}
+		err = p2p.Send(rw, ackCode, &AckMsg{Seq: chunk.Seq})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// the client sends the chunks one by one, each when the one before is answered
+// on every connection it starts from the first chunk not answered, which the server may or may not have
+type client struct {
+	mu      sync.Mutex
+	next    uint64
+	lastErr error
+}
+
+func (self *client) pending() uint64 {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.next
+}
+
+func (self *client) acked(seq uint64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.next = seq + 1
+}
+
+func (self *client) done() bool {
+	return self.pending() > chunkCount
+}
+
+// keeps the error the run loop ended with, for main to tell about
+func (self *client) fail(err error) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.lastErr = err
+	return err
+}
+
+func (self *client) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    "chunks",
+		Version: 1,
+		Length:  2,
+		Run:     self.run,
+	}
+}
+
+func (self *client) run(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	self.fail(nil)
+
+	// reading in a goroutine of its own lets the loop below wait for an answer with a timeout
+	// the channels are buffered so the reader never blocks once the loop has returned
+	ackC := make(chan uint64, chunkCount)
+	errC := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := rw.ReadMsg()
+			if err != nil {
+				errC <- err
+				return
+			}
+			var ack AckMsg
+			if msg.Code != ackCode {
+				err = fmt.Errorf("unexpected message code %d", msg.Code)
+			} else {
+				err = msg.Decode(&ack)
+			}
+			if err != nil {
+				errC <- err
+				return
+			}
+			ackC <- ack.Seq
+		}
+	}()
+
+	for !self.done() {
+		seq := self.pending()
+		err := p2p.Send(rw, chunkCode, &ChunkMsg{Seq: seq, Data: []byte(fmt.Sprintf("chunk %d", seq))})
+		if err != nil {
+			return self.fail(err)
+		}
+		select {
+		case ack := <-ackC:
+			if ack != seq {
+				return self.fail(fmt.Errorf("answer for chunk %d, expected %d", ack, seq))
+			}
+			self.acked(seq)
+		case err := <-errC:
+			return self.fail(err)
+		case <-time.After(ackTimeout):
+			// returning a DiscReason from Run disconnects with that reason, so the peer is told why
+			return self.fail(p2p.DiscReadTimeout)
+		}
+	}
+	return nil
+}
+
+// what the run loop of a protocol sees when a peer is lost, and what it means
+func describe(err error) string {
+	switch err {
+	case nil:
+		return "returned, all chunks answered"
+	case io.EOF:
+		// the protocol's reader is closed when the peer is disconnected, for whatever reason
+		// the reason itself is in the drop event
+		return "read io.EOF, the peer is gone"
+	case p2p.ErrShuttingDown:
+		return "write failed, the peer is shutting down"
+	}
+	if reason, ok := err.(p2p.DiscReason); ok {
+		return fmt.Sprintf("gave up, disconnecting with %q", reason)
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return fmt.Sprintf("network timeout: %v", err)
+	}
+	return fmt.Sprintf("protocol error: %v", err)
+}
+
+// the delay before the next dial doubles with every failure, up to the maximum,
+// and varies a little, so that many clients losing the same server don't all come back at once
+func backoff(failures int) time.Duration {
+	delay := minBackoff << uint(failures)
+	if delay > maxBackoff || delay <= 0 {
+		delay = maxBackoff
+	}
+	return delay*4/5 + time.Duration(rand.Int63n(int64(delay*2/5)))
+}
+
+// dials the node ourselves, so the backoff is ours alone
+// the dialer of the server waits 30 seconds before dialing a node it has dialed already
+func dial(srv *p2p.Server, dest *enode.Node) error {
+	fd, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%d", dest.IP(), dest.TCP()), time.Second)
+	if err != nil {
+		return err
+	}
+	// no flags: the connection is neither inbound nor to a static or trusted node, so it counts against MaxPeers like any other
+	return srv.SetupConn(fd, 0, dest)
+}
+
+func newServer(privkey *ecdsa.PrivateKey, name string, proto p2p.Protocol, port int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey:  privkey,
+		Name:        common.MakeName(name, "1"),
+		MaxPeers:    1,
+		NoDiscovery: true,
+		NoDial:      true,
+		Protocols:   []p2p.Protocol{proto},
+	}
+	if port > 0 {
+		cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+func startServer(privkey *ecdsa.PrivateKey, state *server) *p2p.Server {
+	srv := newServer(privkey, "server", state.protocol(), demo.Conf.P2PPort)
+	if err := srv.Start(); err != nil {
+		demo.Log.Crit("Start p2p.Server failed", "err", err)
+	}
+	// the server is told why the client left
+	eventC := make(chan *p2p.PeerEvent)
+	sub := srv.SubscribeEvents(eventC)
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case ev := <-eventC:
+				if ev.Type == p2p.PeerEventTypeDrop {
+					demo.Log.Info("server dropped the client", "reason", ev.Error)
+				}
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+	return srv
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// the server, which comes back with the same key and state after it's been away
+	serverkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+	state := newServerState()
+	srv := startServer(serverkey, state)
+	dest := srv.Self()
+	srvC := make(chan *p2p.Server, 1)
+	go func() {
+		<-state.stalledC
+		fmt.Println("server goes away")
+		srv.Stop()
+		time.Sleep(downTime)
+		fmt.Println("server is back")
+		srvC <- startServer(serverkey, state)
+	}()
+
+	// the client, which doesn't listen
+	clientkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+	c := &client{next: 1}
+	clientSrv := newServer(clientkey, "client", c.protocol(), 0)
+	if err := clientSrv.Start(); err != nil {
+		demo.Log.Crit("Start p2p.Server failed", "err", err)
+	}
+	defer clientSrv.Stop()
+	eventC := make(chan *p2p.PeerEvent)
+	sub := clientSrv.SubscribeEvents(eventC)
+	defer sub.Unsubscribe()
+
+	// dial, wait for the connection to end, and see if and when to dial again
+	// a connection that gets chunks through starts the backoff over, a failed dial or one that gets nowhere makes it longer
+	failures := 0
+	for {
+		if err := dial(clientSrv, dest); err != nil {
+			fmt.Printf("dial failed: %v\n", err)
+		} else {
+			from := c.pending()
+			fmt.Printf("connected, sending from chunk %d\n", from)
+			var ev *p2p.PeerEvent
+			for ev == nil || ev.Type != p2p.PeerEventTypeDrop {
+				ev = <-eventC
+			}
+			c.mu.Lock()
+			runErr := c.lastErr
+			c.mu.Unlock()
+			fmt.Printf("disconnected: %q\n  run loop: %s\n", ev.Error, describe(runErr))
+			if c.done() {
+				break
+			}
+			if permanentReasons[ev.Error] {
+				demo.Log.Crit("the server won't have us", "reason", ev.Error)
+			}
+			if c.pending() > from {
+				failures = 0
+			}
+		}
+		delay := backoff(failures)
+		failures++
+		fmt.Printf("  dialing again in %v\n", delay.Round(time.Millisecond))
+		time.Sleep(delay)
+	}
+
+	(<-srvC).Stop()
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	fmt.Printf("\nserver connections %d, chunks %d, sent again %d, sum %d (expected %d)\n", state.connections, len(state.applied), state.duplicates, state.sum, chunkCount*(chunkCount+1)/2)
+}
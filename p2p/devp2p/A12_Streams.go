@@ -0,0 +1,465 @@
+// several streams over one protocol connection, each with flow control of its own
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+
+	demo "./common"
+)
+
+const (
+	openCode = iota
+	dataCode
+	creditCode
+)
+
+const (
+	maxFrame = 1024     // the most data in one message
+	window   = 8 * 1024 // the bytes a stream may have sent and not yet read by the other side
+
+	bulkSize      = 64 * 1024
+	bulkReadDelay = time.Millisecond * 20 // the bulk reader is slow, a frame every 20 milliseconds
+	chatLines     = 10
+	chatInterval  = time.Millisecond * 30
+)
+
+var (
+	errClosed = errors.New("connection closed")
+	start     = time.Now()
+)
+
+// opens a stream, naming it so the other side knows what to do with it
+type OpenMsg struct {
+	Stream uint32
+	Name   string
+}
+
+// data on a stream; Fin is set on the last message the sender sends on it
+type DataMsg struct {
+	Stream uint32
+	Data   []byte
+	Fin    bool
+}
+
+// the receiver has read Credit bytes of the stream, and the sender may send that many more
+type CreditMsg struct {
+	Stream uint32
+	Credit uint32
+}
+
+// a stream goes both ways, and each way ends on its own
+//
+// the reader of a stream can't be slower than the window lets the sender be, so the read loop of the connection
+// only ever appends to the buffer of a stream, and never waits for a reader. A slow reader holds up its own stream, not the others
+type stream struct {
+	id   uint32
+	name string
+	mux  *mux
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	credit   int      // bytes we may send
+	buf      [][]byte // received, not yet read
+	buffered int
+	finRecv  bool
+	finSent  bool
+	err      error
+}
+
+func (self *stream) Write(b []byte) error {
+	for len(b) > 0 {
+		self.mu.Lock()
+		for self.credit == 0 && self.err == nil {
+			self.cond.Wait()
+		}
+		if self.err != nil {
+			self.mu.Unlock()
+			return self.err
+		}
+		n := len(b)
+		if n > maxFrame {
+			n = maxFrame
+		}
+		if n > self.credit {
+			n = self.credit
+		}
+		self.credit -= n
+		self.mu.Unlock()
+
+		err := p2p.Send(self.mux.rw, dataCode, &DataMsg{Stream: self.id, Data: b[:n]})
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// ends our side of the stream, the other side may still be sending
+func (self *stream) Close() error {
+	err := p2p.Send(self.mux.rw, dataCode, &DataMsg{Stream: self.id, Fin: true})
+	self.mu.Lock()
+	self.finSent = true
+	self.mu.Unlock()
+	self.mux.done(self)
+	return err
+}
+
+// returns the next data received on the stream, io.EOF when the other side has closed it
+// reading gives the sender back as much credit as was read
+func (self *stream) Read() ([]byte, error) {
+	self.mu.Lock()
+	for len(self.buf) == 0 && !self.finRecv && self.err == nil {
+		self.cond.Wait()
+	}
+	if len(self.buf) == 0 {
+		defer self.mu.Unlock()
+		if self.err != nil {
+			return nil, self.err
+		}
+		return nil, io.EOF
+	}
+	b := self.buf[0]
+	self.buf = self.buf[1:]
+	self.buffered -= len(b)
+	self.mu.Unlock()
+
+	err := p2p.Send(self.mux.rw, creditCode, &CreditMsg{Stream: self.id, Credit: uint32(len(b))})
+	return b, err
+}
+
+// called from the read loop, it must not block
+func (self *stream) receive(msg *DataMsg) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.finRecv {
+		return fmt.Errorf("stream %d: data after fin", self.id)
+	}
+	if self.buffered+len(msg.Data) > window {
+		return fmt.Errorf("stream %d: peer sent past its window", self.id)
+	}
+	if len(msg.Data) > 0 {
+		self.buf = append(self.buf, msg.Data)
+		self.buffered += len(msg.Data)
+	}
+	self.finRecv = msg.Fin
+	self.cond.Broadcast()
+	return nil
+}
+
+func (self *stream) addCredit(n uint32) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.credit += int(n)
+	self.cond.Broadcast()
+}
+
+func (self *stream) fail(err error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.err = err
+	self.cond.Broadcast()
+}
+
+// mux runs the streams of one connection
+// the side that dialed opens streams with odd ids, the other with even ones, so both can open streams without agreeing on ids first
+type mux struct {
+	rw       p2p.MsgReadWriter
+	side     string
+	onStream func(*stream) // runs in a goroutine of its own for each stream the peer opens
+
+	mu      sync.Mutex
+	nextID  uint32
+	streams map[uint32]*stream
+}
+
+func newMux(side string, p *p2p.Peer, rw p2p.MsgReadWriter, onStream func(*stream)) *mux {
+	m := &mux{
+		rw:       rw,
+		side:     side,
+		onStream: onStream,
+		streams:  make(map[uint32]*stream),
+		nextID:   2,
+	}
+	if !p.Inbound() {
+		m.nextID = 1
+	}
+	return m
+}
+
+func (self *mux) newStream(id uint32, name string) *stream {
+	s := &stream{
+		id:     id,
+		name:   name,
+		mux:    self,
+		credit: window,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	self.streams[id] = s
+	return s
+}
+
+func (self *mux) Open(name string) (*stream, error) {
+	self.mu.Lock()
+	s := self.newStream(self.nextID, name)
+	self.nextID += 2
+	self.mu.Unlock()
+	return s, p2p.Send(self.rw, openCode, &OpenMsg{Stream: s.id, Name: name})
+}
+
+// forgets the stream once it's over both ways
+func (self *mux) done(s *stream) {
+	s.mu.Lock()
+	over := s.finSent && s.finRecv
+	s.mu.Unlock()
+	if !over {
+		return
+	}
+	// both ways may end at once, the one that finds the stream still there tells of it
+	self.mu.Lock()
+	_, ok := self.streams[s.id]
+	delete(self.streams, s.id)
+	self.mu.Unlock()
+	if !ok {
+		return
+	}
+	fmt.Printf("%6v %s: stream %d %q done\n", since(), self.side, s.id, s.name)
+}
+
+func (self *mux) stream(id uint32) (*stream, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	s, ok := self.streams[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown stream %d", id)
+	}
+	return s, nil
+}
+
+// the read loop of the connection
+func (self *mux) run() error {
+	err := self.read()
+	self.mu.Lock()
+	for _, s := range self.streams {
+		s.fail(errClosed)
+	}
+	self.mu.Unlock()
+	return err
+}
+
+func (self *mux) read() error {
+	for {
+		msg, err := self.rw.ReadMsg()
+		if err != nil {
+			return err
+		}
+		switch msg.Code {
+		case openCode:
+			var open OpenMsg
+			if err := msg.Decode(&open); err != nil {
+				return err
+			}
+			self.mu.Lock()
+			_, exists := self.streams[open.Stream]
+			if exists || open.Stream%2 == self.nextID%2 {
+				self.mu.Unlock()
+				return fmt.Errorf("peer can't open stream %d", open.Stream)
+			}
+			s := self.newStream(open.Stream, open.Name)
+			self.mu.Unlock()
+			go self.onStream(s)
+
+		case dataCode:
+			var data DataMsg
+			if err := msg.Decode(&data); err != nil {
+				return err
+			}
+			s, err := self.stream(data.Stream)
+			if err != nil {
+				return err
+			}
+			if err := s.receive(&data); err != nil {
+				return err
+			}
+			if data.Fin {
+				self.done(s)
+			}
+
+		case creditCode:
+			var credit CreditMsg
+			if err := msg.Decode(&credit); err != nil {
+				return err
+			}
+			// credit for a stream that's over is late, not wrong
+			if s, err := self.stream(credit.Stream); err == nil {
+				s.addCredit(credit.Credit)
+			}
+
+		default:
+			return fmt.Errorf("unexpected message code %d", msg.Code)
+		}
+	}
+}
+
+func since() time.Duration {
+	return time.Since(start).Round(time.Millisecond)
+}
+
+// the server sends a bulk transfer on a stream it opens, and echoes what comes on the chat stream the client opens
+func serverRun(doneC chan struct{}) func(*p2p.Peer, p2p.MsgReadWriter) error {
+	return func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+		var wg sync.WaitGroup
+		m := newMux("server", p, rw, func(s *stream) {
+			defer wg.Done()
+			for {
+				b, err := s.Read()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					demo.Log.Error("chat read fail", "err", err)
+					return
+				}
+				if err := s.Write(bytes.ToUpper(b)); err != nil {
+					demo.Log.Error("chat write fail", "err", err)
+					return
+				}
+			}
+			s.Close()
+		})
+		wg.Add(2) // the bulk stream, and the chat stream we expect the client to open
+		go func() {
+			defer wg.Done()
+			s, err := m.Open("bulk")
+			if err != nil {
+				demo.Log.Error("open fail", "err", err)
+				return
+			}
+			if err := s.Write(make([]byte, bulkSize)); err != nil {
+				demo.Log.Error("bulk write fail", "err", err)
+				return
+			}
+			fmt.Printf("%6v server: bulk all sent\n", since())
+			s.Close()
+			// the bulk stream is one way, the client closes its side without sending anything
+			if _, err := s.Read(); err != io.EOF {
+				demo.Log.Error("bulk close fail", "err", err)
+			}
+		}()
+		go func() {
+			wg.Wait()
+			doneC <- struct{}{}
+		}()
+		return m.run()
+	}
+}
+
+// the client reads the bulk stream slowly, and chats on a stream of its own meanwhile
+func clientRun(doneC chan struct{}) func(*p2p.Peer, p2p.MsgReadWriter) error {
+	return func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+		var wg sync.WaitGroup
+		m := newMux("client", p, rw, func(s *stream) {
+			defer wg.Done()
+			total := 0
+			for {
+				b, err := s.Read()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					demo.Log.Error("bulk read fail", "err", err)
+					return
+				}
+				total += len(b)
+				if total%(16*1024) == 0 {
+					fmt.Printf("%6v client: bulk %d of %d bytes\n", since(), total, bulkSize)
+				}
+				time.Sleep(bulkReadDelay)
+			}
+			s.Close()
+		})
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s, err := m.Open("chat")
+			if err != nil {
+				demo.Log.Error("open fail", "err", err)
+				return
+			}
+			for i := 1; i <= chatLines; i++ {
+				if err := s.Write([]byte(fmt.Sprintf("line %d", i))); err != nil {
+					demo.Log.Error("chat write fail", "err", err)
+					return
+				}
+				b, err := s.Read()
+				if err != nil {
+					demo.Log.Error("chat read fail", "err", err)
+					return
+				}
+				fmt.Printf("%6v client: chat echo %q\n", since(), b)
+				time.Sleep(chatInterval)
+			}
+			s.Close()
+			if _, err := s.Read(); err != io.EOF {
+				demo.Log.Error("chat close fail", "err", err)
+			}
+		}()
+		go func() {
+			wg.Wait()
+			doneC <- struct{}{}
+		}()
+		return m.run()
+	}
+}
+
+func protocol(run func(*p2p.Peer, p2p.MsgReadWriter) error) p2p.Protocol {
+	return p2p.Protocol{
+		Name:    "streams",
+		Version: 1,
+		Length:  3,
+		Run:     run,
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	serverkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+	clientkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+
+	serverDoneC := make(chan struct{}, 1)
+	srv := demo.NewServer(serverkey, "server", "1", protocol(serverRun(serverDoneC)), demo.Conf.P2PPort)
+	if err := srv.Start(); err != nil {
+		demo.Log.Crit("Start p2p.Server failed", "err", err)
+	}
+	defer srv.Stop()
+
+	clientDoneC := make(chan struct{}, 1)
+	client := demo.NewServer(clientkey, "client", "1", protocol(clientRun(clientDoneC)), 0)
+	if err := client.Start(); err != nil {
+		demo.Log.Crit("Start p2p.Server failed", "err", err)
+	}
+	defer client.Stop()
+
+	start = time.Now()
+	client.AddPeer(srv.Self())
+	for _, doneC := range []chan struct{}{serverDoneC, clientDoneC} {
+		if _, err := demo.ExpectMsg(doneC, nil, time.Second*10); err != nil {
+			demo.Log.Crit("streams did not finish", "err", err)
+		}
+	}
+	fmt.Printf("%6v all streams done\n", since())
+}
@@ -9,6 +9,7 @@ import (
 )
 
 func main() {
+	defer demo.FlushLogs()
 
 	// make a new private key
 	privkey, err := crypto.GenerateKey()
@@ -33,6 +33,7 @@ func newServer(privkey *ecdsa.PrivateKey, name string, version string, port int)
 }
 
 func main() {
+	defer demo.FlushLogs()
 
 	// we need private keys for both servers
 	privkey_one, err := crypto.GenerateKey()
@@ -65,7 +66,12 @@ func main() {
 	srv_one.AddPeer(node_two)
 
 	// wait for the connection to complete
-	time.Sleep(time.Millisecond * 100)
+	err = demo.EventuallyWithin(time.Second, func() bool {
+		return srv_one.PeerCount() == 1 && srv_two.PeerCount() == 1
+	})
+	if err != nil {
+		demo.Log.Crit("connect fail", "err", err)
+	}
 
 	// inspect the results
 	demo.Log.Info("after add", "node one peers", srv_one.Peers(), "node two peers", srv_two.Peers())
@@ -35,6 +35,7 @@ func newServer(privkey *ecdsa.PrivateKey, name string, version string, port int)
 }
 
 func main() {
+	defer demo.FlushLogs()
 
 	// we need private keys for both servers
 	privkey_one, err := crypto.GenerateKey()
@@ -4,7 +4,7 @@ package main
 import (
 	"crypto/ecdsa"
 	"fmt"
-	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -13,10 +13,6 @@ import (
 	demo "./common"
 )
 
-var (
-	messageW = &sync.WaitGroup{}
-)
-
 type FooMsg struct {
 	V uint
 }
@@ -79,6 +75,7 @@ func newServer(privkey *ecdsa.PrivateKey, name string, version string, port int)
 }
 
 func main() {
+	defer demo.FlushLogs()
 
 	// we need private keys for both servers
 	privkey_one, err := crypto.GenerateKey()
@@ -105,9 +102,10 @@ func main() {
 
 	// set up the event subscriptions on both servers
 	// the Err() on the Subscription object returns when subscription is closed
+	// each tells on its own channel when its server got the message
 	eventOneC := make(chan *p2p.PeerEvent)
+	msgOneC := make(chan *p2p.PeerEvent, 1)
 	sub_one := srv_one.SubscribeEvents(eventOneC)
-	messageW.Add(1)
 	go func() {
 		for {
 			peerevent := <-eventOneC
@@ -115,16 +113,15 @@ func main() {
 				demo.Log.Debug("Received peer add notification on node #1", "peer", peerevent.Peer)
 			} else if peerevent.Type == "msgrecv" {
 				demo.Log.Info("Received message nofification on node #1", "event", peerevent)
-
-				messageW.Done()
+				msgOneC <- peerevent
 				return
 			}
 		}
 	}()
 
 	eventTwoC := make(chan *p2p.PeerEvent)
+	msgTwoC := make(chan *p2p.PeerEvent, 1)
 	sub_two := srv_two.SubscribeEvents(eventTwoC)
-	messageW.Add(1)
 	go func() {
 		for {
 			peerevent := <-eventTwoC
@@ -132,7 +129,7 @@ func main() {
 				demo.Log.Debug("Received peer add notification on node #2", "peer", peerevent.Peer)
 			} else if peerevent.Type == "msgrecv" {
 				demo.Log.Info("Received message nofification on node #2", "event", peerevent)
-				messageW.Done()
+				msgTwoC <- peerevent
 				return
 			}
 		}
@@ -146,7 +143,12 @@ func main() {
 	srv_one.AddPeer(node_two)
 
 	// wait for each respective message to be delivered on both sides
-	messageW.Wait()
+	for i, msgC := range []chan *p2p.PeerEvent{msgOneC, msgTwoC} {
+		_, err := demo.ExpectMsg(msgC, nil, time.Second*5)
+		if err != nil {
+			demo.Log.Crit("message notification fail", "node", i+1, "err", err)
+		}
+	}
 
 	// terminate subscription loops and unsubscribe
 	sub_one.Unsubscribe()
@@ -4,7 +4,7 @@ package main
 import (
 	"crypto/ecdsa"
 	"fmt"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -12,11 +12,12 @@ import (
 	"github.com/ethereum/go-ethereum/p2p"
 
 	demo "./common"
+	"./envelope"
 )
 
 var (
-	protoW = &sync.WaitGroup{}
-	pingW  = &sync.WaitGroup{}
+	protoC = make(chan struct{}, 2) // each protocol tells it's done
+	pongs  int32                    // the pongs received by either side
 )
 
 type FooPingMsg struct {
@@ -36,7 +37,6 @@ var (
 		Length:  1,
 		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
 
-			pingW.Add(1)
 			ponged := false
 
 			// create the message structure
@@ -45,8 +45,9 @@ var (
 				Created: time.Now(),
 			}
 
-			// send the message
-			err := p2p.Send(rw, 0, msg)
+			// send the message, in an envelope that tells the other side how to decode it
+			// rlp would silently drop the time, since time.Time has no exported fields, so we use json
+			err := envelope.Send(rw, 0, envelope.JSON, msg)
 			if err != nil {
 				return fmt.Errorf("Send p2p message fail: %v", err)
 			}
@@ -62,7 +63,7 @@ var (
 
 				// decode the message and check the contents
 				var decodedmsg FooPingMsg
-				err = msg.Decode(&decodedmsg)
+				err = envelope.DecodeMsg(msg, &decodedmsg)
 				if err != nil {
 					return fmt.Errorf("Decode p2p message fail: %v", err)
 				}
@@ -70,14 +71,14 @@ var (
 				if decodedmsg.Pong {
 					demo.Log.Info("received pong", "peer", p)
 					ponged = true
-					pingW.Done()
+					atomic.AddInt32(&pongs, 1)
 				} else {
 					demo.Log.Info("received ping", "peer", p)
 					msg := FooPingMsg{
 						Pong:    true,
 						Created: time.Now(),
 					}
-					err := p2p.Send(rw, 0, msg)
+					err := envelope.Send(rw, 0, envelope.JSON, msg)
 					if err != nil {
 						return fmt.Errorf("Send p2p message fail: %v", err)
 					}
@@ -87,8 +88,13 @@ var (
 			}
 
 			// terminate the protocol after all involved have completed the cycle
-			pingW.Wait()
-			protoW.Done()
+			err = demo.EventuallyWithin(time.Second*5, func() bool {
+				return atomic.LoadInt32(&pongs) == 2
+			})
+			if err != nil {
+				return fmt.Errorf("Wait for the other pong fail: %v", err)
+			}
+			protoC <- struct{}{}
 			return nil
 		},
 	}
@@ -116,6 +122,7 @@ func newServer(privkey *ecdsa.PrivateKey, name string, version string, port int)
 }
 
 func main() {
+	defer demo.FlushLogs()
 
 	// we need private keys for both servers
 	privkey_one, err := crypto.GenerateKey()
@@ -144,7 +151,6 @@ func main() {
 	// the Err() on the Subscription object returns when subscription is closed
 	eventOneC := make(chan *p2p.PeerEvent)
 	sub_one := srv_one.SubscribeEvents(eventOneC)
-	protoW.Add(1)
 	go func() {
 		for {
 			select {
@@ -162,7 +168,6 @@ func main() {
 
 	eventTwoC := make(chan *p2p.PeerEvent)
 	sub_two := srv_two.SubscribeEvents(eventTwoC)
-	protoW.Add(1)
 	go func() {
 		for {
 			select {
@@ -186,7 +191,12 @@ func main() {
 	srv_one.AddPeer(node_two)
 
 	// wait for each respective message to be delivered on both sides
-	protoW.Wait()
+	for i := 0; i < 2; i++ {
+		_, err := demo.ExpectMsg(protoC, nil, time.Second*5)
+		if err != nil {
+			demo.Log.Crit("protocol fail", "err", err)
+		}
+	}
 
 	// terminate subscription loops and unsubscribe
 	sub_one.Unsubscribe()
@@ -0,0 +1,200 @@
+// connection limits; max peers, dial ratio and trusted peers
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	demo "./common"
+)
+
+const (
+	hubPort        = 31240
+	clientBasePort = 31241
+	clientCount    = 5
+	connectTimeout = time.Second * 5
+)
+
+// tells main what happened with a connection attempt to the hub
+type connResult struct {
+	id       enode.ID
+	accepted bool
+	reason   string
+}
+
+var (
+	resultC = make(chan connResult, clientCount*2)
+)
+
+// the hub is the server whose limits we are testing
+//
+// MaxPeers is the total amount of peers the server will accept
+// inbound and outbound together
+//
+// DialRatio reserves some of these slots for the connections the server makes itself
+// with DialRatio 3 a third of the slots are for outbound connections, so only two of the three peers may connect to us
+// (this only applies when the server can dial by itself, that is when discovery is on)
+//
+// MaxPendingPeers limits how many connections can be in the handshake phase at the same time
+// with 1 the handshakes are done one after the other
+func newHub(privkey *ecdsa.PrivateKey) *p2p.Server {
+
+	// the server doesn't emit events for the connections it refuses
+	// but it does log them, so we give it a logger that reports them back to us
+	logger := log.New("server", "hub")
+	logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+		if r.Msg != "Rejected peer before protocol handshake" && r.Msg != "Rejected peer" {
+			return nil
+		}
+		var result connResult
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			switch r.Ctx[i] {
+			case "id":
+				result.id, _ = r.Ctx[i+1].(enode.ID)
+			case "err":
+				result.reason = fmt.Sprintf("%v", r.Ctx[i+1])
+			}
+		}
+		resultC <- result
+		return nil
+	}))
+
+	cfg := p2p.Config{
+		PrivateKey:      privkey,
+		Name:            common.MakeName("hub", "42"),
+		ListenAddr:      fmt.Sprintf(":%d", hubPort),
+		MaxPeers:        3,
+		DialRatio:       3,
+		MaxPendingPeers: 1,
+		EnableMsgEvents: true,
+		Logger:          logger,
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+// the clients are plain servers with room for one peer
+func newClient(privkey *ecdsa.PrivateKey, name string, port int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey:  privkey,
+		Name:        common.MakeName(name, "42"),
+		ListenAddr:  fmt.Sprintf(":%d", port),
+		MaxPeers:    1,
+		NoDiscovery: true,
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+// waits for the outcome of a connection attempt from or to the given node
+func waitResult(name string, id enode.ID) {
+	timeout := time.NewTimer(connectTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case r := <-resultC:
+			if r.id != id {
+				continue
+			}
+			if r.accepted {
+				demo.Log.Info("connection accepted", "client", name, "id", id)
+			} else {
+				demo.Log.Warn("connection rejected", "client", name, "id", id, "reason", r.reason)
+			}
+			return
+		case <-timeout.C:
+			demo.Log.Crit("timed out waiting for connection result", "client", name)
+		}
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// start the hub
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+	hub := newHub(privkey)
+	err = hub.Start()
+	if err != nil {
+		demo.Log.Crit("Start hub failed", "err", err)
+	}
+
+	// the connections that are accepted show up as events
+	eventC := make(chan *p2p.PeerEvent)
+	sub := hub.SubscribeEvents(eventC)
+	go func() {
+		for ev := range eventC {
+			if ev.Type == p2p.PeerEventTypeAdd {
+				resultC <- connResult{
+					id:       ev.Peer,
+					accepted: true,
+				}
+			}
+		}
+	}()
+
+	// start the clients
+	var clients []*p2p.Server
+	for i := 0; i < clientCount; i++ {
+		privkey, err := crypto.GenerateKey()
+		if err != nil {
+			demo.Log.Crit("Generate private key failed", "err", err)
+		}
+		client := newClient(privkey, fmt.Sprintf("client%d", i), clientBasePort+i)
+		err = client.Start()
+		if err != nil {
+			demo.Log.Crit("Start client failed", "client", i, "err", err)
+		}
+		clients = append(clients, client)
+	}
+
+	// the last client is trusted by the hub
+	// trusted peers are let in even if all the slots are taken
+	trusted := clients[clientCount-1]
+	hub.AddTrustedPeer(trusted.Self())
+
+	// the first two clients connect to the hub, and fill up the inbound slots
+	for i := 0; i < 2; i++ {
+		clients[i].AddPeer(hub.Self())
+		waitResult(fmt.Sprintf("client%d", i), clients[i].Self().ID())
+	}
+
+	// the third client is refused, even though the hub only has two of its three peers
+	// the last slot is reserved for a connection the hub makes itself
+	clients[2].AddPeer(hub.Self())
+	waitResult("client2", clients[2].Self().ID())
+
+	// so the hub can still connect to the fourth client
+	// (connections we ask for explicitly with AddPeer are also exempt from MaxPeers)
+	hub.AddPeer(clients[3].Self())
+	waitResult("client3", clients[3].Self().ID())
+	demo.Log.Info("hub is full", "peers", hub.PeerCount(), "maxpeers", hub.MaxPeers)
+
+	// the trusted client gets in, even though the hub is full
+	trusted.AddPeer(hub.Self())
+	waitResult(fmt.Sprintf("client%d", clientCount-1), trusted.Self().ID())
+	demo.Log.Info("after trusted peer", "peers", hub.PeerCount(), "maxpeers", hub.MaxPeers)
+
+	// the refused client keeps trying, since we asked it to connect with AddPeer
+	// it can be stopped with RemovePeer
+	clients[2].RemovePeer(hub.Self())
+
+	// stop everything
+	sub.Unsubscribe()
+	for _, client := range clients {
+		client.Stop()
+	}
+	hub.Stop()
+}
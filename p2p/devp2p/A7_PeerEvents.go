@@ -0,0 +1,291 @@
+// all the peer event types, aggregated into per-peer statistics
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	demo "./common"
+	"./envelope"
+)
+
+const (
+	pingCount = 3
+)
+
+// the messages of the "foo" protocol
+// the message codes are the position in the list; ping is 0, pong is 1
+const (
+	fooPingCode = iota
+	fooPongCode
+)
+
+type FooPingMsg struct {
+	Seq     uint
+	Created time.Time
+}
+
+type FooPongMsg struct {
+	Seq uint
+}
+
+// the message of the "bar" protocol
+type BarNoteMsg struct {
+	Text string
+}
+
+var (
+	protoW = &sync.WaitGroup{}
+)
+
+// "foo": the peer that dialed sends some pings, the other side answers each with a pong
+func newFooProtocol(dialer bool) p2p.Protocol {
+	return p2p.Protocol{
+		Name:    "foo",
+		Version: 1,
+		Length:  2,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			if dialer {
+				for i := uint(0); i < pingCount; i++ {
+					err := envelope.Send(rw, fooPingCode, envelope.JSON, &FooPingMsg{Seq: i, Created: time.Now()})
+					if err != nil {
+						return err
+					}
+				}
+			}
+			pongs := 0
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				switch msg.Code {
+				case fooPingCode:
+					var ping FooPingMsg
+					err = envelope.DecodeMsg(msg, &ping)
+					if err != nil {
+						return err
+					}
+					err = envelope.Send(rw, fooPongCode, envelope.RLP, &FooPongMsg{Seq: ping.Seq})
+					if err != nil {
+						return err
+					}
+				case fooPongCode:
+					msg.Discard()
+					pongs++
+					if pongs == pingCount {
+						protoW.Done()
+					}
+				default:
+					return fmt.Errorf("unknown message code %d", msg.Code)
+				}
+			}
+		},
+	}
+}
+
+// "bar": both sides send one note
+func newBarProtocol(name string) p2p.Protocol {
+	return p2p.Protocol{
+		Name:    "bar",
+		Version: 1,
+		Length:  1,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			err := envelope.Send(rw, 0, envelope.RLP, &BarNoteMsg{Text: fmt.Sprintf("hello from %s", name)})
+			if err != nil {
+				return err
+			}
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				msg.Discard()
+				protoW.Done()
+			}
+		},
+	}
+}
+
+// the statistics we keep for each peer
+type peerStats struct {
+	added   int
+	dropped int
+	errors  []string
+	// messages and bytes, per protocol and message code
+	sent      map[string]int
+	sentBytes map[string]uint32
+	recv      map[string]int
+	recvBytes map[string]uint32
+}
+
+func newPeerStats() *peerStats {
+	return &peerStats{
+		sent:      make(map[string]int),
+		sentBytes: make(map[string]uint32),
+		recv:      make(map[string]int),
+		recvBytes: make(map[string]uint32),
+	}
+}
+
+// collects the events from one server
+type eventCollector struct {
+	name  string
+	stats map[enode.ID]*peerStats
+	mu    sync.Mutex
+}
+
+// every event type is handled separately, to show what each of them carry
+//
+// add and drop tell us about the connection, and only the drop has anything in Error
+// msgsend and msgrecv are only emitted if the server has EnableMsgEvents set
+// they carry the name of the protocol, the message code within that protocol, and the size of the message
+// messages of the devp2p base protocol (like the keepalive pings) don't generate events
+func (c *eventCollector) handle(ev *p2p.PeerEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats, ok := c.stats[ev.Peer]
+	if !ok {
+		stats = newPeerStats()
+		c.stats[ev.Peer] = stats
+	}
+	switch ev.Type {
+	case p2p.PeerEventTypeAdd:
+		stats.added++
+		demo.Log.Info("peer added", "node", c.name, "peer", ev.Peer)
+	case p2p.PeerEventTypeDrop:
+		stats.dropped++
+		stats.errors = append(stats.errors, ev.Error)
+		demo.Log.Info("peer dropped", "node", c.name, "peer", ev.Peer, "err", ev.Error)
+	case p2p.PeerEventTypeMsgSend:
+		key := fmt.Sprintf("%s/%d", ev.Protocol, *ev.MsgCode)
+		stats.sent[key]++
+		stats.sentBytes[key] += *ev.MsgSize
+		demo.Log.Debug("message sent", "node", c.name, "peer", ev.Peer, "protocol", ev.Protocol, "code", *ev.MsgCode, "size", *ev.MsgSize)
+	case p2p.PeerEventTypeMsgRecv:
+		key := fmt.Sprintf("%s/%d", ev.Protocol, *ev.MsgCode)
+		stats.recv[key]++
+		stats.recvBytes[key] += *ev.MsgSize
+		demo.Log.Debug("message received", "node", c.name, "peer", ev.Peer, "protocol", ev.Protocol, "code", *ev.MsgCode, "size", *ev.MsgSize)
+	default:
+		demo.Log.Warn("unknown event type", "node", c.name, "type", ev.Type)
+	}
+}
+
+func (c *eventCollector) report() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, stats := range c.stats {
+		demo.Log.Info("peer stats", "node", c.name, "peer", id, "added", stats.added, "dropped", stats.dropped, "errors", stats.errors)
+		var keys []string
+		for key := range stats.sent {
+			keys = append(keys, key)
+		}
+		for key := range stats.recv {
+			if _, ok := stats.sent[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			demo.Log.Info("peer message stats", "node", c.name, "peer", id, "protocol/code", key, "sent", stats.sent[key], "sentbytes", stats.sentBytes[key], "recv", stats.recv[key], "recvbytes", stats.recvBytes[key])
+		}
+	}
+}
+
+// create a server
+func newServer(privkey *ecdsa.PrivateKey, name string, port int, protos ...p2p.Protocol) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey:      privkey,
+		Name:            common.MakeName(name, "42"),
+		MaxPeers:        1,
+		Protocols:       protos,
+		EnableMsgEvents: true,
+	}
+	if port > 0 {
+		cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// we need private keys for both servers
+	privkey_one, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key #1 failed", "err", err)
+	}
+	privkey_two, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key #2 failed", "err", err)
+	}
+
+	// set up the two servers, both running both protocols
+	srv_one := newServer(privkey_one, "one", 0, newFooProtocol(true), newBarProtocol("one"))
+	err = srv_one.Start()
+	if err != nil {
+		demo.Log.Crit("Start p2p.Server #1 failed", "err", err)
+	}
+	srv_two := newServer(privkey_two, "two", 31234, newFooProtocol(false), newBarProtocol("two"))
+	err = srv_two.Start()
+	if err != nil {
+		demo.Log.Crit("Start p2p.Server #2 failed", "err", err)
+	}
+
+	// subscribe to the events of both servers
+	var collectors []*eventCollector
+	var subs []event.Subscription
+	dropW := &sync.WaitGroup{}
+	for i, srv := range []*p2p.Server{srv_one, srv_two} {
+		collector := &eventCollector{
+			name:  fmt.Sprintf("%d", i+1),
+			stats: make(map[enode.ID]*peerStats),
+		}
+		collectors = append(collectors, collector)
+		eventC := make(chan *p2p.PeerEvent)
+		subs = append(subs, srv.SubscribeEvents(eventC))
+		dropW.Add(1)
+		go func(eventC chan *p2p.PeerEvent) {
+			for ev := range eventC {
+				collector.handle(ev)
+				if ev.Type == p2p.PeerEventTypeDrop {
+					dropW.Done()
+				}
+			}
+		}(eventC)
+	}
+
+	// one pong sequence and two notes
+	protoW.Add(3)
+
+	// connect, and wait for the protocols to complete
+	srv_one.AddPeer(srv_two.Self())
+	protoW.Wait()
+
+	// disconnect the peers, which gives a drop event on both sides
+	srv_one.RemovePeer(srv_two.Self())
+	dropW.Wait()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+	for _, collector := range collectors {
+		collector.report()
+	}
+
+	// stop the servers
+	srv_two.Stop()
+	srv_one.Stop()
+}
@@ -0,0 +1,152 @@
+// finding nodes through a signed node list in DNS (EIP-1459)
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	demo "./common"
+	"./dnsdisc"
+)
+
+const (
+	serverBasePort = 31250
+	serverCount    = 3
+	connectTimeout = time.Second * 5
+
+	// the domains the lists are published under
+	// they are only served by our own dns server, so they don't need to exist
+	mainDomain  = "nodes.demo.example"
+	otherDomain = "more.demo.example"
+)
+
+// create a server
+func newServer(privkey *ecdsa.PrivateKey, name string, port int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey:  privkey,
+		Name:        common.MakeName(name, "42"),
+		MaxPeers:    serverCount,
+		NoDiscovery: true,
+	}
+	if port > 0 {
+		cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+// makes a node list of the servers, and signs it with a new key
+// the url tells where to find the list, and what key it must be signed with
+func makeList(domain string, servers []*p2p.Server, links []string) (map[string]string, string) {
+	nodes := make([]*enode.Node, 0, len(servers))
+	for _, srv := range servers {
+
+		// the server's own node has a record signed by the server's node key
+		// nobody else can make it, which is why the list holds records and not enode urls
+		nodes = append(nodes, srv.Self())
+	}
+	tree, err := dnsdisc.MakeTree(1, nodes, links)
+	if err != nil {
+		demo.Log.Crit("make tree fail", "err", err)
+	}
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate signing key failed", "err", err)
+	}
+	url, err := tree.Sign(key, domain)
+	if err != nil {
+		demo.Log.Crit("sign tree fail", "err", err)
+	}
+	return tree.ToTXT(domain), url
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// start the servers that will be in the lists
+	var servers []*p2p.Server
+	for i := 0; i < serverCount; i++ {
+		privkey, err := crypto.GenerateKey()
+		if err != nil {
+			demo.Log.Crit("Generate private key failed", "err", err)
+		}
+		srv := newServer(privkey, fmt.Sprintf("server%d", i), serverBasePort+i)
+		err = srv.Start()
+		if err != nil {
+			demo.Log.Crit("Start p2p.Server failed", "server", i, "err", err)
+		}
+		defer srv.Stop()
+		servers = append(servers, srv)
+	}
+
+	// the last server is in a list of its own, which the main list links to
+	// this is how lists maintained by different people can be combined
+	records, otherurl := makeList(otherDomain, servers[serverCount-1:], nil)
+	mainrecords, url := makeList(mainDomain, servers[:serverCount-1], []string{otherurl})
+	for name, txt := range mainrecords {
+		records[name] = txt
+	}
+	for name, txt := range records {
+		demo.Log.Debug("txt record", "name", name, "txt", txt)
+	}
+
+	// serve the lists on a local dns server
+	dnssrv := dnsdisc.NewServer(records)
+	err := dnssrv.Listen("127.0.0.1:0")
+	if err != nil {
+		demo.Log.Crit("dns server fail", "err", err)
+	}
+	defer dnssrv.Close()
+	demo.Log.Info("serving node lists", "dns", dnssrv.Addr(), "url", url)
+
+	// the client only knows the url
+	// the resolver asks our dns server, with the system resolver the same would work for a list published in real dns
+	client := dnsdisc.NewClient(dnsdisc.NewResolver(dnssrv.Addr().String()))
+	nodes, err := client.Resolve(url)
+	if err != nil {
+		demo.Log.Crit("resolve fail", "err", err)
+	}
+	for _, n := range nodes {
+		demo.Log.Info("found node", "id", n.ID(), "ip", n.IP(), "tcp", n.TCP())
+	}
+
+	// connect to all the nodes we found
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+	srv := newServer(privkey, "client", 0)
+	err = srv.Start()
+	if err != nil {
+		demo.Log.Crit("Start p2p.Server failed", "err", err)
+	}
+	defer srv.Stop()
+	eventC := make(chan *p2p.PeerEvent)
+	sub := srv.SubscribeEvents(eventC)
+	defer sub.Unsubscribe()
+	for _, n := range nodes {
+		srv.AddPeer(n)
+	}
+
+	timeout := time.NewTimer(connectTimeout)
+	defer timeout.Stop()
+	for added := 0; added < len(nodes); {
+		select {
+		case ev := <-eventC:
+			if ev.Type == p2p.PeerEventTypeAdd {
+				added++
+				demo.Log.Info("connected", "peer", ev.Peer)
+			}
+		case <-timeout.C:
+			demo.Log.Crit("timed out connecting to nodes")
+		}
+	}
+	demo.Log.Info("connected to all nodes in the lists", "peers", srv.PeerCount())
+}
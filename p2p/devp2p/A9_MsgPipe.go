@@ -0,0 +1,76 @@
+// protocol handlers talking over an in-memory pipe, without any server or networking
+package main
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+
+	demo "./common"
+	"./envelope"
+	"./pingpong"
+)
+
+func main() {
+	defer demo.FlushLogs()
+
+	// a pipe is two connected message readwriters
+	// it's what a protocol's Run function gets from the server, only without the connection behind it
+	// writing blocks until the other end has read the message, so everything happens in the same order every time
+	rw, peer := p2p.MsgPipe()
+
+	// one end gets the handler of the foo protocol
+	// the handler's clock is fixed, so the pongs are always the same
+	now := time.Date(2018, 11, 1, 12, 0, 0, 0, time.UTC)
+	handler := pingpong.NewHandler()
+	handler.Now = func() time.Time {
+		return now
+	}
+	errC := make(chan error, 1)
+	go func() {
+		errC <- handler.Run(rw)
+	}()
+
+	// we play the peer on the other end
+	for i := 0; i < 3; i++ {
+		err := pingpong.Send(peer, &pingpong.Msg{Created: time.Now()})
+		if err != nil {
+			demo.Log.Crit("send ping fail", "err", err)
+		}
+		msg, err := peer.ReadMsg()
+		if err != nil {
+			demo.Log.Crit("read pong fail", "err", err)
+		}
+		var pong pingpong.Msg
+		err = pingpong.DecodeMsg(msg, &pong)
+		if err != nil {
+			demo.Log.Crit("decode pong fail", "err", err)
+		}
+		demo.Log.Info("got pong", "n", i, "pong", pong.Pong, "created", pong.Created)
+	}
+
+	// p2p.ExpectMsg checks the code and the exact payload of the next message, handy in tests
+	// with the fixed clock we know the bytes of the pong beforehand
+	// note that it only reads the payload when the size matches, otherwise the writer is left waiting
+	want, err := envelope.Wrap(envelope.JSON, &pingpong.Msg{Pong: true, Created: now})
+	if err != nil {
+		demo.Log.Crit("wrap fail", "err", err)
+	}
+	err = pingpong.Send(peer, &pingpong.Msg{})
+	if err != nil {
+		demo.Log.Crit("send ping fail", "err", err)
+	}
+	err = p2p.ExpectMsg(peer, 0, want)
+	if err != nil {
+		demo.Log.Crit("unexpected pong", "err", err)
+	}
+	demo.Log.Info("pong as expected")
+
+	// a message the handler refuses ends it, like a misbehaving peer would get disconnected
+	err = p2p.Send(peer, 0, make([]byte, pingpong.MaxMsgSize))
+	if err != nil {
+		demo.Log.Crit("send fail", "err", err)
+	}
+	demo.Log.Info("handler ended", "err", <-errC, "pongs", handler.Pongs)
+	peer.Close()
+}
@@ -2,7 +2,6 @@
 package main
 
 import (
-	"net"
 	"os"
 
 	"github.com/ethereum/go-ethereum/rpc"
@@ -22,6 +21,7 @@ func (api *FooAPI) HelloWorld() (string, error) {
 }
 
 func main() {
+	defer demo.FlushLogs()
 
 	// set up the RPC server
 	rpcsrv := rpc.NewServer()
@@ -31,8 +31,9 @@ func main() {
 	}
 
 	// create IPC endpoint
-	ipcpath := ".demo.ipc"
-	ipclistener, err := net.Listen("unix", ipcpath)
+	// this is a unix socket file in the working directory, or a named pipe on windows
+	ipcpath := demo.IPCPath("", ".demo.ipc")
+	ipclistener, err := demo.ListenIPC(ipcpath)
 	if err != nil {
 		demo.Log.Crit("IPC endpoint create fail", "err", err)
 	}
@@ -2,7 +2,6 @@
 package main
 
 import (
-	"net"
 	"os"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -14,6 +13,7 @@ import (
 )
 
 func main() {
+	defer demo.FlushLogs()
 
 	// make a new private key
 	privkey, err := crypto.GenerateKey()
@@ -44,8 +44,8 @@ func main() {
 	}
 
 	// create IPC endpoint
-	ipcpath := "demo.ipc"
-	ipclistener, err := net.Listen("unix", ipcpath)
+	ipcpath := demo.IPCPath("", demo.IPCName)
+	ipclistener, err := demo.ListenIPC(ipcpath)
 	if err != nil {
 		demo.Log.Crit("IPC endpoint create fail", "err", err)
 	}
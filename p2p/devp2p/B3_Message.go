@@ -4,9 +4,9 @@ package main
 import (
 	"crypto/ecdsa"
 	"fmt"
-	"net"
 	"os"
-	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -14,13 +14,14 @@ import (
 	"github.com/ethereum/go-ethereum/rpc"
 
 	demo "./common"
+	"./envelope"
 )
 
 var (
-	protoW   = &sync.WaitGroup{}
-	messageW = &sync.WaitGroup{}
-	msgC     = make(chan string)
-	ipcpath  = ".demo.ipc"
+	protoC  = make(chan struct{}, 2) // each protocol tells it's done
+	sent    int32                    // the messages the servers told were sent
+	msgC    = make(chan string)
+	ipcpath = demo.IPCPath("", ".demo.ipc")
 )
 
 // create a protocol that can take care of message sending
@@ -48,16 +49,21 @@ var (
 				}
 
 				// send the message
-				err := p2p.Send(rw, 0, outmsg)
+				err := envelope.Send(rw, 0, envelope.RLP, outmsg)
 				if err != nil {
 					return fmt.Errorf("Send p2p message fail: %v", err)
 				}
 				demo.Log.Info("sending message", "peer", p, "msg", outmsg)
 			}
 
-			// wait for the subscriptions to end
-			messageW.Wait()
-			protoW.Done()
+			// wait for the subscriptions to tell the message was sent
+			err := demo.EventuallyWithin(time.Second*5, func() bool {
+				return atomic.LoadInt32(&sent) > 0
+			})
+			if err != nil {
+				return fmt.Errorf("Wait for message sent fail: %v", err)
+			}
+			protoC <- struct{}{}
 
 			// terminate the protocol
 			return nil
@@ -108,7 +114,7 @@ func newRPCServer() (*rpc.Server, error) {
 	}
 
 	// create IPC endpoint
-	ipclistener, err := net.Listen("unix", ipcpath)
+	ipclistener, err := demo.ListenIPC(ipcpath)
 	if err != nil {
 		return nil, fmt.Errorf("IPC endpoint create fail: %v", err)
 	}
@@ -126,6 +132,7 @@ func newRPCServer() (*rpc.Server, error) {
 }
 
 func main() {
+	defer demo.FlushLogs()
 
 	// we need private keys for both servers
 	privkey_one, err := crypto.GenerateKey()
@@ -162,7 +169,7 @@ func main() {
 					demo.Log.Debug("Received peer add notification on node #1", "peer", peerevent.Peer)
 				} else if peerevent.Type == "msgsend" {
 					demo.Log.Info("Received message send notification on node #1", "event", peerevent)
-					messageW.Done()
+					atomic.AddInt32(&sent, 1)
 				}
 			case <-sub_one.Err():
 				return
@@ -180,7 +187,7 @@ func main() {
 					demo.Log.Debug("Received peer add notification on node #2", "peer", peerevent.Peer)
 				} else if peerevent.Type == "msgsend" {
 					demo.Log.Info("Received message send notification on node #2", "event", peerevent)
-					messageW.Done()
+					atomic.AddInt32(&sent, 1)
 				}
 			case <-sub_two.Err():
 				return
@@ -208,18 +215,19 @@ func main() {
 		demo.Log.Crit("IPC dial fail", "err", err)
 	}
 
-	// wait for one message be sent, and both protocols to end
-	messageW.Add(1)
-	protoW.Add(2)
-
 	// call the RPC method
 	err = rpcclient.Call(nil, "foo_sendMsg", "foobar")
 	if err != nil {
 		demo.Log.Crit("RPC call fail", "err", err)
 	}
 
-	// wait for protocols to finish
-	protoW.Wait()
+	// wait for one message be sent, and both protocols to finish
+	for i := 0; i < 2; i++ {
+		_, err := demo.ExpectMsg(protoC, nil, time.Second*5)
+		if err != nil {
+			demo.Log.Crit("protocol fail", "err", err)
+		}
+	}
 
 	// terminate subscription loops and unsubscribe
 	sub_one.Unsubscribe()
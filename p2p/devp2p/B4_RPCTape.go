@@ -0,0 +1,113 @@
+// recording the rpc session with two nodes, and playing it back without them
+//
+//	go run B4_RPCTape.go -rpc-record b4.tape
+//	go run B4_RPCTape.go -rpc-replay b4.tape
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	demo "./common"
+)
+
+const (
+	pingCount = 3
+)
+
+// starts a node running the foo service, and returns its IPC endpoint
+func startNode(port int) (*node.Node, string) {
+	stack, err := demo.NewServiceNode(port, 0, 0)
+	if err != nil {
+		demo.Log.Crit("ServiceNode create failed", "err", err)
+	}
+	err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		return demo.NewFooService(), nil
+	})
+	if err != nil {
+		demo.Log.Crit("Register service in ServiceNode failed", "err", err)
+	}
+	if err := stack.Start(); err != nil {
+		demo.Log.Crit("ServiceNode start failed", "err", err)
+	}
+	return stack, stack.IPCEndpoint()
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// played back, the nodes aren't needed, everything the example knows of them comes over rpc
+	var endpoints [2]string
+	if !demo.Replaying() {
+		for i := range endpoints {
+			stack, endpoint := startNode(demo.Conf.P2PPort + i)
+			defer demo.RemoveDataDir(stack.DataDir())
+			defer stack.Stop()
+			endpoints[i] = endpoint
+		}
+	}
+
+	client, err := demo.DialRPC(endpoints[0])
+	if err != nil {
+		demo.Log.Crit("dial one fail", "err", err)
+	}
+	defer client.Close()
+	client_two, err := demo.DialRPC(endpoints[1])
+	if err != nil {
+		demo.Log.Crit("dial two fail", "err", err)
+	}
+	defer client_two.Close()
+
+	var info p2p.NodeInfo
+	err = client_two.Call(&info, "admin_nodeInfo")
+	if err != nil {
+		demo.Log.Crit("nodeinfo fail", "err", err)
+	}
+	fmt.Printf("node two is %s\n", info.Enode)
+
+	// the peer events of node one tell when the connection is up
+	eventC := make(chan *p2p.PeerEvent)
+	sub, err := client.Subscribe(context.Background(), "admin", eventC, "peerEvents")
+	if err != nil {
+		demo.Log.Crit("subscribe fail", "err", err)
+	}
+	defer sub.Unsubscribe()
+	err = client.Call(nil, "admin_addPeer", info.Enode)
+	if err != nil {
+		demo.Log.Crit("add peer fail", "err", err)
+	}
+	v, err := demo.ExpectMsg(eventC, func(v interface{}) bool {
+		return v.(*p2p.PeerEvent).Type == p2p.PeerEventTypeAdd
+	}, time.Second*5)
+	if err != nil {
+		demo.Log.Crit("no peer", "err", err)
+	}
+	fmt.Printf("node one added peer %s\n", v.(*p2p.PeerEvent).Peer.TerminalString())
+
+	// node one pings, and counts the pongs
+	id := enode.HexID(info.ID)
+	for i := 0; i < pingCount; i++ {
+		err = client.Call(nil, "foo_ping", id)
+		if err != nil {
+			demo.Log.Crit("ping fail", "err", err)
+		}
+	}
+	var pongs int
+	err = demo.EventuallyWithin(time.Second*5, func() bool {
+		err := client.Call(&pongs, "foo_pongCount")
+		if err != nil {
+			demo.Log.Crit("pong count fail", "err", err)
+		}
+		return pongs == pingCount
+	})
+	if err != nil {
+		demo.Log.Crit("pongs missing", "err", err, "pongs", pongs)
+	}
+	fmt.Printf("node one got %d pongs\n", pongs)
+}
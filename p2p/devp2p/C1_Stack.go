@@ -3,7 +3,7 @@ package main
 
 import (
 	"fmt"
-	"os"
+	"path/filepath"
 
 	"github.com/ethereum/go-ethereum/node"
 
@@ -13,15 +13,23 @@ import (
 const (
 	p2pDefaultPort = 30100
 	ipcpath        = ".demo.ipc"
-	datadirPrefix  = ".data_"
 )
 
 func main() {
+	defer demo.FlushLogs()
+
 	// set up the service node
 	cfg := &node.DefaultConfig
 	cfg.P2P.ListenAddr = fmt.Sprintf(":%d", p2pDefaultPort)
 	cfg.IPCPath = ipcpath
-	cfg.DataDir = fmt.Sprintf("%s%d", datadirPrefix, p2pDefaultPort)
+	cfg.DataDir = demo.DataDir(p2pDefaultPort)
+
+	// the node key is kept in the data directory, so with -datadir the node has the same enode on every run
+	privkey, err := demo.LoadOrCreateKey(filepath.Join(cfg.DataDir, "nodekey"))
+	if err != nil {
+		demo.Log.Crit("node key fail", "err", err)
+	}
+	cfg.P2P.PrivateKey = privkey
 
 	// create the node instance with the config
 	stack, err := node.New(cfg)
@@ -34,7 +42,7 @@ func main() {
 	if err != nil {
 		demo.Log.Crit("ServiceNode start fail", "err", err)
 	}
-	defer os.RemoveAll(stack.DataDir())
+	defer demo.RemoveDataDir(stack.DataDir())
 
 	// shut down
 	err = stack.Stop()
@@ -3,7 +3,6 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
 
 	"github.com/ethereum/go-ethereum/node"
@@ -14,17 +13,25 @@ import (
 )
 
 var (
-	p2pPort       = 30100
-	ipcpath       = ".demo.ipc"
-	datadirPrefix = ".data_"
+	p2pPort = 30100
+	ipcpath = ".demo.ipc"
 )
 
 func main() {
+	defer demo.FlushLogs()
+
 	// set up the service node
 	cfg := &node.DefaultConfig
 	cfg.P2P.ListenAddr = fmt.Sprintf(":%d", p2pPort)
 	cfg.IPCPath = ipcpath
-	cfg.DataDir = fmt.Sprintf("%s%d", datadirPrefix, p2pPort)
+	cfg.DataDir = demo.DataDir(p2pPort)
+
+	// the node key is kept in the data directory, so with -datadir the node has the same enode on every run
+	privkey, err := demo.LoadOrCreateKey(filepath.Join(cfg.DataDir, "nodekey"))
+	if err != nil {
+		demo.Log.Crit("node key fail", "err", err)
+	}
+	cfg.P2P.PrivateKey = privkey
 
 	// create the node instance with the config
 	stack, err := node.New(cfg)
@@ -37,7 +44,7 @@ func main() {
 	if err != nil {
 		demo.Log.Crit("ServiceNode start fail", "err", err)
 	}
-	defer os.RemoveAll(stack.DataDir())
+	defer demo.RemoveDataDir(stack.DataDir())
 
 	// get the info directly via the p2p server object
 	p2pserver := stack.Server()
@@ -55,7 +62,8 @@ func main() {
 	demo.Log.Info("Nodeinfo from IPC via ServiceNode", "enode", localnodeinfo.Enode, "IP", localnodeinfo.IP, "ID", localnodeinfo.ID, "listening address", localnodeinfo.ListenAddr)
 
 	// get the nodeinfo via external IPC
-	rpcclient, err = rpc.Dial(filepath.Join(cfg.DataDir, cfg.IPCPath))
+	// the node knows where its IPC endpoint ended up; inside the datadir, or as a named pipe on windows
+	rpcclient, err = rpc.Dial(stack.IPCEndpoint())
 	if err != nil {
 		demo.Log.Crit("Could not get rpcclient via p2p.Server", "err", err)
 	}
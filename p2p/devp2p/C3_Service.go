@@ -3,7 +3,7 @@ package main
 
 import (
 	"fmt"
-	"os"
+	"path/filepath"
 
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p"
@@ -13,10 +13,9 @@ import (
 )
 
 var (
-	msgCount      = 5
-	p2pPort       = 30100
-	ipcpath       = ".demo.ipc"
-	datadirPrefix = ".data_"
+	msgCount = 5
+	p2pPort  = 30100
+	ipcpath  = ".demo.ipc"
 )
 
 // the service we want to offer on the node
@@ -74,6 +73,7 @@ func (api *BarAPI) Double(n int) (int, error) {
 }
 
 func main() {
+	defer demo.FlushLogs()
 
 	// set up the service node with HTTP and WS
 	// modules to be available through the different interfaces must be specified explicitly
@@ -81,7 +81,14 @@ func main() {
 	cfg := &node.DefaultConfig
 	cfg.P2P.ListenAddr = fmt.Sprintf(":%d", p2pPort)
 	cfg.IPCPath = ipcpath
-	cfg.DataDir = fmt.Sprintf("%s%d", datadirPrefix, p2pPort)
+	cfg.DataDir = demo.DataDir(p2pPort)
+
+	// the node key is kept in the data directory, so with -datadir the node has the same enode on every run
+	privkey, err := demo.LoadOrCreateKey(filepath.Join(cfg.DataDir, "nodekey"))
+	if err != nil {
+		demo.Log.Crit("node key fail", "err", err)
+	}
+	cfg.P2P.PrivateKey = privkey
 
 	// HTTP parameters - both module "foo" and "bar"
 	cfg.HTTPHost = node.DefaultHTTPHost
@@ -98,7 +105,7 @@ func main() {
 	if err != nil {
 		demo.Log.Crit("ServiceNode create fail", "err", err)
 	}
-	defer os.RemoveAll(stack.DataDir())
+	defer demo.RemoveDataDir(stack.DataDir())
 
 	// wrapper function for servicenode to start the service
 	foosvc := func(ctx *node.ServiceContext) (node.Service, error) {
@@ -118,14 +125,13 @@ func main() {
 	if err != nil {
 		demo.Log.Crit("ServiceNode start failed", "err", err)
 	}
-	defer os.RemoveAll(cfg.DataDir)
 
 	// the numbers we will pass to the api
 	var number int
 	var doublenumber int
 
 	// connect to the RPC
-	rpcclient_ipc, err := rpc.Dial(fmt.Sprintf("%s/%s", cfg.DataDir, cfg.IPCPath))
+	rpcclient_ipc, err := rpc.Dial(stack.IPCEndpoint())
 
 	// Using IPC, get the number from the FooApi
 	err = rpcclient_ipc.Call(&number, "foo_getNumber")
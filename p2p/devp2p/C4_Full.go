@@ -3,9 +3,7 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
-	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/node"
@@ -14,13 +12,13 @@ import (
 	"github.com/ethereum/go-ethereum/rpc"
 
 	demo "./common"
+	"./envelope"
 )
 
 var (
-	p2pPort       = 30100
-	ipcpath       = ".demo.ipc"
-	datadirPrefix = ".data_"
-	stackW        = &sync.WaitGroup{}
+	p2pPort = 30100
+	ipcpath = ".demo.ipc"
+	stackC  = make(chan struct{}, 2) // each side tells its ping pong exchange is over
 )
 
 type FooPingMsg struct {
@@ -78,7 +76,7 @@ func (self *fooService) Protocols() []p2p.Protocol {
 
 						// decode the message and check the contents
 						var decodedmsg FooPingMsg
-						err = msg.Decode(&decodedmsg)
+						err = envelope.DecodeMsg(msg, &decodedmsg)
 						if err != nil {
 							demo.Log.Error("Decode p2p message fail", "err", err)
 							break
@@ -95,7 +93,7 @@ func (self *fooService) Protocols() []p2p.Protocol {
 								Pong:    true,
 								Created: time.Now(),
 							}
-							err := p2p.Send(rw, 0, pingmsg)
+							err := envelope.Send(rw, 0, envelope.JSON, pingmsg)
 							if err != nil {
 								demo.Log.Error("Send p2p message fail", "err", err)
 								break
@@ -122,7 +120,7 @@ func (self *fooService) Protocols() []p2p.Protocol {
 					}
 
 					// either handler or sender should be asynchronous, otherwise we might deadlock
-					go p2p.Send(rw, 0, pingmsg)
+					go envelope.Send(rw, 0, envelope.JSON, pingmsg)
 					pingcount++
 					demo.Log.Info("sent ping", "peer", p, "count", pingcount)
 				}
@@ -184,8 +182,14 @@ func newServiceNode(port int, httpport int, wsport int, modules ...string) (*nod
 	cfg.P2P.ListenAddr = fmt.Sprintf(":%d", port)
 	cfg.P2P.EnableMsgEvents = true
 	cfg.P2P.NoDiscovery = true
-	cfg.IPCPath = ipcpath
-	cfg.DataDir = fmt.Sprintf("%s%d", datadirPrefix, port)
+	cfg.DataDir = demo.DataDir(port)
+	cfg.IPCPath = demo.NodeIPCPath(cfg.DataDir, ipcpath)
+	// the node key is kept in the data directory, so with -datadir the node has the same enode on every run
+	privkey, err := demo.LoadOrCreateKey(filepath.Join(cfg.DataDir, "nodekey"))
+	if err != nil {
+		return nil, fmt.Errorf("node key fail: %v", err)
+	}
+	cfg.P2P.PrivateKey = privkey
 	if httpport > 0 {
 		cfg.HTTPHost = node.DefaultHTTPHost
 		cfg.HTTPPort = httpport
@@ -206,6 +210,7 @@ func newServiceNode(port int, httpport int, wsport int, modules ...string) (*nod
 }
 
 func main() {
+	defer demo.FlushLogs()
 
 	// create the two nodes
 	stack_one, err := newServiceNode(p2pPort, 0, 0)
@@ -245,17 +250,17 @@ func main() {
 	}
 
 	// connect to the servicenode RPCs
-	rpcclient_one, err := rpc.Dial(filepath.Join(stack_one.DataDir(), ipcpath))
+	rpcclient_one, err := rpc.Dial(stack_one.IPCEndpoint())
 	if err != nil {
 		demo.Log.Crit("connect to servicenode #1 IPC fail", "err", err)
 	}
-	defer os.RemoveAll(stack_one.DataDir())
+	defer demo.RemoveDataDir(stack_one.DataDir())
 
-	rpcclient_two, err := rpc.Dial(filepath.Join(stack_two.DataDir(), ipcpath))
+	rpcclient_two, err := rpc.Dial(stack_two.IPCEndpoint())
 	if err != nil {
 		demo.Log.Crit("connect to servicenode #2 IPC fail", "err", err)
 	}
-	defer os.RemoveAll(stack_two.DataDir())
+	defer demo.RemoveDataDir(stack_two.DataDir())
 
 	// display that the initial pong counts are 0
 	var count int
@@ -287,7 +292,6 @@ func main() {
 	srv_one.AddPeer(p2pnode_two)
 
 	// fork and do the pinging
-	stackW.Add(2)
 	pingmax_one := 4
 	pingmax_two := 2
 
@@ -297,7 +301,7 @@ func main() {
 		ev := <-eventOneC
 		if ev.Type != "add" {
 			demo.Log.Error("server #1 expected peer add", "eventtype", ev.Type)
-			stackW.Done()
+			stackC <- struct{}{}
 			return
 		}
 		demo.Log.Debug("server #1 connected", "peer", ev.Peer)
@@ -307,8 +311,8 @@ func main() {
 			err := rpcclient_one.Call(nil, "foo_ping", ev.Peer)
 			if err != nil {
 				demo.Log.Error("server #1 RPC ping fail", "err", err)
-				stackW.Done()
-				break
+				stackC <- struct{}{}
+				return
 			}
 		}
 
@@ -322,7 +326,7 @@ func main() {
 			}
 		}
 
-		stackW.Done()
+		stackC <- struct{}{}
 	}()
 
 	// mirrors the previous go func
@@ -330,7 +334,7 @@ func main() {
 		ev := <-eventTwoC
 		if ev.Type != "add" {
 			demo.Log.Error("expected peer add", "eventtype", ev.Type)
-			stackW.Done()
+			stackC <- struct{}{}
 			return
 		}
 		demo.Log.Debug("server #2 connected", "peer", ev.Peer)
@@ -338,8 +342,8 @@ func main() {
 			err := rpcclient_two.Call(nil, "foo_ping", ev.Peer)
 			if err != nil {
 				demo.Log.Error("server #2 RPC ping fail", "err", err)
-				stackW.Done()
-				break
+				stackC <- struct{}{}
+				return
 			}
 		}
 
@@ -351,11 +355,16 @@ func main() {
 			}
 		}
 
-		stackW.Done()
+		stackC <- struct{}{}
 	}()
 
 	// wait for the two ping pong exchanges to finish
-	stackW.Wait()
+	for i := 0; i < 2; i++ {
+		_, err := demo.ExpectMsg(stackC, nil, time.Second*10)
+		if err != nil {
+			demo.Log.Crit("ping pong fail", "err", err)
+		}
+	}
 
 	// tell the API to shut down
 	// this will disconnect the peers and close the channels connecting API and protocol
@@ -0,0 +1,262 @@
+// A-series ping protocol running in service nodes, with node keys from a keystore
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	demo "./common"
+	"./envelope"
+)
+
+const (
+	passphrase = "foo"
+)
+
+var (
+	protoC = make(chan struct{}, 2) // each protocol tells it's done
+	pongs  int32                    // the pongs received by either side
+)
+
+type FooPingMsg struct {
+	Pong    bool
+	Created time.Time
+}
+
+// this is the same protocol as in A5_Reply.go
+// the only difference is that it counts the pongs so we can read the count through the API
+type fooService struct {
+	pongcount int
+	mu        sync.Mutex
+}
+
+func (self *fooService) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{
+		{
+			Name:    "foo",
+			Version: 42,
+			Length:  1,
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+
+				ponged := false
+
+				// send the ping
+				err := envelope.Send(rw, 0, envelope.JSON, FooPingMsg{
+					Pong:    false,
+					Created: time.Now(),
+				})
+				if err != nil {
+					return fmt.Errorf("Send p2p message fail: %v", err)
+				}
+				demo.Log.Info("sending ping", "peer", p)
+
+				for !ponged {
+					msg, err := rw.ReadMsg()
+					if err != nil {
+						return fmt.Errorf("Receive p2p message fail: %v", err)
+					}
+
+					var decodedmsg FooPingMsg
+					err = envelope.DecodeMsg(msg, &decodedmsg)
+					if err != nil {
+						return fmt.Errorf("Decode p2p message fail: %v", err)
+					}
+
+					if decodedmsg.Pong {
+						demo.Log.Info("received pong", "peer", p)
+						self.mu.Lock()
+						self.pongcount++
+						self.mu.Unlock()
+						ponged = true
+						atomic.AddInt32(&pongs, 1)
+					} else {
+						demo.Log.Info("received ping", "peer", p)
+						err := envelope.Send(rw, 0, envelope.JSON, FooPingMsg{
+							Pong:    true,
+							Created: time.Now(),
+						})
+						if err != nil {
+							return fmt.Errorf("Send p2p message fail: %v", err)
+						}
+						demo.Log.Info("sent pong", "peer", p)
+					}
+				}
+
+				// terminate the protocol after all involved have completed the cycle
+				err = demo.EventuallyWithin(time.Second*5, func() bool {
+					return atomic.LoadInt32(&pongs) == 2
+				})
+				if err != nil {
+					return fmt.Errorf("Wait for the other pong fail: %v", err)
+				}
+				protoC <- struct{}{}
+				return nil
+			},
+		},
+	}
+}
+
+// where the bare p2p.Server needed us to set up the RPC server ourselves (B-series)
+// the service node mounts the APIs of the service automatically
+func (self *fooService) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "foo",
+			Version:   "42",
+			Service:   &FooAPI{service: self},
+			Public:    true,
+		},
+	}
+}
+
+func (self *fooService) Start(srv *p2p.Server) error {
+	return nil
+}
+
+func (self *fooService) Stop() error {
+	return nil
+}
+
+type FooAPI struct {
+	service *fooService
+}
+
+func (api *FooAPI) PongCount() (int, error) {
+	api.service.mu.Lock()
+	defer api.service.mu.Unlock()
+	return api.service.pongcount, nil
+}
+
+// create a service node whose p2p identity is an account in the node's keystore
+//
+// in the A-series we generated a throwaway key with crypto.GenerateKey
+// here the key is stored encrypted in the datadir, and the same key shows up as an account in the node's account manager
+func newServiceNode(port int) (*node.Node, error) {
+	datadir := demo.DataDir(port)
+	keystoredir := filepath.Join(datadir, "keystore")
+
+	// create the account, or with -datadir take the one made on an earlier run, and get the private key back out of the keystore
+	// the keystore then only ever holds the one account, which is the node's identity
+	ks := keystore.NewKeyStore(keystoredir, keystore.LightScryptN, keystore.LightScryptP)
+	var account accounts.Account
+	if existing := ks.Accounts(); len(existing) > 0 {
+		account = existing[0]
+	} else {
+		var err error
+		account, err = ks.NewAccount(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("keystore account create fail: %v", err)
+		}
+	}
+	keyjson, err := ks.Export(account, passphrase, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("keystore export fail: %v", err)
+	}
+	key, err := keystore.DecryptKey(keyjson, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("keystore decrypt fail: %v", err)
+	}
+
+	// the p2p settings are the same as for the bare p2p.Server
+	// they just live in the P2P member of the node config
+	cfg := &node.DefaultConfig
+	cfg.P2P.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.P2P.PrivateKey = key.PrivateKey
+	cfg.P2P.MaxPeers = 1
+	cfg.P2P.EnableMsgEvents = true
+	cfg.P2P.NoDiscovery = true
+	cfg.DataDir = datadir
+	cfg.IPCPath = demo.NodeIPCPath(cfg.DataDir, demo.IPCName)
+	cfg.KeyStoreDir = keystoredir
+	cfg.UseLightweightKDF = true
+	stack, err := node.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("ServiceNode create fail: %v", err)
+	}
+	return stack, nil
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// create the two nodes
+	stack_one, err := newServiceNode(demo.P2pPort)
+	if err != nil {
+		demo.Log.Crit("Create servicenode #1 fail", "err", err)
+	}
+	defer demo.RemoveDataDir(stack_one.DataDir())
+	stack_two, err := newServiceNode(demo.P2pPort + 1)
+	if err != nil {
+		demo.Log.Crit("Create servicenode #2 fail", "err", err)
+	}
+	defer demo.RemoveDataDir(stack_two.DataDir())
+
+	// the protocol is no longer passed in the p2p.Config
+	// instead the service node collects it from the registered service
+	foosvc := func(ctx *node.ServiceContext) (node.Service, error) {
+		return &fooService{}, nil
+	}
+	err = stack_one.Register(foosvc)
+	if err != nil {
+		demo.Log.Crit("Register service in servicenode #1 fail", "err", err)
+	}
+	err = stack_two.Register(foosvc)
+	if err != nil {
+		demo.Log.Crit("Register service in servicenode #2 fail", "err", err)
+	}
+
+	// starting the node starts the p2p.Server, the RPC endpoints and the services
+	err = stack_one.Start()
+	if err != nil {
+		demo.Log.Crit("servicenode #1 start failed", "err", err)
+	}
+	err = stack_two.Start()
+	if err != nil {
+		demo.Log.Crit("servicenode #2 start failed", "err", err)
+	}
+
+	// the node's p2p identity is the keystore account
+	for i, stack := range []*node.Node{stack_one, stack_two} {
+		nodeaddr := crypto.PubkeyToAddress(*stack.Server().Self().Pubkey())
+		accounts := stack.AccountManager().Wallets()[0].Accounts()
+		demo.Log.Info("node identity", "node", i+1, "enode", stack.Server().Self(), "nodeaddress", nodeaddr.Hex(), "account", accounts[0].Address.Hex())
+	}
+
+	// the underlying p2p.Server is still available, so everything from the A-series works as before
+	stack_one.Server().AddPeer(stack_two.Server().Self())
+	for i := 0; i < 2; i++ {
+		_, err := demo.ExpectMsg(protoC, nil, time.Second*5)
+		if err != nil {
+			demo.Log.Crit("protocol fail", "err", err)
+		}
+	}
+
+	// but now we can also read the results through RPC
+	for i, stack := range []*node.Node{stack_one, stack_two} {
+		rpcclient, err := stack.Attach()
+		if err != nil {
+			demo.Log.Crit("attach rpc fail", "err", err)
+		}
+		var count int
+		err = rpcclient.Call(&count, "foo_pongCount")
+		if err != nil {
+			demo.Log.Crit("pongcount RPC fail", "err", err)
+		}
+		demo.Log.Info("pongs received", "node", i+1, "count", count)
+		rpcclient.Close()
+	}
+
+	// bring down the servicenodes
+	stack_two.Stop()
+	stack_one.Stop()
+}
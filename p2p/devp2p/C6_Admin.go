@@ -0,0 +1,219 @@
+// controlling the p2p topology of service nodes through the admin RPC API
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	demo "./common"
+)
+
+const (
+	nodeCount   = 3
+	peerTimeout = time.Second * 5
+)
+
+var (
+	nodeNames = []string{"one", "two", "three"}
+)
+
+// the peer information returned by admin_peers
+//
+// this is the JSON representation of p2p.PeerInfo
+// tooling talking to nodes over RPC doesn't need to import go-ethereum, so we declare the fields we care about ourselves
+type peerInfo struct {
+	Enode   string   `json:"enode"`
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Caps    []string `json:"caps"`
+	Network struct {
+		LocalAddress  string `json:"localAddress"`
+		RemoteAddress string `json:"remoteAddress"`
+		Inbound       bool   `json:"inbound"`
+		Trusted       bool   `json:"trusted"`
+		Static        bool   `json:"static"`
+	} `json:"network"`
+}
+
+// the controller only knows the RPC endpoints of the nodes
+// it could just as well run in a separate process, or on another machine using HTTP or websockets
+type controller struct {
+	names   []string
+	clients map[string]*rpc.Client
+	enodes  map[string]string
+	ids     map[string]string
+}
+
+// connects to all the nodes and learns their enodes
+func newController(endpoints map[string]string) (*controller, error) {
+	c := &controller{
+		clients: make(map[string]*rpc.Client),
+		enodes:  make(map[string]string),
+		ids:     make(map[string]string),
+	}
+	for name, endpoint := range endpoints {
+		client, err := rpc.Dial(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("dial %s fail: %v", name, err)
+		}
+		var nodeinfo p2p.NodeInfo
+		err = client.Call(&nodeinfo, "admin_nodeInfo")
+		if err != nil {
+			return nil, fmt.Errorf("nodeinfo %s fail: %v", name, err)
+		}
+		c.names = append(c.names, name)
+		c.clients[name] = client
+		c.enodes[name] = nodeinfo.Enode
+		c.ids[nodeinfo.ID] = name
+	}
+	sort.Strings(c.names)
+	return c, nil
+}
+
+func (c *controller) close() {
+	for _, client := range c.clients {
+		client.Close()
+	}
+}
+
+// admin_addPeer only queues the connection
+// the result just tells whether the enode was valid
+func (c *controller) connect(from string, to string) error {
+	var ok bool
+	err := c.clients[from].Call(&ok, "admin_addPeer", c.enodes[to])
+	if err != nil {
+		return err
+	}
+	demo.Log.Info("requested connect", "from", from, "to", to, "ok", ok)
+	return nil
+}
+
+func (c *controller) disconnect(from string, to string) error {
+	var ok bool
+	err := c.clients[from].Call(&ok, "admin_removePeer", c.enodes[to])
+	if err != nil {
+		return err
+	}
+	demo.Log.Info("requested disconnect", "from", from, "to", to, "ok", ok)
+	return nil
+}
+
+func (c *controller) peers(name string) ([]peerInfo, error) {
+	var peers []peerInfo
+	err := c.clients[name].Call(&peers, "admin_peers")
+	return peers, err
+}
+
+// since connecting and disconnecting happens in the background, we poll until the node has the expected amount of peers
+func (c *controller) waitPeers(name string, count int) error {
+	var err error
+	timeout := demo.EventuallyWithin(peerTimeout, func() bool {
+		var peers []peerInfo
+		peers, err = c.peers(name)
+		return err != nil || len(peers) == count
+	})
+	if timeout != nil {
+		return fmt.Errorf("node %s did not get %d peers in time", name, count)
+	}
+	return err
+}
+
+// shows which node is connected to which, and in which direction
+func (c *controller) showTopology() error {
+	for _, name := range c.names {
+		peers, err := c.peers(name)
+		if err != nil {
+			return err
+		}
+		var links []string
+		for _, peer := range peers {
+			direction := "->"
+			if peer.Network.Inbound {
+				direction = "<-"
+			}
+			links = append(links, fmt.Sprintf("%s %s", direction, c.ids[peer.ID]))
+		}
+		sort.Strings(links)
+		demo.Log.Info("topology", "node", name, "peers", len(peers), "links", strings.Join(links, ", "))
+		for _, peer := range peers {
+			demo.Log.Debug("peer", "node", name, "peer", c.ids[peer.ID], "remote", peer.Network.RemoteAddress, "static", peer.Network.Static, "trusted", peer.Network.Trusted, "name", peer.Name)
+		}
+	}
+	return nil
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// start the nodes
+	// note that this is all we do with the nodes in this process
+	endpoints := make(map[string]string)
+	for i := 0; i < nodeCount; i++ {
+		stack, err := demo.NewServiceNode(demo.Conf.P2PPort+i, 0, 0)
+		if err != nil {
+			demo.Log.Crit("Create servicenode fail", "node", nodeNames[i], "err", err)
+		}
+		err = stack.Start()
+		if err != nil {
+			demo.Log.Crit("Start servicenode fail", "node", nodeNames[i], "err", err)
+		}
+		defer demo.RemoveDataDir(stack.DataDir())
+		defer stack.Stop()
+
+		// the IPC endpoint exposes all the APIs, including admin
+		// over HTTP and websockets "admin" would have to be added to the modules
+		endpoints[nodeNames[i]] = stack.IPCEndpoint()
+	}
+
+	// from here on everything goes through RPC
+	ctrl, err := newController(endpoints)
+	if err != nil {
+		demo.Log.Crit("controller fail", "err", err)
+	}
+	defer ctrl.close()
+
+	// make a line; one - two - three
+	err = ctrl.connect("one", "two")
+	if err == nil {
+		err = ctrl.connect("two", "three")
+	}
+	if err != nil {
+		demo.Log.Crit("connect fail", "err", err)
+	}
+	for name, count := range map[string]int{"one": 1, "two": 2, "three": 1} {
+		err = ctrl.waitPeers(name, count)
+		if err != nil {
+			demo.Log.Crit("wait for peers fail", "err", err)
+		}
+	}
+	ctrl.showTopology()
+
+	// close the triangle
+	err = ctrl.connect("three", "one")
+	if err != nil {
+		demo.Log.Crit("connect fail", "err", err)
+	}
+	err = ctrl.waitPeers("one", 2)
+	if err != nil {
+		demo.Log.Crit("wait for peers fail", "err", err)
+	}
+	ctrl.showTopology()
+
+	// and cut one of the links
+	// this also removes the peer from the static list, so the node won't try to reconnect
+	err = ctrl.disconnect("one", "two")
+	if err != nil {
+		demo.Log.Crit("disconnect fail", "err", err)
+	}
+	err = ctrl.waitPeers("two", 1)
+	if err != nil {
+		demo.Log.Crit("wait for peers fail", "err", err)
+	}
+	ctrl.showTopology()
+}
@@ -0,0 +1,172 @@
+// delegating access to the admin RPC API of a node with capability tokens signed by its admin
+//
+// the admin of a node hands out tokens granting some of the methods of its rpc, for a while, instead of the
+// keys to the whole of it. The node serves its rpc on an http endpoint behind a guard, which only trusts the
+// tokens of the admin, and only lets through the calls they grant
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"./capability"
+	demo "./common"
+)
+
+const (
+	monitorTTL  = time.Second * 3
+	operatorTTL = time.Hour
+	peerTimeout = time.Second * 5
+)
+
+// calls the method with the client, and tells how it went
+func try(who string, client *rpc.Client, result interface{}, method string, args ...interface{}) error {
+	err := client.Call(result, method, args...)
+	if err != nil {
+		demo.Log.Info("refused", "who", who, "method", method, "err", err)
+	} else {
+		demo.Log.Info("granted", "who", who, "method", method)
+	}
+	return err
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// the admin, whose address the node trusts
+	adminkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("generate admin key fail", "err", err)
+	}
+
+	// the node with the guarded endpoint, and another one for it to peer with
+	stack, err := demo.NewServiceNode(demo.Conf.P2PPort, 0, 0)
+	if err != nil {
+		demo.Log.Crit(err.Error())
+	}
+	verifier := capability.NewVerifier("", crypto.PubkeyToAddress(adminkey.PublicKey))
+	guarded := capability.NewService(stack, "127.0.0.1:0", verifier)
+	err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		return guarded, nil
+	})
+	if err != nil {
+		demo.Log.Crit("register capability service fail", "err", err)
+	}
+	other, err := demo.NewServiceNode(demo.Conf.P2PPort+1, 0, 0)
+	if err != nil {
+		demo.Log.Crit(err.Error())
+	}
+	for _, s := range []*node.Node{stack, other} {
+		if err := s.Start(); err != nil {
+			demo.Log.Crit("servicenode start fail", "err", err)
+		}
+		defer demo.RemoveDataDir(s.DataDir())
+		defer s.Stop()
+	}
+	id := stack.Server().Self().ID().String()
+
+	// the admin hands out tokens: one to watch the node for a little while, one to operate it
+	now := time.Now()
+	monitorToken, err := capability.Issue(adminkey, capability.Claims{
+		Subject: "monitor",
+		Grants:  []string{"admin_nodeInfo", "admin_peers"},
+		Issued:  now,
+		Expires: now.Add(monitorTTL),
+	})
+	if err != nil {
+		demo.Log.Crit("issue token fail", "err", err)
+	}
+	operatorToken, err := capability.Issue(adminkey, capability.Claims{
+		Subject: "operator",
+		Node:    id,
+		Grants:  []string{"admin_*"},
+		Issued:  now,
+		Expires: now.Add(operatorTTL),
+	})
+	if err != nil {
+		demo.Log.Crit("issue token fail", "err", err)
+	}
+	demo.Log.Info("tokens issued", "endpoint", guarded.Endpoint(), "monitor", monitorToken)
+
+	monitor, err := capability.DialHTTP(guarded.Endpoint(), monitorToken)
+	if err != nil {
+		demo.Log.Crit("dial fail", "err", err)
+	}
+	defer monitor.Close()
+	operator, err := capability.DialHTTP(guarded.Endpoint(), operatorToken)
+	if err != nil {
+		demo.Log.Crit("dial fail", "err", err)
+	}
+	defer operator.Close()
+
+	// the monitor looks, but can't touch
+	var info p2p.NodeInfo
+	if err := try("monitor", monitor, &info, "admin_nodeInfo"); err != nil {
+		demo.Log.Crit("monitor should see the node", "err", err)
+	}
+	var ok bool
+	otherEnode := other.Server().Self().String()
+	if err := try("monitor", monitor, &ok, "admin_addPeer", otherEnode); err == nil {
+		demo.Log.Crit("monitor should not add peers")
+	}
+
+	// the operator can
+	if err := try("operator", operator, &ok, "admin_addPeer", otherEnode); err != nil {
+		demo.Log.Crit("operator should add peers", "err", err)
+	}
+	err = demo.EventuallyWithin(peerTimeout, func() bool {
+		var peers []*p2p.PeerInfo
+		return monitor.Call(&peers, "admin_peers") == nil && len(peers) == 1
+	})
+	if err != nil {
+		demo.Log.Crit("no peer", "err", err)
+	}
+	demo.Log.Info("the monitor sees the peer the operator added")
+
+	// a token for the other node doesn't open this one, even if it's the same admin's
+	elsewhere, err := capability.Issue(adminkey, capability.Claims{
+		Subject: "operator",
+		Node:    other.Server().Self().ID().String(),
+		Grants:  []string{"admin_*"},
+		Issued:  now,
+		Expires: now.Add(operatorTTL),
+	})
+	if err != nil {
+		demo.Log.Crit("issue token fail", "err", err)
+	}
+	// and a token anyone can make isn't trusted
+	forgerkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("generate key fail", "err", err)
+	}
+	forged, err := capability.Issue(forgerkey, capability.Claims{
+		Subject: "operator",
+		Grants:  []string{"*"},
+		Issued:  now,
+		Expires: now.Add(operatorTTL),
+	})
+	if err != nil {
+		demo.Log.Crit("issue token fail", "err", err)
+	}
+	for who, token := range map[string]string{"other node's operator": elsewhere, "forger": forged} {
+		client, err := capability.DialHTTP(guarded.Endpoint(), token)
+		if err != nil {
+			demo.Log.Crit("dial fail", "err", err)
+		}
+		if err := try(who, client, &ok, "admin_removePeer", otherEnode); err == nil {
+			demo.Log.Crit(fmt.Sprintf("%s should not remove peers", who))
+		}
+		client.Close()
+	}
+
+	// the monitor's time is up
+	time.Sleep(time.Until(now.Add(monitorTTL)))
+	if err := try("monitor", monitor, &info, "admin_nodeInfo"); err == nil {
+		demo.Log.Crit("the monitor's token should have expired")
+	}
+}
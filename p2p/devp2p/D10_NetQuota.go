@@ -0,0 +1,201 @@
+// counting the bytes of every peer, warning the ones nearing their quota, and dropping the ones going over
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	demo "./common"
+	"./netquota"
+)
+
+const (
+	dataSize     = 256
+	sendInterval = time.Millisecond * 20 // about 13k a second, more than the quota
+	runTime      = time.Second * 3
+)
+
+var (
+	// what the server lets each peer send it
+	quota = netquota.Config{
+		Ingress: 4096,
+		Period:  time.Second,
+		WarnAt:  0.75,
+	}
+)
+
+// the server reads what it gets, and that's all
+func serverProtocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    "data",
+		Version: 1,
+		Length:  1,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				msg.Discard()
+			}
+		},
+	}
+}
+
+// a client sends as fast as it may
+// a polite one stops when it's warned, until its quota starts again
+type client struct {
+	name   string
+	polite bool
+	mu     sync.Mutex
+	until  time.Time
+	sent   int
+}
+
+func (self *client) onWarning(id enode.ID, w *netquota.Warning) {
+	demo.Log.Info("quota warning", "client", self.name, "used", w.Used, "quota", w.Quota, "reset ms", w.Reset)
+	if !self.polite {
+		return
+	}
+	self.mu.Lock()
+	self.until = time.Now().Add(time.Duration(w.Reset) * time.Millisecond)
+	self.mu.Unlock()
+}
+
+func (self *client) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    "data",
+		Version: 1,
+		Length:  1,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+
+			// the warnings come in with what we read
+			errC := make(chan error, 1)
+			go func() {
+				for {
+					msg, err := rw.ReadMsg()
+					if err != nil {
+						errC <- err
+						return
+					}
+					msg.Discard()
+				}
+			}()
+
+			ticker := time.NewTicker(sendInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case err := <-errC:
+					demo.Log.Warn("disconnected", "client", self.name, "err", err)
+					return err
+				case <-ticker.C:
+				}
+				self.mu.Lock()
+				wait := time.Now().Before(self.until)
+				self.mu.Unlock()
+				if wait {
+					continue
+				}
+				if err := p2p.Send(rw, 0, make([]byte, dataSize)); err != nil {
+					return err
+				}
+				self.mu.Lock()
+				self.sent++
+				self.mu.Unlock()
+			}
+		},
+	}
+}
+
+func newServer(privkey *ecdsa.PrivateKey, name string, protos []p2p.Protocol, port int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey:  privkey,
+		Name:        common.MakeName(name, "1"),
+		MaxPeers:    8,
+		NoDiscovery: true,
+		Protocols:   protos,
+	}
+	if port > 0 {
+		cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// the server, with its protocol metered
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+	meter := netquota.NewMeter(quota)
+	srv := newServer(privkey, "server", meter.Protocols([]p2p.Protocol{serverProtocol()}), demo.Conf.P2PPort)
+	if err := srv.Start(); err != nil {
+		demo.Log.Crit("Start p2p.Server failed", "err", err)
+	}
+	defer srv.Stop()
+
+	// the netquota api, on an rpc server of its own in the process
+	rpcsrv := rpc.NewServer()
+	for _, api := range netquota.APIs(meter) {
+		if err := rpcsrv.RegisterName(api.Namespace, api.Service); err != nil {
+			demo.Log.Crit("register api fail", "err", err)
+		}
+	}
+	defer rpcsrv.Stop()
+	rpcclient := rpc.DialInProc(rpcsrv)
+	defer rpcclient.Close()
+
+	// the clients meter their protocol too, without a quota, so they get the warnings
+	clients := []*client{
+		{name: "polite", polite: true},
+		{name: "greedy"},
+	}
+	names := make(map[enode.ID]string)
+	for _, c := range clients {
+		privkey, err := crypto.GenerateKey()
+		if err != nil {
+			demo.Log.Crit("Generate private key failed", "err", err)
+		}
+		m := netquota.NewMeter(netquota.Config{})
+		m.OnWarning = c.onWarning
+		csrv := newServer(privkey, c.name, m.Protocols([]p2p.Protocol{c.protocol()}), 0)
+		if err := csrv.Start(); err != nil {
+			demo.Log.Crit("Start p2p.Server failed", "err", err)
+		}
+		defer csrv.Stop()
+		names[csrv.Self().ID()] = c.name
+		csrv.AddPeer(srv.Self())
+	}
+
+	time.Sleep(runTime)
+
+	var peers map[enode.ID]netquota.Stats
+	if err := rpcclient.Call(&peers, "netquota_peers"); err != nil {
+		demo.Log.Crit("netquota_peers fail", "err", err)
+	}
+	fmt.Printf("%-7s %-6s %-10s %-8s %-9s %s\n", "client", "sent", "connected", "dropped", "warnings", "bytes")
+	for _, c := range clients {
+		for id, stats := range peers {
+			if names[id] != c.name {
+				continue
+			}
+			c.mu.Lock()
+			sent := c.sent
+			c.mu.Unlock()
+			fmt.Printf("%-7s %-6d %-10v %-8v %-9d %d\n", c.name, sent, stats.Connected, stats.Disconnected, stats.WarningsSent, stats.TotalIngress)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// remembering how each peer did, and dialing the best ones first after a restart
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	demo "./common"
+	"./peerstore"
+)
+
+const (
+	peerCount   = 6
+	runTime     = time.Second * 3
+	flakyUptime = time.Millisecond * 300 // how long a flaky peer keeps a connection
+)
+
+// the peers: good ones keep the connection, flaky ones drop it soon after, and offline ones aren't running at all
+var (
+	kinds = []string{"good", "flaky", "offline", "good", "flaky", "good"}
+)
+
+func protocol(flaky bool) p2p.Protocol {
+	return p2p.Protocol{
+		Name:    "demo",
+		Version: 1,
+		Length:  1,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			errC := make(chan error, 1)
+			go func() {
+				for {
+					msg, err := rw.ReadMsg()
+					if err != nil {
+						errC <- err
+						return
+					}
+					msg.Discard()
+				}
+			}()
+			if !flaky {
+				return <-errC
+			}
+			select {
+			case err := <-errC:
+				return err
+			case <-time.After(flakyUptime):
+				return fmt.Errorf("flaky")
+			}
+		},
+	}
+}
+
+func newServer(privkey *ecdsa.PrivateKey, name string, proto p2p.Protocol, port int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey:  privkey,
+		Name:        common.MakeName(name, "1"),
+		MaxPeers:    peerCount,
+		NoDiscovery: true,
+		Protocols:   []p2p.Protocol{proto},
+		ListenAddr:  fmt.Sprintf(":%d", port),
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+// runs the node for a while, dialing up to maxPeers of the nodes in the store and the ones given
+func run(privkey *ecdsa.PrivateKey, store *peerstore.Store, maxPeers int, nodes []*enode.Node, names map[enode.ID]string) {
+	srv := newServer(privkey, "node", protocol(false), demo.Conf.P2PPort)
+	if err := srv.Start(); err != nil {
+		demo.Log.Crit("Start p2p.Server failed", "err", err)
+	}
+	defer srv.Stop()
+	dialer := peerstore.NewDialer(store, srv, maxPeers)
+	dialer.DialTimeout = time.Second
+	dialer.Start()
+	defer dialer.Stop()
+
+	dialed, err := dialer.Dial(nodes...)
+	if err != nil {
+		demo.Log.Crit("dial fail", "err", err)
+	}
+	fmt.Print("dialed:")
+	for _, n := range dialed {
+		fmt.Printf(" %s", names[n.ID()])
+	}
+	fmt.Println()
+	time.Sleep(runTime)
+}
+
+func show(store *peerstore.Store, names map[enode.ID]string) {
+	records, err := store.All()
+	if err != nil {
+		demo.Log.Crit("peer store fail", "err", err)
+	}
+	now := time.Now()
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Score(now) > records[j].Score(now)
+	})
+	fmt.Printf("%-10s %-6s %-6s %-6s %-10s %-10s %s\n", "peer", "dials", "fails", "conns", "uptime", "latency", "score")
+	for _, r := range records {
+		id, _ := r.ID()
+		fmt.Printf("%-10s %-6d %-6d %-6d %-10v %-10v %.3f\n", names[id], r.Dials, r.DialFailures, r.Connections, r.Uptime.Round(time.Millisecond), r.Latency.Round(time.Microsecond), r.Score(now))
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// the peers, all but the offline ones running
+	var nodes []*enode.Node
+	names := make(map[enode.ID]string)
+	for i, kind := range kinds {
+		privkey, err := crypto.GenerateKey()
+		if err != nil {
+			demo.Log.Crit("Generate private key failed", "err", err)
+		}
+		name := fmt.Sprintf("%d-%s", i+1, kind)
+		srv := newServer(privkey, name, protocol(kind == "flaky"), demo.Conf.P2PPort+1+i)
+		if kind != "offline" {
+			if err := srv.Start(); err != nil {
+				demo.Log.Crit("Start p2p.Server failed", "err", err)
+			}
+			defer srv.Stop()
+		}
+		n := enode.NewV4(&privkey.PublicKey, net.IP{127, 0, 0, 1}, demo.Conf.P2PPort+1+i, 0)
+		nodes = append(nodes, n)
+		names[n.ID()] = name
+	}
+
+	// the store is in the data directory of the node, and is kept between runs with -datadir
+	datadir := demo.DataDir(demo.Conf.P2PPort)
+	defer demo.RemoveDataDir(datadir)
+	store, err := peerstore.Open(filepath.Join(datadir, "peers"))
+	if err != nil {
+		demo.Log.Crit("peer store fail", "err", err)
+	}
+	defer store.Close()
+
+	// the node's key is the same in both runs, as it would be on restart
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+
+	// the first time the node knows nothing of the peers, and dials them all
+	fmt.Println("first run, all the peers")
+	run(privkey, store, peerCount, nodes, names)
+	show(store, names)
+
+	// after a restart it has room for two, and dials the ones that did best
+	fmt.Println("\nafter restart, two peers")
+	run(privkey, store, 2, nil, names)
+	show(store, names)
+}
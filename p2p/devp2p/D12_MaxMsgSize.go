@@ -0,0 +1,276 @@
+// messages larger than the protocol allows: what happens when one is sent, and how not to send one
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+
+	demo "./common"
+	"./envelope"
+)
+
+const (
+	maxMsgSize = 1024
+
+	// protocols.Peer sends a message inside a WrappedMsg, which adds a few bytes of its own
+	wrapOverhead = 16
+
+	// the data of a part, leaving room for the other fields of the part and the wrapping
+	partSize = maxMsgSize - 64
+
+	// the receiver takes messages of up to 64 kilobytes put back together from parts
+	maxReassembled = 64 * 1024
+	maxPending     = 4
+
+	blobSize   = 4000
+	resultWait = time.Second * 2
+)
+
+// the limit is in the spec, and the run loop of a protocols.Peer checks every message against it
+// before decoding it. It doesn't check the messages it sends, that's up to us
+var (
+	spec = protocols.Spec{
+		Name:       "blobs",
+		Version:    1,
+		MaxMsgSize: maxMsgSize,
+		Messages: []interface{}{
+			&BlobMsg{},
+			&envelope.Part{},
+		},
+	}
+)
+
+// a payload in an envelope, sent whole
+// a payload too large for one message goes in parts of the envelope package instead
+type BlobMsg struct {
+	Envelope []byte
+}
+
+// the receiver takes blobs whole or in parts, and tells main what came of each
+type receiver struct {
+	parts   *envelope.Reassembler
+	resultC chan string
+	runErrC chan error
+}
+
+func (self *receiver) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    spec.Name,
+		Version: spec.Version,
+		Length:  spec.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			pp := protocols.NewPeer(p, rw, &spec)
+			err := pp.Run(self.handle)
+			self.runErrC <- err
+			return err
+		},
+	}
+}
+
+func (self *receiver) handle(ctx context.Context, msg interface{}) error {
+	switch msg := msg.(type) {
+	case *BlobMsg:
+		return self.deliver(msg.Envelope)
+	case *envelope.Part:
+		// parts that don't add up, or add up to too much, are the peer misbehaving, and it's dropped
+		b, err := self.parts.Add(msg)
+		if err != nil {
+			return err
+		}
+		if b != nil {
+			return self.deliver(b)
+		}
+		return nil
+	}
+	return fmt.Errorf("unexpected message %T", msg)
+}
+
+func (self *receiver) deliver(b []byte) error {
+	var payload []byte
+	err := envelope.Unwrap(b, &payload)
+	if envelope.IsTooLarge(err) {
+		// the message itself was within the limit, only its payload is refused, so the peer keeps its connection
+		self.resultC <- fmt.Sprintf("refused: %v", err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	self.resultC <- fmt.Sprintf("got %d bytes, sha256 %x", len(payload), sha256.Sum256(payload))
+	return nil
+}
+
+// the sender's protocol only hands its peer to main, which does the sending
+func senderProtocol(peerC chan *protocols.Peer) p2p.Protocol {
+	return p2p.Protocol{
+		Name:    spec.Name,
+		Version: spec.Version,
+		Length:  spec.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			pp := protocols.NewPeer(p, rw, &spec)
+			peerC <- pp
+			return pp.Run(func(ctx context.Context, msg interface{}) error {
+				return fmt.Errorf("unexpected message %T", msg)
+			})
+		},
+	}
+}
+
+// sends the envelope whole if it fits in a message, and in parts if it doesn't
+func send(pp *protocols.Peer, id uint64, b []byte) error {
+	msg := &BlobMsg{Envelope: b}
+	err := envelope.CheckMsgSize(msg, maxMsgSize-wrapOverhead)
+	if err == nil {
+		return pp.Send(context.TODO(), msg)
+	} else if !envelope.IsTooLarge(err) {
+		return err
+	}
+	parts := envelope.Split(id, b, partSize)
+	fmt.Printf("  %v, sending it in %d parts\n", err, len(parts))
+	for _, part := range parts {
+		err := pp.Send(context.TODO(), part)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		demo.Log.Crit("random bytes fail", "err", err)
+	}
+	return b
+}
+
+func expectResult(resultC chan string) {
+	result, err := demo.ExpectMsg(resultC, nil, resultWait)
+	if err != nil {
+		demo.Log.Crit("no answer from the receiver", "err", err)
+	}
+	fmt.Printf("  receiver: %s\n", result)
+}
+
+// waits for the server to drop its peer, and returns why
+func expectDrop(eventC chan *p2p.PeerEvent) string {
+	for {
+		v, err := demo.ExpectMsg(eventC, nil, resultWait)
+		if err != nil {
+			demo.Log.Crit("peer not dropped", "err", err)
+		}
+		if ev := v.(*p2p.PeerEvent); ev.Type == p2p.PeerEventTypeDrop {
+			return ev.Error
+		}
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// the receiver decompresses no payload to more than this, whatever size it came in
+	// the setting is for the whole process, the sender here doesn't decompress anything
+	envelope.MaxPayloadSize = maxReassembled
+
+	receiverkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+	senderkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+
+	r := &receiver{
+		parts:   envelope.NewReassembler(maxReassembled, maxPending),
+		resultC: make(chan string, 1),
+		runErrC: make(chan error, 1),
+	}
+	receiverSrv := demo.NewServer(receiverkey, "receiver", "1", r.protocol(), demo.Conf.P2PPort)
+	if err := receiverSrv.Start(); err != nil {
+		demo.Log.Crit("Start p2p.Server failed", "err", err)
+	}
+	defer receiverSrv.Stop()
+	receiverEventC := make(chan *p2p.PeerEvent, 16)
+	sub := receiverSrv.SubscribeEvents(receiverEventC)
+	defer sub.Unsubscribe()
+
+	peerC := make(chan *protocols.Peer, 1)
+	senderSrv := demo.NewServer(senderkey, "sender", "1", senderProtocol(peerC), 0)
+	if err := senderSrv.Start(); err != nil {
+		demo.Log.Crit("Start p2p.Server failed", "err", err)
+	}
+	defer senderSrv.Stop()
+	senderEventC := make(chan *p2p.PeerEvent, 16)
+	sub = senderSrv.SubscribeEvents(senderEventC)
+	defer sub.Unsubscribe()
+
+	senderSrv.AddPeer(receiverSrv.Self())
+	v, err := demo.ExpectMsg(peerC, nil, resultWait)
+	if err != nil {
+		demo.Log.Crit("peers did not connect", "err", err)
+	}
+	pp := v.(*protocols.Peer)
+
+	// a blob that fits goes whole
+	b, err := envelope.Encode(envelope.Raw, envelope.CompressNone, randomBytes(200))
+	if err != nil {
+		demo.Log.Crit("encode fail", "err", err)
+	}
+	fmt.Printf("sending %d bytes\n", len(b))
+	if err := send(pp, 1, b); err != nil {
+		demo.Log.Crit("send fail", "err", err)
+	}
+	expectResult(r.resultC)
+
+	// one that doesn't goes in parts, put back together by the receiver
+	payload := randomBytes(blobSize)
+	b, err = envelope.Encode(envelope.Raw, envelope.CompressNone, payload)
+	if err != nil {
+		demo.Log.Crit("encode fail", "err", err)
+	}
+	fmt.Printf("sending %d bytes, sha256 %x\n", len(b), sha256.Sum256(payload))
+	if err := send(pp, 2, b); err != nil {
+		demo.Log.Crit("send fail", "err", err)
+	}
+	expectResult(r.resultC)
+
+	// a small message can still be a large payload: a quarter of a megabyte of zeros compresses to some five hundred bytes
+	// the receiver stops decompressing at its limit, and refuses the payload
+	b, err = envelope.Encode(envelope.Raw, envelope.CompressFlate, make([]byte, 256*1024))
+	if err != nil {
+		demo.Log.Crit("encode fail", "err", err)
+	}
+	fmt.Printf("sending %d bytes of compressed zeros\n", len(b))
+	if err := send(pp, 3, b); err != nil {
+		demo.Log.Crit("send fail", "err", err)
+	}
+	expectResult(r.resultC)
+
+	// a blob that doesn't fit, sent without checking
+	// sending succeeds, nothing on the way stops a message below the 16 megabytes of a devp2p frame;
+	// the receiver drops the peer as soon as it reads the size of the message, before reading the message
+	b, err = envelope.Encode(envelope.Raw, envelope.CompressNone, randomBytes(blobSize))
+	if err != nil {
+		demo.Log.Crit("encode fail", "err", err)
+	}
+	fmt.Printf("sending %d bytes without checking\n", len(b))
+	err = pp.Send(context.TODO(), &BlobMsg{Envelope: b})
+	fmt.Printf("  send returned %v\n", err)
+
+	runErr := <-r.runErrC
+	if perr, ok := runErr.(*protocols.Error); ok && perr.Code == protocols.ErrMsgTooLong {
+		fmt.Printf("  receiver's run loop: protocols error code %d, %q\n", perr.Code, perr.Error())
+	} else {
+		fmt.Printf("  receiver's run loop: %v\n", runErr)
+	}
+	fmt.Printf("  receiver dropped the sender: %q\n", expectDrop(receiverEventC))
+	fmt.Printf("  sender was dropped: %q\n", expectDrop(senderEventC))
+}
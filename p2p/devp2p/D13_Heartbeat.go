@@ -0,0 +1,259 @@
+// finding a peer that hangs, not crashes, with a heartbeat of the protocol, and dialing it again
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	demo "./common"
+	"./heartbeat"
+)
+
+const (
+	nodeCount    = 4
+	tickInterval = time.Millisecond * 100
+	hangAt       = time.Second
+	hangFor      = time.Second * 4
+	runFor       = time.Second * 9
+	minBackoff   = time.Millisecond * 250
+	maxBackoff   = time.Second * 2
+)
+
+var (
+	heartbeatConfig = heartbeat.Config{
+		Interval: time.Millisecond * 250,
+		Misses:   3,
+		Jitter:   0.2,
+	}
+	start = time.Now()
+)
+
+// the service of every node: a protocol sending a tick to each peer every 100 milliseconds, and reading the ticks of the peers
+// hanging stops it doing either, without closing anything, like a deadlock would
+type ticker struct {
+	name    string
+	monitor *heartbeat.Monitor
+	srv     *p2p.Server
+	dialer  p2p.NodeDialer
+	names   func(enode.ID) string
+
+	mu    sync.Mutex
+	hungC chan struct{} // closed when the service stops hanging, nil if it isn't
+}
+
+func (self *ticker) Protocols() []p2p.Protocol {
+	return self.monitor.Protocols([]p2p.Protocol{
+		{
+			Name:    "ticks",
+			Version: 1,
+			Length:  1,
+			Run:     self.run,
+		},
+	})
+}
+
+func (self *ticker) APIs() []rpc.API {
+	return nil
+}
+
+func (self *ticker) Start(srv *p2p.Server) error {
+	self.srv = srv
+	return nil
+}
+
+func (self *ticker) Stop() error {
+	return nil
+}
+
+func (self *ticker) hang(d time.Duration) {
+	hungC := make(chan struct{})
+	self.mu.Lock()
+	self.hungC = hungC
+	self.mu.Unlock()
+	time.AfterFunc(d, func() {
+		self.mu.Lock()
+		self.hungC = nil
+		self.mu.Unlock()
+		close(hungC)
+	})
+}
+
+// blocks while the service hangs
+func (self *ticker) wait() {
+	self.mu.Lock()
+	hungC := self.hungC
+	self.mu.Unlock()
+	if hungC != nil {
+		<-hungC
+	}
+}
+
+func (self *ticker) run(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	errC := make(chan error, 2)
+	go func() {
+		for {
+			self.wait()
+			if err := p2p.Send(rw, 0, uint64(time.Now().UnixNano())); err != nil {
+				errC <- err
+				return
+			}
+			time.Sleep(tickInterval)
+		}
+	}()
+	go func() {
+		for {
+			self.wait()
+			msg, err := rw.ReadMsg()
+			if err != nil {
+				errC <- err
+				return
+			}
+			msg.Discard()
+		}
+	}()
+	return <-errC
+}
+
+// the monitor has dropped the peer, dial it again
+//
+// the dialer of the server won't dial a node it dialed in the last 30 seconds, so we dial it ourselves.
+// A node that still hangs can't tell its end of the old connection is gone, and turns the new one away as already connected.
+// The wait before dialing starts longer the more often the peer has been dropped, and doubles with every failed dial
+func (self *ticker) redial(n *enode.Node) {
+	evictions := self.monitor.Stats()[n.ID()].Evictions
+	delay := minBackoff << uint(evictions-1)
+	fmt.Printf("%6v %s: dropped %s, not answering (%d times now)\n", since(), self.name, self.names(n.ID()), evictions)
+	for {
+		if delay > maxBackoff || delay <= 0 {
+			delay = maxBackoff
+		}
+		time.Sleep(delay)
+		conn, err := self.dialer.Dial(n)
+		if err == nil {
+			err = self.srv.SetupConn(conn, 0, n)
+		}
+		if err == nil {
+			fmt.Printf("%6v %s: connected to %s again\n", since(), self.name, self.names(n.ID()))
+			return
+		}
+		fmt.Printf("%6v %s: dialing %s failed: %v\n", since(), self.name, self.names(n.ID()), err)
+		delay *= 2
+	}
+}
+
+func since() time.Duration {
+	return time.Since(start).Round(time.Millisecond)
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	var mu sync.Mutex
+	tickers := make(map[enode.ID]*ticker)
+	names := func(id enode.ID) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if t, ok := tickers[id]; ok {
+			return t.name
+		}
+		return id.TerminalString()
+	}
+
+	// the adapter is the dialer of the nodes, connecting them with in-memory pipes
+	var adapter *adapters.SimAdapter
+	adapter = adapters.NewSimAdapter(map[string]adapters.ServiceFunc{
+		"ticker": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			t := &ticker{
+				name:    ctx.Config.Name,
+				monitor: heartbeat.NewMonitor(heartbeatConfig),
+				dialer:  adapter,
+				names:   names,
+			}
+			t.monitor.OnDead = t.redial
+			mu.Lock()
+			tickers[ctx.Config.ID] = t
+			mu.Unlock()
+			return t, nil
+		},
+	})
+	net := simulations.NewNetwork(adapter, &simulations.NetworkConfig{
+		DefaultService: "ticker",
+	})
+	defer net.Shutdown()
+
+	var ids []enode.ID
+	for i := 0; i < nodeCount; i++ {
+		cfg := adapters.RandomNodeConfig()
+		cfg.Name = fmt.Sprintf("node%d", i)
+		n, err := net.NewNodeWithConfig(cfg)
+		if err != nil {
+			demo.Log.Crit("new node fail", "err", err)
+		}
+		if err := net.Start(n.ID()); err != nil {
+			demo.Log.Crit("start node fail", "err", err)
+		}
+		ids = append(ids, n.ID())
+	}
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			if err := net.Connect(ids[i], ids[j]); err != nil {
+				demo.Log.Crit("connect fail", "err", err)
+			}
+		}
+	}
+	err := demo.EventuallyWithin(time.Second*5, func() bool {
+		for _, id := range ids {
+			if tickers[id].srv.PeerCount() < nodeCount-1 {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		demo.Log.Crit("nodes did not connect", "err", err)
+	}
+	start = time.Now()
+	fmt.Printf("%6v all %d nodes connected\n", since(), nodeCount)
+
+	hung := ids[nodeCount-1]
+	time.Sleep(hangAt)
+	fmt.Printf("%6v %s hangs for %v\n", since(), names(hung), hangFor)
+	tickers[hung].hang(hangFor)
+	time.Sleep(runFor - hangAt)
+
+	// the hung node is dropped by all, and dropped no one itself; the others never dropped each other
+	fmt.Printf("\n%6v after the run:\n", since())
+	ok := true
+	for _, id := range ids {
+		stats := tickers[id].monitor.Stats()
+		var peers []enode.ID
+		for peer := range stats {
+			peers = append(peers, peer)
+		}
+		sort.Slice(peers, func(i, j int) bool {
+			return names(peers[i]) < names(peers[j])
+		})
+		for _, peer := range peers {
+			s := stats[peer]
+			fmt.Printf("  %s -> %s: connected %v, dropped %d times, last rtt %v\n", names(id), names(peer), s.Connected, s.Evictions, s.RTT.Round(time.Microsecond))
+			wantDropped := peer == hung
+			if (s.Evictions > 0) != wantDropped || !s.Connected {
+				ok = false
+			}
+		}
+	}
+	if !ok {
+		demo.Log.Crit("the hung node was not the only one dropped, or not all are connected again")
+	}
+	fmt.Printf("\nonly %s was dropped, and all are connected again\n", names(hung))
+}
@@ -0,0 +1,419 @@
+// changing the node key, and staying the same node to the peers: a rotation signed with the old key
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	demo "./common"
+)
+
+const (
+	handshakeTimeout = time.Second
+	updateWait       = time.Second * 5
+	maxChain         = 16 // rotations a peer may show, more and it's dropped
+)
+
+// a rotation says the node with the key that signed it now has the key of Node
+// Seq counts the rotations since the first key, which is the identity the node keeps across all of them
+type Rotation struct {
+	Node      string
+	Seq       uint64
+	Signature []byte
+}
+
+// the first message on a connection: the name, where the node listens, and the rotations from its first key to its current one
+// a node that never rotated has none
+type HelloMsg struct {
+	Name  string
+	Node  string
+	Chain []*Rotation
+}
+
+var (
+	identProtocol = protocols.Spec{
+		Name:       "ident",
+		Version:    1,
+		MaxMsgSize: 8192,
+		Messages: []interface{}{
+			&HelloMsg{},
+			&Rotation{},
+		},
+	}
+
+	errChainEnd = errors.New("rotations don't end at the key of the peer")
+)
+
+func rotationHash(node string, seq uint64) ([]byte, error) {
+	b, err := rlp.EncodeToBytes([]interface{}{node, seq})
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Keccak256(b), nil
+}
+
+func signRotation(oldkey *ecdsa.PrivateKey, n *enode.Node, seq uint64) (*Rotation, error) {
+	hash, err := rotationHash(n.String(), seq)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.Sign(hash, oldkey)
+	if err != nil {
+		return nil, err
+	}
+	return &Rotation{
+		Node:      n.String(),
+		Seq:       seq,
+		Signature: sig,
+	}, nil
+}
+
+// returns the id of the key that signed the rotation, and the node it rotated to
+func (r *Rotation) verify() (enode.ID, *enode.Node, error) {
+	hash, err := rotationHash(r.Node, r.Seq)
+	if err != nil {
+		return enode.ID{}, nil, err
+	}
+	pubkey, err := crypto.SigToPub(hash, r.Signature)
+	if err != nil {
+		return enode.ID{}, nil, err
+	}
+	n, err := enode.ParseV4(r.Node)
+	if err != nil {
+		return enode.ID{}, nil, err
+	}
+	return enode.PubkeyToIDV4(pubkey), n, nil
+}
+
+// checks that every rotation is signed by the key the one before rotated to, and the last rotates to the key of the peer
+// it returns the first key, the identity of the peer
+func verifyChain(chain []*Rotation, peer enode.ID) (enode.ID, error) {
+	if len(chain) == 0 {
+		return peer, nil
+	}
+	if len(chain) > maxChain {
+		return enode.ID{}, fmt.Errorf("chain of %d rotations", len(chain))
+	}
+	var origin, prev enode.ID
+	for i, r := range chain {
+		signer, n, err := r.verify()
+		if err != nil {
+			return enode.ID{}, fmt.Errorf("rotation %d: %v", i+1, err)
+		}
+		if i == 0 {
+			origin = signer
+		} else if signer != prev {
+			return enode.ID{}, fmt.Errorf("rotation %d not signed by the key of rotation %d", i+1, i)
+		}
+		if r.Seq != uint64(i+1) {
+			return enode.ID{}, fmt.Errorf("rotation %d numbered %d", i+1, r.Seq)
+		}
+		prev = n.ID()
+	}
+	if prev != peer {
+		return enode.ID{}, errChainEnd
+	}
+	return origin, nil
+}
+
+// what a node knows of another, by the first key of the other
+type contact struct {
+	name string
+	node *enode.Node // where it is now
+	seq  uint64      // the rotations seen
+}
+
+type identNode struct {
+	name  string
+	claim string // the name it gives in its hello, its own unless it lies
+	port  int
+	key   *ecdsa.PrivateKey
+	chain []*Rotation
+	srv   *p2p.Server
+
+	mu    sync.Mutex
+	book  map[enode.ID]*contact
+	peers map[enode.ID]*protocols.Peer
+}
+
+func newIdentNode(name string, port int) *identNode {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+	return &identNode{
+		name:  name,
+		claim: name,
+		port:  port,
+		key:   key,
+		book:  make(map[enode.ID]*contact),
+		peers: make(map[enode.ID]*protocols.Peer),
+	}
+}
+
+func (self *identNode) start() {
+	self.srv = newServer(self.key, self.name, self.protocol(), self.port)
+	if err := self.srv.Start(); err != nil {
+		demo.Log.Crit("Start p2p.Server failed", "err", err)
+	}
+}
+
+func (self *identNode) stop() {
+	self.srv.Stop()
+}
+
+func (self *identNode) hello() *HelloMsg {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return &HelloMsg{
+		Name:  self.claim,
+		Node:  self.srv.Self().String(),
+		Chain: self.chain,
+	}
+}
+
+// the identity of the peer is its first key, whatever key it has now
+// a name already in the book for another identity is someone else using it
+func (self *identNode) verifyHello(p *p2p.Peer) func(interface{}) error {
+	return func(msg interface{}) error {
+		hello, ok := msg.(*HelloMsg)
+		if !ok {
+			return fmt.Errorf("expected hello, got %T", msg)
+		}
+		n, err := enode.ParseV4(hello.Node)
+		if err != nil {
+			return err
+		}
+		if n.ID() != p.ID() {
+			return fmt.Errorf("hello gives address of %s for itself", n.ID().TerminalString())
+		}
+		origin, err := verifyChain(hello.Chain, p.ID())
+		if err != nil {
+			return err
+		}
+		self.mu.Lock()
+		defer self.mu.Unlock()
+		for id, c := range self.book {
+			if c.name == hello.Name && id != origin {
+				return fmt.Errorf("claims to be %s, but has no rotation from the key of %s", hello.Name, hello.Name)
+			}
+		}
+		c, ok := self.book[origin]
+		switch {
+		case !ok:
+			fmt.Printf("  %s: met %s at %s\n", self.name, hello.Name, p.ID().TerminalString())
+			self.book[origin] = &contact{name: hello.Name, node: n, seq: uint64(len(hello.Chain))}
+		case uint64(len(hello.Chain)) > c.seq:
+			fmt.Printf("  %s: %s rotated %d times since we last knew, from %s to %s\n", self.name, hello.Name, uint64(len(hello.Chain))-c.seq, c.node.ID().TerminalString(), p.ID().TerminalString())
+			c.node, c.seq = n, uint64(len(hello.Chain))
+		case uint64(len(hello.Chain)) < c.seq:
+			return fmt.Errorf("%s shows an old key", hello.Name)
+		}
+		return nil
+	}
+}
+
+// a rotation sent by a connected peer is signed with the key it's connected with
+func (self *identNode) handleRotation(p *p2p.Peer, r *Rotation) error {
+	signer, n, err := r.verify()
+	if err != nil {
+		return err
+	}
+	if signer != p.ID() {
+		return fmt.Errorf("rotation not signed by the peer")
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, c := range self.book {
+		if c.node.ID() != p.ID() {
+			continue
+		}
+		if r.Seq != c.seq+1 {
+			return fmt.Errorf("rotation %d after %d", r.Seq, c.seq)
+		}
+		fmt.Printf("  %s: %s announced its new key, %s to %s\n", self.name, c.name, p.ID().TerminalString(), n.ID().TerminalString())
+		c.node, c.seq = n, r.Seq
+		return nil
+	}
+	return fmt.Errorf("rotation from a peer not in the book")
+}
+
+func (self *identNode) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    identProtocol.Name,
+		Version: identProtocol.Version,
+		Length:  identProtocol.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			pp := protocols.NewPeer(p, rw, &identProtocol)
+			ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+			defer cancel()
+			if _, err := pp.Handshake(ctx, self.hello(), self.verifyHello(p)); err != nil {
+				fmt.Printf("  %s: refused %s: %v\n", self.name, p.ID().TerminalString(), err)
+				return err
+			}
+			self.mu.Lock()
+			self.peers[p.ID()] = pp
+			self.mu.Unlock()
+			defer func() {
+				self.mu.Lock()
+				delete(self.peers, p.ID())
+				self.mu.Unlock()
+			}()
+			return pp.Run(func(ctx context.Context, msg interface{}) error {
+				if r, ok := msg.(*Rotation); ok {
+					return self.handleRotation(p, r)
+				}
+				return fmt.Errorf("unexpected message %T", msg)
+			})
+		},
+	}
+}
+
+// changes the key: the rotation is signed with the old one, and sent to the peers connected while it still works
+// then the server starts again with the new key
+func (self *identNode) rotate() {
+	newkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+	self.mu.Lock()
+	newself := enode.NewV4(&newkey.PublicKey, self.srv.Self().IP(), self.port, 0)
+	r, err := signRotation(self.key, newself, uint64(len(self.chain)+1))
+	if err != nil {
+		demo.Log.Crit("sign rotation fail", "err", err)
+	}
+	for _, pp := range self.peers {
+		if err := pp.Send(context.TODO(), r); err != nil {
+			demo.Log.Warn("send rotation fail", "peer", pp.ID(), "err", err)
+		}
+	}
+	self.chain = append(self.chain, r)
+	self.mu.Unlock()
+	fmt.Printf("%s rotates from %s to %s\n", self.name, self.srv.Self().ID().TerminalString(), newself.ID().TerminalString())
+	self.key = newkey
+}
+
+// the contact of the other node in this one's book
+func (self *identNode) contactOf(other *identNode) *contact {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, c := range self.book {
+		if c.name == other.name {
+			cc := *c
+			return &cc
+		}
+	}
+	return nil
+}
+
+// waits until this node has the other where it is now
+func (self *identNode) waitFor(other *identNode) {
+	err := demo.EventuallyWithin(updateWait, func() bool {
+		c := self.contactOf(other)
+		return c != nil && c.node.ID() == enode.PubkeyToIDV4(&other.key.PublicKey)
+	})
+	if err != nil {
+		demo.Log.Crit("address book not updated", "node", self.name, "contact", other.name, "err", err)
+	}
+}
+
+func (self *identNode) show() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	var contacts []*contact
+	for _, c := range self.book {
+		contacts = append(contacts, c)
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		return contacts[i].name < contacts[j].name
+	})
+	for _, c := range contacts {
+		fmt.Printf("  %-6s %-6s %s rotations %d\n", self.name, c.name, c.node.ID().TerminalString(), c.seq)
+	}
+}
+
+func newServer(privkey *ecdsa.PrivateKey, name string, proto p2p.Protocol, port int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey:  privkey,
+		Name:        common.MakeName(name, "1"),
+		MaxPeers:    8,
+		NoDiscovery: true,
+		Protocols:   []p2p.Protocol{proto},
+		ListenAddr:  fmt.Sprintf(":%d", port),
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	alice := newIdentNode("alice", demo.Conf.P2PPort)
+	bob := newIdentNode("bob", demo.Conf.P2PPort+1)
+	carol := newIdentNode("carol", demo.Conf.P2PPort+2)
+	for _, n := range []*identNode{alice, bob, carol} {
+		n.start()
+		defer n.stop()
+	}
+
+	fmt.Println("alice connects to bob and carol")
+	alice.srv.AddPeer(bob.srv.Self())
+	alice.srv.AddPeer(carol.srv.Self())
+	bob.waitFor(alice)
+	carol.waitFor(alice)
+
+	// carol goes away for a while
+	fmt.Println("alice disconnects from carol")
+	alice.srv.RemovePeer(carol.srv.Self())
+	if err := demo.EventuallyWithin(updateWait, func() bool {
+		return carol.srv.PeerCount() == 0
+	}); err != nil {
+		demo.Log.Crit("carol not disconnected", "err", err)
+	}
+
+	// alice rotates twice; bob is told each time, and alice dials him again with the new key
+	for i := 0; i < 2; i++ {
+		alice.rotate()
+		bob.waitFor(alice)
+		alice.stop()
+		alice.start()
+		alice.srv.AddPeer(bob.srv.Self())
+		if err := demo.EventuallyWithin(updateWait, func() bool {
+			return alice.srv.PeerCount() == 1
+		}); err != nil {
+			demo.Log.Crit("bob not connected again", "err", err)
+		}
+	}
+
+	// carol missed both rotations, and follows them from the key she knew to the one alice has now
+	fmt.Println("alice connects to carol again")
+	alice.srv.AddPeer(carol.srv.Self())
+	carol.waitFor(alice)
+
+	// someone else saying it's alice can't show a rotation signed by the key carol knows alice by
+	mallory := newIdentNode("mallory", demo.Conf.P2PPort+3)
+	mallory.claim = alice.name
+	mallory.start()
+	defer mallory.stop()
+	fmt.Println("a node with a key of its own connects to carol as alice")
+	mallory.srv.AddPeer(carol.srv.Self())
+	time.Sleep(handshakeTimeout)
+
+	fmt.Printf("\nalice is %s now, after %d rotations\n", alice.srv.Self().ID().TerminalString(), len(alice.chain))
+	bob.show()
+	carol.show()
+}
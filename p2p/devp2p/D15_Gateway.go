@@ -0,0 +1,410 @@
+// a gateway in two networks at once, passing messages between them as its policy allows
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+
+	demo "./common"
+)
+
+const (
+	privateNetwork   = 1001
+	publicNetwork    = 1
+	handshakeTimeout = time.Second
+	deliverWait      = time.Second * 2
+
+	// how long to wait for messages that shouldn't come
+	settle = time.Millisecond * 200
+)
+
+// the network id is exchanged first, and a peer in another network is refused
+// it's how eth keeps mainnet and the testnets apart, though they speak the same protocol
+type StatusMsg struct {
+	NetworkID uint64
+}
+
+// Via has the networks the message was bridged from, so no gateway sends it back into one of them
+type ChatMsg struct {
+	Topic string
+	From  string
+	Text  string
+	Via   []uint64
+}
+
+var (
+	chatProtocol = protocols.Spec{
+		Name:       "chat",
+		Version:    1,
+		MaxMsgSize: 4096,
+		Messages: []interface{}{
+			&StatusMsg{},
+			&ChatMsg{},
+		},
+	}
+)
+
+// a server in one network; it sends to all its peers, and hands what it gets to onMsg
+// it doesn't pass messages on, the nodes of a network here are all connected to each other
+type netNode struct {
+	name      string
+	networkID uint64
+	srv       *p2p.Server
+	onMsg     func(n *netNode, msg *ChatMsg)
+
+	mu    sync.Mutex
+	peers map[enode.ID]*protocols.Peer
+}
+
+func newNetNode(name string, networkID uint64, port int, onMsg func(n *netNode, msg *ChatMsg)) *netNode {
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+	n := &netNode{
+		name:      name,
+		networkID: networkID,
+		onMsg:     onMsg,
+		peers:     make(map[enode.ID]*protocols.Peer),
+	}
+	n.srv = newServer(privkey, name, n.protocol(), port)
+	if err := n.srv.Start(); err != nil {
+		demo.Log.Crit("Start p2p.Server failed", "err", err)
+	}
+	return n
+}
+
+func (self *netNode) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    chatProtocol.Name,
+		Version: chatProtocol.Version,
+		Length:  chatProtocol.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			pp := protocols.NewPeer(p, rw, &chatProtocol)
+			ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+			defer cancel()
+			_, err := pp.Handshake(ctx, &StatusMsg{NetworkID: self.networkID}, func(msg interface{}) error {
+				status, ok := msg.(*StatusMsg)
+				if !ok {
+					return fmt.Errorf("expected status, got %T", msg)
+				}
+				if status.NetworkID != self.networkID {
+					return fmt.Errorf("peer in network %d, we're in %d", status.NetworkID, self.networkID)
+				}
+				return nil
+			})
+			if err != nil {
+				fmt.Printf("  %s refused %s: %v\n", self.name, p.Name(), err)
+				return err
+			}
+			self.mu.Lock()
+			self.peers[p.ID()] = pp
+			self.mu.Unlock()
+			defer func() {
+				self.mu.Lock()
+				delete(self.peers, p.ID())
+				self.mu.Unlock()
+			}()
+			return pp.Run(func(ctx context.Context, msg interface{}) error {
+				chat, ok := msg.(*ChatMsg)
+				if !ok {
+					return fmt.Errorf("unexpected message %T", msg)
+				}
+				self.onMsg(self, chat)
+				return nil
+			})
+		},
+	}
+}
+
+func (self *netNode) broadcast(msg *ChatMsg) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, pp := range self.peers {
+		if err := pp.Send(context.TODO(), msg); err != nil {
+			demo.Log.Warn("send fail", "node", self.name, "peer", pp.ID(), "err", err)
+		}
+	}
+}
+
+func (self *netNode) connect(other *netNode) {
+	self.srv.AddPeer(other.srv.Self())
+}
+
+// a rule lets the messages of the topics through from one network to the other, "*" is any topic
+type Rule struct {
+	From   uint64
+	To     uint64
+	Topics []string
+}
+
+func (r *Rule) allows(from, to uint64, topic string) bool {
+	if r.From != from || r.To != to {
+		return false
+	}
+	for _, t := range r.Topics {
+		if t == "*" || t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// the gateway has a server in each network, and sends on what one gets to the other if a rule of the policy allows it
+// anything not allowed stays in the network it was sent in
+type gateway struct {
+	nodes map[uint64]*netNode
+
+	mu      sync.Mutex
+	policy  []Rule
+	bridged map[string]int // by "from->to topic"
+	dropped map[string]int
+}
+
+func newGateway(policy []Rule) *gateway {
+	return &gateway{
+		nodes:   make(map[uint64]*netNode),
+		policy:  policy,
+		bridged: make(map[string]int),
+		dropped: make(map[string]int),
+	}
+}
+
+func (self *gateway) join(name string, networkID uint64, port int) *netNode {
+	n := newNetNode(name, networkID, port, self.handle)
+	self.nodes[networkID] = n
+	return n
+}
+
+// the policy can be changed while the gateway runs
+func (self *gateway) setPolicy(policy []Rule) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.policy = policy
+}
+
+func (self *gateway) allowed(from, to uint64, topic string) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	key := fmt.Sprintf("%d->%d %s", from, to, topic)
+	for _, r := range self.policy {
+		if r.allows(from, to, topic) {
+			self.bridged[key]++
+			return true
+		}
+	}
+	self.dropped[key]++
+	return false
+}
+
+func (self *gateway) handle(in *netNode, msg *ChatMsg) {
+	via := append(append([]uint64{}, msg.Via...), in.networkID)
+	for networkID, out := range self.nodes {
+		if networkID == in.networkID || hasNetwork(msg.Via, networkID) {
+			continue
+		}
+		if !self.allowed(in.networkID, networkID, msg.Topic) {
+			continue
+		}
+		out.broadcast(&ChatMsg{
+			Topic: msg.Topic,
+			From:  msg.From,
+			Text:  msg.Text,
+			Via:   via,
+		})
+	}
+}
+
+func hasNetwork(networks []uint64, networkID uint64) bool {
+	for _, n := range networks {
+		if n == networkID {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *gateway) show() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, counts := range []struct {
+		what   string
+		counts map[string]int
+	}{
+		{"bridged", self.bridged},
+		{"dropped", self.dropped},
+	} {
+		var keys []string
+		for k := range counts.counts {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("  %s %-20s %d\n", counts.what, k, counts.counts[k])
+		}
+	}
+}
+
+// keeps what the nodes got
+type inbox struct {
+	mu   sync.Mutex
+	msgs map[string][]string // by node name
+}
+
+func (self *inbox) handle(n *netNode, msg *ChatMsg) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	got := fmt.Sprintf("%s/%s", msg.Topic, msg.Text)
+	if len(msg.Via) > 0 {
+		got += fmt.Sprintf(" via %v", msg.Via)
+	}
+	self.msgs[n.name] = append(self.msgs[n.name], got)
+}
+
+func (self *inbox) has(name string, text string) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, got := range self.msgs[name] {
+		if strings.Contains(got, "/"+text) {
+			return true
+		}
+	}
+	return false
+}
+
+func newServer(privkey *ecdsa.PrivateKey, name string, proto p2p.Protocol, port int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey:  privkey,
+		Name:        common.MakeName(name, "1"),
+		MaxPeers:    8,
+		NoDiscovery: true,
+		Protocols:   []p2p.Protocol{proto},
+		ListenAddr:  fmt.Sprintf(":%d", port),
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// announcements go out to the public network, and only alerts come in
+	gw := newGateway([]Rule{
+		{From: privateNetwork, To: publicNetwork, Topics: []string{"announce"}},
+		{From: publicNetwork, To: privateNetwork, Topics: []string{"alert"}},
+	})
+	gwPrivate := gw.join("gw-private", privateNetwork, demo.Conf.P2PPort)
+	gwPublic := gw.join("gw-public", publicNetwork, demo.Conf.P2PPort+1)
+
+	in := &inbox{msgs: make(map[string][]string)}
+	p1 := newNetNode("p1", privateNetwork, demo.Conf.P2PPort+2, in.handle)
+	p2 := newNetNode("p2", privateNetwork, demo.Conf.P2PPort+3, in.handle)
+	q1 := newNetNode("q1", publicNetwork, demo.Conf.P2PPort+4, in.handle)
+	q2 := newNetNode("q2", publicNetwork, demo.Conf.P2PPort+5, in.handle)
+	for _, n := range []*netNode{gwPrivate, gwPublic, p1, p2, q1, q2} {
+		defer n.srv.Stop()
+	}
+
+	p1.connect(p2)
+	p1.connect(gwPrivate)
+	p2.connect(gwPrivate)
+	q1.connect(q2)
+	q1.connect(gwPublic)
+	q2.connect(gwPublic)
+	err := demo.EventuallyWithin(deliverWait, func() bool {
+		return p1.srv.PeerCount() == 2 && p2.srv.PeerCount() == 2 && q1.srv.PeerCount() == 2 && q2.srv.PeerCount() == 2
+	})
+	if err != nil {
+		demo.Log.Crit("networks did not connect", "err", err)
+	}
+
+	// a node of one network dialing a node of the other gets as far as the handshake
+	fmt.Println("q2 dials p1")
+	q2.connect(p1)
+	time.Sleep(settle)
+
+	steps := []struct {
+		from        *netNode
+		topic, text string
+		want        []string // the nodes that should get it
+	}{
+		{p1, "announce", "release 1.2 is out", []string{"p2", "q1", "q2"}},
+		{p1, "internal", "db password rotated", []string{"p2"}},
+		{q1, "alert", "bad block at 4711", []string{"q2", "p1", "p2"}},
+		{q1, "announce", "buy my token", []string{"q2"}},
+	}
+	run := func(step int) {
+		s := steps[step]
+		fmt.Printf("%s sends %s: %q\n", s.from.name, s.topic, s.text)
+		s.from.broadcast(&ChatMsg{Topic: s.topic, From: s.from.name, Text: s.text})
+		err := demo.EventuallyWithin(deliverWait, func() bool {
+			for _, name := range s.want {
+				if !in.has(name, s.text) {
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			demo.Log.Crit("message not delivered", "text", s.text, "err", err)
+		}
+		time.Sleep(settle)
+	}
+	for i := range steps {
+		run(i)
+	}
+
+	// the public network gets to announce to the private one as well, from now on
+	fmt.Println("the gateway lets public announcements in too")
+	gw.setPolicy([]Rule{
+		{From: privateNetwork, To: publicNetwork, Topics: []string{"announce"}},
+		{From: publicNetwork, To: privateNetwork, Topics: []string{"alert", "announce"}},
+	})
+	steps = append(steps, struct {
+		from        *netNode
+		topic, text string
+		want        []string
+	}{q2, "announce", "meetup on friday", []string{"q1", "p1", "p2"}})
+	run(len(steps) - 1)
+
+	// and nothing reached a node it shouldn't have
+	fmt.Println("\nreceived:")
+	ok := true
+	for _, n := range []*netNode{p1, p2, q1, q2} {
+		in.mu.Lock()
+		got := in.msgs[n.name]
+		in.mu.Unlock()
+		want := 0
+		for _, s := range steps {
+			for _, name := range s.want {
+				if name == n.name {
+					want++
+				}
+			}
+		}
+		if len(got) != want {
+			ok = false
+		}
+		for _, m := range got {
+			fmt.Printf("  %-3s %s\n", n.name, m)
+		}
+	}
+	fmt.Println("\ngateway:")
+	gw.show()
+	if !ok {
+		demo.Log.Crit("messages got where the policy doesn't allow")
+	}
+}
@@ -0,0 +1,377 @@
+// gossip that sends control messages ahead of bulk data, without starving the data
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+
+	demo "./common"
+	"./envelope"
+	"./lanes"
+)
+
+const (
+	nodeCount = 4
+	linkRate  = 256 * 1024 // bytes a second a node sends each peer, so the queues fill up under load
+	msgHeader = 64         // what a message takes on the link besides its data, about
+
+	controlSize = 128
+	bulkSize    = 4096
+	settleTime  = time.Second * 10 // how long the messages of a round may take to cross the network
+	gossipTTL   = settleTime       // a message still on the way when the round is over is stale
+)
+
+// the classes of the messages, which are the lanes they go in
+const (
+	classControl = iota
+	classBulk
+	classCount
+)
+
+var classNames = []string{"control", "bulk"}
+
+// a message flooded through the network, every node passes it on to its peers once
+type GossipMsg struct {
+	ID     uint64
+	Origin enode.ID
+	Class  uint8
+	Sent   uint64 // unix nanoseconds, the clocks of the nodes are the same here
+	Data   []byte // in an envelope with a ttl, which the nodes check before passing it on
+}
+
+var (
+	gossipProtocol = protocols.Spec{
+		Name:       "gossip",
+		Version:    1,
+		MaxMsgSize: bulkSize + 1024,
+		Messages: []interface{}{
+			&GossipMsg{},
+		},
+	}
+)
+
+// how a node queues for its peers
+type mode struct {
+	name  string
+	lanes bool // false puts every message in the first lane, first come first sent
+	cfg   lanes.Config
+}
+
+type gossipPeer struct {
+	*protocols.Peer
+	queue *lanes.Queue
+}
+
+type gossipNode struct {
+	name    string
+	id      enode.ID
+	mu      sync.Mutex
+	mode    mode
+	peers   map[enode.ID]*gossipPeer
+	seen    map[uint64]bool
+	delay   [classCount][]time.Duration // from publishing to getting here, of the messages of the round
+	expired int                         // the messages of the round dropped stale, as they came or in the queues
+}
+
+func newGossipNode(name string) *gossipNode {
+	return &gossipNode{
+		name:  name,
+		peers: make(map[enode.ID]*gossipPeer),
+		seen:  make(map[uint64]bool),
+	}
+}
+
+// queues the message for every peer but the one it came from
+// must be called with the lock held
+func (self *gossipNode) forward(msg *GossipMsg, from enode.ID) {
+	lane := int(msg.Class)
+	if !self.mode.lanes {
+		lane = 0
+	}
+	for id, p := range self.peers {
+		if id == from {
+			continue
+		}
+		if err := p.queue.Push(lane, msg); err != nil {
+			demo.Log.Warn("queue fail", "node", self.name, "peer", id, "err", err)
+		}
+	}
+}
+
+func (self *gossipNode) publish(msg *GossipMsg) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.seen[msg.ID] = true
+	self.forward(msg, enode.ID{})
+}
+
+// sends what's queued for the peer, no faster than the link takes it
+func (self *gossipNode) send(p *gossipPeer) {
+	for {
+		_, msg, _, ok := p.queue.Pop()
+		if !ok {
+			return
+		}
+		gmsg := msg.(*GossipMsg)
+		// what went stale waiting in the queue isn't worth the link
+		if _, err := envelope.Decode(gmsg.Data); envelope.IsExpired(err) {
+			self.mu.Lock()
+			self.expired++
+			self.mu.Unlock()
+			continue
+		}
+		if err := p.Send(context.Background(), gmsg); err != nil {
+			demo.Log.Warn("send fail", "node", self.name, "peer", p.ID(), "err", err)
+		}
+		time.Sleep(time.Second * time.Duration(len(gmsg.Data)+msgHeader) / linkRate)
+	}
+}
+
+func (self *gossipNode) handle(p *gossipPeer) func(context.Context, interface{}) error {
+	return func(ctx context.Context, msg interface{}) error {
+		gmsg, ok := msg.(*GossipMsg)
+		if !ok {
+			return fmt.Errorf("unexpected message %T", msg)
+		}
+		if gmsg.Class >= classCount {
+			return fmt.Errorf("unknown class %d", gmsg.Class)
+		}
+		self.mu.Lock()
+		defer self.mu.Unlock()
+		if self.seen[gmsg.ID] {
+			return nil
+		}
+		self.seen[gmsg.ID] = true
+		// only the header is read, the data is passed on as it came
+		if _, err := envelope.Decode(gmsg.Data); envelope.IsExpired(err) {
+			self.expired++
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("gossip data: %v", err)
+		}
+		self.delay[gmsg.Class] = append(self.delay[gmsg.Class], time.Since(time.Unix(0, int64(gmsg.Sent))))
+		self.forward(gmsg, p.ID())
+		return nil
+	}
+}
+
+func (self *gossipNode) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    gossipProtocol.Name,
+		Version: gossipProtocol.Version,
+		Length:  gossipProtocol.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			self.mu.Lock()
+			gp := &gossipPeer{
+				Peer:  protocols.NewPeer(p, rw, &gossipProtocol),
+				queue: lanes.NewQueue(classCount, self.mode.cfg),
+			}
+			self.peers[p.ID()] = gp
+			self.mu.Unlock()
+			defer func() {
+				self.mu.Lock()
+				delete(self.peers, p.ID())
+				self.mu.Unlock()
+				gp.queue.Close()
+			}()
+			go self.send(gp)
+			return gp.Run(self.handle(gp))
+		},
+	}
+}
+
+func (self *gossipNode) setMode(m mode) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.mode = m
+	for _, p := range self.peers {
+		p.queue.SetConfig(m.cfg)
+	}
+}
+
+// the messages still queued for the peers
+func (self *gossipNode) queued() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	n := 0
+	for _, p := range self.peers {
+		n += p.queue.Len()
+	}
+	return n
+}
+
+// the bulk messages sent ahead of control ones, to keep them from starving
+func (self *gossipNode) promoted() uint64 {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	var n uint64
+	for _, p := range self.peers {
+		n += p.queue.Stats()[classBulk].Promoted
+	}
+	return n
+}
+
+// takes the delays and the expired count of the round, and starts the next from none
+func (self *gossipNode) take() ([classCount][]time.Duration, int) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	d, expired := self.delay, self.expired
+	self.delay = [classCount][]time.Duration{}
+	self.expired = 0
+	return d, expired
+}
+
+func newServer(privkey *ecdsa.PrivateKey, name string, proto p2p.Protocol, port int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey:  privkey,
+		Name:        common.MakeName(name, "1"),
+		MaxPeers:    nodeCount,
+		NoDiscovery: true,
+		Protocols:   []p2p.Protocol{proto},
+		ListenAddr:  fmt.Sprintf(":%d", port),
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+// the delay of the message at the part of the way through the sorted delays
+func percentile(d []time.Duration, p float64) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	return d[int(float64(len(d)-1)*p)]
+}
+
+// the first node publishes control messages while a load of bulk data is on the way
+// in a storm the control messages come all at once, ahead of the bulk data, and more than the links take for a while.
+// The delays are those of the last node, the farthest from the first
+func round(desc string, nodes []*gossipNode, m mode, controls int, bulks int, storm bool, nextID *uint64) {
+	for _, n := range nodes {
+		n.setMode(m)
+	}
+	origin := nodes[0]
+	msg := func(class uint8, size int) *GossipMsg {
+		*nextID++
+		data, err := envelope.EncodeTTL(envelope.Raw, envelope.CompressNone, make([]byte, size), gossipTTL)
+		if err != nil {
+			demo.Log.Crit("envelope fail", "err", err)
+		}
+		return &GossipMsg{
+			ID:     *nextID,
+			Origin: origin.id,
+			Class:  class,
+			Sent:   uint64(time.Now().UnixNano()),
+			Data:   data,
+		}
+	}
+	if storm {
+		for i := 0; i < controls; i++ {
+			origin.publish(msg(classControl, controlSize))
+		}
+	}
+	for i := 0; i < bulks; i++ {
+		origin.publish(msg(classBulk, bulkSize))
+	}
+	for i := 0; i < controls && !storm; i++ {
+		origin.publish(msg(classControl, controlSize))
+		time.Sleep(time.Millisecond * 10)
+	}
+	err := demo.EventuallyWithin(settleTime, func() bool {
+		for _, n := range nodes {
+			if n.queued() > 0 {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		demo.Log.Crit("messages still queued", "round", desc)
+	}
+	// what's no longer queued may still be on the link
+	time.Sleep(time.Millisecond * 200)
+
+	last := nodes[len(nodes)-1]
+	delays, expired := last.take()
+	for _, n := range nodes[:len(nodes)-1] {
+		_, e := n.take()
+		expired += e
+	}
+	fmt.Printf("\n%s\n%-8s %-9s %-9s %-9s %s\n", desc, "class", "got", "p50", "p99", "max")
+	for class, d := range delays {
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		fmt.Printf("%-8s %-9d %-9s %-9s %s\n", classNames[class], len(d),
+			percentile(d, 0.5).Round(time.Millisecond), percentile(d, 0.99).Round(time.Millisecond), percentile(d, 1).Round(time.Millisecond))
+		demo.RunReport.Duration(fmt.Sprintf("%s %s p99", m.name, classNames[class]), percentile(d, 0.99))
+	}
+	var promoted uint64
+	for _, n := range nodes {
+		promoted += n.promoted()
+	}
+	fmt.Printf("bulk sent ahead of control to keep it from starving: %d\n", promoted)
+	fmt.Printf("dropped stale on the way: %d\n", expired)
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// the nodes in a line, each message crosses three links to get to the last
+	var nodes []*gossipNode
+	var servers []*p2p.Server
+	for i := 0; i < nodeCount; i++ {
+		privkey, err := crypto.GenerateKey()
+		if err != nil {
+			demo.Log.Crit("Generate private key failed", "err", err)
+		}
+		n := newGossipNode(fmt.Sprintf("%d", i))
+		srv := newServer(privkey, n.name, n.protocol(), demo.Conf.P2PPort+i)
+		if err := srv.Start(); err != nil {
+			demo.Log.Crit("Start p2p.Server failed", "i", i, "err", err)
+		}
+		defer srv.Stop()
+		n.id = srv.Self().ID()
+		nodes = append(nodes, n)
+		servers = append(servers, srv)
+	}
+	for i := 1; i < nodeCount; i++ {
+		servers[i].AddPeer(servers[i-1].Self())
+	}
+	err := demo.EventuallyWithin(time.Second*5, func() bool {
+		for i, n := range nodes {
+			n.mu.Lock()
+			peers := len(n.peers)
+			n.mu.Unlock()
+			if peers < 2 && i > 0 && i < nodeCount-1 || peers < 1 {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		demo.Log.Crit("timed out connecting the nodes")
+	}
+
+	var id uint64
+	fifo := mode{name: "fifo"}
+	strict := mode{name: "strict", lanes: true}
+	fair := mode{name: "fair", lanes: true, cfg: lanes.Config{MaxBurst: 4, MaxWait: time.Second}}
+
+	// a few control messages behind a load of bulk data
+	round("one queue, control waits behind the bulk data", nodes, fifo, 20, 60, false, &id)
+	round("control lane first", nodes, strict, 20, 60, false, &id)
+
+	// a storm of control messages, with a little bulk data behind them
+	round("control storm, control lane first", nodes, strict, 1000, 10, true, &id)
+	round("control storm, bulk gets one through after every four control", nodes, fair, 1000, 10, true, &id)
+}
@@ -0,0 +1,421 @@
+// collecting the signatures of a committee over a message, and checking them on another node
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+
+	demo "./common"
+)
+
+const (
+	signerCount    = 5
+	threshold      = 3                  // signatures of the committee a message needs
+	collectTimeout = time.Second        // how long the coordinator waits for the signers
+	lateDelay      = collectTimeout * 2 // a late signer answers after the coordinator stopped waiting
+	verifyTimeout  = time.Second * 5
+	sigSize        = 65 // of each signature in the aggregate, r, s and the recovery id
+)
+
+// the coordinator asks the signers to sign the message
+type SignRequest struct {
+	ID      uint64
+	Message []byte
+}
+
+// a signer's signature over the message, with the key of its node
+type SignResponse struct {
+	ID        uint64
+	Signature []byte
+}
+
+// the signatures the coordinator collected, one after the other, for the verifier to check
+type Aggregate struct {
+	ID         uint64
+	Message    []byte
+	Signatures []byte
+}
+
+// what the verifier made of an aggregate
+type Verdict struct {
+	ID      uint64
+	Valid   bool
+	Signers uint8 // the members of the committee who signed
+	Reason  string
+}
+
+var (
+	multisigProtocol = protocols.Spec{
+		Name:       "multisig",
+		Version:    1,
+		MaxMsgSize: 1024 * 16,
+		Messages: []interface{}{
+			&SignRequest{},
+			&SignResponse{},
+			&Aggregate{},
+			&Verdict{},
+		},
+	}
+)
+
+// how a signer answers
+type signMode int
+
+const (
+	signNow     signMode = iota
+	signLate             // after the coordinator stopped waiting
+	signOffline          // never
+)
+
+// the signatures of a round as they come in
+type collected struct {
+	msg   []byte
+	sigs  map[enode.ID][]byte
+	want  int
+	doneC chan struct{} // closed when all the signers answered
+}
+
+type msNode struct {
+	name string
+	key  *ecdsa.PrivateKey
+	id   enode.ID
+
+	mu    sync.Mutex
+	peers map[enode.ID]*protocols.Peer
+	mode  signMode // of a signer
+
+	// the coordinator
+	rounds   map[uint64]*collected
+	late     int // signatures that came after their round
+	verdictC chan *Verdict
+
+	// the verifier
+	committee map[common.Address]bool
+}
+
+func newNode(name string) *msNode {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+	return &msNode{
+		name:     name,
+		key:      key,
+		id:       enode.PubkeyToIDV4(&key.PublicKey),
+		peers:    make(map[enode.ID]*protocols.Peer),
+		rounds:   make(map[uint64]*collected),
+		verdictC: make(chan *Verdict, 1),
+	}
+}
+
+func (self *msNode) setMode(m signMode) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.mode = m
+}
+
+func (self *msNode) peer(id enode.ID) *protocols.Peer {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.peers[id]
+}
+
+// the hash that is signed, prefixed so a signature over it can't pass for a transaction's
+func signHash(msg []byte) []byte {
+	return crypto.Keccak256([]byte("\x19multisig:"), msg)
+}
+
+// the address of the key that made the signature
+func signer(msg []byte, sig []byte) (common.Address, error) {
+	pub, err := crypto.SigToPub(signHash(msg), sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+func (self *msNode) handle(p *protocols.Peer) func(context.Context, interface{}) error {
+	return func(ctx context.Context, msg interface{}) error {
+		switch msg := msg.(type) {
+		case *SignRequest:
+			self.mu.Lock()
+			mode := self.mode
+			self.mu.Unlock()
+			if mode == signOffline {
+				demo.Log.Debug("not signing", "node", self.name, "id", msg.ID)
+				return nil
+			}
+			sig, err := crypto.Sign(signHash(msg.Message), self.key)
+			if err != nil {
+				return err
+			}
+			go func() {
+				if mode == signLate {
+					time.Sleep(lateDelay)
+				}
+				p.Send(context.Background(), &SignResponse{ID: msg.ID, Signature: sig})
+			}()
+
+		case *SignResponse:
+			// the signature must be of the node at the other end, which signs with the key of its node id
+			self.mu.Lock()
+			defer self.mu.Unlock()
+			round, ok := self.rounds[msg.ID]
+			if !ok {
+				self.late++
+				demo.Log.Info("signature after the round", "peer", p.ID().TerminalString(), "id", msg.ID)
+				return nil
+			}
+			pub, err := crypto.SigToPub(signHash(round.msg), msg.Signature)
+			if err != nil || enode.PubkeyToIDV4(pub) != p.ID() {
+				demo.Log.Warn("signature not of the peer", "peer", p.ID().TerminalString(), "id", msg.ID, "err", err)
+				return nil
+			}
+			if _, ok := round.sigs[p.ID()]; ok {
+				return nil
+			}
+			round.sigs[p.ID()] = msg.Signature
+			if len(round.sigs) == round.want {
+				close(round.doneC)
+			}
+
+		case *Aggregate:
+			verdict := self.verify(msg)
+			demo.Log.Info("verified", "node", self.name, "id", msg.ID, "valid", verdict.Valid, "reason", verdict.Reason)
+			go p.Send(context.Background(), verdict)
+
+		case *Verdict:
+			self.verdictC <- msg
+
+		default:
+			return fmt.Errorf("unexpected message %T", msg)
+		}
+		return nil
+	}
+}
+
+// the aggregate is good if it has signatures over the message of as many different members of the committee as the threshold
+func (self *msNode) verify(agg *Aggregate) *Verdict {
+	verdict := &Verdict{ID: agg.ID}
+	if len(agg.Signatures)%sigSize != 0 {
+		verdict.Reason = fmt.Sprintf("%d bytes of signatures", len(agg.Signatures))
+		return verdict
+	}
+	seen := make(map[common.Address]bool)
+	for i := 0; i < len(agg.Signatures); i += sigSize {
+		addr, err := signer(agg.Message, agg.Signatures[i:i+sigSize])
+		if err != nil {
+			verdict.Reason = fmt.Sprintf("signature %d: %v", i/sigSize, err)
+			return verdict
+		}
+		if !self.committee[addr] {
+			verdict.Reason = fmt.Sprintf("signature %d by %s, not of the committee", i/sigSize, addr.Hex())
+			return verdict
+		}
+		if seen[addr] {
+			verdict.Reason = fmt.Sprintf("signature %d by %s again", i/sigSize, addr.Hex())
+			return verdict
+		}
+		seen[addr] = true
+	}
+	verdict.Signers = uint8(len(seen))
+	if len(seen) < threshold {
+		verdict.Reason = fmt.Sprintf("%d signers of %d needed", len(seen), threshold)
+		return verdict
+	}
+	verdict.Valid = true
+	return verdict
+}
+
+// asks all the signers to sign, and waits until they all did or the timeout passed
+// it returns the signatures in the order of the signers, and the signers that didn't answer in time
+func (self *msNode) collect(id uint64, msg []byte, signers []*msNode) ([]byte, []string, error) {
+	round := &collected{
+		msg:   msg,
+		sigs:  make(map[enode.ID][]byte),
+		doneC: make(chan struct{}),
+		want:  len(signers),
+	}
+	self.mu.Lock()
+	self.rounds[id] = round
+	self.mu.Unlock()
+	for _, s := range signers {
+		if err := self.peer(s.id).Send(context.Background(), &SignRequest{ID: id, Message: msg}); err != nil {
+			demo.Log.Warn("sign request fail", "signer", s.name, "err", err)
+		}
+	}
+	select {
+	case <-round.doneC:
+	case <-time.After(collectTimeout):
+	}
+
+	// what comes after the round is closed is late
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	delete(self.rounds, id)
+	var sigs []byte
+	var missing []string
+	for _, s := range signers {
+		if sig, ok := round.sigs[s.id]; ok {
+			sigs = append(sigs, sig...)
+		} else {
+			missing = append(missing, s.name)
+		}
+	}
+	if len(sigs)/sigSize < threshold {
+		return nil, missing, fmt.Errorf("%d signatures of %d needed", len(sigs)/sigSize, threshold)
+	}
+	return sigs, missing, nil
+}
+
+// sends the aggregate to the verifier, and waits for its verdict
+func (self *msNode) check(verifier *msNode, agg *Aggregate) (*Verdict, error) {
+	if err := self.peer(verifier.id).Send(context.Background(), agg); err != nil {
+		return nil, err
+	}
+	select {
+	case v := <-self.verdictC:
+		return v, nil
+	case <-time.After(verifyTimeout):
+		return nil, errors.New("no verdict")
+	}
+}
+
+func (self *msNode) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    multisigProtocol.Name,
+		Version: multisigProtocol.Version,
+		Length:  multisigProtocol.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			pp := protocols.NewPeer(p, rw, &multisigProtocol)
+			self.mu.Lock()
+			self.peers[p.ID()] = pp
+			self.mu.Unlock()
+			defer func() {
+				self.mu.Lock()
+				delete(self.peers, p.ID())
+				self.mu.Unlock()
+			}()
+			return pp.Run(self.handle(pp))
+		},
+	}
+}
+
+func newServer(n *msNode, port int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey:  n.key,
+		Name:        common.MakeName(n.name, "1"),
+		MaxPeers:    signerCount + 1,
+		NoDiscovery: true,
+		Protocols:   []p2p.Protocol{n.protocol()},
+		ListenAddr:  fmt.Sprintf(":%d", port),
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// the coordinator is connected to the signers of the committee, and to the verifier who knows the committee's addresses
+	coordinator := newNode("coordinator")
+	verifier := newNode("verifier")
+	verifier.committee = make(map[common.Address]bool)
+	var signers []*msNode
+	for i := 0; i < signerCount; i++ {
+		s := newNode(fmt.Sprintf("signer%d", i))
+		signers = append(signers, s)
+		verifier.committee[crypto.PubkeyToAddress(s.key.PublicKey)] = true
+	}
+
+	var servers []*p2p.Server
+	for i, n := range append([]*msNode{coordinator, verifier}, signers...) {
+		srv := newServer(n, demo.Conf.P2PPort+i)
+		if err := srv.Start(); err != nil {
+			demo.Log.Crit("Start p2p.Server failed", "node", n.name, "err", err)
+		}
+		defer srv.Stop()
+		servers = append(servers, srv)
+	}
+	for _, srv := range servers[1:] {
+		servers[0].AddPeer(srv.Self())
+	}
+	err := demo.EventuallyWithin(time.Second*5, func() bool {
+		coordinator.mu.Lock()
+		defer coordinator.mu.Unlock()
+		return len(coordinator.peers) == len(servers)-1
+	})
+	if err != nil {
+		demo.Log.Crit("timed out connecting the nodes")
+	}
+
+	// one signer is offline and one answers too late, the other three are enough
+	signers[3].setMode(signLate)
+	signers[4].setMode(signOffline)
+	msg := []byte("pay 10 to 0xc0ffee")
+	sigs, missing, err := coordinator.collect(1, msg, signers)
+	if err != nil {
+		demo.Log.Crit("collect fail", "err", err)
+	}
+	fmt.Printf("%d signatures of %d needed over %q, missing %v\n", len(sigs)/sigSize, threshold, msg, missing)
+	verdict, err := coordinator.check(verifier, &Aggregate{ID: 1, Message: msg, Signatures: sigs})
+	if err != nil {
+		demo.Log.Crit("check fail", "err", err)
+	}
+	if !verdict.Valid {
+		demo.Log.Crit("aggregate refused", "reason", verdict.Reason)
+	}
+	fmt.Printf("verifier: valid, %d signers of the committee\n", verdict.Signers)
+
+	// the same signature three times is one signer, however many signatures there are
+	forged := bytes.Repeat(sigs[:sigSize], threshold)
+	verdict, err = coordinator.check(verifier, &Aggregate{ID: 2, Message: msg, Signatures: forged})
+	if err != nil {
+		demo.Log.Crit("check fail", "err", err)
+	}
+	fmt.Printf("one signature three times: valid %v, %s\n", verdict.Valid, verdict.Reason)
+	if verdict.Valid {
+		demo.Log.Crit("repeated signature accepted")
+	}
+	// and the signatures over another message are no good for this one
+	verdict, err = coordinator.check(verifier, &Aggregate{ID: 3, Message: []byte("pay 1000 to 0xc0ffee"), Signatures: sigs})
+	if err != nil {
+		demo.Log.Crit("check fail", "err", err)
+	}
+	fmt.Printf("signatures over another message: valid %v, %s\n", verdict.Valid, verdict.Reason)
+	if verdict.Valid {
+		demo.Log.Crit("signatures of another message accepted")
+	}
+
+	// with another signer offline there are too few, and the late signature of the first round came after its round
+	signers[2].setMode(signOffline)
+	_, missing, err = coordinator.collect(4, []byte("pay 20 to 0xc0ffee"), signers)
+	if err == nil {
+		demo.Log.Crit("collected with too few signers")
+	}
+	fmt.Printf("%v, missing %v\n", err, missing)
+
+	// the late signer of both rounds answers in the end
+	err = demo.EventuallyWithin(lateDelay*2, func() bool {
+		coordinator.mu.Lock()
+		defer coordinator.mu.Unlock()
+		return coordinator.late == 2
+	})
+	if err != nil {
+		demo.Log.Crit("late signatures missing")
+	}
+	fmt.Printf("2 signatures came after their round, and were ignored\n")
+}
@@ -0,0 +1,488 @@
+// a commit-reveal round among peers, which tells the cheaters, driven by the steps of a simulation
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	demo "./common"
+)
+
+const (
+	nodeCount     = 6
+	round         = 1
+	revealTimeout = time.Second      // how long a node waits for the reveals before it closes the round
+	stepTimeout   = time.Second * 10 // for the expectations of a step to be met on all nodes
+)
+
+// the ways to cheat
+const (
+	honest     = ""
+	equivocate = "equivocate" // reveals another value than it committed to, after seeing the others
+	withhold   = "withhold"   // commits, and doesn't reveal when it doesn't like the outcome
+	copycat    = "copy"       // commits what another node committed, and reveals what that one reveals
+)
+
+// why a node is taken for a cheater
+const (
+	reasonMismatch   = "reveal doesn't match the commitment"
+	reasonNoReveal   = "no reveal"
+	reasonNoCommit   = "reveal without commitment"
+	reasonLateCommit = "commitment after the reveals started"
+)
+
+// round 1: the hash of the value, a random salt and the id of the node
+// the salt keeps the value from being guessed from the hash, and the id keeps another node from committing to the same
+type Commit struct {
+	Round uint64
+	Hash  common.Hash
+}
+
+// round 2: the value and the salt, for every node to check against the commitment
+type Reveal struct {
+	Round uint64
+	Value uint64
+	Salt  []byte
+}
+
+var (
+	crProtocol = protocols.Spec{
+		Name:       "commitreveal",
+		Version:    1,
+		MaxMsgSize: 1024,
+		Messages: []interface{}{
+			&Commit{},
+			&Reveal{},
+		},
+	}
+)
+
+func commitment(value uint64, salt []byte, id enode.ID) common.Hash {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, value)
+	return crypto.Keccak256Hash(v, salt, id[:])
+}
+
+// Status is what a node made of the round so far
+type Status struct {
+	Commits  int               `json:"commits"`
+	Reveals  int               `json:"reveals"`
+	Closed   bool              `json:"closed"`
+	Cheaters map[string]string `json:"cheaters"` // the reason, by node id
+	Outcome  hexutil.Uint64    `json:"outcome"`  // the values of the honest nodes xored, which none of them could choose
+}
+
+// the service of every node, taking part in the round, or cheating in it
+type crNode struct {
+	name   string
+	id     enode.ID
+	cheat  string
+	victim enode.ID // of the copycat
+	value  uint64
+	salt   []byte
+
+	mu       sync.Mutex
+	peers    map[enode.ID]*protocols.Peer
+	revealed bool // the reveals started, commitments are no longer taken
+	commits  map[enode.ID]common.Hash
+	reveals  map[enode.ID]*Reveal
+	status   Status
+	closedC  chan struct{}
+}
+
+func newNode(name string, id enode.ID) *crNode {
+	var v [8]byte
+	salt := make([]byte, 32)
+	rand.Read(v[:])
+	rand.Read(salt)
+	return &crNode{
+		name:    name,
+		id:      id,
+		value:   binary.BigEndian.Uint64(v[:]),
+		salt:    salt,
+		peers:   make(map[enode.ID]*protocols.Peer),
+		commits: make(map[enode.ID]common.Hash),
+		reveals: make(map[enode.ID]*Reveal),
+		closedC: make(chan struct{}),
+	}
+}
+
+func (self *crNode) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{
+		{
+			Name:    crProtocol.Name,
+			Version: crProtocol.Version,
+			Length:  crProtocol.Length(),
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				pp := protocols.NewPeer(p, rw, &crProtocol)
+				self.mu.Lock()
+				self.peers[p.ID()] = pp
+				self.mu.Unlock()
+				defer func() {
+					self.mu.Lock()
+					delete(self.peers, p.ID())
+					self.mu.Unlock()
+				}()
+				return pp.Run(func(ctx context.Context, msg interface{}) error {
+					return self.handle(p.ID(), msg)
+				})
+			},
+		},
+	}
+}
+
+func (self *crNode) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "commitreveal",
+			Version:   "1.0",
+			Service:   &CommitRevealAPI{self},
+			Public:    true,
+		},
+	}
+}
+
+func (self *crNode) Start(srv *p2p.Server) error {
+	return nil
+}
+
+func (self *crNode) Stop() error {
+	return nil
+}
+
+// CommitRevealAPI serves the status of the round
+type CommitRevealAPI struct {
+	node *crNode
+}
+
+// Status returns what the node made of the round so far
+func (self *CommitRevealAPI) Status() Status {
+	self.node.mu.Lock()
+	defer self.node.mu.Unlock()
+	st := self.node.status
+	st.Commits = len(self.node.commits)
+	st.Reveals = len(self.node.reveals)
+	st.Cheaters = make(map[string]string)
+	for id, reason := range self.node.status.Cheaters {
+		st.Cheaters[id] = reason
+	}
+	return st
+}
+
+// the messages of the peers, and our own, are all taken in here
+func (self *crNode) handle(from enode.ID, msg interface{}) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	switch msg := msg.(type) {
+	case *Commit:
+		if msg.Round != round {
+			return nil
+		}
+		if self.revealed {
+			self.flag(from, reasonLateCommit)
+			return nil
+		}
+		if _, ok := self.commits[from]; !ok {
+			self.commits[from] = msg.Hash
+		}
+	case *Reveal:
+		if msg.Round != round || self.status.Closed {
+			return nil
+		}
+		if _, ok := self.reveals[from]; !ok {
+			self.reveals[from] = msg
+		}
+	default:
+		return fmt.Errorf("unexpected message %T", msg)
+	}
+	return nil
+}
+
+// must be called with the lock held
+func (self *crNode) flag(id enode.ID, reason string) {
+	if self.status.Cheaters == nil {
+		self.status.Cheaters = make(map[string]string)
+	}
+	if _, ok := self.status.Cheaters[id.String()]; !ok {
+		self.status.Cheaters[id.String()] = reason
+	}
+}
+
+// sends the message to all the peers, and takes it in itself
+func (self *crNode) broadcast(msg interface{}) {
+	self.mu.Lock()
+	var peers []*protocols.Peer
+	for _, p := range self.peers {
+		peers = append(peers, p)
+	}
+	self.mu.Unlock()
+	for _, p := range peers {
+		if err := p.Send(context.Background(), msg); err != nil {
+			demo.Log.Warn("send fail", "node", self.name, "peer", p.ID().TerminalString(), "err", err)
+		}
+	}
+	self.handle(self.id, msg)
+}
+
+// waits until the node has the commitment or reveal of the victim, for the copycat
+func (self *crNode) waitFor(have func() bool) {
+	demo.EventuallyWithin(revealTimeout, func() bool {
+		self.mu.Lock()
+		defer self.mu.Unlock()
+		return have()
+	})
+}
+
+// round 1
+func (self *crNode) commit() {
+	hash := commitment(self.value, self.salt, self.id)
+	if self.cheat == copycat {
+		self.waitFor(func() bool {
+			_, ok := self.commits[self.victim]
+			return ok
+		})
+		self.mu.Lock()
+		hash = self.commits[self.victim]
+		self.mu.Unlock()
+	}
+	self.broadcast(&Commit{Round: round, Hash: hash})
+}
+
+// round 2, and the round closes after the reveal timeout
+func (self *crNode) reveal() {
+	self.mu.Lock()
+	self.revealed = true
+	self.mu.Unlock()
+	go func() {
+		time.Sleep(revealTimeout)
+		self.close()
+	}()
+
+	reveal := &Reveal{Round: round, Value: self.value, Salt: self.salt}
+	switch self.cheat {
+	case withhold:
+		return
+	case equivocate:
+		// a value that makes the outcome what it likes, once it saw the values of the others
+		self.waitFor(func() bool { return len(self.reveals) >= nodeCount-2 })
+		reveal.Value = self.value ^ 0xff
+	case copycat:
+		self.waitFor(func() bool { return self.reveals[self.victim] != nil })
+		self.mu.Lock()
+		if r := self.reveals[self.victim]; r != nil {
+			reveal.Value, reveal.Salt = r.Value, r.Salt
+		}
+		self.mu.Unlock()
+	}
+	self.broadcast(reveal)
+}
+
+// checks the reveals against the commitments, and takes the outcome from those that match
+func (self *crNode) close() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for id, hash := range self.commits {
+		r, ok := self.reveals[id]
+		if !ok {
+			self.flag(id, reasonNoReveal)
+		} else if commitment(r.Value, r.Salt, id) != hash {
+			self.flag(id, reasonMismatch)
+		}
+	}
+	for id := range self.reveals {
+		if _, ok := self.commits[id]; !ok {
+			self.flag(id, reasonNoCommit)
+		}
+	}
+	var outcome uint64
+	for id, r := range self.reveals {
+		if _, cheated := self.status.Cheaters[id.String()]; !cheated {
+			outcome ^= r.Value
+		}
+	}
+	self.status.Outcome = hexutil.Uint64(outcome)
+	self.status.Closed = true
+	close(self.closedC)
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	var mu sync.Mutex
+	nodes := make(map[enode.ID]*crNode)
+	adapter := adapters.NewSimAdapter(map[string]adapters.ServiceFunc{
+		"commitreveal": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			n := newNode(ctx.Config.Name, ctx.Config.ID)
+			mu.Lock()
+			nodes[ctx.Config.ID] = n
+			mu.Unlock()
+			return n, nil
+		},
+	})
+	net := simulations.NewNetwork(adapter, &simulations.NetworkConfig{
+		DefaultService: "commitreveal",
+	})
+	defer net.Shutdown()
+
+	var ids []enode.ID
+	for i := 0; i < nodeCount; i++ {
+		cfg := adapters.RandomNodeConfig()
+		cfg.Name = fmt.Sprintf("node%d", i)
+		n, err := net.NewNodeWithConfig(cfg)
+		if err != nil {
+			demo.Log.Crit("new node fail", "err", err)
+		}
+		if err := net.Start(n.ID()); err != nil {
+			demo.Log.Crit("start node fail", "err", err)
+		}
+		ids = append(ids, n.ID())
+	}
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			if err := net.Connect(ids[i], ids[j]); err != nil {
+				demo.Log.Crit("connect fail", "err", err)
+			}
+		}
+	}
+	err := demo.EventuallyWithin(time.Second*5, func() bool {
+		for _, id := range ids {
+			nodes[id].mu.Lock()
+			peers := len(nodes[id].peers)
+			nodes[id].mu.Unlock()
+			if peers < nodeCount-1 {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		demo.Log.Crit("nodes did not connect", "err", err)
+	}
+
+	// the last three cheat, each in its own way
+	nodes[ids[3]].cheat = equivocate
+	nodes[ids[4]].cheat = withhold
+	nodes[ids[5]].cheat = copycat
+	nodes[ids[5]].victim = ids[0]
+	cheaters := map[enode.ID]string{
+		ids[3]: reasonMismatch,
+		ids[4]: reasonNoReveal,
+		ids[5]: reasonMismatch,
+	}
+	var outcome uint64
+	for _, id := range ids {
+		if _, ok := cheaters[id]; !ok {
+			outcome ^= nodes[id].value
+		}
+	}
+
+	status := func(id enode.ID) (*Status, error) {
+		client, err := net.GetNode(id).Client()
+		if err != nil {
+			return nil, err
+		}
+		var st Status
+		err = client.Call(&st, "commitreveal_status")
+		return &st, err
+	}
+	sim := simulations.NewSimulation(net)
+	run := func(desc string, action func(enode.ID), done func(enode.ID) <-chan struct{}, check func(enode.ID, *Status) bool) {
+		trigger := make(chan enode.ID)
+		ctx, cancel := context.WithTimeout(context.Background(), stepTimeout)
+		defer cancel()
+		result := sim.Run(ctx, &simulations.Step{
+			// the node triggers its check when it's done with the step
+			Action: func(ctx context.Context) error {
+				for _, id := range ids {
+					go action(id)
+					go func(id enode.ID) {
+						select {
+						case <-done(id):
+						case <-ctx.Done():
+							return
+						}
+						select {
+						case trigger <- id:
+						case <-ctx.Done():
+						}
+					}(id)
+				}
+				return nil
+			},
+			Trigger: trigger,
+			Expect: &simulations.Expectation{
+				Nodes: ids,
+				Check: func(ctx context.Context, id enode.ID) (bool, error) {
+					st, err := status(id)
+					if err != nil {
+						return false, err
+					}
+					return check(id, st), nil
+				},
+			},
+		})
+		if result.Error != nil {
+			demo.Log.Crit("step fail", "step", desc, "err", result.Error, "passed", len(result.Passes))
+		}
+		fmt.Printf("%s: all %d nodes passed in %v\n", desc, len(result.Passes), result.FinishedAt.Sub(result.StartedAt).Round(time.Millisecond))
+		demo.RunReport.Duration(desc, result.FinishedAt.Sub(result.StartedAt))
+	}
+
+	// round 1 is done when every node has a commitment of every node
+	run("commit", func(id enode.ID) {
+		nodes[id].commit()
+	}, func(id enode.ID) <-chan struct{} {
+		doneC := make(chan struct{})
+		go func() {
+			nodes[id].waitFor(func() bool { return len(nodes[id].commits) == nodeCount })
+			close(doneC)
+		}()
+		return doneC
+	}, func(id enode.ID, st *Status) bool {
+		return st.Commits == nodeCount
+	})
+
+	// round 2 when every node closed the round, took the same for cheaters, and has the same outcome
+	run("reveal", func(id enode.ID) {
+		nodes[id].reveal()
+	}, func(id enode.ID) <-chan struct{} {
+		return nodes[id].closedC
+	}, func(id enode.ID, st *Status) bool {
+		if !st.Closed || uint64(st.Outcome) != outcome || len(st.Cheaters) != len(cheaters) {
+			demo.Log.Warn("unexpected status", "node", nodes[id].name, "status", st)
+			return false
+		}
+		for cheater, reason := range cheaters {
+			if st.Cheaters[cheater.String()] != reason {
+				demo.Log.Warn("cheater not found", "node", nodes[id].name, "cheater", nodes[cheater].name, "got", st.Cheaters[cheater.String()])
+				return false
+			}
+		}
+		return true
+	})
+
+	var names []string
+	for cheater, reason := range cheaters {
+		names = append(names, fmt.Sprintf("%s (%s, %s)", nodes[cheater].name, nodes[cheater].cheat, reason))
+	}
+	sort.Strings(names)
+	fmt.Printf("every node found the cheaters %v\n", names)
+	fmt.Printf("and took the outcome %#x from the other %d\n", outcome, nodeCount-len(cheaters))
+}
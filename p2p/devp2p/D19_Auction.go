@@ -0,0 +1,489 @@
+// a sealed-bid auction, bids over p2p and settled by a contract, which the losers can check was fair
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+
+	"./auction"
+	demo "./common"
+)
+
+const (
+	bidderCount    = 4
+	bidTimeout     = time.Millisecond * 500 // how long the auctioneer takes bids
+	openTimeout    = time.Millisecond * 500 // and openings
+	verdictTimeout = time.Second * 5
+)
+
+// how the auctioneer settles
+type settleMode int
+
+const (
+	settleHonest   settleMode = iota
+	settleFavour              // to the second highest bidder, at its bid
+	settleWithhold            // the same, and leaves the highest opening out of the result so it looks right
+)
+
+// the auctioneer tells of an auction, and the contract it's settled in
+type Announce struct {
+	Auction  common.Hash
+	Item     string
+	Contract common.Address
+}
+
+// the bids the auctioneer took, for the bidders to check theirs is there before they open
+type Close struct {
+	Auction common.Hash
+	Bids    []*auction.Bid
+}
+
+// the openings the auctioneer took, once it settled
+type Result struct {
+	Auction  common.Hash
+	Openings []*auction.Opening
+}
+
+var (
+	auctionProtocol = protocols.Spec{
+		Name:       "auction",
+		Version:    1,
+		MaxMsgSize: 64 * 1024,
+		Messages: []interface{}{
+			&Announce{},
+			&auction.Bid{},
+			&Close{},
+			&auction.Opening{},
+			&Result{},
+		},
+	}
+)
+
+// what a bidder made of an auction
+type verdict struct {
+	bidder string
+	err    error
+	paid   bool
+}
+
+// an auction as the auctioneer runs it, or as a bidder takes part in it
+type round struct {
+	contract common.Address
+	bids     []*auction.Bid
+	openings []*auction.Opening
+
+	// of the bidder
+	bid     *auction.Bid
+	opening *auction.Opening
+}
+
+type auctionNode struct {
+	name    string
+	key     *ecdsa.PrivateKey
+	id      enode.ID
+	addr    common.Address
+	backend *backends.SimulatedBackend
+
+	mu       sync.Mutex
+	peers    map[enode.ID]*protocols.Peer
+	rounds   map[common.Hash]*round
+	amounts  map[string]int64 // a bidder's bid on each item
+	verdictC chan<- *verdict  // where a bidder tells what it made of an auction
+}
+
+func newNode(name string) *auctionNode {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+	return &auctionNode{
+		name:    name,
+		key:     key,
+		id:      enode.PubkeyToIDV4(&key.PublicKey),
+		addr:    crypto.PubkeyToAddress(key.PublicKey),
+		peers:   make(map[enode.ID]*protocols.Peer),
+		rounds:  make(map[common.Hash]*round),
+		amounts: make(map[string]int64),
+	}
+}
+
+func (self *auctionNode) round(id common.Hash) *round {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.rounds[id]
+}
+
+func (self *auctionNode) broadcast(msg interface{}) {
+	self.mu.Lock()
+	var peers []*protocols.Peer
+	for _, p := range self.peers {
+		peers = append(peers, p)
+	}
+	self.mu.Unlock()
+	for _, p := range peers {
+		if err := p.Send(context.Background(), msg); err != nil {
+			demo.Log.Warn("send fail", "node", self.name, "peer", p.ID().TerminalString(), "err", err)
+		}
+	}
+}
+
+func (self *auctionNode) handle(p *protocols.Peer) func(context.Context, interface{}) error {
+	bidder := crypto.PubkeyToAddress(*p.Node().Pubkey())
+	return func(ctx context.Context, msg interface{}) error {
+		switch msg := msg.(type) {
+
+		// the auctioneer takes a bid of each bidder while the auction is open, signed by the key of the peer that sent it
+		case *auction.Bid:
+			if msg.Bidder != bidder {
+				return fmt.Errorf("bid of %x from %x", msg.Bidder, bidder)
+			}
+			if err := msg.Verify(); err != nil {
+				return err
+			}
+			self.mu.Lock()
+			defer self.mu.Unlock()
+			r, ok := self.rounds[msg.Auction]
+			if !ok || r.bids == nil {
+				return nil
+			}
+			for _, b := range r.bids {
+				if b.Bidder == bidder {
+					return nil
+				}
+			}
+			r.bids = append(r.bids, msg)
+
+		// and the openings of those bids
+		case *auction.Opening:
+			if msg.Bidder != bidder {
+				return fmt.Errorf("opening of %x from %x", msg.Bidder, bidder)
+			}
+			self.mu.Lock()
+			defer self.mu.Unlock()
+			r, ok := self.rounds[msg.Auction]
+			if !ok || r.openings == nil {
+				return nil
+			}
+			r.openings = append(r.openings, msg)
+
+		// a bidder bids what it wants to pay for the item
+		case *Announce:
+			self.mu.Lock()
+			amount := self.amounts[msg.Item]
+			self.mu.Unlock()
+			b, o, err := auction.NewBid(msg.Auction, big.NewInt(amount), self.key)
+			if err != nil {
+				return err
+			}
+			self.mu.Lock()
+			self.rounds[msg.Auction] = &round{contract: msg.Contract, bid: b, opening: o}
+			self.mu.Unlock()
+			go p.Send(context.Background(), b)
+
+		// and opens it if the auctioneer has it among the others
+		case *Close:
+			r := self.round(msg.Auction)
+			if r == nil {
+				return nil
+			}
+			if err := auction.CheckBids(msg.Auction, msg.Bids, r.bid); err != nil {
+				self.verdictC <- &verdict{bidder: self.name, err: err}
+				return nil
+			}
+			self.mu.Lock()
+			r.bids = msg.Bids
+			self.mu.Unlock()
+			go p.Send(context.Background(), r.opening)
+
+		// then checks the outcome against what was settled, and pays if it won
+		case *Result:
+			r := self.round(msg.Auction)
+			if r == nil {
+				return nil
+			}
+			self.mu.Lock()
+			r.openings = msg.Openings
+			self.mu.Unlock()
+			go func() {
+				self.verdictC <- self.check(msg.Auction, r)
+			}()
+
+		default:
+			return fmt.Errorf("unexpected message %T", msg)
+		}
+		return nil
+	}
+}
+
+// a bidder checks the outcome, whoever won, and pays the price when it's the winner of a fair auction
+func (self *auctionNode) check(id common.Hash, r *round) *verdict {
+	v := &verdict{bidder: self.name}
+	contract, err := auction.NewSettlement(r.contract, self.backend)
+	if err != nil {
+		v.err = err
+		return v
+	}
+	settled, err := auction.ReadSettled(&contract.SettlementCaller, id)
+	if err != nil {
+		v.err = err
+		return v
+	}
+	self.mu.Lock()
+	v.err = auction.CheckOutcome(id, r.bids, r.openings, r.opening, settled)
+	self.mu.Unlock()
+	if v.err != nil || settled.Winner != self.addr {
+		return v
+	}
+	opts := bind.NewKeyedTransactor(self.key)
+	opts.Value = settled.Price
+	if _, err := contract.Pay(opts, id); err != nil {
+		v.err = err
+		return v
+	}
+	self.backend.Commit()
+	v.paid = true
+	return v
+}
+
+// the auctioneer takes the bids, publishes them, takes the openings and settles on the highest, or doesn't
+func (self *auctionNode) run(contract *auction.Settlement, contractAddr common.Address, item string, mode settleMode) (common.Hash, *auction.Opening, error) {
+	id := crypto.Keccak256Hash([]byte(item), self.addr[:])
+	r := &round{contract: contractAddr, bids: []*auction.Bid{}}
+	self.mu.Lock()
+	self.rounds[id] = r
+	self.mu.Unlock()
+
+	self.broadcast(&Announce{Auction: id, Item: item, Contract: contractAddr})
+	time.Sleep(bidTimeout)
+	self.mu.Lock()
+	bids := r.bids
+	r.openings = []*auction.Opening{}
+	self.mu.Unlock()
+	self.broadcast(&Close{Auction: id, Bids: bids})
+	time.Sleep(openTimeout)
+	self.mu.Lock()
+	openings := r.openings
+	self.mu.Unlock()
+
+	winner := auction.Winner(bids, openings)
+	if winner == nil {
+		return id, nil, fmt.Errorf("no bid opened")
+	}
+	if mode != settleHonest {
+		// the highest bid left out, and the next one wins
+		var rest []*auction.Opening
+		for _, o := range openings {
+			if o != winner {
+				rest = append(rest, o)
+			}
+		}
+		winner = auction.Winner(bids, rest)
+		if mode == settleWithhold {
+			openings = rest
+		}
+	}
+	if _, err := contract.Settle(bind.NewKeyedTransactor(self.key), id, auction.Root(bids), winner.Bidder, winner.Amount); err != nil {
+		return id, nil, err
+	}
+	self.backend.Commit()
+	self.broadcast(&Result{Auction: id, Openings: openings})
+	return id, winner, nil
+}
+
+func (self *auctionNode) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    auctionProtocol.Name,
+		Version: auctionProtocol.Version,
+		Length:  auctionProtocol.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			pp := protocols.NewPeer(p, rw, &auctionProtocol)
+			self.mu.Lock()
+			self.peers[p.ID()] = pp
+			self.mu.Unlock()
+			defer func() {
+				self.mu.Lock()
+				delete(self.peers, p.ID())
+				self.mu.Unlock()
+			}()
+			return pp.Run(self.handle(pp))
+		},
+	}
+}
+
+func newServer(n *auctionNode, port int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey:  n.key,
+		Name:        common.MakeName(n.name, "1"),
+		MaxPeers:    bidderCount,
+		NoDiscovery: true,
+		Protocols:   []p2p.Protocol{n.protocol()},
+		ListenAddr:  fmt.Sprintf(":%d", port),
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// the auctioneer is connected to the bidders, and the keys of all their nodes are funded on a simulated chain
+	verdictC := make(chan *verdict, bidderCount)
+	auctioneer := newNode("auctioneer")
+	var bidders []*auctionNode
+	for i := 0; i < bidderCount; i++ {
+		b := newNode(fmt.Sprintf("bidder%d", i))
+		b.verdictC = verdictC
+		bidders = append(bidders, b)
+	}
+	alloc := core.GenesisAlloc{}
+	for _, n := range append([]*auctionNode{auctioneer}, bidders...) {
+		alloc[n.addr] = core.GenesisAccount{Balance: big.NewInt(1000000000000000000)}
+	}
+	backend := backends.NewSimulatedBackend(alloc, 10000000)
+	for _, n := range append([]*auctionNode{auctioneer}, bidders...) {
+		n.backend = backend
+	}
+	contractAddr, _, contract, err := auction.DeploySettlement(bind.NewKeyedTransactor(auctioneer.key), backend)
+	if err != nil {
+		demo.Log.Crit("deploy fail", "err", err)
+	}
+	backend.Commit()
+
+	var servers []*p2p.Server
+	for i, n := range append([]*auctionNode{auctioneer}, bidders...) {
+		srv := newServer(n, demo.Conf.P2PPort+i)
+		if err := srv.Start(); err != nil {
+			demo.Log.Crit("Start p2p.Server failed", "node", n.name, "err", err)
+		}
+		defer srv.Stop()
+		servers = append(servers, srv)
+	}
+	for _, srv := range servers[1:] {
+		servers[0].AddPeer(srv.Self())
+	}
+	err = demo.EventuallyWithin(time.Second*5, func() bool {
+		auctioneer.mu.Lock()
+		defer auctioneer.mu.Unlock()
+		return len(auctioneer.peers) == bidderCount
+	})
+	if err != nil {
+		demo.Log.Crit("timed out connecting the nodes")
+	}
+
+	names := make(map[common.Address]string)
+	for _, b := range bidders {
+		names[b.addr] = b.name
+	}
+	for _, tt := range []struct {
+		item    string
+		mode    settleMode
+		desc    string
+		amounts []int64
+	}{
+		{"a painting", settleHonest, "honest", []int64{300, 500, 450, 100}},
+		{"a vase", settleFavour, "settled to the second highest", []int64{200, 150, 250, 240}},
+		{"a clock", settleWithhold, "settled to the second highest, the highest opening left out", []int64{700, 650, 100, 300}},
+	} {
+		for i, b := range bidders {
+			b.mu.Lock()
+			b.amounts[tt.item] = tt.amounts[i]
+			b.mu.Unlock()
+		}
+		id, winner, err := auctioneer.run(contract, contractAddr, tt.item, tt.mode)
+		if err != nil {
+			demo.Log.Crit("auction fail", "item", tt.item, "err", err)
+		}
+		fmt.Printf("%s, %s: %s won with %v\n", tt.item, tt.desc, names[winner.Bidder], winner.Amount)
+
+		// every bidder checks the outcome
+		verdicts := make(map[string]*verdict)
+		for len(verdicts) < bidderCount {
+			select {
+			case v := <-verdictC:
+				verdicts[v.bidder] = v
+			case <-time.After(verdictTimeout):
+				demo.Log.Crit("verdicts missing", "item", tt.item, "got", len(verdicts))
+			}
+		}
+		var paid bool
+		for _, b := range bidders {
+			v := verdicts[b.name]
+			switch {
+			case v.err != nil:
+				fmt.Printf("  %s: %v\n", b.name, v.err)
+			case v.paid:
+				paid = true
+				fmt.Printf("  %s: fair, won and paid\n", b.name)
+			default:
+				fmt.Printf("  %s: fair\n", b.name)
+			}
+		}
+
+		switch tt.mode {
+		case settleHonest:
+			for _, v := range verdicts {
+				if v.err != nil {
+					demo.Log.Crit("honest auction taken for unfair", "bidder", v.bidder, "err", v.err)
+				}
+			}
+			if !paid {
+				demo.Log.Crit("winner didn't pay")
+			}
+			// the payment went through the contract to the auctioneer
+			it, err := contract.FilterPaid(&bind.FilterOpts{}, [][32]byte{id}, nil)
+			if err != nil {
+				demo.Log.Crit("filter fail", "err", err)
+			}
+			if !it.Next() {
+				demo.Log.Crit("no payment logged", "err", it.Error())
+			}
+			fmt.Printf("  the auctioneer got %v from %s through the contract\n", it.Event.Amount, names[it.Event.Winner])
+		case settleFavour:
+			// the openings show a higher bid than the winner's, every bidder can tell
+			for _, v := range verdicts {
+				if v.err != auction.ErrWinner {
+					demo.Log.Crit("favour not found", "bidder", v.bidder, "err", v.err)
+				}
+			}
+		case settleWithhold:
+			// only the bidder whose opening is missing can tell, and it can show anyone its opening is of a bid under the root settled
+			var left *auctionNode
+			for _, b := range bidders {
+				if verdicts[b.name].err == auction.ErrOpeningLeft {
+					left = b
+				}
+			}
+			if left == nil {
+				demo.Log.Crit("withheld opening not found")
+			}
+			r := left.round(id)
+			settled, err := auction.ReadSettled(&contract.SettlementCaller, id)
+			if err != nil {
+				demo.Log.Crit("read settled fail", "err", err)
+			}
+			left.mu.Lock()
+			openings := append(append([]*auction.Opening{}, r.openings...), r.opening)
+			left.mu.Unlock()
+			err = auction.CheckOutcome(id, r.bids, openings, nil, settled)
+			fmt.Printf("  with the opening of %s the outcome is: %v\n", left.name, err)
+			if err != auction.ErrWinner {
+				demo.Log.Crit("withheld opening doesn't show the cheat", "err", err)
+			}
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/p2p"
@@ -13,33 +14,49 @@ import (
 	demo "./common"
 )
 
-var (
-	messageW = &sync.WaitGroup{}
-)
-
-type FooMsg struct {
-	V uint
-}
-
 // using the protocols abstraction, message structures are registered and their message codes handled automatically
+// the message is in the common package, where it also has a protobuf encoding for -codec protobuf
 var (
 	fooProtocol = protocols.Spec{
 		Name:       demo.FooProtocolName,
 		Version:    demo.FooProtocolVersion,
 		MaxMsgSize: demo.FooProtocolMaxMsgSize,
 		Messages: []interface{}{
-			&FooMsg{},
+			&demo.FooMsg{},
 		},
 	}
 )
 
+// with -enable accounting every message costs its sender a unit per byte, and the balances with the peers are kept
+// the protocols abstraction calls the hook of the spec for every message sent and received
+type fooAccounting struct {
+	mu       sync.Mutex
+	balances map[string]int64 // peer id -> what the peer owes us, less what we owe it
+}
+
+func (self *fooAccounting) Send(p *protocols.Peer, size uint32, msg interface{}) error {
+	self.add(p, -int64(size))
+	return nil
+}
+
+func (self *fooAccounting) Receive(p *protocols.Peer, size uint32, msg interface{}) error {
+	self.add(p, int64(size))
+	return nil
+}
+
+func (self *fooAccounting) add(p *protocols.Peer, amount int64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.balances[p.ID().TerminalString()] += amount
+}
+
 // the protocols abstraction enables use of an external handler function
 type fooHandler struct {
 	peer *p2p.Peer
 }
 
 func (self *fooHandler) handle(_ context.Context, msg interface{}) error {
-	foomsg, ok := msg.(*FooMsg)
+	foomsg, ok := msg.(*demo.FooMsg)
 	if !ok {
 		return fmt.Errorf("invalid message", "msg", msg, "peer", self.peer)
 	}
@@ -59,7 +76,7 @@ var (
 			pp := protocols.NewPeer(p, rw, &fooProtocol)
 
 			// send the message
-			outmsg := &FooMsg{
+			outmsg := &demo.FooMsg{
 				V: 42,
 			}
 
@@ -83,6 +100,14 @@ var (
 )
 
 func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	var accounting *fooAccounting
+	if demo.Enabled("accounting") {
+		accounting = &fooAccounting{balances: make(map[string]int64)}
+		fooProtocol.Hook = accounting
+	}
 
 	// we need private keys for both servers
 	privkey_one, err := crypto.GenerateKey()
@@ -108,9 +133,10 @@ func main() {
 	}
 
 	// set up the event subscriptions on both servers
+	// the message notifications of both go to the same channel
+	msgC := make(chan *p2p.PeerEvent, 2)
 	eventOneC := make(chan *p2p.PeerEvent)
 	sub_one := srv_one.SubscribeEvents(eventOneC)
-	messageW.Add(1)
 	go func() {
 		for {
 			select {
@@ -119,7 +145,7 @@ func main() {
 					demo.Log.Debug("Received peer add notification on node #1", "peer", peerevent.Peer)
 				} else if peerevent.Type == "msgrecv" {
 					demo.Log.Info("Received message nofification on node #1", "event", peerevent)
-					messageW.Done()
+					msgC <- peerevent
 				}
 			case <-sub_one.Err():
 				return
@@ -129,7 +155,6 @@ func main() {
 
 	eventTwoC := make(chan *p2p.PeerEvent)
 	sub_two := srv_two.SubscribeEvents(eventTwoC)
-	messageW.Add(1)
 	go func() {
 		for {
 			select {
@@ -138,7 +163,7 @@ func main() {
 					demo.Log.Debug("Received peer add notification on node #2", "peer", peerevent.Peer)
 				} else if peerevent.Type == "msgrecv" {
 					demo.Log.Info("Received message nofification on node #2", "event", peerevent)
-					messageW.Done()
+					msgC <- peerevent
 				}
 			case <-sub_two.Err():
 				return
@@ -154,7 +179,11 @@ func main() {
 	srv_one.AddPeer(node_two)
 
 	// wait for each respective message to be delivered on both sides
-	messageW.Wait()
+	for i := 0; i < 2; i++ {
+		if _, err := demo.ExpectMsg(msgC, nil, time.Second); err != nil {
+			demo.Log.Crit("message fail", "err", err)
+		}
+	}
 
 	// terminate subscription loops and unsubscribe
 	sub_one.Unsubscribe()
@@ -163,4 +192,12 @@ func main() {
 	// stop the servers
 	srv_one.Stop()
 	srv_two.Stop()
+
+	if accounting != nil {
+		accounting.mu.Lock()
+		for peer, balance := range accounting.balances {
+			demo.Log.Info("balance", "peer", peer, "units", balance)
+		}
+		accounting.mu.Unlock()
+	}
 }
@@ -3,7 +3,6 @@ package main
 
 import (
 	// "fmt"
-	"os"
 
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p"
@@ -98,10 +97,12 @@ func (api *BarAPI) Set(n int) error {
 }
 
 func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
 
 	var sharedvalue int
 
-	stack, err := demo.NewServiceNode(demo.P2pPort, 0, 0)
+	stack, err := demo.NewServiceNode(demo.Conf.P2PPort, 0, 0)
 
 	// register two separate services
 	foosvc := func(ctx *node.ServiceContext) (node.Service, error) {
@@ -119,7 +120,7 @@ func main() {
 	if err != nil {
 		demo.Log.Crit("Register barservice in servicenode failed", "err", err)
 	}
-	defer os.RemoveAll(stack.DataDir())
+	defer demo.RemoveDataDir(stack.DataDir())
 
 	// start the node
 	err = stack.Start()
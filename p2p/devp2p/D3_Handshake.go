@@ -0,0 +1,301 @@
+// application level handshake with capability exchange
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+
+	demo "./common"
+)
+
+const (
+	handshakeTimeout = time.Second
+)
+
+// the handshake message is the first message sent on a new connection
+// it tells the remote peer what we can do and what we expect
+type FooHandshake struct {
+	AppVersion uint
+	Features   []string
+	MaxSize    uint32
+}
+
+type FooMsg struct {
+	V    uint
+	Data []byte
+}
+
+// the handshake message must be part of the protocol spec like any other message
+var (
+	fooProtocol = protocols.Spec{
+		Name:       demo.FooProtocolName,
+		Version:    demo.FooProtocolVersion,
+		MaxMsgSize: demo.FooProtocolMaxMsgSize,
+		Messages: []interface{}{
+			&FooHandshake{},
+			&FooMsg{},
+		},
+	}
+)
+
+// reports the outcome of a handshake back to main
+type handshakeResult struct {
+	node string
+	peer *p2p.Peer
+	pp   *protocols.Peer // to send on the session, when the handshake went through
+	err  error
+}
+
+var (
+	resultC = make(chan handshakeResult, 8)
+)
+
+// checks the handshake received from the remote against what we require
+func verifyHandshake(local *FooHandshake, required []string) func(interface{}) error {
+	return func(msg interface{}) error {
+		remote, ok := msg.(*FooHandshake)
+		if !ok {
+			return fmt.Errorf("expected handshake, got %T", msg)
+		}
+		if remote.AppVersion != local.AppVersion {
+			return fmt.Errorf("app version mismatch: ours %d, theirs %d", local.AppVersion, remote.AppVersion)
+		}
+		for _, f := range required {
+			found := false
+			for _, rf := range remote.Features {
+				if f == rf {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("missing required feature '%s'", f)
+			}
+		}
+		if remote.MaxSize == 0 {
+			return fmt.Errorf("remote does not accept any payload")
+		}
+		return nil
+	}
+}
+
+// sits on the connection to the peer, and holds the messages it sends to the size negotiated in the handshake
+// until the handshake is done there's no limit but that of the protocol spec
+type sizeLimitRW struct {
+	p2p.MsgReadWriter
+	maxsize uint32
+}
+
+func (self *sizeLimitRW) ReadMsg() (p2p.Msg, error) {
+	msg, err := self.MsgReadWriter.ReadMsg()
+	if err != nil {
+		return msg, err
+	}
+	if self.maxsize > 0 && msg.Size > self.maxsize {
+		msg.Discard()
+		return p2p.Msg{}, fmt.Errorf("message of %d bytes over the session limit of %d", msg.Size, self.maxsize)
+	}
+	return msg, nil
+}
+
+// create a protocol that performs the handshake before anything else
+func newProtocol(name string, local *FooHandshake, required []string) p2p.Protocol {
+	return p2p.Protocol{
+		Name:    fooProtocol.Name,
+		Version: fooProtocol.Version,
+		Length:  fooProtocol.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+
+			limitrw := &sizeLimitRW{MsgReadWriter: rw}
+			pp := protocols.NewPeer(p, limitrw, &fooProtocol)
+
+			// the handshake must complete within the timeout
+			// a peer that doesn't answer at all is as useless as one that answers wrong
+			ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+			defer cancel()
+			rhs, err := pp.Handshake(ctx, local, verifyHandshake(local, required))
+			if err != nil {
+				resultC <- handshakeResult{
+					node: name,
+					peer: p,
+					err:  err,
+				}
+				// returning from the Run function disconnects the peer
+				demo.Log.Warn("refusing session", "node", name, "peer", p, "err", err)
+				return err
+			}
+
+			// the limits for the session are the smallest of the two sides
+			// a larger message from the peer fails the run, which disconnects it
+			remote := rhs.(*FooHandshake)
+			maxsize := local.MaxSize
+			if remote.MaxSize < maxsize {
+				maxsize = remote.MaxSize
+			}
+			limitrw.maxsize = maxsize
+			demo.Log.Info("handshake ok", "node", name, "peer", p, "features", remote.Features, "maxsize", maxsize)
+			resultC <- handshakeResult{
+				node: name,
+				peer: p,
+				pp:   pp,
+			}
+
+			// from here on the protocol proceeds as normal
+			err = pp.Run(func(ctx context.Context, msg interface{}) error {
+				demo.Log.Info("received message", "node", name, "msg", msg, "peer", p)
+				return nil
+			})
+			demo.Log.Info("session ended", "node", name, "peer", p, "err", err)
+			return err
+		},
+	}
+}
+
+// a peer that speaks the protocol, but never sends a handshake
+func newSilentProtocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    fooProtocol.Name,
+		Version: fooProtocol.Version,
+		Length:  fooProtocol.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				msg.Discard()
+			}
+		},
+	}
+}
+
+// create a server
+func newServer(privkey *ecdsa.PrivateKey, name string, proto p2p.Protocol, port int, maxpeers int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey: privkey,
+		Name:       common.MakeName(name, "42"),
+		MaxPeers:   maxpeers,
+		Protocols:  []p2p.Protocol{proto},
+	}
+	if port > 0 {
+		cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	}
+	srv := &p2p.Server{
+		Config: cfg,
+	}
+	return srv
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// we need private keys for all servers
+	var privkeys []*ecdsa.PrivateKey
+	for i := 0; i < 4; i++ {
+		privkey, err := crypto.GenerateKey()
+		if err != nil {
+			demo.Log.Crit("Generate private key failed", "err", err)
+		}
+		privkeys = append(privkeys, privkey)
+	}
+
+	// the first node requires the "ping" feature from its peers
+	proto_one := newProtocol("one", &FooHandshake{
+		AppVersion: 2,
+		Features:   []string{"ping", "pong"},
+		MaxSize:    512,
+	}, []string{"ping"})
+
+	// the second node is compatible, but accepts smaller payloads
+	proto_two := newProtocol("two", &FooHandshake{
+		AppVersion: 2,
+		Features:   []string{"ping"},
+		MaxSize:    256,
+	}, nil)
+
+	// the third node is running an older version of the application
+	proto_three := newProtocol("three", &FooHandshake{
+		AppVersion: 1,
+		Features:   []string{"ping"},
+		MaxSize:    256,
+	}, nil)
+
+	// the fourth node never answers the handshake
+	proto_four := newSilentProtocol()
+
+	// set up the servers
+	srv_one := newServer(privkeys[0], "one", proto_one, 0, 3)
+	srv_two := newServer(privkeys[1], "two", proto_two, 31234, 1)
+	srv_three := newServer(privkeys[2], "three", proto_three, 31235, 1)
+	srv_four := newServer(privkeys[3], "four", proto_four, 31236, 1)
+	for i, srv := range []*p2p.Server{srv_one, srv_two, srv_three, srv_four} {
+		err := srv.Start()
+		if err != nil {
+			demo.Log.Crit("Start p2p.Server failed", "i", i, "err", err)
+		}
+	}
+
+	// connect all the nodes to the first one
+	srv_one.AddPeer(srv_two.Self())
+	srv_one.AddPeer(srv_three.Self())
+	srv_one.AddPeer(srv_four.Self())
+
+	// there are five handshakes to wait for
+	// node one has three, and nodes two and three have one each
+	// node four is silent, so it doesn't report
+	timeout := time.NewTimer(handshakeTimeout * 5)
+	var session *protocols.Peer
+	for i := 0; i < 5; i++ {
+		select {
+		case r := <-resultC:
+			if r.err != nil {
+				demo.Log.Info("handshake result", "node", r.node, "peer", r.peer.Name(), "result", "refused", "err", r.err)
+			} else {
+				demo.Log.Info("handshake result", "node", r.node, "peer", r.peer.Name(), "result", "accepted")
+				if r.node == "one" {
+					session = r.pp
+				}
+			}
+		case <-timeout.C:
+			demo.Log.Crit("timed out waiting for handshakes")
+		}
+	}
+
+	// only the compatible peer should remain connected
+	err := demo.EventuallyWithin(handshakeTimeout, func() bool {
+		return srv_one.PeerCount() == 1
+	})
+	if err != nil {
+		demo.Log.Crit("refused peers not dropped", "peers", srv_one.PeerCount())
+	}
+	demo.Log.Info("after handshakes", "node one peers", srv_one.Peers())
+
+	// node two takes messages up to the 256 bytes negotiated, although node one would take 512
+	// a larger one is over the limit of the session, and node two drops the peer
+	for _, size := range []int{128, 384} {
+		err := session.Send(context.Background(), &FooMsg{V: uint(size), Data: make([]byte, size)})
+		if err != nil {
+			demo.Log.Crit("send fail", "err", err)
+		}
+	}
+	err = demo.EventuallyWithin(handshakeTimeout, func() bool {
+		return srv_one.PeerCount() == 0
+	})
+	if err != nil {
+		demo.Log.Crit("peer not dropped for a message over the session limit", "peers", srv_one.PeerCount())
+	}
+	demo.Log.Info("after the message over the limit", "node one peers", srv_one.Peers())
+
+	// stop the servers
+	srv_four.Stop()
+	srv_three.Stop()
+	srv_two.Stop()
+	srv_one.Stop()
+}
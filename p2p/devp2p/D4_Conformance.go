@@ -0,0 +1,58 @@
+// conformance checks of the foo protocol, against our own implementation or any other node
+package main
+
+import (
+	"os"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	demo "./common"
+	"./conformance"
+)
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// with -e the checks run against that node, which can be an implementation in any language
+	// otherwise we start a node with the foo service of the examples and check that
+	var dest *enode.Node
+	if len(demo.Conf.Bootnodes) > 0 {
+		var err error
+		dest, err = enode.ParseV4(demo.Conf.Bootnodes[0])
+		if err != nil {
+			demo.Log.Crit("invalid enode", "err", err)
+		}
+	} else {
+		stack, err := demo.NewServiceNode(demo.Conf.P2PPort, 0, 0)
+		if err != nil {
+			demo.Log.Crit("ServiceNode create fail", "err", err)
+		}
+		err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+			return demo.NewFooService(), nil
+		})
+		if err != nil {
+			demo.Log.Crit("Register service in ServiceNode failed", "err", err)
+		}
+		err = stack.Start()
+		if err != nil {
+			demo.Log.Crit("ServiceNode start failed", "err", err)
+		}
+		defer demo.RemoveDataDir(stack.DataDir())
+		defer stack.Stop()
+		dest = stack.Server().Self()
+	}
+
+	// the checks run one after the other, each on a new connection
+	demo.Log.Info("checking node", "enode", dest)
+	suite := conformance.NewSuite(dest)
+	results := conformance.RunTests(suite.AllTests(), os.Stdout)
+
+	failed := conformance.CountFailures(results)
+	if failed > 0 {
+		demo.Log.Error("node does not conform", "failed", failed, "of", len(results))
+		return
+	}
+	demo.Log.Info("node conforms", "checks", len(results))
+}
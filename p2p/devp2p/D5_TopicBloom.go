@@ -0,0 +1,347 @@
+// peers tell each other the topics they want in a bloom filter, and the messages go only where they're wanted
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/whisper/whisperv6"
+
+	demo "./common"
+)
+
+const (
+	nodeCount       = 4
+	msgsPerRound    = 40
+	refreshInterval = time.Second            // how often a node tells its peers its filter, if it changed
+	deliveryDelay   = time.Millisecond * 300 // time for the messages of a round to arrive
+)
+
+var (
+	topicNames = []string{"blocks", "txs", "prices", "weather", "news", "sports", "music", "chess"}
+
+	// what each node wants to hear about at first
+	interests = [][]string{
+		{"blocks", "txs"},
+		{"prices"},
+		{"weather", "news", "sports"},
+		{},
+	}
+)
+
+// a node's filter, sent when it connects and then whenever it changes
+// it's the bloom of whisper: each topic sets 3 of its 512 bits, and the filter of several topics has the bits of each
+// a message may be sent to a peer whose filter has all the bits of its topic
+// other topics may set the same bits, so a peer sometimes gets a message it didn't want, but never misses one it wants
+type InterestMsg struct {
+	Filter []byte
+}
+
+type PublishMsg struct {
+	Topic whisperv6.TopicType
+	Data  []byte
+}
+
+var (
+	topicProtocol = protocols.Spec{
+		Name:       "topicbloom",
+		Version:    1,
+		MaxMsgSize: 1024,
+		Messages: []interface{}{
+			&InterestMsg{},
+			&PublishMsg{},
+		},
+	}
+)
+
+func topic(name string) whisperv6.TopicType {
+	return whisperv6.BytesToTopic(crypto.Keccak256([]byte(name)))
+}
+
+// what a node did in a round
+type stats struct {
+	sent     int // messages sent to peers
+	skipped  int // messages not sent to a peer, because its filter ruled them out
+	wanted   int // messages got on a topic the node wants
+	unwanted int // messages got on a topic the node doesn't want, by a false positive or because the peer had an older filter
+}
+
+type topicPeer struct {
+	*protocols.Peer
+	filter []byte
+}
+
+type topicNode struct {
+	name   string
+	mu     sync.Mutex
+	topics map[whisperv6.TopicType]string
+	dirty  bool // the topics changed since the filter was last sent
+	peers  map[enode.ID]*topicPeer
+	stats  stats
+}
+
+func newTopicNode(name string, names []string) *topicNode {
+	self := &topicNode{
+		name:   name,
+		topics: make(map[whisperv6.TopicType]string),
+		peers:  make(map[enode.ID]*topicPeer),
+	}
+	for _, n := range names {
+		self.topics[topic(n)] = n
+	}
+	return self
+}
+
+func (self *topicNode) filter() []byte {
+	filter := make([]byte, whisperv6.BloomFilterSize)
+	for t := range self.topics {
+		b := whisperv6.TopicToBloom(t)
+		for i := range filter {
+			filter[i] |= b[i]
+		}
+	}
+	return filter
+}
+
+func (self *topicNode) subscribe(name string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.topics[topic(name)] = name
+	self.dirty = true
+}
+
+func (self *topicNode) unsubscribe(name string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	delete(self.topics, topic(name))
+	self.dirty = true
+}
+
+// tells the peers the filter every interval, if the topics changed since the last time
+// changes in between are sent together, so a node changing its mind often doesn't flood its peers
+func (self *topicNode) refresh(quitC chan struct{}) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quitC:
+			return
+		case <-ticker.C:
+		}
+		self.mu.Lock()
+		if !self.dirty {
+			self.mu.Unlock()
+			continue
+		}
+		self.dirty = false
+		msg := &InterestMsg{Filter: self.filter()}
+		var peers []*topicPeer
+		for _, p := range self.peers {
+			peers = append(peers, p)
+		}
+		self.mu.Unlock()
+		for _, p := range peers {
+			if err := p.Send(context.Background(), msg); err != nil {
+				demo.Log.Warn("send filter fail", "node", self.name, "peer", p.ID(), "err", err)
+			}
+		}
+		demo.Log.Info("filter refreshed", "node", self.name, "peers", len(peers))
+	}
+}
+
+// sends the message to the peers whose filter doesn't rule it out
+// a peer that hasn't sent its filter yet gets everything
+func (self *topicNode) publish(msg *PublishMsg) {
+	sample := whisperv6.TopicToBloom(msg.Topic)
+	self.mu.Lock()
+	var peers []*topicPeer
+	for _, p := range self.peers {
+		if p.filter != nil && !whisperv6.BloomFilterMatch(p.filter, sample) {
+			self.stats.skipped++
+			continue
+		}
+		self.stats.sent++
+		peers = append(peers, p)
+	}
+	self.mu.Unlock()
+	for _, p := range peers {
+		if err := p.Send(context.Background(), msg); err != nil {
+			demo.Log.Warn("publish fail", "node", self.name, "peer", p.ID(), "err", err)
+		}
+	}
+}
+
+func (self *topicNode) handle(p *topicPeer) func(context.Context, interface{}) error {
+	return func(ctx context.Context, msg interface{}) error {
+		self.mu.Lock()
+		defer self.mu.Unlock()
+		switch msg := msg.(type) {
+		case *InterestMsg:
+			if len(msg.Filter) != whisperv6.BloomFilterSize {
+				return fmt.Errorf("filter of %d bytes", len(msg.Filter))
+			}
+			p.filter = msg.Filter
+		case *PublishMsg:
+			if _, ok := self.topics[msg.Topic]; ok {
+				self.stats.wanted++
+			} else {
+				self.stats.unwanted++
+			}
+		}
+		return nil
+	}
+}
+
+func (self *topicNode) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    topicProtocol.Name,
+		Version: topicProtocol.Version,
+		Length:  topicProtocol.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			tp := &topicPeer{Peer: protocols.NewPeer(p, rw, &topicProtocol)}
+			self.mu.Lock()
+			self.peers[p.ID()] = tp
+			msg := &InterestMsg{Filter: self.filter()}
+			self.mu.Unlock()
+			defer func() {
+				self.mu.Lock()
+				delete(self.peers, p.ID())
+				self.mu.Unlock()
+			}()
+
+			// the peer learns what we want first thing
+			if err := tp.Send(context.Background(), msg); err != nil {
+				return err
+			}
+			return tp.Run(self.handle(tp))
+		},
+	}
+}
+
+// takes the stats of the round, and starts the next from zero
+func (self *topicNode) take() stats {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	s := self.stats
+	self.stats = stats{}
+	return s
+}
+
+func (self *topicNode) topicNames() []string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	var names []string
+	for _, n := range self.topics {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newServer(privkey *ecdsa.PrivateKey, name string, proto p2p.Protocol, port int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey: privkey,
+		Name:       common.MakeName(name, "1"),
+		MaxPeers:   nodeCount,
+		Protocols:  []p2p.Protocol{proto},
+		ListenAddr: fmt.Sprintf(":%d", port),
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+// every node publishes messages on random topics
+func round(desc string, nodes []*topicNode) {
+	for _, n := range nodes {
+		for i := 0; i < msgsPerRound; i++ {
+			name := topicNames[rand.Intn(len(topicNames))]
+			n.publish(&PublishMsg{
+				Topic: topic(name),
+				Data:  []byte(fmt.Sprintf("%s %d from %s", name, i, n.name)),
+			})
+		}
+	}
+	time.Sleep(deliveryDelay)
+
+	fmt.Printf("\n%s\n%-6s %-28s %-6s %-8s %-8s %s\n", desc, "node", "topics", "sent", "skipped", "wanted", "unwanted")
+	var total stats
+	for _, n := range nodes {
+		s := n.take()
+		fmt.Printf("%-6s %-28s %-6d %-8d %-8d %d\n", n.name, strings.Join(n.topicNames(), ","), s.sent, s.skipped, s.wanted, s.unwanted)
+		total.sent += s.sent
+		total.skipped += s.skipped
+	}
+	fmt.Printf("%d of %d messages not sent\n", total.skipped, total.sent+total.skipped)
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// the nodes, each with its interests
+	var nodes []*topicNode
+	var servers []*p2p.Server
+	for i := 0; i < nodeCount; i++ {
+		privkey, err := crypto.GenerateKey()
+		if err != nil {
+			demo.Log.Crit("Generate private key failed", "err", err)
+		}
+		n := newTopicNode(fmt.Sprintf("%d", i), interests[i])
+		srv := newServer(privkey, n.name, n.protocol(), demo.Conf.P2PPort+i)
+		if err := srv.Start(); err != nil {
+			demo.Log.Crit("Start p2p.Server failed", "i", i, "err", err)
+		}
+		defer srv.Stop()
+		nodes = append(nodes, n)
+		servers = append(servers, srv)
+	}
+	quitC := make(chan struct{})
+	defer close(quitC)
+	for _, n := range nodes {
+		go n.refresh(quitC)
+	}
+
+	// every node connects to every other
+	for i, srv := range servers {
+		for _, other := range servers[i+1:] {
+			srv.AddPeer(other.Self())
+		}
+	}
+	err := demo.EventuallyWithin(time.Second*5, func() bool {
+		ready := true
+		for _, n := range nodes {
+			n.mu.Lock()
+			for _, p := range n.peers {
+				ready = ready && p.filter != nil
+			}
+			ready = ready && len(n.peers) == nodeCount-1
+			n.mu.Unlock()
+		}
+		return ready
+	})
+	if err != nil {
+		demo.Log.Crit("timed out waiting for the peers' filters")
+	}
+
+	round("with the filters of the peers", nodes)
+
+	// node 1 goes from prices to sports
+	// until its next refresh its peers have the old filter, so it gets prices it doesn't want and misses sports
+	nodes[1].unsubscribe("prices")
+	nodes[1].subscribe("sports")
+	round("node 1 changed its topics, its peers don't know yet", nodes)
+
+	time.Sleep(refreshInterval * 2)
+	round("after node 1 refreshed its filter", nodes)
+}
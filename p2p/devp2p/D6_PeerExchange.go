@@ -0,0 +1,361 @@
+// peers find each other without discovery, by telling each other the peers they know
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	demo "./common"
+)
+
+const (
+	nodeCount    = 8
+	maxPeers     = 3                      // for all but the seed
+	seedMaxPeers = nodeCount              // the seed takes everyone, it's how they get in
+	maxPexNodes  = 16                     // the most nodes a list may have
+	pexInterval  = time.Millisecond * 500 // how often a node sends its list, and dials from what it got
+	dialTimeout  = time.Second * 2        // a node not connected by then is given up on
+)
+
+// the list of nodes a peer knows to be good, with the peer's own address first
+// a peer connected inbound is only known by the address it connected from, so its listening address is what it tells us here
+// the list is signed by the peer, so the address it gives for itself can't be one made up by some other node
+type PexMsg struct {
+	Self      string
+	Nodes     []string
+	Signature []byte
+}
+
+var (
+	pexProtocol = protocols.Spec{
+		Name:       "pex",
+		Version:    1,
+		MaxMsgSize: 4096,
+		Messages: []interface{}{
+			&PexMsg{},
+		},
+	}
+
+	errPexSigner = errors.New("pex list not signed by the peer")
+)
+
+func pexHash(self string, nodes []string) ([]byte, error) {
+	b, err := rlp.EncodeToBytes([]interface{}{self, nodes})
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Keccak256(b), nil
+}
+
+func signPex(privkey *ecdsa.PrivateKey, self string, nodes []string) (*PexMsg, error) {
+	hash, err := pexHash(self, nodes)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.Sign(hash, privkey)
+	if err != nil {
+		return nil, err
+	}
+	return &PexMsg{
+		Self:      self,
+		Nodes:     nodes,
+		Signature: sig,
+	}, nil
+}
+
+// checks the list is signed by the key of the peer, and that its own address has that key too
+func verifyPex(msg *PexMsg, peer *enode.Node) (*enode.Node, error) {
+	hash, err := pexHash(msg.Self, msg.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	pubkey, err := crypto.SigToPub(hash, msg.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if enode.PubkeyToIDV4(pubkey) != peer.ID() {
+		return nil, errPexSigner
+	}
+	self, err := enode.ParseV4(msg.Self)
+	if err != nil {
+		return nil, err
+	}
+	if self.ID() != peer.ID() {
+		return nil, fmt.Errorf("pex list gives address of %s for itself", self.ID())
+	}
+	if len(msg.Nodes) > maxPexNodes {
+		return nil, fmt.Errorf("pex list of %d nodes", len(msg.Nodes))
+	}
+	return self, nil
+}
+
+type dial struct {
+	node  *enode.Node
+	since time.Time
+}
+
+type pexNode struct {
+	name     string
+	privkey  *ecdsa.PrivateKey
+	maxPeers int
+	srv      *p2p.Server
+	mu       sync.Mutex
+	peers    map[enode.ID]*protocols.Peer
+	good     map[enode.ID]*enode.Node // nodes we have been connected to, by the address they gave
+	heard    map[enode.ID]*enode.Node // nodes our peers know, we haven't tried yet
+	dialing  map[enode.ID]*dial       // nodes we are dialing
+	failed   map[enode.ID]struct{}    // nodes we couldn't connect to, not tried again
+}
+
+func newPexNode(name string, privkey *ecdsa.PrivateKey, maxPeers int) *pexNode {
+	return &pexNode{
+		name:     name,
+		privkey:  privkey,
+		maxPeers: maxPeers,
+		peers:    make(map[enode.ID]*protocols.Peer),
+		good:     make(map[enode.ID]*enode.Node),
+		heard:    make(map[enode.ID]*enode.Node),
+		dialing:  make(map[enode.ID]*dial),
+		failed:   make(map[enode.ID]struct{}),
+	}
+}
+
+// our list, signed
+// the nodes we are connected to now come first, then the ones we were connected to before
+func (self *pexNode) list() (*PexMsg, error) {
+	self.mu.Lock()
+	var nodes []string
+	for id := range self.peers {
+		if n, ok := self.good[id]; ok && len(nodes) < maxPexNodes {
+			nodes = append(nodes, n.String())
+		}
+	}
+	for id, n := range self.good {
+		if _, ok := self.peers[id]; !ok && len(nodes) < maxPexNodes {
+			nodes = append(nodes, n.String())
+		}
+	}
+	self.mu.Unlock()
+	return signPex(self.privkey, self.srv.Self().String(), nodes)
+}
+
+func (self *pexNode) handle(p *protocols.Peer) func(context.Context, interface{}) error {
+	return func(ctx context.Context, msg interface{}) error {
+		pex, ok := msg.(*PexMsg)
+		if !ok {
+			return fmt.Errorf("unexpected message %T", msg)
+		}
+		peerNode, err := verifyPex(pex, p.Node())
+		if err != nil {
+			return err
+		}
+		self.mu.Lock()
+		defer self.mu.Unlock()
+		self.good[peerNode.ID()] = peerNode
+		delete(self.heard, peerNode.ID())
+		for _, url := range pex.Nodes {
+			n, err := enode.ParseV4(url)
+			if err != nil {
+				demo.Log.Debug("bad node in pex list", "node", self.name, "peer", p.ID(), "url", url, "err", err)
+				continue
+			}
+			if _, ok := self.good[n.ID()]; ok {
+				continue
+			}
+			if _, ok := self.failed[n.ID()]; ok {
+				continue
+			}
+			if n.ID() == self.srv.Self().ID() {
+				continue
+			}
+			self.heard[n.ID()] = n
+		}
+		return nil
+	}
+}
+
+func (self *pexNode) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    pexProtocol.Name,
+		Version: pexProtocol.Version,
+		Length:  pexProtocol.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			pp := protocols.NewPeer(p, rw, &pexProtocol)
+			self.mu.Lock()
+			self.peers[p.ID()] = pp
+			delete(self.dialing, p.ID())
+			self.mu.Unlock()
+			defer func() {
+				self.mu.Lock()
+				delete(self.peers, p.ID())
+				self.mu.Unlock()
+			}()
+
+			// the peer gets our list as soon as it connects, and after that with the others
+			msg, err := self.list()
+			if err != nil {
+				return err
+			}
+			if err := pp.Send(context.Background(), msg); err != nil {
+				return err
+			}
+			return pp.Run(self.handle(pp))
+		},
+	}
+}
+
+// sends our list to the peers, and dials the nodes we heard of while we have room
+func (self *pexNode) run(quitC chan struct{}) {
+	ticker := time.NewTicker(pexInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quitC:
+			return
+		case <-ticker.C:
+		}
+
+		msg, err := self.list()
+		if err != nil {
+			demo.Log.Error("pex list fail", "node", self.name, "err", err)
+			continue
+		}
+		self.mu.Lock()
+		var peers []*protocols.Peer
+		for _, p := range self.peers {
+			peers = append(peers, p)
+		}
+
+		// a node added with AddPeer is dialed again and again until it's removed
+		// so one that hasn't connected in time is removed, and not tried again
+		for id, d := range self.dialing {
+			if time.Since(d.since) > dialTimeout {
+				self.srv.RemovePeer(d.node)
+				delete(self.dialing, id)
+				delete(self.good, id)
+				self.failed[id] = struct{}{}
+			}
+		}
+
+		// nodes dialed by us are let in whatever the number of peers, so we count them ourselves
+		// the nodes we were connected to before come first, then the ones we heard of
+		var candidates []*enode.Node
+		for _, n := range self.good {
+			candidates = append(candidates, n)
+		}
+		for _, n := range self.heard {
+			candidates = append(candidates, n)
+		}
+		for _, n := range candidates {
+			if len(self.peers)+len(self.dialing) >= self.maxPeers {
+				break
+			}
+			if _, ok := self.peers[n.ID()]; ok {
+				continue
+			}
+			if _, ok := self.dialing[n.ID()]; ok {
+				continue
+			}
+			delete(self.heard, n.ID())
+			self.dialing[n.ID()] = &dial{node: n, since: time.Now()}
+			self.srv.AddPeer(n)
+		}
+		self.mu.Unlock()
+
+		for _, p := range peers {
+			if err := p.Send(context.Background(), msg); err != nil {
+				demo.Log.Warn("send pex list fail", "node", self.name, "peer", p.ID(), "err", err)
+			}
+		}
+	}
+}
+
+func newServer(privkey *ecdsa.PrivateKey, name string, maxPeers int, proto p2p.Protocol, port int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey:  privkey,
+		Name:        common.MakeName(name, "1"),
+		MaxPeers:    maxPeers,
+		NoDiscovery: true,
+		Protocols:   []p2p.Protocol{proto},
+		ListenAddr:  fmt.Sprintf(":%d", port),
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+func show(nodes []*pexNode) {
+	fmt.Printf("%-6s %-6s %-6s %s\n", "node", "peers", "known", "connected to")
+	for _, n := range nodes {
+		var names []string
+		for _, p := range n.srv.Peers() {
+			names = append(names, strings.Split(p.Name(), "/")[0])
+		}
+		n.mu.Lock()
+		known := len(n.good) + len(n.heard)
+		n.mu.Unlock()
+		fmt.Printf("%-6s %-6d %-6d %s\n", n.name, len(names), known, strings.Join(names, " "))
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// node 0 is the seed, the only node the others know of when they start
+	// there is no discovery, as in a network where udp is blocked
+	var nodes []*pexNode
+	for i := 0; i < nodeCount; i++ {
+		privkey, err := crypto.GenerateKey()
+		if err != nil {
+			demo.Log.Crit("Generate private key failed", "err", err)
+		}
+		max := maxPeers
+		if i == 0 {
+			max = seedMaxPeers
+		}
+		n := newPexNode(fmt.Sprintf("%d", i), privkey, max)
+		n.srv = newServer(privkey, n.name, max, n.protocol(), demo.Conf.P2PPort+i)
+		if err := n.srv.Start(); err != nil {
+			demo.Log.Crit("Start p2p.Server failed", "i", i, "err", err)
+		}
+		defer n.srv.Stop()
+		nodes = append(nodes, n)
+	}
+	quitC := make(chan struct{})
+	defer close(quitC)
+	for _, n := range nodes {
+		go n.run(quitC)
+	}
+
+	// the seed is a static peer, so it is dialed again if the connection drops
+	seed := nodes[0].srv.Self()
+	for _, n := range nodes[1:] {
+		n.srv.AddPeer(seed)
+	}
+	time.Sleep(pexInterval)
+	fmt.Println("connected to the seed")
+	show(nodes)
+
+	// after a few exchanges the nodes have filled their slots with peers they heard of from the seed and each other
+	time.Sleep(pexInterval * 8)
+	fmt.Println("\nafter exchanging peers")
+	show(nodes)
+
+	// with the seed gone, the nodes still know each other
+	nodes[0].srv.Stop()
+	time.Sleep(pexInterval * 8)
+	fmt.Println("\nwithout the seed")
+	show(nodes[1:])
+}
@@ -0,0 +1,178 @@
+// letting peers in by their id, address and record, with rules changed over rpc while the node runs
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	demo "./common"
+	"./firewall"
+)
+
+const (
+	peerCount = 3
+)
+
+// the foo service, behind the firewall
+// the guard gets the protocols before the server does, and the firewall api is served with the foo api
+type guardedService struct {
+	*demo.FooService
+	guard *firewall.Guard
+}
+
+func (self *guardedService) Protocols() []p2p.Protocol {
+	return self.guard.Protocols(self.FooService.Protocols())
+}
+
+func (self *guardedService) APIs() []rpc.API {
+	return append(self.FooService.APIs(), firewall.APIs(self.guard)...)
+}
+
+func showPeers(stack *node.Node, names map[enode.ID]string, client *rpc.Client) {
+	fmt.Print("peers:")
+	for _, p := range stack.Server().Peers() {
+		fmt.Printf(" %s", names[p.ID()])
+	}
+	var rejected map[enode.ID]string
+	if err := client.Call(&rejected, "firewall_rejected"); err != nil {
+		demo.Log.Crit("rejected fail", "err", err)
+	}
+	fmt.Println("\nrejected:")
+	for id, reason := range rejected {
+		fmt.Printf("  %s: %s\n", names[id], reason)
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// the peers that will connect to the node
+	var peers []*p2p.Server
+	names := make(map[enode.ID]string)
+	for i := 0; i < peerCount; i++ {
+		privkey, err := crypto.GenerateKey()
+		if err != nil {
+			demo.Log.Crit("Generate private key failed", "err", err)
+		}
+		name := fmt.Sprintf("%c", 'a'+i)
+		srv := demo.NewServer(privkey, name, "1", demo.NewFooService().Protocols()[0], demo.Conf.P2PPort+1+i)
+		if err := srv.Start(); err != nil {
+			demo.Log.Crit("Start p2p.Server failed", "err", err)
+		}
+		defer srv.Stop()
+		peers = append(peers, srv)
+		names[srv.Self().ID()] = name
+	}
+
+	// the node starts with a kept out
+	policy, err := firewall.NewPolicy(firewall.Config{
+		DenyIDs: []enode.ID{peers[0].Self().ID()},
+	})
+	if err != nil {
+		demo.Log.Crit("policy fail", "err", err)
+	}
+	stack, err := demo.NewServiceNode(demo.Conf.P2PPort, 0, 0)
+	if err != nil {
+		demo.Log.Crit("ServiceNode create failed", "err", err)
+	}
+	defer demo.RemoveDataDir(stack.DataDir())
+	err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		return &guardedService{
+			FooService: demo.NewFooService(),
+			guard:      firewall.NewGuard(policy),
+		}, nil
+	})
+	if err != nil {
+		demo.Log.Crit("Register service in ServiceNode failed", "err", err)
+	}
+	if err := stack.Start(); err != nil {
+		demo.Log.Crit("ServiceNode start failed", "err", err)
+	}
+	defer stack.Stop()
+
+	// the firewall api is not public, so it's only on the IPC endpoint and in the process
+	client, err := stack.Attach()
+	if err != nil {
+		demo.Log.Crit("attach fail", "err", err)
+	}
+	defer client.Close()
+
+	for _, srv := range peers {
+		srv.AddPeer(stack.Server().Self())
+	}
+	err = demo.EventuallyWithin(time.Second*5, func() bool {
+		var rejected map[enode.ID]string
+		if err := client.Call(&rejected, "firewall_rejected"); err != nil {
+			demo.Log.Crit("rejected fail", "err", err)
+		}
+		return stack.Server().PeerCount() == 2 && len(rejected) == 1
+	})
+	if err != nil {
+		demo.Log.Crit("peers fail", "err", err)
+	}
+	fmt.Println("a denied by id")
+	showPeers(stack, names, client)
+
+	// the peers are all on this machine, so letting in only a net elsewhere drops b and c
+	// the rules apply to the peers already connected as soon as they change
+	var dropped int
+	if err := client.Call(&dropped, "firewall_allowNet", "10.0.0.0/8"); err != nil {
+		demo.Log.Crit("allow net fail", "err", err)
+	}
+	err = demo.EventuallyWithin(time.Second, func() bool {
+		return stack.Server().PeerCount() == 0
+	})
+	if err != nil {
+		demo.Log.Crit("drop fail", "err", err)
+	}
+	fmt.Printf("\nonly 10.0.0.0/8 allowed, %d peers dropped\n", dropped)
+	showPeers(stack, names, client)
+
+	// c is let in by its id, whatever its address
+	// it would be let in when it connects again
+	if err := client.Call(&dropped, "firewall_allowID", peers[2].Self().ID()); err != nil {
+		demo.Log.Crit("allow id fail", "err", err)
+	}
+	var cfg firewall.Config
+	if err := client.Call(&cfg, "firewall_config"); err != nil {
+		demo.Log.Crit("config fail", "err", err)
+	}
+	fmt.Printf("\nc allowed by id\nconfig: %d ids allowed, %d denied, nets allowed %v\n", len(cfg.AllowIDs), len(cfg.DenyIDs), cfg.AllowNets)
+
+	// a field of the record is matched by its rlp encoding, here the ip
+	ip, err := rlp.EncodeToBytes(net.ParseIP("10.0.0.66").To4())
+	if err != nil {
+		demo.Log.Crit("encode fail", "err", err)
+	}
+	if err := client.Call(&dropped, "firewall_denyField", firewall.Field{Key: "ip", Value: ip}); err != nil {
+		demo.Log.Crit("deny field fail", "err", err)
+	}
+	fmt.Println("ip 10.0.0.66 denied by the ip field of the record")
+
+	// what would happen to nodes that aren't there
+	fmt.Println("\nchecks:")
+	for _, n := range []*enode.Node{
+		peers[1].Self(),
+		peers[2].Self(),
+		enode.NewV4(&peers[1].PrivateKey.PublicKey, net.ParseIP("10.0.0.5"), 30303, 30303),
+		enode.NewV4(&peers[1].PrivateKey.PublicKey, net.ParseIP("10.0.0.66"), 30303, 30303),
+	} {
+		var reason string
+		if err := client.Call(&reason, "firewall_check", n.String()); err != nil {
+			demo.Log.Crit("check fail", "err", err)
+		}
+		if reason == "" {
+			reason = "allowed"
+		}
+		fmt.Printf("  %s at %v: %s\n", names[n.ID()], n.IP(), reason)
+	}
+}
@@ -0,0 +1,212 @@
+// a private overlay: only nodes that can prove they know the network secret get past the handshake
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+
+	demo "./common"
+)
+
+const (
+	networkId        = 42
+	nonceLength      = 32
+	handshakeTimeout = time.Second
+)
+
+var (
+	// the secret the nodes of the overlay are provisioned with
+	// it never goes over the wire, only proofs made with it do
+	networkSecret = []byte("correct horse battery staple")
+
+	errNetworkId = errors.New("network id mismatch")
+	errProof     = errors.New("invalid membership proof")
+)
+
+// the first message of the handshake, each side sends the other a fresh challenge
+type ChallengeMsg struct {
+	NetworkId uint64
+	Nonce     []byte
+}
+
+// the second, each side answers the challenge it got
+type ProofMsg struct {
+	Mac []byte
+}
+
+type DataMsg struct {
+	Text string
+}
+
+var (
+	membershipProtocol = protocols.Spec{
+		Name:       "member",
+		Version:    1,
+		MaxMsgSize: 1024,
+		Messages: []interface{}{
+			&ChallengeMsg{},
+			&ProofMsg{},
+			&DataMsg{},
+		},
+	}
+)
+
+// the proof that the prover knows the secret, for the challenge of the verifier
+// the ids of both are in it, so a proof can't be used on any other connection
+// and a peer sending back our own challenge can't send back our own proof either, as prover and verifier are the other way round
+func membershipProof(secret []byte, nonce []byte, prover enode.ID, verifier enode.ID) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("devp2p-demo membership"))
+	mac.Write(nonce)
+	mac.Write(prover[:])
+	mac.Write(verifier[:])
+	return mac.Sum(nil)
+}
+
+type memberNode struct {
+	name   string
+	secret []byte // nil for a node that isn't provisioned
+	netId  uint64
+	srv    *p2p.Server
+}
+
+// the two rounds of the handshake: challenges, then proofs
+// the nonces are fresh for every connection, so a proof seen once is no use later
+func (self *memberNode) handshake(pp *protocols.Peer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+
+	nonce := make([]byte, nonceLength)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	rhs, err := pp.Handshake(ctx, &ChallengeMsg{NetworkId: self.netId, Nonce: nonce}, func(msg interface{}) error {
+		challenge, ok := msg.(*ChallengeMsg)
+		if !ok {
+			return fmt.Errorf("expected challenge, got %T", msg)
+		}
+		if challenge.NetworkId != self.netId {
+			return errNetworkId
+		}
+		if len(challenge.Nonce) != nonceLength {
+			return fmt.Errorf("challenge nonce of %d bytes", len(challenge.Nonce))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	remoteNonce := rhs.(*ChallengeMsg).Nonce
+
+	// a node without the secret can only guess
+	local := self.srv.Self().ID()
+	var mac []byte
+	if self.secret != nil {
+		mac = membershipProof(self.secret, remoteNonce, local, pp.ID())
+	} else {
+		mac = make([]byte, sha256.Size)
+		rand.Read(mac)
+	}
+	_, err = pp.Handshake(ctx, &ProofMsg{Mac: mac}, func(msg interface{}) error {
+		proof, ok := msg.(*ProofMsg)
+		if !ok {
+			return fmt.Errorf("expected proof, got %T", msg)
+		}
+		// hmac.Equal takes as long whatever the bytes, so the time it takes tells nothing of the right proof
+		if !hmac.Equal(proof.Mac, membershipProof(self.secret, nonce, pp.ID(), local)) {
+			return errProof
+		}
+		return nil
+	})
+	return err
+}
+
+func (self *memberNode) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    membershipProtocol.Name,
+		Version: membershipProtocol.Version,
+		Length:  membershipProtocol.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			pp := protocols.NewPeer(p, rw, &membershipProtocol)
+			if err := self.handshake(pp); err != nil {
+				demo.Log.Warn("refusing peer", "node", self.name, "peer", p.Name(), "err", err)
+				return err
+			}
+			demo.Log.Info("member joined", "node", self.name, "peer", p.Name())
+
+			// only members get here, so whatever is sent from now on stays in the overlay
+			go pp.Send(context.Background(), &DataMsg{Text: fmt.Sprintf("hello from %s", self.name)})
+			return pp.Run(func(ctx context.Context, msg interface{}) error {
+				if data, ok := msg.(*DataMsg); ok {
+					demo.Log.Info("received", "node", self.name, "peer", p.Name(), "text", data.Text)
+				}
+				return nil
+			})
+		},
+	}
+}
+
+func newServer(privkey *ecdsa.PrivateKey, name string, proto p2p.Protocol, port int) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey: privkey,
+		Name:       common.MakeName(name, "1"),
+		MaxPeers:   8,
+		Protocols:  []p2p.Protocol{proto},
+		ListenAddr: fmt.Sprintf(":%d", port),
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// one and two are provisioned
+	// three has a secret of another overlay, four has none, and five has the secret but is on another network
+	nodes := []*memberNode{
+		{name: "one", secret: networkSecret, netId: networkId},
+		{name: "two", secret: networkSecret, netId: networkId},
+		{name: "three", secret: []byte("another secret"), netId: networkId},
+		{name: "four", netId: networkId},
+		{name: "five", secret: networkSecret, netId: networkId + 1},
+	}
+	for i, n := range nodes {
+		privkey, err := crypto.GenerateKey()
+		if err != nil {
+			demo.Log.Crit("Generate private key failed", "err", err)
+		}
+		n.srv = newServer(privkey, n.name, n.protocol(), demo.Conf.P2PPort+i)
+		if err := n.srv.Start(); err != nil {
+			demo.Log.Crit("Start p2p.Server failed", "node", n.name, "err", err)
+		}
+		defer n.srv.Stop()
+	}
+
+	// they all connect to one, which has them all as peers on devp2p
+	// only two gets past the handshake
+	for _, n := range nodes[1:] {
+		n.srv.AddPeer(nodes[0].srv.Self())
+	}
+	time.Sleep(handshakeTimeout * 2)
+
+	for _, n := range nodes {
+		var peers []string
+		for _, p := range n.srv.Peers() {
+			peers = append(peers, p.Name())
+		}
+		fmt.Printf("%-6s peers %v\n", n.name, peers)
+	}
+}
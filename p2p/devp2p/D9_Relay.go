@@ -0,0 +1,401 @@
+// two peers that can't dial each other talk through a relay node they can both reach
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+
+	demo "./common"
+	"./envelope"
+)
+
+const (
+	relayWindow   = time.Second
+	relayCapacity = 2048 // bytes each peer may have relayed per window
+	msgCount      = 20
+	msgSize       = 256
+	dialTimeout   = time.Millisecond * 500
+
+	// the messages are stale after this, less than the two windows of waiting the last of them take
+	msgTTL = time.Millisecond * 1200
+)
+
+// asks the relay where a peer is
+type LookupMsg struct {
+	Peer enode.ID
+}
+
+// the relay's answer, with the address it sees the peer's connection come from
+// behind a NAT that's the address of the NAT, and the port it opened for the connection to the relay
+type PeerInfoMsg struct {
+	Peer   enode.ID
+	Online bool
+	Addr   string
+}
+
+// data to be relayed, in an envelope with a ttl
+// to the relay the peer is the one to send it to, from the relay it's the one it came from
+type ForwardMsg struct {
+	Peer enode.ID
+	Seq  uint64
+	Data []byte
+}
+
+// the relay didn't forward a message
+type RefusedMsg struct {
+	Peer   enode.ID
+	Seq    uint64
+	Reason string
+}
+
+var (
+	relayProtocol = protocols.Spec{
+		Name:       "relay",
+		Version:    1,
+		MaxMsgSize: 4096,
+		Messages: []interface{}{
+			&LookupMsg{},
+			&PeerInfoMsg{},
+			&ForwardMsg{},
+			&RefusedMsg{},
+		},
+	}
+)
+
+// what the relay did for a peer
+type relayAccount struct {
+	window   time.Time // when the current window started
+	used     int       // bytes relayed in it
+	relayed  int       // bytes relayed in all
+	messages int
+	refused  int
+	expired  int // stale messages dropped
+}
+
+// the relay node
+// it forwards between the peers connected to it, each up to its capacity
+type relay struct {
+	mu       sync.Mutex
+	peers    map[enode.ID]*protocols.Peer
+	addrs    map[enode.ID]string
+	accounts map[enode.ID]*relayAccount
+}
+
+func newRelay() *relay {
+	return &relay{
+		peers:    make(map[enode.ID]*protocols.Peer),
+		addrs:    make(map[enode.ID]string),
+		accounts: make(map[enode.ID]*relayAccount),
+	}
+}
+
+// the account of the peer
+// must be called with the lock held
+func (self *relay) account(id enode.ID) *relayAccount {
+	acc, ok := self.accounts[id]
+	if !ok {
+		acc = &relayAccount{}
+		self.accounts[id] = acc
+	}
+	return acc
+}
+
+// takes size bytes off the capacity of the peer, if it has them left in the window
+func (self *relay) charge(id enode.ID, size int) bool {
+	acc := self.account(id)
+	if time.Since(acc.window) > relayWindow {
+		acc.window = time.Now()
+		acc.used = 0
+	}
+	if acc.used+size > relayCapacity {
+		acc.refused++
+		return false
+	}
+	acc.used += size
+	acc.relayed += size
+	acc.messages++
+	return true
+}
+
+func (self *relay) handle(p *protocols.Peer) func(context.Context, interface{}) error {
+	return func(ctx context.Context, msg interface{}) error {
+		switch msg := msg.(type) {
+		case *LookupMsg:
+			self.mu.Lock()
+			addr, ok := self.addrs[msg.Peer]
+			self.mu.Unlock()
+			go p.Send(context.Background(), &PeerInfoMsg{Peer: msg.Peer, Online: ok, Addr: addr})
+
+		case *ForwardMsg:
+			self.mu.Lock()
+			to, ok := self.peers[msg.Peer]
+			reason := ""
+			if !ok {
+				reason = "peer not connected"
+			} else if _, err := envelope.Decode(msg.Data); envelope.IsExpired(err) {
+				// the relay reads only the header, to not spend the capacity of the peer on stale messages
+				self.account(p.ID()).expired++
+				reason = "expired"
+			} else if !self.charge(p.ID(), len(msg.Data)) {
+				reason = "over capacity"
+			}
+			self.mu.Unlock()
+			if reason != "" {
+				go p.Send(context.Background(), &RefusedMsg{Peer: msg.Peer, Seq: msg.Seq, Reason: reason})
+				return nil
+			}
+			go to.Send(context.Background(), &ForwardMsg{Peer: p.ID(), Seq: msg.Seq, Data: msg.Data})
+
+		default:
+			return fmt.Errorf("unexpected message %T", msg)
+		}
+		return nil
+	}
+}
+
+func (self *relay) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    relayProtocol.Name,
+		Version: relayProtocol.Version,
+		Length:  relayProtocol.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			pp := protocols.NewPeer(p, rw, &relayProtocol)
+			self.mu.Lock()
+			self.peers[p.ID()] = pp
+			self.addrs[p.ID()] = p.RemoteAddr().String()
+			self.mu.Unlock()
+			defer func() {
+				self.mu.Lock()
+				delete(self.peers, p.ID())
+				delete(self.addrs, p.ID())
+				self.mu.Unlock()
+			}()
+			return pp.Run(self.handle(pp))
+		},
+	}
+}
+
+// a peer behind a NAT
+// it only connects out, to the relay
+type natPeer struct {
+	name    string
+	relay   *protocols.Peer
+	readyC  chan struct{}
+	infoC   chan *PeerInfoMsg
+	refuseC chan *RefusedMsg
+	mu      sync.Mutex
+	got     map[uint64]bool
+	expired int
+}
+
+func newNatPeer(name string) *natPeer {
+	return &natPeer{
+		name:    name,
+		readyC:  make(chan struct{}),
+		infoC:   make(chan *PeerInfoMsg, 1),
+		refuseC: make(chan *RefusedMsg, msgCount),
+		got:     make(map[uint64]bool),
+	}
+}
+
+func (self *natPeer) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    relayProtocol.Name,
+		Version: relayProtocol.Version,
+		Length:  relayProtocol.Length(),
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			self.relay = protocols.NewPeer(p, rw, &relayProtocol)
+			close(self.readyC)
+			return self.relay.Run(func(ctx context.Context, msg interface{}) error {
+				switch msg := msg.(type) {
+				case *PeerInfoMsg:
+					self.infoC <- msg
+				case *RefusedMsg:
+					self.refuseC <- msg
+				case *ForwardMsg:
+					// what went stale after the relay checked it is dropped here
+					var data []byte
+					err := envelope.Unwrap(msg.Data, &data)
+					self.mu.Lock()
+					if envelope.IsExpired(err) {
+						self.expired++
+					} else if err == nil {
+						self.got[msg.Seq] = true
+					}
+					self.mu.Unlock()
+				}
+				return nil
+			})
+		},
+	}
+}
+
+// tries the address the relay sees the peer at
+// behind a real NAT, both peers dialing each other's address at the same time may open the NATs for each other, the hole punch
+// here the peers don't listen at all, so the dial fails as it does when the NATs won't be punched, and the relay it is
+func tryDirect(info *PeerInfoMsg) error {
+	conn, err := net.DialTimeout("tcp", info.Addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return fmt.Errorf("%s answers, but not as the peer", info.Addr)
+}
+
+// sends the messages through the relay
+// the ones refused for capacity are sent again when the window has passed, until they expire
+func (self *natPeer) send(to enode.ID) {
+	pending := make(map[uint64][]byte)
+	for i := uint64(0); i < msgCount; i++ {
+		b, err := envelope.EncodeTTL(envelope.Raw, envelope.CompressNone, make([]byte, msgSize), msgTTL)
+		if err != nil {
+			demo.Log.Crit("envelope fail", "err", err)
+		}
+		pending[i] = b
+	}
+	for round := 1; len(pending) > 0; round++ {
+		for seq, data := range pending {
+			if err := self.relay.Send(context.Background(), &ForwardMsg{Peer: to, Seq: seq, Data: data}); err != nil {
+				demo.Log.Crit("send fail", "err", err)
+			}
+		}
+		sent := len(pending)
+
+		// the relay only answers for the ones it refuses, so what isn't refused in a while went through
+		time.Sleep(time.Millisecond * 200)
+		retry := make(map[uint64][]byte)
+		expired := 0
+	drain:
+		for {
+			select {
+			case r := <-self.refuseC:
+				switch r.Reason {
+				case "over capacity":
+					retry[r.Seq] = pending[r.Seq]
+				case "expired":
+					expired++
+				default:
+					demo.Log.Crit("relay refused", "peer", r.Peer, "reason", r.Reason)
+				}
+			default:
+				break drain
+			}
+		}
+		fmt.Printf("round %d: sent %d, relay refused %d, and dropped %d expired\n", round, sent, len(retry), expired)
+		pending = retry
+		if len(pending) > 0 {
+			time.Sleep(relayWindow)
+		}
+	}
+}
+
+func (self *natPeer) received() (int, int) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return len(self.got), self.expired
+}
+
+func newServer(privkey *ecdsa.PrivateKey, name string, proto p2p.Protocol, listen string) *p2p.Server {
+	cfg := p2p.Config{
+		PrivateKey:  privkey,
+		Name:        common.MakeName(name, "1"),
+		MaxPeers:    8,
+		NoDiscovery: true,
+		Protocols:   []p2p.Protocol{proto},
+		ListenAddr:  listen,
+	}
+	return &p2p.Server{
+		Config: cfg,
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// the relay listens on a public address
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("Generate private key failed", "err", err)
+	}
+	r := newRelay()
+	relaySrv := newServer(privkey, "relay", r.protocol(), fmt.Sprintf(":%d", demo.Conf.P2PPort))
+	if err := relaySrv.Start(); err != nil {
+		demo.Log.Crit("Start p2p.Server failed", "err", err)
+	}
+	defer relaySrv.Stop()
+
+	// the peers don't listen, nobody can dial them, as if they were behind NATs
+	var peers []*natPeer
+	var servers []*p2p.Server
+	for _, name := range []string{"alice", "bob"} {
+		privkey, err := crypto.GenerateKey()
+		if err != nil {
+			demo.Log.Crit("Generate private key failed", "err", err)
+		}
+		n := newNatPeer(name)
+		srv := newServer(privkey, name, n.protocol(), "")
+		if err := srv.Start(); err != nil {
+			demo.Log.Crit("Start p2p.Server failed", "err", err)
+		}
+		defer srv.Stop()
+		srv.AddPeer(relaySrv.Self())
+		peers = append(peers, n)
+		servers = append(servers, srv)
+	}
+	for _, n := range peers {
+		select {
+		case <-n.readyC:
+		case <-time.After(time.Second * 5):
+			demo.Log.Crit("timed out connecting to the relay", "peer", n.name)
+		}
+	}
+	alice, bob := peers[0], peers[1]
+	bobId := servers[1].Self().ID()
+
+	// alice asks the relay where bob is, and tries to reach him there first
+	// bob may not be through to the relay yet, so she asks until he is
+	var info *PeerInfoMsg
+	for i := 0; i < 10; i++ {
+		alice.relay.Send(context.Background(), &LookupMsg{Peer: bobId})
+		info = <-alice.infoC
+		if info.Online {
+			break
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if !info.Online {
+		demo.Log.Crit("bob not on the relay")
+	}
+	fmt.Printf("relay sees bob at %s\n", info.Addr)
+	if err := tryDirect(info); err != nil {
+		fmt.Printf("direct connection failed: %v\nrelaying\n", err)
+	}
+
+	// alice sends more than the relay takes from her in a window
+	alice.send(bobId)
+	time.Sleep(time.Millisecond * 200)
+	got, expired := bob.received()
+	fmt.Printf("bob got %d of %d messages, and dropped %d expired\n", got, msgCount, expired)
+
+	// what the relay did for each peer
+	fmt.Printf("\n%-6s %-9s %-9s %-8s %s\n", "peer", "messages", "bytes", "refused", "expired")
+	r.mu.Lock()
+	for i, n := range peers {
+		acc, ok := r.accounts[servers[i].Self().ID()]
+		if !ok {
+			acc = &relayAccount{}
+		}
+		fmt.Printf("%-6s %-9d %-9d %-8d %d\n", n.name, acc.messages, acc.relayed, acc.refused, acc.expired)
+	}
+	r.mu.Unlock()
+}
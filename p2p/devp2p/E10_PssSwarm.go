@@ -0,0 +1,267 @@
+// pss tells of new content in swarm, and the ones told fetch it
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm"
+	bzzapi "github.com/ethereum/go-ethereum/swarm/api"
+	bzzclient "github.com/ethereum/go-ethereum/swarm/api/client"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+
+	demo "./common"
+	"./envelope"
+)
+
+const (
+	subscriberCount = 2
+	topicName       = "newcontent"
+	fetchTimeout    = time.Second * 10
+	resultTimeout   = time.Second * 30
+)
+
+var (
+	// from less than a chunk to a tree of chunks
+	contentSizes = []int{100, 5000, 100000}
+)
+
+// what the publisher tells the subscribers about the content it uploaded
+type Notification struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+	Size int    `json:"size"`
+}
+
+// what a subscriber made of a notification
+type fetchResult struct {
+	subscriber int
+	name       string
+	err        error
+}
+
+func newService(bzzdir string, bzzport int, bzznetworkid uint64) func(ctx *node.ServiceContext) (node.Service, error) {
+	return func(ctx *node.ServiceContext) (node.Service, error) {
+
+		// get the private key, which stays the same across runs if the data directory is kept
+		// the swarm overlay address is derived from it
+		privkey, err := demo.LoadOrCreateKey(filepath.Join(bzzdir, "bzzkey"))
+		if err != nil {
+			demo.Log.Crit("private key load servicenode fail", "err", err)
+		}
+
+		// create necessary swarm params
+		bzzconfig := bzzapi.NewConfig()
+		bzzconfig.Path = bzzdir
+		demo.Conf.Pss.Apply(bzzconfig.Pss)
+		bzzconfig.Init(privkey)
+		bzzconfig.Port = fmt.Sprintf("%d", bzzport)
+
+		// shortcut to setting up a swarm node
+		return swarm.NewSwarm(bzzconfig, nil)
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// create the publisher node, and the subscriber nodes after it
+	// every node serves the swarm http api on its own port
+	var stacks []*node.Node
+	var rpcclients []*rpc.Client
+	for i := 0; i <= subscriberCount; i++ {
+		stack, err := demo.NewServiceNode(demo.Conf.P2PPort+i, 0, 0)
+		if err != nil {
+			demo.Log.Crit(err.Error())
+		}
+		err = stack.Register(newService(stack.InstanceDir(), demo.Conf.BzzPort+i, demo.Conf.BzzNetworkId))
+		if err != nil {
+			demo.Log.Crit("servicenode pss register fail", "err", err)
+		}
+		err = stack.Start()
+		if err != nil {
+			demo.Log.Crit("servicenode start failed", "err", err)
+		}
+		defer demo.RemoveDataDir(stack.DataDir())
+		defer stack.Stop()
+		stacks = append(stacks, stack)
+	}
+
+	// connect the subscribers to the publisher
+	for _, stack := range stacks[1:] {
+		stack.Server().AddPeer(stacks[0].Server().Self())
+	}
+
+	// get the rpc clients
+	for _, stack := range stacks {
+		rpcclient, err := stack.Attach()
+		if err != nil {
+			demo.Log.Crit("rpc attach fail", "err", err)
+		}
+		defer rpcclient.Close()
+		rpcclients = append(rpcclients, rpcclient)
+	}
+
+	// wait until the state of the swarm overlay network is ready
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := demo.WaitHealthy(ctx, 2, rpcclients...)
+	if err != nil {
+		demo.Log.Warn("health check fail", "err", err)
+	}
+	time.Sleep(time.Second)
+
+	// get a valid topic byte
+	var topic string
+	err = demo.CallRetry(rpcclients[0], &topic, "pss_stringToTopic", topicName)
+	if err != nil {
+		demo.Log.Crit("pss string to topic fail", "err", err)
+	}
+
+	// the subscribers listen on the topic, and fetch what they're told of through their own swarm node
+	// the publisher needs their public keys to send them messages
+	var pubkeys []string
+	resultC := make(chan fetchResult)
+	for i, rpcclient := range rpcclients[1:] {
+		var bzzaddr string
+		err = demo.CallRetry(rpcclient, &bzzaddr, "pss_baseAddr")
+		if err != nil {
+			demo.Log.Crit("pss get baseaddr fail", "err", err)
+		}
+		var pubkey string
+		err = demo.CallRetry(rpcclient, &pubkey, "pss_getPublicKey")
+		if err != nil {
+			demo.Log.Crit("pss get pubkey fail", "err", err)
+		}
+		err = demo.CallRetry(rpcclients[0], nil, "pss_setPeerPublicKey", pubkey, topic, bzzaddr)
+		if err != nil {
+			demo.Log.Crit("pss set pubkey fail", "err", err)
+		}
+		pubkeys = append(pubkeys, pubkey)
+
+		msgC := make(chan pss.APIMsg)
+		sub, err := demo.Resubscribe(stacks[i+1].Attach, "pss", msgC, "receive", topic, false, false)
+		if err != nil {
+			demo.Log.Crit("pss subscribe fail", "err", err)
+		}
+		defer sub.Unsubscribe()
+		go subscribe(i+1, msgC, resultC)
+	}
+
+	// the publisher uploads the content to its swarm node, and tells the subscribers its hash
+	// the content stays in the publisher's node, pss only carries the hash
+	bzz := bzzclient.NewClient(fmt.Sprintf("http://localhost:%d", demo.Conf.BzzPort))
+	for i, size := range contentSizes {
+		data := make([]byte, size)
+		rand.Read(data)
+		hash, err := bzz.UploadRaw(bytes.NewReader(data), int64(size), false)
+		if err != nil {
+			demo.Log.Crit("swarm upload fail", "err", err)
+		}
+		notification := Notification{
+			Name: fmt.Sprintf("content %d", i),
+			Hash: hash,
+			Size: size,
+		}
+		demo.Log.Info("uploaded", "name", notification.Name, "size", size, "hash", hash)
+
+		msg, err := envelope.Wrap(envelope.JSON, notification)
+		if err != nil {
+			demo.Log.Crit("wrap message fail", "err", err)
+		}
+		for _, pubkey := range pubkeys {
+			err = demo.CallRetry(rpcclients[0], nil, "pss_sendAsym", pubkey, topic, common.ToHex(msg))
+			if err != nil {
+				demo.Log.Crit("pss send fail", "err", err)
+			}
+		}
+	}
+
+	// every subscriber fetches every content
+	timeout := time.After(resultTimeout)
+	for i := 0; i < len(contentSizes)*subscriberCount; i++ {
+		select {
+		case result := <-resultC:
+			if result.err != nil {
+				demo.Log.Crit("fetch fail", "subscriber", result.subscriber, "name", result.name, "err", result.err)
+			}
+			demo.Log.Info("fetched and verified", "subscriber", result.subscriber, "name", result.name)
+		case <-timeout:
+			demo.Log.Crit("timeout waiting for the subscribers", "fetched", i)
+		}
+	}
+}
+
+// fetches the content of each notification from the subscriber's swarm node
+func subscribe(subscriber int, msgC chan pss.APIMsg, resultC chan fetchResult) {
+	bzz := bzzclient.NewClient(fmt.Sprintf("http://localhost:%d", demo.Conf.BzzPort+subscriber))
+	for inmsg := range msgC {
+		var notification Notification
+		err := envelope.Unwrap(inmsg.Msg, &notification)
+		if err != nil {
+			demo.Log.Warn("unwrap message fail", "err", err)
+			continue
+		}
+		demo.Log.Debug("notified", "subscriber", subscriber, "name", notification.Name, "hash", notification.Hash)
+		resultC <- fetchResult{
+			subscriber: subscriber,
+			name:       notification.Name,
+			err:        fetch(bzz, notification),
+		}
+	}
+}
+
+// the chunks are fetched from the network, as they're not in the subscriber's node yet
+// the hash is worked out again from the data fetched, so we're sure it's what was announced
+func fetch(bzz *bzzclient.Client, notification Notification) error {
+	var data []byte
+	var err error
+	timeout := demo.EventuallyWithin(fetchTimeout, func() bool {
+		var reader io.ReadCloser
+		reader, _, err = bzz.DownloadRaw(notification.Hash)
+		if err != nil {
+			return false
+		}
+		data, err = ioutil.ReadAll(reader)
+		reader.Close()
+		return err == nil
+	})
+	if timeout != nil {
+		return err
+	}
+	if len(data) != notification.Size {
+		return fmt.Errorf("size %d, expected %d", len(data), notification.Size)
+	}
+	hash, err := swarmHash(data)
+	if err != nil {
+		return err
+	}
+	if hash.Hex() != notification.Hash {
+		return fmt.Errorf("content hashes to %s, expected %s", hash.Hex(), notification.Hash)
+	}
+	return nil
+}
+
+// the swarm hash of the data, chunked the same way the swarm node does it
+func swarmHash(data []byte) (storage.Address, error) {
+	ctx := context.Background()
+	store := storage.NewMemStore(storage.NewDefaultStoreParams(), nil)
+	defer store.Close()
+	putter := storage.NewHasherStore(store, storage.MakeHashFunc(storage.BMTHash), false)
+	addr, wait, err := storage.TreeSplit(ctx, bytes.NewReader(data), int64(len(data)), putter)
+	if err != nil {
+		return nil, err
+	}
+	return addr, wait(ctx)
+}
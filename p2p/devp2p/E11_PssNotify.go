@@ -0,0 +1,244 @@
+// a push notification service over pss, with the notify package of swarm
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/simulation"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/ethereum/go-ethereum/swarm/pss/notify"
+	"github.com/ethereum/go-ethereum/swarm/state"
+
+	demo "./common"
+)
+
+const (
+	nodeCount       = 8
+	subscriberCount = 3
+	feedName        = "news"
+	healthTimeout   = time.Second * 20
+	notifyTimeout   = time.Second * 5
+
+	// how many bytes of the subscriber address the notifier bins subscribers by
+	// all subscribers in a bin share the symmetric key the notifications are sent with
+	binThreshold = notify.DefaultAddressLength
+)
+
+// what we need to know about each node
+// the controller is made anew each time the node starts, the key stays the same
+type simNode struct {
+	id   enode.ID
+	ctrl *notify.Controller
+	addr []byte
+	key  *ecdsa.PrivateKey
+}
+
+// a notification, as a subscriber got it
+type delivery struct {
+	subscriber int
+	data       string
+}
+
+func newServices() (map[string]simulation.ServiceFunc, func(enode.ID) *simNode) {
+	var mu sync.Mutex
+	kademlias := make(map[enode.ID]*network.Kademlia)
+	nodes := make(map[enode.ID]*simNode)
+	kademlia := func(id enode.ID) *network.Kademlia {
+		mu.Lock()
+		defer mu.Unlock()
+		if k, ok := kademlias[id]; ok {
+			return k
+		}
+		kademlias[id] = network.NewKademlia(id[:], network.NewKadParams())
+		return kademlias[id]
+	}
+	getNode := func(id enode.ID) *simNode {
+		mu.Lock()
+		defer mu.Unlock()
+		return nodes[id]
+	}
+
+	return map[string]simulation.ServiceFunc{
+		"bzz": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			addr := network.NewAddr(ctx.Config.Node())
+			kad := kademlia(ctx.Config.ID)
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+			config := &network.BzzConfig{
+				OverlayAddr:  addr.Over(),
+				UnderlayAddr: addr.Under(),
+				HiveParams:   network.NewHiveParams(),
+			}
+			return network.NewBzz(config, kad, state.NewInmemoryStore(), nil, nil), nil, nil
+		},
+		"pss": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			kad := kademlia(ctx.Config.ID)
+			mu.Lock()
+			defer mu.Unlock()
+			n, ok := nodes[ctx.Config.ID]
+			if !ok {
+				key, err := crypto.GenerateKey()
+				if err != nil {
+					return nil, nil, err
+				}
+				n = &simNode{
+					id:  ctx.Config.ID,
+					key: key,
+				}
+				nodes[ctx.Config.ID] = n
+			}
+			ps, err := pss.NewPss(kad, pss.NewPssParams().WithPrivateKey(n.key))
+			if err != nil {
+				return nil, nil, err
+			}
+
+			// the controller handles the subscriptions on its control topic, and the notifications on the topic of each feed
+			n.ctrl = notify.NewController(ps)
+			n.addr = kad.BaseAddr()
+			return ps, nil, nil
+		},
+	}, getNode
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	services, getNode := newServices()
+	sim := simulation.New(services)
+	defer sim.Close()
+	ids, err := sim.AddNodesAndConnectRing(nodeCount)
+	if err != nil {
+		demo.Log.Crit("create network fail", "err", err)
+	}
+	waitHealthy(sim)
+
+	// the first node publishes, the ones after it subscribe
+	publisher := getNode(ids[0])
+	subscribers := ids[1 : 1+subscriberCount]
+
+	// the notifier sends what it gets on the channel to all subscribers
+	updateC := make(chan []byte)
+	_, err = publisher.ctrl.NewNotifier(feedName, binThreshold, updateC)
+	if err != nil {
+		demo.Log.Crit("new notifier fail", "err", err)
+	}
+
+	// the subscribers ask the publisher for notifications, with its public key and address
+	// the answer carries the symmetric key of the subscriber's bin, and an empty first notification
+	deliveryC := make(chan delivery)
+	for i := range subscribers {
+		subscribe(getNode(subscribers[i]), publisher, i, deliveryC)
+	}
+	expect(deliveryC, "", allOf(subscribers))
+
+	// every subscriber gets every update
+	received := make([][]string, subscriberCount)
+	publish := func(data string, to []int) {
+		updateC <- []byte(data)
+		for _, d := range expect(deliveryC, data, to) {
+			received[d.subscriber] = append(received[d.subscriber], d.data)
+		}
+	}
+	publish("update 1", allOf(subscribers))
+	publish("update 2", allOf(subscribers))
+
+	// a subscriber that's down misses what's published meanwhile
+	// there's no store and forward in pss, and the notifier doesn't know the subscriber is gone
+	restarted := 0
+	err = sim.StopNode(subscribers[restarted])
+	if err != nil {
+		demo.Log.Crit("stop node fail", "err", err)
+	}
+	demo.Log.Info("subscriber down", "subscriber", restarted)
+	publish("update 3", allOf(subscribers)[1:])
+
+	// when it's back, it has a new controller that knows nothing of the subscription, so it subscribes again
+	// the notifier still has the bin of its address, so it gets the same symmetric key again
+	err = sim.StartNode(subscribers[restarted])
+	if err != nil {
+		demo.Log.Crit("start node fail", "err", err)
+	}
+	for _, peer := range []enode.ID{ids[0], ids[2]} {
+		err = sim.Net.Connect(subscribers[restarted], peer)
+		if err != nil {
+			demo.Log.Crit("connect fail", "err", err)
+		}
+	}
+	waitHealthy(sim)
+	demo.Log.Info("subscriber up", "subscriber", restarted)
+	subscribe(getNode(subscribers[restarted]), publisher, restarted, deliveryC)
+	expect(deliveryC, "", []int{restarted})
+	publish("update 4", allOf(subscribers))
+
+	fmt.Printf("%-12s %s\n", "subscriber", "received")
+	for i, r := range received {
+		fmt.Printf("%-12d %s\n", i, strings.Join(r, ", "))
+	}
+}
+
+func subscribe(n *simNode, publisher *simNode, subscriber int, deliveryC chan delivery) {
+	err := n.ctrl.Subscribe(feedName, &publisher.key.PublicKey, pss.PssAddress(publisher.addr), func(name string, data []byte) error {
+		deliveryC <- delivery{
+			subscriber: subscriber,
+			data:       string(data),
+		}
+		return nil
+	})
+	if err != nil {
+		demo.Log.Crit("subscribe fail", "err", err)
+	}
+}
+
+// waits for the subscribers to get the notification, and fails if one doesn't or if anyone else does
+func expect(deliveryC chan delivery, data string, to []int) []delivery {
+	var got []delivery
+	for len(got) < len(to) {
+		msg, err := demo.ExpectMsg(deliveryC, func(msg interface{}) bool {
+			return msg.(delivery).data == data
+		}, notifyTimeout)
+		if err == demo.ErrTimeout {
+			demo.Log.Crit("notification timeout", "data", data, "got", len(got), "expected", len(to))
+		} else if err != nil {
+			demo.Log.Crit("unexpected notification", "err", err, "expected", data)
+		}
+		d := msg.(delivery)
+		demo.Log.Info("notified", "subscriber", d.subscriber, "data", d.data)
+		got = append(got, d)
+	}
+	sort.Slice(got, func(i, j int) bool {
+		return got[i].subscriber < got[j].subscriber
+	})
+	for i, d := range got {
+		if d.subscriber != to[i] {
+			demo.Log.Crit("notification to the wrong subscriber", "subscriber", d.subscriber, "data", data)
+		}
+	}
+	return got
+}
+
+func allOf(subscribers []enode.ID) []int {
+	var all []int
+	for i := range subscribers {
+		all = append(all, i)
+	}
+	return all
+}
+
+func waitHealthy(sim *simulation.Simulation) {
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	defer cancel()
+	_, err := sim.WaitTillHealthy(ctx, network.NewKadParams().MinProxBinSize)
+	if err != nil {
+		demo.Log.Warn("network not healthy, routing may not be optimal", "err", err)
+	}
+}
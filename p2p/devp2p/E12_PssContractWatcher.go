@@ -0,0 +1,794 @@
+// watches the events of a contract through an ethereum node, and tells of them over pss
+// the node drops the connection and the chain reorganises under the watcher, and what was told still adds up to what is on chain
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/contracts/ens/contract"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/bloombits"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/filters"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm"
+	bzzapi "github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+
+	demo "./common"
+	"./envelope"
+)
+
+const (
+	topicName        = "ensnames"
+	reorgDepth       = 6 // how many blocks back the watcher looks again when it resubscribes
+	resubscribeDelay = time.Millisecond * 500
+	stepDelay        = time.Millisecond * 500
+	syncTimeout      = time.Second * 30
+)
+
+var (
+	// every name the example registers, so the label hashes in the events can be told by name
+	labels = []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace"}
+)
+
+// what the watcher tells the subscribers
+// an event taken back by a reorg is told again with removed set
+type Event struct {
+	Seq       uint64         `json:"seq"`
+	Name      string         `json:"name"`
+	Owner     common.Address `json:"owner"`
+	Block     uint64         `json:"block"`
+	BlockHash common.Hash    `json:"blockHash"`
+	LogIndex  uint           `json:"logIndex"`
+	Removed   bool           `json:"removed"`
+}
+
+func (self *Event) key() string {
+	return fmt.Sprintf("%x/%d", self.BlockHash, self.LogIndex)
+}
+
+// the names registered, as worked out from the events
+// both the watcher and the subscriber keep one
+type view struct {
+	mu     sync.Mutex
+	events map[string]Event
+}
+
+func newView() *view {
+	return &view{
+		events: make(map[string]Event),
+	}
+}
+
+// adds or takes back the event, and returns false if it changes nothing
+func (self *view) apply(ev Event) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	_, ok := self.events[ev.key()]
+	if ev.Removed {
+		delete(self.events, ev.key())
+		return ok
+	}
+	self.events[ev.key()] = ev
+	return !ok
+}
+
+// the events from the block on, in chain order
+func (self *view) since(block uint64) []Event {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	var events []Event
+	for _, ev := range self.events {
+		if ev.Block >= block {
+			events = append(events, ev)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Block != events[j].Block {
+			return events[i].Block < events[j].Block
+		}
+		return events[i].LogIndex < events[j].LogIndex
+	})
+	return events
+}
+
+// the last owner of each name
+func (self *view) owners() map[string]Event {
+	owners := make(map[string]Event)
+	for _, ev := range self.since(0) {
+		owners[ev.Name] = ev
+	}
+	return owners
+}
+
+// watches the registry for names getting a new owner
+// the subscription is made anew when the connection drops, and the blocks it may have missed are filtered for again
+type watcher struct {
+	endpoint   string
+	registry   common.Address
+	names      map[common.Hash]string
+	view       *view
+	publish    func(Event)
+	checkpoint uint64 // the head of the chain when the watcher last caught up
+	resubs     int
+}
+
+func (self *watcher) run(ctx context.Context) {
+	for {
+		err := self.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		self.resubs++
+		demo.Log.Warn("watch interrupted, resubscribing", "err", err, "checkpoint", self.checkpoint)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(resubscribeDelay):
+		}
+	}
+}
+
+func (self *watcher) watch(ctx context.Context) error {
+	client, err := ethclient.DialContext(ctx, self.endpoint)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ens, err := contract.NewENSFilterer(self.registry, client)
+	if err != nil {
+		return err
+	}
+
+	// subscribe before catching up, so nothing mined in between is missed
+	// what comes both ways is applied only once
+	sink := make(chan *contract.ENSNewOwner, 64)
+	sub, err := ens.WatchNewOwner(&bind.WatchOpts{Context: ctx}, sink, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	var from uint64
+	if self.checkpoint > reorgDepth {
+		from = self.checkpoint - reorgDepth
+	}
+	if err := self.sync(ctx, client, ens, from); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case ev := <-sink:
+			if !ev.Raw.Removed {
+				self.apply(self.event(ev))
+				if ev.Raw.BlockNumber > self.checkpoint {
+					self.checkpoint = ev.Raw.BlockNumber
+				}
+				continue
+			}
+			// the block of the event left the chain
+			// the node doesn't always send the events of the blocks that replaced it, so the watcher asks for them
+			self.apply(self.event(ev))
+			if err := self.sync(ctx, client, ens, ev.Raw.BlockNumber); err != nil {
+				return err
+			}
+		case err := <-sub.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// makes the view from the block on what the node has now
+// events the node doesn't have anymore are taken back, and the ones it has in addition are added
+func (self *watcher) sync(ctx context.Context, client *ethclient.Client, ens *contract.ENSFilterer, from uint64) error {
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	it, err := ens.FilterNewOwner(&bind.FilterOpts{Start: from, Context: ctx}, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	current := make(map[string]bool)
+	var events []Event
+	for it.Next() {
+		ev := self.event(it.Event)
+		current[ev.key()] = true
+		events = append(events, ev)
+	}
+	if it.Error() != nil {
+		return it.Error()
+	}
+	for _, ev := range self.view.since(from) {
+		if !current[ev.key()] {
+			ev.Removed = true
+			self.apply(ev)
+		}
+	}
+	for _, ev := range events {
+		self.apply(ev)
+	}
+	if head.Number.Uint64() > self.checkpoint {
+		self.checkpoint = head.Number.Uint64()
+	}
+	demo.Log.Debug("caught up", "from", from, "head", head.Number, "events", len(events))
+	return nil
+}
+
+func (self *watcher) event(ev *contract.ENSNewOwner) Event {
+	name, ok := self.names[ev.Label]
+	if !ok {
+		name = common.Hash(ev.Label).Hex()
+	}
+	return Event{
+		Name:      name,
+		Owner:     ev.Owner,
+		Block:     ev.Raw.BlockNumber,
+		BlockHash: ev.Raw.BlockHash,
+		LogIndex:  ev.Raw.Index,
+		Removed:   ev.Raw.Removed,
+	}
+}
+
+// the subscribers are only told of what changes the view
+func (self *watcher) apply(ev Event) {
+	if !self.view.apply(ev) {
+		return
+	}
+	if ev.Removed {
+		demo.Log.Info("registration taken back", "name", ev.Name, "block", ev.Block, "hash", ev.BlockHash.TerminalString())
+	} else {
+		demo.Log.Info("registration", "name", ev.Name, "owner", ev.Owner.Hex(), "block", ev.Block, "hash", ev.BlockHash.TerminalString())
+	}
+	self.publish(ev)
+}
+
+// a proof of work chain with an ens registry on it, mined on demand
+// forks are mined from earlier blocks, and they replace the head when they're longer
+type devChain struct {
+	config   *params.ChainConfig
+	db       ethdb.Database
+	bc       *core.BlockChain
+	key      *ecdsa.PrivateKey
+	from     common.Address
+	signer   types.Signer
+	registry common.Address
+	ensabi   abi.ABI
+	filters  *filters.PublicFilterAPI
+
+	mu       sync.Mutex
+	server   *rpc.Server
+	listener net.Listener
+}
+
+func newDevChain() (*devChain, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	ensabi, err := abi.JSON(strings.NewReader(contract.ENSABI))
+	if err != nil {
+		return nil, err
+	}
+	self := &devChain{
+		config: params.AllEthashProtocolChanges,
+		db:     ethdb.NewMemDatabase(),
+		key:    key,
+		from:   crypto.PubkeyToAddress(key.PublicKey),
+		ensabi: ensabi,
+	}
+	self.signer = types.NewEIP155Signer(self.config.ChainID)
+	genesis := &core.Genesis{
+		Config:   self.config,
+		GasLimit: 8000000,
+		Alloc: core.GenesisAlloc{
+			self.from: {Balance: big.NewInt(params.Ether)},
+		},
+	}
+	genesis.MustCommit(self.db)
+
+	// every state is written to the database, so forks can be mined from any block
+	self.bc, err = core.NewBlockChain(self.db, &core.CacheConfig{Disabled: true}, self.config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	self.filters = filters.NewPublicFilterAPI(&filterBackend{db: self.db, bc: self.bc, mux: new(event.TypeMux)}, false)
+
+	// the registry is deployed in the first block, and the key owns its root node
+	self.registry = crypto.CreateAddress(self.from, 0)
+	_, err = self.mine(self.bc.CurrentBlock(), nil, func(gen *core.BlockGen) {
+		tx := types.NewContractCreation(gen.TxNonce(self.from), new(big.Int), 2000000, big.NewInt(1), common.FromHex(contract.ENSBin))
+		gen.AddTx(self.sign(tx))
+	})
+	if err != nil {
+		self.bc.Stop()
+		return nil, err
+	}
+	return self, nil
+}
+
+func (self *devChain) sign(tx *types.Transaction) *types.Transaction {
+	tx, err := types.SignTx(tx, self.signer, self.key)
+	if err != nil {
+		panic(err) // the key and the signer are ours
+	}
+	return tx
+}
+
+// mines a block on the head for each label, registering it
+// an empty label makes an empty block
+func (self *devChain) extend(labels ...string) error {
+	_, err := self.mine(self.bc.CurrentBlock(), labels, nil)
+	return err
+}
+
+// mines a block for each label from depth blocks below the head
+// there must be more labels than the depth, so the fork is longer and becomes the chain
+func (self *devChain) reorg(depth int, labels ...string) error {
+	head := self.bc.CurrentBlock().NumberU64()
+	parent := self.bc.GetBlockByNumber(head - uint64(depth))
+	_, err := self.mine(parent, labels, func(gen *core.BlockGen) {
+		gen.SetExtra([]byte("fork")) // so the blocks differ from the ones they replace, even if empty
+	})
+	return err
+}
+
+func (self *devChain) mine(parent *types.Block, labels []string, extra func(*core.BlockGen)) (int, error) {
+	n := len(labels)
+	if labels == nil {
+		n = 1
+	}
+	blocks, _ := core.GenerateChain(self.config, parent, ethash.NewFaker(), self.db, n, func(i int, gen *core.BlockGen) {
+		if extra != nil {
+			extra(gen)
+		}
+		if labels == nil || labels[i] == "" {
+			return
+		}
+		data, err := self.ensabi.Pack("setSubnodeOwner", [32]byte{}, labelHash(labels[i]), ownerAddress(labels[i]))
+		if err != nil {
+			panic(err)
+		}
+		tx := types.NewTransaction(gen.TxNonce(self.from), self.registry, new(big.Int), 200000, big.NewInt(1), data)
+		gen.AddTx(self.sign(tx))
+	})
+	return self.bc.InsertChain(blocks)
+}
+
+// the owner of the name in the registry, at the head of the chain
+func (self *devChain) owner(name string) (common.Address, error) {
+	ens, err := contract.NewENSCaller(self.registry, self)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return ens.Owner(nil, nameHash(name))
+}
+
+// serves the chain over websocket json rpc, like an ethereum node does
+// the log subscriptions and the log filters come from the filter api of the eth package
+func (self *devChain) serve(addr string) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", self.filters); err != nil {
+		return err
+	}
+	if err := server.RegisterName("eth", &ChainAPI{chain: self}); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go rpc.NewWSServer([]string{"*"}, server).Serve(listener)
+	self.server = server
+	self.listener = listener
+	return nil
+}
+
+// closes the endpoint and every connection to it, as a node going down would
+func (self *devChain) drop() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.listener == nil {
+		return
+	}
+	self.listener.Close()
+	self.server.Stop()
+	self.listener = nil
+	self.server = nil
+}
+
+func (self *devChain) Stop() {
+	self.drop()
+	self.bc.Stop()
+}
+
+// bind.ContractCaller, on the state at the head
+func (self *devChain) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	statedb, err := self.bc.State()
+	if err != nil {
+		return nil, err
+	}
+	return statedb.GetCode(contract), nil
+}
+
+func (self *devChain) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	statedb, err := self.bc.State()
+	if err != nil {
+		return nil, err
+	}
+	msg := types.NewMessage(call.From, call.To, 0, new(big.Int), math.MaxUint64/2, new(big.Int), call.Data, false)
+	evmContext := core.NewEVMContext(msg, self.bc.CurrentHeader(), self.bc, nil)
+	evm := vm.NewEVM(evmContext, statedb, self.config, vm.Config{})
+	ret, _, _, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(math.MaxUint64))
+	return ret, err
+}
+
+// the headers, which the watcher needs besides the filter api
+type ChainAPI struct {
+	chain *devChain
+}
+
+func (self *ChainAPI) GetBlockByNumber(ctx context.Context, number rpc.BlockNumber, fullTx bool) (*types.Header, error) {
+	if number == rpc.LatestBlockNumber {
+		return self.chain.bc.CurrentHeader(), nil
+	}
+	return self.chain.bc.GetHeaderByNumber(uint64(number)), nil
+}
+
+// filters.Backend for the chain, without the bloom bits index
+type filterBackend struct {
+	db  ethdb.Database
+	bc  *core.BlockChain
+	mux *event.TypeMux
+}
+
+func (self *filterBackend) ChainDb() ethdb.Database {
+	return self.db
+}
+
+func (self *filterBackend) EventMux() *event.TypeMux {
+	return self.mux
+}
+
+func (self *filterBackend) HeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Header, error) {
+	if number == rpc.LatestBlockNumber {
+		return self.bc.CurrentHeader(), nil
+	}
+	return self.bc.GetHeaderByNumber(uint64(number)), nil
+}
+
+func (self *filterBackend) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	return self.bc.GetHeaderByHash(hash), nil
+}
+
+func (self *filterBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+	number := rawdb.ReadHeaderNumber(self.db, hash)
+	if number == nil {
+		return nil, nil
+	}
+	return rawdb.ReadReceipts(self.db, hash, *number), nil
+}
+
+func (self *filterBackend) GetLogs(ctx context.Context, hash common.Hash) ([][]*types.Log, error) {
+	receipts, _ := self.GetReceipts(ctx, hash)
+	logs := make([][]*types.Log, len(receipts))
+	for i, receipt := range receipts {
+		logs[i] = receipt.Logs
+	}
+	return logs, nil
+}
+
+func (self *filterBackend) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription {
+	// there's no transaction pool, the transactions are mined as they're made
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		<-quit
+		return nil
+	})
+}
+
+func (self *filterBackend) SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription {
+	return self.bc.SubscribeChainEvent(ch)
+}
+
+func (self *filterBackend) SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription {
+	return self.bc.SubscribeRemovedLogsEvent(ch)
+}
+
+func (self *filterBackend) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription {
+	return self.bc.SubscribeLogsEvent(ch)
+}
+
+func (self *filterBackend) BloomStatus() (uint64, uint64) {
+	return params.BloomBitsBlocks, 0
+}
+
+func (self *filterBackend) ServiceFilter(ctx context.Context, session *bloombits.MatcherSession) {
+	panic("no bloom bits index")
+}
+
+func labelHash(label string) [32]byte {
+	return crypto.Keccak256Hash([]byte(label))
+}
+
+// the node of a name right under the root
+func nameHash(label string) [32]byte {
+	h := labelHash(label)
+	return crypto.Keccak256Hash(make([]byte, 32), h[:])
+}
+
+// every name gets an owner of its own
+func ownerAddress(label string) common.Address {
+	return common.BytesToAddress(crypto.Keccak256([]byte("owner of " + label)))
+}
+
+func newService(bzzdir string, bzzport int, bzznetworkid uint64) func(ctx *node.ServiceContext) (node.Service, error) {
+	return func(ctx *node.ServiceContext) (node.Service, error) {
+
+		// get the private key, which stays the same across runs if the data directory is kept
+		privkey, err := demo.LoadOrCreateKey(filepath.Join(bzzdir, "bzzkey"))
+		if err != nil {
+			demo.Log.Crit("private key load servicenode fail", "err", err)
+		}
+
+		// create necessary swarm params
+		bzzconfig := bzzapi.NewConfig()
+		bzzconfig.Path = bzzdir
+		demo.Conf.Pss.Apply(bzzconfig.Pss)
+		bzzconfig.Init(privkey)
+		bzzconfig.Port = fmt.Sprintf("%d", bzzport)
+
+		// shortcut to setting up a swarm node
+		return swarm.NewSwarm(bzzconfig, nil)
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// the chain, served on the websocket port
+	chain, err := newDevChain()
+	if err != nil {
+		demo.Log.Crit("create chain fail", "err", err)
+	}
+	defer chain.Stop()
+	endpoint := fmt.Sprintf("127.0.0.1:%d", demo.Conf.WSPort)
+	err = chain.serve(endpoint)
+	if err != nil {
+		demo.Log.Crit("serve chain fail", "err", err)
+	}
+	demo.Log.Info("registry deployed", "address", chain.registry.Hex(), "endpoint", endpoint)
+
+	// the watcher runs on the first pss node, the subscriber on the second
+	var stacks []*node.Node
+	var rpcclients []*rpc.Client
+	for i := 0; i < 2; i++ {
+		stack, err := demo.NewServiceNode(demo.Conf.P2PPort+i, 0, 0)
+		if err != nil {
+			demo.Log.Crit(err.Error())
+		}
+		err = stack.Register(newService(stack.InstanceDir(), demo.Conf.BzzPort+i, demo.Conf.BzzNetworkId))
+		if err != nil {
+			demo.Log.Crit("servicenode pss register fail", "err", err)
+		}
+		err = stack.Start()
+		if err != nil {
+			demo.Log.Crit("servicenode start failed", "err", err)
+		}
+		defer demo.RemoveDataDir(stack.DataDir())
+		defer stack.Stop()
+		stacks = append(stacks, stack)
+		rpcclient, err := stack.Attach()
+		if err != nil {
+			demo.Log.Crit("rpc attach fail", "err", err)
+		}
+		defer rpcclient.Close()
+		rpcclients = append(rpcclients, rpcclient)
+	}
+	stacks[1].Server().AddPeer(stacks[0].Server().Self())
+
+	// wait until the state of the swarm overlay network is ready
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = demo.WaitHealthy(ctx, 1, rpcclients...)
+	if err != nil {
+		demo.Log.Warn("health check fail", "err", err)
+	}
+	time.Sleep(time.Second)
+
+	// get a valid topic byte, and give the watcher the subscriber's public key
+	var topic string
+	err = demo.CallRetry(rpcclients[0], &topic, "pss_stringToTopic", topicName)
+	if err != nil {
+		demo.Log.Crit("pss string to topic fail", "err", err)
+	}
+	var bzzaddr string
+	err = demo.CallRetry(rpcclients[1], &bzzaddr, "pss_baseAddr")
+	if err != nil {
+		demo.Log.Crit("pss get baseaddr fail", "err", err)
+	}
+	var pubkey string
+	err = demo.CallRetry(rpcclients[1], &pubkey, "pss_getPublicKey")
+	if err != nil {
+		demo.Log.Crit("pss get pubkey fail", "err", err)
+	}
+	err = demo.CallRetry(rpcclients[0], nil, "pss_setPeerPublicKey", pubkey, topic, bzzaddr)
+	if err != nil {
+		demo.Log.Crit("pss set pubkey fail", "err", err)
+	}
+
+	// the subscriber keeps its own view, from what it's told
+	msgC := make(chan pss.APIMsg)
+	sub, err := demo.Resubscribe(stacks[1].Attach, "pss", msgC, "receive", topic, false, false)
+	if err != nil {
+		demo.Log.Crit("pss subscribe fail", "err", err)
+	}
+	defer sub.Unsubscribe()
+	subscribed := newView()
+	go subscribe(msgC, subscribed)
+
+	// the watcher tells the subscriber of every change to its view, numbered
+	var seq uint64
+	names := make(map[common.Hash]string)
+	for _, label := range labels {
+		names[labelHash(label)] = label
+	}
+	w := &watcher{
+		endpoint: "ws://" + endpoint,
+		registry: chain.registry,
+		names:    names,
+		view:     newView(),
+		publish: func(ev Event) {
+			ev.Seq = seq
+			seq++
+			msg, err := envelope.Wrap(envelope.JSON, ev)
+			if err != nil {
+				demo.Log.Crit("wrap message fail", "err", err)
+			}
+			err = demo.CallRetry(rpcclients[0], nil, "pss_sendAsym", pubkey, topic, common.ToHex(msg))
+			if err != nil {
+				demo.Log.Error("pss send fail", "err", err)
+			}
+		},
+	}
+	watchctx, stopwatch := context.WithCancel(context.Background())
+	defer stopwatch()
+	go w.run(watchctx)
+
+	// the names are registered one block at a time
+	step := func(desc string, f func() error) {
+		time.Sleep(stepDelay)
+		demo.Log.Info(desc, "head", chain.bc.CurrentBlock().NumberU64())
+		if err := f(); err != nil {
+			demo.Log.Crit("mine fail", "err", err)
+		}
+	}
+	step("register alice and bob", func() error {
+		return chain.extend("alice", "bob", "")
+	})
+
+	// the blocks of bob and the empty block are replaced by a longer fork, where carol comes before bob
+	// the watcher hears of the events taken back from the subscription
+	step("reorg two blocks deep", func() error {
+		return chain.reorg(2, "carol", "bob", "")
+	})
+
+	// dave is registered, and taken back while the watcher is cut off
+	// it only finds out when it filters the blocks below its checkpoint again
+	step("register dave", func() error {
+		return chain.extend("dave")
+	})
+	step("drop the connection", func() error {
+		chain.drop()
+		return nil
+	})
+	step("reorg one block deep", func() error {
+		return chain.reorg(1, "erin", "frank")
+	})
+	step("serve again", func() error {
+		return chain.serve(endpoint)
+	})
+	step("register grace", func() error {
+		return chain.extend("grace")
+	})
+
+	// the watcher and the subscriber must end up with the owners the registry has
+	var diff string
+	err = demo.EventuallyWithin(syncTimeout, func() bool {
+		var err error
+		diff, err = compare(chain, w.view, subscribed)
+		if err != nil {
+			demo.Log.Crit("read registry fail", "err", err)
+		}
+		return diff == ""
+	})
+	if err != nil {
+		demo.Log.Crit("views differ from the registry", "diff", diff)
+	}
+	stopwatch()
+
+	fmt.Printf("\n%-8s %-44s %-8s %s\n", "name", "owner", "block", "hash")
+	owners := subscribed.owners()
+	for _, label := range labels {
+		ev, ok := owners[label]
+		if !ok {
+			fmt.Printf("%-8s %-44s\n", label, "-")
+			continue
+		}
+		fmt.Printf("%-8s %-44s %-8d %s\n", label, ev.Owner.Hex(), ev.Block, ev.BlockHash.TerminalString())
+	}
+	fmt.Printf("\nhead %d, %d events told, %d attempts to resubscribe\n", chain.bc.CurrentBlock().NumberU64(), seq, w.resubs)
+}
+
+// applies the events in the order the watcher told them
+// pss doesn't promise the order the messages arrive in
+func subscribe(msgC chan pss.APIMsg, v *view) {
+	var next uint64
+	pending := make(map[uint64]Event)
+	for inmsg := range msgC {
+		var ev Event
+		err := envelope.Unwrap(inmsg.Msg, &ev)
+		if err != nil {
+			demo.Log.Warn("unwrap message fail", "err", err)
+			continue
+		}
+		pending[ev.Seq] = ev
+		for {
+			ev, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			v.apply(ev)
+			demo.Log.Debug("told", "seq", ev.Seq, "name", ev.Name, "block", ev.Block, "removed", ev.Removed)
+		}
+	}
+}
+
+// what differs between the owners in the registry and the views, or an empty string
+func compare(chain *devChain, views ...*view) (string, error) {
+	var diff []string
+	for i, v := range views {
+		owners := v.owners()
+		for _, label := range labels {
+			owner, err := chain.owner(label)
+			if err != nil {
+				return "", err
+			}
+			if owners[label].Owner != owner {
+				diff = append(diff, fmt.Sprintf("view %d has %s for %s, registry has %s", i, owners[label].Owner.Hex(), label, owner.Hex()))
+			}
+		}
+	}
+	return strings.Join(diff, "; "), nil
+}
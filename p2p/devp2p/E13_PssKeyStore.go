@@ -0,0 +1,269 @@
+// pss keys kept in the data directory, and registered again when the nodes restart, without exchanging them again
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm"
+	bzzapi "github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+
+	demo "./common"
+	"./envelope"
+	"./psskeys"
+)
+
+const (
+	symKeyName = "left-right"
+	recvWait   = time.Second * 5
+)
+
+func newService(bzzdir string, bzzport int, bzznetworkid uint64) func(ctx *node.ServiceContext) (node.Service, error) {
+	return func(ctx *node.ServiceContext) (node.Service, error) {
+
+		// the key is kept in the data directory, so the node comes back with the same overlay address and pss public key
+		privkey, err := demo.LoadOrCreateKey(filepath.Join(bzzdir, "bzzkey"))
+		if err != nil {
+			demo.Log.Crit("private key load servicenode fail", "err", err)
+		}
+
+		bzzconfig := bzzapi.NewConfig()
+		bzzconfig.Path = bzzdir
+		demo.Conf.Pss.Apply(bzzconfig.Pss)
+		bzzconfig.Init(privkey)
+		bzzconfig.Port = fmt.Sprintf("%d", bzzport)
+
+		return swarm.NewSwarm(bzzconfig, nil)
+	}
+}
+
+// a running node, its rpc client, and the keys it knows
+type pssNode struct {
+	name   string
+	stack  *node.Node
+	client *rpc.Client
+	keys   *psskeys.Store
+	addr   string
+	pubkey string
+	msgC   chan pss.APIMsg
+	sub    *demo.Resubscription
+}
+
+func startNode(name string, offset int) *pssNode {
+	stack, err := demo.NewServiceNode(demo.Conf.P2PPort+offset, 0, 0)
+	if err != nil {
+		demo.Log.Crit(err.Error())
+	}
+	err = stack.Register(newService(stack.InstanceDir(), demo.Conf.BzzPort+offset, demo.Conf.BzzNetworkId))
+	if err != nil {
+		demo.Log.Crit("servicenode pss register fail", "name", name, "err", err)
+	}
+	if err := stack.Start(); err != nil {
+		demo.Log.Crit("servicenode start failed", "name", name, "err", err)
+	}
+	client, err := stack.Attach()
+	if err != nil {
+		demo.Log.Crit("attach fail", "name", name, "err", err)
+	}
+
+	// the store opens the file the node left there the last time it ran, if any
+	keys, err := psskeys.Open(filepath.Join(stack.InstanceDir(), "psskeys.json"))
+	if err != nil {
+		demo.Log.Crit("open pss keys fail", "name", name, "err", err)
+	}
+	n := &pssNode{
+		name:   name,
+		stack:  stack,
+		client: client,
+		keys:   keys,
+	}
+	err = demo.CallRetry(client, &n.addr, "pss_baseAddr")
+	if err != nil {
+		demo.Log.Crit("pss get baseaddr fail", "err", err)
+	}
+	err = demo.CallRetry(client, &n.pubkey, "pss_getPublicKey")
+	if err != nil {
+		demo.Log.Crit("pss get pubkey fail", "err", err)
+	}
+	return n
+}
+
+func (self *pssNode) subscribe(topic string) {
+	self.msgC = make(chan pss.APIMsg)
+	sub, err := demo.Resubscribe(self.stack.Attach, "pss", self.msgC, "receive", topic, false, false)
+	if err != nil {
+		demo.Log.Crit("pss subscribe fail", "err", err)
+	}
+	self.sub = sub
+}
+
+func (self *pssNode) stop() {
+	if self.sub != nil {
+		self.sub.Unsubscribe()
+	}
+	self.client.Close()
+	self.stack.Stop()
+}
+
+// starts both nodes, and connects them
+func startNodes(topic *string) (l, r *pssNode) {
+	l = startNode("left", 0)
+	r = startNode("right", 1)
+	l.stack.Server().AddPeer(r.stack.Server().Self())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := demo.WaitHealthy(ctx, 2, l.client, r.client)
+	if err != nil {
+		demo.Log.Crit("health check fail", "err", err)
+	}
+	time.Sleep(time.Second) // because the healthy does not work
+
+	err = demo.CallRetry(l.client, topic, "pss_stringToTopic", "foo")
+	if err != nil {
+		demo.Log.Crit("pss string to topic fail", "err", err)
+	}
+	r.subscribe(*topic)
+	fmt.Printf("left %s, right %s\n", l.addr, r.addr)
+	return l, r
+}
+
+// the out-of-band part: left learns the public key of right, and both get a symmetric key
+// in a real application it would come over another channel, here main just hands them over
+func exchangeKeys(l, r *pssNode, topic string) {
+	err := l.keys.SetPeerPublicKey(l.client, psskeys.PeerKey{
+		PublicKey: common.FromHex(r.pubkey),
+		Topic:     topic,
+		Address:   r.addr,
+	})
+	if err != nil {
+		demo.Log.Crit("pss set peer pubkey fail", "err", err)
+	}
+
+	symkey := make([]byte, 32)
+	if _, err := rand.Read(symkey); err != nil {
+		demo.Log.Crit("symkey gen fail", "err", err)
+	}
+	for _, peers := range [][2]*pssNode{{l, r}, {r, l}} {
+		self, peer := peers[0], peers[1]
+		_, err := self.keys.SetSymmetricKey(self.client, psskeys.SymKey{
+			Name:    symKeyName,
+			Key:     symkey,
+			Topic:   topic,
+			Address: peer.addr,
+		})
+		if err != nil {
+			demo.Log.Crit("pss set symkey fail", "name", self.name, "err", err)
+		}
+	}
+}
+
+// left sends to right with both kinds of keys, and right tells what it got
+func sendBoth(l, r *pssNode, topic string, text string) error {
+	peers := l.keys.Peers()
+	if len(peers) == 0 {
+		return fmt.Errorf("no public key for right")
+	}
+	symkeyid, ok := l.keys.SymKeyID(symKeyName)
+	if !ok {
+		// the id of the key in the last run, which the node forgot when it stopped
+		symkeyid = "0x0000000000000000000000000000000000000000000000000000000000000000"
+	}
+	sends := []struct {
+		method string
+		key    string
+	}{
+		{"pss_sendAsym", peers[0].ID()},
+		{"pss_sendSym", symkeyid},
+	}
+	for _, send := range sends {
+		msg, err := envelope.Wrap(envelope.Raw, fmt.Sprintf("%s (%s)", text, send.method))
+		if err != nil {
+			demo.Log.Crit("wrap message fail", "err", err)
+		}
+		err = l.client.Call(nil, send.method, send.key, topic, common.ToHex(msg))
+		if err != nil {
+			return fmt.Errorf("%s: %v", send.method, err)
+		}
+		v, err := demo.ExpectMsg(r.msgC, nil, recvWait)
+		if err != nil {
+			return fmt.Errorf("%s: nothing received: %v", send.method, err)
+		}
+		var content string
+		if err := envelope.Unwrap(v.(pss.APIMsg).Msg, &content); err != nil {
+			return err
+		}
+		fmt.Printf("  right received %q\n", content)
+	}
+	return nil
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// the nodes need their data across a restart, so it's kept in a temp dir for the run when no data directory is given
+	// with -datadir it's kept for good, and the next run finds the keys of this one
+	if !demo.Persistent() {
+		dir, err := demo.TempDataDir("")
+		if err != nil {
+			demo.Log.Crit("create data dir fail", "err", err)
+		}
+		defer os.RemoveAll(dir)
+		demo.Conf.DataDir = dir
+	}
+
+	var topic string
+	l, r := startNodes(&topic)
+	if len(l.keys.Peers()) == 0 {
+		fmt.Println("no keys kept from before, exchanging them")
+		exchangeKeys(l, r, topic)
+	} else {
+		fmt.Println("keys kept from an earlier run, restoring them")
+		for _, n := range []*pssNode{l, r} {
+			if err := n.keys.Restore(n.client); err != nil {
+				demo.Log.Crit("restore keys fail", "name", n.name, "err", err)
+			}
+		}
+	}
+	if err := sendBoth(l, r, topic, "before the restart"); err != nil {
+		demo.Log.Crit("send fail", "err", err)
+	}
+	oldAddr := l.addr
+	l.stop()
+	r.stop()
+	fmt.Println("stopped both nodes")
+
+	// the same data directories, the same keys, the same addresses
+	l, r = startNodes(&topic)
+	defer l.stop()
+	defer r.stop()
+	if l.addr != oldAddr {
+		demo.Log.Crit("node came back with another address", "before", oldAddr, "after", l.addr)
+	}
+
+	// pss itself remembers nothing from before
+	if err := sendBoth(l, r, topic, "after the restart"); err != nil {
+		fmt.Printf("  before restoring the keys: %v\n", err)
+	} else {
+		demo.Log.Crit("sent without the keys")
+	}
+
+	for _, n := range []*pssNode{l, r} {
+		if err := n.keys.Restore(n.client); err != nil {
+			demo.Log.Crit("restore keys fail", "name", n.name, "err", err)
+		}
+		fmt.Printf("%s restored %d public keys and %d symmetric keys from %s\n", n.name, len(n.keys.Peers()), len(n.keys.SymKeys()), n.stack.InstanceDir())
+	}
+	if err := sendBoth(l, r, topic, "after the restart"); err != nil {
+		demo.Log.Crit("send fail", "err", err)
+	}
+}
@@ -0,0 +1,241 @@
+// the kademlia tables of pss nodes as they find each other, and the bin a message is routed through
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm"
+	bzzapi "github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+
+	demo "./common"
+	"./envelope"
+)
+
+const (
+	nodeCount     = 6
+	joinInterval  = time.Millisecond * 500
+	watchInterval = time.Second
+	discoverWait  = time.Second * 20
+	recvWait      = time.Second * 5
+)
+
+func newService(bzzdir string, bzzport int, bzznetworkid uint64) func(ctx *node.ServiceContext) (node.Service, error) {
+	return func(ctx *node.ServiceContext) (node.Service, error) {
+		privkey, err := demo.LoadOrCreateKey(filepath.Join(bzzdir, "bzzkey"))
+		if err != nil {
+			demo.Log.Crit("private key load servicenode fail", "err", err)
+		}
+		bzzconfig := bzzapi.NewConfig()
+		bzzconfig.Path = bzzdir
+		demo.Conf.Pss.Apply(bzzconfig.Pss)
+		bzzconfig.Init(privkey)
+		bzzconfig.Port = fmt.Sprintf("%d", bzzport)
+		return swarm.NewSwarm(bzzconfig, nil)
+	}
+}
+
+// the number of leading bits the two addresses share, the bin one is in the table of the other
+func proximity(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return len(a) * 8
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	var stacks []*node.Node
+	var clients []*rpc.Client
+	var names []string
+	var addrs [][]byte
+
+	// each node joins through the one before it, and the tables are printed as it does
+	for i := 0; i < nodeCount; i++ {
+		stack, err := demo.NewServiceNode(demo.Conf.P2PPort+i, 0, 0)
+		if err != nil {
+			demo.Log.Crit(err.Error())
+		}
+		err = stack.Register(newService(stack.InstanceDir(), demo.Conf.BzzPort+i, demo.Conf.BzzNetworkId))
+		if err != nil {
+			demo.Log.Crit("servicenode pss register fail", "err", err)
+		}
+		if err := stack.Start(); err != nil {
+			demo.Log.Crit("servicenode start failed", "err", err)
+		}
+		defer demo.RemoveDataDir(stack.DataDir())
+		defer stack.Stop()
+		client, err := stack.Attach()
+		if err != nil {
+			demo.Log.Crit("attach fail", "err", err)
+		}
+		defer client.Close()
+		var addr string
+		err = demo.CallRetry(client, &addr, "pss_baseAddr")
+		if err != nil {
+			demo.Log.Crit("pss get baseaddr fail", "err", err)
+		}
+		if i > 0 {
+			stack.Server().AddPeer(stacks[i-1].Server().Self())
+		}
+		stacks = append(stacks, stack)
+		clients = append(clients, client)
+		names = append(names, fmt.Sprintf("node%d", i))
+		addrs = append(addrs, common.FromHex(addr))
+		time.Sleep(joinInterval)
+		demo.PrintKademlia(os.Stdout, names, clients)
+	}
+
+	// then every second, while the hive of each node connects it to the ones it hears of
+	ctx, cancel := context.WithCancel(context.Background())
+	watchC := make(chan struct{})
+	go func() {
+		demo.WatchKademlia(ctx, os.Stdout, watchInterval, names, clients)
+		close(watchC)
+	}()
+
+	// the network is ready when every node knows all the others
+	err := demo.EventuallyWithin(discoverWait, func() bool {
+		for _, client := range clients {
+			k, err := demo.GetKademlia(client)
+			if err != nil || k.Known < nodeCount-1 {
+				return false
+			}
+		}
+		return true
+	})
+	cancel()
+	<-watchC
+	if err != nil {
+		demo.Log.Crit("nodes did not find each other", "err", err)
+	}
+	fmt.Println("\nall nodes know each other")
+	demo.PrintKademlia(os.Stdout, names, clients)
+
+	// a message from the first node to the last goes to the peers in the bin the last one is in, in the table of the first
+	// or to the nearest neighbours, when that bin is at the depth or beyond
+	from, to := 0, nodeCount-1
+	k, err := demo.GetKademlia(clients[from])
+	if err != nil {
+		demo.Log.Crit("get kademlia fail", "err", err)
+	}
+	po := proximity(addrs[from], addrs[to])
+	if po >= len(k.Bins) {
+		po = len(k.Bins) - 1
+	}
+	bin := k.Bins[po]
+	fmt.Printf("\n%s is in bin %d of %s, with %d connected peers %v", names[to], po, names[from], bin.Connected, bin.Peers)
+	if po >= k.Depth {
+		fmt.Printf(", in its neighbourhood")
+	}
+	fmt.Println()
+
+	var topic string
+	err = demo.CallRetry(clients[from], &topic, "pss_stringToTopic", "foo")
+	if err != nil {
+		demo.Log.Crit("pss string to topic fail", "err", err)
+	}
+	msgC := make(chan pss.APIMsg)
+	// over ipc, since an attached client keeps talking to the services the node had before a restart
+	endpoint := stacks[to].IPCEndpoint()
+	sub, err := demo.Resubscribe(func() (*rpc.Client, error) {
+		return demo.DialRPC(endpoint)
+	}, "pss", msgC, "receive", topic, false, false)
+	if err != nil {
+		demo.Log.Crit("pss subscribe fail", "err", err)
+	}
+	defer sub.Unsubscribe()
+	msg, err := envelope.Wrap(envelope.Raw, "hello through the table")
+	if err != nil {
+		demo.Log.Crit("wrap message fail", "err", err)
+	}
+	var pubkey string
+	err = demo.CallRetry(clients[to], &pubkey, "pss_getPublicKey")
+	if err != nil {
+		demo.Log.Crit("pss get pubkey fail", "err", err)
+	}
+	err = demo.CallRetry(clients[from], nil, "pss_setPeerPublicKey", pubkey, topic, common.ToHex(addrs[to]))
+	if err != nil {
+		demo.Log.Crit("pss set peer pubkey fail", "err", err)
+	}
+	err = demo.CallRetry(clients[from], nil, "pss_sendAsym", pubkey, topic, common.ToHex(msg))
+	if err != nil {
+		demo.Log.Crit("pss send fail", "err", err)
+	}
+	v, err := demo.ExpectMsg(msgC, nil, recvWait)
+	if err != nil {
+		demo.Log.Crit("message not received", "err", err)
+	}
+	var content string
+	if err := envelope.Unwrap(v.(pss.APIMsg).Msg, &content); err != nil {
+		demo.Log.Crit("unwrap message fail", "err", err)
+	}
+	fmt.Printf("%s received %q\n", names[to], content)
+
+	// the last node restarts, and joins again
+	// its subscription is made again by itself, on a new connection
+	if err := stacks[to].Restart(); err != nil {
+		demo.Log.Crit("restart fail", "err", err)
+	}
+	stacks[to].Server().AddPeer(stacks[to-1].Server().Self())
+	err = demo.EventuallyWithin(discoverWait, func() bool {
+		client := sub.Client()
+		if sub.Reconnects() == 0 || client == nil {
+			return false
+		}
+		k, err := demo.GetKademlia(client)
+		return err == nil && k.Connected > 0
+	})
+	if err != nil {
+		demo.Log.Crit("node did not come back", "err", err)
+	}
+	fmt.Printf("%s restarted, subscription made again %d time(s)\n", names[to], sub.Reconnects())
+
+	// without -datadir the node has a new key after the restart, and the first node has to be told it
+	var addr string
+	err = demo.CallRetry(sub.Client(), &addr, "pss_baseAddr")
+	if err != nil {
+		demo.Log.Crit("pss get baseaddr fail", "err", err)
+	}
+	err = demo.CallRetry(sub.Client(), &pubkey, "pss_getPublicKey")
+	if err != nil {
+		demo.Log.Crit("pss get pubkey fail", "err", err)
+	}
+	err = demo.CallRetry(clients[from], nil, "pss_setPeerPublicKey", pubkey, topic, addr)
+	if err != nil {
+		demo.Log.Crit("pss set peer pubkey fail", "err", err)
+	}
+
+	// the first node, not sure the message made it, sends it again, and then another
+	// the one already delivered is dropped by the subscription
+	again, err := envelope.Wrap(envelope.Raw, "hello after the restart")
+	if err != nil {
+		demo.Log.Crit("wrap message fail", "err", err)
+	}
+	for _, m := range [][]byte{msg, again} {
+		err = demo.CallRetry(clients[from], nil, "pss_sendAsym", pubkey, topic, common.ToHex(m))
+		if err != nil {
+			demo.Log.Crit("pss send fail", "err", err)
+		}
+	}
+	v, err = demo.ExpectMsg(msgC, nil, recvWait)
+	if err != nil {
+		demo.Log.Crit("message not received", "err", err)
+	}
+	if err := envelope.Unwrap(v.(pss.APIMsg).Msg, &content); err != nil {
+		demo.Log.Crit("unwrap message fail", "err", err)
+	}
+	fmt.Printf("%s received %q, and dropped %d it had already\n", names[to], content, sub.Duplicates())
+}
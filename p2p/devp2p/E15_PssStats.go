@@ -0,0 +1,212 @@
+// counting the pss messages of each topic, and reading the counts over rpc
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/simulation"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/ethereum/go-ethereum/swarm/state"
+
+	demo "./common"
+)
+
+const (
+	healthTimeout  = time.Second * 10
+	deliverTimeout = time.Second * 10
+)
+
+var (
+	chatTopic = pss.BytesToTopic([]byte("chat"))
+	newsTopic = pss.BytesToTopic([]byte("news"))
+	jobsTopic = pss.BytesToTopic([]byte("jobs"))
+)
+
+// what we need to know about each node
+type simNode struct {
+	ps   *demo.PssStats
+	addr []byte
+	key  *ecdsa.PrivateKey
+}
+
+// the nodes run pss wrapped in the stats, which serves the pssstat namespace along with pss
+func newServices() (map[string]simulation.ServiceFunc, func(enode.ID) *simNode) {
+	var mu sync.Mutex
+	nodes := make(map[enode.ID]*simNode)
+	kademlias := make(map[enode.ID]*network.Kademlia)
+	kademlia := func(id enode.ID) *network.Kademlia {
+		mu.Lock()
+		defer mu.Unlock()
+		if k, ok := kademlias[id]; ok {
+			return k
+		}
+		kademlias[id] = network.NewKademlia(id[:], network.NewKadParams())
+		return kademlias[id]
+	}
+	getNode := func(id enode.ID) *simNode {
+		mu.Lock()
+		defer mu.Unlock()
+		return nodes[id]
+	}
+
+	return map[string]simulation.ServiceFunc{
+		"bzz": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			addr := network.NewAddr(ctx.Config.Node())
+			kad := kademlia(ctx.Config.ID)
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+			config := &network.BzzConfig{
+				OverlayAddr:  addr.Over(),
+				UnderlayAddr: addr.Under(),
+				HiveParams:   network.NewHiveParams(),
+			}
+			return network.NewBzz(config, kad, state.NewInmemoryStore(), nil, nil), nil, nil
+		},
+		"pss": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			key, err := crypto.GenerateKey()
+			if err != nil {
+				return nil, nil, err
+			}
+			kad := kademlia(ctx.Config.ID)
+			ps, err := pss.NewPss(kad, pss.NewPssParams().WithPrivateKey(key))
+			if err != nil {
+				return nil, nil, err
+			}
+			stats := demo.NewPssStats(ps, key)
+			mu.Lock()
+			nodes[ctx.Config.ID] = &simNode{
+				ps:   stats,
+				addr: kad.BaseAddr(),
+				key:  key,
+			}
+			mu.Unlock()
+			return stats, nil, nil
+		},
+	}, getNode
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// three nodes in a row, the messages from the first to the last pass the one in the middle
+	services, getNode := newServices()
+	sim := simulation.New(services)
+	defer sim.Close()
+	ids, err := sim.AddNodesAndConnectChain(3)
+	if err != nil {
+		demo.Log.Crit("create network fail", "err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	_, err = sim.WaitTillHealthy(ctx, 1)
+	cancel()
+	if err != nil {
+		demo.Log.Warn("network not healthy", "err", err)
+	}
+	sender := getNode(ids[0])
+	recipient := getNode(ids[2])
+
+	// at the end every node tells its counts over rpc
+	defer func() {
+		for i, id := range ids {
+			client, err := sim.Net.GetNode(id).Client()
+			if err != nil {
+				demo.Log.Crit("rpc client fail", "err", err)
+			}
+			var stats map[string]demo.PssTopicStats
+			if err := client.Call(&stats, "pssstat_topics"); err != nil {
+				demo.Log.Crit("pssstat fail", "err", err)
+			}
+			fmt.Printf("\nnode %d, %s\n", i, id.TerminalString())
+			demo.WritePssStats(os.Stdout, stats)
+		}
+	}()
+
+	// the recipient takes everything on chat and news, and fails the jobs it doesn't like
+	handle := func(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+		demo.Log.Info("received", "msg", string(msg))
+		return nil
+	}
+	recipient.ps.Register(&chatTopic, handle)
+	recipient.ps.Register(&newsTopic, handle)
+	recipient.ps.Register(&jobsTopic, func(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+		if string(msg) == "bad job" {
+			return errors.New("can't do that")
+		}
+		return handle(msg, p, asymmetric, keyid)
+	})
+
+	// chat and jobs are encrypted with the key of the recipient
+	addr := pss.PssAddress(recipient.addr)
+	pubkey := common.ToHex(crypto.FromECDSAPub(&recipient.key.PublicKey))
+	for _, topic := range []pss.Topic{chatTopic, jobsTopic} {
+		if err := sender.ps.SetPeerPublicKey(&recipient.key.PublicKey, topic, &addr); err != nil {
+			demo.Log.Crit("set public key fail", "err", err)
+		}
+	}
+	// news with a key they share
+	symkey := make([]byte, 32)
+	rand.Read(symkey)
+	symkeyid, err := sender.ps.SetSymmetricKey(symkey, newsTopic, &addr, false)
+	if err != nil {
+		demo.Log.Crit("set symmetric key fail", "err", err)
+	}
+	senderAddr := pss.PssAddress(sender.addr)
+	if _, err := recipient.ps.SetSymmetricKey(symkey, newsTopic, &senderAddr, true); err != nil {
+		demo.Log.Crit("set symmetric key fail", "err", err)
+	}
+	// and a chat message to the address of the recipient, but for a key it doesn't have
+	otherkey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("generate key fail", "err", err)
+	}
+	if err := sender.ps.SetPeerPublicKey(&otherkey.PublicKey, chatTopic, &addr); err != nil {
+		demo.Log.Crit("set public key fail", "err", err)
+	}
+
+	sends := []struct {
+		send func() error
+		what string
+	}{
+		{func() error { return sender.ps.SendAsym(pubkey, chatTopic, []byte("hello")) }, "chat"},
+		{func() error { return sender.ps.SendAsym(pubkey, chatTopic, []byte("how are you")) }, "chat"},
+		{func() error { return sender.ps.SendSym(symkeyid, newsTopic, []byte("pss has stats now")) }, "news"},
+		{func() error { return sender.ps.SendAsym(pubkey, jobsTopic, []byte("good job")) }, "job"},
+		{func() error { return sender.ps.SendAsym(pubkey, jobsTopic, []byte("bad job")) }, "job"},
+		{func() error {
+			return sender.ps.SendAsym(common.ToHex(crypto.FromECDSAPub(&otherkey.PublicKey)), chatTopic, []byte("not for you"))
+		}, "chat for another key"},
+	}
+	for _, s := range sends {
+		if err := s.send(); err != nil {
+			demo.Log.Crit("send fail", "what", s.what, "err", err)
+		}
+	}
+
+	// the recipient handled five, failed one, and couldn't open one
+	err = demo.EventuallyWithin(deliverTimeout, func() bool {
+		var received, handlerErrors, decryptFailed uint64
+		for _, st := range recipient.ps.Stats() {
+			received += st.Received
+			handlerErrors += st.HandlerErrors
+			decryptFailed += st.DecryptFailed
+		}
+		return received == 5 && handlerErrors == 1 && decryptFailed == 1
+	})
+	if err != nil {
+		demo.Log.Crit("messages missing", "err", err)
+	}
+}
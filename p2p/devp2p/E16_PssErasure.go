@@ -0,0 +1,292 @@
+// a payload split into shares held by different peers over pss, and put back together from some of them
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/simulation"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/ethereum/go-ethereum/swarm/state"
+
+	demo "./common"
+	"./erasure"
+)
+
+const (
+	shareCount   = 5 // the payload is split into as many shares as there are holders
+	sharesNeeded = 3 // and any of them give it back
+
+	healthTimeout = time.Second * 10
+	storeTimeout  = time.Second * 10
+	fetchTimeout  = time.Second * 5 // how long the owner waits for the shares, the holders that are gone never answer
+)
+
+var shareTopic = pss.BytesToTopic([]byte("shares"))
+
+// the messages between the owner and the holders of the shares
+const (
+	sharePut    = iota // owner to holder, keep the share
+	shareStored        // holder to owner, it keeps it
+	shareGet           // owner to holder, send the share back
+	shareData          // holder to owner, the share
+)
+
+type shareMsg struct {
+	Code  uint8
+	Key   common.Hash // of the payload
+	Index uint8
+	Size  uint64 // of the payload, the shares are padded
+	Data  []byte
+}
+
+// what we need to know about each node
+type simNode struct {
+	id   enode.ID
+	ps   *pss.Pss
+	addr []byte
+	key  *ecdsa.PrivateKey
+
+	mu     sync.Mutex
+	held   map[common.Hash]*shareMsg // the shares held for the owner
+	stored map[uint8]bool            // by the owner, the holders that said they keep their share
+	got    map[uint8][]byte          // by the owner, the shares that came back
+}
+
+func (self *simNode) pubkey() string {
+	return common.ToHex(crypto.FromECDSAPub(&self.key.PublicKey))
+}
+
+func (self *simNode) send(to string, msg *shareMsg) error {
+	data, err := rlp.EncodeToBytes(msg)
+	if err != nil {
+		return err
+	}
+	return self.ps.SendAsym(to, shareTopic, data)
+}
+
+// the holders keep the shares, and send them back when asked; the owner counts what comes back
+func (self *simNode) handle(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+	var smsg shareMsg
+	if err := rlp.DecodeBytes(msg, &smsg); err != nil {
+		return err
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	switch smsg.Code {
+	case sharePut:
+		self.held[smsg.Key] = &smsg
+		demo.Log.Info("holding share", "node", self.id.TerminalString(), "index", smsg.Index, "size", len(smsg.Data))
+		go self.send(keyid, &shareMsg{Code: shareStored, Key: smsg.Key, Index: smsg.Index})
+	case shareGet:
+		share, ok := self.held[smsg.Key]
+		if !ok {
+			return fmt.Errorf("no share of %x", smsg.Key)
+		}
+		go self.send(keyid, &shareMsg{Code: shareData, Key: share.Key, Index: share.Index, Size: share.Size, Data: share.Data})
+	case shareStored:
+		self.stored[smsg.Index] = true
+	case shareData:
+		self.got[smsg.Index] = smsg.Data
+	default:
+		return fmt.Errorf("unknown share message %d", smsg.Code)
+	}
+	return nil
+}
+
+func newServices() (map[string]simulation.ServiceFunc, func(enode.ID) *simNode) {
+	var mu sync.Mutex
+	nodes := make(map[enode.ID]*simNode)
+	kademlias := make(map[enode.ID]*network.Kademlia)
+	kademlia := func(id enode.ID) *network.Kademlia {
+		mu.Lock()
+		defer mu.Unlock()
+		if k, ok := kademlias[id]; ok {
+			return k
+		}
+		kademlias[id] = network.NewKademlia(id[:], network.NewKadParams())
+		return kademlias[id]
+	}
+	getNode := func(id enode.ID) *simNode {
+		mu.Lock()
+		defer mu.Unlock()
+		return nodes[id]
+	}
+
+	return map[string]simulation.ServiceFunc{
+		"bzz": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			addr := network.NewAddr(ctx.Config.Node())
+			kad := kademlia(ctx.Config.ID)
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+			config := &network.BzzConfig{
+				OverlayAddr:  addr.Over(),
+				UnderlayAddr: addr.Under(),
+				HiveParams:   network.NewHiveParams(),
+			}
+			return network.NewBzz(config, kad, state.NewInmemoryStore(), nil, nil), nil, nil
+		},
+		"pss": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			key, err := crypto.GenerateKey()
+			if err != nil {
+				return nil, nil, err
+			}
+			kad := kademlia(ctx.Config.ID)
+			ps, err := pss.NewPss(kad, pss.NewPssParams().WithPrivateKey(key))
+			if err != nil {
+				return nil, nil, err
+			}
+			n := &simNode{
+				id:     ctx.Config.ID,
+				ps:     ps,
+				addr:   kad.BaseAddr(),
+				key:    key,
+				held:   make(map[common.Hash]*shareMsg),
+				stored: make(map[uint8]bool),
+				got:    make(map[uint8][]byte),
+			}
+			ps.Register(&shareTopic, pss.NewHandler(n.handle))
+			mu.Lock()
+			nodes[ctx.Config.ID] = n
+			mu.Unlock()
+			return ps, nil, nil
+		},
+	}, getNode
+}
+
+// asks all the holders for their shares, and puts the payload back together from the first that come
+// the holders that are gone don't answer, the payload comes back as long as enough of them do
+func fetch(owner *simNode, holders []*simNode, coder *erasure.Coder, key common.Hash, size int) ([]byte, []uint8, error) {
+	owner.mu.Lock()
+	owner.got = make(map[uint8][]byte)
+	owner.mu.Unlock()
+	for _, h := range holders {
+		if err := owner.send(h.pubkey(), &shareMsg{Code: shareGet, Key: key}); err != nil {
+			demo.Log.Warn("get fail", "holder", h.id.TerminalString(), "err", err)
+		}
+	}
+	demo.EventuallyWithin(fetchTimeout, func() bool {
+		owner.mu.Lock()
+		defer owner.mu.Unlock()
+		return len(owner.got) >= coder.Needed()
+	})
+	var used []uint8
+	owner.mu.Lock()
+	shares := make([][]byte, coder.Total())
+	for i, data := range owner.got {
+		shares[i] = data
+		used = append(used, i)
+	}
+	owner.mu.Unlock()
+	sort.Slice(used, func(i, j int) bool { return used[i] < used[j] })
+
+	payload, err := coder.Decode(shares, size)
+	if err != nil {
+		return nil, used, err
+	}
+	// the shares can't tell if they were changed, the hash of the payload does
+	if crypto.Keccak256Hash(payload) != key {
+		return nil, used, fmt.Errorf("payload doesn't match its hash")
+	}
+	return payload, used, nil
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// the owner and the holders all connected, so stopping holders leaves the others a way to the owner
+	services, getNode := newServices()
+	sim := simulation.New(services)
+	defer sim.Close()
+	ids, err := sim.AddNodesAndConnectFull(shareCount + 1)
+	if err != nil {
+		demo.Log.Crit("create network fail", "err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	_, err = sim.WaitTillHealthy(ctx, 1)
+	cancel()
+	if err != nil {
+		demo.Log.Warn("network not healthy", "err", err)
+	}
+	owner := getNode(ids[0])
+	var holders []*simNode
+	for _, id := range ids[1:] {
+		holders = append(holders, getNode(id))
+	}
+
+	// the owner and each holder can send to each other
+	ownerAddr := pss.PssAddress(owner.addr)
+	for _, h := range holders {
+		addr := pss.PssAddress(h.addr)
+		if err := owner.ps.SetPeerPublicKey(&h.key.PublicKey, shareTopic, &addr); err != nil {
+			demo.Log.Crit("set public key fail", "err", err)
+		}
+		if err := h.ps.SetPeerPublicKey(&owner.key.PublicKey, shareTopic, &ownerAddr); err != nil {
+			demo.Log.Crit("set public key fail", "err", err)
+		}
+	}
+
+	// the payload split into a share for each holder, any three of which give it back
+	coder, err := erasure.New(sharesNeeded, shareCount)
+	if err != nil {
+		demo.Log.Crit("coder fail", "err", err)
+	}
+	payload := bytes.Repeat([]byte("a payload no one peer holds all of. "), 100)
+	key := crypto.Keccak256Hash(payload)
+	shares := coder.Encode(payload)
+	for i, h := range holders {
+		err := owner.send(h.pubkey(), &shareMsg{Code: sharePut, Key: key, Index: uint8(i), Size: uint64(len(payload)), Data: shares[i]})
+		if err != nil {
+			demo.Log.Crit("put fail", "holder", i, "err", err)
+		}
+	}
+	err = demo.EventuallyWithin(storeTimeout, func() bool {
+		owner.mu.Lock()
+		defer owner.mu.Unlock()
+		return len(owner.stored) == shareCount
+	})
+	if err != nil {
+		demo.Log.Crit("shares not stored", "err", err)
+	}
+	fmt.Printf("payload of %d bytes in %d shares of %d bytes, any %d of them give it back\n", len(payload), shareCount, coder.ShareSize(len(payload)), sharesNeeded)
+
+	// two holders go away, the payload comes back from the other three
+	for _, i := range []int{1, 3} {
+		if err := sim.Net.Stop(holders[i].id); err != nil {
+			demo.Log.Crit("stop fail", "err", err)
+		}
+		fmt.Printf("holder %d of share %d stopped\n", i+1, i)
+	}
+	got, used, err := fetch(owner, holders, coder, key, len(payload))
+	if err != nil {
+		demo.Log.Crit("fetch fail", "shares", used, "err", err)
+	}
+	if !bytes.Equal(got, payload) {
+		demo.Log.Crit("wrong payload")
+	}
+	fmt.Printf("payload back from shares %v\n", used)
+
+	// with a third gone there are too few
+	if err := sim.Net.Stop(holders[4].id); err != nil {
+		demo.Log.Crit("stop fail", "err", err)
+	}
+	fmt.Printf("holder 5 of share 4 stopped\n")
+	_, used, err = fetch(owner, holders, coder, key, len(payload))
+	if err != erasure.ErrTooFewShares {
+		demo.Log.Crit("payload back from too few shares", "shares", used, "err", err)
+	}
+	fmt.Printf("payload lost with shares %v: %v\n", used, err)
+}
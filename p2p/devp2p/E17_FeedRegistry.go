@@ -0,0 +1,337 @@
+// a registry of services kept in swarm feeds: providers publish what they serve, consumers read it, and leave out what wasn't updated in a while
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm"
+	bzzapi "github.com/ethereum/go-ethereum/swarm/api"
+	bzzclient "github.com/ethereum/go-ethereum/swarm/api/client"
+	"github.com/ethereum/go-ethereum/swarm/storage/feed"
+	"github.com/ethereum/go-ethereum/swarm/storage/feed/lookup"
+
+	demo "./common"
+)
+
+const (
+	registryName    = "service-registry" // the topic of the feeds of the registry, which every node knows
+	providerCount   = 3
+	publishInterval = time.Second * 2     // how often a provider publishes its descriptor, with its load
+	staleAfter      = publishInterval * 3 // a descriptor not updated for as long is taken for a provider that's gone
+	discoverTimeout = time.Second * 20
+)
+
+var (
+	// what each provider serves
+	providerTopics = [][]string{
+		{"chat", "files"},
+		{"chat"},
+		{"files"},
+	}
+)
+
+// Descriptor is what a provider publishes of itself in its feed
+type Descriptor struct {
+	Enode   string   `json:"enode"`
+	Topics  []string `json:"topics"`
+	Load    int      `json:"load"`    // jobs in hand
+	Updated int64    `json:"updated"` // unix time
+}
+
+// Members is what the registrar publishes in its feed, the accounts whose feeds are in the registry
+type Members struct {
+	Accounts []common.Address `json:"accounts"`
+}
+
+// what a consumer made of a member of the registry
+type entry struct {
+	account common.Address
+	desc    *Descriptor
+	err     error
+	stale   bool
+}
+
+func newService(bzzdir string, bzzport int) func(ctx *node.ServiceContext) (node.Service, error) {
+	return func(ctx *node.ServiceContext) (node.Service, error) {
+		privkey, err := demo.LoadOrCreateKey(filepath.Join(bzzdir, "bzzkey"))
+		if err != nil {
+			demo.Log.Crit("private key load servicenode fail", "err", err)
+		}
+		bzzconfig := bzzapi.NewConfig()
+		bzzconfig.Path = bzzdir
+		demo.Conf.Pss.Apply(bzzconfig.Pss)
+		bzzconfig.Init(privkey)
+		bzzconfig.Port = fmt.Sprintf("%d", bzzport)
+		return swarm.NewSwarm(bzzconfig, nil)
+	}
+}
+
+// publishes the value as the next update of the signer's feed on the topic
+func publish(bzz *bzzclient.Client, signer *feed.GenericSigner, topic feed.Topic, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	// the node works out the epoch of the next update from the last one
+	query := feed.NewQueryLatest(&feed.Feed{Topic: topic, User: signer.Address()}, lookup.NoClue)
+	req, err := bzz.GetFeedRequest(query, "")
+	if err != nil {
+		return err
+	}
+	req.SetData(data)
+	if err := req.Sign(signer); err != nil {
+		return err
+	}
+	return bzz.UpdateFeed(req)
+}
+
+// reads the latest update of the user's feed on the topic into the value
+func read(bzz *bzzclient.Client, user common.Address, topic feed.Topic, v interface{}) error {
+	r, err := bzz.QueryFeed(feed.NewQueryLatest(&feed.Feed{Topic: topic, User: user}, lookup.NoClue), "")
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// the provider publishes its descriptor until it's stopped, with the load it has at the time
+func provide(bzz *bzzclient.Client, key *ecdsa.PrivateKey, topic feed.Topic, self *enode.Node, topics []string, load int, quitC chan struct{}) {
+	signer := feed.NewGenericSigner(key)
+	for n := 0; ; n++ {
+		desc := &Descriptor{
+			Enode:   self.String(),
+			Topics:  topics,
+			Load:    (load + n*7) % 10,
+			Updated: time.Now().Unix(),
+		}
+		if err := publish(bzz, signer, topic, desc); err != nil {
+			demo.Log.Warn("publish fail", "err", err)
+		}
+		select {
+		case <-quitC:
+			return
+		case <-time.After(publishInterval):
+		}
+	}
+}
+
+// the consumer reads the members of the registry from the registrar's feed, then the descriptor in the feed of each
+// a descriptor is only taken if its enode is of the key that signed the feed, so no one can pass for another provider
+func discover(bzz *bzzclient.Client, registrar common.Address, topic feed.Topic) ([]*entry, error) {
+	var members Members
+	if err := read(bzz, registrar, topic, &members); err != nil {
+		return nil, err
+	}
+	var entries []*entry
+	for _, account := range members.Accounts {
+		e := &entry{account: account}
+		entries = append(entries, e)
+		var desc Descriptor
+		if e.err = read(bzz, account, topic, &desc); e.err != nil {
+			continue
+		}
+		n, err := enode.ParseV4(desc.Enode)
+		if err != nil {
+			e.err = err
+			continue
+		}
+		if crypto.PubkeyToAddress(*n.Pubkey()) != account {
+			e.err = fmt.Errorf("enode %s not of the feed's owner", n.ID().TerminalString())
+			continue
+		}
+		e.desc = &desc
+		e.stale = time.Since(time.Unix(desc.Updated, 0)) > staleAfter
+	}
+	return entries, nil
+}
+
+// the providers of the service that are up to date, the least loaded first
+func providers(entries []*entry, service string) []*entry {
+	var found []*entry
+	for _, e := range entries {
+		if e.desc == nil || e.stale {
+			continue
+		}
+		for _, t := range e.desc.Topics {
+			if t == service {
+				found = append(found, e)
+			}
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].desc.Load < found[j].desc.Load })
+	return found
+}
+
+func show(entries []*entry, names map[common.Address]string) {
+	for _, e := range entries {
+		switch {
+		case e.err != nil:
+			fmt.Printf("  %s: %v\n", names[e.account], e.err)
+		case e.stale:
+			fmt.Printf("  %s: stale, updated %v ago\n", names[e.account], time.Since(time.Unix(e.desc.Updated, 0)).Round(time.Second))
+		default:
+			fmt.Printf("  %s: %s, load %d\n", names[e.account], strings.Join(e.desc.Topics, " "), e.desc.Load)
+		}
+	}
+	for _, service := range []string{"chat", "files"} {
+		var found []string
+		for _, e := range providers(entries, service) {
+			found = append(found, names[e.account])
+		}
+		fmt.Printf("  %s served by %v\n", service, found)
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// the registrar, the providers and the consumer, each with a swarm node serving the http api on a port of its own
+	var stacks []*node.Node
+	var rpcclients []*rpc.Client
+	for i := 0; i < providerCount+2; i++ {
+		stack, err := demo.NewServiceNode(demo.Conf.P2PPort+i, 0, 0)
+		if err != nil {
+			demo.Log.Crit(err.Error())
+		}
+		err = stack.Register(newService(stack.InstanceDir(), demo.Conf.BzzPort+i))
+		if err != nil {
+			demo.Log.Crit("servicenode swarm register fail", "err", err)
+		}
+		err = stack.Start()
+		if err != nil {
+			demo.Log.Crit("servicenode start failed", "err", err)
+		}
+		defer demo.RemoveDataDir(stack.DataDir())
+		defer stack.Stop()
+		stacks = append(stacks, stack)
+	}
+	for _, stack := range stacks[1:] {
+		stack.Server().AddPeer(stacks[0].Server().Self())
+	}
+	for _, stack := range stacks {
+		rpcclient, err := stack.Attach()
+		if err != nil {
+			demo.Log.Crit("rpc attach fail", "err", err)
+		}
+		defer rpcclient.Close()
+		rpcclients = append(rpcclients, rpcclient)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := demo.WaitHealthy(ctx, 2, rpcclients...); err != nil {
+		demo.Log.Warn("health check fail", "err", err)
+	}
+	time.Sleep(time.Second)
+
+	bzz := func(i int) *bzzclient.Client {
+		return bzzclient.NewClient(fmt.Sprintf("http://localhost:%d", demo.Conf.BzzPort+i))
+	}
+	topic, err := feed.NewTopic(registryName, nil)
+	if err != nil {
+		demo.Log.Crit("topic fail", "err", err)
+	}
+
+	// the feeds of the providers are signed with the keys of their nodes, so their enodes tell who they are
+	// and the registry has two more members: one that never publishes, and one that passes for the first provider
+	registrarKey, err := crypto.GenerateKey()
+	if err != nil {
+		demo.Log.Crit("generate key fail", "err", err)
+	}
+	absentKey, _ := crypto.GenerateKey()
+	impostorKey, _ := crypto.GenerateKey()
+	names := map[common.Address]string{
+		crypto.PubkeyToAddress(absentKey.PublicKey):   "absent",
+		crypto.PubkeyToAddress(impostorKey.PublicKey): "impostor",
+	}
+	var members Members
+	for i, stack := range stacks[1 : providerCount+1] {
+		addr := crypto.PubkeyToAddress(stack.Server().PrivateKey.PublicKey)
+		names[addr] = fmt.Sprintf("provider%d", i)
+		members.Accounts = append(members.Accounts, addr)
+	}
+	members.Accounts = append(members.Accounts, crypto.PubkeyToAddress(absentKey.PublicKey), crypto.PubkeyToAddress(impostorKey.PublicKey))
+	if err := publish(bzz(0), feed.NewGenericSigner(registrarKey), topic, &members); err != nil {
+		demo.Log.Crit("publish members fail", "err", err)
+	}
+	impostor := &Descriptor{Enode: stacks[1].Server().Self().String(), Topics: []string{"chat", "files"}, Updated: time.Now().Unix()}
+	if err := publish(bzz(0), feed.NewGenericSigner(impostorKey), topic, impostor); err != nil {
+		demo.Log.Crit("publish impostor fail", "err", err)
+	}
+
+	var wg sync.WaitGroup
+	quitCs := make([]chan struct{}, providerCount)
+	for i, stack := range stacks[1 : providerCount+1] {
+		quitCs[i] = make(chan struct{})
+		wg.Add(1)
+		go func(i int, stack *node.Node) {
+			defer wg.Done()
+			provide(bzz(i+1), stack.Server().PrivateKey, topic, stack.Server().Self(), providerTopics[i], i*4, quitCs[i])
+		}(i, stack)
+	}
+	stopped := make([]bool, providerCount)
+	stop := func(i int) {
+		if !stopped[i] {
+			close(quitCs[i])
+			stopped[i] = true
+		}
+	}
+	defer func() {
+		for i := range quitCs {
+			stop(i)
+		}
+		wg.Wait()
+	}()
+
+	// the consumer finds the providers through its own swarm node
+	registrar := crypto.PubkeyToAddress(registrarKey.PublicKey)
+	consumer := bzz(providerCount + 1)
+	var entries []*entry
+	err = demo.EventuallyWithin(discoverTimeout, func() bool {
+		entries, err = discover(consumer, registrar, topic)
+		if err != nil {
+			return false
+		}
+		return len(providers(entries, "chat")) == 2 && len(providers(entries, "files")) == 2
+	})
+	if err != nil {
+		demo.Log.Crit("providers not found", "err", err)
+	}
+	fmt.Printf("registry of %d members:\n", len(entries))
+	show(entries, names)
+
+	// the last provider stops publishing, and the consumer leaves it out once its descriptor is stale
+	stop(providerCount - 1)
+	fmt.Printf("%s stops publishing\n", names[members.Accounts[providerCount-1]])
+	err = demo.EventuallyWithin(discoverTimeout, func() bool {
+		entries, err = discover(consumer, registrar, topic)
+		if err != nil {
+			return false
+		}
+		return len(providers(entries, "files")) == 1
+	})
+	if err != nil {
+		demo.Log.Crit("stale provider not left out", "err", err)
+	}
+	fmt.Printf("registry once %s is stale:\n", names[members.Accounts[providerCount-1]])
+	show(entries, names)
+}
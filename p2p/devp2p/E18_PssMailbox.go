@@ -0,0 +1,577 @@
+// a mailbox that keeps pss messages for recipients that are offline, and hands them over when the recipient asks for its mail
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/simulation"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/ethereum/go-ethereum/swarm/state"
+
+	demo "./common"
+)
+
+const (
+	maxMessages  = 3                // the mailbox keeps as many messages for each recipient
+	maxBytes     = 4096             // and as many bytes
+	maxTTL       = time.Minute      // and for as long at most
+	shortTTL     = time.Second * 3  // of a message that is gone before the recipient is back
+	ackTimeout   = time.Second      // how long a sender waits for the recipient to ack, before it leaves the message in the mailbox
+	mailTimeout  = time.Second * 10 // for the mailbox to answer
+	fetchTimeout = time.Second * 2  // how long a recipient waits for the mailbox to answer a fetch, before it asks again
+)
+
+var mailTopic = pss.BytesToTopic([]byte("mailbox"))
+
+// the messages between the senders, the recipients and the mailbox
+const (
+	mailDirect    = iota // sender to recipient, the message
+	mailAck              // recipient to sender, it got the message
+	mailDeposit          // sender to mailbox, keep the message for the recipient
+	mailDeposited        // mailbox to sender, kept, or why not
+	mailFetch            // recipient to mailbox, send my mail
+	mailDeliver          // mailbox to recipient, a message kept for it
+	mailDone             // mailbox to recipient, that was all
+	mailDelete           // recipient to mailbox, got these, drop them
+)
+
+type mailMsg struct {
+	Code   uint8
+	Seq    uint64   // of a message, or of a fetch, which the mailbox takes once
+	To     []byte   // public key of the recipient of a deposit
+	Addr   []byte   // overlay address to answer to
+	TTL    uint32   // seconds the mailbox keeps a deposit
+	Sealed []byte   // the letter, which only the recipient can open
+	IDs    []uint64 // of the messages delivered and to drop
+	Count  uint32   // of the messages delivered
+	Err    string
+}
+
+// what is sealed for the recipient, signed by the sender so the mailbox can't pass it for another's
+type letter struct {
+	Body      []byte
+	Signature []byte
+}
+
+func letterHash(to *ecdsa.PublicKey, body []byte) []byte {
+	return crypto.Keccak256([]byte("\x19mail:"), crypto.FromECDSAPub(to), body)
+}
+
+func seal(from *ecdsa.PrivateKey, to *ecdsa.PublicKey, body []byte) ([]byte, error) {
+	sig, err := crypto.Sign(letterHash(to, body), from)
+	if err != nil {
+		return nil, err
+	}
+	data, err := rlp.EncodeToBytes(&letter{Body: body, Signature: sig})
+	if err != nil {
+		return nil, err
+	}
+	return ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(to), data, nil, nil)
+}
+
+// the body of the letter, and who signed it
+func open(key *ecdsa.PrivateKey, sealed []byte) ([]byte, *ecdsa.PublicKey, error) {
+	data, err := ecies.ImportECDSA(key).Decrypt(sealed, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var l letter
+	if err := rlp.DecodeBytes(data, &l); err != nil {
+		return nil, nil, err
+	}
+	from, err := crypto.SigToPub(letterHash(&key.PublicKey, l.Body), l.Signature)
+	if err != nil {
+		return nil, nil, err
+	}
+	return l.Body, from, nil
+}
+
+// a message in the mailbox
+type stored struct {
+	id      uint64
+	sealed  []byte
+	expires time.Time
+}
+
+// the mail of a recipient
+type box struct {
+	msgs    []*stored
+	lastSeq uint64 // of the fetches, a fetch that isn't newer is a replay
+}
+
+func (self *box) size() (n int) {
+	for _, m := range self.msgs {
+		n += len(m.sealed)
+	}
+	return n
+}
+
+// drops the messages that expired
+func (self *box) expire(now time.Time) (dropped int) {
+	var kept []*stored
+	for _, m := range self.msgs {
+		if now.Before(m.expires) {
+			kept = append(kept, m)
+		} else {
+			dropped++
+		}
+	}
+	self.msgs = kept
+	return dropped
+}
+
+// what we need to know about each node
+type simNode struct {
+	name string
+	id   enode.ID
+	ps   *pss.Pss
+	addr []byte
+	key  *ecdsa.PrivateKey
+
+	mu sync.Mutex
+	// the mailbox
+	boxes   map[string]*box // by the public key of the recipient
+	nextID  uint64
+	expired int
+	// the senders and recipients
+	seq     uint64
+	ackC    map[uint64]chan *mailMsg // waiting for an ack of the recipient, or the mailbox
+	inbox   []string
+	fetched map[uint64][]byte // delivered by the mailbox in the current fetch, by id
+	doneC   chan *mailMsg
+}
+
+func (self *simNode) pubkey() string {
+	return common.ToHex(crypto.FromECDSAPub(&self.key.PublicKey))
+}
+
+func (self *simNode) send(to string, msg *mailMsg) error {
+	data, err := rlp.EncodeToBytes(msg)
+	if err != nil {
+		return err
+	}
+	return self.ps.SendAsym(to, mailTopic, data)
+}
+
+// the answers go to the key that signed the message, at the address it gave
+func (self *simNode) answer(keyid string, addr []byte, msg *mailMsg) {
+	pub, err := crypto.UnmarshalPubkey(common.FromHex(keyid))
+	if err != nil {
+		demo.Log.Warn("bad public key", "err", err)
+		return
+	}
+	a := pss.PssAddress(addr)
+	if err := self.ps.SetPeerPublicKey(pub, mailTopic, &a); err != nil {
+		demo.Log.Warn("set public key fail", "err", err)
+		return
+	}
+	if err := self.send(keyid, msg); err != nil {
+		demo.Log.Warn("answer fail", "node", self.name, "err", err)
+	}
+}
+
+// pss hands us the public key of the signer of the message as keyid, which is how the mailbox knows whose mail is asked for
+func (self *simNode) handle(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+	var mmsg mailMsg
+	if err := rlp.DecodeBytes(msg, &mmsg); err != nil {
+		return err
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	switch mmsg.Code {
+
+	// the recipient
+	case mailDirect:
+		body, _, err := open(self.key, mmsg.Sealed)
+		if err != nil {
+			return err
+		}
+		self.inbox = append(self.inbox, string(body))
+		go self.answer(keyid, mmsg.Addr, &mailMsg{Code: mailAck, Seq: mmsg.Seq, Addr: self.addr})
+	case mailDeliver:
+		if self.fetched != nil {
+			self.fetched[mmsg.Seq] = mmsg.Sealed
+		}
+	case mailDone:
+		if self.doneC != nil {
+			self.doneC <- &mmsg
+		}
+
+	// the sender
+	case mailAck, mailDeposited:
+		if c, ok := self.ackC[mmsg.Seq]; ok {
+			c <- &mmsg
+			delete(self.ackC, mmsg.Seq)
+		}
+
+	// the mailbox
+	case mailDeposit:
+		to := common.ToHex(mmsg.To)
+		b, ok := self.boxes[to]
+		if !ok {
+			b = &box{}
+			self.boxes[to] = b
+		}
+		self.expired += b.expire(time.Now())
+		ack := &mailMsg{Code: mailDeposited, Seq: mmsg.Seq}
+		ttl := time.Duration(mmsg.TTL) * time.Second
+		if ttl > maxTTL {
+			ttl = maxTTL
+		}
+		if len(b.msgs) >= maxMessages || b.size()+len(mmsg.Sealed) > maxBytes {
+			ack.Err = "mailbox full"
+		} else {
+			self.nextID++
+			b.msgs = append(b.msgs, &stored{id: self.nextID, sealed: mmsg.Sealed, expires: time.Now().Add(ttl)})
+		}
+		demo.Log.Info("deposit", "to", to[:10], "kept", ack.Err == "", "messages", len(b.msgs))
+		go self.answer(keyid, mmsg.Addr, ack)
+	case mailFetch:
+		b, ok := self.boxes[keyid]
+		if !ok {
+			b = &box{}
+			self.boxes[keyid] = b
+		}
+		if mmsg.Seq <= b.lastSeq {
+			go self.answer(keyid, mmsg.Addr, &mailMsg{Code: mailDone, Seq: mmsg.Seq, Err: "fetch replayed"})
+			return nil
+		}
+		b.lastSeq = mmsg.Seq
+		self.expired += b.expire(time.Now())
+		msgs := append([]*stored{}, b.msgs...)
+		go func() {
+			for _, m := range msgs {
+				self.answer(keyid, mmsg.Addr, &mailMsg{Code: mailDeliver, Seq: m.id, Sealed: m.sealed})
+			}
+			self.answer(keyid, mmsg.Addr, &mailMsg{Code: mailDone, Seq: mmsg.Seq, Count: uint32(len(msgs))})
+		}()
+	case mailDelete:
+		// only the messages the recipient says it got are dropped, a fetch that doesn't make it loses nothing
+		if b, ok := self.boxes[keyid]; ok {
+			drop := make(map[uint64]bool)
+			for _, id := range mmsg.IDs {
+				drop[id] = true
+			}
+			var kept []*stored
+			for _, m := range b.msgs {
+				if !drop[m.id] {
+					kept = append(kept, m)
+				}
+			}
+			b.msgs = kept
+		}
+	default:
+		return fmt.Errorf("unknown mail message %d", mmsg.Code)
+	}
+	return nil
+}
+
+// sends the message to the recipient, and leaves it in the mailbox if the recipient doesn't ack it
+func (self *simNode) mail(to *simNode, mailbox *simNode, body string, ttl time.Duration) (string, error) {
+	sealed, err := seal(self.key, &to.key.PublicKey, []byte(body))
+	if err != nil {
+		return "", err
+	}
+	ack, err := self.request(to.pubkey(), &mailMsg{Code: mailDirect, Addr: self.addr, Sealed: sealed}, ackTimeout)
+	if err == nil && ack.Code == mailAck {
+		return "delivered", nil
+	}
+	ack, err = self.request(mailbox.pubkey(), &mailMsg{Code: mailDeposit, Addr: self.addr, To: crypto.FromECDSAPub(&to.key.PublicKey), TTL: uint32(ttl / time.Second), Sealed: sealed}, mailTimeout)
+	if err != nil {
+		return "", err
+	}
+	if ack.Err != "" {
+		return "", errors.New(ack.Err)
+	}
+	return "left in the mailbox", nil
+}
+
+func (self *simNode) request(to string, msg *mailMsg, timeout time.Duration) (*mailMsg, error) {
+	c := make(chan *mailMsg, 1)
+	self.mu.Lock()
+	self.seq++
+	msg.Seq = self.seq
+	self.ackC[msg.Seq] = c
+	self.mu.Unlock()
+	defer func() {
+		self.mu.Lock()
+		delete(self.ackC, msg.Seq)
+		self.mu.Unlock()
+	}()
+	if err := self.send(to, msg); err != nil {
+		return nil, err
+	}
+	select {
+	case ack := <-c:
+		return ack, nil
+	case <-time.After(timeout):
+		return nil, errors.New("no answer")
+	}
+}
+
+// asks the mailbox for the mail, opens it, and tells the mailbox to drop what it got
+// the seq of the fetch goes up every time, the mailbox takes none it has seen
+func (self *simNode) fetch(mailbox *simNode, seq uint64) ([]string, error) {
+	doneC := make(chan *mailMsg, 1)
+	self.mu.Lock()
+	self.fetched = make(map[uint64][]byte)
+	self.doneC = doneC
+	self.mu.Unlock()
+	if err := self.send(mailbox.pubkey(), &mailMsg{Code: mailFetch, Seq: seq, Addr: self.addr}); err != nil {
+		return nil, err
+	}
+	var done *mailMsg
+	select {
+	case done = <-doneC:
+	case <-time.After(fetchTimeout):
+		return nil, errors.New("no answer")
+	}
+	if done.Err != "" {
+		return nil, errors.New(done.Err)
+	}
+	// the deliveries may come after the done
+	err := demo.EventuallyWithin(mailTimeout, func() bool {
+		self.mu.Lock()
+		defer self.mu.Unlock()
+		return len(self.fetched) >= int(done.Count)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%d of %d messages delivered", len(self.fetched), done.Count)
+	}
+
+	self.mu.Lock()
+	fetched := self.fetched
+	self.fetched, self.doneC = nil, nil
+	self.mu.Unlock()
+	var bodies []string
+	var ids []uint64
+	for id, sealed := range fetched {
+		body, from, err := open(self.key, sealed)
+		if err != nil {
+			demo.Log.Warn("open fail", "id", id, "err", err)
+			continue
+		}
+		bodies = append(bodies, fmt.Sprintf("%s (from %x)", body, crypto.PubkeyToAddress(*from).Bytes()[:4]))
+		ids = append(ids, id)
+	}
+	return bodies, self.send(mailbox.pubkey(), &mailMsg{Code: mailDelete, IDs: ids, Addr: self.addr})
+}
+
+func newServices() (map[string]simulation.ServiceFunc, func(enode.ID) *simNode) {
+	var mu sync.Mutex
+	nodes := make(map[enode.ID]*simNode)
+	// the keys outlive a restart of the node, the kademlia is made anew
+	// by whichever of the two services starts first, and taken by the other, as they start in no set order
+	keys := make(map[enode.ID]*ecdsa.PrivateKey)
+	kademlias := make(map[enode.ID]*network.Kademlia)
+	kademlia := func(id enode.ID, addr *network.BzzAddr) *network.Kademlia {
+		if kad, ok := kademlias[id]; ok {
+			delete(kademlias, id)
+			return kad
+		}
+		kad := network.NewKademlia(addr.Over(), network.NewKadParams())
+		kademlias[id] = kad
+		return kad
+	}
+	getNode := func(id enode.ID) *simNode {
+		mu.Lock()
+		defer mu.Unlock()
+		return nodes[id]
+	}
+
+	return map[string]simulation.ServiceFunc{
+		"bzz": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			addr := network.NewAddr(ctx.Config.Node())
+			mu.Lock()
+			kad := kademlia(ctx.Config.ID, addr)
+			mu.Unlock()
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+			config := &network.BzzConfig{
+				OverlayAddr:  addr.Over(),
+				UnderlayAddr: addr.Under(),
+				HiveParams:   network.NewHiveParams(),
+			}
+			return network.NewBzz(config, kad, state.NewInmemoryStore(), nil, nil), nil, nil
+		},
+		"pss": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			mu.Lock()
+			key, ok := keys[ctx.Config.ID]
+			if !ok {
+				var err error
+				if key, err = crypto.GenerateKey(); err != nil {
+					mu.Unlock()
+					return nil, nil, err
+				}
+				keys[ctx.Config.ID] = key
+			}
+			kad := kademlia(ctx.Config.ID, network.NewAddr(ctx.Config.Node()))
+			var name string
+			if n, ok := nodes[ctx.Config.ID]; ok {
+				name = n.name
+			}
+			mu.Unlock()
+			ps, err := pss.NewPss(kad, pss.NewPssParams().WithPrivateKey(key))
+			if err != nil {
+				return nil, nil, err
+			}
+			n := &simNode{
+				name:  name,
+				id:    ctx.Config.ID,
+				ps:    ps,
+				addr:  kad.BaseAddr(),
+				key:   key,
+				boxes: make(map[string]*box),
+				ackC:  make(map[uint64]chan *mailMsg),
+			}
+			ps.Register(&mailTopic, pss.NewHandler(n.handle))
+			mu.Lock()
+			nodes[ctx.Config.ID] = n
+			mu.Unlock()
+			return ps, nil, nil
+		},
+	}, getNode
+}
+
+// each node knows the keys and addresses of the others, as if they were in each other's address book
+func introduce(nodes ...*simNode) {
+	for _, a := range nodes {
+		for _, b := range nodes {
+			if a == b {
+				continue
+			}
+			addr := pss.PssAddress(b.addr)
+			if err := a.ps.SetPeerPublicKey(&b.key.PublicKey, mailTopic, &addr); err != nil {
+				demo.Log.Crit("set public key fail", "err", err)
+			}
+		}
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	services, getNode := newServices()
+	sim := simulation.New(services)
+	defer sim.Close()
+	ids, err := sim.AddNodesAndConnectFull(3)
+	if err != nil {
+		demo.Log.Crit("create network fail", "err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	_, err = sim.WaitTillHealthy(ctx, 1)
+	cancel()
+	if err != nil {
+		demo.Log.Warn("network not healthy", "err", err)
+	}
+	mailbox, alice, bob := getNode(ids[0]), getNode(ids[1]), getNode(ids[2])
+	mailbox.name, alice.name, bob.name = "mailbox", "alice", "bob"
+	introduce(mailbox, alice, bob)
+
+	// bob is online, and acks the message himself
+	how, err := alice.mail(bob, mailbox, "hello bob", maxTTL)
+	if err != nil {
+		demo.Log.Crit("mail fail", "err", err)
+	}
+	fmt.Printf("alice to bob, online: %s\n", how)
+
+	// bob goes offline, and the mailbox keeps what it has room for, for as long as it was asked to
+	if err := sim.Net.Stop(bob.id); err != nil {
+		demo.Log.Crit("stop fail", "err", err)
+	}
+	fmt.Printf("bob goes offline\n")
+	for _, m := range []struct {
+		body string
+		ttl  time.Duration
+	}{
+		{"are you there?", maxTTL},
+		{"call me", maxTTL},
+		{"gone soon", shortTTL},
+		{"one too many", maxTTL},
+	} {
+		how, err := alice.mail(bob, mailbox, m.body, m.ttl)
+		if err != nil {
+			how = err.Error()
+		}
+		fmt.Printf("alice to bob, %q for %v: %s\n", m.body, m.ttl, how)
+	}
+	time.Sleep(shortTTL)
+
+	// bob comes back with the same key, and asks for his mail
+	if err := sim.Net.Start(bob.id); err != nil {
+		demo.Log.Crit("start fail", "err", err)
+	}
+	// bob dials the others himself, as they dialed him in vain while he was down, and won't again for a while
+	client, err := sim.Net.GetNode(bob.id).Client()
+	if err != nil {
+		demo.Log.Crit("rpc client fail", "err", err)
+	}
+	for _, id := range ids[:2] {
+		if err := client.Call(nil, "admin_addPeer", string(sim.Net.GetNode(id).Addr())); err != nil {
+			demo.Log.Crit("add peer fail", "err", err)
+		}
+	}
+	bob = getNode(bob.id)
+	err = demo.EventuallyWithin(mailTimeout, func() bool {
+		peers := 0
+		bob.ps.EachConn(nil, 255, func(*network.Peer, int, bool) bool {
+			peers++
+			return true
+		})
+		return peers == len(ids)-1
+	})
+	if err != nil {
+		demo.Log.Crit("bob not connected", "err", err)
+	}
+	bob.name = "bob"
+	introduce(mailbox, alice, bob)
+	fmt.Printf("bob back online\n")
+	var mail []string
+	err = demo.EventuallyWithin(mailTimeout, func() bool {
+		mail, err = bob.fetch(mailbox, uint64(time.Now().UnixNano()))
+		return err == nil
+	})
+	if err != nil {
+		demo.Log.Crit("fetch fail", "err", err)
+	}
+	fmt.Printf("bob's mail: %q\n", mail)
+	mailbox.mu.Lock()
+	expired := mailbox.expired
+	mailbox.mu.Unlock()
+	if len(mail) != maxMessages-1 || expired != 1 {
+		demo.Log.Crit("wrong mail", "messages", len(mail), "expired", expired)
+	}
+	fmt.Printf("%d message expired in the mailbox\n", expired)
+
+	// the mail is dropped once bob got it, and a fetch seen before isn't taken again
+	err = demo.EventuallyWithin(mailTimeout, func() bool {
+		mailbox.mu.Lock()
+		defer mailbox.mu.Unlock()
+		return len(mailbox.boxes[bob.pubkey()].msgs) == 0
+	})
+	if err != nil {
+		demo.Log.Crit("mail not dropped")
+	}
+	mail, err = bob.fetch(mailbox, uint64(time.Now().UnixNano()))
+	fmt.Printf("bob fetches again: %d messages, %v\n", len(mail), err)
+	_, err = bob.fetch(mailbox, 1)
+	fmt.Printf("a fetch with an old seq: %v\n", err)
+	if err == nil {
+		demo.Log.Crit("replayed fetch taken")
+	}
+}
@@ -0,0 +1,209 @@
+// who of the contacts of a node is online, from heartbeats over pss, with the changes pushed over an rpc subscription
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/simulation"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/ethereum/go-ethereum/swarm/state"
+
+	demo "./common"
+	"./presence"
+)
+
+const (
+	healthTimeout = time.Second * 10
+	eventTimeout  = time.Second * 10
+)
+
+var (
+	names          = []string{"alice", "bob", "carol", "dave"}
+	presenceConfig = presence.Config{
+		Interval: time.Second,
+		Timeout:  time.Second * 3,
+	}
+)
+
+// what we need to know about each node
+type simNode struct {
+	ps   *presence.Service
+	addr []byte
+	key  *ecdsa.PrivateKey
+}
+
+func (self *simNode) pubkey() string {
+	return common.ToHex(crypto.FromECDSAPub(&self.key.PublicKey))
+}
+
+// the nodes run pss wrapped in the presence service, which serves the presence namespace along with pss
+func newServices() (map[string]simulation.ServiceFunc, func(enode.ID) *simNode) {
+	var mu sync.Mutex
+	nodes := make(map[enode.ID]*simNode)
+	kademlias := make(map[enode.ID]*network.Kademlia)
+	kademlia := func(id enode.ID) *network.Kademlia {
+		mu.Lock()
+		defer mu.Unlock()
+		if k, ok := kademlias[id]; ok {
+			return k
+		}
+		kademlias[id] = network.NewKademlia(id[:], network.NewKadParams())
+		return kademlias[id]
+	}
+	getNode := func(id enode.ID) *simNode {
+		mu.Lock()
+		defer mu.Unlock()
+		return nodes[id]
+	}
+
+	return map[string]simulation.ServiceFunc{
+		"bzz": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			addr := network.NewAddr(ctx.Config.Node())
+			kad := kademlia(ctx.Config.ID)
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+			config := &network.BzzConfig{
+				OverlayAddr:  addr.Over(),
+				UnderlayAddr: addr.Under(),
+				HiveParams:   network.NewHiveParams(),
+			}
+			return network.NewBzz(config, kad, state.NewInmemoryStore(), nil, nil), nil, nil
+		},
+		"pss": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			key, err := crypto.GenerateKey()
+			if err != nil {
+				return nil, nil, err
+			}
+			kad := kademlia(ctx.Config.ID)
+			ps, err := pss.NewPss(kad, pss.NewPssParams().WithPrivateKey(key))
+			if err != nil {
+				return nil, nil, err
+			}
+			svc := presence.NewService(ps, presenceConfig)
+			mu.Lock()
+			nodes[ctx.Config.ID] = &simNode{
+				ps:   svc,
+				addr: kad.BaseAddr(),
+				key:  key,
+			}
+			mu.Unlock()
+			return svc, nil, nil
+		},
+	}, getNode
+}
+
+// waits for the events of the contacts, in any order, and takes the others that come meanwhile as they are
+func expectEvents(eventC chan presence.Presence, who map[string]string, want map[string]bool) {
+	for len(want) > 0 {
+		v, err := demo.ExpectMsg(eventC, nil, eventTimeout)
+		if err != nil {
+			demo.Log.Crit("presence event missing", "err", err, "waiting for", len(want))
+		}
+		e := v.(presence.Presence)
+		how := "timed out"
+		if e.Left {
+			how = "left"
+		}
+		if e.Online {
+			fmt.Printf("  %s online\n", who[e.Key])
+		} else {
+			fmt.Printf("  %s offline, %s, last seen %v ago\n", who[e.Key], how, time.Since(e.LastSeen).Round(time.Millisecond*100))
+		}
+		if online, ok := want[e.Key]; ok && online == e.Online {
+			delete(want, e.Key)
+		}
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	services, getNode := newServices()
+	sim := simulation.New(services)
+	defer sim.Close()
+	ids, err := sim.AddNodesAndConnectFull(len(names))
+	if err != nil {
+		demo.Log.Crit("create network fail", "err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	_, err = sim.WaitTillHealthy(ctx, 1)
+	cancel()
+	if err != nil {
+		demo.Log.Warn("network not healthy", "err", err)
+	}
+
+	var nodes []*simNode
+	var clients []*rpc.Client
+	who := make(map[string]string)
+	for i, id := range ids {
+		n := getNode(id)
+		nodes = append(nodes, n)
+		who[n.pubkey()] = names[i]
+		client, err := sim.Net.GetNode(id).Client()
+		if err != nil {
+			demo.Log.Crit("rpc client fail", "err", err)
+		}
+		clients = append(clients, client)
+	}
+	alice, carol, dave := nodes[0], nodes[2], nodes[3]
+
+	// alice follows the changes over rpc, the way an app showing her contacts would
+	eventC := make(chan presence.Presence, 16)
+	sub, err := clients[0].Subscribe(context.Background(), "presence", eventC, "events")
+	if err != nil {
+		demo.Log.Crit("subscribe fail", "err", err)
+	}
+	defer sub.Unsubscribe()
+
+	// everyone has everyone else in the contacts
+	for i, client := range clients {
+		for j, n := range nodes {
+			if i == j {
+				continue
+			}
+			if err := client.Call(nil, "presence_watch", hexutil.Bytes(crypto.FromECDSAPub(&n.key.PublicKey)), hexutil.Bytes(n.addr)); err != nil {
+				demo.Log.Crit("watch fail", "err", err)
+			}
+		}
+	}
+	fmt.Printf("alice's contacts come online:\n")
+	expectEvents(eventC, who, map[string]bool{nodes[1].pubkey(): true, carol.pubkey(): true, dave.pubkey(): true})
+
+	// dave drops alice from his contacts, so he stops sending her heartbeats, and after the timeout he's offline to her
+	if err := clients[3].Call(nil, "presence_unwatch", hexutil.Bytes(crypto.FromECDSAPub(&alice.key.PublicKey))); err != nil {
+		demo.Log.Crit("unwatch fail", "err", err)
+	}
+	fmt.Printf("dave hides from alice:\n")
+	expectEvents(eventC, who, map[string]bool{dave.pubkey(): false})
+
+	// carol tells her contacts she leaves before her node stops, so she's offline to them without waiting for the timeout
+	if err := clients[2].Call(nil, "presence_leave"); err != nil {
+		demo.Log.Crit("leave fail", "err", err)
+	}
+	if err := sim.Net.Stop(ids[2]); err != nil {
+		demo.Log.Crit("stop fail", "err", err)
+	}
+	fmt.Printf("carol stops her node:\n")
+	expectEvents(eventC, who, map[string]bool{carol.pubkey(): false})
+
+	var list []presence.Presence
+	if err := clients[0].Call(&list, "presence_list"); err != nil {
+		demo.Log.Crit("presence list fail", "err", err)
+	}
+	fmt.Printf("alice's contacts:\n")
+	for _, p := range list {
+		fmt.Printf("  %-6s online %-5v last seen %v ago\n", who[p.Key], p.Online, time.Since(p.LastSeen).Round(time.Millisecond*100))
+	}
+}
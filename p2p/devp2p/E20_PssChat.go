@@ -0,0 +1,253 @@
+// a chat over pss, with typing and receipts sent apart from the messages, as control messages that may be lost
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/simulation"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/ethereum/go-ethereum/swarm/state"
+
+	"./chat"
+	demo "./common"
+	"./presence"
+)
+
+const (
+	healthTimeout = time.Second * 10
+	eventTimeout  = time.Second * 10
+	keystroke     = time.Millisecond * 150
+)
+
+var (
+	presenceConfig = presence.Config{
+		Interval: time.Second,
+		Timeout:  time.Second * 3,
+	}
+	chatConfig = chat.Config{
+		Resend:    time.Second * 2,
+		MaxSends:  3,
+		TypingTTL: time.Second,
+		Capacity:  16,
+	}
+)
+
+// what we need to know about each node
+type simNode struct {
+	name string
+	ps   *presence.Service
+	addr []byte
+	key  *ecdsa.PrivateKey
+	chat *chat.Chat
+	// the events of the chat, printed as they come, and passed on to the one waiting for them
+	eventC chan chat.Event
+}
+
+// the nodes run pss wrapped in the presence service, the chat is on top of its pss
+func newServices() (map[string]simulation.ServiceFunc, func(enode.ID) *simNode) {
+	var mu sync.Mutex
+	nodes := make(map[enode.ID]*simNode)
+	kademlias := make(map[enode.ID]*network.Kademlia)
+	kademlia := func(id enode.ID) *network.Kademlia {
+		mu.Lock()
+		defer mu.Unlock()
+		if k, ok := kademlias[id]; ok {
+			return k
+		}
+		kademlias[id] = network.NewKademlia(id[:], network.NewKadParams())
+		return kademlias[id]
+	}
+	getNode := func(id enode.ID) *simNode {
+		mu.Lock()
+		defer mu.Unlock()
+		return nodes[id]
+	}
+
+	return map[string]simulation.ServiceFunc{
+		"bzz": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			addr := network.NewAddr(ctx.Config.Node())
+			kad := kademlia(ctx.Config.ID)
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+			config := &network.BzzConfig{
+				OverlayAddr:  addr.Over(),
+				UnderlayAddr: addr.Under(),
+				HiveParams:   network.NewHiveParams(),
+			}
+			return network.NewBzz(config, kad, state.NewInmemoryStore(), nil, nil), nil, nil
+		},
+		"pss": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			key, err := crypto.GenerateKey()
+			if err != nil {
+				return nil, nil, err
+			}
+			kad := kademlia(ctx.Config.ID)
+			ps, err := pss.NewPss(kad, pss.NewPssParams().WithPrivateKey(key))
+			if err != nil {
+				return nil, nil, err
+			}
+			svc := presence.NewService(ps, presenceConfig)
+			mu.Lock()
+			nodes[ctx.Config.ID] = &simNode{
+				ps:   svc,
+				addr: kad.BaseAddr(),
+				key:  key,
+				chat: chat.New(chat.NewPssTransport(ps), key, chatConfig),
+			}
+			mu.Unlock()
+			return svc, nil, nil
+		},
+	}, getNode
+}
+
+// prints the events of the node's chat, as its app would show them
+func (self *simNode) show(names map[string]string) {
+	eventC := make(chan chat.Event, 64)
+	self.eventC = make(chan chat.Event, 64)
+	self.chat.SubscribeEvents(eventC)
+	go func() {
+		for e := range eventC {
+			peer := names[e.Peer]
+			switch e.Kind {
+			case chat.EventTyping:
+				if e.Typing {
+					fmt.Printf("  [%s] %s is typing...\n", self.name, peer)
+				} else {
+					fmt.Printf("  [%s] %s stopped typing\n", self.name, peer)
+				}
+			case chat.EventMessage:
+				fmt.Printf("  [%s] %s: %s\n", self.name, peer, e.Message.Text)
+			case chat.EventStatus:
+				fmt.Printf("  [%s] message %d to %s %s\n", self.name, e.Message.Seq, peer, e.Message.Status)
+			}
+			self.eventC <- e
+		}
+	}()
+}
+
+// waits for the event the matcher takes, the others are passed over
+func (self *simNode) expect(match func(e chat.Event) bool) chat.Event {
+	deadline := time.Now().Add(eventTimeout)
+	for {
+		v, err := demo.ExpectMsg(self.eventC, nil, time.Until(deadline))
+		if err != nil {
+			demo.Log.Crit("chat event missing", "node", self.name, "err", err)
+		}
+		if e := v.(chat.Event); match(e) {
+			return e
+		}
+	}
+}
+
+func status(s chat.Status) func(e chat.Event) bool {
+	return func(e chat.Event) bool {
+		return e.Kind == chat.EventStatus && e.Message.Status == s
+	}
+}
+
+// types the text a key at a time
+func (self *simNode) typeText(to *simNode, text string) {
+	for range text {
+		if err := self.chat.Typing(to.chat.Self()); err != nil {
+			demo.Log.Crit("typing fail", "err", err)
+		}
+		time.Sleep(keystroke)
+	}
+}
+
+// types the text, and sends it
+func (self *simNode) write(to *simNode, text string) *chat.Message {
+	self.typeText(to, text)
+	msg, err := self.chat.Send(to.chat.Self(), text)
+	if err != nil {
+		demo.Log.Crit("send fail", "err", err)
+	}
+	return msg
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// alice and bob, with a node between them that passes their messages on
+	services, getNode := newServices()
+	sim := simulation.New(services)
+	defer sim.Close()
+	ids, err := sim.AddNodesAndConnectChain(3)
+	if err != nil {
+		demo.Log.Crit("create network fail", "err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	_, err = sim.WaitTillHealthy(ctx, 1)
+	cancel()
+	if err != nil {
+		demo.Log.Warn("network not healthy", "err", err)
+	}
+	alice, bob := getNode(ids[0]), getNode(ids[2])
+	alice.name, bob.name = "alice", "bob"
+	names := map[string]string{alice.chat.Self(): "alice", bob.chat.Self(): "bob"}
+	for _, pair := range [][2]*simNode{{alice, bob}, {bob, alice}} {
+		self, other := pair[0], pair[1]
+		if err := self.ps.Watch(&other.key.PublicKey, other.addr); err != nil {
+			demo.Log.Crit("watch fail", "err", err)
+		}
+		if _, err := self.chat.AddPeer(&other.key.PublicKey, other.addr); err != nil {
+			demo.Log.Crit("add peer fail", "err", err)
+		}
+		self.show(names)
+		self.chat.Start()
+		defer self.chat.Stop()
+	}
+
+	// the app waits for bob to show online
+	err = demo.EventuallyWithin(eventTimeout, func() bool {
+		p, err := alice.ps.Tracker().Get(bob.chat.Self())
+		return err == nil && p.Online
+	})
+	if err != nil {
+		demo.Log.Crit("bob not online", "err", err)
+	}
+	fmt.Printf("bob is online\n")
+
+	// alice types, bob sees it, and sees it stop when her message comes
+	hi := alice.write(bob, "hi bob!")
+	bob.expect(func(e chat.Event) bool { return e.Kind == chat.EventMessage })
+	alice.expect(status(chat.Delivered))
+
+	// bob reads it a bit later, and answers
+	time.Sleep(time.Second)
+	if err := bob.chat.MarkRead(alice.chat.Self(), hi.Seq); err != nil {
+		demo.Log.Crit("mark read fail", "err", err)
+	}
+	alice.expect(status(chat.Read))
+	bob.write(alice, "hey alice")
+	alice.expect(func(e chat.Event) bool { return e.Kind == chat.EventMessage })
+
+	// alice starts typing and thinks better of it, bob sees her stop typing once her typing is a while old
+	alice.typeText(bob, "so")
+	bob.expect(func(e chat.Event) bool { return e.Kind == chat.EventTyping && !e.Typing })
+
+	// two more that bob reads in one go, with one read receipt for both
+	alice.write(bob, "are you coming")
+	last := alice.write(bob, "tonight?")
+	alice.expect(status(chat.Delivered))
+	alice.expect(status(chat.Delivered))
+	if err := bob.chat.MarkRead(alice.chat.Self(), last.Seq); err != nil {
+		demo.Log.Crit("mark read fail", "err", err)
+	}
+	alice.expect(status(chat.Read))
+	alice.expect(status(chat.Read))
+
+	for _, n := range []*simNode{alice, bob} {
+		st := n.chat.Stats()
+		fmt.Printf("%s sent %d messages and %d control messages, resent %d, dropped %d stale typing\n", n.name, st.Messages.Popped, st.Control.Popped-st.Stale, st.Resent, st.Stale)
+	}
+}
@@ -0,0 +1,264 @@
+// a group chat over pss, the members admitted by the admin, and the key of the group changed when one is removed
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/simulation"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/ethereum/go-ethereum/swarm/state"
+
+	"./chat"
+	demo "./common"
+)
+
+const (
+	healthTimeout = time.Second * 10
+	eventTimeout  = time.Second * 10
+	// how long we wait for what shouldn't come
+	quietTime = time.Second * 2
+)
+
+var chatConfig = chat.Config{
+	Resend:    time.Second * 2,
+	MaxSends:  3,
+	TypingTTL: time.Second,
+	Capacity:  16,
+}
+
+// what we need to know about each node
+type simNode struct {
+	name string
+	addr []byte
+	key  *ecdsa.PrivateKey
+	chat *chat.Chat
+	// the events of the chat, printed as they come, and passed on to the one waiting for them
+	eventC chan chat.Event
+}
+
+func newServices() (map[string]simulation.ServiceFunc, func(enode.ID) *simNode) {
+	var mu sync.Mutex
+	nodes := make(map[enode.ID]*simNode)
+	kademlias := make(map[enode.ID]*network.Kademlia)
+	kademlia := func(id enode.ID) *network.Kademlia {
+		mu.Lock()
+		defer mu.Unlock()
+		if k, ok := kademlias[id]; ok {
+			return k
+		}
+		kademlias[id] = network.NewKademlia(id[:], network.NewKadParams())
+		return kademlias[id]
+	}
+	getNode := func(id enode.ID) *simNode {
+		mu.Lock()
+		defer mu.Unlock()
+		return nodes[id]
+	}
+
+	return map[string]simulation.ServiceFunc{
+		"bzz": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			addr := network.NewAddr(ctx.Config.Node())
+			kad := kademlia(ctx.Config.ID)
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+			config := &network.BzzConfig{
+				OverlayAddr:  addr.Over(),
+				UnderlayAddr: addr.Under(),
+				HiveParams:   network.NewHiveParams(),
+			}
+			return network.NewBzz(config, kad, state.NewInmemoryStore(), nil, nil), nil, nil
+		},
+		"pss": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			key, err := crypto.GenerateKey()
+			if err != nil {
+				return nil, nil, err
+			}
+			kad := kademlia(ctx.Config.ID)
+			ps, err := pss.NewPss(kad, pss.NewPssParams().WithPrivateKey(key))
+			if err != nil {
+				return nil, nil, err
+			}
+			mu.Lock()
+			nodes[ctx.Config.ID] = &simNode{
+				addr: kad.BaseAddr(),
+				key:  key,
+				chat: chat.New(chat.NewPssTransport(ps), key, chatConfig),
+			}
+			mu.Unlock()
+			return ps, nil, nil
+		},
+	}, getNode
+}
+
+var groupChanges = map[chat.GroupChange]string{
+	chat.GroupJoined:        "let us into",
+	chat.GroupMemberAdded:   "added",
+	chat.GroupMemberRemoved: "removed",
+	chat.GroupRemoved:       "removed us from",
+}
+
+// prints the events of the node's chat, as its app would show them
+func (self *simNode) show(names map[string]string) {
+	eventC := make(chan chat.Event, 64)
+	self.eventC = make(chan chat.Event, 64)
+	self.chat.SubscribeEvents(eventC)
+	go func() {
+		for e := range eventC {
+			peer := names[e.Peer]
+			switch e.Kind {
+			case chat.EventInvite:
+				fmt.Printf("  [%s] %s invites us to %q\n", self.name, peer, e.Invitation.Name)
+			case chat.EventGroup:
+				fmt.Printf("  [%s] %s %s %q, epoch %d, %d members\n", self.name, peer, groupChanges[e.Change], e.Group.Name, e.Group.Epoch, len(e.Group.Members))
+			case chat.EventMessage:
+				fmt.Printf("  [%s] %s: %s\n", self.name, peer, e.Message.Text)
+			}
+			self.eventC <- e
+		}
+	}()
+}
+
+// waits for the event the matcher takes, the others are passed over
+func (self *simNode) expect(match func(e chat.Event) bool) chat.Event {
+	deadline := time.Now().Add(eventTimeout)
+	for {
+		v, err := demo.ExpectMsg(self.eventC, nil, time.Until(deadline))
+		if err != nil {
+			demo.Log.Crit("chat event missing", "node", self.name, "err", err)
+		}
+		if e := v.(chat.Event); match(e) {
+			return e
+		}
+	}
+}
+
+// fails if the matcher takes an event for a while
+func (self *simNode) quiet(match func(e chat.Event) bool) {
+	deadline := time.Now().Add(quietTime)
+	for {
+		v, err := demo.ExpectMsg(self.eventC, nil, time.Until(deadline))
+		if err != nil {
+			return
+		}
+		if match(v.(chat.Event)) {
+			demo.Log.Crit("unexpected chat event", "node", self.name)
+		}
+	}
+}
+
+func kind(k chat.EventKind) func(e chat.Event) bool {
+	return func(e chat.Event) bool {
+		return e.Kind == k
+	}
+}
+
+func change(c chat.GroupChange) func(e chat.Event) bool {
+	return func(e chat.Event) bool {
+		return e.Kind == chat.EventGroup && e.Change == c
+	}
+}
+
+func (self *simNode) say(group *chat.Group, text string) {
+	if _, err := self.chat.SendGroup(group.ID, text); err != nil {
+		demo.Log.Crit("group send fail", "node", self.name, "err", err)
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	services, getNode := newServices()
+	sim := simulation.New(services)
+	defer sim.Close()
+	ids, err := sim.AddNodesAndConnectRing(5)
+	if err != nil {
+		demo.Log.Crit("create network fail", "err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	_, err = sim.WaitTillHealthy(ctx, 1)
+	cancel()
+	if err != nil {
+		demo.Log.Warn("network not healthy", "err", err)
+	}
+	var nodes []*simNode
+	names := make(map[string]string)
+	for i, name := range []string{"alice", "bob", "carol", "dave", "mallory"} {
+		n := getNode(ids[i])
+		n.name = name
+		names[n.chat.Self()] = name
+		nodes = append(nodes, n)
+	}
+	alice, bob, carol, dave, mallory := nodes[0], nodes[1], nodes[2], nodes[3], nodes[4]
+	for _, n := range nodes {
+		n.show(names)
+		n.chat.Start()
+		defer n.chat.Stop()
+	}
+
+	// alice makes the group, and invites bob, carol and dave
+	group, err := alice.chat.CreateGroup("hikers")
+	if err != nil {
+		demo.Log.Crit("create group fail", "err", err)
+	}
+	invitations := make(map[*simNode]*chat.Invitation)
+	for _, n := range []*simNode{bob, carol, dave} {
+		if _, err := alice.chat.Invite(group.ID, &n.key.PublicKey, n.addr); err != nil {
+			demo.Log.Crit("invite fail", "err", err)
+		}
+		invitations[n] = n.expect(kind(chat.EventInvite)).Invitation
+	}
+
+	// mallory got hold of dave's invitation, and tries it first, alice won't take it from her
+	if err := mallory.chat.Accept(invitations[dave]); err != nil {
+		demo.Log.Crit("accept fail", "err", err)
+	}
+	mallory.quiet(change(chat.GroupJoined))
+	fmt.Printf("mallory wasn't let in with dave's invitation\n")
+
+	for _, n := range []*simNode{bob, carol, dave} {
+		if err := n.chat.Accept(invitations[n]); err != nil {
+			demo.Log.Crit("accept fail", "err", err)
+		}
+		n.expect(change(chat.GroupJoined))
+	}
+	// the last in learns of the others from the state alice sends, the first when alice adds them
+	bob.expect(func(e chat.Event) bool { return change(chat.GroupMemberAdded)(e) && e.Peer == dave.chat.Self() })
+
+	carol.say(group, "where to this weekend?")
+	for _, n := range []*simNode{alice, bob, dave} {
+		n.expect(kind(chat.EventMessage))
+	}
+
+	// alice removes carol, and the others get a new key
+	if err := alice.chat.Remove(group.ID, carol.chat.Self()); err != nil {
+		demo.Log.Crit("remove fail", "err", err)
+	}
+	carol.expect(change(chat.GroupRemoved))
+	bob.expect(change(chat.GroupMemberRemoved))
+	dave.expect(change(chat.GroupMemberRemoved))
+
+	bob.say(group, "the lake, without carol")
+	alice.expect(kind(chat.EventMessage))
+	dave.expect(kind(chat.EventMessage))
+	carol.quiet(kind(chat.EventMessage))
+	if _, err := carol.chat.SendGroup(group.ID, "wait for me"); err != nil {
+		fmt.Printf("carol can't send to the group: %v\n", err)
+	}
+
+	for _, g := range bob.chat.Groups() {
+		var members []string
+		for _, m := range g.Members {
+			members = append(members, names[m])
+		}
+		fmt.Printf("%q of %s, epoch %d: %v, %d messages\n", g.Name, names[g.Admin], g.Epoch, members, len(bob.chat.GroupMessages(g.ID)))
+	}
+}
@@ -0,0 +1,223 @@
+// files sent in the chat: uploaded to swarm encrypted, and the message carries the hash and the key to fetch them
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm"
+	bzzapi "github.com/ethereum/go-ethereum/swarm/api"
+	bzzclient "github.com/ethereum/go-ethereum/swarm/api/client"
+
+	"./chat"
+	demo "./common"
+)
+
+const (
+	eventTimeout = time.Second * 10
+	fetchTimeout = time.Second * 20
+	// the width of the progress bar
+	barWidth = 20
+)
+
+var (
+	chatConfig = chat.Config{
+		Resend:    time.Second * 2,
+		MaxSends:  3,
+		TypingTTL: time.Second,
+		Capacity:  16,
+	}
+
+	// what alice sends, from less than a chunk to a tree of chunks
+	files = []struct {
+		name string
+		size int
+	}{
+		{"note.txt", 300},
+		{"photo.jpg", 300000},
+	}
+)
+
+// what we need to know about each node
+type chatNode struct {
+	name   string
+	stack  *node.Node
+	key    *ecdsa.PrivateKey
+	addr   []byte
+	rpc    *rpc.Client
+	bzz    *bzzclient.Client
+	chat   *chat.Chat
+	eventC chan chat.Event
+}
+
+func newService(privkey *ecdsa.PrivateKey, bzzdir string, bzzport int) func(ctx *node.ServiceContext) (node.Service, error) {
+	return func(ctx *node.ServiceContext) (node.Service, error) {
+		bzzconfig := bzzapi.NewConfig()
+		bzzconfig.Path = bzzdir
+		demo.Conf.Pss.Apply(bzzconfig.Pss)
+		// the key of the node is the key of its pss, and of the chat on top of it
+		bzzconfig.Init(privkey)
+		bzzconfig.Port = fmt.Sprintf("%d", bzzport)
+		return swarm.NewSwarm(bzzconfig, nil)
+	}
+}
+
+// the swarm node has its pss to itself, the chat reaches it over rpc
+func newChatNode(name string, i int) *chatNode {
+	stack, err := demo.NewServiceNode(demo.Conf.P2PPort+i, 0, 0)
+	if err != nil {
+		demo.Log.Crit(err.Error())
+	}
+	privkey, err := demo.LoadOrCreateKey(filepath.Join(stack.InstanceDir(), "bzzkey"))
+	if err != nil {
+		demo.Log.Crit("private key load fail", "err", err)
+	}
+	if err := stack.Register(newService(privkey, stack.InstanceDir(), demo.Conf.BzzPort+i)); err != nil {
+		demo.Log.Crit("servicenode swarm register fail", "err", err)
+	}
+	if err := stack.Start(); err != nil {
+		demo.Log.Crit("servicenode start failed", "err", err)
+	}
+	client, err := stack.Attach()
+	if err != nil {
+		demo.Log.Crit("rpc attach fail", "err", err)
+	}
+	transport, err := chat.NewRPCTransport(client)
+	if err != nil {
+		demo.Log.Crit("chat transport fail", "err", err)
+	}
+	n := &chatNode{
+		name:   name,
+		stack:  stack,
+		key:    privkey,
+		addr:   transport.BaseAddr(),
+		rpc:    client,
+		bzz:    bzzclient.NewClient(fmt.Sprintf("http://localhost:%d", demo.Conf.BzzPort+i)),
+		chat:   chat.New(transport, privkey, chatConfig),
+		eventC: make(chan chat.Event, 64),
+	}
+	n.chat.SubscribeEvents(n.eventC)
+	n.chat.Start()
+	return n
+}
+
+// waits for the event the matcher takes, the others are passed over
+func (self *chatNode) expect(match func(e chat.Event) bool) chat.Event {
+	deadline := time.Now().Add(eventTimeout)
+	for {
+		v, err := demo.ExpectMsg(self.eventC, nil, time.Until(deadline))
+		if err != nil {
+			demo.Log.Crit("chat event missing", "node", self.name, "err", err)
+		}
+		if e := v.(chat.Event); match(e) {
+			return e
+		}
+	}
+}
+
+// shows how much of the file came, once for each tenth of it
+func (self *chatNode) progress(name string) chat.Progress {
+	shown := -1
+	return func(done, total uint64) {
+		tenths := int(done * 10 / total)
+		if tenths == shown {
+			return
+		}
+		shown = tenths
+		filled := int(done * barWidth / total)
+		fmt.Printf("  [%s] %-10s [%s%s] %3d%% %d/%d\n", self.name, name, strings.Repeat("#", filled), strings.Repeat(".", barWidth-filled), done*100/total, done, total)
+	}
+}
+
+// fetches the attachment through the node's own swarm node
+// the chunks may not have got there yet, so it's tried until the timeout
+func (self *chatNode) fetch(att *chat.Attachment) []byte {
+	var buf bytes.Buffer
+	var err error
+	timeout := demo.EventuallyWithin(fetchTimeout, func() bool {
+		buf.Reset()
+		err = chat.Fetch(self.bzz, att, &buf, self.progress(att.Name))
+		return err == nil
+	})
+	if timeout != nil {
+		demo.Log.Crit("fetch fail", "node", self.name, "name", att.Name, "err", err)
+	}
+	return buf.Bytes()
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	// alice and bob each run a swarm node, bob's is connected to alice's
+	alice, bob := newChatNode("alice", 0), newChatNode("bob", 1)
+	for _, n := range []*chatNode{alice, bob} {
+		defer demo.RemoveDataDir(n.stack.DataDir())
+		defer n.stack.Stop()
+		defer n.rpc.Close()
+		defer n.chat.Stop()
+	}
+	bob.stack.Server().AddPeer(alice.stack.Server().Self())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := demo.WaitHealthy(ctx, 1, alice.rpc, bob.rpc); err != nil {
+		demo.Log.Warn("health check fail", "err", err)
+	}
+	time.Sleep(time.Second)
+
+	if _, err := alice.chat.AddPeer(&bob.key.PublicKey, bob.addr); err != nil {
+		demo.Log.Crit("add peer fail", "err", err)
+	}
+	if _, err := bob.chat.AddPeer(&alice.key.PublicKey, alice.addr); err != nil {
+		demo.Log.Crit("add peer fail", "err", err)
+	}
+
+	// alice uploads each file to her swarm node, and sends bob the message with the hash and the key
+	sent := make(map[string][]byte)
+	for _, f := range files {
+		data := make([]byte, f.size)
+		rand.Read(data)
+		att, err := chat.Upload(alice.bzz, f.name, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			demo.Log.Crit("upload fail", "name", f.name, "err", err)
+		}
+		sent[f.name] = data
+		fmt.Printf("alice uploaded %s, %d bytes, hash %x\n", f.name, att.Size, att.Hash)
+		if _, err := alice.chat.SendAttachment(bob.chat.Self(), "here's "+f.name, att); err != nil {
+			demo.Log.Crit("send fail", "err", err)
+		}
+	}
+
+	// bob's app shows the messages, and fetches the files when he opens them
+	var attachments []*chat.Attachment
+	for range files {
+		e := bob.expect(func(e chat.Event) bool { return e.Kind == chat.EventMessage })
+		fmt.Printf("  [bob] alice: %s (%s, %d bytes)\n", e.Message.Text, e.Message.Attachment.Name, e.Message.Attachment.Size)
+		attachments = append(attachments, e.Message.Attachment)
+	}
+	for _, att := range attachments {
+		data := bob.fetch(att)
+		if !bytes.Equal(data, sent[att.Name]) {
+			demo.Log.Crit("fetched not what was sent", "name", att.Name)
+		}
+		fmt.Printf("bob opened %s\n", att.Name)
+	}
+
+	// without the key the hash is of chunks no one can read
+	withoutKey := *attachments[0]
+	withoutKey.Key = make([]byte, len(withoutKey.Key))
+	var buf bytes.Buffer
+	if err := chat.Fetch(bob.bzz, &withoutKey, &buf, nil); err == nil && bytes.Equal(buf.Bytes(), sent[withoutKey.Name]) {
+		demo.Log.Crit("fetched without the key")
+	}
+	fmt.Printf("%s can't be read without the key\n", withoutKey.Name)
+}
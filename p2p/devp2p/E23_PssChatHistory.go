@@ -0,0 +1,332 @@
+// the history of a group chat kept in leveldb, and what a member missed while it was away synced from the others
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/simulation"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/ethereum/go-ethereum/swarm/state"
+
+	"./chat"
+	demo "./common"
+)
+
+const (
+	healthTimeout = time.Second * 10
+	eventTimeout  = time.Second * 10
+	// how long we wait for what shouldn't come
+	quietTime = time.Second * 2
+)
+
+var chatConfig = chat.Config{
+	Resend:    time.Second * 2,
+	MaxSends:  3,
+	TypingTTL: time.Second,
+	Capacity:  16,
+}
+
+// what we need to know about each node
+type simNode struct {
+	name    string
+	id      enode.ID
+	ps      *pss.Pss
+	kad     *network.Kademlia
+	key     *ecdsa.PrivateKey
+	history *chat.History
+	chat    *chat.Chat
+	// the events of the chat, printed as they come, and passed on to the one waiting for them
+	eventC chan chat.Event
+}
+
+func newServices() (map[string]simulation.ServiceFunc, func(enode.ID) *simNode) {
+	var mu sync.Mutex
+	nodes := make(map[enode.ID]*simNode)
+	// the keys outlive a restart of the node, the kademlia is made anew
+	// by whichever of the two services starts first, and taken by the other, as they start in no set order
+	keys := make(map[enode.ID]*ecdsa.PrivateKey)
+	kademlias := make(map[enode.ID]*network.Kademlia)
+	kademlia := func(id enode.ID, addr *network.BzzAddr) *network.Kademlia {
+		if kad, ok := kademlias[id]; ok {
+			delete(kademlias, id)
+			return kad
+		}
+		kad := network.NewKademlia(addr.Over(), network.NewKadParams())
+		kademlias[id] = kad
+		return kad
+	}
+	getNode := func(id enode.ID) *simNode {
+		mu.Lock()
+		defer mu.Unlock()
+		return nodes[id]
+	}
+
+	return map[string]simulation.ServiceFunc{
+		"bzz": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			addr := network.NewAddr(ctx.Config.Node())
+			mu.Lock()
+			kad := kademlia(ctx.Config.ID, addr)
+			mu.Unlock()
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+			config := &network.BzzConfig{
+				OverlayAddr:  addr.Over(),
+				UnderlayAddr: addr.Under(),
+				HiveParams:   network.NewHiveParams(),
+			}
+			return network.NewBzz(config, kad, state.NewInmemoryStore(), nil, nil), nil, nil
+		},
+		"pss": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			mu.Lock()
+			key, ok := keys[ctx.Config.ID]
+			if !ok {
+				var err error
+				if key, err = crypto.GenerateKey(); err != nil {
+					mu.Unlock()
+					return nil, nil, err
+				}
+				keys[ctx.Config.ID] = key
+			}
+			kad := kademlia(ctx.Config.ID, network.NewAddr(ctx.Config.Node()))
+			mu.Unlock()
+			ps, err := pss.NewPss(kad, pss.NewPssParams().WithPrivateKey(key))
+			if err != nil {
+				return nil, nil, err
+			}
+			mu.Lock()
+			nodes[ctx.Config.ID] = &simNode{id: ctx.Config.ID, ps: ps, kad: kad, key: key}
+			mu.Unlock()
+			return ps, nil, nil
+		},
+	}, getNode
+}
+
+// starts the chat on the node's pss, with the groups and messages the history has
+func (self *simNode) start(name string, history *chat.History, names map[string]string) {
+	self.name = name
+	self.history = history
+	self.chat = chat.New(chat.NewPssTransport(self.ps), self.key, chatConfig)
+	if err := self.chat.SetHistory(history); err != nil {
+		demo.Log.Crit("chat history fail", "node", name, "err", err)
+	}
+	names[self.chat.Self()] = name
+	self.show(names)
+	self.chat.Start()
+}
+
+// the chat stops, and the history is closed, as when the app is closed
+func (self *simNode) stop() {
+	self.chat.Stop()
+	self.history.Close()
+}
+
+// prints the events of the node's chat, as its app would show them
+func (self *simNode) show(names map[string]string) {
+	eventC := make(chan chat.Event, 64)
+	self.eventC = make(chan chat.Event, 64)
+	self.chat.SubscribeEvents(eventC)
+	go func() {
+		for e := range eventC {
+			if e.Kind == chat.EventMessage {
+				fmt.Printf("  [%s] %s: %s\n", self.name, names[e.Peer], e.Message.Text)
+			}
+			self.eventC <- e
+		}
+	}()
+}
+
+// waits for the event the matcher takes, the others are passed over
+func (self *simNode) expect(match func(e chat.Event) bool) chat.Event {
+	deadline := time.Now().Add(eventTimeout)
+	for {
+		v, err := demo.ExpectMsg(self.eventC, nil, time.Until(deadline))
+		if err != nil {
+			demo.Log.Crit("chat event missing", "node", self.name, "err", err)
+		}
+		if e := v.(chat.Event); match(e) {
+			return e
+		}
+	}
+}
+
+// fails if the matcher takes an event for a while
+func (self *simNode) quiet(match func(e chat.Event) bool) {
+	deadline := time.Now().Add(quietTime)
+	for {
+		v, err := demo.ExpectMsg(self.eventC, nil, time.Until(deadline))
+		if err != nil {
+			return
+		}
+		if match(v.(chat.Event)) {
+			demo.Log.Crit("unexpected chat event", "node", self.name)
+		}
+	}
+}
+
+func kind(k chat.EventKind) func(e chat.Event) bool {
+	return func(e chat.Event) bool {
+		return e.Kind == k
+	}
+}
+
+func (self *simNode) say(group *chat.Group, text string) {
+	if _, err := self.chat.SendGroup(group.ID, text); err != nil {
+		demo.Log.Crit("group send fail", "node", self.name, "err", err)
+	}
+}
+
+// the texts of the group's messages, in the order the node shows them
+func (self *simNode) texts(group *chat.Group) []string {
+	var texts []string
+	for _, m := range self.chat.GroupMessages(group.ID) {
+		texts = append(texts, m.Text)
+	}
+	return texts
+}
+
+func openHistory() (*chat.History, string) {
+	dir, err := demo.TempDataDir("chat-history")
+	if err != nil {
+		demo.Log.Crit("history dir fail", "err", err)
+	}
+	history, err := chat.OpenHistory(dir)
+	if err != nil {
+		demo.Log.Crit("history open fail", "err", err)
+	}
+	return history, dir
+}
+
+func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
+
+	services, getNode := newServices()
+	sim := simulation.New(services)
+	defer sim.Close()
+	ids, err := sim.AddNodesAndConnectFull(4)
+	if err != nil {
+		demo.Log.Crit("create network fail", "err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	_, err = sim.WaitTillHealthy(ctx, 1)
+	cancel()
+	if err != nil {
+		demo.Log.Warn("network not healthy", "err", err)
+	}
+
+	// every app keeps its history in a directory of its own
+	names := make(map[string]string)
+	dirs := make(map[enode.ID]string)
+	var nodes []*simNode
+	for i, name := range []string{"alice", "bob", "carol", "dave"} {
+		n := getNode(ids[i])
+		history, dir := openHistory()
+		defer os.RemoveAll(dir)
+		dirs[n.id] = dir
+		n.start(name, history, names)
+		nodes = append(nodes, n)
+	}
+	alice, bob, carol, dave := nodes[0], nodes[1], nodes[2], nodes[3]
+
+	group, err := alice.chat.CreateGroup("hikers")
+	if err != nil {
+		demo.Log.Crit("create group fail", "err", err)
+	}
+	for _, n := range []*simNode{bob, carol, dave} {
+		if _, err := alice.chat.Invite(group.ID, &n.key.PublicKey, n.kad.BaseAddr()); err != nil {
+			demo.Log.Crit("invite fail", "err", err)
+		}
+		inv := n.expect(kind(chat.EventInvite)).Invitation
+		if err := n.chat.Accept(inv); err != nil {
+			demo.Log.Crit("accept fail", "err", err)
+		}
+		n.expect(func(e chat.Event) bool { return e.Kind == chat.EventGroup && e.Change == chat.GroupJoined })
+	}
+	// the first ones in learn of dave from alice
+	bob.expect(func(e chat.Event) bool { return e.Kind == chat.EventGroup && e.Peer == dave.chat.Self() })
+	carol.expect(func(e chat.Event) bool { return e.Kind == chat.EventGroup && e.Peer == dave.chat.Self() })
+
+	alice.say(group, "meet at nine?")
+	for _, n := range []*simNode{bob, carol, dave} {
+		n.expect(kind(chat.EventMessage))
+	}
+
+	// dave closes his app and his node goes down, the others go on without him
+	dave.stop()
+	if err := sim.Net.Stop(dave.id); err != nil {
+		demo.Log.Crit("stop fail", "err", err)
+	}
+	fmt.Printf("dave goes offline\n")
+	bob.say(group, "nine is fine")
+	carol.say(group, "make it ten")
+	alice.say(group, "ten it is")
+	alice.expect(kind(chat.EventMessage))
+	alice.expect(kind(chat.EventMessage))
+	for _, n := range []*simNode{bob, carol} {
+		n.expect(kind(chat.EventMessage))
+		n.expect(kind(chat.EventMessage))
+	}
+
+	// dave comes back with the same key, and dials the others himself
+	if err := sim.Net.Start(dave.id); err != nil {
+		demo.Log.Crit("start fail", "err", err)
+	}
+	client, err := sim.Net.GetNode(dave.id).Client()
+	if err != nil {
+		demo.Log.Crit("rpc client fail", "err", err)
+	}
+	for _, id := range ids[:3] {
+		if err := client.Call(nil, "admin_addPeer", string(sim.Net.GetNode(id).Addr())); err != nil {
+			demo.Log.Crit("add peer fail", "err", err)
+		}
+	}
+	dave = getNode(dave.id)
+	err = demo.EventuallyWithin(eventTimeout, func() bool {
+		peers := 0
+		dave.kad.EachConn(nil, 255, func(*network.Peer, int, bool) bool {
+			peers++
+			return true
+		})
+		return peers == len(ids)-1
+	})
+	if err != nil {
+		demo.Log.Crit("dave not connected", "err", err)
+	}
+
+	// his app opens the history again, which has the group and what came before he left
+	history, err := chat.OpenHistory(dirs[dave.id])
+	if err != nil {
+		demo.Log.Crit("history open fail", "err", err)
+	}
+	dave.start("dave", history, names)
+	fmt.Printf("dave back online, his history has %q\n", dave.texts(group))
+
+	// he asks the others for what he missed, each of them answers, and he takes each message once
+	if err := dave.chat.SyncGroup(group.ID); err != nil {
+		demo.Log.Crit("sync fail", "err", err)
+	}
+	for i := 0; i < 3; i++ {
+		dave.expect(kind(chat.EventMessage))
+	}
+	dave.quiet(kind(chat.EventMessage))
+
+	for _, n := range nodes[:3] {
+		if !reflect.DeepEqual(n.texts(group), dave.texts(group)) {
+			demo.Log.Crit("histories differ", "node", n.name, "messages", n.texts(group), "dave", dave.texts(group))
+		}
+	}
+	fmt.Printf("everyone has %q\n", dave.texts(group))
+	for _, n := range []*simNode{alice, bob, carol, dave} {
+		n.stop()
+	}
+}
@@ -0,0 +1,172 @@
+// pss between machines on the same local network, finding each other with mdns instead of a bootnode
+//
+// run it on two laptops on the same wifi, or twice on one machine with the second on other ports:
+//
+//	go run E24_PssLAN.go
+//	go run E24_PssLAN.go -l 30300
+//
+// every node announces its enode, its pss key and its overlay address on the local network, see the mdns package.
+// The nodes connect to the ones they find, tell pss their keys, and greet each other over pss every few seconds,
+// until interrupted. The firewall must let the mdns packets (udp 5353) and the p2p connections through
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+
+	demo "./common"
+	"./common/registry"
+	"./envelope"
+	"./mdns"
+)
+
+const (
+	greetInterval = time.Second * 5
+)
+
+var (
+	topic = pss.BytesToTopic([]byte("lan"))
+)
+
+// a node found on the network, as far as pss goes
+type lanPeer struct {
+	name   string
+	pubkey string
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	if demo.Enabled("mdns") {
+		demo.Log.Crit("the example runs mdns itself, announcing the pss key of the node along, leave -enable mdns out")
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "somewhere"
+	}
+	name := fmt.Sprintf("%s:%d", hostname, demo.Conf.P2PPort)
+
+	stack, err := demo.NewServiceNode(demo.Conf.P2PPort, 0, 0)
+	if err != nil {
+		demo.Log.Crit(err.Error())
+	}
+	if _, err := demo.ComposeServices(stack, []string{"swarm"}); err != nil {
+		demo.Log.Crit("compose services fail", "err", err)
+	}
+
+	// mdns after swarm, as it tells the others about the pss of swarm
+	var lan *mdns.Service
+	err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		ps, err := registry.SwarmPss(ctx)
+		if err != nil {
+			return nil, err
+		}
+		lan = mdns.NewService(mdns.DefaultConfig(), map[string]string{
+			"name": name,
+			"pss":  common.ToHex(crypto.FromECDSAPub(ps.PublicKey())),
+			"bzz":  common.ToHex(ps.BaseAddr()),
+		})
+		return lan, nil
+	})
+	if err != nil {
+		demo.Log.Crit("servicenode mdns register fail", "err", err)
+	}
+	if err := stack.Start(); err != nil {
+		demo.Log.Crit("servicenode start fail", "err", err)
+	}
+	defer demo.RemoveDataDir(stack.DataDir())
+	defer stack.Stop()
+
+	client, err := stack.Attach()
+	if err != nil {
+		demo.Log.Crit("attach fail", "err", err)
+	}
+	defer client.Close()
+	demo.Log.Info("node up, looking for the others", "name", name, "enode", stack.Server().NodeInfo().Enode)
+
+	// the peers found, by their pss key
+	peers := make(map[string]lanPeer)
+
+	// subscribed before looking at the nodes found already, so none is missed
+	eventC := make(chan mdns.Event, 16)
+	sub := lan.Discovery().SubscribeEvents(eventC)
+	defer sub.Unsubscribe()
+	found := func(p mdns.Peer) {
+		pubkey, addr := p.Info["pss"], p.Info["bzz"]
+		if pubkey == "" || addr == "" {
+			demo.Log.Debug("node without pss found", "node", p.Node)
+			return
+		}
+		if err := demo.CallRetry(client, nil, "pss_setPeerPublicKey", pubkey, topic, addr); err != nil {
+			demo.Log.Warn("pss set peer key fail", "name", p.Info["name"], "err", err)
+			return
+		}
+		peers[pubkey] = lanPeer{name: p.Info["name"], pubkey: pubkey}
+		fmt.Printf("found %s at %s\n", p.Info["name"], p.Node.IP())
+	}
+	for _, p := range lan.Discovery().Peers() {
+		found(p)
+	}
+
+	msgC := make(chan pss.APIMsg)
+	msub, err := demo.Resubscribe(stack.Attach, "pss", msgC, "receive", topic, false, false)
+	if err != nil {
+		demo.Log.Crit("pss subscribe fail", "err", err)
+	}
+	defer msub.Unsubscribe()
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+	ticker := time.NewTicker(greetInterval)
+	defer ticker.Stop()
+	// the greetings are numbered, a subscription takes the same message twice for one
+	greetings := 0
+	for {
+		select {
+		case e := <-eventC:
+			if e.Lost {
+				// pss keeps the key, it's just not greeted anymore
+				delete(peers, e.Peer.Info["pss"])
+				fmt.Printf("%s is gone\n", e.Peer.Info["name"])
+				continue
+			}
+			found(e.Peer)
+
+		case <-ticker.C:
+			greetings++
+			for _, p := range peers {
+				msg, err := envelope.Wrap(envelope.Raw, fmt.Sprintf("hello #%d from %s", greetings, name))
+				if err != nil {
+					demo.Log.Crit("wrap message fail", "err", err)
+				}
+				if err := client.Call(nil, "pss_sendAsym", p.pubkey, topic, common.ToHex(msg)); err != nil {
+					demo.Log.Warn("pss send fail", "to", p.name, "err", err)
+				}
+			}
+
+		case msg := <-msgC:
+			var content string
+			if err := envelope.Unwrap(msg.Msg, &content); err != nil {
+				demo.Log.Warn("unwrap message fail", "err", err)
+				continue
+			}
+			from := peers[msg.Key].name
+			if from == "" {
+				from = msg.Key
+			}
+			fmt.Printf("%s says: %s\n", from, content)
+
+		case <-sigC:
+			demo.Log.Info("stopping")
+			return
+		}
+	}
+}
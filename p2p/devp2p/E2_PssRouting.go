@@ -4,31 +4,33 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/swarm"
 	bzzapi "github.com/ethereum/go-ethereum/swarm/api"
 	"github.com/ethereum/go-ethereum/swarm/pss"
 
 	demo "./common"
+	"./envelope"
 )
 
 func newService(bzzdir string, bzzport int, bzznetworkid uint64) func(ctx *node.ServiceContext) (node.Service, error) {
 	return func(ctx *node.ServiceContext) (node.Service, error) {
 
-		// generate a new private key
-		privkey, err := crypto.GenerateKey()
+		// get the private key, which stays the same across runs if the data directory is kept
+		// the swarm overlay address is derived from it
+		privkey, err := demo.LoadOrCreateKey(filepath.Join(bzzdir, "bzzkey"))
 		if err != nil {
-			demo.Log.Crit("private key generate servicenode 'left' fail: %v")
+			demo.Log.Crit("private key load servicenode fail", "err", err)
 		}
 
 		// create necessary swarm params
 		bzzconfig := bzzapi.NewConfig()
 		bzzconfig.Path = bzzdir
+		demo.Conf.Pss.Apply(bzzconfig.Pss)
 		bzzconfig.Init(privkey)
 		if err != nil {
 			demo.Log.Crit("unable to configure swarm", "err", err)
@@ -42,33 +44,35 @@ func newService(bzzdir string, bzzport int, bzznetworkid uint64) func(ctx *node.
 }
 
 func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
 
 	// create three nodes
-	l_stack, err := demo.NewServiceNode(demo.P2pPort, 0, 0)
+	l_stack, err := demo.NewServiceNode(demo.Conf.P2PPort, 0, 0)
 	if err != nil {
 		demo.Log.Crit(err.Error())
 	}
-	r_stack, err := demo.NewServiceNode(demo.P2pPort+1, 0, 0)
+	r_stack, err := demo.NewServiceNode(demo.Conf.P2PPort+1, 0, 0)
 	if err != nil {
 		demo.Log.Crit(err.Error())
 	}
-	c_stack, err := demo.NewServiceNode(demo.P2pPort+2, 0, 0)
+	c_stack, err := demo.NewServiceNode(demo.Conf.P2PPort+2, 0, 0)
 	if err != nil {
 		demo.Log.Crit(err.Error())
 	}
 
 	// register the pss activated bzz services
-	l_svc := newService(l_stack.InstanceDir(), demo.BzzDefaultPort, demo.BzzDefaultNetworkId)
+	l_svc := newService(l_stack.InstanceDir(), demo.Conf.BzzPort, demo.Conf.BzzNetworkId)
 	err = l_stack.Register(l_svc)
 	if err != nil {
 		demo.Log.Crit("servicenode 'left' pss register fail", "err", err)
 	}
-	r_svc := newService(r_stack.InstanceDir(), demo.BzzDefaultPort+1, demo.BzzDefaultNetworkId)
+	r_svc := newService(r_stack.InstanceDir(), demo.Conf.BzzPort+1, demo.Conf.BzzNetworkId)
 	err = r_stack.Register(r_svc)
 	if err != nil {
 		demo.Log.Crit("servicenode 'right' pss register fail", "err", err)
 	}
-	c_svc := newService(c_stack.InstanceDir(), demo.BzzDefaultPort+2, demo.BzzDefaultNetworkId)
+	c_svc := newService(c_stack.InstanceDir(), demo.Conf.BzzPort+2, demo.Conf.BzzNetworkId)
 	err = c_stack.Register(c_svc)
 	if err != nil {
 		demo.Log.Crit("servicenode 'right' pss register fail", "err", err)
@@ -79,17 +83,17 @@ func main() {
 	if err != nil {
 		demo.Log.Crit("servicenode start failed", "err", err)
 	}
-	defer os.RemoveAll(l_stack.DataDir())
+	defer demo.RemoveDataDir(l_stack.DataDir())
 	err = r_stack.Start()
 	if err != nil {
 		demo.Log.Crit("servicenode start failed", "err", err)
 	}
-	defer os.RemoveAll(r_stack.DataDir())
+	defer demo.RemoveDataDir(r_stack.DataDir())
 	err = c_stack.Start()
 	if err != nil {
 		demo.Log.Crit("servicenode start failed", "err", err)
 	}
-	defer os.RemoveAll(c_stack.DataDir())
+	defer demo.RemoveDataDir(c_stack.DataDir())
 
 	// connect the nodes to the middle
 	c_stack.Server().AddPeer(l_stack.Server().Self())
@@ -106,11 +110,15 @@ func main() {
 	if err != nil {
 		demo.Log.Crit("health check fail", "err", err)
 	}
-	time.Sleep(time.Second) // because the healthy does not work
+	// the health info isn't to be relied on yet, so wait until the kademlia tables have the peers connected too
+	err = demo.EventuallyWithin(time.Second*5, demo.KademliaHealthy(l_rpcclient, r_rpcclient))
+	if err != nil {
+		demo.Log.Crit("kademlia not ready", "err", err)
+	}
 
 	// get a valid topic byte
 	var topic string
-	err = l_rpcclient.Call(&topic, "pss_stringToTopic", "foo")
+	err = demo.CallRetry(l_rpcclient, &topic, "pss_stringToTopic", "foo")
 	if err != nil {
 		demo.Log.Crit("pss string to topic fail", "err", err)
 	}
@@ -118,34 +126,50 @@ func main() {
 	// subscribe to incoming messages on the receiving sevicenode
 	// this will register a message handler on the specified topic
 	msgC := make(chan pss.APIMsg)
-	sub, err := r_rpcclient.Subscribe(context.Background(), "pss", msgC, "receive", topic, false, false)
+	sub, err := demo.Resubscribe(r_stack.Attach, "pss", msgC, "receive", topic, false, false)
+	if err != nil {
+		demo.Log.Crit("pss subscribe fail", "err", err)
+	}
 
 	// supply no address for routing
 	r_bzzaddr := "0x"
 
 	// get the receiver's public key
 	var r_pubkey string
-	err = r_rpcclient.Call(&r_pubkey, "pss_getPublicKey")
+	err = demo.CallRetry(r_rpcclient, &r_pubkey, "pss_getPublicKey")
 	if err != nil {
 		demo.Log.Crit("pss get pubkey fail", "err", err)
 	}
 
 	// make the sender aware of the receiver's public key
-	err = l_rpcclient.Call(nil, "pss_setPeerPublicKey", r_pubkey, topic, r_bzzaddr)
+	err = demo.CallRetry(l_rpcclient, nil, "pss_setPeerPublicKey", r_pubkey, topic, r_bzzaddr)
 	if err != nil {
 		demo.Log.Crit("pss get pubkey fail", "err", err)
 	}
 
 	// send message using asymmetric encryption
 	// since it's sent to ourselves, it will not go through pss forwarding
-	err = l_rpcclient.Call(nil, "pss_sendAsym", r_pubkey, topic, common.ToHex([]byte("bar")))
+	msg, err := envelope.Wrap(envelope.Raw, "bar")
+	if err != nil {
+		demo.Log.Crit("wrap message fail", "err", err)
+	}
+	err = demo.CallRetry(l_rpcclient, nil, "pss_sendAsym", r_pubkey, topic, common.ToHex(msg))
 	if err != nil {
 		demo.Log.Crit("pss send fail", "err", err)
 	}
 
 	// get the incoming message
-	inmsg := <-msgC
-	demo.Log.Info("pss received", "msg", string(inmsg.Msg), "from", fmt.Sprintf("%x", inmsg.Key))
+	v, err := demo.ExpectMsg(msgC, nil, time.Second*5)
+	if err != nil {
+		demo.Log.Crit("pss receive fail", "err", err)
+	}
+	inmsg := v.(pss.APIMsg)
+	var content string
+	err = envelope.Unwrap(inmsg.Msg, &content)
+	if err != nil {
+		demo.Log.Crit("unwrap message fail", "err", err)
+	}
+	demo.Log.Info("pss received", "msg", content, "from", fmt.Sprintf("%x", inmsg.Key))
 
 	// bring down the servicenodes
 	sub.Unsubscribe()
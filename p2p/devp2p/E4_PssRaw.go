@@ -5,7 +5,7 @@ import (
 	"context"
 	"crypto/rand"
 	"fmt"
-	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -17,20 +17,23 @@ import (
 	"github.com/ethereum/go-ethereum/swarm/pss"
 
 	demo "./common"
+	"./envelope"
 )
 
 func newService(bzzdir string, bzzport int, bzznetworkid uint64) func(ctx *node.ServiceContext) (node.Service, error) {
 	return func(ctx *node.ServiceContext) (node.Service, error) {
 
-		// generate a new private key
-		privkey, err := crypto.GenerateKey()
+		// get the private key, which stays the same across runs if the data directory is kept
+		// the swarm overlay address is derived from it
+		privkey, err := demo.LoadOrCreateKey(filepath.Join(bzzdir, "bzzkey"))
 		if err != nil {
-			demo.Log.Crit("private key generate servicenode 'left' fail: %v")
+			demo.Log.Crit("private key load servicenode fail", "err", err)
 		}
 
 		// create necessary swarm params
 		bzzconfig := bzzapi.NewConfig()
 		bzzconfig.Path = bzzdir
+		demo.Conf.Pss.Apply(bzzconfig.Pss)
 		bzzconfig.Pss.AllowRaw = true
 		bzzconfig.Init(privkey)
 		if err != nil {
@@ -45,24 +48,26 @@ func newService(bzzdir string, bzzport int, bzznetworkid uint64) func(ctx *node.
 }
 
 func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
 
 	// create two nodes
-	l_stack, err := demo.NewServiceNode(demo.P2pPort, 0, 0)
+	l_stack, err := demo.NewServiceNode(demo.Conf.P2PPort, 0, 0)
 	if err != nil {
 		demo.Log.Crit(err.Error())
 	}
-	r_stack, err := demo.NewServiceNode(demo.P2pPort+1, 0, 0)
+	r_stack, err := demo.NewServiceNode(demo.Conf.P2PPort+1, 0, 0)
 	if err != nil {
 		demo.Log.Crit(err.Error())
 	}
 
 	// register the pss activated bzz services
-	l_svc := newService(l_stack.InstanceDir(), demo.BzzDefaultPort, demo.BzzDefaultNetworkId)
+	l_svc := newService(l_stack.InstanceDir(), demo.Conf.BzzPort, demo.Conf.BzzNetworkId)
 	err = l_stack.Register(l_svc)
 	if err != nil {
 		demo.Log.Crit("servicenode 'left' pss register fail", "err", err)
 	}
-	r_svc := newService(r_stack.InstanceDir(), demo.BzzDefaultPort+1, demo.BzzDefaultNetworkId)
+	r_svc := newService(r_stack.InstanceDir(), demo.Conf.BzzPort+1, demo.Conf.BzzNetworkId)
 	err = r_stack.Register(r_svc)
 	if err != nil {
 		demo.Log.Crit("servicenode 'right' pss register fail", "err", err)
@@ -73,12 +78,12 @@ func main() {
 	if err != nil {
 		demo.Log.Crit("servicenode start failed", "err", err)
 	}
-	defer os.RemoveAll(l_stack.DataDir())
+	defer demo.RemoveDataDir(l_stack.DataDir())
 	err = r_stack.Start()
 	if err != nil {
 		demo.Log.Crit("servicenode start failed", "err", err)
 	}
-	defer os.RemoveAll(r_stack.DataDir())
+	defer demo.RemoveDataDir(r_stack.DataDir())
 
 	// connect the nodes to the middle
 	l_stack.Server().AddPeer(r_stack.Server().Self())
@@ -94,11 +99,15 @@ func main() {
 	if err != nil {
 		demo.Log.Crit("health check fail", "err", err)
 	}
-	time.Sleep(time.Second) // because the healthy does not work
+	// the health info isn't to be relied on yet, so wait until the kademlia tables have the peers connected too
+	err = demo.EventuallyWithin(time.Second*5, demo.KademliaHealthy(l_rpcclient, r_rpcclient))
+	if err != nil {
+		demo.Log.Crit("kademlia not ready", "err", err)
+	}
 
 	// get a valid topic byte
 	var topic string
-	err = l_rpcclient.Call(&topic, "pss_stringToTopic", "foo")
+	err = demo.CallRetry(l_rpcclient, &topic, "pss_stringToTopic", "foo")
 	if err != nil {
 		demo.Log.Crit("pss string to topic fail", "err", err)
 	}
@@ -106,16 +115,19 @@ func main() {
 	// subscribe to incoming messages on the receiving sevicenode
 	// this will register a message handler on the specified topic
 	msgC := make(chan pss.APIMsg)
-	sub, err := r_rpcclient.Subscribe(context.Background(), "pss", msgC, "receive", topic, true, false)
+	sub, err := demo.Resubscribe(r_stack.Attach, "pss", msgC, "receive", topic, true, false)
+	if err != nil {
+		demo.Log.Crit("pss subscribe fail", "err", err)
+	}
 
 	// get the recipient node's swarm overlay address
 	var l_bzzaddr string
-	err = r_rpcclient.Call(&l_bzzaddr, "pss_baseAddr")
+	err = demo.CallRetry(r_rpcclient, &l_bzzaddr, "pss_baseAddr")
 	if err != nil {
 		demo.Log.Crit("pss get baseaddr fail", "err", err)
 	}
 	var r_bzzaddr string
-	err = r_rpcclient.Call(&r_bzzaddr, "pss_baseAddr")
+	err = demo.CallRetry(r_rpcclient, &r_bzzaddr, "pss_baseAddr")
 	if err != nil {
 		demo.Log.Crit("pss get baseaddr fail", "err", err)
 	}
@@ -125,7 +137,11 @@ func main() {
 	if err != nil {
 		demo.Log.Crit("generate external encryption key fail", "err", err)
 	}
-	m := []byte("xyzzy")
+	// the envelope goes inside the encryption, pss only sees the ciphertext
+	m, err := envelope.Wrap(envelope.Raw, "xyzzy")
+	if err != nil {
+		demo.Log.Crit("wrap message fail", "err", err)
+	}
 	ciphertext, err := ecies.Encrypt(rand.Reader, &r_externalkey.PublicKey, m, nil, nil)
 	if err != nil {
 		demo.Log.Crit("external message encryption fail", "err", err)
@@ -133,17 +149,29 @@ func main() {
 
 	// send message using symmetric encryption
 	// since it's sent to ourselves, it will not go through pss forwarding
-	err = l_rpcclient.Call(nil, "pss_sendRaw", r_bzzaddr, topic, common.ToHex(ciphertext))
+	err = demo.CallRetry(l_rpcclient, nil, "pss_sendRaw", r_bzzaddr, topic, common.ToHex(ciphertext))
 	if err != nil {
 		demo.Log.Crit("pss send fail", "err", err)
 	}
 
 	// get the incoming message
-	inmsg := <-msgC
+	v, err := demo.ExpectMsg(msgC, nil, time.Second*5)
+	if err != nil {
+		demo.Log.Crit("pss receive fail", "err", err)
+	}
+	inmsg := v.(pss.APIMsg)
 
 	// decrypt the message
 	plaintext, err := r_externalkey.Decrypt(inmsg.Msg, nil, nil)
-	demo.Log.Info("pss received", "msg", string(plaintext), "from", fmt.Sprintf("%x", inmsg.Key))
+	if err != nil {
+		demo.Log.Crit("external message decryption fail", "err", err)
+	}
+	var content string
+	err = envelope.Unwrap(plaintext, &content)
+	if err != nil {
+		demo.Log.Crit("unwrap message fail", "err", err)
+	}
+	demo.Log.Info("pss received", "msg", content, "from", fmt.Sprintf("%x", inmsg.Key))
 
 	// bring down the servicenodes
 	sub.Unsubscribe()
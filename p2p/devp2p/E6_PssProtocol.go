@@ -4,11 +4,9 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
-	"sync"
+	"path/filepath"
 	"time"
 
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/enode"
@@ -18,10 +16,10 @@ import (
 	"github.com/ethereum/go-ethereum/swarm/pss"
 
 	demo "./common"
+	"./specdoc"
 )
 
 var (
-	messageW  = &sync.WaitGroup{}
 	pssprotos []*pss.Protocol
 )
 
@@ -92,15 +90,17 @@ var (
 
 func newService(bzzdir string, bzzport int, bzznetworkid uint64, specs []*protocols.Spec, protocols []*p2p.Protocol) func(ctx *node.ServiceContext) (node.Service, error) {
 	return func(ctx *node.ServiceContext) (node.Service, error) {
-		// generate a new private key
-		privkey, err := crypto.GenerateKey()
+		// get the private key, which stays the same across runs if the data directory is kept
+		// the swarm overlay address is derived from it
+		privkey, err := demo.LoadOrCreateKey(filepath.Join(bzzdir, "bzzkey"))
 		if err != nil {
-			demo.Log.Crit("private key generate servicenode 'left' fail: %v")
+			demo.Log.Crit("private key load servicenode fail", "err", err)
 		}
 
 		// create necessary swarm params
 		bzzconfig := bzzapi.NewConfig()
 		bzzconfig.Path = bzzdir
+		demo.Conf.Pss.Apply(bzzconfig.Pss)
 		bzzconfig.Init(privkey)
 		if err != nil {
 			demo.Log.Crit("unable to configure swarm", "err", err)
@@ -125,40 +125,55 @@ func newService(bzzdir string, bzzport int, bzznetworkid uint64, specs []*protoc
 }
 
 func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
 
 	// create two nodes
-	l_stack, err := demo.NewServiceNode(demo.P2pPort, 0, 0)
+	l_stack, err := demo.NewServiceNode(demo.Conf.P2PPort, 0, 0)
 	if err != nil {
 		demo.Log.Crit(err.Error())
 	}
-	r_stack, err := demo.NewServiceNode(demo.P2pPort+1, 0, 0)
+	r_stack, err := demo.NewServiceNode(demo.Conf.P2PPort+1, 0, 0)
 	if err != nil {
 		demo.Log.Crit(err.Error())
 	}
 
 	// register the pss activated bzz services
-	l_svc := newService(l_stack.InstanceDir(), demo.BzzDefaultPort, demo.BzzDefaultNetworkId, []*protocols.Spec{&fooProtocol}, []*p2p.Protocol{&proto})
+	l_svc := newService(l_stack.InstanceDir(), demo.Conf.BzzPort, demo.Conf.BzzNetworkId, []*protocols.Spec{&fooProtocol}, []*p2p.Protocol{&proto})
 	err = l_stack.Register(l_svc)
 	if err != nil {
 		demo.Log.Crit("servicenode 'left' pss register fail", "err", err)
 	}
-	r_svc := newService(r_stack.InstanceDir(), demo.BzzDefaultPort, demo.BzzDefaultNetworkId, []*protocols.Spec{&fooProtocol}, []*p2p.Protocol{&proto})
+	r_svc := newService(r_stack.InstanceDir(), demo.Conf.BzzPort, demo.Conf.BzzNetworkId, []*protocols.Spec{&fooProtocol}, []*p2p.Protocol{&proto})
 	err = r_stack.Register(r_svc)
 	if err != nil {
 		demo.Log.Crit("servicenode 'right' pss register fail", "err", err)
 	}
 
+	// the spec service describes the message formats of the protocols over rpc, for those writing clients of their own
+	specsvc := func(ctx *node.ServiceContext) (node.Service, error) {
+		return specdoc.NewService(&fooProtocol), nil
+	}
+	err = l_stack.Register(specsvc)
+	if err != nil {
+		demo.Log.Crit("servicenode 'left' spec register fail", "err", err)
+	}
+	err = r_stack.Register(specsvc)
+	if err != nil {
+		demo.Log.Crit("servicenode 'right' spec register fail", "err", err)
+	}
+
 	// start the nodes
 	err = l_stack.Start()
 	if err != nil {
 		demo.Log.Crit("servicenode start failed", "err", err)
 	}
-	defer os.RemoveAll(l_stack.DataDir())
+	defer demo.RemoveDataDir(l_stack.DataDir())
 	err = r_stack.Start()
 	if err != nil {
 		demo.Log.Crit("servicenode start failed", "err", err)
 	}
-	defer os.RemoveAll(r_stack.DataDir())
+	defer demo.RemoveDataDir(r_stack.DataDir())
 
 	// connect the nodes
 	l_stack.Server().AddPeer(r_stack.Server().Self())
@@ -174,46 +189,63 @@ func main() {
 	if err != nil {
 		demo.Log.Crit("health check fail", "err", err)
 	}
-	time.Sleep(time.Second) // because the healthy does not work
+	// the health info isn't to be relied on yet, so wait until the kademlia tables have the peers connected too
+	err = demo.EventuallyWithin(time.Second*5, demo.KademliaHealthy(l_rpcclient, r_rpcclient))
+	if err != nil {
+		demo.Log.Crit("kademlia not ready", "err", err)
+	}
+
+	// see what the other side will be sending us
+	var specs []*specdoc.Protocol
+	err = demo.CallRetry(l_rpcclient, &specs, "spec_describe")
+	if err != nil {
+		demo.Log.Crit("spec describe fail", "err", err)
+	}
+	for _, spec := range specs {
+		for _, msg := range spec.Messages {
+			demo.Log.Info("protocol message", "protocol", spec.Name, "version", spec.Version, "code", msg.Code, "name", msg.Name, "fields", msg.Schema.PropertyOrder)
+		}
+	}
 
 	// get the overlay addresses
 	var l_bzzaddr string
-	err = l_rpcclient.Call(&l_bzzaddr, "pss_baseAddr")
+	err = demo.CallRetry(l_rpcclient, &l_bzzaddr, "pss_baseAddr")
 	if err != nil {
 		demo.Log.Crit("pss get pubkey fail", "err", err)
 	}
 	var r_bzzaddr string
-	err = r_rpcclient.Call(&r_bzzaddr, "pss_baseAddr")
+	err = demo.CallRetry(r_rpcclient, &r_bzzaddr, "pss_baseAddr")
 	if err != nil {
 		demo.Log.Crit("pss get pubkey fail", "err", err)
 	}
 
 	// get the publickeys
 	var l_pubkey string
-	err = l_rpcclient.Call(&l_pubkey, "pss_getPublicKey")
+	err = demo.CallRetry(l_rpcclient, &l_pubkey, "pss_getPublicKey")
 	if err != nil {
 		demo.Log.Crit("pss get pubkey fail", "err", err)
 	}
 	var r_pubkey string
-	err = r_rpcclient.Call(&r_pubkey, "pss_getPublicKey")
+	err = demo.CallRetry(r_rpcclient, &r_pubkey, "pss_getPublicKey")
 	if err != nil {
 		demo.Log.Crit("pss get pubkey fail", "err", err)
 	}
 
 	// set the peers' publickeys
-	err = l_rpcclient.Call(nil, "pss_setPeerPublicKey", r_pubkey, topic.String(), r_bzzaddr)
+	err = demo.CallRetry(l_rpcclient, nil, "pss_setPeerPublicKey", r_pubkey, topic.String(), r_bzzaddr)
 	if err != nil {
 		demo.Log.Crit("pss get pubkey fail", "err", err)
 	}
-	err = r_rpcclient.Call(nil, "pss_setPeerPublicKey", l_pubkey, topic.String(), l_bzzaddr)
+	err = demo.CallRetry(r_rpcclient, nil, "pss_setPeerPublicKey", l_pubkey, topic.String(), l_bzzaddr)
 	if err != nil {
 		demo.Log.Crit("pss get pubkey fail", "err", err)
 	}
 
 	// set up the event subscriptions on both nodes
+	// each tells on its own channel when its node got the message
 	eventOneC := make(chan *p2p.PeerEvent)
+	msgOneC := make(chan *p2p.PeerEvent, 1)
 	sub_one := l_stack.Server().SubscribeEvents(eventOneC)
-	messageW.Add(1)
 	go func() {
 		for {
 			select {
@@ -222,7 +254,11 @@ func main() {
 					demo.Log.Debug("Received peer add notification on node #1", "peer", peerevent.Peer)
 				} else if peerevent.Type == "msgrecv" {
 					demo.Log.Info("Received message nofification on node #1", "event", peerevent)
-					messageW.Done()
+					// without holding up the events of the server, which waits for them to be taken
+					select {
+					case msgOneC <- peerevent:
+					default:
+					}
 				}
 			case <-sub_one.Err():
 				return
@@ -231,8 +267,8 @@ func main() {
 	}()
 
 	eventTwoC := make(chan *p2p.PeerEvent)
+	msgTwoC := make(chan *p2p.PeerEvent, 1)
 	sub_two := r_stack.Server().SubscribeEvents(eventTwoC)
-	messageW.Add(1)
 	go func() {
 		for {
 			select {
@@ -241,7 +277,10 @@ func main() {
 					demo.Log.Debug("Received peer add notification on node #2", "peer", peerevent.Peer)
 				} else if peerevent.Type == "msgrecv" {
 					demo.Log.Info("Received message nofification on node #2", "event", peerevent)
-					messageW.Done()
+					select {
+					case msgTwoC <- peerevent:
+					default:
+					}
 				}
 			case <-sub_two.Err():
 				return
@@ -255,7 +294,12 @@ func main() {
 	pssprotos[0].AddPeer(p, topic, true, r_pubkey)
 
 	// wait for each respective message to be delivered on both sides
-	messageW.Wait()
+	for i, msgC := range []chan *p2p.PeerEvent{msgOneC, msgTwoC} {
+		_, err := demo.ExpectMsg(msgC, nil, time.Second*5)
+		if err != nil {
+			demo.Log.Crit("message notification fail", "node", i+1, "err", err)
+		}
+	}
 
 	// terminate subscription loops and unsubscribe
 	sub_one.Unsubscribe()
@@ -4,11 +4,10 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/protocols"
@@ -60,15 +59,17 @@ func run(ctx context.Context, msg interface{}) error {
 func newService(bzzdir string, bzzport int, bzznetworkid uint64) func(ctx *node.ServiceContext) (node.Service, error) {
 	return func(ctx *node.ServiceContext) (node.Service, error) {
 
-		// generate a new private key
-		privkey, err := crypto.GenerateKey()
+		// get the private key, which stays the same across runs if the data directory is kept
+		// the swarm overlay address is derived from it
+		privkey, err := demo.LoadOrCreateKey(filepath.Join(bzzdir, "bzzkey"))
 		if err != nil {
-			demo.Log.Crit("private key generate servicenode 'left' fail: %v")
+			demo.Log.Crit("private key load servicenode fail", "err", err)
 		}
 
 		// create necessary swarm params
 		bzzconfig := bzzapi.NewConfig()
 		bzzconfig.Path = bzzdir
+		demo.Conf.Pss.Apply(bzzconfig.Pss)
 		bzzconfig.Init(privkey)
 		if err != nil {
 			demo.Log.Crit("unable to configure swarm", "err", err)
@@ -82,25 +83,27 @@ func newService(bzzdir string, bzzport int, bzznetworkid uint64) func(ctx *node.
 }
 
 func main() {
+	defer demo.FlushLogs()
+	defer demo.WriteReport()
 
 	// create two nodes
-	l_stack, err := demo.NewServiceNode(demo.P2pPort, 0, demo.WSDefaultPort, "pss")
+	l_stack, err := demo.NewServiceNode(demo.Conf.P2PPort, 0, demo.Conf.WSPort, "pss")
 	if err != nil {
 		demo.Log.Crit(err.Error())
 	}
-	r_stack, err := demo.NewServiceNode(demo.P2pPort+1, 0, demo.WSDefaultPort+1, "pss")
+	r_stack, err := demo.NewServiceNode(demo.Conf.P2PPort+1, 0, demo.Conf.WSPort+1, "pss")
 	if err != nil {
 		demo.Log.Crit(err.Error())
 	}
 
 	// register the pss activated bzz services
-	l_svc := newService(l_stack.InstanceDir(), demo.BzzDefaultPort, demo.BzzDefaultNetworkId)
+	l_svc := newService(l_stack.InstanceDir(), demo.Conf.BzzPort, demo.Conf.BzzNetworkId)
 	err = l_stack.Register(l_svc)
 	if err != nil {
 		demo.Log.Crit("servicenode 'left' pss register fail", "err", err)
 	}
 
-	r_svc := newService(r_stack.InstanceDir(), demo.BzzDefaultPort+1, demo.BzzDefaultNetworkId)
+	r_svc := newService(r_stack.InstanceDir(), demo.Conf.BzzPort+1, demo.Conf.BzzNetworkId)
 	err = r_stack.Register(r_svc)
 	if err != nil {
 		demo.Log.Crit("servicenode 'right' pss register fail", "err", err)
@@ -111,12 +114,12 @@ func main() {
 	if err != nil {
 		demo.Log.Crit("servicenode start failed", "err", err)
 	}
-	defer os.RemoveAll(l_stack.DataDir())
+	defer demo.RemoveDataDir(l_stack.DataDir())
 	err = r_stack.Start()
 	if err != nil {
 		demo.Log.Crit("servicenode start failed", "err", err)
 	}
-	defer os.RemoveAll(r_stack.DataDir())
+	defer demo.RemoveDataDir(r_stack.DataDir())
 
 	// connect the nodes to the middle
 	l_stack.Server().AddPeer(r_stack.Server().Self())
@@ -127,34 +130,34 @@ func main() {
 
 	// get the public keys
 	var l_pubkey string
-	err = l_rpcclient.Call(&l_pubkey, "pss_getPublicKey")
+	err = demo.CallRetry(l_rpcclient, &l_pubkey, "pss_getPublicKey")
 	if err != nil {
 		demo.Log.Crit("pss get pubkey fail", "err", err)
 	}
 	var r_pubkey string
-	err = r_rpcclient.Call(&r_pubkey, "pss_getPublicKey")
+	err = demo.CallRetry(r_rpcclient, &r_pubkey, "pss_getPublicKey")
 	if err != nil {
 		demo.Log.Crit("pss get pubkey fail", "err", err)
 	}
 
 	// get the overlay addresses
 	var l_bzzaddr string
-	err = l_rpcclient.Call(&l_bzzaddr, "pss_baseAddr")
+	err = demo.CallRetry(l_rpcclient, &l_bzzaddr, "pss_baseAddr")
 	if err != nil {
 		demo.Log.Crit("pss get baseaddr fail", "err", err)
 	}
 	var r_bzzaddr string
-	err = r_rpcclient.Call(&r_bzzaddr, "pss_baseAddr")
+	err = demo.CallRetry(r_rpcclient, &r_bzzaddr, "pss_baseAddr")
 	if err != nil {
 		demo.Log.Crit("pss get baseaddr fail", "err", err)
 	}
 
 	// make the nodes aware of each others' public keys
-	err = l_rpcclient.Call(nil, "pss_setPeerPublicKey", r_pubkey, topic, r_bzzaddr)
+	err = demo.CallRetry(l_rpcclient, nil, "pss_setPeerPublicKey", r_pubkey, topic, r_bzzaddr)
 	if err != nil {
 		demo.Log.Crit("pss set pubkey fail", "err", err)
 	}
-	err = r_rpcclient.Call(nil, "pss_setPeerPublicKey", l_pubkey, topic, l_bzzaddr)
+	err = demo.CallRetry(r_rpcclient, nil, "pss_setPeerPublicKey", l_pubkey, topic, l_bzzaddr)
 	if err != nil {
 		demo.Log.Crit("pss set pubkey fail", "err", err)
 	}
@@ -166,11 +169,15 @@ func main() {
 	if err != nil {
 		demo.Log.Crit("health check fail", "err", err)
 	}
-	time.Sleep(time.Second) // because the healthy does not work
+	// the health info isn't to be relied on yet, so wait until the kademlia tables have the peers connected too
+	err = demo.EventuallyWithin(time.Second*5, demo.KademliaHealthy(l_rpcclient, r_rpcclient))
+	if err != nil {
+		demo.Log.Crit("kademlia not ready", "err", err)
+	}
 
 	// configure and start up pss client RPCs
 	// we can use websockets ...
-	c_left, err := pssclient.NewClient(fmt.Sprintf("ws://localhost:%d", demo.WSDefaultPort))
+	c_left, err := pssclient.NewClient(fmt.Sprintf("ws://localhost:%d", demo.Conf.WSPort))
 	if err != nil {
 		demo.Log.Crit("pssclient 'left' create fail", "err", err)
 	}
@@ -207,17 +214,25 @@ func main() {
 	// add the 'right' peer
 	c_left.AddPssPeer(r_pubkey, common.FromHex(r_bzzaddr), pss.PingProtocol)
 
-	time.Sleep(time.Second)
-
-	// send ping
-	l_ping.OutC <- false
+	// send ping, which waits for the protocol of the peer to be running to take it
+	select {
+	case l_ping.OutC <- false:
+	case <-time.After(time.Second * 5):
+		demo.Log.Crit("ping send fail", "err", demo.ErrTimeout)
+	}
 
-	// get ping
-	<-r_ping.InC
+	// get ping, the InC of the protocol tells if what came was a pong
+	_, err = demo.ExpectMsg(r_ping.InC, func(v interface{}) bool { return !v.(bool) }, time.Second*5)
+	if err != nil {
+		demo.Log.Crit("ping receive fail", "err", err)
+	}
 	demo.Log.Info("got ping")
 
 	// get pong
-	<-l_ping.InC
+	_, err = demo.ExpectMsg(l_ping.InC, func(v interface{}) bool { return v.(bool) }, time.Second*5)
+	if err != nil {
+		demo.Log.Crit("pong receive fail", "err", err)
+	}
 	demo.Log.Info("got pong")
 
 	// the 'right' will receive the ping and send on the quit channel
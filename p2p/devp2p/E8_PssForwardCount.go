@@ -0,0 +1,356 @@
+// counting the pss messages that pass through each node, to see the routing
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/simulation"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/ethereum/go-ethereum/swarm/state"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+
+	demo "./common"
+)
+
+const (
+	nodeCount     = 24
+	msgCount      = 16
+	healthTimeout = time.Second * 20
+	msgTimeout    = time.Second * 2
+
+	// how long to wait after a delivery for copies still on their way
+	settle = time.Millisecond * 300
+
+	// pss messages are the first and only message of the pss protocol
+	pssMsgCode = 0
+)
+
+var (
+	topic = pss.BytesToTopic([]byte("foo"))
+
+	// from no forwards to many
+	shades = []byte(" .:-=+*#%@")
+)
+
+// the number of messages of a topic that went in and out of a node
+type TapCounts struct {
+	In  uint64 `json:"in"`
+	Out uint64 `json:"out"`
+}
+
+// wraps pss to count the messages going through the node, by topic
+// the topic is in the clear on the envelope, so we can count messages we can't decrypt, and which aren't for us
+type tapService struct {
+	*pss.Pss
+	mu     sync.Mutex
+	counts map[pss.Topic]*TapCounts
+}
+
+func newTapService(ps *pss.Pss) *tapService {
+	return &tapService{
+		Pss:    ps,
+		counts: make(map[pss.Topic]*TapCounts),
+	}
+}
+
+// the pss protocol, with the tap between the peer connection and pss
+func (self *tapService) Protocols() []p2p.Protocol {
+	protos := self.Pss.Protocols()
+	for i := range protos {
+		run := protos[i].Run
+		protos[i].Run = func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			return run(p, &tapReadWriter{MsgReadWriter: rw, tap: self})
+		}
+	}
+	return protos
+}
+
+// the pss api, and ours to get the counts
+func (self *tapService) APIs() []rpc.API {
+	return append(self.Pss.APIs(), rpc.API{
+		Namespace: "tap",
+		Version:   "42",
+		Service:   &TapAPI{tap: self},
+		Public:    true,
+	})
+}
+
+func (self *tapService) count(msg *p2p.Msg, out bool) error {
+	if msg.Code != pssMsgCode {
+		return nil
+	}
+
+	// the payload can only be read once, so we read it all and give the message a fresh reader of the same bytes
+	payload, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		return err
+	}
+	msg.Payload = bytes.NewReader(payload)
+
+	// the pss message is wrapped by the protocols package
+	var wmsg protocols.WrappedMsg
+	err = rlp.DecodeBytes(payload, &wmsg)
+	if err != nil {
+		return err
+	}
+	var pssmsg pss.PssMsg
+	err = rlp.DecodeBytes(wmsg.Payload, &pssmsg)
+	if err != nil {
+		return err
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	t := pss.Topic(pssmsg.Payload.Topic)
+	if self.counts[t] == nil {
+		self.counts[t] = &TapCounts{}
+	}
+	if out {
+		self.counts[t].Out++
+	} else {
+		self.counts[t].In++
+	}
+	return nil
+}
+
+// sits on the connection to each peer
+type tapReadWriter struct {
+	p2p.MsgReadWriter
+	tap *tapService
+}
+
+func (self *tapReadWriter) ReadMsg() (p2p.Msg, error) {
+	msg, err := self.MsgReadWriter.ReadMsg()
+	if err != nil {
+		return msg, err
+	}
+	err = self.tap.count(&msg, false)
+	if err != nil {
+		demo.Log.Warn("tap count fail", "err", err)
+	}
+	return msg, nil
+}
+
+func (self *tapReadWriter) WriteMsg(msg p2p.Msg) error {
+	err := self.tap.count(&msg, true)
+	if err != nil {
+		demo.Log.Warn("tap count fail", "err", err)
+	}
+	return self.MsgReadWriter.WriteMsg(msg)
+}
+
+type TapAPI struct {
+	tap *tapService
+}
+
+// Counts returns the counts for a topic
+func (self *TapAPI) Counts(topic pss.Topic) TapCounts {
+	self.tap.mu.Lock()
+	defer self.tap.mu.Unlock()
+	if c, ok := self.tap.counts[topic]; ok {
+		return *c
+	}
+	return TapCounts{}
+}
+
+// what we need to know about each node
+type simNode struct {
+	id   enode.ID
+	ps   *pss.Pss
+	addr []byte
+	key  *ecdsa.PrivateKey
+}
+
+func newServices(deliveryC chan string) (map[string]simulation.ServiceFunc, func(enode.ID) *simNode) {
+	var mu sync.Mutex
+	kademlias := make(map[enode.ID]*network.Kademlia)
+	nodes := make(map[enode.ID]*simNode)
+	kademlia := func(id enode.ID) *network.Kademlia {
+		mu.Lock()
+		defer mu.Unlock()
+		if k, ok := kademlias[id]; ok {
+			return k
+		}
+		kademlias[id] = network.NewKademlia(id[:], network.NewKadParams())
+		return kademlias[id]
+	}
+	getNode := func(id enode.ID) *simNode {
+		mu.Lock()
+		defer mu.Unlock()
+		return nodes[id]
+	}
+
+	return map[string]simulation.ServiceFunc{
+		"bzz": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			addr := network.NewAddr(ctx.Config.Node())
+			kad := kademlia(ctx.Config.ID)
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+			config := &network.BzzConfig{
+				OverlayAddr:  addr.Over(),
+				UnderlayAddr: addr.Under(),
+				HiveParams:   network.NewHiveParams(),
+			}
+			return network.NewBzz(config, kad, state.NewInmemoryStore(), nil, nil), nil, nil
+		},
+		"pss": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			key, err := crypto.GenerateKey()
+			if err != nil {
+				return nil, nil, err
+			}
+			kad := kademlia(ctx.Config.ID)
+			ps, err := pss.NewPss(kad, pss.NewPssParams().WithPrivateKey(key))
+			if err != nil {
+				return nil, nil, err
+			}
+
+			// only the recipient's handler is called
+			// the nodes in between just pass the message on, and the tap is the only one that sees it
+			ps.Register(&topic, pss.NewHandler(func(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+				deliveryC <- string(msg)
+				return nil
+			}))
+			mu.Lock()
+			nodes[ctx.Config.ID] = &simNode{
+				id:   ctx.Config.ID,
+				ps:   ps,
+				addr: kad.BaseAddr(),
+				key:  key,
+			}
+			mu.Unlock()
+			return newTapService(ps), nil, nil
+		},
+	}, getNode
+}
+
+// gets the counts of all nodes over rpc
+func getCounts(sim *simulation.Simulation, nodes []*simNode) ([]TapCounts, error) {
+	counts := make([]TapCounts, len(nodes))
+	for i, n := range nodes {
+		client, err := sim.Net.GetNode(n.id).Client()
+		if err != nil {
+			return nil, err
+		}
+		err = demo.CallRetry(client, &counts[i], "tap_counts", topic)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// the network starts as a ring, and the nodes find more peers through the hive
+	deliveryC := make(chan string, msgCount)
+	services, getNode := newServices(deliveryC)
+	sim := simulation.New(services)
+	defer sim.Close()
+	ids, err := sim.AddNodesAndConnectRing(nodeCount)
+	if err != nil {
+		demo.Log.Crit("create network fail", "err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	_, err = sim.WaitTillHealthy(ctx, network.NewKadParams().MinProxBinSize)
+	cancel()
+	if err != nil {
+		demo.Log.Warn("network not healthy, routing may not be optimal", "err", err)
+	}
+
+	// all messages go to the same recipient, so we can compare the paths
+	// the nodes are ordered by how close they are to the recipient, closest first
+	var nodes []*simNode
+	for _, id := range ids {
+		nodes = append(nodes, getNode(id))
+	}
+	recipient := nodes[0]
+	sort.Slice(nodes, func(i, j int) bool {
+		return storage.Proximity(nodes[i].addr, recipient.addr) > storage.Proximity(nodes[j].addr, recipient.addr)
+	})
+	for _, n := range nodes {
+		if n == recipient {
+			continue
+		}
+		err = n.ps.SetPeerPublicKey(&recipient.key.PublicKey, topic, (*pss.PssAddress)(&recipient.addr))
+		if err != nil {
+			demo.Log.Crit("set public key fail", "err", err)
+		}
+	}
+	pubkey := common.ToHex(crypto.FromECDSAPub(&recipient.key.PublicKey))
+
+	// send the messages one at a time from random nodes, and see what the counts became
+	// what a node sent out of a message it didn't send itself is what it forwarded
+	// close to the recipient, nodes pass the message to all their neighbours, so there can be more than one forward per message
+	forwards := make([][]uint64, len(nodes))
+	senders := make([]int, msgCount)
+	before, err := getCounts(sim, nodes)
+	if err != nil {
+		demo.Log.Crit("get counts fail", "err", err)
+	}
+	for m := 0; m < msgCount; m++ {
+
+		// the recipient is closest to itself, so it's first
+		s := 1 + rand.Intn(len(nodes)-1)
+		senders[m] = s
+		err = nodes[s].ps.SendAsym(pubkey, topic, []byte(fmt.Sprintf("msg %d", m)))
+		if err != nil {
+			demo.Log.Crit("send fail", "err", err)
+		}
+		if _, err := demo.ExpectMsg(deliveryC, nil, msgTimeout); err != nil {
+			demo.Log.Warn("message not delivered", "seq", m, "err", err)
+		}
+		time.Sleep(settle)
+
+		after, err := getCounts(sim, nodes)
+		if err != nil {
+			demo.Log.Crit("get counts fail", "err", err)
+		}
+		for i := range nodes {
+			forwards[i] = append(forwards[i], after[i].Out-before[i].Out)
+		}
+		before = after
+	}
+
+	// the heatmap, a row for each node and a column for each message
+	// the sender of each message is marked with S, the recipient is R
+	fmt.Printf("%-10s %-4s %-*s %s\n", "node", "po", msgCount, "messages", "forwards")
+	for i, n := range nodes {
+		var row strings.Builder
+		total := uint64(0)
+		for m, f := range forwards[i] {
+			switch {
+			case n == recipient:
+				row.WriteByte('R')
+			case senders[m] == i:
+				row.WriteByte('S')
+			case f >= uint64(len(shades)):
+				row.WriteByte(shades[len(shades)-1])
+				total += f
+			default:
+				row.WriteByte(shades[f])
+				total += f
+			}
+		}
+		po := storage.Proximity(n.addr, recipient.addr)
+		fmt.Printf("%x %-4d %s %d\n", n.addr[:5], po, row.String(), total)
+	}
+}
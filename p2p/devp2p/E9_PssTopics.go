@@ -0,0 +1,250 @@
+// how protocols map to pss topics, what happens when two topics collide, and how to tell them apart anyway
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/simulation"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/ethereum/go-ethereum/swarm/state"
+
+	demo "./common"
+)
+
+const (
+	healthTimeout = time.Second * 10
+
+	// how long we wait for messages to come in
+	// we wait the whole time, since we want to see messages that shouldn't come too
+	collectTime = time.Second
+)
+
+var (
+	chatSpec = &protocols.Spec{
+		Name:     "chat",
+		Version:  1,
+		Messages: []interface{}{},
+	}
+	filesSpec = &protocols.Spec{
+		Name:     "files",
+		Version:  1,
+		Messages: []interface{}{},
+	}
+	chatSpecV2 = &protocols.Spec{
+		Name:     "chat",
+		Version:  2,
+		Messages: []interface{}{},
+	}
+)
+
+// the application envelope
+// it travels inside the encrypted payload, and says which protocol and version the message is for
+type envelope struct {
+	Protocol string
+	Version  uint
+	Payload  []byte
+}
+
+func wrap(spec *protocols.Spec, payload []byte) ([]byte, error) {
+	return rlp.EncodeToBytes(&envelope{
+		Protocol: spec.Name,
+		Version:  spec.Version,
+		Payload:  payload,
+	})
+}
+
+// a handler that only passes on the messages in an envelope for the spec
+// anything else on the topic is some other protocol's, and is dropped
+func envelopeHandler(spec *protocols.Spec, f func(payload []byte)) pss.HandlerFunc {
+	return func(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+		var env envelope
+		err := rlp.DecodeBytes(msg, &env)
+		if err != nil {
+			demo.Log.Debug("dropping message without envelope", "protocol", spec.Name, "err", err)
+			return nil
+		}
+		if env.Protocol != spec.Name || env.Version != spec.Version {
+			demo.Log.Debug("dropping message for other protocol", "protocol", spec.Name, "other", env.Protocol, "version", env.Version)
+			return nil
+		}
+		f(env.Payload)
+		return nil
+	}
+}
+
+// finds two protocols with different names but the same topic
+// a topic is only four bytes, so after some tens of thousands of names two of them will share one
+func findCollision() (*protocols.Spec, *protocols.Spec, int) {
+	seen := make(map[pss.Topic]string)
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("proto%d", i)
+		t := pss.ProtocolTopic(&protocols.Spec{Name: name, Version: 1})
+		if other, ok := seen[t]; ok {
+			a := &protocols.Spec{Name: other, Version: 1, Messages: []interface{}{}}
+			b := &protocols.Spec{Name: name, Version: 1, Messages: []interface{}{}}
+			return a, b, i + 1
+		}
+		seen[t] = name
+	}
+}
+
+// what we need to know about each node
+type simNode struct {
+	ps   *pss.Pss
+	addr []byte
+	key  *ecdsa.PrivateKey
+}
+
+func newServices() (map[string]simulation.ServiceFunc, func(enode.ID) *simNode) {
+	var mu sync.Mutex
+	nodes := make(map[enode.ID]*simNode)
+	kademlias := make(map[enode.ID]*network.Kademlia)
+	kademlia := func(id enode.ID) *network.Kademlia {
+		mu.Lock()
+		defer mu.Unlock()
+		if k, ok := kademlias[id]; ok {
+			return k
+		}
+		kademlias[id] = network.NewKademlia(id[:], network.NewKadParams())
+		return kademlias[id]
+	}
+	getNode := func(id enode.ID) *simNode {
+		mu.Lock()
+		defer mu.Unlock()
+		return nodes[id]
+	}
+
+	return map[string]simulation.ServiceFunc{
+		"bzz": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			addr := network.NewAddr(ctx.Config.Node())
+			kad := kademlia(ctx.Config.ID)
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+			config := &network.BzzConfig{
+				OverlayAddr:  addr.Over(),
+				UnderlayAddr: addr.Under(),
+				HiveParams:   network.NewHiveParams(),
+			}
+			return network.NewBzz(config, kad, state.NewInmemoryStore(), nil, nil), nil, nil
+		},
+		"pss": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			key, err := crypto.GenerateKey()
+			if err != nil {
+				return nil, nil, err
+			}
+			kad := kademlia(ctx.Config.ID)
+			ps, err := pss.NewPss(kad, pss.NewPssParams().WithPrivateKey(key))
+			if err != nil {
+				return nil, nil, err
+			}
+			mu.Lock()
+			nodes[ctx.Config.ID] = &simNode{
+				ps:   ps,
+				addr: kad.BaseAddr(),
+				key:  key,
+			}
+			mu.Unlock()
+			return ps, nil, nil
+		},
+	}, getNode
+}
+
+// sends the message, and returns what the handlers got
+func sendAndCollect(sender *simNode, recipient *simNode, topic pss.Topic, msg []byte, gotC chan string) []string {
+	addr := pss.PssAddress(recipient.addr)
+	err := sender.ps.SetPeerPublicKey(&recipient.key.PublicKey, topic, &addr)
+	if err != nil {
+		demo.Log.Crit("set public key fail", "err", err)
+	}
+	err = sender.ps.SendAsym(common.ToHex(crypto.FromECDSAPub(&recipient.key.PublicKey)), topic, msg)
+	if err != nil {
+		demo.Log.Crit("send fail", "err", err)
+	}
+	var got []string
+	timeout := time.After(collectTime)
+	for {
+		select {
+		case g := <-gotC:
+			got = append(got, g)
+		case <-timeout:
+			return got
+		}
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// the topic of a protocol is the hash of its name and version
+	// different protocols get different topics, and so do different versions of the same protocol
+	for _, spec := range []*protocols.Spec{chatSpec, filesSpec, chatSpecV2} {
+		t := pss.ProtocolTopic(spec)
+		demo.Log.Info("protocol topic", "name", spec.Name, "version", spec.Version, "topic", common.ToHex(t[:]))
+	}
+
+	// but the topic is only four bytes, so unrelated protocols can end up with the same
+	collideA, collideB, tries := findCollision()
+	topic := pss.ProtocolTopic(collideA)
+	demo.Log.Info("topic collision", "one", collideA.Name, "other", collideB.Name, "topic", common.ToHex(topic[:]), "names tried", tries)
+
+	// two nodes are enough to show what the recipient gets
+	services, getNode := newServices()
+	sim := simulation.New(services)
+	defer sim.Close()
+	ids, err := sim.AddNodesAndConnectChain(2)
+	if err != nil {
+		demo.Log.Crit("create network fail", "err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	_, err = sim.WaitTillHealthy(ctx, 1)
+	cancel()
+	if err != nil {
+		demo.Log.Warn("network not healthy", "err", err)
+	}
+	sender := getNode(ids[0])
+	recipient := getNode(ids[1])
+	gotC := make(chan string, 8)
+
+	// first the naive way, where the handlers take whatever comes on their topic
+	// pss calls every handler registered on the topic, so a message for one protocol also goes to the other
+	deregA := recipient.ps.Register(&topic, pss.NewHandler(func(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+		gotC <- fmt.Sprintf("%s got '%s'", collideA.Name, msg)
+		return nil
+	}))
+	deregB := recipient.ps.Register(&topic, pss.NewHandler(func(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+		gotC <- fmt.Sprintf("%s got '%s'", collideB.Name, msg)
+		return nil
+	}))
+	got := sendAndCollect(sender, recipient, topic, []byte("for "+collideA.Name), gotC)
+	demo.Log.Info("without envelope", "deliveries", len(got), "got", got)
+	deregA()
+	deregB()
+
+	// now with the envelope, each handler can see which messages are its own
+	recipient.ps.Register(&topic, pss.NewHandler(envelopeHandler(collideA, func(payload []byte) {
+		gotC <- fmt.Sprintf("%s got '%s'", collideA.Name, payload)
+	})))
+	recipient.ps.Register(&topic, pss.NewHandler(envelopeHandler(collideB, func(payload []byte) {
+		gotC <- fmt.Sprintf("%s got '%s'", collideB.Name, payload)
+	})))
+	for _, spec := range []*protocols.Spec{collideA, collideB} {
+		msg, err := wrap(spec, []byte("for "+spec.Name))
+		if err != nil {
+			demo.Log.Crit("wrap fail", "err", err)
+		}
+		got = sendAndCollect(sender, recipient, topic, msg, gotC)
+		demo.Log.Info("with envelope", "deliveries", len(got), "got", got)
+	}
+}
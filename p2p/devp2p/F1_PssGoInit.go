@@ -12,6 +12,7 @@ import (
 )
 
 func main() {
+	defer demo.FlushLogs()
 
 	// generate a new private key
 	privkey, err := crypto.GenerateKey()
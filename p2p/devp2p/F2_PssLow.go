@@ -14,6 +14,7 @@ import (
 	"github.com/ethereum/go-ethereum/swarm/pss"
 
 	demo "./common"
+	"./envelope"
 )
 
 var (
@@ -120,8 +121,8 @@ func newServiceController(datadir string, port int, bzznetworkid uint64) (*Swarm
 	nodconfig := &node.DefaultConfig
 	nodconfig.P2P.ListenAddr = fmt.Sprintf("%v:%d", svcWrapper.host.IP, svcWrapper.host.Port)
 	nodconfig.P2P.NoDiscovery = true
-	nodconfig.IPCPath = demo.IPCName
 	nodconfig.DataDir = fmt.Sprintf("%s%d", datadir, svcWrapper.host.Port)
+	nodconfig.IPCPath = demo.NodeIPCPath(nodconfig.DataDir, demo.IPCName)
 	svcWrapper.node, err = node.New(nodconfig)
 	if err != nil {
 		return nil, fmt.Errorf("ServiceNode create fail: %v", err)
@@ -140,6 +141,13 @@ type pssMsgNotification struct {
 	msg   []byte
 }
 
+// the message of the echo protocol, sent in an envelope
+// the reply has no address, the sender is already in the address book
+type echoMsg struct {
+	Addr    []byte
+	Content string
+}
+
 // object providing the handler function for message in pss
 // includes a notification channel for received messages
 type pssMsgHandler struct {
@@ -153,15 +161,20 @@ type pssMsgHandler struct {
 //
 // If the notifyC channel is set, the handler merely sends the received message on the channel
 //
-// If it is NOT set, the handler expects the first 32 bytes of the message to be the swarm overlay address of the sending node.
+// If it is NOT set, the handler expects an echoMsg with the swarm overlay address of the sending node.
 // It will use this to create an address book entry for the sender on the receiving node.
-// Any leftover bytes in the message will then be sent back to the newly added node.
+// The content of the message will then be sent back to the newly added node.
 func (h *pssMsgHandler) handler(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
 	demo.Log.Debug("Received msg", "msg", msg, "keyid", keyid)
+	var echo echoMsg
+	err := envelope.Unwrap(msg, &echo)
+	if err != nil {
+		return err
+	}
 	if h.notifyC != nil {
 		h.notifyC <- pssMsgNotification{
 			keyid: keyid,
-			msg:   msg,
+			msg:   []byte(echo.Content),
 		}
 		return nil
 	}
@@ -169,16 +182,22 @@ func (h *pssMsgHandler) handler(msg []byte, p *p2p.Peer, asymmetric bool, keyid
 	if err != nil {
 		return err
 	}
-	addr := pss.PssAddress(msg[:32])
+	addr := pss.PssAddress(echo.Addr)
 	pubkey, err := crypto.UnmarshalPubkey(pubkeybytes)
 	if err != nil {
 		return err
 	}
-	h.ps.SetPeerPublicKey(pubkey, topic, addr)
-	return h.ps.SendAsym(keyid, topic, msg[32:])
+	h.ps.SetPeerPublicKey(pubkey, topic, &addr)
+	reply, err := envelope.Wrap(envelope.RLP, &echoMsg{Content: echo.Content})
+	if err != nil {
+		return err
+	}
+	return h.ps.SendAsym(keyid, topic, reply)
 }
 
 func main() {
+	defer demo.FlushLogs()
+
 	var err error
 
 	// create two nodes and start them
@@ -234,9 +253,13 @@ func main() {
 	pss_l.SetPeerPublicKey(pss_r.PublicKey(), topic, pss_r.BaseAddr())
 
 	// send the message using the address book entry
-	var msg []byte
-	msg = append(msg, pss_l.BaseAddr()...)
-	msg = append(msg, []byte("sendmeback")...)
+	msg, err := envelope.Wrap(envelope.RLP, &echoMsg{
+		Addr:    pss_l.BaseAddr(),
+		Content: "sendmeback",
+	})
+	if err != nil {
+		demo.Log.Crit("wrap message fail", "err", err)
+	}
 	pss_l.SendAsym(hexutil.Encode(crypto.FromECDSAPub(pss_r.PublicKey())), topic, msg)
 
 	// that's all folks
@@ -0,0 +1,276 @@
+// builds, signs and sends transactions to a dev mode node, from one sender and from many at once
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"sync"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+
+	demo "./common"
+)
+
+const (
+	senderCount  = 3
+	workerCount  = 4 // goroutines sending from the same account
+	txsPerWorker = 5
+)
+
+// hands out the nonces of the accounts it sends from
+// the node's pending nonce is only asked for once, as it doesn't count transactions that are about to be sent
+type nonceManager struct {
+	client   *ethclient.Client
+	mu       sync.Mutex
+	next     map[common.Address]uint64
+	released map[common.Address][]uint64
+}
+
+func newNonceManager(client *ethclient.Client) *nonceManager {
+	return &nonceManager{
+		client:   client,
+		next:     make(map[common.Address]uint64),
+		released: make(map[common.Address][]uint64),
+	}
+}
+
+// the nonce for the next transaction from the account
+// a released nonce is handed out again before a new one, or the transactions after it would wait for it forever
+func (self *nonceManager) Next(ctx context.Context, account common.Address) (uint64, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if released := self.released[account]; len(released) > 0 {
+		lowest := 0
+		for i, nonce := range released {
+			if nonce < released[lowest] {
+				lowest = i
+			}
+		}
+		nonce := released[lowest]
+		self.released[account] = append(released[:lowest], released[lowest+1:]...)
+		return nonce, nil
+	}
+	nonce, ok := self.next[account]
+	if !ok {
+		var err error
+		nonce, err = self.client.PendingNonceAt(ctx, account)
+		if err != nil {
+			return 0, err
+		}
+	}
+	self.next[account] = nonce + 1
+	return nonce, nil
+}
+
+// gives back a nonce whose transaction didn't make it to the node
+func (self *nonceManager) Release(account common.Address, nonce uint64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.released[account] = append(self.released[account], nonce)
+}
+
+// what a sent transaction came to
+type sent struct {
+	tx       *types.Transaction
+	estimate uint64
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// the dev chain funds its accounts in the genesis block
+	// one is used to show a transaction with, one for the senders that don't coordinate, and the others share a nonce manager
+	dev, err := demo.NewDevChainNode()
+	if err != nil {
+		demo.Log.Crit("dev node start fail", "err", err)
+	}
+	defer dev.Close()
+	keys := dev.Accounts[:senderCount+2]
+	client := dev.Client
+
+	// the chain id goes into the signature, so the transaction can't be replayed on another chain
+	// this node doesn't serve eth_chainId yet, so it's taken from the chain config
+	// the network id is often the same number, but it's only what the nodes of a network tell each other
+	ctx := context.Background()
+	chainID := dev.ChainID
+	networkID, err := client.NetworkID(ctx)
+	if err != nil {
+		demo.Log.Crit("get network id fail", "err", err)
+	}
+	demo.Log.Info("dev node up", "chainid", chainID, "networkid", networkID)
+	signer := types.NewEIP155Signer(chainID)
+
+	// a transfer with some data, step by step
+	key := keys[0]
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	to := common.HexToAddress("0x00000000000000000000000000000000deadbeef")
+	value := big.NewInt(params.Ether)
+	data := []byte("hello from the transactions example")
+	nonce, err := client.PendingNonceAt(ctx, from)
+	if err != nil {
+		demo.Log.Crit("get nonce fail", "err", err)
+	}
+	gasPrice, err := client.SuggestGasPrice(ctx)
+	if err != nil {
+		demo.Log.Crit("suggest gas price fail", "err", err)
+	}
+
+	// the node runs the transaction on the pending state to see what it takes
+	// a plain transfer takes 21000 gas, every byte of data adds 4 if it's zero and 68 if not
+	gas, err := client.EstimateGas(ctx, ethereum.CallMsg{From: from, To: &to, Value: value, Data: data})
+	if err != nil {
+		demo.Log.Crit("estimate gas fail", "err", err)
+	}
+	tx, err := types.SignTx(types.NewTransaction(nonce, to, value, gas, gasPrice, data), signer, key)
+	if err != nil {
+		demo.Log.Crit("sign fail", "err", err)
+	}
+	v, _, _ := tx.RawSignatureValues()
+	fmt.Printf("\n%-12s %s\n", "hash", tx.Hash().Hex())
+	fmt.Printf("%-12s %d\n", "nonce", tx.Nonce())
+	fmt.Printf("%-12s %d\n", "gas price", tx.GasPrice())
+	fmt.Printf("%-12s %d\n", "gas", tx.Gas())
+	fmt.Printf("%-12s %v\n", "protected", tx.Protected())
+	fmt.Printf("%-12s %d (chain id * 2 + 35 or 36)\n", "v", v)
+
+	// the sender is recovered from the signature, with the chain id
+	// a signer for another chain doesn't accept it
+	sender, err := types.Sender(signer, tx)
+	if err != nil {
+		demo.Log.Crit("recover sender fail", "err", err)
+	}
+	fmt.Printf("%-12s %s\n", "sender", sender.Hex())
+	_, err = types.Sender(types.NewEIP155Signer(big.NewInt(1)), tx)
+	fmt.Printf("%-12s %v\n", "on chain 1", err)
+
+	err = client.SendTransaction(ctx, tx)
+	if err != nil {
+		demo.Log.Crit("send fail", "err", err)
+	}
+	receipt, err := bind.WaitMined(ctx, client, tx)
+	if err != nil {
+		demo.Log.Crit("wait mined fail", "err", err)
+	}
+	fmt.Printf("%-12s %d\n", "status", receipt.Status)
+	fmt.Printf("%-12s %d of %d estimated\n", "gas used", receipt.GasUsed, gas)
+
+	// senders asking the node for the nonce each on their own get the same one when they ask at the same time
+	// the node takes the first transaction with a nonce, and turns away the others
+	failed := sendAll(ctx, client, signer, keys[1], func(ctx context.Context, account common.Address) (uint64, error) {
+		return client.PendingNonceAt(ctx, account)
+	}, nil)
+	turnedAway := 0
+	for _, count := range failed {
+		turnedAway += count
+	}
+	fmt.Printf("\nwithout a nonce manager, %d of %d transactions were turned away\n", turnedAway, workerCount*txsPerWorker)
+	for err, count := range failed {
+		fmt.Printf("  %-40s %d\n", err, count)
+	}
+
+	// with the nonce manager every transaction gets a nonce of its own
+	// every tenth transaction is first sent with less gas than any transaction takes, and the node turns it away before it takes the nonce
+	// the nonce goes back to the manager, so there's no gap
+	manager := newNonceManager(client)
+	var wg sync.WaitGroup
+	results := make([]map[string]int, senderCount)
+	for i := 0; i < senderCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = sendAll(ctx, client, signer, keys[2+i], manager.Next, manager.Release)
+		}(i)
+	}
+	wg.Wait()
+
+	fmt.Printf("\n%-44s %-8s %-8s %s\n", "sender", "sent", "nonce", "turned away")
+	for i, key := range keys[2:] {
+		account := crypto.PubkeyToAddress(key.PublicKey)
+		nonce, err := client.NonceAt(ctx, account, nil)
+		if err != nil {
+			demo.Log.Crit("get nonce fail", "err", err)
+		}
+		turnedAway := 0
+		for _, count := range results[i] {
+			turnedAway += count
+		}
+		fmt.Printf("%-44s %-8d %-8d %d\n", account.Hex(), workerCount*txsPerWorker, nonce, turnedAway)
+		if nonce != uint64(workerCount*txsPerWorker) {
+			demo.Log.Crit("transactions missing", "sender", account.Hex(), "nonce", nonce)
+		}
+	}
+}
+
+// sends from the key in several goroutines at once, and waits until what the node took is mined
+// returns the errors of the transactions the node turned away, counted
+// with release set, a transaction the node turns away is sent again with the nonce it gets back
+func sendAll(ctx context.Context, client *ethclient.Client, signer types.Signer, key *ecdsa.PrivateKey, next func(context.Context, common.Address) (uint64, error), release func(common.Address, uint64)) map[string]int {
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	var mu sync.Mutex
+	var txs []sent
+	failed := make(map[string]int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workerCount; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < txsPerWorker; i++ {
+				to := common.BigToAddress(big.NewInt(int64(0x1000 + w*txsPerWorker + i))) // clear of the precompiled contracts at the low addresses
+				msg := ethereum.CallMsg{From: from, To: &to, Value: big.NewInt(1)}
+				estimate, err := client.EstimateGas(ctx, msg)
+				if err != nil {
+					demo.Log.Crit("estimate gas fail", "err", err)
+				}
+				gas := estimate
+				if release != nil && (w*txsPerWorker+i)%10 == 0 {
+					gas = params.TxGas - 1
+				}
+				for {
+					nonce, err := next(ctx, from)
+					if err != nil {
+						demo.Log.Crit("get nonce fail", "err", err)
+					}
+					tx, err := types.SignTx(types.NewTransaction(nonce, to, msg.Value, gas, big.NewInt(1), nil), signer, key)
+					if err != nil {
+						demo.Log.Crit("sign fail", "err", err)
+					}
+					err = client.SendTransaction(ctx, tx)
+					mu.Lock()
+					if err != nil {
+						failed[err.Error()]++
+					} else {
+						txs = append(txs, sent{tx: tx, estimate: estimate})
+					}
+					mu.Unlock()
+					if err == nil || release == nil {
+						break
+					}
+					demo.Log.Debug("sent again", "from", from.Hex(), "nonce", nonce, "err", err)
+					release(from, nonce)
+					gas = estimate
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, s := range txs {
+		receipt, err := bind.WaitMined(ctx, client, s.tx)
+		if err != nil {
+			demo.Log.Crit("wait mined fail", "err", err)
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful || receipt.GasUsed != s.estimate {
+			demo.Log.Crit("unexpected receipt", "tx", s.tx.Hash().Hex(), "status", receipt.Status, "gas", receipt.GasUsed, "estimate", s.estimate)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,203 @@
+// Package auction holds sealed-bid auctions over p2p, settled on chain by the Settlement contract
+//
+// the bidders send the auctioneer commitments to their bids, signed, and open them once the auctioneer published all the commitments it took
+// the highest bid that opens wins and pays what it bid; the root of the commitments goes on chain with the winner,
+// so every bidder can check the outcome against the same bids the auctioneer settled on
+package auction
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	ErrBidMissing   = errors.New("bid left out")
+	ErrRoot         = errors.New("bids don't match the root settled")
+	ErrWinner       = errors.New("winner settled isn't the highest bid")
+	ErrNotSettled   = errors.New("auction not settled")
+	ErrOpening      = errors.New("opening doesn't match a bid")
+	ErrOpeningLeft  = errors.New("opening left out")
+	ErrSignature    = errors.New("bid not signed by its bidder")
+	ErrDoubleBid    = errors.New("more than one bid of the bidder")
+	ErrWrongAuction = errors.New("bid of another auction")
+)
+
+// Bid is sealed, the amount is only in the commitment
+type Bid struct {
+	Auction    common.Hash
+	Bidder     common.Address
+	Commitment common.Hash
+	Signature  []byte
+}
+
+// Opening is the amount of a bid and the salt, which give its commitment
+type Opening struct {
+	Auction common.Hash
+	Bidder  common.Address
+	Amount  *big.Int
+	Salt    []byte
+}
+
+// Settled is what the contract holds of an auction
+type Settled struct {
+	Winner common.Address
+	Price  *big.Int
+	Root   common.Hash
+	Paid   bool
+}
+
+// ReadSettled reads the settlement of the auction from the contract
+func ReadSettled(contract *SettlementCaller, auction common.Hash) (*Settled, error) {
+	a, err := contract.Auctions(nil, auction)
+	if err != nil {
+		return nil, err
+	}
+	return &Settled{Winner: a.Winner, Price: a.Price, Root: a.Root, Paid: a.Paid}, nil
+}
+
+// the commitment binds the amount to the auction and the bidder, so it can't be copied to another
+func Commitment(auction common.Hash, bidder common.Address, amount *big.Int, salt []byte) common.Hash {
+	return crypto.Keccak256Hash(auction[:], bidder[:], common.LeftPadBytes(amount.Bytes(), 32), salt)
+}
+
+// the hash that is signed, prefixed so a signature over it can't pass for a transaction's
+func bidHash(auction common.Hash, commitment common.Hash) []byte {
+	return crypto.Keccak256([]byte("\x19auction bid:"), auction[:], commitment[:])
+}
+
+// NewBid seals the amount with a random salt, and signs the commitment
+// the opening is kept by the bidder until the auctioneer published the bids
+func NewBid(auction common.Hash, amount *big.Int, key *ecdsa.PrivateKey) (*Bid, *Opening, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+	bidder := crypto.PubkeyToAddress(key.PublicKey)
+	o := &Opening{
+		Auction: auction,
+		Bidder:  bidder,
+		Amount:  new(big.Int).Set(amount),
+		Salt:    salt,
+	}
+	b := &Bid{
+		Auction:    auction,
+		Bidder:     bidder,
+		Commitment: o.Commitment(),
+	}
+	sig, err := crypto.Sign(bidHash(auction, b.Commitment), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	b.Signature = sig
+	return b, o, nil
+}
+
+// Verify checks the bid was signed by its bidder
+func (self *Bid) Verify() error {
+	pub, err := crypto.SigToPub(bidHash(self.Auction, self.Commitment), self.Signature)
+	if err != nil {
+		return ErrSignature
+	}
+	if crypto.PubkeyToAddress(*pub) != self.Bidder {
+		return ErrSignature
+	}
+	return nil
+}
+
+func (self *Opening) Commitment() common.Hash {
+	return Commitment(self.Auction, self.Bidder, self.Amount, self.Salt)
+}
+
+// Root of the bids, in the order the auctioneer published them
+func Root(bids []*Bid) common.Hash {
+	var buf bytes.Buffer
+	for _, b := range bids {
+		buf.Write(b.Commitment[:])
+	}
+	return crypto.Keccak256Hash(buf.Bytes())
+}
+
+// Winner is the highest of the openings that match a bid, the earlier bid of two as high
+// the openings that don't match are left out, as if their bid never opened
+func Winner(bids []*Bid, openings []*Opening) *Opening {
+	index := make(map[common.Address]int)
+	for i, b := range bids {
+		index[b.Bidder] = i
+	}
+	var winner *Opening
+	var at int
+	for _, o := range openings {
+		i, ok := index[o.Bidder]
+		if !ok || o.Commitment() != bids[i].Commitment {
+			continue
+		}
+		if winner == nil || o.Amount.Cmp(winner.Amount) > 0 || (o.Amount.Cmp(winner.Amount) == 0 && i < at) {
+			winner, at = o, i
+		}
+	}
+	return winner
+}
+
+// CheckBids is what the bidder checks of the bids the auctioneer published, before it opens its own:
+// every bid of the auction and signed, one for each bidder, and its own among them
+func CheckBids(auction common.Hash, bids []*Bid, own *Bid) error {
+	seen := make(map[common.Address]bool)
+	found := false
+	for _, b := range bids {
+		if b.Auction != auction {
+			return ErrWrongAuction
+		}
+		if err := b.Verify(); err != nil {
+			return fmt.Errorf("%v: %x", err, b.Bidder)
+		}
+		if seen[b.Bidder] {
+			return fmt.Errorf("%v: %x", ErrDoubleBid, b.Bidder)
+		}
+		seen[b.Bidder] = true
+		if own != nil && b.Commitment == own.Commitment {
+			found = true
+		}
+	}
+	if own != nil && !found {
+		return ErrBidMissing
+	}
+	return nil
+}
+
+// CheckOutcome is what any bidder, a loser most of all, checks of the settlement:
+// the bids are the ones settled on, the openings are of those bids and its own among them, and the winner is the highest of them at the price it bid
+func CheckOutcome(auction common.Hash, bids []*Bid, openings []*Opening, own *Opening, settled *Settled) error {
+	if settled == nil || settled.Winner == (common.Address{}) {
+		return ErrNotSettled
+	}
+	if Root(bids) != settled.Root {
+		return ErrRoot
+	}
+	if err := CheckBids(auction, bids, nil); err != nil {
+		return err
+	}
+	found := false
+	for _, o := range openings {
+		if Winner(bids, []*Opening{o}) == nil {
+			return fmt.Errorf("%v: %x", ErrOpening, o.Bidder)
+		}
+		if own != nil && o.Commitment() == own.Commitment() {
+			found = true
+		}
+	}
+	if own != nil && !found {
+		return ErrOpeningLeft
+	}
+	winner := Winner(bids, openings)
+	if winner == nil || winner.Bidder != settled.Winner || winner.Amount.Cmp(settled.Price) != 0 {
+		return ErrWinner
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package auction
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var testAuction = common.HexToHash("0xa0c7")
+
+func newKeys(t *testing.T, n int) []*ecdsa.PrivateKey {
+	var keys []*ecdsa.PrivateKey
+	for i := 0; i < n; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// a bid of each key, of the amounts
+func newBids(t *testing.T, keys []*ecdsa.PrivateKey, amounts ...int64) ([]*Bid, []*Opening) {
+	var bids []*Bid
+	var openings []*Opening
+	for i, key := range keys {
+		b, o, err := NewBid(testAuction, big.NewInt(amounts[i]), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bids = append(bids, b)
+		openings = append(openings, o)
+	}
+	return bids, openings
+}
+
+func TestCheckBids(t *testing.T) {
+	keys := newKeys(t, 3)
+	bids, _ := newBids(t, keys, 10, 30, 20)
+	if err := CheckBids(testAuction, bids, bids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckBids(testAuction, bids[:1], bids[1]); err != ErrBidMissing {
+		t.Fatalf("bid left out: %v", err)
+	}
+	if err := CheckBids(common.Hash{}, bids, nil); err != ErrWrongAuction {
+		t.Fatalf("bids of another auction: %v", err)
+	}
+	// a bid passed for another bidder's
+	forged := *bids[0]
+	forged.Bidder = bids[2].Bidder
+	if err := CheckBids(testAuction, []*Bid{&forged, bids[1]}, nil); err == nil {
+		t.Fatal("forged bid accepted")
+	}
+	again, _, err := NewBid(testAuction, big.NewInt(40), keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckBids(testAuction, append(bids, again), nil); err == nil {
+		t.Fatal("two bids of a bidder accepted")
+	}
+}
+
+func TestCheckOutcome(t *testing.T) {
+	keys := newKeys(t, 3)
+	bids, openings := newBids(t, keys, 10, 30, 20)
+	root := Root(bids)
+	winner := Winner(bids, openings)
+	if winner != openings[1] {
+		t.Fatalf("winner %x bid %v", winner.Bidder, winner.Amount)
+	}
+	honest := &Settled{Winner: openings[1].Bidder, Price: big.NewInt(30), Root: root}
+	for i := range openings {
+		if err := CheckOutcome(testAuction, bids, openings, openings[i], honest); err != nil {
+			t.Fatalf("bidder %d: %v", i, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		desc     string
+		bids     []*Bid
+		openings []*Opening
+		own      *Opening
+		settled  *Settled
+		err      error
+	}{
+		{"not settled", bids, openings, nil, &Settled{}, ErrNotSettled},
+		{"settled to the lower", bids, openings, nil, &Settled{Winner: openings[2].Bidder, Price: big.NewInt(20), Root: root}, ErrWinner},
+		{"settled below the bid", bids, openings, nil, &Settled{Winner: openings[1].Bidder, Price: big.NewInt(20), Root: root}, ErrWinner},
+		{"bid left out", bids[:2], openings, nil, honest, ErrRoot},
+		{"opening left out", bids, []*Opening{openings[0], openings[2]}, openings[1], &Settled{Winner: openings[2].Bidder, Price: big.NewInt(20), Root: root}, ErrOpeningLeft},
+	} {
+		if err := CheckOutcome(testAuction, tt.bids, tt.openings, tt.own, tt.settled); err != tt.err {
+			t.Fatalf("%s: got %v, expected %v", tt.desc, err, tt.err)
+		}
+	}
+
+	// an opening of another amount than was bid doesn't pass
+	raised := *openings[0]
+	raised.Amount = big.NewInt(50)
+	if err := CheckOutcome(testAuction, bids, []*Opening{&raised, openings[1], openings[2]}, nil, honest); err == nil {
+		t.Fatal("raised opening accepted")
+	}
+	if w := Winner(bids, []*Opening{&raised, openings[2]}); w != openings[2] {
+		t.Fatal("raised opening won")
+	}
+}
+
+// only the owner settles, once, and only the winner pays, the price, which goes to the owner
+func TestSettlement(t *testing.T) {
+	keys := newKeys(t, 3)
+	owner, winner, other := keys[0], keys[1], keys[2]
+	alloc := core.GenesisAlloc{}
+	for _, k := range keys {
+		alloc[crypto.PubkeyToAddress(k.PublicKey)] = core.GenesisAccount{Balance: big.NewInt(1000000000000000000)}
+	}
+	backend := backends.NewSimulatedBackend(alloc, 10000000)
+	addr, _, contract, err := DeploySettlement(bind.NewKeyedTransactor(owner), backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+
+	bids, openings := newBids(t, keys[1:], 30, 20)
+	root := Root(bids)
+	price := openings[0].Amount
+	winnerAddr := crypto.PubkeyToAddress(winner.PublicKey)
+	if _, err := contract.Settle(bind.NewKeyedTransactor(other), testAuction, root, winnerAddr, price); err == nil {
+		t.Fatal("settled by another than the owner")
+	}
+	if _, err := contract.Settle(bind.NewKeyedTransactor(owner), testAuction, root, winnerAddr, price); err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+	if _, err := contract.Settle(bind.NewKeyedTransactor(owner), testAuction, root, winnerAddr, price); err == nil {
+		t.Fatal("settled twice")
+	}
+
+	settled, err := ReadSettled(&contract.SettlementCaller, testAuction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckOutcome(testAuction, bids, openings, openings[1], settled); err != nil {
+		t.Fatal(err)
+	}
+
+	pay := func(key *ecdsa.PrivateKey, amount *big.Int) error {
+		opts := bind.NewKeyedTransactor(key)
+		opts.Value = amount
+		_, err := contract.Pay(opts, testAuction)
+		return err
+	}
+	if err := pay(other, price); err == nil {
+		t.Fatal("paid by another than the winner")
+	}
+	if err := pay(winner, big.NewInt(29)); err == nil {
+		t.Fatal("paid less than the price")
+	}
+	before, err := backend.BalanceAt(nil, crypto.PubkeyToAddress(owner.PublicKey), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pay(winner, price); err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+	if err := pay(winner, price); err == nil {
+		t.Fatal("paid twice")
+	}
+	after, err := backend.BalanceAt(nil, crypto.PubkeyToAddress(owner.PublicKey), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if new(big.Int).Sub(after, before).Cmp(price) != 0 {
+		t.Fatalf("owner got %v, expected %v", new(big.Int).Sub(after, before), price)
+	}
+	if settled, err = ReadSettled(&contract.SettlementCaller, testAuction); err != nil || !settled.Paid {
+		t.Fatalf("not paid: %v", err)
+	}
+	if held, err := backend.BalanceAt(nil, addr, nil); err != nil || held.Sign() != 0 {
+		t.Fatalf("contract holds %v: %v", held, err)
+	}
+
+	it, err := contract.FilterSettled(&bind.FilterOpts{}, [][32]byte{testAuction}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() || it.Event.Winner != winnerAddr || it.Event.Price.Cmp(price) != 0 || it.Event.Root != root {
+		t.Fatal("no settled event")
+	}
+}
@@ -0,0 +1,586 @@
+// Code generated - DO NOT EDIT.
+// This file is a generated binding and any manual changes will be lost.
+
+package auction
+
+import (
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var (
+	_ = big.NewInt
+	_ = strings.NewReader
+	_ = ethereum.NotFound
+	_ = abi.U256
+	_ = bind.Bind
+	_ = common.Big1
+	_ = types.BloomLookup
+	_ = event.NewSubscription
+)
+
+// SettlementABI is the input ABI used to generate the binding from.
+const SettlementABI = "[{\"constant\":false,\"inputs\":[{\"name\":\"id\",\"type\":\"bytes32\"},{\"name\":\"root\",\"type\":\"bytes32\"},{\"name\":\"winner\",\"type\":\"address\"},{\"name\":\"price\",\"type\":\"uint256\"}],\"name\":\"settle\",\"outputs\":[],\"payable\":false,\"stateMutability\":\"nonpayable\",\"type\":\"function\"},{\"constant\":false,\"inputs\":[{\"name\":\"id\",\"type\":\"bytes32\"}],\"name\":\"pay\",\"outputs\":[],\"payable\":true,\"stateMutability\":\"payable\",\"type\":\"function\"},{\"constant\":true,\"inputs\":[{\"name\":\"id\",\"type\":\"bytes32\"}],\"name\":\"auctions\",\"outputs\":[{\"name\":\"winner\",\"type\":\"address\"},{\"name\":\"price\",\"type\":\"uint256\"},{\"name\":\"root\",\"type\":\"bytes32\"},{\"name\":\"paid\",\"type\":\"bool\"}],\"payable\":false,\"stateMutability\":\"view\",\"type\":\"function\"},{\"constant\":true,\"inputs\":[],\"name\":\"owner\",\"outputs\":[{\"name\":\"\",\"type\":\"address\"}],\"payable\":false,\"stateMutability\":\"view\",\"type\":\"function\"},{\"anonymous\":false,\"inputs\":[{\"indexed\":true,\"name\":\"id\",\"type\":\"bytes32\"},{\"indexed\":true,\"name\":\"winner\",\"type\":\"address\"},{\"indexed\":false,\"name\":\"price\",\"type\":\"uint256\"},{\"indexed\":false,\"name\":\"root\",\"type\":\"bytes32\"}],\"name\":\"Settled\",\"type\":\"event\"},{\"anonymous\":false,\"inputs\":[{\"indexed\":true,\"name\":\"id\",\"type\":\"bytes32\"},{\"indexed\":true,\"name\":\"winner\",\"type\":\"address\"},{\"indexed\":false,\"name\":\"amount\",\"type\":\"uint256\"}],\"name\":\"Paid\",\"type\":\"event\"}]"
+
+// SettlementBin is the compiled bytecode used for deploying new contracts.
+const SettlementBin = `336000556101848060106000396000f36000357c0100000000000000000000000000000000000000000000000000000000900480638609cad11463000000d35734630000005e578063f00e0b861463000000635780631edbc5be14630000014b5780638da5cb5b146300000178575b600080fd5b600054331415630000005e5760043560005260206000208054630000005e576044358015630000005e578082556064358083600101556024358084600201556020526000526004357f21de41055b4c56c0b51e97a1bb6664ad4f3cab9b004c525ca3865e71cc03941860406000a3005b60043560005260206000208054331415630000005e578060030154630000005e578060010154341415630000005e57600181600301556000808080346000545af115630000005e5734600052336004357f606160394df2742a67735645b7e783304d61d2dbc5b550e8c6acfaf27b6291d760206000a3005b60043560005260206000208054600052806001015460205280600201546040526003015460605260806000f35b60005460005260206000f3`
+
+// DeploySettlement deploys a new Ethereum contract, binding an instance of Settlement to it.
+func DeploySettlement(auth *bind.TransactOpts, backend bind.ContractBackend) (common.Address, *types.Transaction, *Settlement, error) {
+	parsed, err := abi.JSON(strings.NewReader(SettlementABI))
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	address, tx, contract, err := bind.DeployContract(auth, parsed, common.FromHex(SettlementBin), backend)
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	return address, tx, &Settlement{SettlementCaller: SettlementCaller{contract: contract}, SettlementTransactor: SettlementTransactor{contract: contract}, SettlementFilterer: SettlementFilterer{contract: contract}}, nil
+}
+
+// Settlement is an auto generated Go binding around an Ethereum contract.
+type Settlement struct {
+	SettlementCaller     // Read-only binding to the contract
+	SettlementTransactor // Write-only binding to the contract
+	SettlementFilterer   // Log filterer for contract events
+}
+
+// SettlementCaller is an auto generated read-only Go binding around an Ethereum contract.
+type SettlementCaller struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// SettlementTransactor is an auto generated write-only Go binding around an Ethereum contract.
+type SettlementTransactor struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// SettlementFilterer is an auto generated log filtering Go binding around an Ethereum contract events.
+type SettlementFilterer struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// SettlementSession is an auto generated Go binding around an Ethereum contract,
+// with pre-set call and transact options.
+type SettlementSession struct {
+	Contract     *Settlement       // Generic contract binding to set the session for
+	CallOpts     bind.CallOpts     // Call options to use throughout this session
+	TransactOpts bind.TransactOpts // Transaction auth options to use throughout this session
+}
+
+// SettlementCallerSession is an auto generated read-only Go binding around an Ethereum contract,
+// with pre-set call options.
+type SettlementCallerSession struct {
+	Contract *SettlementCaller // Generic contract caller binding to set the session for
+	CallOpts bind.CallOpts     // Call options to use throughout this session
+}
+
+// SettlementTransactorSession is an auto generated write-only Go binding around an Ethereum contract,
+// with pre-set transact options.
+type SettlementTransactorSession struct {
+	Contract     *SettlementTransactor // Generic contract transactor binding to set the session for
+	TransactOpts bind.TransactOpts     // Transaction auth options to use throughout this session
+}
+
+// SettlementRaw is an auto generated low-level Go binding around an Ethereum contract.
+type SettlementRaw struct {
+	Contract *Settlement // Generic contract binding to access the raw methods on
+}
+
+// SettlementCallerRaw is an auto generated low-level read-only Go binding around an Ethereum contract.
+type SettlementCallerRaw struct {
+	Contract *SettlementCaller // Generic read-only contract binding to access the raw methods on
+}
+
+// SettlementTransactorRaw is an auto generated low-level write-only Go binding around an Ethereum contract.
+type SettlementTransactorRaw struct {
+	Contract *SettlementTransactor // Generic write-only contract binding to access the raw methods on
+}
+
+// NewSettlement creates a new instance of Settlement, bound to a specific deployed contract.
+func NewSettlement(address common.Address, backend bind.ContractBackend) (*Settlement, error) {
+	contract, err := bindSettlement(address, backend, backend, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &Settlement{SettlementCaller: SettlementCaller{contract: contract}, SettlementTransactor: SettlementTransactor{contract: contract}, SettlementFilterer: SettlementFilterer{contract: contract}}, nil
+}
+
+// NewSettlementCaller creates a new read-only instance of Settlement, bound to a specific deployed contract.
+func NewSettlementCaller(address common.Address, caller bind.ContractCaller) (*SettlementCaller, error) {
+	contract, err := bindSettlement(address, caller, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SettlementCaller{contract: contract}, nil
+}
+
+// NewSettlementTransactor creates a new write-only instance of Settlement, bound to a specific deployed contract.
+func NewSettlementTransactor(address common.Address, transactor bind.ContractTransactor) (*SettlementTransactor, error) {
+	contract, err := bindSettlement(address, nil, transactor, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SettlementTransactor{contract: contract}, nil
+}
+
+// NewSettlementFilterer creates a new log filterer instance of Settlement, bound to a specific deployed contract.
+func NewSettlementFilterer(address common.Address, filterer bind.ContractFilterer) (*SettlementFilterer, error) {
+	contract, err := bindSettlement(address, nil, nil, filterer)
+	if err != nil {
+		return nil, err
+	}
+	return &SettlementFilterer{contract: contract}, nil
+}
+
+// bindSettlement binds a generic wrapper to an already deployed contract.
+func bindSettlement(address common.Address, caller bind.ContractCaller, transactor bind.ContractTransactor, filterer bind.ContractFilterer) (*bind.BoundContract, error) {
+	parsed, err := abi.JSON(strings.NewReader(SettlementABI))
+	if err != nil {
+		return nil, err
+	}
+	return bind.NewBoundContract(address, parsed, caller, transactor, filterer), nil
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_Settlement *SettlementRaw) Call(opts *bind.CallOpts, result interface{}, method string, params ...interface{}) error {
+	return _Settlement.Contract.SettlementCaller.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_Settlement *SettlementRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _Settlement.Contract.SettlementTransactor.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_Settlement *SettlementRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _Settlement.Contract.SettlementTransactor.contract.Transact(opts, method, params...)
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_Settlement *SettlementCallerRaw) Call(opts *bind.CallOpts, result interface{}, method string, params ...interface{}) error {
+	return _Settlement.Contract.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_Settlement *SettlementTransactorRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _Settlement.Contract.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_Settlement *SettlementTransactorRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _Settlement.Contract.contract.Transact(opts, method, params...)
+}
+
+// Auctions is a free data retrieval call binding the contract method 0x1edbc5be.
+//
+// Solidity: function auctions(id bytes32) constant returns(winner address, price uint256, root bytes32, paid bool)
+func (_Settlement *SettlementCaller) Auctions(opts *bind.CallOpts, id [32]byte) (struct {
+	Winner common.Address
+	Price  *big.Int
+	Root   [32]byte
+	Paid   bool
+}, error) {
+	ret := new(struct {
+		Winner common.Address
+		Price  *big.Int
+		Root   [32]byte
+		Paid   bool
+	})
+	out := ret
+	err := _Settlement.contract.Call(opts, out, "auctions", id)
+	return *ret, err
+}
+
+// Auctions is a free data retrieval call binding the contract method 0x1edbc5be.
+//
+// Solidity: function auctions(id bytes32) constant returns(winner address, price uint256, root bytes32, paid bool)
+func (_Settlement *SettlementSession) Auctions(id [32]byte) (struct {
+	Winner common.Address
+	Price  *big.Int
+	Root   [32]byte
+	Paid   bool
+}, error) {
+	return _Settlement.Contract.Auctions(&_Settlement.CallOpts, id)
+}
+
+// Auctions is a free data retrieval call binding the contract method 0x1edbc5be.
+//
+// Solidity: function auctions(id bytes32) constant returns(winner address, price uint256, root bytes32, paid bool)
+func (_Settlement *SettlementCallerSession) Auctions(id [32]byte) (struct {
+	Winner common.Address
+	Price  *big.Int
+	Root   [32]byte
+	Paid   bool
+}, error) {
+	return _Settlement.Contract.Auctions(&_Settlement.CallOpts, id)
+}
+
+// Owner is a free data retrieval call binding the contract method 0x8da5cb5b.
+//
+// Solidity: function owner() constant returns(address)
+func (_Settlement *SettlementCaller) Owner(opts *bind.CallOpts) (common.Address, error) {
+	var (
+		ret0 = new(common.Address)
+	)
+	out := ret0
+	err := _Settlement.contract.Call(opts, out, "owner")
+	return *ret0, err
+}
+
+// Owner is a free data retrieval call binding the contract method 0x8da5cb5b.
+//
+// Solidity: function owner() constant returns(address)
+func (_Settlement *SettlementSession) Owner() (common.Address, error) {
+	return _Settlement.Contract.Owner(&_Settlement.CallOpts)
+}
+
+// Owner is a free data retrieval call binding the contract method 0x8da5cb5b.
+//
+// Solidity: function owner() constant returns(address)
+func (_Settlement *SettlementCallerSession) Owner() (common.Address, error) {
+	return _Settlement.Contract.Owner(&_Settlement.CallOpts)
+}
+
+// Pay is a paid mutator transaction binding the contract method 0x8609cad1.
+//
+// Solidity: function pay(id bytes32) returns()
+func (_Settlement *SettlementTransactor) Pay(opts *bind.TransactOpts, id [32]byte) (*types.Transaction, error) {
+	return _Settlement.contract.Transact(opts, "pay", id)
+}
+
+// Pay is a paid mutator transaction binding the contract method 0x8609cad1.
+//
+// Solidity: function pay(id bytes32) returns()
+func (_Settlement *SettlementSession) Pay(id [32]byte) (*types.Transaction, error) {
+	return _Settlement.Contract.Pay(&_Settlement.TransactOpts, id)
+}
+
+// Pay is a paid mutator transaction binding the contract method 0x8609cad1.
+//
+// Solidity: function pay(id bytes32) returns()
+func (_Settlement *SettlementTransactorSession) Pay(id [32]byte) (*types.Transaction, error) {
+	return _Settlement.Contract.Pay(&_Settlement.TransactOpts, id)
+}
+
+// Settle is a paid mutator transaction binding the contract method 0xf00e0b86.
+//
+// Solidity: function settle(id bytes32, root bytes32, winner address, price uint256) returns()
+func (_Settlement *SettlementTransactor) Settle(opts *bind.TransactOpts, id [32]byte, root [32]byte, winner common.Address, price *big.Int) (*types.Transaction, error) {
+	return _Settlement.contract.Transact(opts, "settle", id, root, winner, price)
+}
+
+// Settle is a paid mutator transaction binding the contract method 0xf00e0b86.
+//
+// Solidity: function settle(id bytes32, root bytes32, winner address, price uint256) returns()
+func (_Settlement *SettlementSession) Settle(id [32]byte, root [32]byte, winner common.Address, price *big.Int) (*types.Transaction, error) {
+	return _Settlement.Contract.Settle(&_Settlement.TransactOpts, id, root, winner, price)
+}
+
+// Settle is a paid mutator transaction binding the contract method 0xf00e0b86.
+//
+// Solidity: function settle(id bytes32, root bytes32, winner address, price uint256) returns()
+func (_Settlement *SettlementTransactorSession) Settle(id [32]byte, root [32]byte, winner common.Address, price *big.Int) (*types.Transaction, error) {
+	return _Settlement.Contract.Settle(&_Settlement.TransactOpts, id, root, winner, price)
+}
+
+// SettlementPaidIterator is returned from FilterPaid and is used to iterate over the raw logs and unpacked data for Paid events raised by the Settlement contract.
+type SettlementPaidIterator struct {
+	Event *SettlementPaid // Event containing the contract specifics and raw log
+
+	contract *bind.BoundContract // Generic contract to use for unpacking event data
+	event    string              // Event name to use for unpacking event data
+
+	logs chan types.Log        // Log channel receiving the found contract events
+	sub  ethereum.Subscription // Subscription for errors, completion and termination
+	done bool                  // Whether the subscription completed delivering logs
+	fail error                 // Occurred error to stop iteration
+}
+
+// Next advances the iterator to the subsequent event, returning whether there
+// are any more events found. In case of a retrieval or parsing error, false is
+// returned and Error() can be queried for the exact failure.
+func (it *SettlementPaidIterator) Next() bool {
+	// If the iterator failed, stop iterating
+	if it.fail != nil {
+		return false
+	}
+	// If the iterator completed, deliver directly whatever's available
+	if it.done {
+		select {
+		case log := <-it.logs:
+			it.Event = new(SettlementPaid)
+			if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+				it.fail = err
+				return false
+			}
+			it.Event.Raw = log
+			return true
+
+		default:
+			return false
+		}
+	}
+	// Iterator still in progress, wait for either a data or an error event
+	select {
+	case log := <-it.logs:
+		it.Event = new(SettlementPaid)
+		if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+			it.fail = err
+			return false
+		}
+		it.Event.Raw = log
+		return true
+
+	case err := <-it.sub.Err():
+		it.done = true
+		it.fail = err
+		return it.Next()
+	}
+}
+
+// Error returns any retrieval or parsing error occurred during filtering.
+func (it *SettlementPaidIterator) Error() error {
+	return it.fail
+}
+
+// Close terminates the iteration process, releasing any pending underlying
+// resources.
+func (it *SettlementPaidIterator) Close() error {
+	it.sub.Unsubscribe()
+	return nil
+}
+
+// SettlementPaid represents a Paid event raised by the Settlement contract.
+type SettlementPaid struct {
+	Id     [32]byte
+	Winner common.Address
+	Amount *big.Int
+	Raw    types.Log // Blockchain specific contextual infos
+}
+
+// FilterPaid is a free log retrieval operation binding the contract event 0x606160394df2742a67735645b7e783304d61d2dbc5b550e8c6acfaf27b6291d7.
+//
+// Solidity: e Paid(id indexed bytes32, winner indexed address, amount uint256)
+func (_Settlement *SettlementFilterer) FilterPaid(opts *bind.FilterOpts, id [][32]byte, winner []common.Address) (*SettlementPaidIterator, error) {
+
+	var idRule []interface{}
+	for _, idItem := range id {
+		idRule = append(idRule, idItem)
+	}
+	var winnerRule []interface{}
+	for _, winnerItem := range winner {
+		winnerRule = append(winnerRule, winnerItem)
+	}
+
+	logs, sub, err := _Settlement.contract.FilterLogs(opts, "Paid", idRule, winnerRule)
+	if err != nil {
+		return nil, err
+	}
+	return &SettlementPaidIterator{contract: _Settlement.contract, event: "Paid", logs: logs, sub: sub}, nil
+}
+
+// WatchPaid is a free log subscription operation binding the contract event 0x606160394df2742a67735645b7e783304d61d2dbc5b550e8c6acfaf27b6291d7.
+//
+// Solidity: e Paid(id indexed bytes32, winner indexed address, amount uint256)
+func (_Settlement *SettlementFilterer) WatchPaid(opts *bind.WatchOpts, sink chan<- *SettlementPaid, id [][32]byte, winner []common.Address) (event.Subscription, error) {
+
+	var idRule []interface{}
+	for _, idItem := range id {
+		idRule = append(idRule, idItem)
+	}
+	var winnerRule []interface{}
+	for _, winnerItem := range winner {
+		winnerRule = append(winnerRule, winnerItem)
+	}
+
+	logs, sub, err := _Settlement.contract.WatchLogs(opts, "Paid", idRule, winnerRule)
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case log := <-logs:
+				// New log arrived, parse the event and forward to the user
+				event := new(SettlementPaid)
+				if err := _Settlement.contract.UnpackLog(event, "Paid", log); err != nil {
+					return err
+				}
+				event.Raw = log
+
+				select {
+				case sink <- event:
+				case err := <-sub.Err():
+					return err
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+
+// SettlementSettledIterator is returned from FilterSettled and is used to iterate over the raw logs and unpacked data for Settled events raised by the Settlement contract.
+type SettlementSettledIterator struct {
+	Event *SettlementSettled // Event containing the contract specifics and raw log
+
+	contract *bind.BoundContract // Generic contract to use for unpacking event data
+	event    string              // Event name to use for unpacking event data
+
+	logs chan types.Log        // Log channel receiving the found contract events
+	sub  ethereum.Subscription // Subscription for errors, completion and termination
+	done bool                  // Whether the subscription completed delivering logs
+	fail error                 // Occurred error to stop iteration
+}
+
+// Next advances the iterator to the subsequent event, returning whether there
+// are any more events found. In case of a retrieval or parsing error, false is
+// returned and Error() can be queried for the exact failure.
+func (it *SettlementSettledIterator) Next() bool {
+	// If the iterator failed, stop iterating
+	if it.fail != nil {
+		return false
+	}
+	// If the iterator completed, deliver directly whatever's available
+	if it.done {
+		select {
+		case log := <-it.logs:
+			it.Event = new(SettlementSettled)
+			if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+				it.fail = err
+				return false
+			}
+			it.Event.Raw = log
+			return true
+
+		default:
+			return false
+		}
+	}
+	// Iterator still in progress, wait for either a data or an error event
+	select {
+	case log := <-it.logs:
+		it.Event = new(SettlementSettled)
+		if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+			it.fail = err
+			return false
+		}
+		it.Event.Raw = log
+		return true
+
+	case err := <-it.sub.Err():
+		it.done = true
+		it.fail = err
+		return it.Next()
+	}
+}
+
+// Error returns any retrieval or parsing error occurred during filtering.
+func (it *SettlementSettledIterator) Error() error {
+	return it.fail
+}
+
+// Close terminates the iteration process, releasing any pending underlying
+// resources.
+func (it *SettlementSettledIterator) Close() error {
+	it.sub.Unsubscribe()
+	return nil
+}
+
+// SettlementSettled represents a Settled event raised by the Settlement contract.
+type SettlementSettled struct {
+	Id     [32]byte
+	Winner common.Address
+	Price  *big.Int
+	Root   [32]byte
+	Raw    types.Log // Blockchain specific contextual infos
+}
+
+// FilterSettled is a free log retrieval operation binding the contract event 0x21de41055b4c56c0b51e97a1bb6664ad4f3cab9b004c525ca3865e71cc039418.
+//
+// Solidity: e Settled(id indexed bytes32, winner indexed address, price uint256, root bytes32)
+func (_Settlement *SettlementFilterer) FilterSettled(opts *bind.FilterOpts, id [][32]byte, winner []common.Address) (*SettlementSettledIterator, error) {
+
+	var idRule []interface{}
+	for _, idItem := range id {
+		idRule = append(idRule, idItem)
+	}
+	var winnerRule []interface{}
+	for _, winnerItem := range winner {
+		winnerRule = append(winnerRule, winnerItem)
+	}
+
+	logs, sub, err := _Settlement.contract.FilterLogs(opts, "Settled", idRule, winnerRule)
+	if err != nil {
+		return nil, err
+	}
+	return &SettlementSettledIterator{contract: _Settlement.contract, event: "Settled", logs: logs, sub: sub}, nil
+}
+
+// WatchSettled is a free log subscription operation binding the contract event 0x21de41055b4c56c0b51e97a1bb6664ad4f3cab9b004c525ca3865e71cc039418.
+//
+// Solidity: e Settled(id indexed bytes32, winner indexed address, price uint256, root bytes32)
+func (_Settlement *SettlementFilterer) WatchSettled(opts *bind.WatchOpts, sink chan<- *SettlementSettled, id [][32]byte, winner []common.Address) (event.Subscription, error) {
+
+	var idRule []interface{}
+	for _, idItem := range id {
+		idRule = append(idRule, idItem)
+	}
+	var winnerRule []interface{}
+	for _, winnerItem := range winner {
+		winnerRule = append(winnerRule, winnerItem)
+	}
+
+	logs, sub, err := _Settlement.contract.WatchLogs(opts, "Settled", idRule, winnerRule)
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case log := <-logs:
+				// New log arrived, parse the event and forward to the user
+				event := new(SettlementSettled)
+				if err := _Settlement.contract.UnpackLog(event, "Settled", log); err != nil {
+					return err
+				}
+				event.Raw = log
+
+				select {
+				case sink <- event:
+				case err := <-sub.Err():
+					return err
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
@@ -0,0 +1,226 @@
+package capability
+
+import (
+	"crypto/ecdsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const testNode = "aa11"
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newTestVerifier(key *ecdsa.PrivateKey, now time.Time) *Verifier {
+	v := NewVerifier(testNode, crypto.PubkeyToAddress(key.PublicKey))
+	v.Now = func() time.Time { return now }
+	return v
+}
+
+func TestAllows(t *testing.T) {
+	c := &Claims{Grants: []string{"admin_peers", "foo_*"}}
+	for method, want := range map[string]bool{
+		"admin_peers":   true,
+		"admin_addPeer": false,
+		"foo_ping":      true,
+		"foobar_ping":   false,
+		"pss_send":      false,
+	} {
+		if got := c.Allows(method); got != want {
+			t.Fatalf("%s allowed %v, want %v", method, got, want)
+		}
+	}
+	if !(&Claims{Grants: []string{"*"}}).Allows("admin_addPeer") {
+		t.Fatal("* doesn't allow all")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	admin := newTestKey(t)
+	now := time.Now().Round(time.Second)
+	claims := Claims{
+		Subject: "ops",
+		Node:    testNode,
+		Grants:  []string{"admin_peers"},
+		Issued:  now,
+		Expires: now.Add(time.Minute),
+	}
+	s, err := Issue(admin, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := newTestVerifier(admin, now)
+	tok, err := v.Verify(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Subject != "ops" || tok.Issuer != crypto.PubkeyToAddress(admin.PublicKey) || !tok.Expires.Equal(claims.Expires) {
+		t.Fatalf("token %+v", tok)
+	}
+
+	other, err := Issue(newTestKey(t), claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elsewhere := claims
+	elsewhere.Node = "bb22"
+	wrongNode, err := Issue(admin, elsewhere)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the claims of another token with the signature of this one
+	more := claims
+	more.Grants = []string{"*"}
+	tampered, err := Issue(newTestKey(t), more)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered = tampered[:strings.IndexByte(tampered, '.')] + s[strings.IndexByte(s, '.'):]
+
+	for name, c := range map[string]struct {
+		token string
+		now   time.Time
+		err   error
+	}{
+		"other issuer":  {other, now, ErrUnknownIssuer},
+		"wrong node":    {wrongNode, now, ErrWrongNode},
+		"tampered":      {tampered, now, ErrUnknownIssuer},
+		"expired":       {s, now.Add(time.Minute), ErrExpired},
+		"not yet valid": {s, now.Add(-time.Second), ErrNotYetValid},
+		"cut":           {s[:len(s)-4], now, ErrMalformed},
+		"no signature":  {s[:strings.IndexByte(s, '.')], now, ErrMalformed},
+	} {
+		v.Now = func() time.Time { return c.now }
+		if _, err := v.Verify(c.token); err != c.err {
+			t.Fatalf("%s: err %v, want %v", name, err, c.err)
+		}
+	}
+
+	// a token for any node
+	claims.Node = ""
+	if s, err = Issue(admin, claims); err != nil {
+		t.Fatal(err)
+	}
+	v.Now = func() time.Time { return now }
+	if _, err := v.Verify(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIssueFail(t *testing.T) {
+	admin := newTestKey(t)
+	if _, err := Issue(admin, Claims{Grants: []string{"admin_peers"}}); err == nil {
+		t.Fatal("issued a token that doesn't expire")
+	}
+	for _, g := range []string{"admin", "_peers", "admin_", "ad*_peers", "admin_p*"} {
+		if _, err := Issue(admin, Claims{Grants: []string{g}, Expires: time.Now()}); err == nil {
+			t.Fatalf("issued grant %s", g)
+		}
+	}
+}
+
+// the methods a node serves, as far as the tests go
+// exported, as the rpc server only takes exported types
+type AdminAPI struct {
+	added []string
+}
+
+func (a *AdminAPI) Peers() []string {
+	return a.added
+}
+
+func (a *AdminAPI) AddPeer(url string) bool {
+	a.added = append(a.added, url)
+	return true
+}
+
+func TestGuard(t *testing.T) {
+	admin := newTestKey(t)
+	api := &AdminAPI{}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("admin", api); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	v := newTestVerifier(admin, time.Now())
+	hs := httptest.NewServer(NewGuard(v, srv))
+	defer hs.Close()
+
+	tok, err := Issue(admin, Claims{Subject: "ops", Grants: []string{"admin_peers"}, Expires: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := DialHTTP(hs.URL, tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var peers []string
+	if err := client.Call(&peers, "admin_peers"); err != nil {
+		t.Fatal(err)
+	}
+	var ok bool
+	err = client.Call(&ok, "admin_addPeer", "enode://x")
+	if err == nil || !strings.Contains(err.Error(), "not granted to ops") {
+		t.Fatalf("err %v", err)
+	}
+	// one call not granted refuses the batch
+	batch := []rpc.BatchElem{
+		{Method: "admin_peers", Result: &peers},
+		{Method: "admin_addPeer", Args: []interface{}{"enode://y"}, Result: &ok},
+	}
+	if err := client.BatchCall(batch); err != nil {
+		t.Fatal(err)
+	}
+	if batch[0].Error == nil || batch[1].Error == nil {
+		t.Fatalf("batch errors %v, %v", batch[0].Error, batch[1].Error)
+	}
+	if len(api.added) != 0 {
+		t.Fatalf("peers added %v", api.added)
+	}
+
+	// without a token, and with one of someone else
+	for name, dial := range map[string]func() (*rpc.Client, error){
+		"no token": func() (*rpc.Client, error) {
+			return rpc.DialHTTP(hs.URL)
+		},
+		"other issuer": func() (*rpc.Client, error) {
+			tok, err := Issue(newTestKey(t), Claims{Grants: []string{"*"}, Expires: time.Now().Add(time.Minute)})
+			if err != nil {
+				return nil, err
+			}
+			return DialHTTP(hs.URL, tok)
+		},
+	} {
+		c, err := dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Call(&peers, "admin_peers")
+		c.Close()
+		if err == nil || !strings.Contains(err.Error(), "401") {
+			t.Fatalf("%s: err %v", name, err)
+		}
+	}
+
+	// the health checks of load balancers
+	resp, err := http.Get(hs.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("empty request status %d", resp.StatusCode)
+	}
+}
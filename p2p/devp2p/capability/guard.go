@@ -0,0 +1,168 @@
+package capability
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// the most the rpc server takes in a request, a larger one is refused before it's read
+	maxRequestSize = 1024 * 512
+
+	// the json-rpc error code of the calls the token doesn't grant, in the range left to the servers
+	errcodeNotGranted = -32001
+)
+
+// the part of a json-rpc call that matters here
+type call struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+}
+
+type callError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type callResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Error   callError       `json:"error"`
+}
+
+// Guard is a middleware in front of the http rpc of a node, passing on the requests whose calls the token of the request grants
+//
+// the token is in the Authorization header, as a bearer token. A request without a token, or with one that's no good,
+// is refused with 401 and the reason. A request calling a method the token doesn't grant gets an error for every call
+// in it, and none of them is made. Empty requests go through, they're the health checks of load balancers
+type Guard struct {
+	v    *Verifier
+	next http.Handler
+}
+
+// NewGuard puts the guard in front of the handler
+func NewGuard(v *Verifier, next http.Handler) *Guard {
+	return &Guard{
+		v:    v,
+		next: next,
+	}
+}
+
+func (g *Guard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ContentLength > maxRequestSize {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		g.next.ServeHTTP(w, r)
+		return
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		http.Error(w, "no capability token", http.StatusUnauthorized)
+		return
+	}
+	token, err := g.v.Verify(strings.TrimPrefix(auth, "Bearer "))
+	if err != nil {
+		log.Debug("rpc token refused", "remote", r.RemoteAddr, "err", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	calls, batch, err := parseCalls(body)
+	if err != nil {
+		// the rpc server could still make the calls of a batch that are fine
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var refused []string
+	for _, c := range calls {
+		if !token.Allows(c.Method) {
+			refused = append(refused, c.Method)
+		}
+	}
+	if len(refused) > 0 {
+		log.Info("rpc calls not granted", "sub", token.Subject, "methods", strings.Join(refused, ","))
+		writeRefusal(w, token, calls, batch)
+		return
+	}
+	for _, c := range calls {
+		log.Debug("rpc call granted", "sub", token.Subject, "method", c.Method)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	g.next.ServeHTTP(w, r)
+}
+
+// the calls of a request, a single one or a batch
+func parseCalls(body []byte) ([]call, bool, error) {
+	body = bytes.TrimSpace(body)
+	if body[0] == '[' {
+		var calls []call
+		err := json.Unmarshal(body, &calls)
+		return calls, true, err
+	}
+	var c call
+	err := json.Unmarshal(body, &c)
+	return []call{c}, false, err
+}
+
+// answers every call of the request with an error, saying which are not granted
+func writeRefusal(w http.ResponseWriter, token *Token, calls []call, batch bool) {
+	var responses []callResponse
+	for _, c := range calls {
+		msg := fmt.Sprintf("%s not granted to %s", c.Method, token.Subject)
+		if token.Allows(c.Method) {
+			msg = "batch refused, it has calls not granted"
+		}
+		responses = append(responses, callResponse{
+			Version: "2.0",
+			ID:      c.ID,
+			Error:   callError{Code: errcodeNotGranted, Message: msg},
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if batch {
+		json.NewEncoder(w).Encode(responses)
+	} else {
+		json.NewEncoder(w).Encode(responses[0])
+	}
+}
+
+// DialHTTP dials the http rpc endpoint of a node, sending the token with every request
+func DialHTTP(endpoint string, token string) (*rpc.Client, error) {
+	return rpc.DialHTTPWithClient(endpoint, &http.Client{
+		Transport: &bearer{token: token, next: http.DefaultTransport},
+	})
+}
+
+// adds the token to the requests
+type bearer struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (b *bearer) RoundTrip(r *http.Request) (*http.Response, error) {
+	// a round tripper mustn't change the request it's given
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = make(http.Header, len(r.Header)+1)
+	for k, v := range r.Header {
+		r2.Header[k] = v
+	}
+	r2.Header.Set("Authorization", "Bearer "+b.token)
+	return b.next.RoundTrip(r2)
+}
@@ -0,0 +1,77 @@
+package capability
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Service serves the rpc of the node stack on an http endpoint of its own, behind a guard
+//
+// the endpoint has all the apis of the stack, the private ones too, as it's the token that tells what may be called.
+// The endpoint of the stack itself can't have a guard put in front of it, it should be left off or kept local
+type Service struct {
+	stack *node.Node
+	addr  string
+	v     *Verifier
+
+	ln  net.Listener
+	srv *http.Server
+}
+
+// NewService makes the service of the stack, to be served on the address
+// the verifier's node is set to the stack's when it starts
+func NewService(stack *node.Node, addr string, v *Verifier) *Service {
+	return &Service{
+		stack: stack,
+		addr:  addr,
+		v:     v,
+	}
+}
+
+// Endpoint is the url of the guarded endpoint, once the service is started
+func (s *Service) Endpoint() string {
+	if s.ln == nil {
+		return ""
+	}
+	return "http://" + s.ln.Addr().String()
+}
+
+func (s *Service) Protocols() []p2p.Protocol {
+	return nil
+}
+
+func (s *Service) APIs() []rpc.API {
+	return nil
+}
+
+func (s *Service) Start(srv *p2p.Server) error {
+	s.v.Node = srv.Self().ID().String()
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("guarded rpc listen fail: %v", err)
+	}
+	s.ln = ln
+	// the rpc of the stack is only started after its services, so it's looked up for every request
+	rpcHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, err := s.stack.RPCHandler()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+	s.srv = &http.Server{Handler: NewGuard(s.v, rpcHandler)}
+	go s.srv.Serve(ln)
+	log.Info("guarded rpc endpoint opened", "url", s.Endpoint())
+	return nil
+}
+
+func (s *Service) Stop() error {
+	return s.srv.Close()
+}
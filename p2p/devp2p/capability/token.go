@@ -0,0 +1,174 @@
+// Package capability has tokens the admin of a node signs, granting someone the calls of some rpc methods of the node until they expire
+//
+// a token is the json of its claims and the signature of the admin over them, both base64url encoded and joined by a dot.
+// The signature is made with a secp256k1 key like the accounts' and the issuer is the address it recovers to,
+// so a node only needs the addresses of the admins it trusts, not their keys. A Guard in front of the http rpc of the
+// node checks the token of every request, and refuses the calls of methods it doesn't grant
+package capability
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	ErrMalformed     = errors.New("malformed token")
+	ErrBadSignature  = errors.New("bad token signature")
+	ErrUnknownIssuer = errors.New("token issuer not trusted")
+	ErrWrongNode     = errors.New("token for another node")
+	ErrExpired       = errors.New("token expired")
+	ErrNotYetValid   = errors.New("token not valid yet")
+)
+
+const (
+	// the signature is over this and the encoded claims, so a token can't be passed off as some other signed message
+	signPrefix = "devp2p capability token\n"
+	// r, s and the recovery id
+	signatureLength = 65
+)
+
+// Claims is what a token grants, to whom, and for how long
+type Claims struct {
+	Subject string    `json:"sub"`            // who the token is for, logged with the calls made with it
+	Node    string    `json:"node,omitempty"` // the id of the only node it's good for, empty for any node trusting the issuer
+	Grants  []string  `json:"grants"`         // the methods, as namespace_method, namespace_* or * for all
+	Issued  time.Time `json:"iat"`
+	Expires time.Time `json:"exp"`
+}
+
+// Allows tells whether the claims grant calling the method
+func (c *Claims) Allows(method string) bool {
+	for _, g := range c.Grants {
+		switch {
+		case g == "*" || g == method:
+			return true
+		case strings.HasSuffix(g, "_*") && strings.HasPrefix(method, g[:len(g)-1]):
+			return true
+		}
+	}
+	return false
+}
+
+// Token is the claims of a token, and who signed them
+type Token struct {
+	Claims
+	Issuer common.Address
+}
+
+// Issue signs the claims with the key of the admin
+func Issue(key *ecdsa.PrivateKey, c Claims) (string, error) {
+	if c.Expires.IsZero() {
+		return "", errors.New("a token must expire")
+	}
+	for _, g := range c.Grants {
+		if err := checkGrant(g); err != nil {
+			return "", err
+		}
+	}
+	b, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	sig, err := crypto.Sign(signHash(payload), key)
+	if err != nil {
+		return "", err
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Parse reads the token and recovers its issuer, without judging whether it's any good
+func Parse(s string) (*Token, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return nil, ErrMalformed
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(sig) != signatureLength {
+		return nil, ErrMalformed
+	}
+	pub, err := crypto.SigToPub(signHash(parts[0]), sig)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+	t := &Token{Issuer: crypto.PubkeyToAddress(*pub)}
+	if err := json.Unmarshal(b, &t.Claims); err != nil {
+		return nil, ErrMalformed
+	}
+	return t, nil
+}
+
+func signHash(payload string) []byte {
+	return crypto.Keccak256([]byte(signPrefix + payload))
+}
+
+// a grant is a method, all the methods of a namespace, or all of them
+func checkGrant(g string) error {
+	if g == "*" {
+		return nil
+	}
+	i := strings.IndexByte(g, '_')
+	name := strings.TrimSuffix(g, "_*")
+	if i <= 0 || i == len(g)-1 || strings.Contains(name, "*") {
+		return fmt.Errorf("invalid grant '%s'", g)
+	}
+	return nil
+}
+
+// Verifier takes the tokens of the issuers it trusts, for its node
+type Verifier struct {
+	Issuers []common.Address
+	Node    string // the id of the node, as hex
+	Now     func() time.Time
+}
+
+// NewVerifier makes the verifier of the node trusting the issuers
+func NewVerifier(node string, issuers ...common.Address) *Verifier {
+	return &Verifier{
+		Issuers: issuers,
+		Node:    node,
+		Now:     time.Now,
+	}
+}
+
+// Verify parses the token, and tells why it's no good if it isn't
+// a signature the issuer never made recovers to some other address, so it's refused as an unknown issuer
+func (v *Verifier) Verify(s string) (*Token, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	trusted := false
+	for _, issuer := range v.Issuers {
+		if issuer == t.Issuer {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return nil, ErrUnknownIssuer
+	}
+	if t.Node != "" && t.Node != v.Node {
+		return nil, ErrWrongNode
+	}
+	now := v.Now()
+	if now.Before(t.Issued) {
+		return nil, ErrNotYetValid
+	}
+	if !now.Before(t.Expires) {
+		return nil, ErrExpired
+	}
+	return t, nil
+}
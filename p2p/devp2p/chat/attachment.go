@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// the attachments are uploaded to swarm encrypted, swarm makes a key and encrypts each chunk with it,
+// and the reference it gives back is the hash of the root chunk followed by the key.
+// The message carries both, so the recipient can fetch the file through its own swarm node,
+// while the nodes that keep the chunks only see them encrypted
+
+var ErrAttachment = errors.New("attachment not valid")
+
+// Attachment is a file in swarm, sent with a message
+type Attachment struct {
+	Name string `json:"name"`
+	Size uint64 `json:"size"`
+	Hash []byte `json:"hash"` // of the root chunk
+	Key  []byte `json:"key"`  // of the chunks
+}
+
+// Store is what the attachments need of swarm, the client of the swarm http api has it
+type Store interface {
+	UploadRaw(r io.Reader, size int64, toEncrypt bool) (string, error)
+	DownloadRaw(hash string) (io.ReadCloser, bool, error)
+}
+
+// Progress is told how much of the attachment came, as it comes
+type Progress func(done uint64, total uint64)
+
+// Upload puts the file in swarm, encrypted
+func Upload(s Store, name string, r io.Reader, size int64) (*Attachment, error) {
+	ref, err := s.UploadRaw(r, size, true)
+	if err != nil {
+		return nil, err
+	}
+	b := common.FromHex(ref)
+	if len(b) != storage.AddressLength*2 {
+		return nil, fmt.Errorf("%v: reference of %d bytes, not encrypted", ErrAttachment, len(b))
+	}
+	return &Attachment{
+		Name: name,
+		Size: uint64(size),
+		Hash: b[:storage.AddressLength],
+		Key:  b[storage.AddressLength:],
+	}, nil
+}
+
+type progressWriter struct {
+	w        io.Writer
+	done     uint64
+	total    uint64
+	progress Progress
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += uint64(n)
+	if p.progress != nil {
+		p.progress(p.done, p.total)
+	}
+	return n, err
+}
+
+// Fetch writes the attachment to w, the swarm node fetches the chunks and decrypts them with the key
+func Fetch(s Store, att *Attachment, w io.Writer, progress Progress) error {
+	if len(att.Hash) != storage.AddressLength || len(att.Key) != storage.AddressLength {
+		return ErrAttachment
+	}
+	r, _, err := s.DownloadRaw(common.Bytes2Hex(append(append([]byte{}, att.Hash...), att.Key...)))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	pw := &progressWriter{w: w, total: att.Size, progress: progress}
+	// a file longer than it says is cut, one shorter fails below
+	if _, err := io.Copy(pw, io.LimitReader(r, int64(att.Size))); err != nil {
+		return err
+	}
+	if pw.done != att.Size {
+		return fmt.Errorf("%v: %d bytes of %d", ErrAttachment, pw.done, att.Size)
+	}
+	return nil
+}
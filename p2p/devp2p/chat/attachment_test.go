@@ -0,0 +1,121 @@
+package chat
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// keeps the files by the reference it makes up, the hash of the file and a random key when encrypted
+type testStore struct {
+	files map[string][]byte
+	cut   int // if set, the files come that much shorter
+}
+
+func (s *testStore) UploadRaw(r io.Reader, size int64, toEncrypt bool) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	ref := crypto.Keccak256(data)
+	if toEncrypt {
+		key := make([]byte, 32)
+		rand.Read(key)
+		ref = append(ref, key...)
+	}
+	s.files[common.Bytes2Hex(ref)] = data
+	return common.Bytes2Hex(ref), nil
+}
+
+func (s *testStore) DownloadRaw(hash string) (io.ReadCloser, bool, error) {
+	data, ok := s.files[hash]
+	if !ok {
+		return nil, false, ErrAttachment
+	}
+	return ioutil.NopCloser(bytes.NewReader(data[:len(data)-s.cut])), true, nil
+}
+
+func TestAttachment(t *testing.T) {
+	_, peers := newTestPeers(t, 2)
+	a, b := peers[0], peers[1]
+	defer a.cleanup()
+	defer b.cleanup()
+
+	store := &testStore{files: make(map[string][]byte)}
+	data := make([]byte, 100000)
+	rand.Read(data)
+	att, err := Upload(store, "photo.jpg", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.chat.SendAttachment(b.chat.Self(), "look", att); err != nil {
+		t.Fatal(err)
+	}
+	e := b.expect(t, EventMessage)
+	got := e.Message.Attachment
+	if got == nil || got.Name != "photo.jpg" || got.Size != uint64(len(data)) || !bytes.Equal(got.Key, att.Key) {
+		t.Fatalf("attachment %+v", got)
+	}
+
+	var buf bytes.Buffer
+	var calls int
+	var last uint64
+	err = Fetch(store, got, &buf, func(done, total uint64) {
+		if done < last || total != got.Size {
+			t.Fatalf("progress %d of %d", done, total)
+		}
+		calls++
+		last = done
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) || last != got.Size || calls == 0 {
+		t.Fatalf("fetched %d bytes, progress %d in %d calls", buf.Len(), last, calls)
+	}
+
+	// a message without is still one
+	if _, err := a.chat.Send(b.chat.Self(), "no attachment"); err != nil {
+		t.Fatal(err)
+	}
+	if e := b.expect(t, EventMessage); e.Message.Attachment != nil {
+		t.Fatalf("attachment %+v", e.Message.Attachment)
+	}
+}
+
+func TestAttachmentInvalid(t *testing.T) {
+	store := &testStore{files: make(map[string][]byte)}
+	data := []byte("not so secret")
+	// swarm gives back a reference without the key when it was asked not to encrypt
+	if _, err := Upload(&plainStore{store}, "plain.txt", bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Fatal("unencrypted upload taken")
+	}
+
+	att, err := Upload(store, "secret.txt", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.cut = 1
+	if err := Fetch(store, att, ioutil.Discard, nil); err == nil {
+		t.Fatal("short file fetched")
+	}
+	store.cut = 0
+	att.Key = att.Key[1:]
+	if err := Fetch(store, att, ioutil.Discard, nil); err != ErrAttachment {
+		t.Fatalf("fetch with a bad key: %v", err)
+	}
+}
+
+// a store that doesn't encrypt
+type plainStore struct {
+	*testStore
+}
+
+func (s *plainStore) UploadRaw(r io.Reader, size int64, toEncrypt bool) (string, error) {
+	return s.testStore.UploadRaw(r, size, false)
+}
@@ -0,0 +1,621 @@
+// Package chat is a chat between keys over pss, with the messages sent until they're delivered, and the receipts and typing not
+//
+// there are two classes of messages, on two topics. The chat messages are kept: each has the seq of its sender in the conversation,
+// and is sent again until the recipient's delivered receipt comes, or it's given up. The control messages, typing, delivered and read,
+// are sent once and may be lost. They wait behind the chat messages in a lane of lower priority, a lane over capacity drops them,
+// and a typing that waited longer than it means anything for is dropped before it's sent. Nothing is lost with them:
+// a message sent again is acked again, and a read receipt is for all the messages up to its seq.
+//
+// The chat tells the app of the messages, the receipts and the typing of its peers with events.
+// The groups are in group.go, the history of the groups in history.go, and the files sent with the messages in attachment.go
+package chat
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+
+	"../lanes"
+)
+
+var (
+	// MessageTopic is the topic of the chat messages
+	MessageTopic = pss.BytesToTopic([]byte("chat"))
+	// ControlTopic is the topic of the typing and the receipts
+	ControlTopic = pss.BytesToTopic([]byte("chat-control"))
+
+	ErrUnknownPeer = errors.New("not a peer of the chat")
+	ErrSymmetric   = errors.New("chat message not signed")
+)
+
+// the lanes of the send queue, the chat messages first
+const (
+	laneMessages = iota
+	laneControl
+	laneCount
+)
+
+// the kinds of control messages
+const (
+	controlTyping = iota
+	controlDelivered
+	controlRead
+)
+
+// Config is how hard the chat tries to deliver
+type Config struct {
+	Resend    time.Duration `json:"resend"`    // a message not delivered is sent again after as long, in nanoseconds over json
+	MaxSends  int           `json:"maxSends"`  // sends of a message before it's given up
+	TypingTTL time.Duration `json:"typingTTL"` // a peer shows as typing for as long after its typing comes, and a typing queued for longer is dropped
+	Capacity  int           `json:"capacity"`  // messages waiting to be sent in each lane, 0 for no limit
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Resend:    time.Second * 5,
+		MaxSends:  5,
+		TypingTTL: time.Second * 3,
+		Capacity:  64,
+	}
+}
+
+// Transport is what the chat needs of pss
+// NewPssTransport gives it for a *pss.Pss, NewRPCTransport for the pss of a node over rpc, and the PssStats of the examples have it already
+type Transport interface {
+	BaseAddr() []byte
+	SendAsym(pubkeyid string, topic pss.Topic, msg []byte) error
+	SetPeerPublicKey(pubkey *ecdsa.PublicKey, topic pss.Topic, address *pss.PssAddress) error
+	Register(topic *pss.Topic, f pss.HandlerFunc) func()
+}
+
+type pssTransport struct {
+	*pss.Pss
+}
+
+func NewPssTransport(ps *pss.Pss) Transport {
+	return &pssTransport{Pss: ps}
+}
+
+func (t *pssTransport) Register(topic *pss.Topic, f pss.HandlerFunc) func() {
+	return t.Pss.Register(topic, pss.NewHandler(f))
+}
+
+// the transport of a node's pss over its rpc, as a swarm node keeps its pss to itself
+type rpcTransport struct {
+	client *rpc.Client
+	addr   []byte
+}
+
+// NewRPCTransport gives the transport over the pss api of the node, the client must take subscriptions
+func NewRPCTransport(client *rpc.Client) (Transport, error) {
+	var addr pss.PssAddress
+	if err := client.Call(&addr, "pss_baseAddr"); err != nil {
+		return nil, err
+	}
+	return &rpcTransport{client: client, addr: addr}, nil
+}
+
+func (t *rpcTransport) BaseAddr() []byte {
+	return t.addr
+}
+
+func (t *rpcTransport) SendAsym(pubkeyid string, topic pss.Topic, msg []byte) error {
+	return t.client.Call(nil, "pss_sendAsym", pubkeyid, topic, hexutil.Bytes(msg))
+}
+
+func (t *rpcTransport) SetPeerPublicKey(pubkey *ecdsa.PublicKey, topic pss.Topic, address *pss.PssAddress) error {
+	var addr pss.PssAddress
+	if address != nil {
+		addr = *address
+	}
+	return t.client.Call(nil, "pss_setPeerPublicKey", hexutil.Bytes(crypto.FromECDSAPub(pubkey)), topic, addr)
+}
+
+// the messages come over a subscription, and are handed to the handler one at a time
+func (t *rpcTransport) Register(topic *pss.Topic, f pss.HandlerFunc) func() {
+	msgC := make(chan pss.APIMsg)
+	sub, err := t.client.Subscribe(context.Background(), "pss", msgC, "receive", *topic, false, false)
+	if err != nil {
+		log.Error("pss subscribe fail", "topic", topic, "err", err)
+		return func() {}
+	}
+	go func() {
+		for {
+			select {
+			case msg := <-msgC:
+				if err := f(msg.Msg, nil, msg.Asymmetric, msg.Key); err != nil {
+					log.Warn("chat handler failed", "err", err)
+				}
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+	return sub.Unsubscribe
+}
+
+// Status is how far a message we sent got
+type Status uint8
+
+const (
+	Sending Status = iota
+	Delivered
+	Read
+	Failed // given up after MaxSends
+)
+
+var statusNames = []string{"sending", "delivered", "read", "failed"}
+
+func (s Status) String() string {
+	if int(s) < len(statusNames) {
+		return statusNames[s]
+	}
+	return "unknown"
+}
+
+// Message is a chat message, as both ends keep it
+type Message struct {
+	From   string    `json:"from"` // the keys, in hex as pss gives them
+	To     string    `json:"to"`
+	Seq    uint64    `json:"seq"` // of the sender, in the conversation
+	Text   string    `json:"text"`
+	Sent   time.Time `json:"sent"`   // by the sender's clock
+	Status Status    `json:"status"` // of a message we sent
+	// of a message to a group, To is empty and Seq is of the sender in the group
+	Group common.Hash `json:"group"`
+	// a file in swarm sent with the message, fetched when the app wants it
+	Attachment *Attachment `json:"attachment,omitempty"`
+}
+
+type EventKind uint8
+
+const (
+	EventMessage EventKind = iota // a message came
+	EventStatus                   // a message we sent was delivered, read, or given up
+	EventTyping                   // the peer started or stopped typing
+	EventInvite                   // an invitation to a group came
+	EventGroup                    // a group we're in changed
+)
+
+// Event is what the app is told
+type Event struct {
+	Kind    EventKind
+	Peer    string
+	Message *Message // a copy, of EventMessage and EventStatus
+	Typing  bool
+
+	Invitation *Invitation // of EventInvite
+	Group      *Group      // a copy, of EventGroup
+	Change     GroupChange // of EventGroup, with Peer the member it's about, or the admin when it let us in or removed us
+}
+
+// Stats are the counts of the send queue
+type Stats struct {
+	Messages lanes.Stats `json:"messages"`
+	Control  lanes.Stats `json:"control"`
+	Stale    uint64      `json:"stale"` // typing dropped for waiting too long
+	Resent   uint64      `json:"resent"`
+}
+
+type wireMessage struct {
+	Seq        uint64
+	Text       string
+	Sent       uint64      // unix nanoseconds
+	Attachment *Attachment `rlp:"nil"`
+}
+
+type wireControl struct {
+	Kind uint8
+	Seq  uint64 // of the message delivered, or read up to
+}
+
+// waiting in the send queue
+type outgoing struct {
+	to     string
+	topic  pss.Topic
+	data   []byte
+	typing bool
+}
+
+// a message we sent that wasn't delivered yet
+type pending struct {
+	msg      *Message
+	data     []byte
+	sends    int
+	lastSent time.Time
+}
+
+type conversation struct {
+	peer       string
+	nextSeq    uint64
+	messages   []*Message // both ways, in the order they were sent or came
+	pending    map[uint64]*pending
+	received   map[uint64]bool // the seqs of the peer taken, so a message sent again is taken once
+	typingTill time.Time       // when the peer stops showing as typing, zero if it isn't
+	typingSent time.Time       // when we last told the peer we type
+}
+
+// Chat is the conversations of a key with its peers
+type Chat struct {
+	// the clock of the chat
+	Now func() time.Time
+
+	t     Transport
+	key   *ecdsa.PrivateKey
+	self  string
+	cfg   Config
+	queue *lanes.Queue
+	feed  event.Feed
+
+	mu          sync.Mutex
+	peers       map[string]*conversation
+	groups      map[common.Hash]*group
+	invitations map[common.Hash]*Invitation // taken or accepted, until the state of the group comes
+	history     *History                    // if set, where the groups are kept
+	stale       uint64
+	resent      uint64
+	unregs      []func()
+	quitC       chan struct{}
+	wg          sync.WaitGroup
+}
+
+// New makes the chat of the key that signs what pss sends, it takes the messages once it's started
+// the key signs the messages to groups and the invitations too
+func New(t Transport, key *ecdsa.PrivateKey, cfg Config) *Chat {
+	return &Chat{
+		Now:         time.Now,
+		t:           t,
+		key:         key,
+		self:        common.ToHex(crypto.FromECDSAPub(&key.PublicKey)),
+		cfg:         cfg,
+		queue:       lanes.NewQueue(laneCount, lanes.Config{Capacity: cfg.Capacity}),
+		peers:       make(map[string]*conversation),
+		groups:      make(map[common.Hash]*group),
+		invitations: make(map[common.Hash]*Invitation),
+		quitC:       make(chan struct{}),
+	}
+}
+
+// Self is our key, in hex
+func (c *Chat) Self() string {
+	return c.self
+}
+
+func (c *Chat) Start() {
+	c.unregs = append(c.unregs,
+		c.t.Register(&MessageTopic, c.handleMessage),
+		c.t.Register(&ControlTopic, c.handleControl),
+		c.t.Register(&GroupTopic, c.handleGroup),
+	)
+	c.wg.Add(2)
+	go c.sendLoop()
+	go c.tickLoop()
+}
+
+// Stop drops what wasn't sent yet
+func (c *Chat) Stop() {
+	for _, unreg := range c.unregs {
+		unreg()
+	}
+	close(c.quitC)
+	c.queue.Close()
+	c.wg.Wait()
+}
+
+func (c *Chat) SubscribeEvents(ch chan<- Event) event.Subscription {
+	return c.feed.Subscribe(ch)
+}
+
+// AddPeer starts a conversation with the key, at the pss address
+func (c *Chat) AddPeer(pub *ecdsa.PublicKey, addr pss.PssAddress) (string, error) {
+	for _, topic := range []pss.Topic{MessageTopic, ControlTopic} {
+		if err := c.t.SetPeerPublicKey(pub, topic, &addr); err != nil {
+			return "", err
+		}
+	}
+	key := common.ToHex(crypto.FromECDSAPub(pub))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.peers[key]; !ok {
+		c.peers[key] = &conversation{
+			peer:     key,
+			nextSeq:  1,
+			pending:  make(map[uint64]*pending),
+			received: make(map[uint64]bool),
+		}
+	}
+	return key, nil
+}
+
+// Send sends the text to the peer, and keeps sending it until it's delivered or given up
+func (c *Chat) Send(peer string, text string) (*Message, error) {
+	return c.SendAttachment(peer, text, nil)
+}
+
+// SendAttachment sends the message with a file uploaded to swarm, see Upload
+func (c *Chat) SendAttachment(peer string, text string, att *Attachment) (*Message, error) {
+	c.mu.Lock()
+	conv, ok := c.peers[peer]
+	if !ok {
+		c.mu.Unlock()
+		return nil, ErrUnknownPeer
+	}
+	now := c.Now()
+	msg := &Message{
+		From:       c.self,
+		To:         peer,
+		Seq:        conv.nextSeq,
+		Text:       text,
+		Sent:       now,
+		Attachment: att,
+	}
+	data, err := rlp.EncodeToBytes(&wireMessage{Seq: msg.Seq, Text: text, Sent: uint64(now.UnixNano()), Attachment: att})
+	if err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	conv.nextSeq++
+	conv.messages = append(conv.messages, msg)
+	conv.pending[msg.Seq] = &pending{msg: msg, data: data, sends: 1, lastSent: now}
+	// sending a message ends the typing, the peer stops showing it when the message comes
+	conv.typingSent = time.Time{}
+	m := *msg
+	c.mu.Unlock()
+	return &m, c.queue.Push(laneMessages, &outgoing{to: peer, topic: MessageTopic, data: data})
+}
+
+// Typing tells the peer we type, at most every half TypingTTL however often it's called, so the app calls it on every key
+func (c *Chat) Typing(peer string) error {
+	c.mu.Lock()
+	conv, ok := c.peers[peer]
+	if !ok {
+		c.mu.Unlock()
+		return ErrUnknownPeer
+	}
+	now := c.Now()
+	if now.Sub(conv.typingSent) < c.cfg.TypingTTL/2 {
+		c.mu.Unlock()
+		return nil
+	}
+	conv.typingSent = now
+	c.mu.Unlock()
+	return c.control(peer, controlTyping, 0)
+}
+
+// MarkRead tells the peer its messages up to the seq were read
+func (c *Chat) MarkRead(peer string, seq uint64) error {
+	c.mu.Lock()
+	_, ok := c.peers[peer]
+	c.mu.Unlock()
+	if !ok {
+		return ErrUnknownPeer
+	}
+	return c.control(peer, controlRead, seq)
+}
+
+// Messages returns the conversation with the peer
+func (c *Chat) Messages(peer string) []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conv, ok := c.peers[peer]
+	if !ok {
+		return nil
+	}
+	var msgs []Message
+	for _, m := range conv.messages {
+		msgs = append(msgs, *m)
+	}
+	return msgs
+}
+
+func (c *Chat) Stats() Stats {
+	stats := c.queue.Stats()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Messages: stats[laneMessages],
+		Control:  stats[laneControl],
+		Stale:    c.stale,
+		Resent:   c.resent,
+	}
+}
+
+// queues a control message, dropped if its lane is full
+func (c *Chat) control(peer string, kind uint8, seq uint64) error {
+	data, err := rlp.EncodeToBytes(&wireControl{Kind: kind, Seq: seq})
+	if err != nil {
+		return err
+	}
+	err = c.queue.Push(laneControl, &outgoing{to: peer, topic: ControlTopic, data: data, typing: kind == controlTyping})
+	if err == lanes.ErrFull {
+		log.Debug("chat control dropped", "to", peer, "kind", kind)
+		return nil
+	}
+	return err
+}
+
+func (c *Chat) sendLoop() {
+	defer c.wg.Done()
+	for {
+		_, v, waited, ok := c.queue.Pop()
+		if !ok {
+			return
+		}
+		o := v.(*outgoing)
+		if o.typing && waited > c.cfg.TypingTTL {
+			c.mu.Lock()
+			c.stale++
+			c.mu.Unlock()
+			continue
+		}
+		if err := c.t.SendAsym(o.to, o.topic, o.data); err != nil {
+			log.Debug("chat send fail", "to", o.to, "err", err)
+		}
+	}
+}
+
+// sends again what wasn't delivered, gives up what was sent too often, and ends the typing of the peers that stopped
+func (c *Chat) tickLoop() {
+	defer c.wg.Done()
+	interval := c.cfg.Resend
+	if c.cfg.TypingTTL < interval {
+		interval = c.cfg.TypingTTL
+	}
+	ticker := time.NewTicker(interval / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.tick()
+		case <-c.quitC:
+			return
+		}
+	}
+}
+
+func (c *Chat) tick() {
+	now := c.Now()
+	var events []Event
+	var resend []*outgoing
+	c.mu.Lock()
+	for _, conv := range c.peers {
+		for seq, p := range conv.pending {
+			if now.Sub(p.lastSent) < c.cfg.Resend {
+				continue
+			}
+			if p.sends >= c.cfg.MaxSends {
+				p.msg.Status = Failed
+				delete(conv.pending, seq)
+				m := *p.msg
+				events = append(events, Event{Kind: EventStatus, Peer: conv.peer, Message: &m})
+				continue
+			}
+			p.sends++
+			p.lastSent = now
+			c.resent++
+			resend = append(resend, &outgoing{to: conv.peer, topic: MessageTopic, data: p.data})
+		}
+		if !conv.typingTill.IsZero() && now.After(conv.typingTill) {
+			conv.typingTill = time.Time{}
+			events = append(events, Event{Kind: EventTyping, Peer: conv.peer, Typing: false})
+		}
+	}
+	c.mu.Unlock()
+	for _, o := range resend {
+		if err := c.queue.Push(laneMessages, o); err != nil {
+			log.Debug("chat resend fail", "to", o.to, "err", err)
+		}
+	}
+	c.send(events)
+}
+
+func (c *Chat) send(events []Event) {
+	for _, e := range events {
+		c.feed.Send(e)
+	}
+}
+
+// pss gives the key that signed the message as keyid, the messages of keys that aren't peers are dropped
+func (c *Chat) conversation(asymmetric bool, keyid string) (*conversation, error) {
+	if !asymmetric {
+		return nil, ErrSymmetric
+	}
+	conv, ok := c.peers[keyid]
+	if !ok {
+		return nil, ErrUnknownPeer
+	}
+	return conv, nil
+}
+
+func (c *Chat) handleMessage(data []byte, _ *p2p.Peer, asymmetric bool, keyid string) error {
+	var wm wireMessage
+	if err := rlp.DecodeBytes(data, &wm); err != nil {
+		return err
+	}
+	var events []Event
+	c.mu.Lock()
+	conv, err := c.conversation(asymmetric, keyid)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	if !conv.received[wm.Seq] {
+		conv.received[wm.Seq] = true
+		msg := &Message{
+			From:       keyid,
+			To:         c.self,
+			Seq:        wm.Seq,
+			Text:       wm.Text,
+			Sent:       time.Unix(0, int64(wm.Sent)),
+			Attachment: wm.Attachment,
+		}
+		conv.messages = append(conv.messages, msg)
+		if !conv.typingTill.IsZero() {
+			conv.typingTill = time.Time{}
+			events = append(events, Event{Kind: EventTyping, Peer: keyid, Typing: false})
+		}
+		m := *msg
+		events = append(events, Event{Kind: EventMessage, Peer: keyid, Message: &m})
+	}
+	c.mu.Unlock()
+	c.send(events)
+	// acked every time it comes, the ack of the last time may be lost
+	return c.control(keyid, controlDelivered, wm.Seq)
+}
+
+func (c *Chat) handleControl(data []byte, _ *p2p.Peer, asymmetric bool, keyid string) error {
+	var wc wireControl
+	if err := rlp.DecodeBytes(data, &wc); err != nil {
+		return err
+	}
+	var events []Event
+	c.mu.Lock()
+	conv, err := c.conversation(asymmetric, keyid)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	switch wc.Kind {
+	case controlTyping:
+		if conv.typingTill.IsZero() {
+			events = append(events, Event{Kind: EventTyping, Peer: keyid, Typing: true})
+		}
+		conv.typingTill = c.Now().Add(c.cfg.TypingTTL)
+	case controlDelivered:
+		if p, ok := conv.pending[wc.Seq]; ok {
+			delete(conv.pending, wc.Seq)
+			p.msg.Status = Delivered
+			m := *p.msg
+			events = append(events, Event{Kind: EventStatus, Peer: keyid, Message: &m})
+		}
+	case controlRead:
+		// read is delivered too, and covers the receipts lost before it
+		for _, msg := range conv.messages {
+			if msg.From != c.self || msg.Seq > wc.Seq || msg.Status == Read || msg.Status == Failed {
+				continue
+			}
+			delete(conv.pending, msg.Seq)
+			msg.Status = Read
+			m := *msg
+			events = append(events, Event{Kind: EventStatus, Peer: keyid, Message: &m})
+		}
+	default:
+		c.mu.Unlock()
+		return errors.New("unknown chat control")
+	}
+	c.mu.Unlock()
+	c.send(events)
+	return nil
+}
@@ -0,0 +1,287 @@
+package chat
+
+import (
+	"crypto/ecdsa"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+)
+
+var testConfig = Config{
+	Resend:    time.Millisecond * 50,
+	MaxSends:  3,
+	TypingTTL: time.Millisecond * 100,
+	Capacity:  16,
+}
+
+// hands the messages between the transports of the test, unless drop says to lose them
+type testNet struct {
+	mu         sync.Mutex
+	transports map[string]*testTransport
+	drop       func(from string, topic pss.Topic, data []byte) bool
+	sends      map[pss.Topic]int
+}
+
+type testTransport struct {
+	net      *testNet
+	key      string
+	gate     chan struct{} // if set, a send waits for it
+	mu       sync.Mutex
+	handlers map[pss.Topic]pss.HandlerFunc
+}
+
+func (t *testTransport) SendAsym(to string, topic pss.Topic, msg []byte) error {
+	if t.gate != nil {
+		<-t.gate
+	}
+	n := t.net
+	n.mu.Lock()
+	n.sends[topic]++
+	dropped := n.drop != nil && n.drop(t.key, topic, msg)
+	peer := n.transports[to]
+	n.mu.Unlock()
+	if dropped || peer == nil {
+		return nil
+	}
+	peer.mu.Lock()
+	f := peer.handlers[topic]
+	peer.mu.Unlock()
+	if f != nil {
+		go f(msg, nil, true, t.key)
+	}
+	return nil
+}
+
+func (t *testTransport) BaseAddr() []byte {
+	return common.FromHex(t.key)[1:33]
+}
+
+func (t *testTransport) SetPeerPublicKey(*ecdsa.PublicKey, pss.Topic, *pss.PssAddress) error {
+	return nil
+}
+
+func (t *testTransport) Register(topic *pss.Topic, f pss.HandlerFunc) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers[*topic] = f
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.handlers, *topic)
+	}
+}
+
+type testPeer struct {
+	chat    *Chat
+	t       *testTransport
+	key     *ecdsa.PrivateKey
+	eventC  chan Event
+	cleanup func()
+}
+
+func newTestPeers(t *testing.T, n int) (*testNet, []*testPeer) {
+	net := &testNet{
+		transports: make(map[string]*testTransport),
+		sends:      make(map[pss.Topic]int),
+	}
+	var peers []*testPeer
+	for i := 0; i < n; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := &testTransport{
+			net:      net,
+			key:      common.ToHex(crypto.FromECDSAPub(&key.PublicKey)),
+			handlers: make(map[pss.Topic]pss.HandlerFunc),
+		}
+		net.transports[tr.key] = tr
+		c := New(tr, key, testConfig)
+		eventC := make(chan Event, 64)
+		sub := c.SubscribeEvents(eventC)
+		c.Start()
+		peers = append(peers, &testPeer{chat: c, t: tr, key: key, eventC: eventC, cleanup: func() {
+			sub.Unsubscribe()
+			c.Stop()
+		}})
+	}
+	for _, a := range peers {
+		for _, b := range peers {
+			if a != b {
+				if _, err := a.chat.AddPeer(&b.key.PublicKey, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	return net, peers
+}
+
+// the next event of the kind, the others are skipped
+func (p *testPeer) expect(t *testing.T, kind EventKind) Event {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-p.eventC:
+			if e.Kind == kind {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no event of kind %d", kind)
+		}
+	}
+}
+
+func (p *testPeer) quiet(t *testing.T, kind EventKind, d time.Duration) {
+	t.Helper()
+	timeout := time.After(d)
+	for {
+		select {
+		case e := <-p.eventC:
+			if e.Kind == kind {
+				t.Fatalf("unexpected event %v", e)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestDelivery(t *testing.T) {
+	_, peers := newTestPeers(t, 2)
+	a, b := peers[0], peers[1]
+	defer a.cleanup()
+	defer b.cleanup()
+
+	sent, err := a.chat.Send(b.chat.Self(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := b.expect(t, EventMessage)
+	if e.Message.Text != "hello" || e.Message.From != a.chat.Self() || e.Message.Seq != sent.Seq {
+		t.Fatalf("message %v", e.Message)
+	}
+	if e := a.expect(t, EventStatus); e.Message.Status != Delivered {
+		t.Fatalf("status %v", e.Message.Status)
+	}
+	if err := b.chat.MarkRead(a.chat.Self(), e.Message.Seq); err != nil {
+		t.Fatal(err)
+	}
+	if e := a.expect(t, EventStatus); e.Message.Status != Read {
+		t.Fatalf("status %v", e.Message.Status)
+	}
+	if msgs := b.chat.Messages(a.chat.Self()); len(msgs) != 1 {
+		t.Fatalf("%d messages", len(msgs))
+	}
+	if _, err := a.chat.Send("0x04bb", "hello"); err != ErrUnknownPeer {
+		t.Fatalf("send to a stranger: %v", err)
+	}
+}
+
+// a message lost is sent again, and one sent again because its receipt was lost is taken once
+func TestResend(t *testing.T) {
+	net, peers := newTestPeers(t, 2)
+	a, b := peers[0], peers[1]
+	defer a.cleanup()
+	defer b.cleanup()
+
+	lost := map[pss.Topic]bool{}
+	net.drop = func(from string, topic pss.Topic, data []byte) bool {
+		if !lost[topic] {
+			lost[topic] = true
+			return true
+		}
+		return false
+	}
+	if _, err := a.chat.Send(b.chat.Self(), "again"); err != nil {
+		t.Fatal(err)
+	}
+	b.expect(t, EventMessage)
+	if e := a.expect(t, EventStatus); e.Message.Status != Delivered {
+		t.Fatalf("status %v", e.Message.Status)
+	}
+	b.quiet(t, EventMessage, testConfig.Resend*2)
+	if msgs := b.chat.Messages(a.chat.Self()); len(msgs) != 1 {
+		t.Fatalf("%d messages", len(msgs))
+	}
+	if st := a.chat.Stats(); st.Resent != 2 {
+		t.Fatalf("resent %d", st.Resent)
+	}
+
+	// and given up when nothing gets through
+	net.mu.Lock()
+	net.drop = func(string, pss.Topic, []byte) bool { return true }
+	net.mu.Unlock()
+	if _, err := a.chat.Send(b.chat.Self(), "lost"); err != nil {
+		t.Fatal(err)
+	}
+	if e := a.expect(t, EventStatus); e.Message.Status != Failed {
+		t.Fatalf("status %v", e.Message.Status)
+	}
+}
+
+func TestTyping(t *testing.T) {
+	net, peers := newTestPeers(t, 2)
+	a, b := peers[0], peers[1]
+	defer a.cleanup()
+	defer b.cleanup()
+
+	for i := 0; i < 5; i++ {
+		if err := a.chat.Typing(b.chat.Self()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if e := b.expect(t, EventTyping); !e.Typing {
+		t.Fatal("not typing")
+	}
+	net.mu.Lock()
+	sends := net.sends[ControlTopic]
+	net.mu.Unlock()
+	if sends != 1 {
+		t.Fatalf("%d typing sent", sends)
+	}
+	// stops showing after the ttl
+	if e := b.expect(t, EventTyping); e.Typing {
+		t.Fatal("still typing")
+	}
+	// and when the message comes
+	if err := a.chat.Typing(b.chat.Self()); err != nil {
+		t.Fatal(err)
+	}
+	b.expect(t, EventTyping)
+	if _, err := a.chat.Send(b.chat.Self(), "done typing"); err != nil {
+		t.Fatal(err)
+	}
+	if e := b.expect(t, EventTyping); e.Typing {
+		t.Fatal("still typing")
+	}
+}
+
+// a typing that waited behind the messages for longer than its ttl isn't sent
+func TestStaleTyping(t *testing.T) {
+	_, peers := newTestPeers(t, 2)
+	a, b := peers[0], peers[1]
+	defer a.cleanup()
+	defer b.cleanup()
+
+	gate := make(chan struct{})
+	a.t.gate = gate
+	if _, err := a.chat.Send(b.chat.Self(), "slow"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.chat.Typing(b.chat.Self()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(testConfig.TypingTTL * 2)
+	close(gate)
+	b.expect(t, EventMessage)
+	b.quiet(t, EventTyping, testConfig.TypingTTL)
+	if st := a.chat.Stats(); st.Stale != 1 || st.Control.Popped != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+}
@@ -0,0 +1,718 @@
+package chat
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+)
+
+// a group has an admin, who invites the members and removes them
+//
+// the messages to the group are sealed once, with the key of the group, and signed inside by their sender;
+// the same sealed message goes to every member. The key is the admin's to make: it sends it to each member
+// with the state of the group, encrypted to the member's key by pss, and makes a new one every time it removes a member,
+// so a member removed can't read what the others send after, even if it gets hold of it.
+//
+// Joining is a handshake: the admin sends the invitee an invitation it signed, for the invitee's key and the group,
+// the invitee sends it back to join, and the admin takes it only from the key it's for, and only once.
+// The admin answers with the state of the group, and sends the others the new member
+
+var (
+	// GroupTopic is the topic of the groups, the messages, the invitations and the state from the admin
+	GroupTopic = pss.BytesToTopic([]byte("chat-group"))
+
+	ErrUnknownGroup = errors.New("no such group")
+	ErrNotAdmin     = errors.New("not the admin of the group")
+	ErrNotMember    = errors.New("not a member of the group")
+	ErrInvitation   = errors.New("invitation not valid")
+	ErrEpoch        = errors.New("no key of the epoch")
+)
+
+const (
+	// how long an invitation can be taken
+	invitationTTL = time.Hour * 24
+	groupKeyLen   = 32
+)
+
+// the kinds of group messages
+const (
+	groupInvite = iota
+	groupJoin
+	groupState
+	groupRemoved
+	groupMessage
+	groupSyncRequest
+	groupSyncReply
+)
+
+// GroupChange is how a group changed, in an EventGroup
+type GroupChange uint8
+
+const (
+	GroupJoined        GroupChange = iota // the admin let us in
+	GroupMemberAdded                      // Peer joined
+	GroupMemberRemoved                    // Peer was removed, and the key changed
+	GroupRemoved                          // the admin removed us
+)
+
+// Group is what the members know of a group
+type Group struct {
+	ID      common.Hash `json:"id"`
+	Name    string      `json:"name"`
+	Admin   string      `json:"admin"`
+	Epoch   uint64      `json:"epoch"`   // of the key, one up every time a member is removed
+	Members []string    `json:"members"` // the keys, the admin among them, sorted
+}
+
+// Member is a key of the group and its pss address
+type Member struct {
+	Key  []byte
+	Addr []byte
+}
+
+// Invitation is for the key of the invitee to join the group, signed by the admin
+type Invitation struct {
+	Group     common.Hash
+	Name      string
+	Admin     Member
+	Invitee   []byte
+	Expires   uint64 // unix time
+	Signature []byte
+}
+
+func (inv *Invitation) hash() []byte {
+	return crypto.Keccak256([]byte("\x19chat invitation:"), inv.Group[:], []byte(inv.Name), inv.Admin.Key, inv.Admin.Addr, inv.Invitee, uint64Bytes(inv.Expires))
+}
+
+// Verify checks the invitation was signed by its admin, and hasn't expired
+func (inv *Invitation) Verify(now time.Time) error {
+	pub, err := crypto.SigToPub(inv.hash(), inv.Signature)
+	if err != nil || !bytes.Equal(crypto.FromECDSAPub(pub), inv.Admin.Key) {
+		return fmt.Errorf("%v: not signed by the admin", ErrInvitation)
+	}
+	if uint64(now.Unix()) > inv.Expires {
+		return fmt.Errorf("%v: expired", ErrInvitation)
+	}
+	return nil
+}
+
+type wireGroup struct {
+	Kind uint8
+	Data []byte
+}
+
+type wireJoin struct {
+	Invitation Invitation
+	Addr       []byte
+}
+
+// the state of the group, from the admin, with the key: to a new member, and to all when the members change
+type wireState struct {
+	Group   common.Hash
+	Name    string
+	Epoch   uint64
+	Key     []byte
+	Members []Member
+}
+
+type wireRemoved struct {
+	Group common.Hash
+}
+
+// a message to the group, sealed with the key of the epoch
+type wireGroupMessage struct {
+	Group  common.Hash
+	Epoch  uint64
+	Nonce  []byte
+	Sealed []byte
+}
+
+// what's sealed, signed by the sender
+type sealedMessage struct {
+	Seq       uint64
+	Text      string
+	Sent      uint64
+	Signature []byte
+}
+
+func (m *sealedMessage) hash(group common.Hash, epoch uint64) []byte {
+	return crypto.Keccak256([]byte("\x19chat group message:"), group[:], uint64Bytes(epoch),
+		uint64Bytes(m.Seq), []byte(m.Text), uint64Bytes(m.Sent))
+}
+
+type group struct {
+	info    Group
+	keys    map[uint64][]byte // by epoch, the old ones to open what was sent before a change
+	members map[string]Member
+	invited map[string]bool // of the admin, the invitees that didn't join yet
+	seq     uint64
+	// the messages taken, ours too, by sender and seq, with what was sent so they can be passed on to the members that missed them
+	entries map[string]map[uint64]*groupEntry
+}
+
+type groupEntry struct {
+	msg  *Message
+	wire *wireGroupMessage
+}
+
+func newGroup(info Group) *group {
+	return &group{
+		info:    info,
+		keys:    make(map[uint64][]byte),
+		members: make(map[string]Member),
+		invited: make(map[string]bool),
+		entries: make(map[string]map[uint64]*groupEntry),
+	}
+}
+
+// must be called with the lock held
+func (g *group) entry(from string, seq uint64) *groupEntry {
+	return g.entries[from][seq]
+}
+
+// must be called with the lock held
+func (g *group) add(e *groupEntry) {
+	if g.entries[e.msg.From] == nil {
+		g.entries[e.msg.From] = make(map[uint64]*groupEntry)
+	}
+	g.entries[e.msg.From][e.msg.Seq] = e
+}
+
+func uint64Bytes(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func newGroupKey() ([]byte, error) {
+	key := make([]byte, groupKeyLen)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+func seal(key []byte, plain []byte) ([]byte, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plain, nil), nil
+}
+
+func open(key []byte, nonce []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("bad nonce")
+	}
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+// the group, with its members sorted
+// must be called with the lock held
+func (g *group) snapshot() *Group {
+	info := g.info
+	info.Members = nil
+	for key := range g.members {
+		info.Members = append(info.Members, key)
+	}
+	sort.Strings(info.Members)
+	return &info
+}
+
+// must be called with the lock held
+func (g *group) state() *wireState {
+	s := &wireState{
+		Group: g.info.ID,
+		Name:  g.info.Name,
+		Epoch: g.info.Epoch,
+		Key:   g.keys[g.info.Epoch],
+	}
+	for _, key := range g.snapshot().Members {
+		s.Members = append(s.Members, g.members[key])
+	}
+	return s
+}
+
+// queues a group message to the key
+func (c *Chat) pushGroup(to string, kind uint8, v interface{}) error {
+	data, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		return err
+	}
+	data, err = rlp.EncodeToBytes(&wireGroup{Kind: kind, Data: data})
+	if err != nil {
+		return err
+	}
+	return c.queue.Push(laneMessages, &outgoing{to: to, topic: GroupTopic, data: data})
+}
+
+func (c *Chat) setGroupPeer(m Member) error {
+	pub, err := crypto.UnmarshalPubkey(m.Key)
+	if err != nil {
+		return err
+	}
+	addr := pss.PssAddress(m.Addr)
+	return c.t.SetPeerPublicKey(pub, GroupTopic, &addr)
+}
+
+func (c *Chat) member() Member {
+	return Member{Key: crypto.FromECDSAPub(&c.key.PublicKey), Addr: c.t.BaseAddr()}
+}
+
+// CreateGroup makes a group with us as its admin, and its only member
+func (c *Chat) CreateGroup(name string) (*Group, error) {
+	key, err := newGroupKey()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	g := newGroup(Group{
+		ID:    crypto.Keccak256Hash(crypto.FromECDSAPub(&c.key.PublicKey), []byte(name), nonce),
+		Name:  name,
+		Admin: c.self,
+	})
+	g.keys[0] = key
+	g.members[c.self] = c.member()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.groups[g.info.ID] = g
+	c.saveGroup(g)
+	return g.snapshot(), nil
+}
+
+// the group we're the admin of
+// must be called with the lock held
+func (c *Chat) adminGroup(id common.Hash) (*group, error) {
+	g, ok := c.groups[id]
+	if !ok {
+		return nil, ErrUnknownGroup
+	}
+	if g.info.Admin != c.self {
+		return nil, ErrNotAdmin
+	}
+	return g, nil
+}
+
+// Invite sends the key at the address an invitation to the group we're the admin of
+func (c *Chat) Invite(id common.Hash, pub *ecdsa.PublicKey, addr pss.PssAddress) (*Invitation, error) {
+	invitee := Member{Key: crypto.FromECDSAPub(pub), Addr: addr}
+	if err := c.setGroupPeer(invitee); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	g, err := c.adminGroup(id)
+	if err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	inv := &Invitation{
+		Group:   id,
+		Name:    g.info.Name,
+		Admin:   g.members[c.self],
+		Invitee: invitee.Key,
+		Expires: uint64(c.Now().Add(invitationTTL).Unix()),
+	}
+	if inv.Signature, err = crypto.Sign(inv.hash(), c.key); err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	g.invited[common.ToHex(invitee.Key)] = true
+	c.saveGroup(g)
+	c.mu.Unlock()
+	return inv, c.pushGroup(common.ToHex(invitee.Key), groupInvite, inv)
+}
+
+// Accept joins the group of the invitation, the admin answers with the state of the group
+func (c *Chat) Accept(inv *Invitation) error {
+	if err := inv.Verify(c.Now()); err != nil {
+		return err
+	}
+	if err := c.setGroupPeer(inv.Admin); err != nil {
+		return err
+	}
+	// kept until the admin answers, the state is taken from it only
+	c.mu.Lock()
+	c.invitations[inv.Group] = inv
+	c.mu.Unlock()
+	return c.pushGroup(common.ToHex(inv.Admin.Key), groupJoin, &wireJoin{Invitation: *inv, Addr: c.t.BaseAddr()})
+}
+
+// Remove takes the member out of the group we're the admin of, and gives the others a new key
+func (c *Chat) Remove(id common.Hash, member string) error {
+	key, err := newGroupKey()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	g, err := c.adminGroup(id)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	if _, ok := g.members[member]; !ok || member == c.self {
+		c.mu.Unlock()
+		return ErrNotMember
+	}
+	delete(g.members, member)
+	g.info.Epoch++
+	g.keys[g.info.Epoch] = key
+	c.saveGroup(g)
+	state := g.state()
+	info := g.snapshot()
+	c.mu.Unlock()
+
+	c.send([]Event{{Kind: EventGroup, Peer: member, Group: info, Change: GroupMemberRemoved}})
+	if err := c.pushGroup(member, groupRemoved, &wireRemoved{Group: id}); err != nil {
+		return err
+	}
+	return c.pushState(state)
+}
+
+// sends the state to all the members but us
+func (c *Chat) pushState(state *wireState) error {
+	for _, m := range state.Members {
+		key := common.ToHex(m.Key)
+		if key == c.self {
+			continue
+		}
+		if err := c.pushGroup(key, groupState, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendGroup seals the text with the key of the group, and sends it to all the members
+// unlike a message to a peer it's sent once, and not acked
+func (c *Chat) SendGroup(id common.Hash, text string) (*Message, error) {
+	c.mu.Lock()
+	g, ok := c.groups[id]
+	if !ok {
+		c.mu.Unlock()
+		return nil, ErrUnknownGroup
+	}
+	now := c.Now()
+	sm := &sealedMessage{Seq: g.seq + 1, Text: text, Sent: uint64(now.UnixNano())}
+	wm, err := c.seal(g, sm)
+	if err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	g.seq++
+	e := &groupEntry{msg: &Message{From: c.self, Seq: sm.Seq, Text: text, Sent: now, Group: id}, wire: wm}
+	g.add(e)
+	c.saveEntry(g, e)
+	members := g.snapshot().Members
+	c.mu.Unlock()
+
+	for _, member := range members {
+		if member == c.self {
+			continue
+		}
+		if err := c.pushGroup(member, groupMessage, wm); err != nil {
+			log.Debug("group send fail", "to", member, "err", err)
+		}
+	}
+	m := *e.msg
+	return &m, nil
+}
+
+// signs the message, and seals it with the key of the group's epoch
+// must be called with the lock held
+func (c *Chat) seal(g *group, sm *sealedMessage) (*wireGroupMessage, error) {
+	var err error
+	epoch := g.info.Epoch
+	if sm.Signature, err = crypto.Sign(sm.hash(g.info.ID, epoch), c.key); err != nil {
+		return nil, err
+	}
+	plain, err := rlp.EncodeToBytes(sm)
+	if err != nil {
+		return nil, err
+	}
+	nonce, sealed, err := seal(g.keys[epoch], plain)
+	if err != nil {
+		return nil, err
+	}
+	return &wireGroupMessage{Group: g.info.ID, Epoch: epoch, Nonce: nonce, Sealed: sealed}, nil
+}
+
+func (c *Chat) Groups() []Group {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var groups []Group
+	for _, g := range c.groups {
+		groups = append(groups, *g.snapshot())
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// GroupMessages gives the messages of the group by the time they were sent, then by sender and seq,
+// so every member has them in the same order however they came
+func (c *Chat) GroupMessages(id common.Hash) []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, ok := c.groups[id]
+	if !ok {
+		return nil
+	}
+	return g.messages()
+}
+
+// pss gives the key that signed the message as keyid, which is the sender of an invitation, a join or a state,
+// and the member that passed on a message to the group, which has the signature of its sender inside
+func (c *Chat) handleGroup(data []byte, _ *p2p.Peer, asymmetric bool, keyid string) error {
+	if !asymmetric {
+		return ErrSymmetric
+	}
+	var wg wireGroup
+	if err := rlp.DecodeBytes(data, &wg); err != nil {
+		return err
+	}
+	var events []Event
+	var err error
+	switch wg.Kind {
+	case groupInvite:
+		var inv Invitation
+		if err = rlp.DecodeBytes(wg.Data, &inv); err == nil {
+			events, err = c.takeInvitation(&inv, keyid)
+		}
+	case groupJoin:
+		var join wireJoin
+		if err = rlp.DecodeBytes(wg.Data, &join); err == nil {
+			events, err = c.takeJoin(&join, keyid)
+		}
+	case groupState:
+		var state wireState
+		if err = rlp.DecodeBytes(wg.Data, &state); err == nil {
+			events, err = c.takeState(&state, keyid)
+		}
+	case groupRemoved:
+		var removed wireRemoved
+		if err = rlp.DecodeBytes(wg.Data, &removed); err == nil {
+			events, err = c.takeRemoved(&removed, keyid)
+		}
+	case groupMessage:
+		var wm wireGroupMessage
+		if err = rlp.DecodeBytes(wg.Data, &wm); err == nil {
+			events, err = c.takeGroupMessage(&wm)
+		}
+	case groupSyncRequest:
+		var req wireSyncRequest
+		if err = rlp.DecodeBytes(wg.Data, &req); err == nil {
+			err = c.takeSyncRequest(&req, keyid)
+		}
+	case groupSyncReply:
+		var reply wireSyncReply
+		if err = rlp.DecodeBytes(wg.Data, &reply); err == nil {
+			events = c.takeSyncReply(&reply, keyid)
+		}
+	default:
+		err = errors.New("unknown group message")
+	}
+	c.send(events)
+	return err
+}
+
+// an invitation is for us, from the admin that signed it
+func (c *Chat) takeInvitation(inv *Invitation, keyid string) ([]Event, error) {
+	if err := inv.Verify(c.Now()); err != nil {
+		return nil, err
+	}
+	if common.ToHex(inv.Admin.Key) != keyid || common.ToHex(inv.Invitee) != c.self {
+		return nil, ErrInvitation
+	}
+	c.mu.Lock()
+	c.invitations[inv.Group] = inv
+	c.mu.Unlock()
+	return []Event{{Kind: EventInvite, Peer: keyid, Invitation: inv}}, nil
+}
+
+// the admin takes an invitation it signed, from the key it's for, once
+func (c *Chat) takeJoin(join *wireJoin, keyid string) ([]Event, error) {
+	inv := &join.Invitation
+	if err := inv.Verify(c.Now()); err != nil {
+		return nil, err
+	}
+	if common.ToHex(inv.Invitee) != keyid {
+		return nil, fmt.Errorf("%v: for another key", ErrInvitation)
+	}
+	newMember := Member{Key: inv.Invitee, Addr: join.Addr}
+	if err := c.setGroupPeer(newMember); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	g, err := c.adminGroup(inv.Group)
+	if err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	if common.ToHex(inv.Admin.Key) != c.self {
+		c.mu.Unlock()
+		return nil, ErrNotAdmin
+	}
+	if !g.invited[keyid] {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("%v: taken already", ErrInvitation)
+	}
+	delete(g.invited, keyid)
+	g.members[keyid] = newMember
+	c.saveGroup(g)
+	state := g.state()
+	info := g.snapshot()
+	c.mu.Unlock()
+	return []Event{{Kind: EventGroup, Peer: keyid, Group: info, Change: GroupMemberAdded}}, c.pushState(state)
+}
+
+// the state is taken only from the admin of the group, and only of its epoch or later
+func (c *Chat) takeState(state *wireState, keyid string) ([]Event, error) {
+	if len(state.Key) != groupKeyLen {
+		return nil, errors.New("bad group key")
+	}
+	members := make(map[string]Member)
+	for _, m := range state.Members {
+		members[common.ToHex(m.Key)] = m
+	}
+	if _, ok := members[c.self]; !ok {
+		return nil, ErrNotMember
+	}
+	for key, m := range members {
+		if key == c.self {
+			continue
+		}
+		if err := c.setGroupPeer(m); err != nil {
+			return nil, err
+		}
+	}
+
+	var events []Event
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, ok := c.groups[state.Group]
+	if !ok {
+		// the first state, the answer to our join
+		inv, invited := c.invitations[state.Group]
+		if !invited || common.ToHex(inv.Admin.Key) != keyid {
+			return nil, ErrNotAdmin
+		}
+		g = newGroup(Group{ID: state.Group, Name: state.Name, Admin: keyid})
+		c.groups[state.Group] = g
+		delete(c.invitations, state.Group)
+		g.info.Epoch = state.Epoch
+		g.keys[state.Epoch] = state.Key
+		g.members = members
+		c.saveGroup(g)
+		return append(events, Event{Kind: EventGroup, Peer: keyid, Group: g.snapshot(), Change: GroupJoined}), nil
+	}
+	if g.info.Admin != keyid {
+		return nil, ErrNotAdmin
+	}
+	if state.Epoch < g.info.Epoch {
+		return nil, ErrEpoch
+	}
+	old := g.members
+	g.info.Epoch = state.Epoch
+	g.keys[state.Epoch] = state.Key
+	g.members = members
+	c.saveGroup(g)
+	info := g.snapshot()
+	for key := range members {
+		if _, ok := old[key]; !ok {
+			events = append(events, Event{Kind: EventGroup, Peer: key, Group: info, Change: GroupMemberAdded})
+		}
+	}
+	for key := range old {
+		if _, ok := members[key]; !ok {
+			events = append(events, Event{Kind: EventGroup, Peer: key, Group: info, Change: GroupMemberRemoved})
+		}
+	}
+	return events, nil
+}
+
+func (c *Chat) takeRemoved(removed *wireRemoved, keyid string) ([]Event, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, ok := c.groups[removed.Group]
+	if !ok {
+		return nil, ErrUnknownGroup
+	}
+	if g.info.Admin != keyid {
+		return nil, ErrNotAdmin
+	}
+	delete(c.groups, removed.Group)
+	c.deleteGroup(removed.Group)
+	return []Event{{Kind: EventGroup, Peer: keyid, Group: g.snapshot(), Change: GroupRemoved}}, nil
+}
+
+// a message is opened with the key of its epoch, and taken if its sender is a member now
+func (c *Chat) takeGroupMessage(wm *wireGroupMessage) ([]Event, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, ok := c.groups[wm.Group]
+	if !ok {
+		return nil, ErrUnknownGroup
+	}
+	key, ok := g.keys[wm.Epoch]
+	if !ok {
+		return nil, ErrEpoch
+	}
+	plain, err := open(key, wm.Nonce, wm.Sealed)
+	if err != nil {
+		return nil, err
+	}
+	var sm sealedMessage
+	if err := rlp.DecodeBytes(plain, &sm); err != nil {
+		return nil, err
+	}
+	pub, err := crypto.SigToPub(sm.hash(wm.Group, wm.Epoch), sm.Signature)
+	if err != nil {
+		return nil, err
+	}
+	from := common.ToHex(crypto.FromECDSAPub(pub))
+	if _, ok := g.members[from]; !ok {
+		return nil, ErrNotMember
+	}
+	// a message is the same for everyone, whoever passed it on, so what we have already is left as it is
+	if g.entry(from, sm.Seq) != nil {
+		return nil, nil
+	}
+	e := &groupEntry{
+		msg:  &Message{From: from, Seq: sm.Seq, Text: sm.Text, Sent: time.Unix(0, int64(sm.Sent)), Group: wm.Group},
+		wire: wm,
+	}
+	g.add(e)
+	c.saveEntry(g, e)
+	m := *e.msg
+	return []Event{{Kind: EventMessage, Peer: from, Message: &m}}, nil
+}
@@ -0,0 +1,204 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+)
+
+// the next group event of the change, the others are skipped
+func (p *testPeer) expectGroup(t *testing.T, change GroupChange) Event {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-p.eventC:
+			if e.Kind == EventGroup && e.Change == change {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no group change %d", change)
+		}
+	}
+}
+
+// the admin invites the peer, and the peer joins with the invitation it got
+func join(t *testing.T, admin *testPeer, id common.Hash, p *testPeer) {
+	t.Helper()
+	if _, err := admin.chat.Invite(id, &p.key.PublicKey, p.t.BaseAddr()); err != nil {
+		t.Fatal(err)
+	}
+	e := p.expect(t, EventInvite)
+	if err := p.chat.Accept(e.Invitation); err != nil {
+		t.Fatal(err)
+	}
+	p.expectGroup(t, GroupJoined)
+	admin.expectGroup(t, GroupMemberAdded)
+}
+
+func TestGroup(t *testing.T) {
+	_, peers := newTestPeers(t, 3)
+	a, b, c := peers[0], peers[1], peers[2]
+	for _, p := range peers {
+		defer p.cleanup()
+	}
+
+	g, err := a.chat.CreateGroup("hikers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	join(t, a, g.ID, b)
+	join(t, a, g.ID, c)
+	// b learns of c from the admin
+	if e := b.expectGroup(t, GroupMemberAdded); e.Peer != c.chat.Self() {
+		t.Fatalf("added %s", e.Peer)
+	}
+	if groups := b.chat.Groups(); len(groups) != 1 || len(groups[0].Members) != 3 || groups[0].Admin != a.chat.Self() {
+		t.Fatalf("groups %+v", groups)
+	}
+
+	if _, err := c.chat.SendGroup(g.ID, "hello all"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*testPeer{a, b} {
+		e := p.expect(t, EventMessage)
+		if e.Message.Text != "hello all" || e.Message.From != c.chat.Self() || e.Message.Group != g.ID {
+			t.Fatalf("message %+v", e.Message)
+		}
+	}
+	if _, err := b.chat.Invite(g.ID, &c.key.PublicKey, nil); err != ErrNotAdmin {
+		t.Fatalf("invite by a member: %v", err)
+	}
+}
+
+// an invitation is taken only from the key it's for, and only once
+func TestStolenInvitation(t *testing.T) {
+	_, peers := newTestPeers(t, 3)
+	a, b, m := peers[0], peers[1], peers[2]
+	for _, p := range peers {
+		defer p.cleanup()
+	}
+
+	g, err := a.chat.CreateGroup("hikers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err := a.chat.Invite(g.ID, &b.key.PublicKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.chat.Accept(inv); err != nil {
+		t.Fatal(err)
+	}
+	m.quiet(t, EventGroup, testConfig.Resend)
+	if groups := a.chat.Groups(); len(groups[0].Members) != 1 {
+		t.Fatalf("members %v", groups[0].Members)
+	}
+
+	// a forged one isn't
+	forged := *inv
+	forged.Invitee = crypto.FromECDSAPub(&m.key.PublicKey)
+	if err := forged.Verify(time.Now()); err == nil {
+		t.Fatal("forged invitation verified")
+	}
+	expired := *inv
+	if err := expired.Verify(time.Now().Add(invitationTTL * 2)); err == nil {
+		t.Fatal("expired invitation verified")
+	}
+
+	if err := b.chat.Accept(inv); err != nil {
+		t.Fatal(err)
+	}
+	b.expectGroup(t, GroupJoined)
+	// and once
+	if _, err := a.chat.takeJoin(&wireJoin{Invitation: *inv}, b.chat.Self()); err == nil {
+		t.Fatal("invitation taken twice")
+	}
+}
+
+// a member removed can't read the messages sent after, and its messages aren't taken
+func TestGroupRemove(t *testing.T) {
+	net, peers := newTestPeers(t, 3)
+	a, b, c := peers[0], peers[1], peers[2]
+	for _, p := range peers {
+		defer p.cleanup()
+	}
+
+	g, err := a.chat.CreateGroup("hikers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	join(t, a, g.ID, b)
+	join(t, a, g.ID, c)
+	b.expectGroup(t, GroupMemberAdded)
+
+	// c keeps what is sent to the group, as if it could still see the messages go by
+	var sealed [][]byte
+	net.mu.Lock()
+	net.drop = func(from string, topic pss.Topic, data []byte) bool {
+		if topic == GroupTopic && from == b.chat.Self() {
+			sealed = append(sealed, data)
+		}
+		return false
+	}
+	net.mu.Unlock()
+
+	if err := a.chat.Remove(g.ID, c.chat.Self()); err != nil {
+		t.Fatal(err)
+	}
+	c.expectGroup(t, GroupRemoved)
+	if e := b.expectGroup(t, GroupMemberRemoved); e.Peer != c.chat.Self() || e.Group.Epoch != 1 {
+		t.Fatalf("removed %+v", e.Group)
+	}
+	if len(c.chat.Groups()) != 0 {
+		t.Fatal("still in the group")
+	}
+
+	if _, err := b.chat.SendGroup(g.ID, "without c"); err != nil {
+		t.Fatal(err)
+	}
+	a.expect(t, EventMessage)
+	net.mu.Lock()
+	msgs := sealed
+	net.mu.Unlock()
+	if len(msgs) != 1 {
+		t.Fatalf("%d sent", len(msgs))
+	}
+	// the key c had doesn't open it
+	var wg wireGroup
+	var wm wireGroupMessage
+	if err := rlp.DecodeBytes(msgs[0], &wg); err != nil {
+		t.Fatal(err)
+	}
+	if err := rlp.DecodeBytes(wg.Data, &wm); err != nil {
+		t.Fatal(err)
+	}
+	a.chat.mu.Lock()
+	oldKey := a.chat.groups[g.ID].keys[0]
+	a.chat.mu.Unlock()
+	if wm.Epoch != 1 {
+		t.Fatalf("epoch %d", wm.Epoch)
+	}
+	if _, err := open(oldKey, wm.Nonce, wm.Sealed); err == nil {
+		t.Fatal("opened with the old key")
+	}
+
+	// what c seals with the key it has isn't taken from it, it's no member
+	sm := &sealedMessage{Seq: 1, Text: "still here"}
+	if sm.Signature, err = crypto.Sign(sm.hash(g.ID, 0), c.key); err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := rlp.EncodeToBytes(sm)
+	nonce, data, err := seal(oldKey, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.chat.takeGroupMessage(&wireGroupMessage{Group: g.ID, Nonce: nonce, Sealed: data}); err != ErrNotMember {
+		t.Fatalf("message from a removed member: %v", err)
+	}
+	b.quiet(t, EventMessage, testConfig.Resend)
+}
@@ -0,0 +1,435 @@
+package chat
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// the history keeps the groups and their messages, so the chat has them again when it starts,
+// and a member that was away asks the others for what it missed.
+//
+// A message of a group is known by its sender and the sender's seq, and is the same whoever passes it on:
+// it is sealed with the key of the group and signed by its sender, and a member passes on what it was sent as it was.
+// So the members merge what they're given with what they have without conflicts, taking what they don't have,
+// and show the messages in the same order, by the time they were sent, then by sender and seq.
+//
+// The member asks the others for the ranges of seqs it misses of each sender, and the ones after the last it has,
+// and gets the messages of the senders it didn't know of too. It can only take the messages of the epochs
+// it has the key of, and of the senders that are members still
+
+const (
+	historyCache   = 16
+	historyHandles = 16
+
+	// the most messages in a sync reply, the rest come in more replies
+	syncBatch = 16
+)
+
+var (
+	groupPrefix   = []byte("group:")
+	messagePrefix = []byte("gmsg:")
+)
+
+// History keeps the groups and their messages in a leveldb database
+type History struct {
+	db *ethdb.LDBDatabase
+}
+
+// OpenHistory opens the history in the directory, and creates it if it isn't there
+func OpenHistory(path string) (*History, error) {
+	db, err := ethdb.NewLDBDatabase(path, historyCache, historyHandles)
+	if err != nil {
+		return nil, fmt.Errorf("open chat history: %v", err)
+	}
+	return &History{db: db}, nil
+}
+
+func (h *History) Close() {
+	h.db.Close()
+}
+
+type epochKey struct {
+	Epoch uint64
+	Key   []byte
+}
+
+// what is kept of a group
+type groupRecord struct {
+	ID      common.Hash
+	Name    string
+	Admin   string
+	Epoch   uint64
+	Keys    []epochKey
+	Members []Member
+	Invited []string
+	Seq     uint64
+}
+
+// what is kept of a message, what was sent and what it was opened to
+type messageRecord struct {
+	From string
+	Seq  uint64
+	Text string
+	Sent uint64
+	Wire wireGroupMessage
+}
+
+func groupKey(id common.Hash) []byte {
+	return append(append([]byte{}, groupPrefix...), id[:]...)
+}
+
+// the messages of a group are in the order of their sender and seq
+func messageKey(id common.Hash, from string, seq uint64) []byte {
+	key := append(append([]byte{}, messagePrefix...), id[:]...)
+	key = append(key, common.FromHex(from)...)
+	return append(key, uint64Bytes(seq)...)
+}
+
+func (h *History) putGroup(g *group) error {
+	r := &groupRecord{
+		ID:    g.info.ID,
+		Name:  g.info.Name,
+		Admin: g.info.Admin,
+		Epoch: g.info.Epoch,
+		Seq:   g.seq,
+	}
+	for epoch, key := range g.keys {
+		r.Keys = append(r.Keys, epochKey{Epoch: epoch, Key: key})
+	}
+	sort.Slice(r.Keys, func(i, j int) bool { return r.Keys[i].Epoch < r.Keys[j].Epoch })
+	for _, key := range g.snapshot().Members {
+		r.Members = append(r.Members, g.members[key])
+	}
+	for key := range g.invited {
+		r.Invited = append(r.Invited, key)
+	}
+	sort.Strings(r.Invited)
+	b, err := rlp.EncodeToBytes(r)
+	if err != nil {
+		return err
+	}
+	return h.db.Put(groupKey(g.info.ID), b)
+}
+
+func (h *History) putMessage(e *groupEntry) error {
+	r := &messageRecord{
+		From: e.msg.From,
+		Seq:  e.msg.Seq,
+		Text: e.msg.Text,
+		Sent: uint64(e.msg.Sent.UnixNano()),
+		Wire: *e.wire,
+	}
+	b, err := rlp.EncodeToBytes(r)
+	if err != nil {
+		return err
+	}
+	return h.db.Put(messageKey(e.msg.Group, e.msg.From, e.msg.Seq), b)
+}
+
+// the group and its messages go
+func (h *History) deleteGroup(id common.Hash) error {
+	batch := h.db.NewBatch()
+	batch.Delete(groupKey(id))
+	it := h.db.NewIteratorWithPrefix(append(append([]byte{}, messagePrefix...), id[:]...))
+	defer it.Release()
+	for it.Next() {
+		batch.Delete(common.CopyBytes(it.Key()))
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// the groups kept, with their messages
+func (h *History) groups() ([]*group, error) {
+	var groups []*group
+	it := h.db.NewIteratorWithPrefix(groupPrefix)
+	defer it.Release()
+	for it.Next() {
+		var r groupRecord
+		if err := rlp.DecodeBytes(it.Value(), &r); err != nil {
+			return nil, err
+		}
+		g := newGroup(Group{ID: r.ID, Name: r.Name, Admin: r.Admin, Epoch: r.Epoch})
+		g.seq = r.Seq
+		for _, k := range r.Keys {
+			g.keys[k.Epoch] = k.Key
+		}
+		for _, m := range r.Members {
+			g.members[common.ToHex(m.Key)] = m
+		}
+		for _, key := range r.Invited {
+			g.invited[key] = true
+		}
+		if err := h.loadMessages(g); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, it.Error()
+}
+
+func (h *History) loadMessages(g *group) error {
+	it := h.db.NewIteratorWithPrefix(append(append([]byte{}, messagePrefix...), g.info.ID[:]...))
+	defer it.Release()
+	for it.Next() {
+		var r messageRecord
+		if err := rlp.DecodeBytes(it.Value(), &r); err != nil {
+			return err
+		}
+		wire := r.Wire
+		g.add(&groupEntry{
+			msg:  &Message{From: r.From, Seq: r.Seq, Text: r.Text, Sent: time.Unix(0, int64(r.Sent)), Group: g.info.ID},
+			wire: &wire,
+		})
+	}
+	return it.Error()
+}
+
+// SetHistory keeps the groups and their messages in the history, and takes the ones it has
+// it must be called before Start
+func (c *Chat) SetHistory(h *History) error {
+	groups, err := h.groups()
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		for key, m := range g.members {
+			if key == c.self {
+				continue
+			}
+			if err := c.setGroupPeer(m); err != nil {
+				return err
+			}
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.history = h
+	for _, g := range groups {
+		c.groups[g.info.ID] = g
+	}
+	return nil
+}
+
+// must be called with the lock held
+func (c *Chat) saveGroup(g *group) {
+	if c.history == nil {
+		return
+	}
+	if err := c.history.putGroup(g); err != nil {
+		log.Warn("chat history group fail", "group", g.info.ID, "err", err)
+	}
+}
+
+// must be called with the lock held
+func (c *Chat) saveEntry(g *group, e *groupEntry) {
+	if c.history == nil {
+		return
+	}
+	if err := c.history.putMessage(e); err != nil {
+		log.Warn("chat history message fail", "group", g.info.ID, "err", err)
+	}
+	// our seq goes with the group
+	if e.msg.From == c.self {
+		c.saveGroup(g)
+	}
+}
+
+// must be called with the lock held
+func (c *Chat) deleteGroup(id common.Hash) {
+	if c.history == nil {
+		return
+	}
+	if err := c.history.deleteGroup(id); err != nil {
+		log.Warn("chat history delete fail", "group", id, "err", err)
+	}
+}
+
+// the messages in the order every member shows them
+// must be called with the lock held
+func (g *group) messages() []Message {
+	var msgs []Message
+	for _, seqs := range g.entries {
+		for _, e := range seqs {
+			msgs = append(msgs, *e.msg)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		a, b := msgs[i], msgs[j]
+		if !a.Sent.Equal(b.Sent) {
+			return a.Sent.Before(b.Sent)
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.Seq < b.Seq
+	})
+	return msgs
+}
+
+// SeqRange is the seqs of a sender from First to Last, or all after First if Last is 0
+type SeqRange struct {
+	Sender []byte
+	First  uint64
+	Last   uint64
+}
+
+func (r *SeqRange) has(seq uint64) bool {
+	return seq >= r.First && (r.Last == 0 || seq <= r.Last)
+}
+
+// the seqs we miss of each sender we know of, the ones between those we have, and all after the last
+// must be called with the lock held
+func (g *group) missing() []SeqRange {
+	var ranges []SeqRange
+	for from, entries := range g.entries {
+		sender := common.FromHex(from)
+		var seqs []uint64
+		for seq := range entries {
+			seqs = append(seqs, seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+		next := uint64(1)
+		for _, seq := range seqs {
+			if seq > next {
+				ranges = append(ranges, SeqRange{Sender: sender, First: next, Last: seq - 1})
+			}
+			next = seq + 1
+		}
+		ranges = append(ranges, SeqRange{Sender: sender, First: next})
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		if c := bytes.Compare(ranges[i].Sender, ranges[j].Sender); c != 0 {
+			return c < 0
+		}
+		return ranges[i].First < ranges[j].First
+	})
+	return ranges
+}
+
+// what we have in the ranges, and all of the senders not in them
+// must be called with the lock held
+func (g *group) inRanges(ranges []SeqRange) []*groupEntry {
+	asked := make(map[string][]SeqRange)
+	for _, r := range ranges {
+		from := common.ToHex(r.Sender)
+		asked[from] = append(asked[from], r)
+	}
+	var entries []*groupEntry
+	for from, seqs := range g.entries {
+		for seq, e := range seqs {
+			rs, ok := asked[from]
+			take := !ok
+			for _, r := range rs {
+				if r.has(seq) {
+					take = true
+					break
+				}
+			}
+			if take {
+				entries = append(entries, e)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].msg, entries[j].msg
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.Seq < b.Seq
+	})
+	return entries
+}
+
+type wireSyncRequest struct {
+	Group  common.Hash
+	Ranges []SeqRange
+}
+
+type wireSyncReply struct {
+	Group    common.Hash
+	Messages []wireGroupMessage
+}
+
+// SyncGroup asks the other members for the messages of the group we miss
+// what they send comes as EventMessage, like the messages sent to the group
+func (c *Chat) SyncGroup(id common.Hash) error {
+	c.mu.Lock()
+	g, ok := c.groups[id]
+	if !ok {
+		c.mu.Unlock()
+		return ErrUnknownGroup
+	}
+	req := &wireSyncRequest{Group: id, Ranges: g.missing()}
+	members := g.snapshot().Members
+	c.mu.Unlock()
+
+	for _, member := range members {
+		if member == c.self {
+			continue
+		}
+		if err := c.pushGroup(member, groupSyncRequest, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// a member asks for what it missed, it gets what we have, as it was sent
+func (c *Chat) takeSyncRequest(req *wireSyncRequest, keyid string) error {
+	c.mu.Lock()
+	g, ok := c.groups[req.Group]
+	if !ok {
+		c.mu.Unlock()
+		return ErrUnknownGroup
+	}
+	if _, ok := g.members[keyid]; !ok {
+		c.mu.Unlock()
+		return ErrNotMember
+	}
+	entries := g.inRanges(req.Ranges)
+	c.mu.Unlock()
+
+	for len(entries) > 0 {
+		n := len(entries)
+		if n > syncBatch {
+			n = syncBatch
+		}
+		reply := &wireSyncReply{Group: req.Group}
+		for _, e := range entries[:n] {
+			reply.Messages = append(reply.Messages, *e.wire)
+		}
+		entries = entries[n:]
+		if err := c.pushGroup(keyid, groupSyncReply, reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the messages are taken as if they were sent to us, the ones we have already are passed over
+func (c *Chat) takeSyncReply(reply *wireSyncReply, keyid string) []Event {
+	var events []Event
+	for i := range reply.Messages {
+		wm := &reply.Messages[i]
+		if wm.Group != reply.Group {
+			continue
+		}
+		evs, err := c.takeGroupMessage(wm)
+		if err != nil {
+			log.Debug("synced message not taken", "from", keyid, "err", err)
+			continue
+		}
+		events = append(events, evs...)
+	}
+	return events
+}
@@ -0,0 +1,186 @@
+package chat
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func openTestHistory(t *testing.T) (*History, string) {
+	dir, err := ioutil.TempDir("", "chat-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := OpenHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, dir
+}
+
+// stops the chat of the peer, and starts a new one with its key and the history, as when the app starts again
+func (p *testPeer) restart(t *testing.T, h *History) {
+	p.cleanup()
+	c := New(p.t, p.key, testConfig)
+	if err := c.SetHistory(h); err != nil {
+		t.Fatal(err)
+	}
+	p.eventC = make(chan Event, 64)
+	sub := c.SubscribeEvents(p.eventC)
+	c.Start()
+	p.chat = c
+	p.cleanup = func() {
+		sub.Unsubscribe()
+		c.Stop()
+	}
+}
+
+// the texts of the messages, in the order the chat shows them
+func texts(msgs []Message) []string {
+	var s []string
+	for _, m := range msgs {
+		s = append(s, m.Text)
+	}
+	return s
+}
+
+func TestHistoryRestart(t *testing.T) {
+	_, peers := newTestPeers(t, 2)
+	a, b := peers[0], peers[1]
+	defer b.cleanup()
+	h, dir := openTestHistory(t)
+	defer os.RemoveAll(dir)
+	a.restart(t, h)
+
+	g, err := a.chat.CreateGroup("hikers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	join(t, a, g.ID, b)
+	if _, err := a.chat.SendGroup(g.ID, "first"); err != nil {
+		t.Fatal(err)
+	}
+	b.expect(t, EventMessage)
+	if _, err := b.chat.SendGroup(g.ID, "second"); err != nil {
+		t.Fatal(err)
+	}
+	a.expect(t, EventMessage)
+	before := a.chat.GroupMessages(g.ID)
+
+	// the group and its messages are back, with our seq
+	h.Close()
+	h, err = OpenHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	a.restart(t, h)
+	defer a.cleanup()
+	if groups := a.chat.Groups(); len(groups) != 1 || groups[0].ID != g.ID || len(groups[0].Members) != 2 {
+		t.Fatalf("groups %+v", groups)
+	}
+	if after := a.chat.GroupMessages(g.ID); !reflect.DeepEqual(texts(after), texts(before)) {
+		t.Fatalf("messages %v, before %v", texts(after), texts(before))
+	}
+	msg, err := a.chat.SendGroup(g.ID, "third")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Seq != 2 {
+		t.Fatalf("seq %d", msg.Seq)
+	}
+	if e := b.expect(t, EventMessage); e.Message.Text != "third" {
+		t.Fatalf("message %+v", e.Message)
+	}
+}
+
+// a member that was away gets what it missed from the others, once, in the order they have it in
+func TestSync(t *testing.T) {
+	net, peers := newTestPeers(t, 3)
+	a, b, c := peers[0], peers[1], peers[2]
+	for _, p := range peers {
+		defer p.cleanup()
+	}
+	g, err := a.chat.CreateGroup("hikers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	join(t, a, g.ID, b)
+	join(t, a, g.ID, c)
+	b.expectGroup(t, GroupMemberAdded)
+	if _, err := b.chat.SendGroup(g.ID, "before"); err != nil {
+		t.Fatal(err)
+	}
+	c.expect(t, EventMessage)
+	a.expect(t, EventMessage)
+
+	// c is away while a and b talk
+	net.mu.Lock()
+	delete(net.transports, c.t.key)
+	net.mu.Unlock()
+	for _, m := range []struct {
+		from *testPeer
+		text string
+	}{{a, "one"}, {b, "two"}, {a, "three"}} {
+		if _, err := m.from.chat.SendGroup(g.ID, m.text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.expect(t, EventMessage)
+	b.expect(t, EventMessage)
+	b.expect(t, EventMessage)
+	net.mu.Lock()
+	net.transports[c.t.key] = c.t
+	net.mu.Unlock()
+
+	c.chat.mu.Lock()
+	missing := c.chat.groups[g.ID].missing()
+	c.chat.mu.Unlock()
+	if len(missing) != 1 || missing[0].First != 2 || missing[0].Last != 0 || common.ToHex(missing[0].Sender) != b.chat.Self() {
+		t.Fatalf("missing %+v", missing)
+	}
+
+	// both a and b answer, each message is taken once
+	if err := c.chat.SyncGroup(g.ID); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		c.expect(t, EventMessage)
+	}
+	c.quiet(t, EventMessage, testConfig.Resend)
+	want := texts(a.chat.GroupMessages(g.ID))
+	if got := texts(c.chat.GroupMessages(g.ID)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("messages %v, want %v", got, want)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	from := "0x04aa"
+	g := newGroup(Group{})
+	for _, seq := range []uint64{2, 3, 6} {
+		g.add(&groupEntry{msg: &Message{From: from, Seq: seq}})
+	}
+	want := []SeqRange{
+		{Sender: common.FromHex(from), First: 1, Last: 1},
+		{Sender: common.FromHex(from), First: 4, Last: 5},
+		{Sender: common.FromHex(from), First: 7},
+	}
+	if got := g.missing(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("missing %+v", got)
+	}
+
+	// what is asked for, and all of the senders not asked about
+	other := "0x04bb"
+	g.add(&groupEntry{msg: &Message{From: other, Seq: 1}})
+	var got []uint64
+	for _, e := range g.inRanges([]SeqRange{{Sender: common.FromHex(from), First: 3, Last: 5}}) {
+		got = append(got, e.msg.Seq)
+	}
+	// the senders sort by key, from first
+	if !reflect.DeepEqual(got, []uint64{3, 1}) {
+		t.Fatalf("in ranges %v", got)
+	}
+}
@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+
+	"../common/registry"
+)
+
+// registered as "chat", over the pss of the swarm service of the stack, with its key
+func init() {
+	registry.Register("chat", func(ctx *node.ServiceContext) (node.Service, error) {
+		ps, err := registry.SwarmPss(ctx)
+		if err != nil {
+			return nil, err
+		}
+		key, err := registry.SwarmKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return NewService(New(NewPssTransport(ps), key, DefaultConfig())), nil
+	}, "swarm")
+}
+
+// Service is the chat as a service of a node, with the chat namespace on its rpc
+type Service struct {
+	Chat *Chat
+}
+
+func NewService(c *Chat) *Service {
+	return &Service{Chat: c}
+}
+
+// the messages go over pss, the chat has no protocol of its own
+func (s *Service) Protocols() []p2p.Protocol {
+	return nil
+}
+
+func (s *Service) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "chat",
+			Version:   "1.0",
+			Service:   &API{s.Chat},
+			Public:    true,
+		},
+	}
+}
+
+func (s *Service) Start(srv *p2p.Server) error {
+	s.Chat.Start()
+	return nil
+}
+
+func (s *Service) Stop() error {
+	s.Chat.Stop()
+	return nil
+}
+
+// API is the chat namespace of the node's rpc
+type API struct {
+	c *Chat
+}
+
+// Self is our key, in hex
+func (api *API) Self() string {
+	return api.c.Self()
+}
+
+// AddPeer starts a conversation with the public key, at the pss address, and returns the peer's name in the chat
+func (api *API) AddPeer(pubkey hexutil.Bytes, addr hexutil.Bytes) (string, error) {
+	pub, err := crypto.UnmarshalPubkey(pubkey)
+	if err != nil {
+		return "", err
+	}
+	return api.c.AddPeer(pub, pss.PssAddress(addr))
+}
+
+// Send sends the text to the peer
+func (api *API) Send(peer string, text string) (*Message, error) {
+	return api.c.Send(peer, text)
+}
+
+// Messages is the conversation with the peer
+func (api *API) Messages(peer string) []Message {
+	return api.c.Messages(peer)
+}
+
+func (api *API) Stats() Stats {
+	return api.c.Stats()
+}
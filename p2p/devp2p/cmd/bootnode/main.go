@@ -0,0 +1,172 @@
+// runs a discovery-only bootnode
+//
+// it speaks the discovery protocol (udp) and nothing else; there is no p2p server, so no peers connect to it over tcp
+// nodes that have it in their bootnodes learn about each other through it
+//
+// the node key is kept in a file, so the enode stays the same between runs
+// the enode url and the node record are printed at start, and can be written to a file with -o
+// the record is what goes into a dns node list, see cmd/dnstree
+//
+// usage, from the directory with the examples:
+//
+//	go run cmd/bootnode/main.go -k bootnode.key -addr :30301 -extip 10.0.0.1 -o bootnode.enr
+package main
+
+import (
+	"crypto/ecdsa"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/netutil"
+
+	"../../dnsdisc"
+)
+
+const (
+	// how often we report what the node knows
+	statusInterval = time.Second * 30
+)
+
+var (
+	listenaddr  = flag.String("addr", ":30301", "udp address to listen on")
+	nodekeyfile = flag.String("k", "bootnode.key", "file with the hex encoded node key, generated if it doesn't exist")
+	extip       = flag.String("extip", "", "ip address other nodes reach us on, if not the local one")
+	bootnodes   = flag.String("bootnodes", "", "other bootnodes to join, comma separated enodes")
+	netrestrict = flag.String("netrestrict", "", "only talk to the given networks (CIDR masks, comma separated)")
+	outfile     = flag.String("o", "", "file to write the node record to")
+	verbose     = flag.Bool("v", false, "more verbose logs")
+)
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// loads the key, or makes one and saves it if there is no key file yet
+func loadKey(path string) (*ecdsa.PrivateKey, error) {
+	key, err := crypto.LoadECDSA(path)
+	if err == nil {
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err = crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	log.Info("generated new node key", "file", path)
+	return key, crypto.SaveECDSA(path, key)
+}
+
+func main() {
+	flag.Parse()
+
+	loglevel := log.LvlInfo
+	if *verbose {
+		loglevel = log.LvlTrace
+	}
+	log.Root().SetHandler(log.LvlFilterHandler(loglevel, log.StreamHandler(os.Stderr, log.TerminalFormat(true))))
+
+	key, err := loadKey(*nodekeyfile)
+	if err != nil {
+		fatal("node key: %v", err)
+	}
+
+	var boot []*enode.Node
+	for _, url := range strings.Split(*bootnodes, ",") {
+		if url == "" {
+			continue
+		}
+		n, err := enode.ParseV4(url)
+		if err != nil {
+			fatal("bootnode %s: %v", url, err)
+		}
+		boot = append(boot, n)
+	}
+
+	var restrict *netutil.Netlist
+	if *netrestrict != "" {
+		restrict, err = netutil.ParseNetlist(*netrestrict)
+		if err != nil {
+			fatal("netrestrict: %v", err)
+		}
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", *listenaddr)
+	if err != nil {
+		fatal("listen address: %v", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		fatal("listen: %v", err)
+	}
+	realaddr := conn.LocalAddr().(*net.UDPAddr)
+
+	// the local node holds our record, and signs it with the node key
+	// the discovery protocol fills in the address as other nodes see it, but until then we tell it what we know
+	// the node database is in memory; a bootnode has no use for remembering nodes between runs
+	db, err := enode.OpenDB("")
+	if err != nil {
+		fatal("node db: %v", err)
+	}
+	ln := enode.NewLocalNode(db, key)
+	ln.SetFallbackIP(net.IP{127, 0, 0, 1})
+	ln.SetFallbackUDP(realaddr.Port)
+	if *extip != "" {
+		ip := net.ParseIP(*extip)
+		if ip == nil {
+			fatal("invalid extip '%s'", *extip)
+		}
+		ln.SetStaticIP(ip)
+	} else if !realaddr.IP.IsUnspecified() {
+		ln.SetStaticIP(realaddr.IP)
+	}
+
+	tab, err := discover.ListenUDP(conn, ln, discover.Config{
+		PrivateKey:  key,
+		NetRestrict: restrict,
+		Bootnodes:   boot,
+	})
+	if err != nil {
+		fatal("discovery: %v", err)
+	}
+	defer tab.Close()
+
+	self := ln.Node()
+	fmt.Println(self.String())
+	fmt.Println(dnsdisc.ENR(self))
+	if *outfile != "" {
+		err = ioutil.WriteFile(*outfile, []byte(dnsdisc.ENR(self)+"\n"), 0644)
+		if err != nil {
+			fatal("write record: %v", err)
+		}
+	}
+
+	// run until we're told to stop, and tell now and then how many nodes we know of
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	buf := make([]*enode.Node, 256)
+	for {
+		select {
+		case <-ticker.C:
+			log.Info("bootnode status", "known", tab.ReadRandomNodes(buf))
+		case <-sigC:
+			log.Info("stopping")
+			return
+		}
+	}
+}
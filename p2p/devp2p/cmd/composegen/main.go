@@ -0,0 +1,278 @@
+// generates a docker-compose environment that runs an example as separate nodes in containers
+//
+// every node gets its own directory with a private key, and all nodes connect to the first one
+// the first node is thus the "bootnode" of the network
+//
+// usage, from the directory with the examples:
+//
+//	go run cmd/composegen/main.go -x E1_Pss.go -n 3 -o compose
+//	cd compose && docker-compose up
+package main
+
+import (
+	"crypto/ecdsa"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+const (
+	// same as the default p2p port in demo/common
+	defaultP2pPort = 30100
+
+	// the go-ethereum version the examples are written against
+	gethVersion = "v1.8.20"
+
+	// where the examples are mounted in the container
+	// it must be outside of GOPATH, since the examples use relative imports
+	srcMount = "/demo"
+
+	// where each node's own directory is mounted in the container
+	dataMount = "/data"
+)
+
+// the packages the examples import besides go-ethereum, the ones go-ethereum vendors too, as the examples can't see its vendor directory
+var dependencies = []string{
+	"github.com/mattn/go-colorable",
+	"github.com/mattn/go-isatty",
+	"gopkg.in/natefinch/npipe.v2",
+	"github.com/naoina/toml",
+	"gopkg.in/yaml.v2",
+	"github.com/golang/protobuf/proto",
+	"golang.org/x/net/websocket",
+	"golang.org/x/crypto/ssh",
+	"github.com/skip2/go-qrcode",
+	"gonum.org/v1/plot/...",
+	"github.com/google/pprof/profile",
+}
+
+var (
+	example  = flag.String("x", "", "example file to run, e.g. E1_Pss.go")
+	nodes    = flag.Int("n", 3, "number of nodes")
+	outdir   = flag.String("o", "compose", "output directory")
+	srcdir   = flag.String("s", "..", "directory with the examples, relative to the output directory")
+	hostport = flag.Int("hostport", defaultP2pPort, "first host port to map the nodes' p2p ports to, 0 for no mapping")
+	subnet   = flag.String("subnet", "172.28.0.0/24", "subnet of the docker network")
+	image    = flag.String("image", "ethereum-samples/devp2p", "name of the docker image to build")
+	verbose  = flag.Bool("v", false, "run the examples with verbose logs")
+	health   = flag.Int("health", 8080, "port the nodes serve their health on in the container, for the compose health checks, 0 for none")
+)
+
+// everything the templates need to know about a node
+type composeNode struct {
+	Name       string
+	IP         string
+	Port       int
+	HostPort   int
+	HealthPort int
+	Enode      string
+	Command    string
+}
+
+type composeEnv struct {
+	Image       string
+	Subnet      string
+	SrcDir      string
+	SrcMount    string
+	DataMount   string
+	GethVersion string
+	Deps        []string
+	Nodes       []*composeNode
+}
+
+var composeTemplate = template.Must(template.New("compose").Parse(`version: "3"
+
+services:
+{{- range .Nodes}}
+  {{.Name}}:
+    build: .
+    image: {{$.Image}}
+    working_dir: {{$.SrcMount}}
+    command: {{.Command}}
+    volumes:
+      - {{$.SrcDir}}:{{$.SrcMount}}
+      - ./{{.Name}}:{{$.DataMount}}
+{{- if .HostPort}}
+    ports:
+      - "{{.HostPort}}:{{.Port}}"
+{{- end}}
+{{- if .HealthPort}}
+    healthcheck:
+      test: ["CMD", "wget", "-q", "-O", "-", "http://localhost:{{.HealthPort}}/readyz"]
+      interval: 5s
+      timeout: 3s
+      retries: 24
+{{- end}}
+    networks:
+      demo:
+        ipv4_address: {{.IP}}
+{{- end}}
+
+networks:
+  demo:
+    ipam:
+      config:
+        - subnet: {{.Subnet}}
+`))
+
+var dockerfileTemplate = template.Must(template.New("dockerfile").Parse(`FROM golang:1.11-alpine
+
+RUN apk add --update git gcc musl-dev linux-headers
+
+RUN mkdir -p $GOPATH/src/github.com/ethereum && \
+	cd $GOPATH/src/github.com/ethereum && \
+	git clone https://github.com/ethereum/go-ethereum && \
+	cd go-ethereum && \
+	git checkout {{.GethVersion}}
+
+RUN go get -d{{range .Deps}} \
+	{{.}}{{end}}
+
+WORKDIR {{.SrcMount}}
+`))
+
+// assigns addresses in the subnet, starting from .10 to stay clear of the gateway
+func nodeIPs(cidr string, count int) ([]net.IP, error) {
+	ip, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ip = ip.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("only ipv4 subnets are supported")
+	}
+	var ips []net.IP
+	for i := 0; i < count; i++ {
+		if int(ip[3])+10+i > 254 {
+			return nil, fmt.Errorf("subnet %s too small for %d nodes", cidr, count)
+		}
+		nodeip := make(net.IP, len(ip))
+		copy(nodeip, ip)
+		nodeip[3] += byte(10 + i)
+		if !ipnet.Contains(nodeip) {
+			return nil, fmt.Errorf("subnet %s too small for %d nodes", cidr, count)
+		}
+		ips = append(ips, nodeip)
+	}
+	return ips, nil
+}
+
+// creates the node's directory and puts a fresh private key in it
+func writeNodeKey(dir string) (*ecdsa.PrivateKey, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	err = crypto.SaveECDSA(filepath.Join(dir, "nodekey"), privkey)
+	if err != nil {
+		return nil, err
+	}
+	return privkey, nil
+}
+
+func writeTemplate(path string, tpl *template.Template, env *composeEnv) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return tpl.Execute(f, env)
+}
+
+func main() {
+	flag.Parse()
+
+	if *example == "" {
+		fmt.Fprintln(os.Stderr, "no example given (-x)")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *nodes < 1 {
+		fmt.Fprintln(os.Stderr, "need at least one node (-n)")
+		os.Exit(1)
+	}
+
+	ips, err := nodeIPs(*subnet, *nodes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid subnet: %v\n", err)
+		os.Exit(1)
+	}
+
+	env := &composeEnv{
+		Image:       *image,
+		Subnet:      *subnet,
+		SrcDir:      *srcdir,
+		SrcMount:    srcMount,
+		DataMount:   dataMount,
+		GethVersion: gethVersion,
+		Deps:        dependencies,
+	}
+
+	// since we make the keys, we know the enodes before any of the nodes are started
+	// this lets us pass the first node's enode to all the others on the command line
+	for i, ip := range ips {
+		n := &composeNode{
+			Name: fmt.Sprintf("node%d", i),
+			IP:   ip.String(),
+			Port: defaultP2pPort,
+		}
+		if *hostport > 0 {
+			n.HostPort = *hostport + i
+		}
+		privkey, err := writeNodeKey(filepath.Join(*outdir, n.Name))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "create key for %s fail: %v\n", n.Name, err)
+			os.Exit(1)
+		}
+		n.Enode = enode.NewV4(&privkey.PublicKey, ip, n.Port, n.Port).String()
+
+		args := []string{"go", "run", *example, "-l", fmt.Sprintf("%d", n.Port), "-k", dataMount + "/nodekey"}
+		if *verbose {
+			args = append(args, "-v")
+		}
+		// the node is healthy once its example has its nodes up, which for a first run includes building it
+		if *health > 0 {
+			n.HealthPort = *health
+			args = append(args, "-health", fmt.Sprintf(":%d", *health))
+		}
+		if i > 0 {
+			args = append(args, "-e", env.Nodes[0].Enode)
+		}
+		n.Command = strings.Join(args, " ")
+
+		err = ioutil.WriteFile(filepath.Join(*outdir, n.Name, "enode"), []byte(n.Enode+"\n"), 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "write enode for %s fail: %v\n", n.Name, err)
+			os.Exit(1)
+		}
+		env.Nodes = append(env.Nodes, n)
+	}
+
+	err = writeTemplate(filepath.Join(*outdir, "docker-compose.yml"), composeTemplate, env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "write compose file fail: %v\n", err)
+		os.Exit(1)
+	}
+	err = writeTemplate(filepath.Join(*outdir, "Dockerfile"), dockerfileTemplate, env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "write dockerfile fail: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, n := range env.Nodes {
+		fmt.Printf("%s\t%s\n", n.Name, n.Enode)
+	}
+	fmt.Printf("wrote %s/docker-compose.yml, run it with 'docker-compose up' from that directory\n", *outdir)
+}
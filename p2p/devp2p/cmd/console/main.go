@@ -0,0 +1,710 @@
+// attaches to a running example node over IPC and takes commands for it, one per line
+//
+// the node is given by the path of its IPC socket, or by its p2p port, which finds the socket in the data directory
+// the examples make for that port. Tab completes the commands, and the methods for call.
+// Read from a pipe it runs the commands without a prompt, so it can be scripted
+//
+// usage, from the directory with the examples, while an example runs:
+//
+//	go run cmd/console/main.go 30100
+//	go run cmd/console/main.go .data_30101/demo.ipc
+//	echo peers | go run cmd/console/main.go 30100
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"../../contact"
+	"../../envelope"
+)
+
+const (
+	// same as in demo/common
+	datadirPrefix = ".data_"
+	ipcName       = "demo.ipc"
+
+	prompt = "> "
+)
+
+var (
+	datadir = flag.String("datadir", "", "the data directory the example was run with, if any")
+)
+
+type command struct {
+	name string
+	args string
+	help string
+	run  func(c *console, args []string) error
+	sub  []*command // the subcommands, instead of run
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "help", help: "list the commands", run: (*console).help},
+		{name: "modules", help: "the rpc namespaces of the node", run: (*console).modules},
+		{name: "nodeinfo", help: "the enode and protocols of the node", run: (*console).nodeInfo},
+		{name: "peers", help: "the connected peers", run: (*console).peers},
+		{name: "addpeer", args: "<enode>", help: "connect to a node", run: (*console).addPeer},
+		{name: "removepeer", args: "<enode>", help: "disconnect from a node", run: (*console).removePeer},
+		{name: "kademlia", help: "the kademlia table of a swarm node", run: (*console).kademlia},
+		{name: "pss", help: "pss messaging, topics are given as 0x hex or as a string", sub: []*command{
+			{name: "addr", help: "the overlay address of the node", run: (*console).pssAddr},
+			{name: "pubkey", help: "the public key of the node", run: (*console).pssPubkey},
+			{name: "topic", args: "<string>", help: "the topic for the string", run: (*console).pssTopic},
+			{name: "peer", args: "<pubkey> <topic> [addr]", help: "add the public key of a peer, to send to it on the topic", run: (*console).pssPeer},
+			{name: "send", args: "<pubkey> <topic> <text>", help: "send the text to a peer added with pss peer", run: (*console).pssSend},
+			{name: "receive", args: "<topic>", help: "print the messages on the topic as they arrive", run: (*console).pssReceive},
+			{name: "stop", args: "<topic>", help: "stop printing the messages on the topic", run: (*console).pssStop},
+		}},
+		{name: "demo", help: "the jobs of a protocol-complex node, data is given as 0x hex or as a string", sub: []*command{
+			{name: "difficulty", args: "<min> <max>", help: "set the difficulties the node takes jobs for, and announce them", run: (*console).demoDifficulty},
+			{name: "submit", args: "<data> <difficulty> [timeout]", help: "submit a job to a worker, the timeout in milliseconds", run: (*console).demoSubmit},
+			{name: "job", args: "<id>", help: "the status of a submitted job", run: (*console).demoJob},
+			{name: "jobs", help: "the latest submitted jobs", run: (*console).demoJobs},
+			{name: "cancel", args: "<id>", help: "cancel a submitted job", run: (*console).demoCancel},
+			{name: "skills", help: "the skills the peers announced", run: (*console).demoSkills},
+		}},
+		{name: "card", help: "the contact card of the node, to peer with it and send to it over pss from another machine", sub: []*command{
+			{name: "show", args: "[name] [topic...]", help: "the card as compact json, and as a qr code", run: (*console).cardShow},
+			{name: "vcard", args: "[name] [topic...]", help: "the card as a vcard, and as a qr code", run: (*console).cardVCard},
+			{name: "import", args: "<card or file> [topic...]", help: "peer with the node of a card, and add its pss key for its topics", run: (*console).cardImport},
+		}},
+		{name: "call", args: "<method> [args]", help: "call any method, the args are json, or taken as strings if they aren't", run: (*console).call},
+		{name: "exit", help: "leave the console"},
+	}
+}
+
+func findCommand(cmds []*command, name string) *command {
+	for _, cmd := range cmds {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+type console struct {
+	client *rpc.Client
+	out    io.Writer
+
+	mu         sync.Mutex
+	namespaces []string
+	subs       map[string]*rpc.ClientSubscription // topic -> subscription of pss receive
+}
+
+// runs the command in the line, false when it's the end of the session
+func (c *console) exec(line string) bool {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return true
+	}
+	if args[0] == "exit" || args[0] == "quit" {
+		return false
+	}
+	cmd := findCommand(commands, args[0])
+	if cmd == nil {
+		fmt.Fprintf(c.out, "unknown command %q, try help\n", args[0])
+		return true
+	}
+	args = args[1:]
+	if cmd.sub != nil {
+		if len(args) == 0 || findCommand(cmd.sub, args[0]) == nil {
+			var names []string
+			for _, sub := range cmd.sub {
+				names = append(names, sub.name)
+			}
+			fmt.Fprintf(c.out, "%s takes one of %s\n", cmd.name, strings.Join(names, ", "))
+			return true
+		}
+		cmd, args = findCommand(cmd.sub, args[0]), args[1:]
+	}
+	if err := cmd.run(c, args); err != nil {
+		fmt.Fprintf(c.out, "%s: %v\n", cmd.name, err)
+	}
+	return true
+}
+
+// completes the word at the cursor with the command, subcommand or method names it can be
+// only as far as all of them agree, and with a space after when there's one
+func (c *console) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	words := strings.Fields(line[:pos])
+	if len(words) == 0 || strings.HasSuffix(line[:pos], " ") {
+		words = append(words, "")
+	}
+	word := words[len(words)-1]
+
+	var candidates []string
+	switch len(words) {
+	case 1:
+		for _, cmd := range commands {
+			candidates = append(candidates, cmd.name)
+		}
+	case 2:
+		if cmd := findCommand(commands, words[0]); cmd != nil {
+			for _, sub := range cmd.sub {
+				candidates = append(candidates, sub.name)
+			}
+			if cmd.name == "call" {
+				c.mu.Lock()
+				for _, ns := range c.namespaces {
+					candidates = append(candidates, ns+"_")
+				}
+				c.mu.Unlock()
+			}
+		}
+	}
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	completion := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, completion) {
+			completion = completion[:len(completion)-1]
+		}
+	}
+	if len(matches) == 1 && !strings.HasSuffix(completion, "_") {
+		completion += " "
+	}
+	if len(completion) == len(word) {
+		return "", 0, false
+	}
+	start := pos - len(word)
+	return line[:start] + completion + line[pos:], start + len(completion), true
+}
+
+func (c *console) help(args []string) error {
+	for _, cmd := range commands {
+		if cmd.sub == nil {
+			fmt.Fprintf(c.out, "  %-30s %s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.help)
+			continue
+		}
+		fmt.Fprintf(c.out, "  %-30s %s\n", cmd.name, cmd.help)
+		for _, sub := range cmd.sub {
+			fmt.Fprintf(c.out, "    %-28s %s\n", strings.TrimSpace(sub.name+" "+sub.args), sub.help)
+		}
+	}
+	return nil
+}
+
+// prints the result as indented json
+func (c *console) print(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, string(b))
+	return nil
+}
+
+// fetches the rpc namespaces of the node, and keeps them for completing the methods of call
+func (c *console) fetchModules() (map[string]string, error) {
+	var modules map[string]string
+	if err := c.client.Call(&modules, "rpc_modules"); err != nil {
+		return nil, err
+	}
+	var namespaces []string
+	for ns := range modules {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	c.mu.Lock()
+	c.namespaces = namespaces
+	c.mu.Unlock()
+	return modules, nil
+}
+
+func (c *console) modules(args []string) error {
+	modules, err := c.fetchModules()
+	if err != nil {
+		return err
+	}
+	for _, ns := range c.namespaces {
+		fmt.Fprintf(c.out, "  %s %s\n", ns, modules[ns])
+	}
+	return nil
+}
+
+func (c *console) nodeInfo(args []string) error {
+	var info p2p.NodeInfo
+	if err := c.client.Call(&info, "admin_nodeInfo"); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "%s\n%s\n", info.Name, info.Enode)
+	var names []string
+	for name := range info.Protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(c.out, "  %s\n", name)
+	}
+	return nil
+}
+
+func (c *console) peers(args []string) error {
+	var peers []*p2p.PeerInfo
+	if err := c.client.Call(&peers, "admin_peers"); err != nil {
+		return err
+	}
+	if len(peers) == 0 {
+		fmt.Fprintln(c.out, "no peers")
+	}
+	for _, peer := range peers {
+		fmt.Fprintf(c.out, "  %s %s %s %v\n", peer.ID[:16], peer.Network.RemoteAddress, peer.Name, peer.Caps)
+	}
+	return nil
+}
+
+func (c *console) addPeer(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: addpeer <enode>")
+	}
+	return c.client.Call(nil, "admin_addPeer", args[0])
+}
+
+func (c *console) removePeer(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: removepeer <enode>")
+	}
+	return c.client.Call(nil, "admin_removePeer", args[0])
+}
+
+func (c *console) kademlia(args []string) error {
+	var s string
+	if err := c.client.Call(&s, "hive_string"); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, s)
+	return nil
+}
+
+func (c *console) pssAddr(args []string) error {
+	var addr hexutil.Bytes
+	if err := c.client.Call(&addr, "pss_baseAddr"); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, addr)
+	return nil
+}
+
+func (c *console) pssPubkey(args []string) error {
+	var pubkey hexutil.Bytes
+	if err := c.client.Call(&pubkey, "pss_getPublicKey"); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, pubkey)
+	return nil
+}
+
+// a topic given in hex is taken as it is, anything else is made into one by the node
+func (c *console) topic(s string) (string, error) {
+	if strings.HasPrefix(s, "0x") && len(s) == 2+2*len(pss.Topic{}) {
+		return s, nil
+	}
+	var topic string
+	err := c.client.Call(&topic, "pss_stringToTopic", s)
+	return topic, err
+}
+
+func (c *console) pssTopic(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: pss topic <string>")
+	}
+	topic, err := c.topic(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, topic)
+	return nil
+}
+
+func (c *console) pssPeer(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("usage: pss peer <pubkey> <topic> [addr]")
+	}
+	topic, err := c.topic(args[1])
+	if err != nil {
+		return err
+	}
+	addr := "0x"
+	if len(args) == 3 {
+		addr = args[2]
+	}
+	return c.client.Call(nil, "pss_setPeerPublicKey", args[0], topic, addr)
+}
+
+// the text is sent in an envelope, as the examples send their messages
+func (c *console) pssSend(args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("usage: pss send <pubkey> <topic> <text>")
+	}
+	topic, err := c.topic(args[1])
+	if err != nil {
+		return err
+	}
+	msg, err := envelope.Wrap(envelope.Raw, strings.Join(args[2:], " "))
+	if err != nil {
+		return err
+	}
+	return c.client.Call(nil, "pss_sendAsym", args[0], topic, common.ToHex(msg))
+}
+
+func (c *console) pssReceive(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: pss receive <topic>")
+	}
+	topic, err := c.topic(args[0])
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subs[topic] != nil {
+		return fmt.Errorf("already receiving on %s", topic)
+	}
+	msgC := make(chan pss.APIMsg)
+	sub, err := c.client.Subscribe(context.Background(), "pss", msgC, "receive", topic, false, false)
+	if err != nil {
+		return err
+	}
+	c.subs[topic] = sub
+	go func() {
+		for {
+			select {
+			case msg := <-msgC:
+				var text string
+				if err := envelope.Unwrap(msg.Msg, &text); err != nil {
+					text = msg.Msg.String()
+				}
+				fmt.Fprintf(c.out, "[%s] from %s: %s\n", topic, msg.Key, text)
+			case err := <-sub.Err():
+				if err != nil {
+					fmt.Fprintf(c.out, "[%s] receive ended: %v\n", topic, err)
+				}
+				return
+			}
+		}
+	}()
+	fmt.Fprintf(c.out, "receiving on %s\n", topic)
+	return nil
+}
+
+func (c *console) pssStop(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: pss stop <topic>")
+	}
+	topic, err := c.topic(args[0])
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sub := c.subs[topic]
+	if sub == nil {
+		return fmt.Errorf("not receiving on %s", topic)
+	}
+	sub.Unsubscribe()
+	delete(c.subs, topic)
+	return nil
+}
+
+func (c *console) demoDifficulty(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: demo difficulty <min> <max>")
+	}
+	min, err := strconv.ParseUint(args[0], 10, 8)
+	if err != nil {
+		return err
+	}
+	max, err := strconv.ParseUint(args[1], 10, 8)
+	if err != nil {
+		return err
+	}
+	return c.client.Call(nil, "demo_setDifficulty", min, max)
+}
+
+func (c *console) demoSubmit(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("usage: demo submit <data> <difficulty> [timeout]")
+	}
+	data := []byte(args[0])
+	if b, err := hexutil.Decode(args[0]); err == nil {
+		data = b
+	}
+	difficulty, err := strconv.ParseUint(args[1], 10, 8)
+	if err != nil {
+		return err
+	}
+	params := []interface{}{hexutil.Bytes(data), difficulty}
+	if len(args) == 3 {
+		timeout, err := strconv.ParseUint(args[2], 10, 32)
+		if err != nil {
+			return err
+		}
+		params = append(params, timeout)
+	}
+	var job interface{}
+	if err := c.client.Call(&job, "demo_submitJob", params...); err != nil {
+		return err
+	}
+	return c.print(job)
+}
+
+func (c *console) demoJob(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: demo job <id>")
+	}
+	var job interface{}
+	if err := c.client.Call(&job, "demo_jobStatus", args[0]); err != nil {
+		return err
+	}
+	return c.print(job)
+}
+
+func (c *console) demoJobs(args []string) error {
+	var jobs interface{}
+	if err := c.client.Call(&jobs, "demo_listJobs", struct{}{}); err != nil {
+		return err
+	}
+	return c.print(jobs)
+}
+
+func (c *console) demoCancel(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: demo cancel <id>")
+	}
+	return c.client.Call(nil, "demo_cancelJob", args[0])
+}
+
+func (c *console) demoSkills(args []string) error {
+	var skills interface{}
+	if err := c.client.Call(&skills, "demo_peerSkills"); err != nil {
+		return err
+	}
+	return c.print(skills)
+}
+
+// the topics given, in hex or as strings
+func (c *console) topics(args []string) ([]pss.Topic, error) {
+	var topics []pss.Topic
+	for _, arg := range args {
+		s, err := c.topic(arg)
+		if err != nil {
+			return nil, err
+		}
+		var t pss.Topic
+		if err := t.UnmarshalJSON([]byte(`"` + s + `"`)); err != nil {
+			return nil, err
+		}
+		topics = append(topics, t)
+	}
+	return topics, nil
+}
+
+// the card of the node, with the name and topics given
+func (c *console) card(args []string) (*contact.Card, error) {
+	var name string
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	topics, err := c.topics(args)
+	if err != nil {
+		return nil, err
+	}
+	return contact.FromNode(c.client, name, topics...)
+}
+
+func (c *console) cardShow(args []string) error {
+	card, err := c.card(args)
+	if err != nil {
+		return err
+	}
+	s, err := card.Encode()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, s)
+	return contact.WriteQR(c.out, s)
+}
+
+func (c *console) cardVCard(args []string) error {
+	card, err := c.card(args)
+	if err != nil {
+		return err
+	}
+	s, err := card.VCard()
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(c.out, s)
+	return contact.WriteQR(c.out, s)
+}
+
+// the card is given as it is, or as the file it's in, which is how a vcard, on many lines, is given
+func (c *console) cardImport(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: card import <card or file> [topic...]")
+	}
+	s := args[0]
+	if b, err := ioutil.ReadFile(s); err == nil {
+		s = string(b)
+	}
+	card, err := contact.Decode(s)
+	if err != nil {
+		return err
+	}
+	topics, err := c.topics(args[1:])
+	if err != nil {
+		return err
+	}
+	if err := contact.Import(c.client, card, topics...); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "added %s\n", card.Enode)
+	return nil
+}
+
+func (c *console) call(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: call <method> [args]")
+	}
+	var params []interface{}
+	for _, arg := range args[1:] {
+		var v interface{}
+		if err := json.Unmarshal([]byte(arg), &v); err != nil {
+			v = arg
+		}
+		params = append(params, v)
+	}
+	var result interface{}
+	if err := c.client.Call(&result, args[0], params...); err != nil {
+		return err
+	}
+	return c.print(result)
+}
+
+// the ipc socket of the node, from its path or the p2p port of the node
+func ipcPath(arg string) (string, error) {
+	port, err := strconv.Atoi(arg)
+	if err != nil {
+		return arg, nil
+	}
+	// the examples put the data directory in the temp dir when the path of the socket would be too long
+	dir := fmt.Sprintf("%s%d", datadirPrefix, port)
+	// on windows it's a named pipe, named after the data directory, see common.IPCPath
+	if runtime.GOOS == "windows" {
+		return `\\.\pipe\` + dir + "-" + ipcName, nil
+	}
+	for _, path := range []string{filepath.Join(*datadir, dir, ipcName), filepath.Join(os.TempDir(), dir, ipcName)} {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no node running on port %d here", port)
+}
+
+// reads commands until exit or the end of the input, with line editing and completion on a terminal
+func run(c *console) error {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() && c.exec(scanner.Text()) {
+		}
+		return scanner.Err()
+	}
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer terminal.Restore(fd, state)
+	term := terminal.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, prompt)
+	term.AutoCompleteCallback = c.complete
+	// the messages printed as they arrive go through the terminal too, so they don't break the line being edited
+	c.out = term
+	for {
+		line, err := term.ReadLine()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !c.exec(line) {
+			return nil
+		}
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-datadir dir] <ipc path or p2p port>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path, err := ipcPath(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	client, err := rpc.Dial(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "attach %s fail: %v\n", path, err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	c := &console{
+		client: client,
+		out:    os.Stdout,
+		subs:   make(map[string]*rpc.ClientSubscription),
+	}
+	if _, err := c.fetchModules(); err != nil {
+		fmt.Fprintf(os.Stderr, "node not answering: %v\n", err)
+		os.Exit(1)
+	}
+
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Printf("attached to %s, tab completes, help lists the commands\n", path)
+	}
+	if err := run(c); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,449 @@
+// measures what revealing less of the recipient's address costs in pss
+//
+// pss can send a message with only the first bytes of the recipient's overlay address, or with none at all
+// the less we reveal, the more nodes could be the recipient, so the better the recipient is hidden
+// but the message must then be sent on to all of those nodes, since any of them could be the one
+//
+// this tool sends the same message over a simulated network with 0, 1, 2 ... 32 bytes of the address revealed,
+// and writes a line of CSV for each of these "luminosity" levels, with:
+//
+//	the share of messages that were delivered
+//	the hops from sender to recipient, on the shortest of the paths the message actually took
+//	the number of times the message was sent between nodes in total
+//	the number of nodes matching the revealed address; the anonymity set of the recipient
+//
+// usage, from the directory with the examples:
+//
+//	go run cmd/darkness/main.go -n 32 -m 10 -o darkness.csv
+//	go run cmd/darkness/main.go -n 64 -levels 0,1,2,4,32
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/simulation"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/ethereum/go-ethereum/swarm/state"
+)
+
+const (
+	// the full length of an overlay address
+	addressLength = 32
+
+	// the protocol and message code of pss messages between peers
+	pssProtocolName = "pss"
+	pssMsgCode      = 0
+)
+
+var (
+	nodeCount     = flag.Int("n", 32, "number of nodes in the simulated network")
+	msgCount      = flag.Int("m", 10, "messages to send for each luminosity level")
+	levelsFlag    = flag.String("levels", "", "luminosity levels to measure, in bytes of the address, comma separated (default 0 to 32)")
+	outfile       = flag.String("o", "", "file to write the CSV to (default stdout)")
+	msgTimeout    = flag.Duration("timeout", time.Second*2, "how long to wait for a message to be delivered")
+	settle        = flag.Duration("settle", time.Millisecond*300, "how long to keep counting forwards after a message is delivered")
+	healthTimeout = flag.Duration("health", time.Second*30, "how long to wait for the network to be ready")
+	seed          = flag.Int64("seed", 0, "seed for picking senders and recipients (default time based)")
+	verbose       = flag.Bool("v", false, "more verbose logs")
+
+	topic = pss.BytesToTopic([]byte("darkness"))
+)
+
+// what we keep for each node in the simulation
+type simNode struct {
+	id   enode.ID
+	ps   *pss.Pss
+	addr []byte
+	key  *ecdsa.PrivateKey
+}
+
+// the messages delivered to the recipients' handlers
+type delivery struct {
+	id      enode.ID
+	payload string
+}
+
+// the results of one luminosity level
+type levelResult struct {
+	level         int
+	sent          int
+	delivered     int
+	hops          []int
+	transmissions []int
+	latency       []time.Duration
+	anonymity     []int
+}
+
+// collects the pss messages sent between the nodes, while a message is underway
+// messages are sent one at a time, so all the forwarding we see in that time belongs to it
+type forwardCollector struct {
+	mu         sync.Mutex
+	collecting bool
+	edges      map[enode.ID][]enode.ID
+	count      int
+}
+
+func (c *forwardCollector) start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.collecting = true
+	c.edges = make(map[enode.ID][]enode.ID)
+	c.count = 0
+}
+
+// stops collecting, and returns the hops from sender to recipient and the total messages sent
+// the hops are -1 if the message never reached the recipient
+func (c *forwardCollector) stop(from enode.ID, to enode.ID) (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.collecting = false
+
+	// breadth first from the sender, over the connections the message went through
+	dist := map[enode.ID]int{from: 0}
+	queue := []enode.ID{from}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if cur == to {
+			return dist[cur], c.count
+		}
+		for _, next := range c.edges[cur] {
+			if _, ok := dist[next]; !ok {
+				dist[next] = dist[cur] + 1
+				queue = append(queue, next)
+			}
+		}
+	}
+	return -1, c.count
+}
+
+func (c *forwardCollector) run(events chan *simulations.Event) {
+	for ev := range events {
+		if ev.Type != simulations.EventTypeMsg || ev.Control {
+			continue
+		}
+		msg := ev.Msg
+		if msg.Received || msg.Protocol != pssProtocolName || msg.Code != pssMsgCode {
+			continue
+		}
+		c.mu.Lock()
+		if c.collecting {
+			c.edges[msg.One] = append(c.edges[msg.One], msg.Other)
+			c.count++
+		}
+		c.mu.Unlock()
+	}
+}
+
+// the bzz and pss services of the simulated nodes
+// pss routes through bzz' kademlia, so they share it
+func newServices(deliveryC chan delivery) (map[string]simulation.ServiceFunc, func(enode.ID) *simNode) {
+	var mu sync.Mutex
+	kademlias := make(map[enode.ID]*network.Kademlia)
+	nodes := make(map[enode.ID]*simNode)
+	kademlia := func(id enode.ID) *network.Kademlia {
+		mu.Lock()
+		defer mu.Unlock()
+		if k, ok := kademlias[id]; ok {
+			return k
+		}
+		kademlias[id] = network.NewKademlia(id[:], network.NewKadParams())
+		return kademlias[id]
+	}
+	getNode := func(id enode.ID) *simNode {
+		mu.Lock()
+		defer mu.Unlock()
+		return nodes[id]
+	}
+
+	return map[string]simulation.ServiceFunc{
+		"bzz": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			addr := network.NewAddr(ctx.Config.Node())
+			kad := kademlia(ctx.Config.ID)
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+			config := &network.BzzConfig{
+				OverlayAddr:  addr.Over(),
+				UnderlayAddr: addr.Under(),
+				HiveParams:   network.NewHiveParams(),
+			}
+			return network.NewBzz(config, kad, state.NewInmemoryStore(), nil, nil), nil, nil
+		},
+		"pss": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			key, err := crypto.GenerateKey()
+			if err != nil {
+				return nil, nil, err
+			}
+			params := pss.NewPssParams().WithPrivateKey(key)
+
+			// undelivered messages are retried until they expire
+			// they shouldn't still be around when we measure the next one
+			params.MsgTTL = *msgTimeout
+			kad := kademlia(ctx.Config.ID)
+			ps, err := pss.NewPss(kad, params)
+			if err != nil {
+				return nil, nil, err
+			}
+			id := ctx.Config.ID
+			ps.Register(&topic, pss.NewHandler(func(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+				deliveryC <- delivery{id: id, payload: string(msg)}
+				return nil
+			}))
+			mu.Lock()
+			nodes[id] = &simNode{
+				id:   id,
+				ps:   ps,
+				addr: kad.BaseAddr(),
+				key:  key,
+			}
+			mu.Unlock()
+			return ps, nil, nil
+		},
+	}, getNode
+}
+
+func parseLevels(s string) ([]int, error) {
+	var levels []int
+	if s == "" {
+		for i := 0; i <= addressLength; i++ {
+			levels = append(levels, i)
+		}
+		return levels, nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		l, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || l < 0 || l > addressLength {
+			return nil, fmt.Errorf("invalid level '%s', must be 0 to %d", f, addressLength)
+		}
+		levels = append(levels, l)
+	}
+	return levels, nil
+}
+
+// sends one message from sender to recipient, revealing level bytes of the recipient's address
+func sendOne(sender *simNode, recipient *simNode, level int, seq int, collector *forwardCollector, deliveryC chan delivery) (bool, int, int, time.Duration, error) {
+	partial := pss.PssAddress(recipient.addr[:level])
+	err := sender.ps.SetPeerPublicKey(&recipient.key.PublicKey, topic, &partial)
+	if err != nil {
+		return false, 0, 0, 0, err
+	}
+	payload := fmt.Sprintf("darkness %d", seq)
+
+	collector.start()
+	started := time.Now()
+	err = sender.ps.SendAsym(common.ToHex(crypto.FromECDSAPub(&recipient.key.PublicKey)), topic, []byte(payload))
+	if err != nil {
+		collector.stop(sender.id, recipient.id)
+		return false, 0, 0, 0, err
+	}
+
+	// only the recipient can decrypt the message, so that's the only delivery we can get
+	// deliveries of earlier messages that arrive late are skipped
+	var delivered bool
+	var latency time.Duration
+	timeout := time.NewTimer(*msgTimeout)
+	defer timeout.Stop()
+	for !delivered {
+		select {
+		case d := <-deliveryC:
+			if d.id == recipient.id && d.payload == payload {
+				delivered = true
+				latency = time.Since(started)
+			}
+		case <-timeout.C:
+			hops, transmissions := collector.stop(sender.id, recipient.id)
+			return false, hops, transmissions, 0, nil
+		}
+	}
+
+	// the message may still be on its way to other nodes matching the address
+	time.Sleep(*settle)
+	hops, transmissions := collector.stop(sender.id, recipient.id)
+	return true, hops, transmissions, latency, nil
+}
+
+func average(values []int) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0
+	for _, v := range values {
+		sum += v
+	}
+	return float64(sum) / float64(len(values))
+}
+
+func minmax(values []int) (int, int) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	min, max := values[0], values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	return min, max
+}
+
+func writeCSV(w io.Writer, results []*levelResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"luminosity", "sent", "delivered", "delivery_ratio", "hops_avg", "hops_min", "hops_max", "transmissions_avg", "latency_avg_ms", "anonymity_avg"})
+	for _, r := range results {
+		hopsmin, hopsmax := minmax(r.hops)
+		var latency time.Duration
+		for _, l := range r.latency {
+			latency += l
+		}
+		if len(r.latency) > 0 {
+			latency /= time.Duration(len(r.latency))
+		}
+		cw.Write([]string{
+			strconv.Itoa(r.level),
+			strconv.Itoa(r.sent),
+			strconv.Itoa(r.delivered),
+			strconv.FormatFloat(float64(r.delivered)/float64(r.sent), 'f', 3, 64),
+			strconv.FormatFloat(average(r.hops), 'f', 2, 64),
+			strconv.Itoa(hopsmin),
+			strconv.Itoa(hopsmax),
+			strconv.FormatFloat(average(r.transmissions), 'f', 2, 64),
+			strconv.FormatFloat(float64(latency)/float64(time.Millisecond), 'f', 1, 64),
+			strconv.FormatFloat(average(r.anonymity), 'f', 2, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func main() {
+	flag.Parse()
+
+	// the simulated nodes warn about every handshake that is cut short by another, which is just noise here
+	loglevel := log.LvlError
+	if *verbose {
+		loglevel = log.LvlDebug
+	}
+	log.Root().SetHandler(log.LvlFilterHandler(loglevel, log.StreamHandler(os.Stderr, log.TerminalFormat(true))))
+
+	levels, err := parseLevels(*levelsFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *nodeCount < 2 {
+		fmt.Fprintln(os.Stderr, "need at least two nodes")
+		os.Exit(1)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	rnd := rand.New(rand.NewSource(*seed))
+
+	// the network starts as a ring, and the nodes find more peers through the hive
+	deliveryC := make(chan delivery, 1024)
+	services, getNode := newServices(deliveryC)
+	sim := simulation.New(services)
+	defer sim.Close()
+	ids, err := sim.AddNodesAndConnectRing(*nodeCount)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create network fail: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "started %d nodes, waiting for the network to be ready\n", len(ids))
+	ctx, cancel := context.WithTimeout(context.Background(), *healthTimeout)
+	ill, err := sim.WaitTillHealthy(ctx, network.NewKadParams().MinProxBinSize)
+	cancel()
+	if err != nil {
+		// the results are still meaningful, but routing is worse than it could be
+		fmt.Fprintf(os.Stderr, "warning: %d of %d nodes don't have a healthy kademlia yet\n", len(ill), len(ids))
+	}
+
+	var nodes []*simNode
+	for _, id := range ids {
+		nodes = append(nodes, getNode(id))
+	}
+
+	events := make(chan *simulations.Event, 1024)
+	sub := sim.Net.Events().Subscribe(events)
+	defer sub.Unsubscribe()
+	collector := &forwardCollector{}
+	go collector.run(events)
+
+	var results []*levelResult
+	seq := 0
+	for _, level := range levels {
+		result := &levelResult{level: level}
+		for i := 0; i < *msgCount; i++ {
+			sender := nodes[rnd.Intn(len(nodes))]
+			recipient := sender
+			for recipient == sender {
+				recipient = nodes[rnd.Intn(len(nodes))]
+			}
+			seq++
+			delivered, hops, transmissions, latency, err := sendOne(sender, recipient, level, seq, collector, deliveryC)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "send fail: %v\n", err)
+				os.Exit(1)
+			}
+
+			anonymity := 0
+			for _, n := range nodes {
+				if bytes.HasPrefix(n.addr, recipient.addr[:level]) {
+					anonymity++
+				}
+			}
+			result.sent++
+			result.transmissions = append(result.transmissions, transmissions)
+			result.anonymity = append(result.anonymity, anonymity)
+			if delivered {
+				result.delivered++
+				result.latency = append(result.latency, latency)
+				if hops >= 0 {
+					result.hops = append(result.hops, hops)
+				}
+			}
+			log.Debug("message", "level", level, "seq", seq, "delivered", delivered, "hops", hops, "transmissions", transmissions)
+		}
+		fmt.Fprintf(os.Stderr, "luminosity %d: %d of %d delivered\n", level, result.delivered, result.sent)
+		results = append(results, result)
+	}
+
+	out := io.Writer(os.Stdout)
+	if *outfile != "" {
+		f, err := os.Create(*outfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "create %s fail: %v\n", *outfile, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+	err = writeCSV(out, results)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "write csv fail: %v\n", err)
+		os.Exit(1)
+	}
+}
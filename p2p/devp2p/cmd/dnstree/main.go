@@ -0,0 +1,273 @@
+// builds and signs a dns node list (EIP-1459), and serves it
+//
+// the nodes come from a file of node records (the enr: lines cmd/bootnode prints),
+// and/or from the output directory of cmd/composegen or cmd/testnet, which have the keys of the nodes they made
+// a node record must be signed by the node's own key, so enode urls alone are not enough
+//
+// the tree is signed with the key in -k, and the enrtree:// url to find it with is printed
+// it can be written as a zone file to publish in real dns, or be served directly with -serve
+//
+// usage, from the directory with the examples:
+//
+//	go run cmd/dnstree/main.go -domain nodes.example.org -nodes bootnode.enr -keys compose -zone nodes.zone
+//	go run cmd/dnstree/main.go -domain nodes.example.org -nodes bootnode.enr -serve 127.0.0.1:5353
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+
+	"../../dnsdisc"
+)
+
+const (
+	// how long resolvers may cache the records, in seconds
+	zoneTTL = 60
+)
+
+var (
+	domain    = flag.String("domain", "", "domain the tree is published under")
+	keyfile   = flag.String("k", "dnstree.key", "file with the hex encoded signing key, generated if it doesn't exist")
+	nodesfile = flag.String("nodes", "", "file with node records, one enr: per line")
+	keysdir   = flag.String("keys", "", "output directory of composegen or testnet, to make records for the nodes in it")
+	links     = flag.String("links", "", "enrtree:// urls of other trees to link to, comma separated")
+	seq       = flag.Uint("seq", uint(time.Now().Unix()), "sequence number of the tree, must increase every time it is published")
+	zonefile  = flag.String("zone", "", "file to write the tree to as a dns zone")
+	serveaddr = flag.String("serve", "", "udp address to serve the tree on, e.g. 127.0.0.1:5353")
+)
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func loadKey(path string) (*ecdsa.PrivateKey, error) {
+	key, err := crypto.LoadECDSA(path)
+	if err == nil {
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err = crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "generated new signing key in %s\n", path)
+	return key, crypto.SaveECDSA(path, key)
+}
+
+// reads the lines of a file, skipping empty ones and comments
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func readRecords(path string) ([]*enode.Node, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*enode.Node
+	for _, line := range lines {
+		n, err := dnsdisc.ParseENR(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// makes signed records for the nodes in a composegen or testnet directory
+//
+// composegen puts an enode file next to each nodekey, testnet puts all the enodes in one enodes file
+// so we collect all the keys and all the enodes, and match them up by node id
+func recordsFromKeys(dir string) ([]*enode.Node, error) {
+	keys := make(map[enode.ID]*ecdsa.PrivateKey)
+	var urls []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		switch info.Name() {
+		case "nodekey":
+			key, err := crypto.LoadECDSA(path)
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			keys[enode.PubkeyToIDV4(&key.PublicKey)] = key
+		case "enode", "enodes":
+			lines, err := readLines(path)
+			if err != nil {
+				return err
+			}
+			urls = append(urls, lines...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*enode.Node
+	for _, url := range urls {
+		n, err := enode.ParseV4(url)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", url, err)
+		}
+		key, ok := keys[n.ID()]
+		if !ok {
+			return nil, fmt.Errorf("no key for %s", url)
+		}
+		var r enr.Record
+		r.Set(enr.IP(n.IP()))
+		r.Set(enr.TCP(n.TCP()))
+		r.Set(enr.UDP(n.UDP()))
+		err = enode.SignV4(&r, key)
+		if err != nil {
+			return nil, err
+		}
+		signed, err := enode.New(enode.ValidSchemes, &r)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, signed)
+	}
+	return nodes, nil
+}
+
+// writes the records in zone file format, with the names relative to the domain
+// text longer than 255 bytes has to be split in several strings
+func writeZone(w io.Writer, domain string, records map[string]string) error {
+	var names []string
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	_, err := fmt.Fprintf(w, "$ORIGIN %s.\n$TTL %d\n", domain, zoneTTL)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		txt := records[name]
+		var parts []string
+		for len(txt) > 0 {
+			n := len(txt)
+			if n > 255 {
+				n = 255
+			}
+			parts = append(parts, `"`+txt[:n]+`"`)
+			txt = txt[n:]
+		}
+		rel := strings.TrimSuffix(strings.TrimSuffix(name, domain), ".")
+		if rel == "" {
+			rel = "@"
+		}
+		_, err = fmt.Fprintf(w, "%s\tIN\tTXT\t%s\n", rel, strings.Join(parts, " "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	if *domain == "" || (*nodesfile == "" && *keysdir == "") {
+		fmt.Fprintln(os.Stderr, "need a domain (-domain), and nodes (-nodes and/or -keys)")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var nodes []*enode.Node
+	if *nodesfile != "" {
+		records, err := readRecords(*nodesfile)
+		if err != nil {
+			fatal("nodes: %v", err)
+		}
+		nodes = append(nodes, records...)
+	}
+	if *keysdir != "" {
+		records, err := recordsFromKeys(*keysdir)
+		if err != nil {
+			fatal("keys: %v", err)
+		}
+		nodes = append(nodes, records...)
+	}
+	var linkurls []string
+	for _, l := range strings.Split(*links, ",") {
+		if l != "" {
+			linkurls = append(linkurls, l)
+		}
+	}
+
+	key, err := loadKey(*keyfile)
+	if err != nil {
+		fatal("signing key: %v", err)
+	}
+	tree, err := dnsdisc.MakeTree(*seq, nodes, linkurls)
+	if err != nil {
+		fatal("make tree: %v", err)
+	}
+	url, err := tree.Sign(key, *domain)
+	if err != nil {
+		fatal("sign tree: %v", err)
+	}
+	records := tree.ToTXT(*domain)
+	fmt.Fprintf(os.Stderr, "tree with %d nodes and %d links, %d records, seq %d\n", len(nodes), len(linkurls), len(records), tree.Seq())
+	fmt.Println(url)
+
+	if *zonefile != "" {
+		f, err := os.Create(*zonefile)
+		if err == nil {
+			err = writeZone(f, *domain, records)
+			f.Close()
+		}
+		if err != nil {
+			fatal("write zone: %v", err)
+		}
+	}
+
+	if *serveaddr == "" {
+		return
+	}
+	srv := dnsdisc.NewServer(records)
+	err = srv.Listen(*serveaddr)
+	if err != nil {
+		fatal("serve: %v", err)
+	}
+	defer srv.Close()
+	fmt.Fprintf(os.Stderr, "serving on %s\n", srv.Addr())
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+	<-sigC
+}
@@ -0,0 +1,134 @@
+// sends a stream of pss messages over a simulated network, with nodes going down and coming back, and reports how many arrived
+//
+// the report has the delivery rate, the duplicate rate and the latency distribution
+// with expectations given, the exit code tells whether they were met, so it can be used in scripts
+//
+// usage, from the directory with the examples:
+//
+//	go run cmd/pssload/main.go -n 50 -m 2000 -churn 1s
+//	go run cmd/pssload/main.go -n 64 -m 5000 -rate 50 -min-delivery 0.95 -max-latency 500ms
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	"../../pssharness"
+)
+
+var (
+	defaults = pssharness.DefaultConfig()
+
+	nodeCount     = flag.Int("n", defaults.Nodes, "number of nodes in the simulated network")
+	msgCount      = flag.Int("m", defaults.Messages, "messages to send")
+	rate          = flag.Int("rate", defaults.Rate, "messages sent per second")
+	luminosity    = flag.Int("luminosity", defaults.Luminosity, "bytes of the recipient's address revealed in the messages")
+	msgTimeout    = flag.Duration("timeout", defaults.Timeout, "how long to wait for messages still underway when all are sent")
+	churn         = flag.Duration("churn", 0, "how often a node goes down or comes back (default no churn)")
+	churnMax      = flag.Int("churn-max", defaults.ChurnMax, "most nodes down at the same time")
+	healthTimeout = flag.Duration("health", defaults.HealthTimeout, "how long to wait for the network to be ready")
+	seed          = flag.Int64("seed", 0, "seed for picking nodes (default time based)")
+	minDelivery   = flag.Float64("min-delivery", 0, "fail if less than this share of the messages is delivered")
+	maxDuplicate  = flag.Float64("max-duplicate", 0, "fail if there are more duplicates than this per delivered message")
+	maxLatency    = flag.Duration("max-latency", 0, "fail if the latency percentile given by -percentile is above this")
+	percentile    = flag.Float64("percentile", 0.99, "the latency percentile -max-latency applies to")
+	buckets       = flag.Int("buckets", 10, "number of bars in the latency histogram")
+	verbose       = flag.Bool("v", false, "more verbose logs")
+)
+
+// prints the latencies as a histogram, with equal width buckets from the fastest to the slowest
+func printHistogram(latencies []time.Duration, n int) {
+	if len(latencies) == 0 || n < 1 {
+		return
+	}
+	min, max := latencies[0], latencies[len(latencies)-1]
+	width := (max - min) / time.Duration(n)
+	if width == 0 {
+		width = 1
+	}
+	counts := make([]int, n)
+	for _, l := range latencies {
+		i := int((l - min) / width)
+		if i >= n {
+			i = n - 1
+		}
+		counts[i]++
+	}
+	most := 0
+	for _, c := range counts {
+		if c > most {
+			most = c
+		}
+	}
+	for i, c := range counts {
+		bar := make([]byte, c*50/most)
+		for j := range bar {
+			bar[j] = '#'
+		}
+		from := min + width*time.Duration(i)
+		fmt.Printf("%12v %6d %s\n", from.Round(time.Microsecond), c, bar)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	// the simulated nodes warn about every handshake that is cut short by another, which is just noise here
+	loglevel := log.LvlError
+	if *verbose {
+		loglevel = log.LvlDebug
+	}
+	log.Root().SetHandler(log.LvlFilterHandler(loglevel, log.StreamHandler(os.Stderr, log.TerminalFormat(true))))
+
+	if *rate < 1 {
+		fmt.Fprintln(os.Stderr, "rate must be at least one message per second")
+		os.Exit(1)
+	}
+	cfg := pssharness.Config{
+		Nodes:         *nodeCount,
+		Messages:      *msgCount,
+		Rate:          *rate,
+		Luminosity:    *luminosity,
+		Timeout:       *msgTimeout,
+		ChurnInterval: *churn,
+		ChurnMax:      *churnMax,
+		HealthTimeout: *healthTimeout,
+		Seed:          *seed,
+	}
+
+	// interrupting stops the sending, the run is then reported as failed
+	ctx, cancel := context.WithCancel(context.Background())
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, os.Interrupt)
+	go func() {
+		<-sigC
+		cancel()
+	}()
+
+	fmt.Fprintf(os.Stderr, "sending %d messages over %d nodes, this takes at least %v\n", cfg.Messages, cfg.Nodes, time.Duration(cfg.Messages)*time.Second/time.Duration(cfg.Rate)+cfg.Timeout)
+	report, err := pssharness.Run(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "run fail: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(report)
+	fmt.Println()
+	printHistogram(report.Latencies, *buckets)
+
+	err = report.Check(pssharness.Expectation{
+		MinDeliveryRate:   *minDelivery,
+		MaxDuplicateRate:  *maxDuplicate,
+		MaxLatency:        *maxLatency,
+		LatencyPercentile: *percentile,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "expectations not met: %v\n", err)
+		os.Exit(1)
+	}
+}
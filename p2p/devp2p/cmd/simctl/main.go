@@ -0,0 +1,271 @@
+// drives a simulated network running in another process, through the http api of p2p/simulations only
+//
+// it makes the nodes, starts them and connects them in a ring, and follows the event stream of the server
+// to see the nodes come up, the connections made and the messages sent between the nodes
+// then it asks each node over rpc, which the server passes on over a websocket, what it got,
+// and stops the nodes again, unless they should be kept running for another controller to look at
+//
+// the server can be cmd/simserver, or any program serving simulations.NewServer; the messages counted
+// are those of the protocol given with -proto, the "ping" protocol of cmd/simserver by default
+//
+// usage, from the directory with the examples:
+//
+//	go run cmd/simserver/main.go -addr localhost:8888 &
+//	go run cmd/simctl/main.go -url http://localhost:8888 -n 5 -watch 5s
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+var (
+	serverURL   = flag.String("url", "http://localhost:8888", "url of the simulation server")
+	nodeCount   = flag.Int("n", 5, "number of nodes to make")
+	service     = flag.String("service", "", "service the nodes run (default the default service of the server)")
+	proto       = flag.String("proto", "ping", "protocol of the messages to count")
+	statsCall   = flag.String("stats", "ping_stats", "rpc method to call on each node at the end, none if empty")
+	watch       = flag.Duration("watch", time.Second*3, "how long to watch the messages once the nodes are connected")
+	waitTimeout = flag.Duration("timeout", time.Second*10, "how long to wait for the nodes to come up and connect")
+	keep        = flag.Bool("keep", false, "leave the nodes running at the end")
+	verbose     = flag.Bool("v", false, "print every message event")
+)
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// what the event stream told us of the network
+type view struct {
+	mu    sync.Mutex
+	names map[enode.ID]string
+	up    map[enode.ID]bool
+	conns map[string]bool
+	// the messages received, by message code
+	msgs map[uint64]int
+}
+
+func newView() *view {
+	return &view{
+		names: make(map[enode.ID]string),
+		up:    make(map[enode.ID]bool),
+		conns: make(map[string]bool),
+		msgs:  make(map[uint64]int),
+	}
+}
+
+func (v *view) name(id enode.ID) string {
+	if name, ok := v.names[id]; ok {
+		return name
+	}
+	return id.TerminalString()
+}
+
+// prints the events as they come, and keeps track of the nodes, the connections and the messages
+func (v *view) follow(events chan *simulations.Event) {
+	for e := range events {
+		v.mu.Lock()
+		switch e.Type {
+		case simulations.EventTypeNode:
+			id := e.Node.Config.ID
+			_, seen := v.names[id]
+			was := v.up[id]
+			v.names[id] = e.Node.Config.Name
+			v.up[id] = e.Node.Up
+			switch {
+			case !seen:
+				fmt.Printf("%s node %s added, %s\n", e.Time.Format("15:04:05.000"), v.name(id), upDown(e.Node.Up))
+			case was != e.Node.Up:
+				fmt.Printf("%s node %s %s\n", e.Time.Format("15:04:05.000"), v.name(id), upDown(e.Node.Up))
+			}
+		case simulations.EventTypeConn:
+			// a connection is told down when it is being dialed, and when a node it is to goes down; we only tell a change
+			label := simulations.ConnLabel(e.Conn.One, e.Conn.Other)
+			if v.conns[label] == e.Conn.Up {
+				break
+			}
+			v.conns[label] = e.Conn.Up
+			fmt.Printf("%s conn %s - %s %s\n", e.Time.Format("15:04:05.000"), v.name(e.Conn.One), v.name(e.Conn.Other), upDown(e.Conn.Up))
+		case simulations.EventTypeMsg:
+			// a message is seen twice, when it is sent and when it is received, we count it once
+			if !e.Msg.Received {
+				break
+			}
+			v.msgs[e.Msg.Code]++
+			if *verbose {
+				fmt.Printf("%s msg %s -> %s %s:%d\n", e.Time.Format("15:04:05.000"), v.name(e.Msg.One), v.name(e.Msg.Other), e.Msg.Protocol, e.Msg.Code)
+			}
+		}
+		v.mu.Unlock()
+	}
+}
+
+func upDown(up bool) string {
+	if up {
+		return "up"
+	}
+	return "down"
+}
+
+// waits until what the stream told us meets the condition
+func (v *view) waitFor(what string, cond func(v *view) bool) {
+	deadline := time.Now().Add(*waitTimeout)
+	for time.Now().Before(deadline) {
+		v.mu.Lock()
+		ok := cond(v)
+		v.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	fatal("timed out waiting for %s", what)
+}
+
+func main() {
+	flag.Parse()
+	if *nodeCount < 2 {
+		fatal("need at least two nodes")
+	}
+
+	client := simulations.NewClient(*serverURL)
+	network, err := client.GetNetwork()
+	if err != nil {
+		fatal("get network: %v", err)
+	}
+	fmt.Printf("server at %s has %d nodes and %d connections\n", *serverURL, len(network.Nodes), len(network.Conns))
+
+	// the stream starts with the nodes and connections there are already, then tells what changes
+	events := make(chan *simulations.Event, 1024)
+	sub, err := client.SubscribeNetwork(events, simulations.SubscribeOpts{
+		Current: true,
+		Filter:  *proto + ":*",
+	})
+	if err != nil {
+		fatal("subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+	v := newView()
+	go v.follow(events)
+
+	// the names go on from those of the nodes there are, since they must be unique in the network
+	var ids []string
+	for i := 0; i < *nodeCount; i++ {
+		config := adapters.RandomNodeConfig()
+		config.Name = fmt.Sprintf("node%d", len(network.Nodes)+i)
+		if *service != "" {
+			config.Services = []string{*service}
+		}
+		info, err := client.CreateNode(config)
+		if err != nil {
+			fatal("create node: %v", err)
+		}
+		ids = append(ids, info.ID)
+	}
+	for _, id := range ids {
+		if err := client.StartNode(id); err != nil {
+			fatal("start node %s: %v", id, err)
+		}
+	}
+	v.waitFor("the nodes to start", func(v *view) bool {
+		for _, id := range ids {
+			if !v.up[enode.HexID(id)] {
+				return false
+			}
+		}
+		return true
+	})
+
+	// a ring, or a line of two
+	var labels []string
+	for i := range ids {
+		next := (i + 1) % len(ids)
+		if len(ids) == 2 && i == 1 {
+			break
+		}
+		if err := client.ConnectNode(ids[i], ids[next]); err != nil {
+			fatal("connect %s to %s: %v", ids[i], ids[next], err)
+		}
+		labels = append(labels, simulations.ConnLabel(enode.HexID(ids[i]), enode.HexID(ids[next])))
+	}
+	v.waitFor("the nodes to connect", func(v *view) bool {
+		for _, label := range labels {
+			if !v.conns[label] {
+				return false
+			}
+		}
+		return true
+	})
+
+	v.mu.Lock()
+	before := make(map[uint64]int)
+	for code, count := range v.msgs {
+		before[code] = count
+	}
+	v.mu.Unlock()
+	time.Sleep(*watch)
+	v.mu.Lock()
+	var codes []uint64
+	for code := range v.msgs {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	var counts []string
+	for _, code := range codes {
+		counts = append(counts, fmt.Sprintf("code %d: %d", code, v.msgs[code]-before[code]))
+	}
+	v.mu.Unlock()
+	if len(counts) == 0 {
+		counts = append(counts, "none")
+	}
+	fmt.Printf("%s messages received in %v: %s\n", *proto, *watch, strings.Join(counts, ", "))
+
+	if *statsCall != "" {
+		for _, id := range ids {
+			ctx, cancel := context.WithTimeout(context.Background(), *waitTimeout)
+			rpcClient, err := client.RPCClient(ctx, id)
+			cancel()
+			if err != nil {
+				fatal("rpc of %s: %v", id, err)
+			}
+			var stats map[string]interface{}
+			err = rpcClient.Call(&stats, *statsCall)
+			rpcClient.Close()
+			if err != nil {
+				fatal("%s on %s: %v", *statsCall, id, err)
+			}
+			v.mu.Lock()
+			name := v.name(enode.HexID(id))
+			v.mu.Unlock()
+			fmt.Printf("%s %s: %v\n", name, *statsCall, stats)
+		}
+	}
+
+	if *keep {
+		return
+	}
+	for _, id := range ids {
+		if err := client.StopNode(id); err != nil {
+			fatal("stop node %s: %v", id, err)
+		}
+	}
+	v.waitFor("the nodes to stop", func(v *view) bool {
+		for _, id := range ids {
+			if v.up[enode.HexID(id)] {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,192 @@
+// serves a simulated network over the http api of p2p/simulations, for a controller in another process to drive
+//
+// the network starts empty: making nodes, starting them and connecting them is up to the controller, see cmd/simctl
+// the nodes run the "ping" service: every node pings each of its peers now and then, and the peers answer with a pong,
+// so there are messages to watch in the event stream. The service counts what it got, which the rpc method ping_stats returns
+//
+// usage, from the directory with the examples:
+//
+//	go run cmd/simserver/main.go -addr localhost:8888 -interval 500ms
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"../../envelope"
+)
+
+const (
+	serviceName = "ping"
+
+	pingMsgCode = 0
+	pongMsgCode = 1
+)
+
+var (
+	listenaddr = flag.String("addr", "localhost:8888", "address to serve the http api on")
+	interval   = flag.Duration("interval", time.Second, "how often a node pings each of its peers")
+	verbose    = flag.Bool("v", false, "more verbose logs")
+)
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// a ping, and the pong answering it has the same seq
+type pingMsg struct {
+	Seq uint64
+}
+
+// what a node got, returned by ping_stats
+type PingStats struct {
+	Pings uint64 `json:"pings"`
+	Pongs uint64 `json:"pongs"`
+}
+
+type pingService struct {
+	interval time.Duration
+	pings    uint64
+	pongs    uint64
+}
+
+func (self *pingService) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{
+		{
+			Name:    serviceName,
+			Version: 1,
+			Length:  2,
+			Run:     self.run,
+		},
+	}
+}
+
+func (self *pingService) run(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	// the pongs are sent by the reading subroutine, which reports here why it stopped
+	errC := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := rw.ReadMsg()
+			if err != nil {
+				errC <- err
+				return
+			}
+			var m pingMsg
+			err = envelope.DecodeMsg(msg, &m)
+			if err != nil {
+				errC <- err
+				return
+			}
+			switch msg.Code {
+			case pingMsgCode:
+				atomic.AddUint64(&self.pings, 1)
+				err = envelope.Send(rw, pongMsgCode, envelope.RLP, &m)
+			case pongMsgCode:
+				atomic.AddUint64(&self.pongs, 1)
+			}
+			if err != nil {
+				errC <- err
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(self.interval)
+	defer ticker.Stop()
+	var seq uint64
+	for {
+		select {
+		case <-ticker.C:
+			seq++
+			err := envelope.Send(rw, pingMsgCode, envelope.RLP, &pingMsg{Seq: seq})
+			if err != nil {
+				return err
+			}
+			log.Debug("sent ping", "peer", p, "seq", seq)
+		case err := <-errC:
+			return err
+		}
+	}
+}
+
+func (self *pingService) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: serviceName,
+			Version:   "1.0",
+			Service:   &PingAPI{service: self},
+			Public:    true,
+		},
+	}
+}
+
+func (self *pingService) Start(srv *p2p.Server) error {
+	return nil
+}
+
+func (self *pingService) Stop() error {
+	return nil
+}
+
+type PingAPI struct {
+	service *pingService
+}
+
+// the pings and pongs the node got from all of its peers
+func (api *PingAPI) Stats() PingStats {
+	return PingStats{
+		Pings: atomic.LoadUint64(&api.service.pings),
+		Pongs: atomic.LoadUint64(&api.service.pongs),
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	// the simulated nodes warn about every handshake that is cut short by another, which is just noise here
+	loglevel := log.LvlError
+	if *verbose {
+		loglevel = log.LvlDebug
+	}
+	log.Root().SetHandler(log.LvlFilterHandler(loglevel, log.StreamHandler(os.Stderr, log.TerminalFormat(true))))
+
+	adapter := adapters.NewSimAdapter(map[string]adapters.ServiceFunc{
+		serviceName: func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return &pingService{interval: *interval}, nil
+		},
+	})
+	net := simulations.NewNetwork(adapter, &simulations.NetworkConfig{
+		DefaultService: serviceName,
+	})
+	defer net.Shutdown()
+
+	listener := &http.Server{Addr: *listenaddr, Handler: simulations.NewServer(net)}
+	errC := make(chan error, 1)
+	go func() {
+		errC <- listener.ListenAndServe()
+	}()
+	fmt.Printf("serving the simulation api on http://%s\n", *listenaddr)
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errC:
+		fatal("serve: %v", err)
+	case <-sigC:
+		listener.Close()
+	}
+}
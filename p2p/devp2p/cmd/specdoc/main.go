@@ -0,0 +1,65 @@
+// prints the message formats of the protocols a node runs, from its spec_describe rpc method
+//
+// the default is markdown tables, -json gives the JSON Schemas instead, for generating clients from
+//
+// usage, from the directory with the examples, while E6_PssProtocol.go is running:
+//
+//	go run cmd/specdoc/main.go -rpc .data_30100/demo.ipc > protocols.md
+//	go run cmd/specdoc/main.go -rpc http://127.0.0.1:8545 -json > protocols.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"../../specdoc"
+)
+
+var (
+	endpoint = flag.String("rpc", "", "rpc endpoint of the node, an ipc path or a http or ws url")
+	asJSON   = flag.Bool("json", false, "print the JSON Schemas instead of markdown")
+	timeout  = flag.Duration("timeout", time.Second*10, "how long to wait for the node")
+)
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func main() {
+	flag.Parse()
+	if *endpoint == "" {
+		fatal("-rpc is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	client, err := rpc.DialContext(ctx, *endpoint)
+	if err != nil {
+		fatal("connect to %s failed: %v", *endpoint, err)
+	}
+	defer client.Close()
+
+	var protos []*specdoc.Protocol
+	err = client.CallContext(ctx, &protos, "spec_describe")
+	if err != nil {
+		fatal("spec_describe failed: %v", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(protos)
+	} else {
+		err = specdoc.Markdown(os.Stdout, protos...)
+	}
+	if err != nil {
+		fatal("write failed: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+// runs one node with the registered services asked for with -services, and those they need
+//
+// the example packages register their services when they are imported, see common/registry, and this
+// puts any set of them together on a node stack, so a mix of them can be tried without a main of its own:
+//
+//	go run cmd/stack/main.go -services pingpong
+//	go run cmd/stack/main.go -services chat,pingpong -l 30400 -c stack.toml
+//
+// chat needs swarm, so it is registered first. With no services it lists the ones there are, with what they need.
+// All the apis of the services are on the ipc endpoint of the node, the websocket has the namespaces named
+// after the services. The node runs until interrupted
+//
+// stacks in other processes, and any other examples, join the node's network with -network:
+//
+//	go run cmd/stack/main.go -services pingpong -network net.json
+//	go run cmd/stack/main.go -services pingpong -network net.json -l 30101
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	_ "../../chat"
+	demo "../../common"
+	"../../common/registry"
+	_ "../../pingpong"
+)
+
+func main() {
+	defer demo.FlushLogs()
+
+	if len(demo.Conf.Services) == 0 {
+		fmt.Println("services, with the services they need:")
+		for _, name := range demo.ServiceNames() {
+			fmt.Printf("  %-10s %s\n", name, strings.Join(registry.Needs(name), ", "))
+		}
+		fmt.Println("put them together with -services")
+		os.Exit(2)
+	}
+
+	// the websocket port is as far from the configured one as the p2p port is from the default, so stacks can run side by side
+	wsport := demo.Conf.WSPort + demo.Conf.P2PPort - demo.P2pPort
+	stack, err := demo.NewServiceNode(demo.Conf.P2PPort, 0, wsport, append([]string{"admin"}, demo.Conf.Services...)...)
+	if err != nil {
+		demo.Log.Crit(err.Error())
+	}
+	order, err := demo.ComposeServices(stack, demo.Conf.Services)
+	if err != nil {
+		demo.Log.Crit("compose services fail", "err", err)
+	}
+	if err := stack.Start(); err != nil {
+		demo.Log.Crit("servicenode start fail", "err", err)
+	}
+	defer demo.RemoveDataDir(stack.DataDir())
+	defer stack.Stop()
+
+	demo.Log.Info("node up", "services", strings.Join(order, ", "), "enode", stack.Server().NodeInfo().Enode, "ipc", demo.IPCEndpoint(stack))
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+	<-sigC
+	demo.Log.Info("stopping")
+}
@@ -0,0 +1,351 @@
+// bootstraps a demo network on a set of remote hosts over ssh
+//
+// the example is built locally, and copied to every host along with a freshly generated node key
+// the first host is the bootnode, all the others are started with its enode (-e)
+// the enodes of all the nodes are printed, and saved in the output directory
+//
+// the hosts file has one host per line, as [user@]host[:sshport]
+//
+// usage, from the directory with the examples:
+//
+//	go run cmd/testnet/main.go -hosts hosts.txt -i ~/.ssh/id_rsa -x E1_Pss.go
+//	go run cmd/testnet/main.go -hosts hosts.txt -i ~/.ssh/id_rsa -stop
+//
+// the binary is built with cgo (the secp256k1 library needs it)
+// so building for another platform than the local one needs a cross compiling C toolchain
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// same as the default p2p port in demo/common
+	defaultP2pPort = 30100
+
+	sshTimeout = time.Second * 10
+	binName    = "demonode"
+)
+
+var (
+	hostsfile  = flag.String("hosts", "", "file with the hosts to deploy to, one [user@]host[:sshport] per line")
+	identities = flag.String("i", "", "ssh private key files, comma separated")
+	example    = flag.String("x", "", "example file to run, e.g. E1_Pss.go")
+	port       = flag.Int("port", defaultP2pPort, "p2p port of the nodes")
+	remotedir  = flag.String("dir", "demo-testnet", "directory on the hosts to put the files in, relative to the home directory")
+	outdir     = flag.String("o", "testnet", "local directory to save the node keys and enodes in")
+	goos       = flag.String("goos", runtime.GOOS, "operating system of the hosts")
+	goarch     = flag.String("goarch", runtime.GOARCH, "architecture of the hosts")
+	knownfile  = flag.String("known", filepath.Join(homeDir(), ".ssh", "known_hosts"), "ssh known hosts file")
+	insecure   = flag.Bool("insecure", false, "don't check the host keys of the hosts")
+	verbose    = flag.Bool("v", false, "run the examples with verbose logs")
+	stop       = flag.Bool("stop", false, "stop the nodes on the hosts instead of starting them")
+)
+
+type testnetHost struct {
+	User    string
+	Host    string
+	SSHPort string
+	IP      net.IP
+	Key     *ecdsa.PrivateKey
+	Enode   string
+	Err     error
+}
+
+func (h *testnetHost) String() string {
+	return fmt.Sprintf("%s@%s:%s", h.User, h.Host, h.SSHPort)
+}
+
+func homeDir() string {
+	if u, err := user.Current(); err == nil {
+		return u.HomeDir
+	}
+	return os.Getenv("HOME")
+}
+
+// reads the hosts file, and looks up the addresses of the hosts
+// enode urls need the ip address, not the name
+func readHosts(path string) ([]*testnetHost, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	defaultuser := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		defaultuser = u.Username
+	}
+
+	var hosts []*testnetHost
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		h := &testnetHost{
+			User:    defaultuser,
+			SSHPort: "22",
+		}
+		if i := strings.Index(line, "@"); i > -1 {
+			h.User = line[:i]
+			line = line[i+1:]
+		}
+		h.Host = line
+		if host, sshport, err := net.SplitHostPort(line); err == nil {
+			h.Host = host
+			h.SSHPort = sshport
+		}
+		h.IP = net.ParseIP(h.Host)
+		if h.IP == nil {
+			addrs, err := net.LookupIP(h.Host)
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve host %s: %v", h.Host, err)
+			}
+			h.IP = addrs[0]
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, scanner.Err()
+}
+
+func sshConfig() (*ssh.ClientConfig, error) {
+	var signers []ssh.Signer
+	for _, keyfile := range strings.Split(*identities, ",") {
+		if keyfile == "" {
+			continue
+		}
+		keydata, err := ioutil.ReadFile(keyfile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(keydata)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", keyfile, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no ssh keys given (-i)")
+	}
+
+	hostkeycallback := ssh.InsecureIgnoreHostKey()
+	if !*insecure {
+		var err error
+		hostkeycallback, err = knownhosts.New(*knownfile)
+		if err != nil {
+			return nil, fmt.Errorf("known hosts: %v", err)
+		}
+	}
+	return &ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostkeycallback,
+		Timeout:         sshTimeout,
+	}, nil
+}
+
+// runs a command on the remote host, feeding it stdin if not nil
+func run(client *ssh.Client, cmd string, stdin io.Reader) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	var out bytes.Buffer
+	session.Stdout = &out
+	session.Stderr = &out
+	session.Stdin = stdin
+	err = session.Run(cmd)
+	if err != nil {
+		return out.String(), fmt.Errorf("'%s' fail: %v: %s", cmd, err, out.String())
+	}
+	return out.String(), nil
+}
+
+// copies a file to the remote host
+// we don't depend on scp or sftp being available, cat does the job
+func upload(client *ssh.Client, data []byte, path string, mode os.FileMode) error {
+	_, err := run(client, fmt.Sprintf("cat > %s && chmod %o %s", path, mode, path), bytes.NewReader(data))
+	return err
+}
+
+// stops the node that was started on the host previously, if any
+func stopCommand() string {
+	return fmt.Sprintf("cd %s 2>/dev/null && if [ -f node.pid ]; then kill $(cat node.pid) 2>/dev/null; rm -f node.pid; fi; true", *remotedir)
+}
+
+func deploy(h *testnetHost, cfg *ssh.ClientConfig, bin []byte, bootnode string) error {
+	hostcfg := *cfg
+	hostcfg.User = h.User
+	client, err := ssh.Dial("tcp", net.JoinHostPort(h.Host, h.SSHPort), &hostcfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if _, err := run(client, stopCommand(), nil); err != nil {
+		return err
+	}
+	if *stop {
+		return nil
+	}
+
+	if _, err := run(client, fmt.Sprintf("mkdir -p %s", *remotedir), nil); err != nil {
+		return err
+	}
+	if err := upload(client, bin, filepath.Join(*remotedir, binName), 0755); err != nil {
+		return err
+	}
+	if err := upload(client, []byte(fmt.Sprintf("%x", crypto.FromECDSA(h.Key))), filepath.Join(*remotedir, "nodekey"), 0600); err != nil {
+		return err
+	}
+
+	// the node runs detached from the ssh session, and its logs end up in node.log
+	// it is exec'ed in a subshell, so the pid we record is that of the node itself
+	args := []string{"./" + binName, "-l", fmt.Sprintf("%d", *port), "-k", "nodekey"}
+	if *verbose {
+		args = append(args, "-v")
+	}
+	if bootnode != "" {
+		args = append(args, "-e", bootnode)
+	}
+	cmd := fmt.Sprintf("(cd %s && exec nohup %s > node.log 2>&1 < /dev/null) & echo $! > %s/node.pid", *remotedir, strings.Join(args, " "), *remotedir)
+	_, err = run(client, cmd, nil)
+	return err
+}
+
+// builds the example for the hosts' platform
+func build(path string) ([]byte, error) {
+	tmpdir, err := ioutil.TempDir("", "demo-testnet-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpdir)
+	binpath := filepath.Join(tmpdir, binName)
+	cmd := exec.Command("go", "build", "-o", binpath, path)
+	cmd.Env = append(os.Environ(), "GOOS="+*goos, "GOARCH="+*goarch, "CGO_ENABLED=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, out)
+	}
+	return ioutil.ReadFile(binpath)
+}
+
+func main() {
+	flag.Parse()
+
+	if *hostsfile == "" || (*example == "" && !*stop) {
+		fmt.Fprintln(os.Stderr, "need hosts (-hosts) and an example to run (-x)")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	hosts, err := readHosts(*hostsfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hosts: %v\n", err)
+		os.Exit(1)
+	}
+	if len(hosts) == 0 {
+		fmt.Fprintln(os.Stderr, "no hosts in hosts file")
+		os.Exit(1)
+	}
+	sshcfg, err := sshConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ssh: %v\n", err)
+		os.Exit(1)
+	}
+
+	var bin []byte
+	if !*stop {
+		fmt.Printf("building %s for %s/%s\n", *example, *goos, *goarch)
+		bin, err = build(*example)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "build fail: %v\n", err)
+			os.Exit(1)
+		}
+
+		// since we make the keys, we know all the enodes before any of the nodes are started
+		var enodes []string
+		for _, h := range hosts {
+			h.Key, err = crypto.GenerateKey()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "key generate fail: %v\n", err)
+				os.Exit(1)
+			}
+			h.Enode = enode.NewV4(&h.Key.PublicKey, h.IP, *port, *port).String()
+			enodes = append(enodes, h.Enode)
+
+			hostdir := filepath.Join(*outdir, h.Host)
+			err = os.MkdirAll(hostdir, 0700)
+			if err == nil {
+				err = crypto.SaveECDSA(filepath.Join(hostdir, "nodekey"), h.Key)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "save key fail: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		err = ioutil.WriteFile(filepath.Join(*outdir, "enodes"), []byte(strings.Join(enodes, "\n")+"\n"), 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "save enodes fail: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// the bootnode goes first, so it's up when the others try to connect
+	var bootnode string
+	hosts[0].Err = deploy(hosts[0], sshcfg, bin, "")
+	if !*stop {
+		if hosts[0].Err != nil {
+			fmt.Fprintf(os.Stderr, "bootnode %s fail: %v\n", hosts[0], hosts[0].Err)
+			os.Exit(1)
+		}
+		bootnode = hosts[0].Enode
+	}
+	var wg sync.WaitGroup
+	for _, h := range hosts[1:] {
+		wg.Add(1)
+		go func(h *testnetHost) {
+			defer wg.Done()
+			h.Err = deploy(h, sshcfg, bin, bootnode)
+		}(h)
+	}
+	wg.Wait()
+
+	// report
+	failed := 0
+	for _, h := range hosts {
+		if h.Err != nil {
+			failed++
+			fmt.Printf("%s\tFAIL\t%v\n", h, h.Err)
+		} else if *stop {
+			fmt.Printf("%s\tstopped\n", h)
+		} else {
+			fmt.Printf("%s\tOK\t%s\n", h, h.Enode)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,211 @@
+// writes the topology of a network as graphviz dot or graphml snapshots at intervals, to look at how its connections change offline
+//
+// it follows the event stream of a simulation server, like cmd/simserver, and/or the peer events of live nodes over their rpc,
+// and writes numbered snapshots to a directory until it's interrupted. With -diff every snapshot but the first also gets a diff
+// file, drawing the connections added since the one before in green and those removed in dashed red
+//
+// given two graphml snapshots instead, it writes the diff between them to stdout
+//
+// usage, from the directory with the examples:
+//
+//	go run cmd/topology/main.go -url http://localhost:8888 -dir topology -interval 2s -diff
+//	go run cmd/topology/main.go -nodes .data_30100/demo.ipc,.data_30101/demo.ipc -format graphml
+//	go run cmd/topology/main.go -format dot topology/topology-0001.graphml topology/topology-0009.graphml > changes.dot
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"../../topology"
+)
+
+var (
+	serverURL = flag.String("url", "", "url of a simulation server to follow")
+	nodes     = flag.String("nodes", "", "rpc endpoints of live nodes to follow, ipc paths or websocket urls, separated by commas")
+	dir       = flag.String("dir", "topology", "directory to write the snapshots to")
+	interval  = flag.Duration("interval", time.Second*5, "how often to write a snapshot")
+	formats   = flag.String("format", "dot,graphml", "formats to write, dot and/or graphml separated by commas")
+	diff      = flag.Bool("diff", false, "write the diff with the snapshot before next to each snapshot")
+	verbose   = flag.Bool("v", false, "more verbose logs")
+)
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// follows the network of the simulation server, from the nodes and connections there are already
+func followSim(tracker *topology.Tracker, url string) (func(), error) {
+	client := simulations.NewClient(url)
+	events := make(chan *simulations.Event, 1024)
+	sub, err := client.SubscribeNetwork(events, simulations.SubscribeOpts{Current: true})
+	if err != nil {
+		return nil, fmt.Errorf("subscribe to %s: %v", url, err)
+	}
+	go func() {
+		for {
+			select {
+			case ev := <-events:
+				tracker.ApplySim(ev)
+			case err := <-sub.Err():
+				if err != nil {
+					log.Error("simulation event stream lost", "url", url, "err", err)
+				}
+				return
+			}
+		}
+	}()
+	log.Info("following simulation", "url", url)
+	return sub.Unsubscribe, nil
+}
+
+// follows the peers of a live node; it's subscribed before its peers are asked for, so none is missed between
+func followNode(tracker *topology.Tracker, endpoint string) (func(), error) {
+	client, err := rpc.Dial(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %v", endpoint, err)
+	}
+	var info p2p.NodeInfo
+	if err := client.Call(&info, "admin_nodeInfo"); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("node info of %s: %v", endpoint, err)
+	}
+	events := make(chan *p2p.PeerEvent, 1024)
+	sub, err := client.Subscribe(context.Background(), "admin", events, "peerEvents")
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("peer events of %s: %v", endpoint, err)
+	}
+	var peers []*p2p.PeerInfo
+	if err := client.Call(&peers, "admin_peers"); err != nil {
+		sub.Unsubscribe()
+		client.Close()
+		return nil, fmt.Errorf("peers of %s: %v", endpoint, err)
+	}
+	tracker.SetNode(info.ID, info.Name, true)
+	for _, p := range peers {
+		tracker.SetNode(p.ID, p.Name, true)
+		tracker.SetEdge(info.ID, p.ID, true)
+	}
+	go func() {
+		for {
+			select {
+			case ev := <-events:
+				tracker.ApplyPeer(info.ID, ev)
+			case err := <-sub.Err():
+				// the node is gone, or we're done
+				if err != nil {
+					log.Warn("node gone", "endpoint", endpoint, "err", err)
+					tracker.SetNode(info.ID, "", false)
+				}
+				return
+			}
+		}
+	}()
+	log.Info("following node", "endpoint", endpoint, "id", info.ID)
+	return func() {
+		sub.Unsubscribe()
+		client.Close()
+	}, nil
+}
+
+func readGraph(path string) *topology.Graph {
+	f, err := os.Open(path)
+	if err != nil {
+		fatal("%v", err)
+	}
+	defer f.Close()
+	g, err := topology.ReadGraphML(f)
+	if err != nil {
+		fatal("read %s: %v", path, err)
+	}
+	return g
+}
+
+// writes the diff of two snapshots to stdout, in the first of the formats
+func compare(prevPath, curPath string, format string) {
+	write, ok := topology.Writers[format]
+	if !ok {
+		fatal("unknown format %q", format)
+	}
+	prev, cur := readGraph(prevPath), readGraph(curPath)
+	d := topology.Compare(prev, cur)
+	if err := write(os.Stdout, cur, d); err != nil {
+		fatal("write diff: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "nodes +%d -%d, started %d, stopped %d; connections +%d -%d\n",
+		len(d.AddedNodes), len(d.RemovedNodes), len(d.StartedNodes), len(d.StoppedNodes), len(d.AddedEdges), len(d.RemovedEdges))
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-url url] [-nodes endpoints] [options]\n       %s [-format f] old.graphml new.graphml\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	loglevel := log.LvlInfo
+	if *verbose {
+		loglevel = log.LvlDebug
+	}
+	log.Root().SetHandler(log.LvlFilterHandler(loglevel, log.StreamHandler(os.Stderr, log.TerminalFormat(true))))
+
+	if flag.NArg() == 2 {
+		compare(flag.Arg(0), flag.Arg(1), strings.Split(*formats, ",")[0])
+		return
+	}
+	if flag.NArg() != 0 || (*serverURL == "" && *nodes == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	tracker := topology.NewTracker()
+	var stops []func()
+	defer func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}()
+	if *serverURL != "" {
+		stop, err := followSim(tracker, *serverURL)
+		if err != nil {
+			fatal("%v", err)
+		}
+		stops = append(stops, stop)
+	}
+	if *nodes != "" {
+		for _, endpoint := range strings.Split(*nodes, ",") {
+			stop, err := followNode(tracker, endpoint)
+			if err != nil {
+				fatal("%v", err)
+			}
+			stops = append(stops, stop)
+		}
+	}
+
+	exporter, err := topology.NewExporter(tracker, *dir, strings.Split(*formats, ","), *diff)
+	if err != nil {
+		fatal("%v", err)
+	}
+	quitC := make(chan struct{})
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigC
+		close(quitC)
+	}()
+	if err := exporter.Run(*interval, quitC); err != nil {
+		fatal("%v", err)
+	}
+}
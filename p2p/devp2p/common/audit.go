@@ -0,0 +1,535 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// the name of the node's own IPC socket with -audit, the one other processes find is the audited one
+	unauditedIPCPrefix = "unaudited-"
+)
+
+var (
+	// AuditMethods are the methods audited, a name ending with * stands for all the methods starting with the rest of it
+	// but for those that only read
+	AuditMethods = []string{"admin_*", "demo_set*", "pss_setPeerPublicKey"}
+
+	// the methods of AuditMethods that only tell how things are, and change nothing
+	auditReadOnly = map[string]bool{
+		"admin_nodeInfo": true,
+		"admin_peers":    true,
+		"admin_datadir":  true,
+	}
+
+	// RunAudit records the calls to the nodes of the running example, nil without -audit
+	RunAudit *Audit
+)
+
+// Audit keeps a record of the mutating rpc calls made to the nodes of the example, with -audit
+//
+// the record is a file with a line of json for every call, see AuditEntry, which is only ever appended to, also
+// across runs, but by one example at a time. Every line has the hash of the one before it, so a line changed or
+// taken out is found by Verify.
+// The calls are taken from the connections to the IPC socket and the websocket of the nodes: with -audit the runner
+// serves these itself in front of the rpc of the node, and the node's own socket is moved aside. The calls the example
+// makes itself, in process with Attach or on the socket of stack.IPCEndpoint, are not audited
+type Audit struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	seq  uint64
+	prev string // the hash of the last line
+}
+
+// AuditEntry is a line of the audit record, a call and how it went
+type AuditEntry struct {
+	Seq       uint64          `json:"seq"`
+	Time      time.Time       `json:"time"`
+	Node      string          `json:"node"`
+	Transport string          `json:"transport"`        // ipc or ws
+	Conn      int             `json:"conn"`             // the connection of the caller, by the order they were accepted in
+	Remote    string          `json:"remote,omitempty"` // the address of the caller, for the transports that have one
+	Method    string          `json:"method"`
+	Params    json.RawMessage `json:"params,omitempty"`
+	OK        bool            `json:"ok"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Millis    int64           `json:"ms"`   // how long the node took to answer
+	Prev      string          `json:"prev"` // the hash of the line before, empty for the first
+}
+
+// AuditQuery picks entries of the record, the fields left empty pick all
+type AuditQuery struct {
+	Method    string    `json:"method"` // a method, or a pattern like those of AuditMethods
+	Transport string    `json:"transport"`
+	Since     time.Time `json:"since"`
+	Failed    bool      `json:"failed"` // only the calls that failed
+	Limit     int       `json:"limit"`  // the last entries, this many at most
+}
+
+// opens the record at the path, carrying on from its last line
+func openAudit(path string) (*Audit, error) {
+	a := &Audit{path: path}
+	var last *AuditEntry
+	err := a.scan(func(e *AuditEntry, line []byte) error {
+		last = e
+		a.prev = lineHash(line)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if last != nil {
+		a.seq = last.Seq
+	}
+	a.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// reads the lines of the record, stopping at the first error of the function
+func (a *Audit) scan(f func(e *AuditEntry, line []byte) error) error {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("%s:%d: %v", a.path, n, err)
+		}
+		if err := f(&e, scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func lineHash(line []byte) string {
+	return hexutil.Encode(crypto.Keccak256(line)[:16])
+}
+
+func (a *Audit) write(e *AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.seq++
+	e.Seq = a.seq
+	e.Prev = a.prev
+	line, err := json.Marshal(e)
+	if err != nil {
+		Log.Error("audit entry fail", "method", e.Method, "err", err)
+		return
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		Log.Error("audit write fail", "err", err)
+		return
+	}
+	a.prev = lineHash(line)
+}
+
+// Entries returns the entries of the node that the query picks, oldest first
+func (a *Audit) Entries(nodename string, q AuditQuery) ([]*AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var entries []*AuditEntry
+	err := a.scan(func(e *AuditEntry, line []byte) error {
+		switch {
+		case e.Node != nodename:
+		case q.Method != "" && !matchMethod(q.Method, e.Method):
+		case q.Transport != "" && q.Transport != e.Transport:
+		case e.Time.Before(q.Since):
+		case q.Failed && e.OK:
+		default:
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries, err
+}
+
+// Verify checks that every line of the record follows the one before, and that the last is the one written last,
+// and returns how many there are
+func (a *Audit) Verify() (uint64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var prev string
+	var n uint64
+	err := a.scan(func(e *AuditEntry, line []byte) error {
+		n++
+		if e.Prev != prev || e.Seq != n {
+			return fmt.Errorf("audit record broken at line %d, seq %d", n, e.Seq)
+		}
+		prev = lineHash(line)
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	// the last line isn't followed by one with its hash, but it's the one last written
+	if n != a.seq || prev != a.prev {
+		return n, fmt.Errorf("audit record changed after seq %d", n)
+	}
+	return n, nil
+}
+
+func (a *Audit) Close() error {
+	return a.file.Close()
+}
+
+func matchMethod(pattern string, method string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(method, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == method
+}
+
+func audited(method string) bool {
+	if auditReadOnly[method] {
+		return false
+	}
+	for _, pattern := range AuditMethods {
+		if matchMethod(pattern, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditAPI is the audit namespace of the rpc of a node, on its IPC endpoint
+type AuditAPI struct {
+	audit *Audit
+	name  string
+}
+
+// Entries returns the calls to the node that the query picks
+func (api *AuditAPI) Entries(q AuditQuery) ([]*AuditEntry, error) {
+	return api.audit.Entries(api.name, q)
+}
+
+// Verify checks the whole record, of all the nodes, and returns how many entries there are
+func (api *AuditAPI) Verify() (uint64, error) {
+	return api.audit.Verify()
+}
+
+// serves the IPC socket and the websocket of a node with -audit, taking the calls from their connections
+type auditService struct {
+	audit   *Audit
+	name    string
+	stack   *node.Node
+	ipcpath string
+	wsaddr  string
+	modules map[string]bool // the namespaces served on the websocket
+
+	mu    sync.Mutex
+	conns int
+	lns   []net.Listener
+}
+
+// IPCEndpoint returns the IPC endpoint of the stack that other processes are to use, the audited one with -audit
+func IPCEndpoint(stack *node.Node) string {
+	if RunAudit == nil {
+		return stack.IPCEndpoint()
+	}
+	return IPCPath(stack.DataDir(), Conf.IPCName)
+}
+
+// moves the node's own endpoints aside, and registers the service serving them instead
+// it is given the node config before the node is made with it
+func registerAuditService(cfg *node.Config, name string, wsport int, modules []string) func(*node.Node) error {
+	s := &auditService{
+		audit:   RunAudit,
+		name:    name,
+		ipcpath: IPCPath(cfg.DataDir, Conf.IPCName),
+		modules: make(map[string]bool),
+	}
+	cfg.IPCPath = NodeIPCPath(cfg.DataDir, unauditedIPCPrefix+Conf.IPCName)
+	if cfg.WSHost != "" {
+		s.wsaddr = fmt.Sprintf("%s:%d", cfg.WSHost, wsport)
+		for _, m := range modules {
+			s.modules[m] = true
+		}
+		cfg.WSHost = ""
+	}
+	return func(stack *node.Node) error {
+		s.stack = stack
+		return stack.Register(func(*node.ServiceContext) (node.Service, error) {
+			return s, nil
+		})
+	}
+}
+
+func (s *auditService) Protocols() []p2p.Protocol {
+	return nil
+}
+
+func (s *auditService) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "audit",
+			Version:   "1.0",
+			Service:   &AuditAPI{audit: s.audit, name: s.name},
+			Public:    false,
+		},
+	}
+}
+
+func (s *auditService) Start(srv *p2p.Server) error {
+	ln, err := ListenIPC(s.ipcpath)
+	if err != nil {
+		return fmt.Errorf("audited ipc fail: %v", err)
+	}
+	s.lns = append(s.lns, ln)
+	go s.accept(ln, "ipc")
+	if s.wsaddr != "" {
+		wsln, err := net.Listen("tcp", s.wsaddr)
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("audited websocket fail: %v", err)
+		}
+		s.lns = append(s.lns, wsln)
+		// any origin, as the nodes of the examples take them all
+		ws := websocket.Server{
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler: func(conn *websocket.Conn) {
+				s.serve(conn, "ws", conn.Request().RemoteAddr)
+			},
+		}
+		go http.Serve(wsln, ws)
+	}
+	Log.Info("audited rpc endpoints opened", "node", s.name, "ipc", s.ipcpath, "ws", s.wsaddr)
+	return nil
+}
+
+func (s *auditService) Stop() error {
+	for _, ln := range s.lns {
+		ln.Close()
+	}
+	return nil
+}
+
+func (s *auditService) accept(ln net.Listener, transport string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(conn, transport, "")
+	}
+}
+
+// an rpc message, as much of it as the audit needs to know
+type auditMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// a call waiting for its reply
+type auditCall struct {
+	msg     *auditMessage
+	started time.Time
+}
+
+// serves the rpc of the node on the connection
+// the connection is tapped: what the caller sends is read and passed on to the rpc server through a pipe, and
+// what it answers is read and passed back, and the audited calls are written to the record with their replies
+func (s *auditService) serve(conn io.ReadWriteCloser, transport string, remote string) {
+	defer conn.Close()
+	handler, err := s.stack.RPCHandler()
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns++
+	id := s.conns
+	s.mu.Unlock()
+	entry := func(call *auditCall) *AuditEntry {
+		return &AuditEntry{
+			Time:      call.started,
+			Node:      s.name,
+			Transport: transport,
+			Conn:      id,
+			Remote:    remote,
+			Method:    call.msg.Method,
+			Params:    call.msg.Params,
+		}
+	}
+
+	local, upstream := net.Pipe()
+	go handler.ServeCodec(rpc.NewJSONCodec(upstream), rpc.OptionMethodInvocation|rpc.OptionSubscriptions)
+	defer local.Close()
+
+	var (
+		mu      sync.Mutex // for the writes to the caller, and the calls pending
+		pending = make(map[string]*auditCall)
+		done    = make(chan struct{})
+	)
+	// the replies
+	go func() {
+		defer close(done)
+		dec := json.NewDecoder(local)
+		for {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return
+			}
+			msgs := splitBatch(raw)
+			mu.Lock()
+			for _, msg := range msgs {
+				call, ok := pending[string(msg.ID)]
+				if !ok || len(msg.ID) == 0 {
+					continue
+				}
+				delete(pending, string(msg.ID))
+				e := entry(call)
+				e.Millis = int64(time.Since(call.started) / time.Millisecond)
+				if msg.Error != nil {
+					e.Error = msg.Error.Message
+				} else {
+					e.OK = true
+					e.Result = msg.Result
+				}
+				s.audit.write(e)
+			}
+			_, err := conn.Write(append(raw, '\n'))
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// the calls
+	dec := json.NewDecoder(conn)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			break
+		}
+		msgs := splitBatch(raw)
+		if refusal := s.refuse(transport, raw, msgs); refusal != nil {
+			mu.Lock()
+			_, err := conn.Write(append(refusal, '\n'))
+			mu.Unlock()
+			if err != nil {
+				break
+			}
+			continue
+		}
+		now := time.Now()
+		mu.Lock()
+		for _, msg := range msgs {
+			if !audited(msg.Method) {
+				continue
+			}
+			call := &auditCall{msg: msg, started: now}
+			if len(msg.ID) == 0 {
+				// a notification gets no reply, so there's no telling how it went
+				e := entry(call)
+				e.Error = "notification, no reply"
+				s.audit.write(e)
+				continue
+			}
+			pending[string(msg.ID)] = call
+		}
+		mu.Unlock()
+		if _, err := local.Write(append(raw, '\n')); err != nil {
+			break
+		}
+	}
+	local.Close()
+	<-done
+	for _, call := range pending {
+		e := entry(call)
+		e.Error = "no reply, connection closed"
+		s.audit.write(e)
+	}
+}
+
+func isBatch(raw json.RawMessage) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(raw), []byte("["))
+}
+
+// the messages of a batch, or the message, nil if it's not one the rpc server takes
+func splitBatch(raw json.RawMessage) []*auditMessage {
+	var msgs []*auditMessage
+	if isBatch(raw) {
+		if json.Unmarshal(raw, &msgs) == nil && len(msgs) > 0 {
+			return msgs
+		}
+		return nil
+	}
+	var msg auditMessage
+	if json.Unmarshal(raw, &msg) != nil {
+		return nil
+	}
+	return []*auditMessage{&msg}
+}
+
+// the websocket only serves the namespaces the node was made with, like the node's own
+// a request calling others is refused as a whole, with an error for each call
+func (s *auditService) refuse(transport string, raw json.RawMessage, msgs []*auditMessage) json.RawMessage {
+	if msgs == nil {
+		// the rpc server could still make the calls of a batch it takes in part, without them being audited
+		return rpcErrorReply(json.RawMessage("null"), "invalid request")
+	}
+	if transport != "ws" {
+		return nil
+	}
+	served := func(method string) bool {
+		ns := strings.SplitN(method, "_", 2)[0]
+		return s.modules[ns] || ns == "rpc"
+	}
+	var refused bool
+	for _, msg := range msgs {
+		if !served(msg.Method) {
+			refused = true
+		}
+	}
+	if !refused {
+		return nil
+	}
+	var replies []json.RawMessage
+	for _, msg := range msgs {
+		reason := fmt.Sprintf("the method %s does not exist/is not available", msg.Method)
+		if served(msg.Method) {
+			reason = "batch refused, it has methods that are not available"
+		}
+		replies = append(replies, rpcErrorReply(msg.ID, reason))
+	}
+	if !isBatch(raw) {
+		return replies[0]
+	}
+	out, _ := json.Marshal(replies)
+	return out
+}
@@ -5,17 +5,23 @@ import (
 	"crypto/ecdsa"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/ethereum/go-ethereum/swarm/network"
+	colorable "github.com/mattn/go-colorable"
+	"github.com/mattn/go-isatty"
 	//	"github.com/ethereum/go-ethereum/swarm/pss"
+
+	"../mdns"
 )
 
 const (
@@ -40,6 +46,7 @@ var (
 	remotehost   = flag.String("h", "127.0.0.1", "remote host (RPC, p2p)")
 	enodeconnect = flag.String("e", "", "enode to connect to (overrides remote RPC lookup)")
 	p2plocalport = flag.Int("l", P2pPort, "local port for p2p connections")
+	nodekeyfile  = flag.String("k", "", "file with hex encoded private key for the node on the local port")
 )
 
 // setup logging
@@ -49,6 +56,15 @@ func init() {
 
 	flag.Parse()
 
+	// the config file and flags decide the settings, and with that the log level
+	// logging is not set up yet, so errors go straight to stderr
+	err = setupConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration fail: %v\n", err)
+		os.Exit(1)
+	}
+	dumpConfig()
+
 	// get the working directory
 	BasePath, err = os.Getwd()
 	if err != nil {
@@ -56,15 +72,78 @@ func init() {
 	}
 
 	// ensure good log formats for terminal
-	// handle verbosity flag
-	hs := log.StreamHandler(os.Stderr, log.TerminalFormat(true))
-	loglevel := log.LvlInfo
-	if *verbose {
-		loglevel = log.LvlTrace
+	// colors are only used when we're actually writing to a terminal
+	// the windows console doesn't understand the escape codes, so colorable translates them
+	// handle verbosity flag, through the config
+	usecolor := (isatty.IsTerminal(os.Stderr.Fd()) || isatty.IsCygwinTerminal(os.Stderr.Fd())) && os.Getenv("TERM") != "dumb"
+	output := io.Writer(os.Stderr)
+	if usecolor {
+		output = colorable.NewColorableStderr()
+	}
+	// with the dashboard the log lines are shown under it, the screen is redrawn too often for them to stay
+	if Conf.TUI {
+		RunDashboard = startDashboard(os.Stdout)
+		output = RunDashboard
+		usecolor = false
+	}
+	hs := log.StreamHandler(output, log.TerminalFormat(usecolor))
+
+	// with many nodes in the process, every node can get a log file of its own
+	// the console still shows them all, with the node in front of each line
+	if Conf.LogFiles {
+		router, err := newLogRouter(LogDir())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Log directory fail: %v\n", err)
+			os.Exit(1)
+		}
+		hs = log.MultiHandler(nodeConsoleHandler(output, usecolor), router)
 	}
+
+	// the logs can go to a log server too, so the logs of many nodes can be searched in one place
+	if Conf.LogShip.URL != "" {
+		shipper = newLogShipper(Conf.LogShip)
+		hs = log.MultiHandler(hs, shipper)
+	}
+
+	// the report keeps the errors logged, whatever the log level
+	// the golden files are compared with the report, so it's made for them too
+	if Conf.Report != "" || Conf.Golden != "" {
+		RunReport = newReport()
+		hs = log.MultiHandler(hs, RunReport)
+	}
+	if RunDashboard != nil {
+		hs = log.MultiHandler(hs, RunDashboard)
+	}
+	loglevel, _ := log.LvlFromString(Conf.LogLevel)
 	hf := log.LvlFilterHandler(loglevel, hs)
 	h := log.CallerFileHandler(hf)
 	log.Root().SetHandler(h)
+
+	// the rpc sessions of the example can be recorded, and played back later without the nodes
+	if Conf.RPCRecord != "" {
+		runTape, err = RecordRPC(Conf.RPCRecord)
+	} else if Conf.RPCReplay != "" {
+		runTape, err = ReplayRPC(Conf.RPCReplay)
+	}
+	if err != nil {
+		Log.Crit("RPC tape fail", "err", err)
+	}
+
+	// the calls that change the nodes are kept a record of, for the operators
+	if Conf.Audit != "" {
+		RunAudit, err = openAudit(Conf.Audit)
+		if err != nil {
+			Log.Crit("Audit record fail", "err", err)
+		}
+	}
+
+	// orchestrators, like docker's health checks, ask the example whether its nodes are up
+	if Conf.Health != "" {
+		RunHealth, err = startHealth(Conf.Health)
+		if err != nil {
+			Log.Crit("Health server fail", "err", err)
+		}
+	}
 }
 
 //func NewSwarmService(stack *node.Node, bzzport int) func(ctx *node.ServiceContext) (node.Service, error) {
@@ -104,14 +183,48 @@ func init() {
 // set up the local service node
 func NewServiceNode(port int, httpport int, wsport int, modules ...string) (*node.Node, error) {
 	if port == 0 {
-		port = P2pPort
+		port = Conf.P2PPort
 	}
 	cfg := &node.DefaultConfig
 	cfg.P2P.ListenAddr = fmt.Sprintf(":%d", port)
 	cfg.P2P.EnableMsgEvents = true
 	cfg.P2P.NoDiscovery = true
-	cfg.IPCPath = IPCName
-	cfg.DataDir = fmt.Sprintf("%s%d", DatadirPrefix, port)
+	cfg.DataDir = DataDir(port)
+	cfg.IPCPath = NodeIPCPath(cfg.DataDir, Conf.IPCName)
+	// the logs of the node itself are tagged with it, so they can be told apart from the other nodes in the process
+	cfg.Logger = log.New(NodeLogKey, fmt.Sprintf("%d", port))
+
+	// when run in a container (see cmd/composegen) the node on the local port gets its identity from the key file
+	// and connects to the bootnodes
+	// (the config is shared between calls, so clear what the previous node may have left there)
+	cfg.P2P.PrivateKey = nil
+	cfg.P2P.StaticNodes = nil
+	if port == Conf.P2PPort {
+		if Conf.NodeKey != "" {
+			privkey, err := crypto.LoadECDSA(Conf.NodeKey)
+			if err != nil {
+				return nil, fmt.Errorf("load node key fail: %v", err)
+			}
+			cfg.P2P.PrivateKey = privkey
+		}
+		for _, bootnode := range Conf.Bootnodes {
+			remotenode, err := enode.ParseV4(bootnode)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bootnode enode: %v", err)
+			}
+			cfg.P2P.StaticNodes = append(cfg.P2P.StaticNodes, remotenode)
+		}
+		// with -network it also connects to the nodes of the examples running in other processes
+		if Conf.Network != "" {
+			if err := joinNetwork(&cfg.P2P); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// the same goes for the rpc endpoints, only the nodes asking for them get them
+	cfg.HTTPHost = ""
+	cfg.WSHost = ""
+	cfg.WSModules = nil
 	if httpport > 0 {
 		cfg.HTTPHost = node.DefaultHTTPHost
 		cfg.HTTPPort = httpport
@@ -124,10 +237,38 @@ func NewServiceNode(port int, httpport int, wsport int, modules ...string) (*nod
 			cfg.WSModules = append(cfg.WSModules, modules[i])
 		}
 	}
+	// with -audit the endpoints other processes use are served in front of the node's rpc
+	var registerAudit func(*node.Node) error
+	if RunAudit != nil {
+		registerAudit = registerAuditService(cfg, fmt.Sprintf("%d", port), wsport, modules)
+	}
 	stack, err := node.New(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("ServiceNode create fail: %v", err)
 	}
+	if registerAudit != nil {
+		if err := registerAudit(stack); err != nil {
+			return nil, fmt.Errorf("ServiceNode audit fail: %v", err)
+		}
+	}
+	if RunReport != nil || RunDashboard != nil || Enabled("tracing") {
+		if err := registerWatchService(stack, fmt.Sprintf("%d", port)); err != nil {
+			return nil, fmt.Errorf("ServiceNode report fail: %v", err)
+		}
+	}
+	if port == Conf.P2PPort && Conf.Network != "" {
+		if err := registerNetworkService(stack); err != nil {
+			return nil, fmt.Errorf("ServiceNode network fail: %v", err)
+		}
+	}
+	if port == Conf.P2PPort && Enabled("mdns") {
+		if err := stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+			return mdns.NewService(mdns.DefaultConfig(), nil), nil
+		}); err != nil {
+			return nil, fmt.Errorf("ServiceNode mdns fail: %v", err)
+		}
+	}
+	RunHealth.WatchNode(fmt.Sprintf("%d", port), stack)
 	return stack, nil
 }
 
@@ -140,6 +281,7 @@ func NewServer(privkey *ecdsa.PrivateKey, name string, version string, proto p2p
 		MaxPeers:        1,
 		Protocols:       []p2p.Protocol{proto},
 		EnableMsgEvents: true,
+		Logger:          log.New(NodeLogKey, name),
 	}
 	if port > 0 {
 		cfg.ListenAddr = fmt.Sprintf(":%d", port)
@@ -147,6 +289,10 @@ func NewServer(privkey *ecdsa.PrivateKey, name string, version string, proto p2p
 	srv := &p2p.Server{
 		Config: cfg,
 	}
+	RunReport.WatchServer(name, srv)
+	RunHealth.WatchServer(name, srv)
+	RunDashboard.WatchServer(name, srv)
+	traceServer(name, srv)
 	return srv
 }
 
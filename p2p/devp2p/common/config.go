@@ -0,0 +1,287 @@
+package common
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/naoina/toml"
+	"gopkg.in/yaml.v2"
+
+	"../envelope"
+)
+
+// Config holds the settings that the examples would otherwise take from the constants in this package
+//
+// it can be loaded from a TOML or YAML file with -c
+// flags given on the command line override what is in the file
+type Config struct {
+	P2PPort      int      `yaml:"p2pPort"`      // p2p port of the first node, the other nodes in an example use the ports following it
+	WSPort       int      `yaml:"wsPort"`       // websocket rpc port
+	BzzPort      int      `yaml:"bzzPort"`      // swarm http port of the first node
+	BzzNetworkId uint64   `yaml:"bzzNetworkId"` // swarm network id
+	DataDir      string   `yaml:"dataDir"`      // directory to keep the node data directories in between runs, empty means the working directory and removing them after the run
+	IPCName      string   `yaml:"ipcName"`      // name of the IPC endpoint in the node data directories
+	LogLevel     string   `yaml:"logLevel"`     // crit, error, warn, info, debug or trace
+	LogFiles     bool     `yaml:"logFiles"`     // write the logs of every node to a file of its own, and tag the lines on the console with the node
+	Report       string   `yaml:"report"`       // directory to write a report of the run to when the example ends, empty means no report
+	Golden       string   `yaml:"golden"`       // directory with the golden files to compare the report of the run with, empty means none
+	GoldenUpdate bool     `yaml:"goldenUpdate"` // write the golden file from the run instead of comparing with it
+	NodeKey      string   `yaml:"nodeKey"`      // file with hex encoded private key for the node on the p2p port
+	Bootnodes    []string `yaml:"bootnodes"`    // enodes the node on the p2p port connects to
+	Codec        string   `yaml:"codec"`        // rlp, or protobuf to send the messages that have a protobuf encoding as protobuf in rlp frames
+	Health       string   `yaml:"health"`       // address to serve /healthz, /readyz and /status on, empty means none
+	Features     []string `yaml:"features"`     // optional behaviors to turn on, see Features
+	Services     []string `yaml:"services"`     // services to put together on a node stack, see RegisterService
+	Network      string   `yaml:"network"`      // network descriptor file to join the examples in other processes through, see NetworkDescriptor
+	TUI          bool     `yaml:"tui"`          // show a dashboard of the nodes in the terminal instead of the log
+	RPCRecord    string   `yaml:"rpcRecord"`    // file to record the rpc sessions of DialRPC to
+	RPCReplay    string   `yaml:"rpcReplay"`    // file to play the rpc sessions of DialRPC back from, instead of running the nodes
+	Audit        string   `yaml:"audit"`        // file to append the mutating rpc calls to the nodes to, empty means none, see Audit
+	Pss          PssConfig
+	Envelope     EnvelopeConfig
+	LogShip      LogShipConfig
+	Retry        RetryPolicy // how the rpc calls of the examples are tried again when they fail
+}
+
+// PssConfig holds the pss options
+type PssConfig struct {
+	MsgTTL              int  `yaml:"msgTTL"`   // seconds a message lives in the network
+	CacheTTL            int  `yaml:"cacheTTL"` // seconds a message digest is remembered, to avoid forwarding a message twice
+	SymKeyCacheCapacity int  `yaml:"symKeyCacheCapacity"`
+	AllowRaw            bool `yaml:"allowRaw"` // accept messages that aren't encrypted by pss
+}
+
+// EnvelopeConfig holds the settings of the envelopes the examples wrap their payloads in
+type EnvelopeConfig struct {
+	TTL       int `yaml:"ttl"`       // milliseconds the envelopes made with envelope.Wrap are valid, 0 for ever
+	ClockSkew int `yaml:"clockSkew"` // milliseconds an envelope is still taken after it expired, for senders with a clock behind ours
+}
+
+// Apply sets the pss options on the pss parameters
+func (c *PssConfig) Apply(params *pss.PssParams) {
+	params.MsgTTL = time.Duration(c.MsgTTL) * time.Second
+	params.CacheTTL = time.Duration(c.CacheTTL) * time.Second
+	params.SymKeyCacheCapacity = c.SymKeyCacheCapacity
+	params.AllowRaw = c.AllowRaw
+}
+
+var (
+	// Conf is the configuration in effect for the running example
+	Conf = DefaultConfig()
+
+	configfile = flag.String("c", "", "config file (.toml, .yaml or .yml)")
+	dumpconfig = flag.Bool("dump-config", false, "print the configuration in effect and exit")
+	datadir    = flag.String("datadir", "", "directory for the node data, which is then kept between runs")
+	codec      = flag.String("codec", "rlp", "message encoding, rlp or protobuf (both sides must use the same)")
+	logfiles   = flag.Bool("logfiles", false, "write the logs of every node to a file of its own in the logs directory")
+	report     = flag.String("report", "", "directory to write a report of the run to, in JSON and markdown")
+	golden     = flag.String("golden", "", "directory with golden files to compare the report of the run with, written when missing")
+	goldenup   = flag.Bool("golden-update", false, "write the golden file from this run instead of comparing")
+	logship    = flag.String("logship", "", "url to ship the logs to, a loki push endpoint or with a config file a json ingest")
+	health     = flag.String("health", "", "address to serve the health of the nodes on, e.g. :8080 for /healthz, /readyz and /status")
+	tui        = flag.Bool("tui", false, "show a live dashboard of the nodes, their peers and messages, with the log below it")
+	rpcrecord  = flag.String("rpc-record", "", "file to record the rpc calls and replies of the example to")
+	rpcreplay  = flag.String("rpc-replay", "", "file to play the rpc replies back from, so the example runs without its nodes")
+	ttl        = flag.Int("ttl", 0, "milliseconds the messages in envelopes are valid, receivers and relays drop them after")
+	enable     = flag.String("enable", "", "comma separated features to turn on: "+strings.Join(FeatureNames(), ", "))
+	services   = flag.String("services", "", "comma separated services to put together on a node stack, in the examples that compose them")
+	netfile    = flag.String("network", "", "network descriptor file, written by the first example and joined by the ones after it")
+	auditfile  = flag.String("audit", "", "file to append the mutating rpc calls to the nodes to, with who made them and how they went")
+)
+
+// these settings make the TOML keys the same as the field names, like geth's config file
+var tomlSettings = toml.Config{
+	NormFieldName: func(rt reflect.Type, key string) string {
+		return key
+	},
+	FieldToKey: func(rt reflect.Type, field string) string {
+		return field
+	},
+	MissingField: func(rt reflect.Type, field string) error {
+		return fmt.Errorf("field '%s' is not defined in %s", field, rt.String())
+	},
+}
+
+// DefaultConfig returns the configuration matching the constants in this package
+func DefaultConfig() *Config {
+	pssparams := pss.NewPssParams()
+	return &Config{
+		P2PPort:      P2pPort,
+		WSPort:       WSDefaultPort,
+		BzzPort:      BzzDefaultPort,
+		BzzNetworkId: BzzDefaultNetworkId,
+		IPCName:      IPCName,
+		LogLevel:     "info",
+		Codec:        "rlp",
+		Pss: PssConfig{
+			MsgTTL:              int(pssparams.MsgTTL / time.Second),
+			CacheTTL:            int(pssparams.CacheTTL / time.Second),
+			SymKeyCacheCapacity: pssparams.SymKeyCacheCapacity,
+			AllowRaw:            pssparams.AllowRaw,
+		},
+		Envelope: EnvelopeConfig{
+			ClockSkew: int(envelope.DefaultClockSkew / time.Millisecond),
+		},
+		LogShip: LogShipConfig{
+			Format:    "loki",
+			BatchSize: defaultShipBatch,
+			Interval:  defaultShipInterval,
+		},
+		Retry: RetryPolicy{
+			Attempts: 5,
+			Delay:    200,
+			MaxDelay: 2000,
+			Timeout:  5000,
+		},
+	}
+}
+
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// LoadConfig reads the configuration from a TOML or YAML file
+// the format is chosen by the file extension
+// settings missing from the file keep their default values
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := DefaultConfig()
+	if isYAML(path) {
+		err = yaml.UnmarshalStrict(data, cfg)
+	} else {
+		err = tomlSettings.NewDecoder(bytes.NewReader(data)).Decode(cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// DumpConfig writes the configuration in TOML, or in YAML if yml is true
+func DumpConfig(w io.Writer, cfg *Config, yml bool) error {
+	var data []byte
+	var err error
+	if yml {
+		data, err = yaml.Marshal(cfg)
+	} else {
+		data, err = tomlSettings.Marshal(cfg)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// loads the config file if there is one, and puts the flags that were given on top
+func setupConfig() error {
+	if *configfile != "" {
+		cfg, err := LoadConfig(*configfile)
+		if err != nil {
+			return err
+		}
+		Conf = cfg
+	}
+
+	// only the flags actually present on the command line count
+	// otherwise their default values would overwrite the file
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "v":
+			if *verbose {
+				Conf.LogLevel = "trace"
+			}
+		case "l":
+			Conf.P2PPort = *p2plocalport
+		case "e":
+			Conf.Bootnodes = []string{*enodeconnect}
+		case "k":
+			Conf.NodeKey = *nodekeyfile
+		case "datadir":
+			Conf.DataDir = *datadir
+		case "codec":
+			Conf.Codec = *codec
+		case "logfiles":
+			Conf.LogFiles = *logfiles
+		case "report":
+			Conf.Report = *report
+		case "golden":
+			Conf.Golden = *golden
+		case "golden-update":
+			Conf.GoldenUpdate = *goldenup
+		case "logship":
+			Conf.LogShip.URL = *logship
+		case "health":
+			Conf.Health = *health
+		case "tui":
+			Conf.TUI = *tui
+		case "rpc-record":
+			Conf.RPCRecord = *rpcrecord
+		case "rpc-replay":
+			Conf.RPCReplay = *rpcreplay
+		case "ttl":
+			Conf.Envelope.TTL = *ttl
+		case "enable":
+			Conf.Features = splitList(*enable)
+		case "services":
+			Conf.Services = splitList(*services)
+		case "network":
+			Conf.Network = *netfile
+		case "audit":
+			Conf.Audit = *auditfile
+		}
+	})
+	if _, err := log.LvlFromString(Conf.LogLevel); err != nil {
+		return fmt.Errorf("invalid log level '%s'", Conf.LogLevel)
+	}
+	switch Conf.Codec {
+	case "rlp":
+		envelope.UseProtobuf(false)
+	case "protobuf":
+		envelope.UseProtobuf(true)
+	default:
+		return fmt.Errorf("invalid codec '%s'", Conf.Codec)
+	}
+	if Conf.Envelope.TTL < 0 || Conf.Envelope.ClockSkew < 0 {
+		return fmt.Errorf("negative envelope ttl or clock skew")
+	}
+	envelope.UseTTL(time.Duration(Conf.Envelope.TTL) * time.Millisecond)
+	envelope.SetClockSkew(time.Duration(Conf.Envelope.ClockSkew) * time.Millisecond)
+	if Conf.LogShip.Format != "loki" && Conf.LogShip.Format != "json" {
+		return fmt.Errorf("invalid log shipping format '%s'", Conf.LogShip.Format)
+	}
+	if Conf.GoldenUpdate && Conf.Golden == "" {
+		return fmt.Errorf("-golden-update needs the golden directory")
+	}
+	if Conf.RPCRecord != "" && Conf.RPCReplay != "" {
+		return fmt.Errorf("rpc calls can't be recorded and replayed at the same time")
+	}
+	return setupFeatures()
+}
+
+// handles -dump-config, which exits the program after printing the configuration
+func dumpConfig() {
+	if !*dumpconfig {
+		return
+	}
+	err := DumpConfig(os.Stdout, Conf, isYAML(*configfile))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dump config fail: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,85 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// DataDir returns the data directory to use for a service node listening on port
+//
+// normally it's a hidden directory in the working directory, or in the data directory of the config
+// but if the working directory is nested so deep that the node's IPC socket path would be too long for the platform
+// (this easily happens on macOS) the directory is put in the system temp dir instead, with a warning naming it
+func DataDir(port int) string {
+	datadir := fmt.Sprintf("%s%d", DatadirPrefix, port)
+	if ipcPathFits(IPCPath(filepath.Join(Conf.DataDir, datadir), Conf.IPCName)) {
+		return filepath.Join(Conf.DataDir, datadir)
+	}
+	tmpdir := filepath.Join(os.TempDir(), datadir)
+	if Conf.DataDir != "" {
+		Log.Warn("ipc path too long in the data directory given, using the temp dir instead", "datadir", Conf.DataDir, "used", tmpdir)
+	} else {
+		Log.Warn("ipc path too long in the working directory, using the temp dir instead", "used", tmpdir)
+	}
+	return tmpdir
+}
+
+// TempDataDir creates a new and uniquely named data directory in the system temp dir
+// the caller is responsible for removing it
+func TempDataDir(prefix string) (string, error) {
+	if prefix == "" {
+		prefix = DatadirPrefix
+	}
+	return ioutil.TempDir("", prefix)
+}
+
+// Persistent tells whether the node data is kept between runs
+// this is the case when a data directory is given, with -datadir or in the config file
+func Persistent() bool {
+	return Conf.DataDir != ""
+}
+
+// RemoveDataDir removes a node data directory at the end of a run, unless the data is persistent
+func RemoveDataDir(path string) error {
+	if Persistent() {
+		Log.Debug("keeping data directory", "path", path)
+		return nil
+	}
+	return os.RemoveAll(path)
+}
+
+// LoadOrCreateKey loads the private key from the file at path
+//
+// if the file doesn't exist a new key is generated
+// when the data is persistent the new key is saved to the file, so the next run gets the same one back
+func LoadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	if Persistent() {
+		privkey, err := crypto.LoadECDSA(path)
+		if err == nil {
+			Log.Debug("loaded key", "path", path)
+			return privkey, nil
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("load key fail: %v", err)
+		}
+	}
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	if Persistent() {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return nil, err
+		}
+		err = crypto.SaveECDSA(path, privkey)
+		if err != nil {
+			return nil, fmt.Errorf("save key fail: %v", err)
+		}
+	}
+	return privkey, nil
+}
@@ -0,0 +1,118 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+const (
+	DevNetworkId = 1337
+	DevAccounts  = 8    // accounts funded in the genesis block of a dev chain
+	DevBalance   = 1000 // ether each of them gets
+)
+
+// DevChain is an ethereum node with a chain of its own, run the way geth --dev does it
+// it seals a block with clique as soon as there are transactions to put in it
+type DevChain struct {
+	Node     *node.Node
+	Eth      *eth.Ethereum
+	Client   *ethclient.Client // over the in-process rpc of the node
+	ChainID  *big.Int
+	Accounts []*ecdsa.PrivateKey
+}
+
+// NewDevChainNode starts a dev mode node in the process, for the examples that send transactions or use contracts
+// the chain is kept in memory, and is gone when the node stops
+func NewDevChainNode() (*DevChain, error) {
+	stack, err := node.New(&node.Config{
+		UseLightweightKDF: true,
+		P2P: p2p.Config{
+			MaxPeers:    0,
+			NoDiscovery: true,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DevChain create fail: %v", err)
+	}
+
+	// the signer of the blocks has its key in the keystore, unlocked
+	// without a data directory the keystore is in a temporary directory, removed when the node stops
+	ks := stack.AccountManager().Backends(keystore.KeyStoreType)[0].(*keystore.KeyStore)
+	developer, err := ks.NewAccount("")
+	if err != nil {
+		return nil, err
+	}
+	if err := ks.Unlock(developer, ""); err != nil {
+		return nil, err
+	}
+
+	// the accounts the examples send from get their ether in the genesis block
+	genesis := core.DeveloperGenesisBlock(0, developer.Address)
+	var accounts []*ecdsa.PrivateKey
+	for i := 0; i < DevAccounts; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		genesis.Alloc[crypto.PubkeyToAddress(key.PublicKey)] = core.GenesisAccount{
+			Balance: new(big.Int).Mul(big.NewInt(DevBalance), big.NewInt(params.Ether)),
+		}
+		accounts = append(accounts, key)
+	}
+
+	ethconfig := eth.DefaultConfig
+	ethconfig.NetworkId = DevNetworkId
+	ethconfig.Genesis = genesis
+	ethconfig.Etherbase = developer.Address
+	ethconfig.MinerGasPrice = big.NewInt(1)
+	err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		return eth.New(ctx, &ethconfig)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DevChain eth register fail: %v", err)
+	}
+	if err := stack.Start(); err != nil {
+		return nil, fmt.Errorf("DevChain start fail: %v", err)
+	}
+	var ethereum *eth.Ethereum
+	if err := stack.Service(&ethereum); err != nil {
+		stack.Stop()
+		return nil, err
+	}
+	if err := ethereum.StartMining(1); err != nil {
+		stack.Stop()
+		return nil, fmt.Errorf("DevChain mining start fail: %v", err)
+	}
+	// the examples joining with -network can tell the chain by its genesis
+	if err := NoteNetworkGenesis(ethereum.BlockChain().Genesis().Hash().Hex()); err != nil {
+		Log.Warn("network genesis fail", "err", err)
+	}
+	rpcclient, err := stack.Attach()
+	if err != nil {
+		stack.Stop()
+		return nil, err
+	}
+	return &DevChain{
+		Node:     stack,
+		Eth:      ethereum,
+		Client:   ethclient.NewClient(rpcclient),
+		ChainID:  ethereum.BlockChain().Config().ChainID,
+		Accounts: accounts,
+	}, nil
+}
+
+// Close closes the client, and stops the node
+func (self *DevChain) Close() error {
+	self.Client.Close()
+	return self.Node.Stop()
+}
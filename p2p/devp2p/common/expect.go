@@ -0,0 +1,78 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+const (
+	// how often Eventually checks its condition
+	pollInterval = time.Millisecond * 50
+)
+
+var (
+	ErrTimeout       = errors.New("timed out")
+	ErrChannelClosed = errors.New("channel closed")
+)
+
+// Eventually checks the condition until it holds, and fails with ErrTimeout if the context is done first
+//
+// it's for what happens in the background, like peers connecting, where a sleep would either be too long or sometimes too short
+// the condition is checked right away, then every 50 milliseconds
+func Eventually(ctx context.Context, cond func() bool) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if cond() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return ErrTimeout
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// EventuallyWithin is Eventually with a timeout in place of the context
+func EventuallyWithin(timeout time.Duration, cond func() bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return Eventually(ctx, cond)
+}
+
+// ExpectMsg receives the next value from the channel within the timeout, and checks it with the matcher
+//
+// the channel can be of any type, the value is returned as an interface{} to be asserted to the type of the channel.
+// A nil matcher takes anything, which is how to wait for a signal on a chan struct{}.
+// It fails with ErrTimeout if nothing comes in time, ErrChannelClosed if the channel is closed,
+// and with an error naming the value if it doesn't match, in which case the value is returned too
+func ExpectMsg(ch interface{}, matcher func(interface{}) bool, timeout time.Duration) (interface{}, error) {
+	chv := reflect.ValueOf(ch)
+	if chv.Kind() != reflect.Chan {
+		panic(fmt.Sprintf("ExpectMsg on %T, not a channel", ch))
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	chosen, v, ok := reflect.Select([]reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: chv},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)},
+	})
+	if chosen == 1 {
+		return nil, ErrTimeout
+	}
+	if !ok {
+		return nil, ErrChannelClosed
+	}
+	msg := v.Interface()
+	if matcher != nil && !matcher(msg) {
+		return msg, fmt.Errorf("unexpected %+v", msg)
+	}
+	return msg, nil
+}
@@ -0,0 +1,112 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+
+	"../envelope"
+)
+
+// Features are the optional behaviors an example can be run with, turned on with -enable
+// some take effect in this package, the others are up to the examples that check for them with Enabled
+var Features = map[string]string{
+	"rawpss":     "accept pss messages that aren't encrypted by pss (sets Pss.AllowRaw)",
+	"compress":   "compress the payloads of the envelopes made with envelope.Wrap",
+	"accounting": "keep the balance of the messages exchanged with every peer, in the examples that support it",
+	"tracing":    "log every message the nodes send and receive",
+	"mdns":       "find the nodes of the examples on the local network with mdns, and connect to them",
+}
+
+// FeatureNames returns the names of the features, sorted
+func FeatureNames() []string {
+	var names []string
+	for name := range Features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enabled tells whether the feature was turned on
+// asking for a feature that doesn't exist is a mistake in the example, so it's a critical error
+func Enabled(name string) bool {
+	if _, ok := Features[name]; !ok {
+		Log.Crit("unknown feature", "name", name)
+	}
+	for _, f := range Conf.Features {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// splits a comma separated list of names, dropping the spaces and empty names
+func splitList(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// checks the features asked for and applies those that take effect in this package
+func setupFeatures() error {
+	for _, name := range Conf.Features {
+		if _, ok := Features[name]; !ok {
+			return fmt.Errorf("unknown feature '%s', the features are %s", name, strings.Join(FeatureNames(), ", "))
+		}
+	}
+	if Enabled("rawpss") {
+		Conf.Pss.AllowRaw = true
+	}
+	if Enabled("compress") {
+		envelope.UseCompression(envelope.CompressFlate)
+	} else {
+		envelope.UseCompression(envelope.CompressNone)
+	}
+	return nil
+}
+
+// logs the messages of the server with tracing, the server may be started after
+func traceServer(name string, srv *p2p.Server) {
+	if !Enabled("tracing") {
+		return
+	}
+	logger := log.New(NodeLogKey, name)
+	eventC := make(chan *p2p.PeerEvent, 64)
+	sub := srv.SubscribeEvents(eventC)
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case ev := <-eventC:
+				switch ev.Type {
+				case p2p.PeerEventTypeMsgSend, p2p.PeerEventTypeMsgRecv:
+					var size uint32
+					if ev.MsgSize != nil {
+						size = *ev.MsgSize
+					}
+					var code uint64
+					if ev.MsgCode != nil {
+						code = *ev.MsgCode
+					}
+					logger.Info("trace "+string(ev.Type), "peer", ev.Peer, "protocol", ev.Protocol, "code", code, "size", size)
+				case p2p.PeerEventTypeAdd:
+					logger.Info("trace add", "peer", ev.Peer)
+				case p2p.PeerEventTypeDrop:
+					logger.Info("trace drop", "peer", ev.Peer, "err", ev.Error)
+				}
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"io"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/protobuf/proto"
+
+	"../envelope"
+)
+
+// FooMsg is the message of the "foo" protocol
+//
+// with -codec protobuf it's sent as the protobuf encoding in foo.proto, inside an rlp frame
+type FooMsg struct {
+	V uint
+}
+
+// the plain rlp encoding
+type fooMsgRLP FooMsg
+
+func (m *FooMsg) EncodeRLP(w io.Writer) error {
+	return envelope.EncodeFrame(w, m, (*fooMsgRLP)(m))
+}
+
+func (m *FooMsg) DecodeRLP(s *rlp.Stream) error {
+	return envelope.DecodeFrame(s, m, (*fooMsgRLP)(m))
+}
+
+func (m *FooMsg) MarshalProto() ([]byte, error) {
+	buf := proto.NewBuffer(nil)
+
+	// proto3 leaves out fields with default values
+	if m.V != 0 {
+		envelope.ProtoKey(buf, 1, envelope.WireVarint)
+		buf.EncodeVarint(uint64(m.V))
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *FooMsg) UnmarshalProto(b []byte) error {
+	*m = FooMsg{}
+	return envelope.ProtoFields(b, func(num int, wire int, buf *proto.Buffer) (bool, error) {
+		if num == 1 && wire == envelope.WireVarint {
+			v, err := buf.DecodeVarint()
+			m.V = uint(v)
+			return true, err
+		}
+		return false, nil
+	})
+}
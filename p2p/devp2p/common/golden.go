@@ -0,0 +1,153 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// keys, ids, addresses and hashes: a run of hex digits long enough not to be a number that means something
+	goldenHexRe = regexp.MustCompile(`(0x)?[0-9a-fA-F]{8,}`)
+
+	// the udp port of a server without a listen address is picked by the system
+	goldenDiscportRe = regexp.MustCompile(`discport=\d+`)
+)
+
+// GoldenReport is the report of a run with what changes from run to run taken out, to compare runs with -golden
+//
+// the times are left out, the durations keep only their names, and every key, id and address is replaced
+// by a placeholder numbered by where it first appears, so a node that is the peer of another is still seen to be
+type GoldenReport struct {
+	Example   string           `json:"example"`
+	Outcome   string           `json:"outcome"`
+	Nodes     []*ReportNode    `json:"nodes"`
+	Counts    map[string]int64 `json:"counts,omitempty"`
+	Durations []string         `json:"durations,omitempty"`
+	Errors    []string         `json:"errors,omitempty"` // level and message, and the context
+}
+
+// replaces the hex strings by placeholders, the same string by the same one
+type goldenNormalizer struct {
+	seen map[string]string
+}
+
+func (g *goldenNormalizer) normalize(s string) string {
+	return goldenHexRe.ReplaceAllStringFunc(s, func(hex string) string {
+		key := strings.ToLower(strings.TrimPrefix(hex, "0x"))
+		if p, ok := g.seen[key]; ok {
+			return p
+		}
+		p := fmt.Sprintf("<hex%d>", len(g.seen)+1)
+		g.seen[key] = p
+		return p
+	})
+}
+
+// Golden returns the report as it's compared with -golden
+func (r *Report) Golden() *GoldenReport {
+	g := &goldenNormalizer{seen: make(map[string]string)}
+	golden := &GoldenReport{
+		Example: r.Example,
+		Outcome: r.Outcome,
+		Counts:  r.Counts,
+	}
+	for _, n := range r.Nodes {
+		gn := *n
+		gn.ID = g.normalize(n.ID)
+		gn.Enode = goldenDiscportRe.ReplaceAllString(g.normalize(n.Enode), "discport=<port>")
+		golden.Nodes = append(golden.Nodes, &gn)
+	}
+	golden.Durations = sortedKeys(r.Durations)
+	// the errors of the nodes running at the same time come in any order
+	for _, e := range r.Errors {
+		golden.Errors = append(golden.Errors, strings.TrimSpace(fmt.Sprintf("%s %s %s", e.Level, e.Msg, g.normalize(e.Ctx))))
+	}
+	sort.Strings(golden.Errors)
+	return golden
+}
+
+// GoldenPath is the golden file of the example in the directory
+func GoldenPath(dir string, example string) string {
+	return filepath.Join(dir, example+".golden.json")
+}
+
+// compares the report with the golden file of the example, or writes the file with -golden-update or when there's none yet
+// the differences are written to w
+func checkGolden(r *Report, dir string, update bool, w io.Writer) (bool, error) {
+	// the placeholders are kept readable, as they are in the file
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r.Golden()); err != nil {
+		return false, err
+	}
+	got := b.Bytes()
+	path := GoldenPath(dir, r.Example)
+	want, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) || update {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return false, err
+		}
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			return false, err
+		}
+		fmt.Fprintf(w, "golden file %s written\n", path)
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if bytes.Equal(got, want) {
+		fmt.Fprintf(w, "golden file %s matches\n", path)
+		return true, nil
+	}
+	fmt.Fprintf(w, "golden file %s differs, - golden + this run:\n", path)
+	for _, line := range diffLines(strings.Split(string(want), "\n"), strings.Split(string(got), "\n")) {
+		fmt.Fprintln(w, line)
+	}
+	return false, nil
+}
+
+// the lines that differ between a and b, as - and + lines, from their longest common subsequence
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "- "+a[i])
+			i++
+		default:
+			diff = append(diff, "+ "+b[j])
+			j++
+		}
+	}
+	return diff
+}
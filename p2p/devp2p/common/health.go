@@ -0,0 +1,243 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// how long a check may take, the whole probe should answer well within the timeout of an orchestrator
+	healthCheckTimeout = time.Second
+)
+
+// Health serves the state of the nodes of the running example over http, with -health
+//
+// /healthz answers as long as the process is up, /readyz only when every node is ready, and /status tells why in json.
+// A node is ready when its p2p server runs, and listens if it has a listen address, and for a service node when its rpc answers
+// and, if it runs swarm, its kademlia table is healthy. Nodes are added by NewServer and NewServiceNode
+type Health struct {
+	started time.Time
+
+	mu    sync.Mutex
+	nodes []*healthNode
+}
+
+type healthNode struct {
+	name  string
+	srv   *p2p.Server // nil for a service node, whose server only exists while it runs
+	stack *node.Node
+}
+
+// HealthCheck is the outcome of one check of a node
+type HealthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// NodeStatus is the state of a node, as /status gives it
+type NodeStatus struct {
+	Name   string         `json:"name"`
+	Enode  string         `json:"enode,omitempty"`
+	Peers  int            `json:"peers"`
+	Ready  bool           `json:"ready"`
+	Checks []*HealthCheck `json:"checks"`
+}
+
+// HealthStatus is what /status answers
+type HealthStatus struct {
+	Example string        `json:"example"`
+	Started time.Time     `json:"started"`
+	Uptime  string        `json:"uptime"`
+	Ready   bool          `json:"ready"`
+	Nodes   []*NodeStatus `json:"nodes"`
+}
+
+var (
+	// RunHealth serves the health of the running example, nil without -health
+	RunHealth *Health
+)
+
+// starts serving on the address; the listener is opened before returning, so a port in use is an error here
+func startHealth(addr string) (*Health, error) {
+	h := &Health{started: time.Now()}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", h.serveReady)
+	mux.HandleFunc("/status", h.serveStatus)
+	go http.Serve(ln, mux)
+	return h, nil
+}
+
+// WatchServer adds the server to the nodes checked
+func (h *Health) WatchServer(name string, srv *p2p.Server) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nodes = append(h.nodes, &healthNode{name: name, srv: srv})
+}
+
+// WatchNode adds the service node to the nodes checked
+func (h *Health) WatchNode(name string, stack *node.Node) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nodes = append(h.nodes, &healthNode{name: name, stack: stack})
+}
+
+// Status checks all the nodes
+// with no nodes yet the example isn't ready, it hasn't started any
+func (h *Health) Status() *HealthStatus {
+	h.mu.Lock()
+	nodes := append([]*healthNode{}, h.nodes...)
+	h.mu.Unlock()
+	status := &HealthStatus{
+		Example: strings.TrimSuffix(filepath.Base(os.Args[0]), filepath.Ext(os.Args[0])),
+		Started: h.started,
+		Uptime:  time.Since(h.started).Round(time.Second).String(),
+		Ready:   len(nodes) > 0,
+	}
+	for _, n := range nodes {
+		ns := n.check()
+		status.Ready = status.Ready && ns.Ready
+		status.Nodes = append(status.Nodes, ns)
+	}
+	return status
+}
+
+func (h *Health) serveReady(w http.ResponseWriter, r *http.Request) {
+	status := h.Status()
+	if status.Ready {
+		fmt.Fprintln(w, "ready")
+		return
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if len(status.Nodes) == 0 {
+		fmt.Fprintln(w, "no nodes")
+	}
+	for _, n := range status.Nodes {
+		for _, c := range n.Checks {
+			if !c.OK {
+				fmt.Fprintf(w, "%s %s: %s\n", n.Name, c.Name, c.Detail)
+			}
+		}
+	}
+}
+
+func (h *Health) serveStatus(w http.ResponseWriter, r *http.Request) {
+	status := h.Status()
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(status)
+}
+
+func (n *healthNode) check() *NodeStatus {
+	ns := &NodeStatus{Name: n.name}
+	add := func(name string, err error) {
+		c := &HealthCheck{Name: name, OK: err == nil}
+		if err != nil {
+			c.Detail = err.Error()
+		}
+		ns.Checks = append(ns.Checks, c)
+	}
+
+	srv := n.srv
+	if n.stack != nil {
+		srv = n.stack.Server()
+	}
+	// the peers of a server that hasn't started can't be counted, the call would wait for it
+	err := checkServer(srv)
+	add("p2p", err)
+	if err == nil {
+		ns.Enode = srv.Self().String()
+		ns.Peers = srv.PeerCount()
+	}
+
+	// only service nodes have rpc, and only those running swarm have a kademlia table
+	if n.stack != nil {
+		client, err := n.stack.Attach()
+		if err == nil {
+			defer client.Close()
+			err = checkRPC(client)
+		}
+		add("rpc", err)
+		if err == nil {
+			if ok, err := checkKademlia(client); ok {
+				add("kademlia", err)
+			}
+		}
+	}
+
+	ns.Ready = true
+	for _, c := range ns.Checks {
+		ns.Ready = ns.Ready && c.OK
+	}
+	return ns
+}
+
+// a server runs once it has a node record of its own, and listens once the record has its tcp port
+// this is the state the server keeps, no connection is made to it, so a probe is neither a peer nor kept waiting
+func checkServer(srv *p2p.Server) error {
+	if srv == nil {
+		return fmt.Errorf("not running")
+	}
+	self := srv.Self()
+	if self.IP().IsUnspecified() {
+		return fmt.Errorf("not running")
+	}
+	if srv.ListenAddr != "" && self.TCP() == 0 {
+		return fmt.Errorf("not listening on %s", srv.ListenAddr)
+	}
+	return nil
+}
+
+func checkRPC(client *rpc.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	var modules map[string]string
+	return client.CallContext(ctx, &modules, "rpc_modules")
+}
+
+// false if the node has no kademlia table, that is, doesn't run swarm
+func checkKademlia(client *rpc.Client) (bool, error) {
+	var s string
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	err := client.CallContext(ctx, &s, "hive_string")
+	if rpcerr, ok := err.(rpc.Error); ok && rpcerr.ErrorCode() == -32601 {
+		return false, nil
+	} else if err != nil {
+		return true, err
+	}
+	k, err := ParseKademlia(s)
+	if err != nil {
+		return true, err
+	}
+	return true, k.Healthy()
+}
@@ -0,0 +1,48 @@
+//go:build !windows
+// +build !windows
+
+package common
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// unix socket paths are limited to 104 bytes on darwin and 108 on linux, including the terminating zero
+	// we go with the smallest
+	maxIPCPathLen = 103
+)
+
+// IPCPath returns the endpoint of the IPC socket called name in the directory dir
+func IPCPath(dir string, name string) string {
+	return filepath.Join(dir, name)
+}
+
+// NodeIPCPath returns the IPCPath to put in the config of a node with the data directory
+// the node puts the socket in its data directory itself
+func NodeIPCPath(datadir string, name string) string {
+	return name
+}
+
+// ListenIPC opens an IPC listener on the endpoint
+// a stale socket file from a previous run will block the listener, so it is removed first
+func ListenIPC(endpoint string) (net.Listener, error) {
+	os.Remove(endpoint)
+	return net.Listen("unix", endpoint)
+}
+
+// connects to the IPC endpoint
+func dialIPC(endpoint string) (net.Conn, error) {
+	return net.Dial("unix", endpoint)
+}
+
+// checks if the IPC endpoint can be used as a socket path on this platform
+func ipcPathFits(endpoint string) bool {
+	abspath, err := filepath.Abs(endpoint)
+	if err != nil {
+		return false
+	}
+	return len(abspath) <= maxIPCPathLen
+}
@@ -0,0 +1,50 @@
+//go:build windows
+// +build windows
+
+package common
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/natefinch/npipe.v2"
+)
+
+const (
+	pipePrefix = `\\.\pipe\`
+)
+
+// IPCPath returns the endpoint of the IPC socket called name
+// windows uses named pipes, which all live in the same top level namespace
+// so the last element of the directory goes in the name, for the nodes of a process to get pipes of their own
+func IPCPath(dir string, name string) string {
+	if strings.HasPrefix(name, pipePrefix) {
+		return name
+	}
+	if dir == "" {
+		return pipePrefix + name
+	}
+	return pipePrefix + filepath.Base(dir) + "-" + name
+}
+
+// NodeIPCPath returns the IPCPath to put in the config of a node with the data directory
+// the node would make the pipe of the name alone, the same for every node
+func NodeIPCPath(datadir string, name string) string {
+	return IPCPath(datadir, name)
+}
+
+// ListenIPC opens a named pipe listener on the endpoint
+func ListenIPC(endpoint string) (net.Listener, error) {
+	return npipe.Listen(endpoint)
+}
+
+// connects to the named pipe of the endpoint
+func dialIPC(endpoint string) (net.Conn, error) {
+	return npipe.Dial(endpoint)
+}
+
+// pipe names are not bound by the length of the data directory path
+func ipcPathFits(endpoint string) bool {
+	return true
+}
@@ -0,0 +1,158 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	kadHeaderRe = regexp.MustCompile(`queen's address: ([0-9a-f]+)`)
+	kadPopRe    = regexp.MustCompile(`population: (\d+) \((\d+)\)`)
+	kadDepthRe  = regexp.MustCompile(`DEPTH: (\d+)`)
+	kadRowRe    = regexp.MustCompile(`^(\d{3}) (.*)\|(.*)$`)
+	kadKnownRe  = regexp.MustCompile(`([0-9a-f]{4}) \((\d+)\)`)
+)
+
+// KademliaBin is a row of the kademlia table: the peers whose address shares PO leading bits with ours
+// the last bin of the table also holds all the peers closer than that
+type KademliaBin struct {
+	PO        int
+	Connected int
+	Known     int      // the connected ones included
+	Peers     []string // the first bytes of the addresses of the first few connected peers
+}
+
+// Kademlia is the kademlia table of a node, as the hive shows it
+type Kademlia struct {
+	Addr      string // the first bytes of the node's overlay address
+	Depth     int    // the nearest neighbours are in the bins from here on
+	Connected int
+	Known     int
+	Bins      []KademliaBin
+}
+
+// GetKademlia fetches the kademlia table of a swarm node
+//
+// the hive only gives it over rpc as the ascii table it logs, hive_string, so this takes the numbers back out of it
+func GetKademlia(client *rpc.Client) (*Kademlia, error) {
+	var s string
+	if err := client.Call(&s, "hive_string"); err != nil {
+		return nil, err
+	}
+	return ParseKademlia(s)
+}
+
+// ParseKademlia reads the kademlia table from the text of Kademlia.String in swarm/network
+func ParseKademlia(s string) (*Kademlia, error) {
+	k := &Kademlia{Depth: -1}
+	for _, line := range strings.Split(s, "\n") {
+		if m := kadHeaderRe.FindStringSubmatch(line); m != nil {
+			k.Addr = m[1]
+		} else if m := kadPopRe.FindStringSubmatch(line); m != nil {
+			k.Connected, _ = strconv.Atoi(m[1])
+			k.Known, _ = strconv.Atoi(m[2])
+		} else if m := kadDepthRe.FindStringSubmatch(line); m != nil {
+			k.Depth, _ = strconv.Atoi(m[1])
+		} else if m := kadRowRe.FindStringSubmatch(line); m != nil {
+			var bin KademliaBin
+			bin.PO, _ = strconv.Atoi(m[1])
+			live := strings.Fields(m[2])
+			known := strings.Fields(m[3])
+			if len(live) == 0 || len(known) == 0 {
+				return nil, fmt.Errorf("invalid kademlia row %q", line)
+			}
+			bin.Connected, _ = strconv.Atoi(live[0])
+			bin.Known, _ = strconv.Atoi(known[0])
+			bin.Peers = live[1:]
+			k.Bins = append(k.Bins, bin)
+		}
+	}
+	if k.Addr == "" || len(k.Bins) == 0 {
+		return nil, fmt.Errorf("no kademlia table")
+	}
+	// without a depth line all the bins are outside the neighbourhood
+	if k.Depth < 0 {
+		k.Depth = len(k.Bins)
+	}
+	return k, nil
+}
+
+// Healthy tells whether the node is connected well enough to route: it has peers,
+// and in every bin outside the neighbourhood where it knows of peers it's connected to one
+func (k *Kademlia) Healthy() error {
+	if k.Connected == 0 {
+		return fmt.Errorf("no peers")
+	}
+	for _, bin := range k.Bins {
+		if bin.PO < k.Depth && bin.Known > 0 && bin.Connected == 0 {
+			return fmt.Errorf("no peer connected in bin %d of %d known", bin.PO, bin.Known)
+		}
+	}
+	return nil
+}
+
+// KademliaHealthy is a condition for Eventually, that the kademlia tables of all the nodes are Healthy
+// a node that doesn't answer isn't
+func KademliaHealthy(clients ...*rpc.Client) func() bool {
+	return func() bool {
+		for _, client := range clients {
+			k, err := GetKademlia(client)
+			if err != nil || k.Healthy() != nil {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Format writes the table with a row for each bin that has peers, or is the depth
+// the peers of a bin are marked with a * when the bin is in the neighbourhood
+func (k *Kademlia) Format(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %s.. depth %d, %d connected of %d known\n", name, k.Addr, k.Depth, k.Connected, k.Known)
+	for _, bin := range k.Bins {
+		if bin.Known == 0 && bin.PO != k.Depth {
+			continue
+		}
+		nn := " "
+		if bin.PO >= k.Depth {
+			nn = "*"
+		}
+		row := fmt.Sprintf("  %s%2d %2d/%-2d %s", nn, bin.PO, bin.Connected, bin.Known, strings.Join(bin.Peers, " "))
+		fmt.Fprintln(w, strings.TrimRight(row, " "))
+	}
+}
+
+// WatchKademlia prints the kademlia tables of the nodes every interval, until the context is done
+// a node that doesn't answer gets the error printed instead of its table
+func WatchKademlia(ctx context.Context, w io.Writer, interval time.Duration, names []string, clients []*rpc.Client) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		PrintKademlia(w, names, clients)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// PrintKademlia prints the kademlia tables of the nodes once
+func PrintKademlia(w io.Writer, names []string, clients []*rpc.Client) {
+	fmt.Fprintf(w, "--- kademlia at %s\n", time.Now().Format("15:04:05.000"))
+	for i, client := range clients {
+		k, err := GetKademlia(client)
+		if err != nil {
+			fmt.Fprintf(w, "%s: %v\n", names[i], err)
+			continue
+		}
+		k.Format(w, names[i])
+	}
+}
@@ -0,0 +1,130 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	// NodeLogKey is the context key of a log line naming the node it is from
+	// the loggers of the nodes set it, and both -logfiles and the log shipping read it
+	NodeLogKey = "node"
+
+	logDirName  = "logs"
+	mainLogName = "main" // the file for the lines that aren't from a node
+)
+
+var (
+	// the colors of the node tags on the console, picked by the name of the node
+	tagColors = []int{31, 32, 33, 34, 35, 36, 91, 92, 93, 94, 95, 96}
+)
+
+// LogDir returns the directory the node log files are written to with -logfiles
+// it is in the data directory of the config, or in the working directory, and isn't removed at the end of a run
+func LogDir() string {
+	if Conf.DataDir != "" {
+		return filepath.Join(Conf.DataDir, logDirName)
+	}
+	return filepath.Join(basePath, logDirName)
+}
+
+// the value of the node key in the context of the record, empty if it has none
+func recordNode(r *log.Record) string {
+	for i := 0; i+1 < len(r.Ctx); i += 2 {
+		if k, ok := r.Ctx[i].(string); ok && k == NodeLogKey {
+			return fmt.Sprint(r.Ctx[i+1])
+		}
+	}
+	return ""
+}
+
+// logRouter is a log handler writing the lines of every node to a file of its own, <node>.log in the log directory
+// the files are created when a node logs its first line, and the ones of an earlier run are appended to
+type logRouter struct {
+	dir    string
+	format log.Format
+	mu     sync.Mutex
+	files  map[string]*os.File
+	failed bool
+}
+
+func newLogRouter(dir string) (*logRouter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &logRouter{
+		dir:    dir,
+		format: log.TerminalFormat(false),
+		files:  make(map[string]*os.File),
+	}, nil
+}
+
+// Log implements log.Handler
+func (l *logRouter) Log(r *log.Record) error {
+	node := recordNode(r)
+	if node == "" {
+		node = mainLogName
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.files[node]
+	if !ok {
+		var err error
+		f, err = os.OpenFile(filepath.Join(l.dir, logFileName(node)), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			// told once on stderr, logging it would come back here
+			if !l.failed {
+				fmt.Fprintf(os.Stderr, "log file fail: %v\n", err)
+				l.failed = true
+			}
+			return err
+		}
+		l.files[node] = f
+	}
+	_, err := f.Write(l.format.Format(r))
+	return err
+}
+
+// node names can be anything printed, so what can't be in a file name is replaced
+func logFileName(node string) string {
+	name := []byte(node)
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			name[i] = '_'
+		}
+	}
+	return string(name) + ".log"
+}
+
+// returns a handler writing the lines of all nodes to the console, each with the name of its node in front
+// with colors every node gets a color of its own, so the lines of one node can be followed by eye
+func nodeConsoleHandler(w io.Writer, usecolor bool) log.Handler {
+	format := log.TerminalFormat(usecolor)
+	var mu sync.Mutex
+	return log.FuncHandler(func(r *log.Record) error {
+		var buf bytes.Buffer
+		if node := recordNode(r); node != "" {
+			if usecolor {
+				h := fnv.New32a()
+				h.Write([]byte(node))
+				fmt.Fprintf(&buf, "\x1b[%dm%-8s\x1b[0m ", tagColors[h.Sum32()%uint32(len(tagColors))], node)
+			} else {
+				fmt.Fprintf(&buf, "%-8s ", node)
+			}
+		} else {
+			fmt.Fprintf(&buf, "%-8s ", "")
+		}
+		buf.Write(format.Format(r))
+		mu.Lock()
+		defer mu.Unlock()
+		_, err := w.Write(buf.Bytes())
+		return err
+	})
+}
@@ -0,0 +1,279 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+const (
+	defaultShipBatch    = 100
+	defaultShipInterval = 1000 // milliseconds
+	shipQueueSize       = 4096
+	shipTimeout         = time.Second * 5
+)
+
+// LogShipConfig holds the settings for shipping the logs to a log server
+type LogShipConfig struct {
+	URL       string            `yaml:"url"`       // endpoint to post the logs to, empty means the logs only go to stderr
+	Format    string            `yaml:"format"`    // loki for the loki push api, json for an ingest taking one json object per line (logstash, vector, fluentd)
+	Labels    map[string]string `yaml:"labels"`    // labels put on every line, in addition to job, example and node
+	BatchSize int               `yaml:"batchSize"` // lines sent in one request at most
+	Interval  int               `yaml:"interval"`  // milliseconds between requests
+}
+
+// FlushLogs sends the log lines not yet shipped, and waits for them to be sent
+// examples defer it in main, or the last lines are lost when they return; a critical error flushes them itself
+func FlushLogs() {
+	if shipper != nil {
+		shipper.flush()
+	}
+}
+
+var shipper *logShipper
+
+type shipEntry struct {
+	labels map[string]string
+	time   time.Time
+	line   string
+}
+
+// logShipper is a log handler queueing the lines and sending them in batches from a goroutine of its own
+// it must never block the caller, so when the queue is full lines are dropped and counted
+// its own errors go to stderr, logging them would only queue more lines
+type logShipper struct {
+	cfg      LogShipConfig
+	labels   map[string]string // what every line is labelled with
+	client   *http.Client
+	format   log.Format
+	entryC   chan *shipEntry
+	flushC   chan chan struct{}
+	mu       sync.Mutex
+	dropped  int
+	failing  bool
+	interval time.Duration
+}
+
+func newLogShipper(cfg LogShipConfig) *logShipper {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultShipBatch
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultShipInterval
+	}
+	labels := map[string]string{
+		"job":     "devp2p-demo",
+		"example": strings.TrimSuffix(filepath.Base(os.Args[0]), filepath.Ext(os.Args[0])),
+		"node":    defaultNodeLabel(),
+	}
+	for k, v := range cfg.Labels {
+		labels[k] = v
+	}
+	s := &logShipper{
+		cfg:      cfg,
+		labels:   labels,
+		client:   &http.Client{Timeout: shipTimeout},
+		entryC:   make(chan *shipEntry, shipQueueSize),
+		flushC:   make(chan chan struct{}),
+		interval: time.Duration(cfg.Interval) * time.Millisecond,
+	}
+	if cfg.Format == "json" {
+		s.format = log.JSONFormatEx(false, false)
+	} else {
+		s.format = log.LogfmtFormat()
+	}
+	go s.loop()
+	return s
+}
+
+// the node a process runs when there's one per process, as in containers:
+// the id of the node key if it has one, otherwise the host and the p2p port
+func defaultNodeLabel() string {
+	if Conf.NodeKey != "" {
+		if privkey, err := crypto.LoadECDSA(Conf.NodeKey); err == nil {
+			return enode.PubkeyToIDV4(&privkey.PublicKey).TerminalString()
+		}
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s:%d", host, Conf.P2PPort)
+}
+
+// Log implements log.Handler
+// the line is formatted right away, as the values in the context may change after the call
+// a critical error is sent before returning, since the program exits right after
+func (s *logShipper) Log(r *log.Record) error {
+	labels := s.labels
+	ctx := r.Ctx
+	if node := recordNode(r); node != "" {
+		labels = make(map[string]string, len(s.labels))
+		for k, v := range s.labels {
+			labels[k] = v
+		}
+		labels["node"] = node
+	}
+	// the json lines carry their labels with them, as a generic ingest has no streams to put them on
+	if s.cfg.Format == "json" {
+		ctx = make([]interface{}, 0, len(labels)*2+len(r.Ctx))
+		for _, k := range sortedKeys(labels) {
+			ctx = append(ctx, k, labels[k])
+		}
+		ctx = append(ctx, r.Ctx...)
+	}
+	rec := *r
+	rec.Ctx = ctx
+	line := strings.TrimSuffix(string(s.format.Format(&rec)), "\n")
+	select {
+	case s.entryC <- &shipEntry{labels: labels, time: r.Time, line: line}:
+	default:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+	}
+	if r.Lvl == log.LvlCrit {
+		s.flush()
+	}
+	return nil
+}
+
+func (s *logShipper) loop() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	var batch []*shipEntry
+	for {
+		select {
+		case e := <-s.entryC:
+			batch = append(batch, e)
+			if len(batch) < s.cfg.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case doneC := <-s.flushC:
+			for len(s.entryC) > 0 {
+				batch = append(batch, <-s.entryC)
+			}
+			s.send(batch)
+			batch = nil
+			close(doneC)
+			continue
+		}
+		s.send(batch)
+		batch = nil
+	}
+}
+
+func (s *logShipper) flush() {
+	doneC := make(chan struct{})
+	select {
+	case s.flushC <- doneC:
+	case <-time.After(shipTimeout):
+		return
+	}
+	select {
+	case <-doneC:
+	case <-time.After(shipTimeout):
+	}
+}
+
+func (s *logShipper) send(batch []*shipEntry) {
+	s.mu.Lock()
+	dropped := s.dropped
+	s.dropped = 0
+	s.mu.Unlock()
+	if dropped > 0 {
+		fmt.Fprintf(os.Stderr, "log shipping: queue full, dropped %d lines\n", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	var body []byte
+	var contentType string
+	if s.cfg.Format == "json" {
+		var buf bytes.Buffer
+		for _, e := range batch {
+			buf.WriteString(e.line)
+			buf.WriteByte('\n')
+		}
+		body, contentType = buf.Bytes(), "application/x-ndjson"
+	} else {
+		var err error
+		body, err = json.Marshal(lokiPush(batch))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "log shipping: %v\n", err)
+			return
+		}
+		contentType = "application/json"
+	}
+	err := s.post(body, contentType)
+
+	// only the first of a run of failures is told of, and when it's over
+	if err != nil && !s.failing {
+		fmt.Fprintf(os.Stderr, "log shipping to %s failed, lines are lost until it's back: %v\n", s.cfg.URL, err)
+	} else if err == nil && s.failing {
+		fmt.Fprintf(os.Stderr, "log shipping to %s is back\n", s.cfg.URL)
+	}
+	s.failing = err != nil
+}
+
+func (s *logShipper) post(body []byte, contentType string) error {
+	resp, err := s.client.Post(s.cfg.URL, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("http status %s", resp.Status)
+	}
+	return nil
+}
+
+// the body of a request to the loki push api, /loki/api/v1/push
+// the lines go in streams by their labels, with the time in nanoseconds as a string
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func lokiPush(batch []*shipEntry) interface{} {
+	var streams []*lokiStream
+	byLabels := make(map[string]*lokiStream)
+	for _, e := range batch {
+		var key strings.Builder
+		for _, k := range sortedKeys(e.labels) {
+			fmt.Fprintf(&key, "%s=%q,", k, e.labels[k])
+		}
+		stream, ok := byLabels[key.String()]
+		if !ok {
+			stream = &lokiStream{Stream: e.labels}
+			byLabels[key.String()] = stream
+			streams = append(streams, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(e.time.UnixNano(), 10), e.line})
+	}
+	return map[string]interface{}{
+		"streams": streams,
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
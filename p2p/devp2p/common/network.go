@@ -0,0 +1,200 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// how long a process waits for another to be done with the descriptor
+	networkLockWait = time.Second * 5
+	// a lock older than this was left by a process that died holding it
+	networkLockStale = time.Second * 30
+)
+
+// NetworkDescriptor is the file examples running in different processes meet in, given with -network
+//
+// the first example to start writes it, and the ones after it join the network it describes: the node on
+// the local port of each connects to the nodes in it, and puts itself in it while it runs, so the examples
+// after it can connect to it too. The last one to leave removes the file
+type NetworkDescriptor struct {
+	NetworkID uint64    `json:"networkId"`         // the swarm network id of the nodes
+	Bootnodes []string  `json:"bootnodes"`         // enodes of the nodes running now
+	Genesis   string    `json:"genesis,omitempty"` // hash of the genesis block of the dev chain of the first example, if it runs one
+	Created   time.Time `json:"created"`
+}
+
+// LoadNetwork reads the network descriptor at path
+func LoadNetwork(path string) (*NetworkDescriptor, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var d NetworkDescriptor
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, fmt.Errorf("network descriptor %s: %v", path, err)
+	}
+	return &d, nil
+}
+
+// joins the network of the descriptor, if there is one yet
+// the node gets the nodes in it as static nodes, and the swarm services made after it the network id
+func joinNetwork(cfg *p2p.Config) error {
+	d, err := LoadNetwork(Conf.Network)
+	if os.IsNotExist(err) {
+		Log.Info("no network yet, starting one", "descriptor", Conf.Network)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, bootnode := range d.Bootnodes {
+		n, err := enode.ParseV4(bootnode)
+		if err != nil {
+			return fmt.Errorf("invalid enode in network descriptor: %v", err)
+		}
+		cfg.StaticNodes = append(cfg.StaticNodes, n)
+	}
+	Conf.BzzNetworkId = d.NetworkID
+	Log.Info("joining network", "descriptor", Conf.Network, "nodes", len(d.Bootnodes), "networkid", d.NetworkID)
+	return nil
+}
+
+// updateNetwork changes the descriptor with f, making it if it isn't there yet, and removes it if f leaves no nodes in it
+// the processes sharing it take turns with a lock file next to it
+func updateNetwork(path string, f func(d *NetworkDescriptor)) error {
+	unlock, err := lockNetwork(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	d, err := LoadNetwork(path)
+	if os.IsNotExist(err) {
+		d = &NetworkDescriptor{NetworkID: Conf.BzzNetworkId, Created: time.Now()}
+	} else if err != nil {
+		return err
+	}
+	f(d)
+	if len(d.Bootnodes) == 0 {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	// the others never read half a file
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func lockNetwork(path string) (func(), error) {
+	lock := path + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lock), 0755); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(networkLockWait)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		} else if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(lock); err == nil && time.Since(fi.ModTime()) > networkLockStale {
+			Log.Warn("removing stale network descriptor lock", "path", lock)
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("network descriptor locked by another process")
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+// NoteNetworkGenesis puts the hash of the genesis block of the dev chain in the network descriptor, unless one is there already
+func NoteNetworkGenesis(hash string) error {
+	if Conf.Network == "" {
+		return nil
+	}
+	_, err := LoadNetwork(Conf.Network)
+	if os.IsNotExist(err) {
+		// there are no nodes in the network yet, it's noted when the first one comes
+		networkGenesis = hash
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return updateNetwork(Conf.Network, func(d *NetworkDescriptor) {
+		if d.Genesis == "" {
+			d.Genesis = hash
+		}
+	})
+}
+
+// the genesis noted before there was a descriptor to put it in
+var networkGenesis string
+
+// a service added to the node on the local port with -network, which puts the node in the descriptor while it runs
+type networkService struct {
+	enode string
+}
+
+func registerNetworkService(stack *node.Node) error {
+	return stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		return &networkService{}, nil
+	})
+}
+
+func (s *networkService) Protocols() []p2p.Protocol {
+	return nil
+}
+
+func (s *networkService) APIs() []rpc.API {
+	return nil
+}
+
+func (s *networkService) Start(srv *p2p.Server) error {
+	s.enode = srv.Self().String()
+	return updateNetwork(Conf.Network, func(d *NetworkDescriptor) {
+		if d.Genesis == "" {
+			d.Genesis = networkGenesis
+		}
+		for _, n := range d.Bootnodes {
+			if n == s.enode {
+				return
+			}
+		}
+		d.Bootnodes = append(d.Bootnodes, s.enode)
+	})
+}
+
+func (s *networkService) Stop() error {
+	return updateNetwork(Conf.Network, func(d *NetworkDescriptor) {
+		var nodes []string
+		for _, n := range d.Bootnodes {
+			if n != s.enode {
+				nodes = append(nodes, n)
+			}
+		}
+		d.Bootnodes = nodes
+	})
+}
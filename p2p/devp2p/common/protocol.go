@@ -7,6 +7,8 @@ import (
 	"github.com/ethereum/go-ethereum/p2p/protocols"
 	"github.com/ethereum/go-ethereum/rpc"
 	"time"
+
+	"../envelope"
 )
 
 const (
@@ -71,7 +73,8 @@ func (self *FooService) Protocols() []p2p.Protocol {
 				self.pingC[p.ID()] = make(chan struct{})
 				pingcount := 0
 
-				// create the message structure
+				// a peer sending what it shouldn't is dropped, the reading subroutine reports it here
+				errC := make(chan error, 1)
 
 				// we don't know if we're awaiting anything at the time of the kill so this subroutine will run till the application ends
 				go func() {
@@ -80,15 +83,23 @@ func (self *FooService) Protocols() []p2p.Protocol {
 						msg, err := rw.ReadMsg()
 						if err != nil {
 							Log.Warn("Receive p2p message fail", "err", err)
+							errC <- err
+							break
+						}
+
+						if msg.Size > FooProtocolMaxMsgSize {
+							Log.Warn("p2p message too big", "peer", p, "size", msg.Size)
+							errC <- fmt.Errorf("message of %d bytes, max is %d", msg.Size, FooProtocolMaxMsgSize)
 							break
 						}
 
 						Log.Debug("in pong catch after readmsg")
 						// decode the message and check the contents
 						var decodedmsg FooPingMsg
-						err = msg.Decode(&decodedmsg)
+						err = envelope.DecodeMsg(msg, &decodedmsg)
 						if err != nil {
 							Log.Error("Decode p2p message fail", "err", err)
+							errC <- err
 							break
 						}
 
@@ -102,9 +113,10 @@ func (self *FooService) Protocols() []p2p.Protocol {
 								Pong:    true,
 								Created: time.Now(),
 							}
-							err := p2p.Send(rw, 0, pingmsg)
+							err := envelope.Send(rw, 0, envelope.JSON, pingmsg)
 							if err != nil {
 								Log.Error("Send p2p message fail", "err", err)
+								errC <- err
 								break
 							}
 							Log.Debug("sent pong", "peer", p)
@@ -115,24 +127,26 @@ func (self *FooService) Protocols() []p2p.Protocol {
 				// pings are invoked through the API using a channel
 				// when this channel is closed we quit the protocol
 				for {
-					_, ok := <-self.pingC[p.ID()]
-					if !ok {
-						Log.Debug("break protocol", "peer", p)
-						break
+					select {
+					case _, ok := <-self.pingC[p.ID()]:
+						if !ok {
+							Log.Debug("break protocol", "peer", p)
+							return nil
+						}
+					case err := <-errC:
+						return err
 					}
 					pingmsg := &FooPingMsg{
 						Pong:    false,
 						Created: time.Now(),
 					}
-					err := p2p.Send(rw, 0, pingmsg)
+					err := envelope.Send(rw, 0, envelope.JSON, pingmsg)
 					if err != nil {
 						return fmt.Errorf("Send p2p message fail: %v", err)
 					}
 					pingcount++
 					Log.Info("sending ping", "peer", p, "count", pingcount)
 				}
-
-				return nil
 			},
 		},
 	}
@@ -0,0 +1,310 @@
+package common
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+)
+
+const (
+	// the code of PssMsg in the pss protocol
+	pssMsgCode = 0
+
+	// the flags in the control byte of a PssMsg
+	pssControlSym = 1
+	pssControlRaw = 1 << 1
+
+	// the messages remembered, so one that comes from more than one peer is counted once
+	pssStatsSeen = 1024
+)
+
+// PssTopicStats are the counts of the messages on a topic
+type PssTopicStats struct {
+	Sent          uint64 `json:"sent"`
+	SendErrors    uint64 `json:"sendErrors"`
+	Received      uint64 `json:"received"` // a message counts once for every handler it's passed to
+	DecryptFailed uint64 `json:"decryptFailed"`
+	HandlerErrors uint64 `json:"handlerErrors"`
+}
+
+// PssStats wraps pss, and counts the messages sent and received with it by topic
+//
+// it takes the place of pss as the service of the node, and adds the pssstat rpc namespace to its apis.
+// The messages are counted when they are sent with its Send methods, and received by the handlers registered with its Register methods.
+//
+// pss doesn't tell when it can't open a message, so the wrapper looks at the messages coming in from the peers itself:
+// one that could be for this node, on a topic with a handler, that neither the key of the node nor a symmetric key set
+// through the wrapper opens, counts as a decrypt failure. Symmetric keys set over the pss rpc aren't known to it
+type PssStats struct {
+	*pss.Pss
+	key *ecdsa.PrivateKey
+
+	mu      sync.Mutex
+	topics  map[pss.Topic]*PssTopicStats
+	symkeys [][]byte // the symmetric keys pss tries on incoming messages, those of any topic
+	seen    map[common.Hash]bool
+}
+
+// NewPssStats wraps the pss, which has the private key
+func NewPssStats(ps *pss.Pss, key *ecdsa.PrivateKey) *PssStats {
+	return &PssStats{
+		Pss:    ps,
+		key:    key,
+		topics: make(map[pss.Topic]*PssTopicStats),
+		seen:   make(map[common.Hash]bool),
+	}
+}
+
+// the counts of the topic
+// must be called with the lock held
+func (s *PssStats) topic(topic pss.Topic) *PssTopicStats {
+	st, ok := s.topics[topic]
+	if !ok {
+		st = &PssTopicStats{}
+		s.topics[topic] = st
+	}
+	return st
+}
+
+func (s *PssStats) count(topic pss.Topic, f func(st *PssTopicStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s.topic(topic))
+}
+
+func (s *PssStats) sent(topic pss.Topic, err error) error {
+	s.count(topic, func(st *PssTopicStats) {
+		if err != nil {
+			st.SendErrors++
+		} else {
+			st.Sent++
+		}
+	})
+	return err
+}
+
+func (s *PssStats) SendAsym(pubkeyid string, topic pss.Topic, msg []byte) error {
+	return s.sent(topic, s.Pss.SendAsym(pubkeyid, topic, msg))
+}
+
+func (s *PssStats) SendSym(symkeyid string, topic pss.Topic, msg []byte) error {
+	return s.sent(topic, s.Pss.SendSym(symkeyid, topic, msg))
+}
+
+func (s *PssStats) SendRaw(address pss.PssAddress, topic pss.Topic, msg []byte) error {
+	return s.sent(topic, s.Pss.SendRaw(address, topic, msg))
+}
+
+// the handler, counting what it gets and the errors it returns
+func (s *PssStats) handler(topic pss.Topic, f pss.HandlerFunc) pss.HandlerFunc {
+	return func(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+		err := f(msg, p, asymmetric, keyid)
+		s.count(topic, func(st *PssTopicStats) {
+			st.Received++
+			if err != nil {
+				st.HandlerErrors++
+			}
+		})
+		return err
+	}
+}
+
+// Register registers the handler on the topic with pss, and returns the function that deregisters it
+func (s *PssStats) Register(topic *pss.Topic, f pss.HandlerFunc) func() {
+	s.count(*topic, func(*PssTopicStats) {})
+	return s.Pss.Register(topic, pss.NewHandler(s.handler(*topic, f)))
+}
+
+// RegisterRaw is Register for a handler that takes raw messages
+func (s *PssStats) RegisterRaw(topic *pss.Topic, f pss.HandlerFunc) func() {
+	s.count(*topic, func(*PssTopicStats) {})
+	return s.Pss.Register(topic, pss.NewHandler(s.handler(*topic, f)).WithRaw())
+}
+
+// SetSymmetricKey sets the key with pss, and remembers it to tell the messages it opens
+func (s *PssStats) SetSymmetricKey(key []byte, topic pss.Topic, address *pss.PssAddress, addtocache bool) (string, error) {
+	keyid, err := s.Pss.SetSymmetricKey(key, topic, address, addtocache)
+	if err == nil && addtocache {
+		s.mu.Lock()
+		s.symkeys = append(s.symkeys, key)
+		s.mu.Unlock()
+	}
+	return keyid, err
+}
+
+// GenerateSymmetricKey generates a key with pss, and remembers it to tell the messages it opens
+func (s *PssStats) GenerateSymmetricKey(topic pss.Topic, address *pss.PssAddress, addToCache bool) (string, error) {
+	keyid, err := s.Pss.GenerateSymmetricKey(topic, address, addToCache)
+	if err != nil || !addToCache {
+		return keyid, err
+	}
+	key, err := s.Pss.GetSymmetricKey(keyid)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.symkeys = append(s.symkeys, key)
+	s.mu.Unlock()
+	return keyid, nil
+}
+
+// Protocols is the pss protocol, reading the messages from the peers through the wrapper
+func (s *PssStats) Protocols() []p2p.Protocol {
+	protos := s.Pss.Protocols()
+	for i := range protos {
+		run := protos[i].Run
+		protos[i].Run = func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			return run(p, &pssStatsRW{MsgReadWriter: rw, stats: s})
+		}
+	}
+	return protos
+}
+
+// APIs are the apis of pss, and the pssstat namespace
+func (s *PssStats) APIs() []rpc.API {
+	return append(s.Pss.APIs(), rpc.API{
+		Namespace: "pssstat",
+		Version:   "1.0",
+		Service:   &PssStatsAPI{stats: s},
+		Public:    true,
+	})
+}
+
+// looks at a message from a peer, to count it if it's for us and can't be opened
+func (s *PssStats) incoming(msg *pss.PssMsg) {
+	if msg.Payload == nil || len(msg.Control) == 0 || msg.Control[0]&pssControlRaw != 0 {
+		return
+	}
+	// pss drops expired messages without trying
+	if int64(msg.Expire) < time.Now().Unix() {
+		return
+	}
+	// the same test pss makes, a message to a part of our address could be for us
+	local := s.BaseAddr()
+	if len(msg.To) > len(local) || !bytes.Equal(msg.To, local[:len(msg.To)]) {
+		return
+	}
+	topic := pss.Topic(msg.Payload.Topic)
+	s.mu.Lock()
+	_, registered := s.topics[topic]
+	hash := msg.Payload.Hash()
+	if !registered || s.seen[hash] {
+		s.mu.Unlock()
+		return
+	}
+	if len(s.seen) >= pssStatsSeen {
+		s.seen = make(map[common.Hash]bool)
+	}
+	s.seen[hash] = true
+	symkeys := s.symkeys
+	s.mu.Unlock()
+
+	opened := false
+	if msg.Control[0]&pssControlSym != 0 {
+		for _, key := range symkeys {
+			if m, err := msg.Payload.OpenSymmetric(key); err == nil && m.Validate() {
+				opened = true
+				break
+			}
+		}
+	} else if m, err := msg.Payload.OpenAsymmetric(s.key); err == nil && m.Validate() {
+		opened = true
+	}
+	if !opened {
+		s.count(topic, func(st *PssTopicStats) {
+			st.DecryptFailed++
+		})
+	}
+}
+
+// Stats returns the counts by topic, in hex
+func (s *PssStats) Stats() map[string]PssTopicStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[string]PssTopicStats)
+	for topic, st := range s.topics {
+		stats[topic.String()] = *st
+	}
+	return stats
+}
+
+// Summary writes a table of the counts by topic
+func (s *PssStats) Summary(w io.Writer) {
+	WritePssStats(w, s.Stats())
+}
+
+// WritePssStats writes a table of the counts by topic, as PssStats and the pssstat_topics rpc return them
+func WritePssStats(w io.Writer, stats map[string]PssTopicStats) {
+	var topics []string
+	for topic := range stats {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	fmt.Fprintf(w, "%-12s %8s %8s %8s %8s %8s\n", "TOPIC", "SENT", "SENDERR", "RECV", "DECRYPT", "HANDLER")
+	for _, topic := range topics {
+		st := stats[topic]
+		fmt.Fprintf(w, "%-12s %8d %8d %8d %8d %8d\n", topic, st.Sent, st.SendErrors, st.Received, st.DecryptFailed, st.HandlerErrors)
+	}
+}
+
+// passes the messages from the peer on to pss, after the stats have seen them
+type pssStatsRW struct {
+	p2p.MsgReadWriter
+	stats *PssStats
+}
+
+func (rw *pssStatsRW) ReadMsg() (p2p.Msg, error) {
+	msg, err := rw.MsgReadWriter.ReadMsg()
+	if err != nil || msg.Code != pssMsgCode {
+		return msg, err
+	}
+	// the payload can only be read once, pss gets a copy
+	payload, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		return msg, err
+	}
+	msg.Payload = bytes.NewReader(payload)
+	// p2p/protocols wraps the message, with the tracing context
+	var wmsg protocols.WrappedMsg
+	var pssmsg pss.PssMsg
+	if rlp.DecodeBytes(payload, &wmsg) == nil && rlp.DecodeBytes(wmsg.Payload, &pssmsg) == nil {
+		rw.stats.incoming(&pssmsg)
+	}
+	return msg, nil
+}
+
+// PssStatsAPI is the pssstat rpc namespace
+type PssStatsAPI struct {
+	stats *PssStats
+}
+
+// Topics returns the counts of the messages by topic
+func (api *PssStatsAPI) Topics() map[string]PssTopicStats {
+	return api.stats.Stats()
+}
+
+// Topic returns the counts of the messages on the topic
+func (api *PssStatsAPI) Topic(topic pss.Topic) PssTopicStats {
+	return api.stats.Stats()[topic.String()]
+}
+
+// Reset sets all counts back to zero
+func (api *PssStatsAPI) Reset() {
+	api.stats.mu.Lock()
+	defer api.stats.mu.Unlock()
+	for topic := range api.stats.topics {
+		api.stats.topics[topic] = &PssTopicStats{}
+	}
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/swarm"
+	bzzapi "github.com/ethereum/go-ethereum/swarm/api"
+
+	"./registry"
+)
+
+// RegisterService makes the service constructor known by the name, for ComposeServices
+// the services it needs are put on the stack before it; see the registry package, which the example packages register with
+func RegisterService(name string, ctor node.ServiceConstructor, needs ...string) {
+	registry.Register(name, ctor, needs...)
+}
+
+// ServiceNames returns the names of the registered services, sorted
+func ServiceNames() []string {
+	return registry.Names()
+}
+
+// ComposeServices registers the services on the stack, after those they need, each once
+// it returns the names in the order they were registered
+func ComposeServices(stack *node.Node, names []string) ([]string, error) {
+	return registry.Compose(stack, names)
+}
+
+// the services of this package
+func init() {
+	RegisterService("foo", func(*node.ServiceContext) (node.Service, error) {
+		return NewFooService(), nil
+	})
+	// swarm with pss, on the network of the config, and a bzz port as far from the configured one as the p2p port is from the default
+	RegisterService("swarm", func(ctx *node.ServiceContext) (node.Service, error) {
+		privkey, err := registry.SwarmKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		bzzconfig := bzzapi.NewConfig()
+		bzzconfig.Path = ctx.ResolvePath("bzz")
+		bzzconfig.NetworkID = Conf.BzzNetworkId
+		Conf.Pss.Apply(bzzconfig.Pss)
+		bzzconfig.Init(privkey)
+		bzzconfig.Port = fmt.Sprintf("%d", Conf.BzzPort+Conf.P2PPort-P2pPort)
+		return swarm.NewSwarm(bzzconfig, nil)
+	})
+}
@@ -0,0 +1,169 @@
+// Package registry is where the example packages register the services they offer, for a node stack made of any of them
+//
+// A package registers its service by name when it is imported, with the services it needs, and a main
+// puts together those it is asked for with Compose, which registers the needed ones on the stack first.
+// demo/common registers its own services here, and its RegisterService is the same as Register, but the
+// example packages can't import demo/common, which parses the flags and sets up the logging when imported.
+package registry
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/swarm"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+)
+
+var (
+	mu       sync.RWMutex
+	services = make(map[string]*service)
+)
+
+type service struct {
+	ctor  node.ServiceConstructor
+	needs []string
+}
+
+// Register makes the service constructor known by the name, for Compose
+// the services it needs are put on the stack before it, so it can get them with ServiceContext.Service
+// it is meant to be called from init, so registering a name twice is a mistake and panics, like database/sql.Register
+func Register(name string, ctor node.ServiceConstructor, needs ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if name == "" || ctor == nil {
+		panic("register service without a name or constructor")
+	}
+	if _, ok := services[name]; ok {
+		panic(fmt.Sprintf("service %s registered twice", name))
+	}
+	services[name] = &service{ctor: ctor, needs: needs}
+}
+
+// Names returns the names of the registered services, sorted
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return names()
+}
+
+// must be called with the lock held
+func names() []string {
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Needs returns the services the service needs, as it was registered
+func Needs(name string) []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if s, ok := services[name]; ok {
+		return s.needs
+	}
+	return nil
+}
+
+// Compose registers the services on the stack, after those they need, each once
+// it returns the names in the order they were registered
+func Compose(stack *node.Node, names []string) ([]string, error) {
+	order, err := Resolve(names)
+	if err != nil {
+		return nil, err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, name := range order {
+		if err := stack.Register(services[name].ctor); err != nil {
+			return nil, fmt.Errorf("service %s register fail: %v", name, err)
+		}
+	}
+	return order, nil
+}
+
+// Resolve puts the services and those they need in the order they can be registered in, the needed ones first
+func Resolve(want []string) ([]string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	var order []string
+	done := make(map[string]bool)
+	visiting := make(map[string]bool)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		if done[name] {
+			return nil
+		}
+		s, ok := services[name]
+		if !ok {
+			if len(path) > 0 {
+				return fmt.Errorf("unknown service '%s', needed by %s", name, path[len(path)-1])
+			}
+			return fmt.Errorf("unknown service '%s', the services are %s", name, strings.Join(names(), ", "))
+		}
+		if visiting[name] {
+			return fmt.Errorf("services need each other: %s", strings.Join(append(path, name), " -> "))
+		}
+		visiting[name] = true
+		for _, need := range s.needs {
+			if err := visit(need, append(path, name)); err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		done[name] = true
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range want {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// SwarmKey returns the private key of the swarm service of the stack, kept in its instance directory
+// pss uses it too, so the services talking over pss need it for their identity
+func SwarmKey(ctx *node.ServiceContext) (*ecdsa.PrivateKey, error) {
+	path := ctx.ResolvePath("bzzkey")
+	key, err := crypto.LoadECDSA(path)
+	if err == nil {
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("load key fail: %v", err)
+	}
+	if key, err = crypto.GenerateKey(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := crypto.SaveECDSA(path, key); err != nil {
+		return nil, fmt.Errorf("save key fail: %v", err)
+	}
+	return key, nil
+}
+
+// SwarmPss returns the pss of the swarm service of the stack
+// swarm doesn't give it out, but the pss api it serves is made of it
+func SwarmPss(ctx *node.ServiceContext) (*pss.Pss, error) {
+	var sw *swarm.Swarm
+	if err := ctx.Service(&sw); err != nil {
+		return nil, fmt.Errorf("no swarm service on the stack: %v", err)
+	}
+	for _, api := range sw.APIs() {
+		if papi, ok := api.Service.(*pss.API); ok {
+			return papi.Pss, nil
+		}
+	}
+	return nil, fmt.Errorf("swarm service without pss")
+}
@@ -0,0 +1,113 @@
+package registry
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// a service doing nothing, of its own type so the stack takes several
+type nopService struct{}
+
+func (nopService) Protocols() []p2p.Protocol { return nil }
+func (nopService) APIs() []rpc.API           { return nil }
+func (nopService) Start(*p2p.Server) error   { return nil }
+func (nopService) Stop() error               { return nil }
+
+type nopA struct{ nopService }
+type nopB struct{ nopService }
+type nopC struct{ nopService }
+
+// registers the test services, after the ones of the test before are gone
+func reset() {
+	services = make(map[string]*service)
+	Register("a", func(*node.ServiceContext) (node.Service, error) { return &nopA{}, nil })
+	Register("b", func(*node.ServiceContext) (node.Service, error) { return &nopB{}, nil }, "a")
+	Register("c", func(*node.ServiceContext) (node.Service, error) { return &nopC{}, nil }, "b", "a")
+}
+
+func TestResolve(t *testing.T) {
+	reset()
+	for _, test := range []struct {
+		want  []string
+		order []string
+	}{
+		{[]string{"a"}, []string{"a"}},
+		{[]string{"b"}, []string{"a", "b"}},
+		{[]string{"c"}, []string{"a", "b", "c"}},
+		{[]string{"c", "a", "c"}, []string{"a", "b", "c"}},
+		{nil, nil},
+	} {
+		order, err := Resolve(test.want)
+		if err != nil {
+			t.Fatalf("%v: %v", test.want, err)
+		}
+		if !reflect.DeepEqual(order, test.order) {
+			t.Fatalf("%v in order %v, want %v", test.want, order, test.order)
+		}
+	}
+	if names := Names(); !reflect.DeepEqual(names, []string{"a", "b", "c"}) {
+		t.Fatalf("names %v", names)
+	}
+	if needs := Needs("c"); !reflect.DeepEqual(needs, []string{"b", "a"}) {
+		t.Fatalf("c needs %v", needs)
+	}
+}
+
+func TestResolveFail(t *testing.T) {
+	reset()
+	Register("d", func(*node.ServiceContext) (node.Service, error) { return nopService{}, nil }, "missing")
+	Register("e", func(*node.ServiceContext) (node.Service, error) { return nopService{}, nil }, "f")
+	Register("f", func(*node.ServiceContext) (node.Service, error) { return nopService{}, nil }, "e")
+	for want, msg := range map[string]string{
+		"x": "unknown service 'x'",
+		"d": "needed by d",
+		"e": "e -> f -> e",
+	} {
+		_, err := Resolve([]string{want})
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%s: error %v, want %q", want, err, msg)
+		}
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	reset()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic")
+		}
+	}()
+	Register("a", func(*node.ServiceContext) (node.Service, error) { return &nopA{}, nil })
+}
+
+func TestCompose(t *testing.T) {
+	reset()
+	stack, err := node.New(&node.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := Compose(stack, []string{"b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(order, []string{"a", "b"}) {
+		t.Fatalf("order %v", order)
+	}
+	if err := stack.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Stop()
+	var a *nopA
+	if err := stack.Service(&a); err != nil {
+		t.Fatal(err)
+	}
+	var c *nopC
+	if err := stack.Service(&c); err == nil {
+		t.Fatal("service c not asked for is on the stack")
+	}
+}
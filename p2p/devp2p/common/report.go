@@ -0,0 +1,324 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Report holds the facts of a run of an example, to be written out as JSON and markdown when it ends
+//
+// with -report the nodes made by NewServiceNode and NewServer are followed for their peers and messages,
+// and the errors logged are kept. The example can add counts and durations of its own
+type Report struct {
+	Example   string            `json:"example"`
+	Args      []string          `json:"args"`
+	Started   time.Time         `json:"started"`
+	Finished  time.Time         `json:"finished"`
+	Elapsed   string            `json:"elapsed"`
+	Outcome   string            `json:"outcome"` // ok, or crit when the example died of a critical error
+	Nodes     []*ReportNode     `json:"nodes"`
+	Counts    map[string]int64  `json:"counts,omitempty"`
+	Durations map[string]string `json:"durations,omitempty"`
+	Errors    []*ReportError    `json:"errors,omitempty"`
+
+	mu      sync.Mutex
+	servers map[*ReportNode]p2pServer
+	written bool
+}
+
+// ReportNode is what the report knows of a node
+type ReportNode struct {
+	Name          string `json:"name"`
+	ID            string `json:"id,omitempty"`
+	Enode         string `json:"enode,omitempty"`
+	PeersAdded    int    `json:"peersAdded"`
+	PeersDropped  int    `json:"peersDropped"`
+	MsgsSent      int    `json:"msgsSent"`
+	MsgsReceived  int    `json:"msgsReceived"`
+	BytesSent     uint64 `json:"bytesSent"`
+	BytesReceived uint64 `json:"bytesReceived"`
+}
+
+// ReportError is an error logged during the run
+type ReportError struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	Msg   string    `json:"msg"`
+	Ctx   string    `json:"ctx,omitempty"`
+}
+
+// the servers are only asked for their enode when the report is written, as they may not have been running when they were added
+type p2pServer interface {
+	Self() *enode.Node
+}
+
+var (
+	// RunReport is the report of the running example, nil without -report or -golden
+	RunReport *Report
+)
+
+func newReport() *Report {
+	return &Report{
+		Example:   strings.TrimSuffix(filepath.Base(os.Args[0]), filepath.Ext(os.Args[0])),
+		Args:      os.Args[1:],
+		Started:   time.Now(),
+		Outcome:   "ok",
+		Counts:    make(map[string]int64),
+		Durations: make(map[string]string),
+		servers:   make(map[*ReportNode]p2pServer),
+	}
+}
+
+// Count adds n to the count under the name
+func (r *Report) Count(name string, n int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Counts[name] += n
+}
+
+// Duration records how long something named took
+func (r *Report) Duration(name string, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Durations[name] = d.String()
+}
+
+// WatchServer adds the node of the server to the report, and follows its peers and messages
+// the server needs EnableMsgEvents for the messages to be counted, and may be started after
+func (r *Report) WatchServer(name string, srv *p2p.Server) {
+	if r == nil {
+		return
+	}
+	n := &ReportNode{Name: name}
+	r.mu.Lock()
+	r.Nodes = append(r.Nodes, n)
+	r.servers[n] = srv
+	r.mu.Unlock()
+
+	// the events of a server that isn't running yet can be subscribed to all the same
+	eventC := make(chan *p2p.PeerEvent, 64)
+	sub := srv.SubscribeEvents(eventC)
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case ev := <-eventC:
+				r.event(n, ev)
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+}
+
+func (r *Report) event(n *ReportNode, ev *p2p.PeerEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var size uint64
+	if ev.MsgSize != nil {
+		size = uint64(*ev.MsgSize)
+	}
+	switch ev.Type {
+	case p2p.PeerEventTypeAdd:
+		n.PeersAdded++
+	case p2p.PeerEventTypeDrop:
+		n.PeersDropped++
+	case p2p.PeerEventTypeMsgSend:
+		n.MsgsSent++
+		n.BytesSent += size
+	case p2p.PeerEventTypeMsgRecv:
+		n.MsgsReceived++
+		n.BytesReceived += size
+	}
+}
+
+// Log implements log.Handler, keeping the errors and critical errors
+// a critical error ends the program right after it's logged, so the report is written then
+func (r *Report) Log(rec *log.Record) error {
+	if rec.Lvl > log.LvlError {
+		return nil
+	}
+	var ctx []string
+	for i := 0; i+1 < len(rec.Ctx); i += 2 {
+		ctx = append(ctx, fmt.Sprintf("%v=%v", rec.Ctx[i], rec.Ctx[i+1]))
+	}
+	r.mu.Lock()
+	r.Errors = append(r.Errors, &ReportError{
+		Time:  rec.Time,
+		Level: rec.Lvl.String(),
+		Msg:   rec.Msg,
+		Ctx:   strings.Join(ctx, " "),
+	})
+	if rec.Lvl == log.LvlCrit {
+		r.Outcome = "crit"
+	}
+	r.mu.Unlock()
+	if rec.Lvl == log.LvlCrit {
+		WriteReport()
+	}
+	return nil
+}
+
+// a service added to the service nodes with -report, -tui or tracing, which is how they get to the p2p server of the node
+type watchService struct {
+	name string
+}
+
+func (s *watchService) Protocols() []p2p.Protocol {
+	return nil
+}
+
+func (s *watchService) APIs() []rpc.API {
+	return nil
+}
+
+func (s *watchService) Start(srv *p2p.Server) error {
+	RunReport.WatchServer(s.name, srv)
+	RunDashboard.WatchServer(s.name, srv)
+	traceServer(s.name, srv)
+	return nil
+}
+
+func (s *watchService) Stop() error {
+	return nil
+}
+
+func registerWatchService(stack *node.Node, name string) error {
+	return stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		return &watchService{name: name}, nil
+	})
+}
+
+// WriteReport writes the report of the run to the report directory, as <example>-<time>.json and .md,
+// and with -golden compares it with the golden file of the example, ending the program with exit status 1 if it differs
+// examples should defer it at the start of main; it does nothing without -report or -golden, and only runs once
+func WriteReport() {
+	r := RunReport
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.written {
+		return
+	}
+	r.written = true
+	r.Finished = time.Now()
+	r.Elapsed = r.Finished.Sub(r.Started).Round(time.Millisecond).String()
+	for n, srv := range r.servers {
+		if self := srv.Self(); self != nil {
+			n.ID = self.ID().String()
+			n.Enode = self.String()
+		}
+	}
+
+	if Conf.Golden != "" {
+		defer func() {
+			ok, err := checkGolden(r, Conf.Golden, Conf.GoldenUpdate, os.Stderr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "golden fail: %v\n", err)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+		}()
+	}
+	if Conf.Report == "" {
+		return
+	}
+
+	// errors go straight to stderr, the logs may be what brought us here
+	if err := os.MkdirAll(Conf.Report, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "report fail: %v\n", err)
+		return
+	}
+	base := filepath.Join(Conf.Report, fmt.Sprintf("%s-%s", r.Example, r.Started.Format("20060102-150405")))
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(base+".json", data, 0644)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(base+".md", r.markdown(), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "report fail: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "report written to %s.json and %s.md\n", base, base)
+}
+
+func (r *Report) markdown() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Run of %s\n\n", r.Example)
+	fmt.Fprintf(&b, "* args: `%s`\n", strings.Join(r.Args, " "))
+	fmt.Fprintf(&b, "* started: %s\n", r.Started.Format(time.RFC3339))
+	fmt.Fprintf(&b, "* elapsed: %s\n", r.Elapsed)
+	fmt.Fprintf(&b, "* outcome: %s\n", r.Outcome)
+
+	if len(r.Nodes) > 0 {
+		fmt.Fprintf(&b, "\n## Nodes\n\n")
+		fmt.Fprintf(&b, "| node | id | peers added | peers dropped | msgs sent | msgs received | bytes sent | bytes received |\n")
+		fmt.Fprintf(&b, "|---|---|---|---|---|---|---|---|\n")
+		for _, n := range r.Nodes {
+			id := n.ID
+			if len(id) > 16 {
+				id = id[:16]
+			}
+			fmt.Fprintf(&b, "| %s | `%s` | %d | %d | %d | %d | %d | %d |\n", n.Name, id, n.PeersAdded, n.PeersDropped, n.MsgsSent, n.MsgsReceived, n.BytesSent, n.BytesReceived)
+		}
+		fmt.Fprintf(&b, "\n")
+		for _, n := range r.Nodes {
+			if n.Enode != "" {
+				fmt.Fprintf(&b, "* %s: `%s`\n", n.Name, n.Enode)
+			}
+		}
+	}
+	if len(r.Counts) > 0 {
+		fmt.Fprintf(&b, "\n## Counts\n\n")
+		for _, k := range sortedKeys64(r.Counts) {
+			fmt.Fprintf(&b, "* %s: %d\n", k, r.Counts[k])
+		}
+	}
+	if len(r.Durations) > 0 {
+		fmt.Fprintf(&b, "\n## Durations\n\n")
+		for _, k := range sortedKeys(r.Durations) {
+			fmt.Fprintf(&b, "* %s: %s\n", k, r.Durations[k])
+		}
+	}
+	if len(r.Errors) > 0 {
+		fmt.Fprintf(&b, "\n## Errors\n\n")
+		for _, e := range r.Errors {
+			fmt.Fprintf(&b, "* %s %s %s `%s`\n", e.Time.Format("15:04:05.000"), strings.ToUpper(e.Level), e.Msg, e.Ctx)
+		}
+	}
+	return b.Bytes()
+}
+
+func sortedKeys64(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
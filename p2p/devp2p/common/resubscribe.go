@@ -0,0 +1,242 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// the notifications remembered, to drop the ones that come again after a reconnect
+	resubSeen = 1024
+
+	// the notifications waiting between the subscription and the channel of the example
+	resubBuffer = 16
+)
+
+var errUnsubscribed = errors.New("unsubscribed")
+
+// Resubscription is an rpc subscription that is made again when the connection to the node drops
+//
+// the notifications go to the channel given to Resubscribe, the same one across reconnects.
+// When the subscription fails, the client is closed and dialed again, with the delays of the Retry policy of the config,
+// and the subscription made anew. A notification that's the same as one of the last ones delivered is dropped,
+// so a message the sender tried again while the connection was down, or one two nodes both deliver, comes once.
+// Notifications sent while the connection was down are lost, the node doesn't keep them
+type Resubscription struct {
+	dial      func() (*rpc.Client, error)
+	namespace string
+	args      []interface{}
+	channel   reflect.Value
+	policy    RetryPolicy
+
+	mu         sync.Mutex
+	client     *rpc.Client
+	reconnects int
+	duplicates int
+	seen       map[string]bool
+	order      []string // the keys of seen, oldest first
+
+	quitC chan struct{}
+	doneC chan struct{}
+	errC  chan error
+	once  sync.Once
+}
+
+// Resubscribe dials the node and subscribes, like rpc.Client.Subscribe, and keeps the subscription up until Unsubscribe
+// dial returns a new client to the node every time it's called, like node.Node.Attach or a DialRPC of its endpoint.
+// The client is the subscription's own, Client returns it for calls between reconnects
+func Resubscribe(dial func() (*rpc.Client, error), namespace string, channel interface{}, args ...interface{}) (*Resubscription, error) {
+	ch := reflect.ValueOf(channel)
+	if ch.Kind() != reflect.Chan || ch.Type().ChanDir()&reflect.SendDir == 0 {
+		return nil, fmt.Errorf("channel must be a writable channel, not %T", channel)
+	}
+	s := &Resubscription{
+		dial:      dial,
+		namespace: namespace,
+		args:      args,
+		channel:   ch,
+		policy:    Conf.Retry,
+		seen:      make(map[string]bool),
+		quitC:     make(chan struct{}),
+		doneC:     make(chan struct{}),
+		errC:      make(chan error, 1),
+	}
+	client, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	sub, notifyC, err := s.subscribe(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	s.client = client
+	go s.loop(sub, notifyC)
+	return s, nil
+}
+
+// subscribes with the client, to a channel of its own
+// a subscription that failed may still deliver what it had, so every subscription gets a new channel
+func (s *Resubscription) subscribe(client *rpc.Client) (*rpc.ClientSubscription, reflect.Value, error) {
+	notifyC := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, s.channel.Type().Elem()), resubBuffer)
+	sub, err := client.Subscribe(context.Background(), s.namespace, notifyC.Interface(), s.args...)
+	return sub, notifyC, err
+}
+
+func (s *Resubscription) loop(sub *rpc.ClientSubscription, notifyC reflect.Value) {
+	defer close(s.doneC)
+	quit := reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.quitC)}
+	for {
+		cases := []reflect.SelectCase{
+			quit,
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(sub.Err())},
+			{Dir: reflect.SelectRecv, Chan: notifyC},
+		}
+		chosen, v, _ := reflect.Select(cases)
+		switch chosen {
+		case 0:
+			sub.Unsubscribe()
+			s.closeClient()
+			return
+
+		case 1:
+			Log.Warn("subscription dropped, reconnecting", "namespace", s.namespace, "err", v.Interface())
+			sub.Unsubscribe()
+			var err error
+			sub, notifyC, err = s.reconnect()
+			if err == errUnsubscribed {
+				return
+			} else if err != nil {
+				Log.Error("resubscribe fail", "namespace", s.namespace, "err", err)
+				s.errC <- err
+				return
+			}
+
+		case 2:
+			if !s.fresh(v) {
+				continue
+			}
+			cases := []reflect.SelectCase{
+				quit,
+				{Dir: reflect.SelectSend, Chan: s.channel, Send: v},
+			}
+			if chosen, _, _ := reflect.Select(cases); chosen == 0 {
+				sub.Unsubscribe()
+				s.closeClient()
+				return
+			}
+		}
+	}
+}
+
+// dials again and subscribes, as many times as the retry policy says
+func (s *Resubscription) reconnect() (*rpc.ClientSubscription, reflect.Value, error) {
+	s.closeClient()
+	delay := time.Duration(s.policy.Delay) * time.Millisecond
+	maxDelay := time.Duration(s.policy.MaxDelay) * time.Millisecond
+	var err error
+	for attempt := 1; attempt <= s.policy.Attempts; attempt++ {
+		select {
+		case <-s.quitC:
+			return nil, reflect.Value{}, errUnsubscribed
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if maxDelay > 0 && delay > maxDelay {
+			delay = maxDelay
+		}
+		var client *rpc.Client
+		client, err = s.dial()
+		if err != nil {
+			Log.Debug("redial fail", "namespace", s.namespace, "attempt", attempt, "err", err)
+			continue
+		}
+		sub, notifyC, err := s.subscribe(client)
+		if err != nil {
+			Log.Debug("resubscribe fail", "namespace", s.namespace, "attempt", attempt, "err", err)
+			client.Close()
+			continue
+		}
+		s.mu.Lock()
+		s.client = client
+		s.reconnects++
+		s.mu.Unlock()
+		Log.Info("resubscribed", "namespace", s.namespace, "attempt", attempt)
+		return sub, notifyC, nil
+	}
+	return nil, reflect.Value{}, fmt.Errorf("gave up after %d attempts: %v", s.policy.Attempts, err)
+}
+
+func (s *Resubscription) closeClient() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+}
+
+// tells if the notification wasn't delivered already, and remembers it
+func (s *Resubscription) fresh(v reflect.Value) bool {
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return true
+	}
+	key := string(crypto.Keccak256(b))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen[key] {
+		s.duplicates++
+		return false
+	}
+	if len(s.order) >= resubSeen {
+		delete(s.seen, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.seen[key] = true
+	s.order = append(s.order, key)
+	return true
+}
+
+// Client returns the client of the subscription, nil while it reconnects
+func (s *Resubscription) Client() *rpc.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client
+}
+
+// Reconnects returns how often the subscription was made again
+func (s *Resubscription) Reconnects() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reconnects
+}
+
+// Duplicates returns how many notifications were dropped for having been delivered already
+func (s *Resubscription) Duplicates() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.duplicates
+}
+
+// Err returns the error the subscription ended with, when it couldn't be made again
+// the channel isn't closed on Unsubscribe
+func (s *Resubscription) Err() <-chan error {
+	return s.errC
+}
+
+// Unsubscribe ends the subscription and closes its client
+func (s *Resubscription) Unsubscribe() {
+	s.once.Do(func() {
+		close(s.quitC)
+	})
+	<-s.doneC
+}
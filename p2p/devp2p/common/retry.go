@@ -0,0 +1,67 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// RetryPolicy says how an rpc call that fails is tried again
+type RetryPolicy struct {
+	Attempts int `yaml:"attempts"` // tries in all, the first included
+	Delay    int `yaml:"delay"`    // milliseconds before the first retry, doubled after every retry
+	MaxDelay int `yaml:"maxDelay"` // milliseconds the delay grows to at most
+	Timeout  int `yaml:"timeout"`  // milliseconds a single try may take, 0 for no limit
+}
+
+// the errors that come of the request itself, which are the same however often it's sent
+func permanent(err error) bool {
+	rpcerr, ok := err.(rpc.Error)
+	if !ok {
+		return false
+	}
+	switch rpcerr.ErrorCode() {
+	case -32700, -32600, -32601, -32602: // parse error, invalid request, method not found, invalid params
+		return true
+	}
+	return false
+}
+
+// CallRetry makes the rpc call, trying again with the retry policy of the config when it fails
+//
+// a node that just started may not have its peers or its routing table yet, and the calls that need them fail for a while.
+// Errors in the request itself, like a method that doesn't exist, aren't tried again
+func CallRetry(client *rpc.Client, result interface{}, method string, args ...interface{}) error {
+	return CallRetryContext(context.Background(), Conf.Retry, client, result, method, args...)
+}
+
+// CallRetryContext is CallRetry with a retry policy of its own, giving up when the context is done
+func CallRetryContext(ctx context.Context, policy RetryPolicy, client *rpc.Client, result interface{}, method string, args ...interface{}) error {
+	delay := time.Duration(policy.Delay) * time.Millisecond
+	maxDelay := time.Duration(policy.MaxDelay) * time.Millisecond
+	var err error
+	for attempt := 1; ; attempt++ {
+		callctx, cancel := ctx, context.CancelFunc(func() {})
+		if policy.Timeout > 0 {
+			callctx, cancel = context.WithTimeout(ctx, time.Duration(policy.Timeout)*time.Millisecond)
+		}
+		err = client.CallContext(callctx, result, method, args...)
+		cancel()
+		if err == nil || permanent(err) || attempt >= policy.Attempts {
+			break
+		}
+		Log.Debug("rpc call failed, retrying", "method", method, "attempt", attempt, "delay", delay, "err", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %v (last error: %v)", method, ctx.Err(), err)
+		}
+		delay *= 2
+		if maxDelay > 0 && delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+	return err
+}
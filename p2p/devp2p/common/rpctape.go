@@ -0,0 +1,347 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// an rpc message, as much of it as the tape needs to know
+type rpcMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// RPCTapeEntry is a line of a recording: a message between a client and a node
+type RPCTapeEntry struct {
+	Conn int             `json:"conn"` // the client, by the order they were dialed in
+	Time int64           `json:"t"`    // milliseconds since the recording started
+	Call bool            `json:"call"` // from the client, otherwise from the node
+	Msg  json.RawMessage `json:"msg"`
+}
+
+// RPCTape records the rpc sessions of the clients of DialRPC with -rpc-record, or plays them back with -rpc-replay
+//
+// a recording is a file with a line of json for every message, see RPCTapeEntry.
+// On playback the clients are answered from it, without a node: a call gets the reply to the same call, or when the
+// arguments differ, to the next call of the method. A call made more often than it was recorded gets the last reply again,
+// so an example polling a method till it changes runs the same. The notifications of a subscription follow right after
+// the reply to the subscribe, without the waits between them
+type RPCTape struct {
+	started time.Time
+	replay  bool
+
+	mu    sync.Mutex
+	file  *os.File // when recording
+	conns int
+	calls map[int][]*rpcExchange // conn -> the calls, when replaying
+}
+
+// a call and what the node answered to it
+type rpcExchange struct {
+	method        string
+	params        json.RawMessage
+	reply         json.RawMessage
+	notifications []json.RawMessage
+	used          bool
+}
+
+var (
+	// the tape of the running example, nil without -rpc-record or -rpc-replay
+	runTape *RPCTape
+
+	// the proxy sockets get names of their own
+	tapeSockets int
+)
+
+// DialRPC attaches to the node at the IPC endpoint
+// with -rpc-record the session is recorded, with -rpc-replay it's played back and the endpoint isn't used
+func DialRPC(endpoint string) (*rpc.Client, error) {
+	if runTape == nil {
+		return rpc.Dial(endpoint)
+	}
+	return runTape.Dial(endpoint)
+}
+
+// Replaying tells whether the rpc sessions are played back, in which case an example doesn't need to start its nodes
+func Replaying() bool {
+	return runTape != nil && runTape.replay
+}
+
+// RecordRPC starts a recording to the file at path
+func RecordRPC(path string) (*RPCTape, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &RPCTape{started: time.Now(), file: f}, nil
+}
+
+// ReplayRPC loads the recording at path for playing back
+func ReplayRPC(path string) (*RPCTape, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &RPCTape{started: time.Now(), replay: true, calls: make(map[int][]*rpcExchange)}
+	pending := make(map[string]*rpcExchange)       // conn and id -> the call waiting for its reply
+	subscriptions := make(map[string]*rpcExchange) // conn and subscription id -> the subscribe call
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry RPCTapeEntry
+		var msg rpcMessage
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+		if err := json.Unmarshal(entry.Msg, &msg); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+		key := fmt.Sprintf("%d %s", entry.Conn, msg.ID)
+		switch {
+		case entry.Call:
+			x := &rpcExchange{method: msg.Method, params: compactJSON(msg.Params)}
+			t.calls[entry.Conn] = append(t.calls[entry.Conn], x)
+			pending[key] = x
+		case strings.HasSuffix(msg.Method, "_subscription"):
+			var params struct {
+				Subscription string `json:"subscription"`
+			}
+			json.Unmarshal(msg.Params, &params)
+			if x := subscriptions[fmt.Sprintf("%d %s", entry.Conn, params.Subscription)]; x != nil {
+				x.notifications = append(x.notifications, entry.Msg)
+			}
+		case pending[key] != nil:
+			x := pending[key]
+			delete(pending, key)
+			x.reply = entry.Msg
+			var id string
+			if strings.HasSuffix(x.method, "_subscribe") && json.Unmarshal(msg.Result, &id) == nil {
+				subscriptions[fmt.Sprintf("%d %s", entry.Conn, id)] = x
+			}
+		}
+	}
+	return t, nil
+}
+
+// Dial attaches a client to the node at the endpoint, through the tape
+// the client talks to a socket of the tape's own, which passes the messages on to the node and records them,
+// or answers them from the recording
+func (t *RPCTape) Dial(endpoint string) (*rpc.Client, error) {
+	t.mu.Lock()
+	conn := t.conns
+	t.conns++
+	tapeSockets++
+	path := IPCPath(os.TempDir(), fmt.Sprintf("rpctape-%d-%d.ipc", os.Getpid(), tapeSockets))
+	t.mu.Unlock()
+
+	ln, err := ListenIPC(path)
+	if err != nil {
+		return nil, err
+	}
+	// the client connects once, the socket isn't needed after that
+	connC := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			close(connC)
+			return
+		}
+		connC <- c
+	}()
+	client, err := rpc.Dial(path)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	c, ok := <-connC
+	if !ok {
+		return nil, fmt.Errorf("tape socket closed")
+	}
+	if t.replay {
+		go t.play(conn, c)
+		return client, nil
+	}
+	upstream, err := dialIPC(endpoint)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	go t.pipe(conn, c, upstream, true)
+	go t.pipe(conn, upstream, c, false)
+	return client, nil
+}
+
+// passes the messages from one side on to the other, recording them
+func (t *RPCTape) pipe(conn int, from net.Conn, to net.Conn, call bool) {
+	defer from.Close()
+	defer to.Close()
+	dec := json.NewDecoder(from)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return
+		}
+		// the client sends a batch as an array, the messages in it are recorded one by one
+		// and before they're passed on, so a call is always recorded before its reply
+		var msgs []json.RawMessage
+		if json.Unmarshal(raw, &msgs) != nil {
+			msgs = []json.RawMessage{raw}
+		}
+		for _, msg := range msgs {
+			t.record(conn, call, msg)
+		}
+		if _, err := to.Write(append(raw, '\n')); err != nil {
+			return
+		}
+	}
+}
+
+func (t *RPCTape) record(conn int, call bool, msg json.RawMessage) {
+	line, err := json.Marshal(&RPCTapeEntry{
+		Conn: conn,
+		Time: int64(time.Since(t.started) / time.Millisecond),
+		Call: call,
+		Msg:  compactJSON(msg),
+	})
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.file.Write(append(line, '\n')); err != nil {
+		Log.Error("rpc recording fail", "err", err)
+	}
+}
+
+// answers the client from the recording
+func (t *RPCTape) play(conn int, c net.Conn) {
+	defer c.Close()
+	dec := json.NewDecoder(c)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return
+		}
+		var msgs []json.RawMessage
+		batch := json.Unmarshal(raw, &msgs) == nil
+		if !batch {
+			msgs = []json.RawMessage{raw}
+		}
+		var replies, notifications []json.RawMessage
+		for _, m := range msgs {
+			var msg rpcMessage
+			json.Unmarshal(m, &msg)
+			x := t.lookup(conn, msg.Method, compactJSON(msg.Params))
+			if x == nil {
+				replies = append(replies, rpcErrorReply(msg.ID, fmt.Sprintf("%s isn't in the recording", msg.Method)))
+				continue
+			}
+			replies = append(replies, withID(x.reply, msg.ID))
+			notifications = append(notifications, x.notifications...)
+		}
+		var out []byte
+		if batch {
+			out, _ = json.Marshal(replies)
+		} else {
+			out = replies[0]
+		}
+		if _, err := c.Write(append(out, '\n')); err != nil {
+			return
+		}
+		for _, n := range notifications {
+			if _, err := c.Write(append(n, '\n')); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// the recorded call to answer with: the next one with the same arguments, else the next one of the method,
+// else the last one of the method, preferring the same arguments again
+func (t *RPCTape) lookup(conn int, method string, params json.RawMessage) *rpcExchange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var sameMethod, lastSame, lastMethod *rpcExchange
+	for _, x := range t.calls[conn] {
+		if x.method != method || x.reply == nil {
+			continue
+		}
+		same := bytes.Equal(x.params, params)
+		if !x.used && same {
+			x.used = true
+			return x
+		}
+		if !x.used && sameMethod == nil {
+			sameMethod = x
+		}
+		if x.used && same {
+			lastSame = x
+		}
+		if x.used {
+			lastMethod = x
+		}
+	}
+	switch {
+	case sameMethod != nil:
+		sameMethod.used = true
+		return sameMethod
+	case lastSame != nil:
+		return lastSame
+	}
+	return lastMethod
+}
+
+// the message with the id replaced
+func withID(msg json.RawMessage, id json.RawMessage) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(msg, &fields) != nil {
+		return msg
+	}
+	fields["id"] = id
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return msg
+	}
+	return out
+}
+
+func rpcErrorReply(id json.RawMessage, message string) json.RawMessage {
+	out, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"error":   map[string]interface{}{"code": -32601, "message": message},
+	})
+	return out
+}
+
+// json with the spaces taken out, so the same values compare the same
+func compactJSON(raw json.RawMessage) json.RawMessage {
+	var b bytes.Buffer
+	if json.Compact(&b, raw) != nil {
+		return raw
+	}
+	return b.Bytes()
+}
+
+// Close ends the recording
+func (t *RPCTape) Close() error {
+	if t == nil || t.file == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.file.Close()
+}
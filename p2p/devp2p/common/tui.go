@@ -0,0 +1,223 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	dashboardInterval = time.Second
+	dashboardEvents   = 8   // the peer events shown
+	dashboardLogs     = 200 // the log lines kept, as many as fit are shown
+)
+
+// Dashboard shows the nodes of the running example in the terminal, with -tui
+//
+// it redraws the screen every second with a table of the nodes, their peers and the messages they send and receive,
+// the last peer events, and the last lines of the log, which go there instead of to stderr.
+// Nodes are added by NewServer and NewServiceNode
+type Dashboard struct {
+	out     io.Writer
+	started time.Time
+
+	mu      sync.Mutex
+	nodes   []*dashboardNode
+	events  []string
+	logs    []string
+	partial []byte // a log line not ended yet
+}
+
+type dashboardNode struct {
+	name                 string
+	srv                  *p2p.Server
+	sent, received       uint64
+	lastSent, lastRecv   uint64 // the counts at the last redraw, for the rates
+	bytesSent, bytesRecv uint64
+}
+
+var (
+	// RunDashboard is the dashboard of the running example, nil without -tui
+	RunDashboard *Dashboard
+)
+
+// starts redrawing the dashboard on the writer
+func startDashboard(out io.Writer) *Dashboard {
+	d := &Dashboard{
+		out:     out,
+		started: time.Now(),
+	}
+	go func() {
+		ticker := time.NewTicker(dashboardInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			d.Draw()
+		}
+	}()
+	return d
+}
+
+// WatchServer adds the server to the dashboard, and follows its peers and messages
+// a service node gets a new server each time it starts, which takes the place of the one before
+func (d *Dashboard) WatchServer(name string, srv *p2p.Server) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	var n *dashboardNode
+	for _, dn := range d.nodes {
+		if dn.name == name {
+			n = dn
+		}
+	}
+	if n == nil {
+		n = &dashboardNode{name: name}
+		d.nodes = append(d.nodes, n)
+	}
+	n.srv = srv
+	d.mu.Unlock()
+
+	eventC := make(chan *p2p.PeerEvent, 64)
+	sub := srv.SubscribeEvents(eventC)
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case ev := <-eventC:
+				d.event(n, ev)
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+}
+
+func (d *Dashboard) event(n *dashboardNode, ev *p2p.PeerEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var size uint64
+	if ev.MsgSize != nil {
+		size = uint64(*ev.MsgSize)
+	}
+	switch ev.Type {
+	case p2p.PeerEventTypeMsgSend:
+		n.sent++
+		n.bytesSent += size
+	case p2p.PeerEventTypeMsgRecv:
+		n.received++
+		n.bytesRecv += size
+	case p2p.PeerEventTypeAdd:
+		d.addEvent(fmt.Sprintf("%s %s added peer %s", time.Now().Format("15:04:05"), n.name, ev.Peer.TerminalString()))
+	case p2p.PeerEventTypeDrop:
+		d.addEvent(fmt.Sprintf("%s %s dropped peer %s: %s", time.Now().Format("15:04:05"), n.name, ev.Peer.TerminalString(), ev.Error))
+	}
+}
+
+func (d *Dashboard) addEvent(s string) {
+	d.events = append(d.events, s)
+	if len(d.events) > dashboardEvents {
+		d.events = d.events[len(d.events)-dashboardEvents:]
+	}
+}
+
+// Write takes the log lines, which the dashboard shows instead of them going to stderr
+func (d *Dashboard) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.partial = append(d.partial, b...)
+	for {
+		i := bytes.IndexByte(d.partial, '\n')
+		if i < 0 {
+			break
+		}
+		d.logs = append(d.logs, string(d.partial[:i]))
+		d.partial = d.partial[i+1:]
+	}
+	if len(d.logs) > dashboardLogs {
+		d.logs = d.logs[len(d.logs)-dashboardLogs:]
+	}
+	return len(b), nil
+}
+
+// Log implements log.Handler, drawing the dashboard right away on a critical error
+// since that ends the program before the next redraw
+func (d *Dashboard) Log(rec *log.Record) error {
+	if rec.Lvl == log.LvlCrit {
+		d.Draw()
+	}
+	return nil
+}
+
+// Draw redraws the dashboard
+func (d *Dashboard) Draw() {
+	width, height := 100, 30
+	if f, ok := d.out.(*os.File); ok && terminal.IsTerminal(int(f.Fd())) {
+		if w, h, err := terminal.GetSize(int(f.Fd())); err == nil {
+			width, height = w, h
+		}
+	}
+
+	// the peer counts are asked of the servers before taking the lock, their events come in with it
+	d.mu.Lock()
+	nodes := append([]*dashboardNode{}, d.nodes...)
+	srvs := make([]*p2p.Server, len(nodes))
+	for i, n := range nodes {
+		srvs[i] = n.srv
+	}
+	d.mu.Unlock()
+	peers := make([]string, len(nodes))
+	for i, srv := range srvs {
+		peers[i] = "-"
+		// a server that hasn't started yet would never answer
+		if srv != nil && !srv.Self().IP().IsUnspecified() {
+			peers[i] = fmt.Sprintf("%d", srv.PeerCount())
+		}
+	}
+
+	d.mu.Lock()
+	var lines []string
+	uptime := time.Since(d.started).Round(time.Second)
+	lines = append(lines, fmt.Sprintf("%s, running %s", strings.TrimSuffix(filepath.Base(os.Args[0]), filepath.Ext(os.Args[0])), uptime), "")
+	lines = append(lines, fmt.Sprintf("%-12s %6s %10s %10s %8s %8s %12s %12s", "NODE", "PEERS", "SENT", "RECEIVED", "OUT/S", "IN/S", "BYTES OUT", "BYTES IN"))
+	for i, n := range nodes {
+		// the rates are over the time since the last redraw, which is about a second
+		rateSent := float64(n.sent-n.lastSent) / dashboardInterval.Seconds()
+		rateRecv := float64(n.received-n.lastRecv) / dashboardInterval.Seconds()
+		n.lastSent, n.lastRecv = n.sent, n.received
+		lines = append(lines, fmt.Sprintf("%-12s %6s %10d %10d %8.1f %8.1f %12d %12d", n.name, peers[i], n.sent, n.received, rateSent, rateRecv, n.bytesSent, n.bytesRecv))
+	}
+	lines = append(lines, "", "EVENTS")
+	lines = append(lines, d.events...)
+	lines = append(lines, "", "LOG")
+	// the log gets the lines that are left
+	logs := d.logs
+	if room := height - len(lines) - 1; room < len(logs) {
+		if room < 0 {
+			room = 0
+		}
+		logs = logs[len(logs)-room:]
+	}
+	lines = append(lines, logs...)
+	d.mu.Unlock()
+
+	var b bytes.Buffer
+	// to the top left, and clear the screen
+	b.WriteString("\x1b[H\x1b[2J")
+	for _, line := range lines {
+		if len(line) > width {
+			line = line[:width]
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	d.out.Write(b.Bytes())
+}
@@ -0,0 +1,171 @@
+package conformance
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	"../envelope"
+)
+
+var (
+	errTimeout = errors.New("timed out")
+)
+
+// Conn is a connection to the node under test, running the foo protocol
+// pings from the node are answered, pongs are passed on to the test
+type Conn struct {
+	srv   *p2p.Server
+	peer  *p2p.Peer
+	rw    p2p.MsgReadWriter
+	pongC chan *PingMsg
+	errC  chan error
+	quit  chan struct{}
+}
+
+// dials the node with a fresh identity, and waits until the foo protocol runs
+func dial(dest *enode.Node, timeout time.Duration) (*Conn, error) {
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		pongC: make(chan *PingMsg, 64),
+		errC:  make(chan error, 1),
+		quit:  make(chan struct{}),
+	}
+	readyC := make(chan struct{})
+	c.srv = newServer(privkey, p2p.Protocol{
+		Name:    ProtocolName,
+		Version: ProtocolVersion,
+		// one code more than the protocol has, so we can send the node a code it doesn't know
+		Length: ProtocolLength + 1,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			c.peer = p
+			c.rw = rw
+			close(readyC)
+			return c.read()
+		},
+	})
+	err = c.srv.Start()
+	if err != nil {
+		return nil, err
+	}
+	c.srv.AddPeer(dest)
+	select {
+	case <-readyC:
+		return c, nil
+	case <-time.After(timeout):
+		c.srv.Stop()
+		return nil, fmt.Errorf("protocol %s/%d not started within %v", ProtocolName, ProtocolVersion, timeout)
+	}
+}
+
+func newServer(privkey *ecdsa.PrivateKey, proto p2p.Protocol) *p2p.Server {
+	return &p2p.Server{
+		Config: p2p.Config{
+			PrivateKey:  privkey,
+			Name:        "conformance",
+			MaxPeers:    1,
+			NoDiscovery: true,
+			Protocols:   []p2p.Protocol{proto},
+		},
+	}
+}
+
+// the loop reading from the node, until it goes away or we close the connection
+func (c *Conn) read() error {
+	for {
+		msg, err := c.rw.ReadMsg()
+		if err != nil {
+			c.errC <- err
+			return err
+		}
+		var ping PingMsg
+		err = envelope.DecodeMsg(msg, &ping)
+		if err != nil {
+			err = fmt.Errorf("invalid message from the node: %v", err)
+			c.errC <- err
+			return err
+		}
+		if !ping.Pong {
+			err = c.Send(0, &PingMsg{Pong: true, Created: time.Now()})
+			if err != nil {
+				c.errC <- err
+				return err
+			}
+			continue
+		}
+		select {
+		case c.pongC <- &ping:
+		case <-c.quit:
+			return nil
+		}
+	}
+}
+
+// Send sends a message in a JSON envelope
+func (c *Conn) Send(code uint64, v interface{}) error {
+	return envelope.Send(c.rw, code, envelope.JSON, v)
+}
+
+// SendRaw sends the payload as it is
+func (c *Conn) SendRaw(code uint64, payload []byte) error {
+	return p2p.Send(c.rw, code, payload)
+}
+
+// Ping sends a ping
+func (c *Conn) Ping() error {
+	return c.Send(0, &PingMsg{Created: time.Now()})
+}
+
+// ExpectPong waits for the next pong
+func (c *Conn) ExpectPong(timeout time.Duration) (*PingMsg, error) {
+	select {
+	case pong := <-c.pongC:
+		return pong, nil
+	case err := <-c.errC:
+		return nil, fmt.Errorf("disconnected: %v", err)
+	case <-time.After(timeout):
+		return nil, errTimeout
+	}
+}
+
+// ExpectDisconnect waits for the node to drop the connection, which fails if it sends a pong first
+func (c *Conn) ExpectDisconnect(timeout time.Duration) error {
+	select {
+	case <-c.pongC:
+		return errors.New("got a pong")
+	case <-c.errC:
+		return nil
+	case <-time.After(timeout):
+		return errTimeout
+	}
+}
+
+// ExpectSilence makes sure the node sends nothing and stays connected for a while
+func (c *Conn) ExpectSilence(d time.Duration) error {
+	select {
+	case pong := <-c.pongC:
+		return fmt.Errorf("got a pong: %v", pong)
+	case err := <-c.errC:
+		return fmt.Errorf("disconnected: %v", err)
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// Caps returns the capabilities the node announced in the devp2p handshake
+func (c *Conn) Caps() []p2p.Cap {
+	return c.peer.Caps()
+}
+
+func (c *Conn) Close() {
+	close(c.quit)
+	c.srv.Stop()
+}
@@ -0,0 +1,111 @@
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Test is a conformance check
+type Test struct {
+	Name string
+	Fn   func(*T)
+}
+
+// T is passed to the tests to report with, like testing.T
+type T struct {
+	mu     sync.Mutex
+	failed bool
+	output bytes.Buffer
+}
+
+func (t *T) Logf(format string, args ...interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(&t.output, format+"\n", args...)
+}
+
+// Errorf reports a failure, and the test goes on
+func (t *T) Errorf(format string, args ...interface{}) {
+	t.Logf(format, args...)
+	t.mu.Lock()
+	t.failed = true
+	t.mu.Unlock()
+}
+
+// Fatalf reports a failure, and ends the test
+func (t *T) Fatalf(format string, args ...interface{}) {
+	t.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+func (t *T) Failed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failed
+}
+
+// Result is the outcome of a test
+type Result struct {
+	Name     string
+	Failed   bool
+	Output   string
+	Duration time.Duration
+}
+
+// RunTests runs the tests one after the other, and writes how they went to the report if it's not nil
+func RunTests(tests []Test, report io.Writer) []Result {
+	if report == nil {
+		report = ioutil.Discard
+	}
+	var results []Result
+	for _, test := range tests {
+		fmt.Fprintf(report, "-- RUN %s\n", test.Name)
+		r := run(test)
+		results = append(results, r)
+		if r.Output != "" {
+			fmt.Fprint(report, r.Output)
+		}
+		status := "OK"
+		if r.Failed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(report, "-- %s %s (%v)\n", status, r.Name, r.Duration.Round(time.Millisecond))
+	}
+	return results
+}
+
+// the test runs in its own goroutine, so Fatalf can end it
+func run(test Test) Result {
+	t := &T{}
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		test.Fn(t)
+	}()
+	<-done
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Result{
+		Name:     test.Name,
+		Failed:   t.failed,
+		Output:   t.output.String(),
+		Duration: time.Since(start),
+	}
+}
+
+// CountFailures returns the number of failed tests
+func CountFailures(results []Result) int {
+	n := 0
+	for _, r := range results {
+		if r.Failed {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,237 @@
+// Package conformance checks that a node speaks the foo protocol of the examples as it should
+//
+// it connects to the node over devp2p like any other peer, so it works with implementations in any language
+//
+// the protocol it checks is the ping-pong of the service node examples:
+//
+//   - the capability is fooping/666, with one message code, 0
+//   - a message is a JSON envelope (see the envelope package) with {"Pong": bool, "Created": time}
+//   - a ping (Pong false) is answered with a pong (Pong true), in the order the pings came in
+//   - a pong gets no answer
+//   - a message bigger than 1024 bytes, a message that isn't a JSON envelope, and a message code other than 0
+//     make the node drop the connection
+package conformance
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	"../envelope"
+)
+
+const (
+	ProtocolName    = "fooping"
+	ProtocolVersion = 666
+	ProtocolLength  = 1
+	MaxMsgSize      = 1024
+
+	DefaultTimeout = time.Second * 5
+
+	// the pings sent back to back in the ordering check
+	orderingPings = 32
+)
+
+// PingMsg is the message of the protocol
+type PingMsg struct {
+	Pong    bool
+	Created time.Time
+}
+
+// a ping with padding, to make it the size we want
+type paddedPingMsg struct {
+	Pong    bool
+	Created time.Time
+	Padding string
+}
+
+// Suite runs the checks against a node
+type Suite struct {
+	Dest    *enode.Node
+	Timeout time.Duration
+}
+
+func NewSuite(dest *enode.Node) *Suite {
+	return &Suite{
+		Dest:    dest,
+		Timeout: DefaultTimeout,
+	}
+}
+
+// AllTests returns all the checks, in the order they should be run
+func (s *Suite) AllTests() []Test {
+	return []Test{
+		{"Handshake", s.TestHandshake},
+		{"PingPong", s.TestPingPong},
+		{"Ordering", s.TestOrdering},
+		{"PongIgnored", s.TestPongIgnored},
+		{"SizeLimit", s.TestSizeLimit},
+		{"InvalidMessage", s.TestInvalidMessage},
+		{"UnknownCode", s.TestUnknownCode},
+	}
+}
+
+func (s *Suite) dial(t *T) *Conn {
+	c, err := dial(s.Dest, s.Timeout)
+	if err != nil {
+		t.Fatalf("connect fail: %v", err)
+	}
+	return c
+}
+
+// the node completes the devp2p handshake, announces the protocol and runs it
+func (s *Suite) TestHandshake(t *T) {
+	c := s.dial(t)
+	defer c.Close()
+	var caps []string
+	found := false
+	for _, cap := range c.Caps() {
+		caps = append(caps, cap.String())
+		if cap.Name == ProtocolName && cap.Version == ProtocolVersion {
+			found = true
+		}
+	}
+	t.Logf("node caps: %s", strings.Join(caps, ", "))
+	if !found {
+		t.Errorf("%s/%d not announced", ProtocolName, ProtocolVersion)
+	}
+
+	// a node must not drop a peer that has done nothing wrong
+	err := c.ExpectSilence(time.Second)
+	if err != nil {
+		t.Errorf("idle connection: %v", err)
+	}
+}
+
+// a ping is answered with a pong
+func (s *Suite) TestPingPong(t *T) {
+	c := s.dial(t)
+	defer c.Close()
+	err := c.Ping()
+	if err != nil {
+		t.Fatalf("send ping fail: %v", err)
+	}
+	pong, err := c.ExpectPong(s.Timeout)
+	if err != nil {
+		t.Fatalf("no pong: %v", err)
+	}
+	if pong.Created.IsZero() {
+		t.Errorf("pong has no creation time")
+	}
+}
+
+// pings sent back to back are all answered, in order
+func (s *Suite) TestOrdering(t *T) {
+	c := s.dial(t)
+	defer c.Close()
+	for i := 0; i < orderingPings; i++ {
+		err := c.Ping()
+		if err != nil {
+			t.Fatalf("send ping %d fail: %v", i, err)
+		}
+	}
+	var last time.Time
+	for i := 0; i < orderingPings; i++ {
+		pong, err := c.ExpectPong(s.Timeout)
+		if err != nil {
+			t.Fatalf("pong %d of %d: %v", i+1, orderingPings, err)
+		}
+		if pong.Created.Before(last) {
+			t.Errorf("pong %d created before the one before it", i+1)
+		}
+		last = pong.Created
+	}
+	err := c.ExpectSilence(time.Second)
+	if err != nil {
+		t.Errorf("after the pongs: %v", err)
+	}
+}
+
+// a pong isn't answered, and doesn't break anything
+func (s *Suite) TestPongIgnored(t *T) {
+	c := s.dial(t)
+	defer c.Close()
+	err := c.Send(0, &PingMsg{Pong: true, Created: time.Now()})
+	if err != nil {
+		t.Fatalf("send pong fail: %v", err)
+	}
+	err = c.ExpectSilence(time.Second)
+	if err != nil {
+		t.Fatalf("after unsolicited pong: %v", err)
+	}
+	err = c.Ping()
+	if err != nil {
+		t.Fatalf("send ping fail: %v", err)
+	}
+	_, err = c.ExpectPong(s.Timeout)
+	if err != nil {
+		t.Errorf("no pong after unsolicited pong: %v", err)
+	}
+}
+
+// a ping within the size limit is answered, a bigger one gets the connection dropped
+func (s *Suite) TestSizeLimit(t *T) {
+	for _, size := range []int{MaxMsgSize - 64, MaxMsgSize + 64} {
+		msg, err := paddedPing(size)
+		if err != nil {
+			t.Fatalf("make %d byte ping fail: %v", size, err)
+		}
+		c := s.dial(t)
+		err = c.Send(0, msg)
+		if err != nil {
+			c.Close()
+			t.Fatalf("send %d byte ping fail: %v", size, err)
+		}
+		if size <= MaxMsgSize {
+			_, err = c.ExpectPong(s.Timeout)
+		} else {
+			err = c.ExpectDisconnect(s.Timeout)
+		}
+		if err != nil {
+			t.Errorf("%d byte ping: %v", size, err)
+		}
+		c.Close()
+	}
+}
+
+// a ping which is size bytes on the wire, which is the rlp string of the envelope
+func paddedPing(size int) (*paddedPingMsg, error) {
+	msg := &paddedPingMsg{Created: time.Now()}
+	b, err := envelope.Wrap(envelope.JSON, msg)
+	if err != nil {
+		return nil, err
+	}
+	// rlp puts 3 bytes in front of strings from 256 to 65535 bytes long
+	msg.Padding = strings.Repeat("x", size-len(b)-3)
+	return msg, nil
+}
+
+// a message that isn't a JSON envelope gets the connection dropped
+func (s *Suite) TestInvalidMessage(t *T) {
+	c := s.dial(t)
+	defer c.Close()
+	err := c.SendRaw(0, []byte("not an envelope"))
+	if err != nil {
+		t.Fatalf("send fail: %v", err)
+	}
+	err = c.ExpectDisconnect(s.Timeout)
+	if err != nil {
+		t.Errorf("invalid message: %v", err)
+	}
+}
+
+// a message code the protocol doesn't have gets the connection dropped
+// devp2p itself does this for codes beyond the protocol's length, so only a broken stack fails here
+func (s *Suite) TestUnknownCode(t *T) {
+	c := s.dial(t)
+	defer c.Close()
+	err := c.Send(ProtocolLength, &PingMsg{Created: time.Now()})
+	if err != nil {
+		t.Fatalf("send fail: %v", err)
+	}
+	err = c.ExpectDisconnect(s.Timeout)
+	if err != nil {
+		t.Errorf("unknown message code: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package conformance
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	"../envelope"
+)
+
+// a node running the foo protocol, which skips the size check if told to
+func startNode(t *testing.T, checkSize bool) (*p2p.Server, *enode.Node) {
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(privkey, p2p.Protocol{
+		Name:    ProtocolName,
+		Version: ProtocolVersion,
+		Length:  ProtocolLength,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				if checkSize && msg.Size > MaxMsgSize {
+					return fmt.Errorf("message too big")
+				}
+				var ping PingMsg
+				err = envelope.DecodeMsg(msg, &ping)
+				if err != nil {
+					return err
+				}
+				if ping.Pong {
+					continue
+				}
+				err = envelope.Send(rw, 0, envelope.JSON, &PingMsg{Pong: true, Created: time.Now()})
+				if err != nil {
+					return err
+				}
+			}
+		},
+	})
+	srv.ListenAddr = "127.0.0.1:0"
+	srv.MaxPeers = 8
+	err = srv.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, srv.Self()
+}
+
+func runSuite(t *testing.T, checkSize bool) map[string]bool {
+	srv, node := startNode(t, checkSize)
+	defer srv.Stop()
+	s := NewSuite(node)
+	s.Timeout = time.Second * 2
+	var report io.Writer
+	if testing.Verbose() {
+		report = os.Stderr
+	}
+	failed := make(map[string]bool)
+	for _, r := range RunTests(s.AllTests(), report) {
+		failed[r.Name] = r.Failed
+	}
+	return failed
+}
+
+func TestConforming(t *testing.T) {
+	for name, failed := range runSuite(t, true) {
+		if failed {
+			t.Errorf("%s failed", name)
+		}
+	}
+}
+
+func TestNoSizeLimit(t *testing.T) {
+	failed := runSuite(t, false)
+	if !failed["SizeLimit"] {
+		t.Error("missing size check not caught")
+	}
+	if failed["PingPong"] {
+		t.Error("PingPong failed")
+	}
+}
@@ -0,0 +1,249 @@
+// Package contact is a card with what another node needs to peer with a node and send to it over pss, to carry between machines
+//
+// the card holds the enode of the node, its pss public key and overlay address, and the topics it takes messages on.
+// It's made from a running node with FromNode, and encoded as compact JSON, or as a vCard, which phones and
+// address books keep; either can be shown as a QR code on the terminal with WriteQR and scanned on the other machine.
+// Import connects the node there to the node of the card, and adds its key for the topics of the card
+package contact
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+)
+
+const (
+	// the longest overlay address, a swarm address is 32 bytes
+	maxAddrLen = 32
+
+	vcardBegin = "BEGIN:VCARD"
+	vcardEnd   = "END:VCARD"
+)
+
+var (
+	ErrNoEnode = errors.New("card without an enode")
+)
+
+// Card is what a node gives out to be peered with
+// the pss key is compressed, so the card fits a smaller QR code
+type Card struct {
+	Name    string        `json:"name,omitempty"`
+	Enode   string        `json:"enode"`
+	PssKey  hexutil.Bytes `json:"pssKey,omitempty"`
+	PssAddr hexutil.Bytes `json:"pssAddr,omitempty"` // the overlay address, or the part of it the node reveals
+	Topics  []pss.Topic   `json:"topics,omitempty"`
+}
+
+// FromNode makes the card of the node at the other end of the client
+// the pss fields are left empty if the node doesn't run pss
+func FromNode(client *rpc.Client, name string, topics ...pss.Topic) (*Card, error) {
+	var info p2p.NodeInfo
+	if err := client.Call(&info, "admin_nodeInfo"); err != nil {
+		return nil, fmt.Errorf("nodeinfo fail: %v", err)
+	}
+	c := &Card{Name: name, Enode: info.Enode, Topics: topics}
+	var key hexutil.Bytes
+	if err := client.Call(&key, "pss_getPublicKey"); err != nil {
+		return c, nil
+	}
+	pub, err := crypto.UnmarshalPubkey(key)
+	if err != nil {
+		return nil, fmt.Errorf("pss key of the node: %v", err)
+	}
+	c.PssKey = crypto.CompressPubkey(pub)
+	if err := client.Call(&c.PssAddr, "pss_baseAddr"); err != nil {
+		return nil, fmt.Errorf("pss address fail: %v", err)
+	}
+	return c, nil
+}
+
+// Validate checks the enode and the key, so a card mistyped or cut short is refused before it's used
+func (c *Card) Validate() error {
+	if c.Enode == "" {
+		return ErrNoEnode
+	}
+	if _, err := enode.ParseV4(c.Enode); err != nil {
+		return fmt.Errorf("invalid enode: %v", err)
+	}
+	if len(c.PssKey) > 0 {
+		if _, err := crypto.DecompressPubkey(c.PssKey); err != nil {
+			return fmt.Errorf("invalid pss key: %v", err)
+		}
+	} else if len(c.PssAddr) > 0 || len(c.Topics) > 0 {
+		return errors.New("pss address or topics without a pss key")
+	}
+	if len(c.PssAddr) > maxAddrLen {
+		return fmt.Errorf("pss address of %d bytes, max is %d", len(c.PssAddr), maxAddrLen)
+	}
+	return nil
+}
+
+// PublicKey is the pss key of the card, uncompressed as pss_setPeerPublicKey takes it
+func (c *Card) PublicKey() (hexutil.Bytes, error) {
+	pub, err := crypto.DecompressPubkey(c.PssKey)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.FromECDSAPub(pub), nil
+}
+
+// Encode is the card as JSON without spaces
+func (c *Card) Encode() (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(c)
+	return string(b), err
+}
+
+// VCard is the card as a vCard 3.0, with the node in extension properties
+func (c *Card) VCard() (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	name := c.Name
+	if name == "" {
+		name = "node"
+	}
+	var b strings.Builder
+	line := func(k, v string) {
+		fmt.Fprintf(&b, "%s:%s\r\n", k, v)
+	}
+	line("BEGIN", "VCARD")
+	line("VERSION", "3.0")
+	line("FN", escape(name))
+	line("X-ENODE", escape(c.Enode))
+	if len(c.PssKey) > 0 {
+		line("X-PSS-KEY", c.PssKey.String())
+	}
+	if len(c.PssAddr) > 0 {
+		line("X-PSS-ADDR", c.PssAddr.String())
+	}
+	for _, t := range c.Topics {
+		line("X-PSS-TOPIC", t.String())
+	}
+	line("END", "VCARD")
+	return b.String(), nil
+}
+
+// Decode reads a card from its JSON or its vCard, and validates it
+func Decode(s string) (*Card, error) {
+	s = strings.TrimSpace(s)
+	var c *Card
+	var err error
+	if strings.HasPrefix(strings.ToUpper(s), vcardBegin) {
+		c, err = decodeVCard(s)
+	} else {
+		c = new(Card)
+		dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid card: %v", err)
+	}
+	return c, c.Validate()
+}
+
+func decodeVCard(s string) (*Card, error) {
+	c := new(Card)
+	ended := false
+	for _, l := range unfold(s) {
+		k, v := l, ""
+		if i := strings.IndexByte(l, ':'); i >= 0 {
+			k, v = l[:i], l[i+1:]
+		}
+		// the parameters of a property, like ;CHARSET=, don't matter here
+		if i := strings.IndexByte(k, ';'); i >= 0 {
+			k = k[:i]
+		}
+		var err error
+		switch strings.ToUpper(k) {
+		case "FN":
+			c.Name = unescape(v)
+		case "X-ENODE":
+			c.Enode = unescape(v)
+		case "X-PSS-KEY":
+			c.PssKey, err = hexutil.Decode(v)
+		case "X-PSS-ADDR":
+			c.PssAddr, err = hexutil.Decode(v)
+		case "X-PSS-TOPIC":
+			var t pss.Topic
+			if err = t.UnmarshalJSON([]byte(`"` + v + `"`)); err == nil {
+				c.Topics = append(c.Topics, t)
+			}
+		case "END":
+			ended = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", k, err)
+		}
+	}
+	if !ended {
+		return nil, errors.New("vcard not ended")
+	}
+	return c, nil
+}
+
+// the lines of a vcard, with the long lines folded over several put back together
+func unfold(s string) []string {
+	var lines []string
+	for _, l := range strings.Split(strings.Replace(s, "\r\n", "\n", -1), "\n") {
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+var (
+	escaper   = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`)
+	unescaper = strings.NewReplacer(`\\`, `\`, `\,`, ",", `\;`, ";", `\n`, "\n", `\N`, "\n")
+)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
+
+// Import peers the node at the other end of the client with the node of the card,
+// and adds the pss key of the card for its topics and any others given, so the node can send to it
+func Import(client *rpc.Client, c *Card, topics ...pss.Topic) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	var ok bool
+	if err := client.Call(&ok, "admin_addPeer", c.Enode); err != nil {
+		return fmt.Errorf("add peer fail: %v", err)
+	}
+	if len(c.PssKey) == 0 {
+		return nil
+	}
+	key, err := c.PublicKey()
+	if err != nil {
+		return err
+	}
+	addr := pss.PssAddress(c.PssAddr)
+	for _, t := range append(append([]pss.Topic{}, c.Topics...), topics...) {
+		if err := client.Call(nil, "pss_setPeerPublicKey", key, t, addr); err != nil {
+			return fmt.Errorf("set pss key fail for topic %v: %v", t, err)
+		}
+	}
+	return nil
+}
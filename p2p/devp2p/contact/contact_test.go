@@ -0,0 +1,207 @@
+package contact
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+)
+
+func newTestCard(t *testing.T) (*Card, *ecdsa.PrivateKey) {
+	nodekey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	psskey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Card{
+		Name:    "alice; at home, on wifi",
+		Enode:   enode.NewV4(&nodekey.PublicKey, []byte{10, 0, 0, 1}, 30100, 0).String(),
+		PssKey:  crypto.CompressPubkey(&psskey.PublicKey),
+		PssAddr: hexutil.Bytes{0x12, 0x34},
+		Topics:  []pss.Topic{pss.BytesToTopic([]byte("chat"))},
+	}, psskey
+}
+
+func TestEncode(t *testing.T) {
+	c, _ := newTestCard(t)
+	s, err := c.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(s, "\n") || strings.Contains(s, `": `) {
+		t.Fatalf("not compact: %s", s)
+	}
+	got, err := Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Fatalf("decoded %+v, want %+v", got, c)
+	}
+}
+
+func TestVCard(t *testing.T) {
+	c, _ := newTestCard(t)
+	s, err := c.VCard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s, "BEGIN:VCARD\r\nVERSION:3.0\r\n") {
+		t.Fatalf("vcard %q", s)
+	}
+	got, err := Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Fatalf("decoded %+v, want %+v", got, c)
+	}
+
+	// as an address book may give it back: lines folded, and parameters on the properties
+	folded := strings.Replace(s, "X-ENODE:", "X-ENODE;CHARSET=UTF-8:", 1)
+	i := strings.Index(folded, "@10.0.0.1")
+	folded = folded[:i] + "\r\n " + folded[i:]
+	if got, err = Decode(folded); err != nil {
+		t.Fatal(err)
+	}
+	if got.Enode != c.Enode {
+		t.Fatalf("enode %s, want %s", got.Enode, c.Enode)
+	}
+}
+
+func TestDecodeFail(t *testing.T) {
+	c, _ := newTestCard(t)
+	s, err := c.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := c.VCard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, bad := range map[string]string{
+		"cut short":       s[:len(s)/2],
+		"no enode":        `{"name":"bob"}`,
+		"bad enode":       strings.Replace(s, "enode://", "enode://ff", 1),
+		"bad key":         strings.Replace(s, `"pssKey":"0x0`, `"pssKey":"0x4`, 1),
+		"unknown field":   strings.Replace(s, `{`, `{"foo":1,`, 1),
+		"key missing":     `{"enode":"` + c.Enode + `","pssAddr":"0x12"}`,
+		"long address":    strings.Replace(s, `"pssAddr":"0x1234"`, `"pssAddr":"0x`+strings.Repeat("00", 33)+`"`, 1),
+		"vcard not ended": strings.Replace(v, "END:VCARD", "", 1),
+	} {
+		if _, err := Decode(bad); err == nil {
+			t.Fatalf("%s: decoded", name)
+		}
+	}
+}
+
+func TestWriteQR(t *testing.T) {
+	c, _ := newTestCard(t)
+	s, err := c.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteQR(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	width := utf8.RuneCountInString(lines[0])
+	// a code is square, with two rows of modules on a line, and a quiet zone of light modules all around
+	if want := (width + 1) / 2; len(lines) != want {
+		t.Fatalf("%d lines of %d modules, want %d", len(lines), width, want)
+	}
+	for i, l := range lines {
+		if utf8.RuneCountInString(l) != width {
+			t.Fatalf("line %d of %d modules, want %d", i, utf8.RuneCountInString(l), width)
+		}
+	}
+	if lines[0] != strings.Repeat("█", width) {
+		t.Fatalf("no quiet zone: %q", lines[0])
+	}
+}
+
+// the admin and pss apis a node serves, as far as cards go
+// exported, as the rpc server only takes exported types
+type AdminAPI struct {
+	info  p2p.NodeInfo
+	peers []string
+}
+
+func (a *AdminAPI) NodeInfo() *p2p.NodeInfo {
+	return &a.info
+}
+
+func (a *AdminAPI) AddPeer(url string) (bool, error) {
+	a.peers = append(a.peers, url)
+	return true, nil
+}
+
+type PssAPI struct {
+	key   *ecdsa.PrivateKey
+	addr  pss.PssAddress
+	peers map[pss.Topic]hexutil.Bytes
+}
+
+func (p *PssAPI) GetPublicKey() hexutil.Bytes {
+	return crypto.FromECDSAPub(&p.key.PublicKey)
+}
+
+func (p *PssAPI) BaseAddr() (pss.PssAddress, error) {
+	return p.addr, nil
+}
+
+func (p *PssAPI) SetPeerPublicKey(pubkey hexutil.Bytes, topic pss.Topic, addr pss.PssAddress) error {
+	p.peers[topic] = append(pubkey, addr...)
+	return nil
+}
+
+func TestFromNodeImport(t *testing.T) {
+	c, psskey := newTestCard(t)
+	admin := &AdminAPI{info: p2p.NodeInfo{Enode: c.Enode}}
+	ps := &PssAPI{key: psskey, addr: pss.PssAddress(c.PssAddr), peers: make(map[pss.Topic]hexutil.Bytes)}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("admin", admin); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterName("pss", ps); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	client := rpc.DialInProc(srv)
+	defer client.Close()
+
+	got, err := FromNode(client, c.Name, c.Topics...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Fatalf("card %+v, want %+v", got, c)
+	}
+
+	other := pss.BytesToTopic([]byte("other"))
+	if err := Import(client, got, other); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(admin.peers, []string{c.Enode}) {
+		t.Fatalf("peers added %v", admin.peers)
+	}
+	want := append(crypto.FromECDSAPub(&psskey.PublicKey), c.PssAddr...)
+	for _, topic := range []pss.Topic{c.Topics[0], other} {
+		if !bytes.Equal(ps.peers[topic], want) {
+			t.Fatalf("topic %v: key and address %x, want %x", topic, ps.peers[topic], want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package contact
+
+import (
+	"bufio"
+	"io"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// the blocks for the two rows of modules a line of the terminal shows, by whether the top one and the bottom one are dark
+// the light modules are drawn, the dark ones left as spaces, for a terminal with light text on a dark background
+var halfBlocks = [2][2]string{
+	{"█", "▀"},
+	{"▄", " "},
+}
+
+// WriteQR writes the text as a QR code made of unicode blocks, two rows of modules to a line of the terminal
+// medium error correction is enough for a screen
+func WriteQR(w io.Writer, text string) error {
+	q, err := qrcode.New(text, qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	// the bitmap has the quiet zone around the code already
+	bitmap := q.Bitmap()
+	bw := bufio.NewWriter(w)
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			top := bitmap[y][x]
+			bottom := false
+			if y+1 < len(bitmap) {
+				bottom = bitmap[y+1][x]
+			}
+			bw.WriteString(halfBlocks[b2i(top)][b2i(bottom)])
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,154 @@
+package dnsdisc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+const (
+	defaultTimeout = time.Second * 5
+)
+
+// Resolver looks up TXT records
+// *net.Resolver implements it
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// NewResolver returns a resolver that asks the DNS server at addr for everything, instead of the system's name servers
+// this is how we get at a tree that is only served locally
+func NewResolver(addr string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", addr)
+		},
+	}
+}
+
+// Client fetches node lists over DNS
+type Client struct {
+	resolver Resolver
+	timeout  time.Duration
+}
+
+// NewClient creates a client using the given resolver
+// with nil the system resolver is used
+func NewClient(resolver Resolver) *Client {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &Client{
+		resolver: resolver,
+		timeout:  defaultTimeout,
+	}
+}
+
+// SyncTree fetches the whole tree at the url, and checks the signature and all the hashes
+// the trees it links to are not fetched
+func (c *Client) SyncTree(url string) (*Tree, error) {
+	le, err := parseLink(url)
+	if err != nil {
+		return nil, err
+	}
+
+	txt, err := c.lookup(le.domain)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseRoot(txt)
+	if err != nil {
+		return nil, err
+	}
+	if !root.verifySignature(le.pubkey) {
+		return nil, errInvalidSig
+	}
+
+	t := &Tree{
+		root:    root,
+		entries: make(map[string]entry),
+	}
+	err = c.syncSubtree(t, le.domain, root.eroot)
+	if err != nil {
+		return nil, err
+	}
+	err = c.syncSubtree(t, le.domain, root.lroot)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Resolve returns the nodes of all the trees at the urls, and of the trees they link to
+// every tree is only fetched once, so links going in circles are fine
+func (c *Client) Resolve(urls ...string) ([]*enode.Node, error) {
+	var nodes []*enode.Node
+	seen := make(map[string]bool)
+	for len(urls) > 0 {
+		url := urls[0]
+		urls = urls[1:]
+		if seen[url] {
+			continue
+		}
+		seen[url] = true
+		t, err := c.SyncTree(url)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", url, err)
+		}
+		nodes = append(nodes, t.Nodes()...)
+		urls = append(urls, t.Links()...)
+	}
+	return nodes, nil
+}
+
+func (c *Client) syncSubtree(t *Tree, domain string, hash string) error {
+	if _, ok := t.entries[hash]; ok {
+		return nil
+	}
+	txt, err := c.lookup(hash + "." + domain)
+	if err != nil {
+		return err
+	}
+
+	// the name of an entry is its hash, so if they don't match someone has tampered with it
+	e, err := parseEntry(txt)
+	if err != nil {
+		return err
+	}
+	if subdomain(e) != hash {
+		return fmt.Errorf("entry at %s.%s doesn't match its hash", hash, domain)
+	}
+	t.entries[hash] = e
+
+	if b, ok := e.(*branchEntry); ok {
+		for _, child := range b.children {
+			err = c.syncSubtree(t, domain, child)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// there may be other TXT records at the same name, we only want the tree entry
+func (c *Client) lookup(name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	txts, err := c.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	for _, txt := range txts {
+		if strings.HasPrefix(txt, "enr") {
+			return txt, nil
+		}
+	}
+	return "", fmt.Errorf("no tree entry at %s", name)
+}
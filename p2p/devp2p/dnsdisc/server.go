@@ -0,0 +1,194 @@
+package dnsdisc
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	dnsHeaderLength = 12
+	dnsTypeTXT      = 16
+	dnsTypeANY      = 255
+	dnsClassIN      = 1
+
+	dnsFlagResponse      = 0x8000
+	dnsFlagAuthoritative = 0x0400
+	dnsFlagRecursion     = 0x0100
+	dnsRcodeNameError    = 3
+
+	// how long resolvers may cache the records, in seconds
+	recordTTL = 60
+)
+
+var (
+	errShortPacket = errors.New("short dns packet")
+)
+
+// Server answers DNS queries for TXT records over UDP
+//
+// it knows only the records it is given, and nothing else
+// that is enough to serve a tree to a client that asks it directly, without publishing it in real DNS
+type Server struct {
+	conn    *net.UDPConn
+	records map[string]string
+	mu      sync.RWMutex
+}
+
+// NewServer creates a server for the records, which are TXT record contents by full name
+func NewServer(records map[string]string) *Server {
+	s := &Server{}
+	s.SetRecords(records)
+	return s
+}
+
+// SetRecords replaces the records served
+func (s *Server) SetRecords(records map[string]string) {
+	lower := make(map[string]string)
+	for name, txt := range records {
+		lower[normalize(name)] = txt
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = lower
+}
+
+// Listen starts answering queries on the UDP address
+func (s *Server) Listen(addr string) error {
+	udpaddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	s.conn, err = net.ListenUDP("udp", udpaddr)
+	if err != nil {
+		return err
+	}
+	go s.serve()
+	return nil
+}
+
+// Addr is the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Server) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, from, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		reply, err := s.handle(buf[:n])
+		if err != nil {
+			log.Debug("bad dns query", "from", from, "err", err)
+			continue
+		}
+		_, err = s.conn.WriteToUDP(reply, from)
+		if err != nil {
+			log.Debug("dns reply fail", "to", from, "err", err)
+		}
+	}
+}
+
+// makes the reply to one query
+//
+// the query has a header, and a question with the name and the type of record asked for
+// the reply repeats them, and adds the answer if we have one
+func (s *Server) handle(query []byte) ([]byte, error) {
+	if len(query) < dnsHeaderLength {
+		return nil, errShortPacket
+	}
+	name, qend, err := readName(query, dnsHeaderLength)
+	if err != nil {
+		return nil, err
+	}
+	if qend+4 > len(query) {
+		return nil, errShortPacket
+	}
+	qtype := binary.BigEndian.Uint16(query[qend:])
+	question := query[dnsHeaderLength : qend+4]
+
+	s.mu.RLock()
+	txt, ok := s.records[normalize(name)]
+	s.mu.RUnlock()
+
+	flags := uint16(dnsFlagResponse | dnsFlagAuthoritative)
+	flags |= binary.BigEndian.Uint16(query[2:]) & dnsFlagRecursion
+	if !ok {
+		flags |= dnsRcodeNameError
+	}
+	var answers uint16
+	if ok && (qtype == dnsTypeTXT || qtype == dnsTypeANY) {
+		answers = 1
+	}
+
+	reply := make([]byte, dnsHeaderLength, 512)
+	copy(reply, query[:2])
+	binary.BigEndian.PutUint16(reply[2:], flags)
+	binary.BigEndian.PutUint16(reply[4:], 1)
+	binary.BigEndian.PutUint16(reply[6:], answers)
+	reply = append(reply, question...)
+	if answers > 0 {
+		reply = appendTXT(reply, txt)
+	}
+	return reply, nil
+}
+
+// the answer refers to the name in the question instead of repeating it
+// the text goes in strings of at most 255 bytes
+func appendTXT(reply []byte, txt string) []byte {
+	var rdata []byte
+	for len(txt) > 0 {
+		n := len(txt)
+		if n > 255 {
+			n = 255
+		}
+		rdata = append(rdata, byte(n))
+		rdata = append(rdata, txt[:n]...)
+		txt = txt[n:]
+	}
+	rr := make([]byte, 12)
+	binary.BigEndian.PutUint16(rr[0:], 0xc000|dnsHeaderLength)
+	binary.BigEndian.PutUint16(rr[2:], dnsTypeTXT)
+	binary.BigEndian.PutUint16(rr[4:], dnsClassIN)
+	binary.BigEndian.PutUint32(rr[6:], recordTTL)
+	binary.BigEndian.PutUint16(rr[10:], uint16(len(rdata)))
+	reply = append(reply, rr...)
+	return append(reply, rdata...)
+}
+
+// reads the name in the question, which is a list of labels prefixed by their length, ending with an empty one
+func readName(packet []byte, offset int) (string, int, error) {
+	var labels []string
+	for {
+		if offset >= len(packet) {
+			return "", 0, errShortPacket
+		}
+		n := int(packet[offset])
+		offset++
+		if n == 0 {
+			break
+		}
+		if n > 63 || offset+n > len(packet) {
+			return "", 0, errors.New("invalid name in dns query")
+		}
+		labels = append(labels, string(packet[offset:offset+n]))
+		offset += n
+	}
+	return strings.Join(labels, "."), offset, nil
+}
+
+// names are case insensitive, and may or may not end with the root dot
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,359 @@
+// Package dnsdisc implements the node lists of EIP-1459
+//
+// a node list is a tree of entries, published as DNS TXT records under a domain
+// the root of the tree is signed, and every other entry is found under the hash of its content
+// so whoever knows the domain and the public key of the signer can get the nodes and trust them
+package dnsdisc
+
+import (
+	"crypto/ecdsa"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	rootPrefix   = "enrtree-root:v1"
+	branchPrefix = "enrtree-branch:"
+	enrPrefix    = "enr:"
+	linkPrefix   = "enrtree://"
+
+	// the hashes of the entries are the first 16 bytes of their keccak256 hash
+	hashLength = 16
+
+	// a TXT record should stay below 370 bytes, for the DNS reply to fit in a UDP packet
+	// a branch holds as many hashes as fit within that
+	maxChildren = 370 / (26 + 1)
+)
+
+var (
+	b32format = base32.StdEncoding.WithPadding(base32.NoPadding)
+	b64format = base64.RawURLEncoding
+
+	errUnknownEntry = errors.New("unknown entry type")
+	errNoPubkey     = errors.New("missing public key")
+	errBadPubkey    = errors.New("invalid public key")
+	errInvalidSig   = errors.New("invalid root signature")
+	errInvalidChild = errors.New("invalid child hash")
+)
+
+// the entries in the tree
+type entry interface {
+	fmt.Stringer
+}
+
+type (
+	rootEntry struct {
+		eroot string // hash of the top of the node subtree
+		lroot string // hash of the top of the link subtree
+		seq   uint
+		sig   []byte
+	}
+	branchEntry struct {
+		children []string
+	}
+	enrEntry struct {
+		node *enode.Node
+	}
+	linkEntry struct {
+		str    string
+		domain string
+		pubkey *ecdsa.PublicKey
+	}
+)
+
+// Tree is a signed node list
+type Tree struct {
+	root    *rootEntry
+	entries map[string]entry
+}
+
+// MakeTree builds a node list of the given nodes, and the links to other lists
+//
+// the nodes must have signed records, like the ones from p2p.Server.Self() or enode.SignV4
+// the tree still has to be signed before it can be published
+func MakeTree(seq uint, nodes []*enode.Node, links []string) (*Tree, error) {
+	records := make([]entry, 0, len(nodes))
+	for _, n := range nodes {
+		// nodes made from enode urls have an empty signature, which the clients would refuse
+		if err := n.Record().VerifySignature(enode.ValidSchemes); err != nil {
+			return nil, fmt.Errorf("node %s has no valid signed record: %v", n.ID(), err)
+		}
+		records = append(records, &enrEntry{node: n})
+	}
+	// the same nodes always give the same tree
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].String() < records[j].String()
+	})
+
+	var linkentries []entry
+	for _, l := range links {
+		le, err := parseLink(l)
+		if err != nil {
+			return nil, err
+		}
+		linkentries = append(linkentries, le)
+	}
+
+	t := &Tree{entries: make(map[string]entry)}
+	eroot := t.build(records)
+	t.entries[subdomain(eroot)] = eroot
+	lroot := t.build(linkentries)
+	t.entries[subdomain(lroot)] = lroot
+	t.root = &rootEntry{
+		eroot: subdomain(eroot),
+		lroot: subdomain(lroot),
+		seq:   seq,
+	}
+	return t, nil
+}
+
+// puts the entries under branches of at most maxChildren, and returns the top of the subtree
+func (t *Tree) build(entries []entry) entry {
+	if len(entries) == 1 {
+		return entries[0]
+	}
+	if len(entries) <= maxChildren {
+		b := &branchEntry{}
+		for _, e := range entries {
+			sd := subdomain(e)
+			b.children = append(b.children, sd)
+			t.entries[sd] = e
+		}
+		return b
+	}
+	var subtrees []entry
+	for len(entries) > 0 {
+		n := maxChildren
+		if len(entries) < n {
+			n = len(entries)
+		}
+		sub := t.build(entries[:n])
+		entries = entries[n:]
+		subtrees = append(subtrees, sub)
+		t.entries[subdomain(sub)] = sub
+	}
+	return t.build(subtrees)
+}
+
+// Sign signs the root of the tree, and returns the enrtree:// url the tree can be found with on the domain
+func (t *Tree) Sign(key *ecdsa.PrivateKey, domain string) (string, error) {
+	sig, err := crypto.Sign(t.root.sigHash(), key)
+	if err != nil {
+		return "", err
+	}
+	t.root.sig = sig
+	return makeURL(&key.PublicKey, domain), nil
+}
+
+// Seq is the sequence number of the tree, which should be increased every time the tree is republished
+func (t *Tree) Seq() uint {
+	return t.root.seq
+}
+
+// Nodes returns the nodes in the tree
+func (t *Tree) Nodes() []*enode.Node {
+	var nodes []*enode.Node
+	for _, e := range t.entries {
+		if ee, ok := e.(*enrEntry); ok {
+			nodes = append(nodes, ee.node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID().String() < nodes[j].ID().String()
+	})
+	return nodes
+}
+
+// Links returns the urls of the other trees this one links to
+func (t *Tree) Links() []string {
+	var links []string
+	for _, e := range t.entries {
+		if le, ok := e.(*linkEntry); ok {
+			links = append(links, le.str)
+		}
+	}
+	sort.Strings(links)
+	return links
+}
+
+// ToTXT returns the TXT records of the tree, by the full name they should be published at
+func (t *Tree) ToTXT(domain string) map[string]string {
+	records := map[string]string{domain: t.root.String()}
+	for sd, e := range t.entries {
+		records[sd+"."+domain] = e.String()
+	}
+	return records
+}
+
+// the hash of an entry, which is also the name of the subdomain it is published at
+func subdomain(e entry) string {
+	h := crypto.Keccak256([]byte(e.String()))
+	return b32format.EncodeToString(h[:hashLength])
+}
+
+func makeURL(pubkey *ecdsa.PublicKey, domain string) string {
+	return linkPrefix + b32format.EncodeToString(crypto.CompressPubkey(pubkey)) + "@" + domain
+}
+
+// the signature covers the root record without the signature itself
+func (e *rootEntry) sigHash() []byte {
+	return crypto.Keccak256([]byte(fmt.Sprintf("%s e=%s l=%s seq=%d", rootPrefix, e.eroot, e.lroot, e.seq)))
+}
+
+func (e *rootEntry) verifySignature(pubkey *ecdsa.PublicKey) bool {
+	if len(e.sig) != 65 {
+		return false
+	}
+	return crypto.VerifySignature(crypto.FromECDSAPub(pubkey), e.sigHash(), e.sig[:64])
+}
+
+func (e *rootEntry) String() string {
+	return fmt.Sprintf("%s e=%s l=%s seq=%d sig=%s", rootPrefix, e.eroot, e.lroot, e.seq, b64format.EncodeToString(e.sig))
+}
+
+func (e *branchEntry) String() string {
+	return branchPrefix + strings.Join(e.children, ",")
+}
+
+func (e *enrEntry) String() string {
+	enc, _ := rlp.EncodeToBytes(e.node.Record())
+	return enrPrefix + b64format.EncodeToString(enc)
+}
+
+func (e *linkEntry) String() string {
+	return e.str
+}
+
+// ENR returns the text form of the node's record, as used in the tree
+func ENR(n *enode.Node) string {
+	return (&enrEntry{node: n}).String()
+}
+
+// ParseENR returns the node of a record in text form
+func ParseENR(s string) (*enode.Node, error) {
+	if !strings.HasPrefix(s, enrPrefix) {
+		return nil, fmt.Errorf("invalid record '%s', must start with %s", s, enrPrefix)
+	}
+	e, err := parseEntry(s)
+	if err != nil {
+		return nil, err
+	}
+	return e.(*enrEntry).node, nil
+}
+
+// ParseURL returns the domain and public key of an enrtree:// url
+func ParseURL(url string) (string, *ecdsa.PublicKey, error) {
+	le, err := parseLink(url)
+	if err != nil {
+		return "", nil, err
+	}
+	return le.domain, le.pubkey, nil
+}
+
+func parseLink(s string) (*linkEntry, error) {
+	if !strings.HasPrefix(s, linkPrefix) {
+		return nil, fmt.Errorf("invalid url '%s', must start with %s", s, linkPrefix)
+	}
+	i := strings.IndexByte(s, '@')
+	if i == -1 {
+		return nil, errNoPubkey
+	}
+	keystring, domain := s[len(linkPrefix):i], s[i+1:]
+	keybytes, err := b32format.DecodeString(keystring)
+	if err != nil {
+		return nil, errBadPubkey
+	}
+	pubkey, err := crypto.DecompressPubkey(keybytes)
+	if err != nil {
+		return nil, errBadPubkey
+	}
+	return &linkEntry{str: s, domain: domain, pubkey: pubkey}, nil
+}
+
+func parseRoot(s string) (*rootEntry, error) {
+	var e rootEntry
+	fields := strings.Fields(s)
+	if len(fields) != 5 || fields[0] != rootPrefix {
+		return nil, fmt.Errorf("invalid root '%s'", s)
+	}
+	for _, f := range fields[1:] {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid root field '%s'", f)
+		}
+		var err error
+		switch kv[0] {
+		case "e":
+			e.eroot, err = parseHash(kv[1])
+		case "l":
+			e.lroot, err = parseHash(kv[1])
+		case "seq":
+			var seq uint64
+			seq, err = strconv.ParseUint(kv[1], 10, 64)
+			e.seq = uint(seq)
+		case "sig":
+			e.sig, err = b64format.DecodeString(kv[1])
+		default:
+			err = fmt.Errorf("unknown root field '%s'", kv[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &e, nil
+}
+
+// parses the entries below the root
+func parseEntry(s string) (entry, error) {
+	switch {
+	case strings.HasPrefix(s, linkPrefix):
+		return parseLink(s)
+	case strings.HasPrefix(s, branchPrefix):
+		var e branchEntry
+		for _, c := range strings.Split(s[len(branchPrefix):], ",") {
+			if c == "" {
+				continue
+			}
+			h, err := parseHash(c)
+			if err != nil {
+				return nil, err
+			}
+			e.children = append(e.children, h)
+		}
+		return &e, nil
+	case strings.HasPrefix(s, enrPrefix):
+		enc, err := b64format.DecodeString(s[len(enrPrefix):])
+		if err != nil {
+			return nil, err
+		}
+		var r enr.Record
+		err = rlp.DecodeBytes(enc, &r)
+		if err != nil {
+			return nil, err
+		}
+		n, err := enode.New(enode.ValidSchemes, &r)
+		if err != nil {
+			return nil, err
+		}
+		return &enrEntry{node: n}, nil
+	}
+	return nil, errUnknownEntry
+}
+
+func parseHash(s string) (string, error) {
+	h, err := b32format.DecodeString(s)
+	if err != nil || len(h) < 12 || len(h) > 32 {
+		return "", errInvalidChild
+	}
+	return s, nil
+}
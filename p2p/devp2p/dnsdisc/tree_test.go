@@ -0,0 +1,154 @@
+package dnsdisc
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+)
+
+func testNodes(t *testing.T, count int) []*enode.Node {
+	var nodes []*enode.Node
+	for i := 0; i < count; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r enr.Record
+		r.Set(enr.IP(net.IP{127, 0, 0, 1}))
+		r.Set(enr.TCP(30100 + i))
+		r.Set(enr.UDP(30100 + i))
+		err = enode.SignV4(&r, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := enode.New(enode.ValidSchemes, &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func testTree(t *testing.T, nodes []*enode.Node, links []string, domain string) (*Tree, string) {
+	tree, err := MakeTree(1, nodes, links)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := tree.Sign(key, domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree, url
+}
+
+func serve(t *testing.T, records map[string]string) (*Server, *Client) {
+	srv := NewServer(records)
+	err := srv.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, NewClient(NewResolver(srv.Addr().String()))
+}
+
+// more nodes than fit in one branch, so the tree gets several levels
+func TestSyncTree(t *testing.T) {
+	nodes := testNodes(t, maxChildren*2+1)
+	tree, url := testTree(t, nodes, nil, "nodes.example.org")
+	srv, client := serve(t, tree.ToTXT("nodes.example.org"))
+	defer srv.Close()
+
+	synced, err := client.SyncTree(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synced.Seq() != 1 {
+		t.Fatalf("wrong seq %d", synced.Seq())
+	}
+	got := synced.Nodes()
+	want := tree.Nodes()
+	if len(got) != len(want) {
+		t.Fatalf("got %d nodes, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID() != want[i].ID() || got[i].TCP() != want[i].TCP() {
+			t.Fatalf("node %d mismatch: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestResolveLinks(t *testing.T) {
+	nodes := testNodes(t, 4)
+	other, otherurl := testTree(t, nodes[2:], nil, "other.example.org")
+	tree, url := testTree(t, nodes[:2], []string{otherurl}, "nodes.example.org")
+	records := tree.ToTXT("nodes.example.org")
+	for name, txt := range other.ToTXT("other.example.org") {
+		records[name] = txt
+	}
+	srv, client := serve(t, records)
+	defer srv.Close()
+
+	resolved, err := client.Resolve(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resolved) != len(nodes) {
+		t.Fatalf("got %d nodes, want %d", len(resolved), len(nodes))
+	}
+}
+
+func TestBadSignature(t *testing.T) {
+	tree, _ := testTree(t, testNodes(t, 2), nil, "nodes.example.org")
+	srv, client := serve(t, tree.ToTXT("nodes.example.org"))
+	defer srv.Close()
+
+	// the tree is there, but not signed by this key
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.SyncTree(makeURL(&key.PublicKey, "nodes.example.org"))
+	if err != errInvalidSig {
+		t.Fatalf("expected %v, got %v", errInvalidSig, err)
+	}
+}
+
+func TestTamperedEntry(t *testing.T) {
+	nodes := testNodes(t, 2)
+	tree, url := testTree(t, nodes[:1], nil, "nodes.example.org")
+	records := tree.ToTXT("nodes.example.org")
+
+	// put another node's record where the first one should be
+	for name, txt := range records {
+		if strings.HasPrefix(txt, enrPrefix) {
+			records[name] = (&enrEntry{node: nodes[1]}).String()
+		}
+	}
+	srv, client := serve(t, records)
+	defer srv.Close()
+
+	_, err := client.SyncTree(url)
+	if err == nil {
+		t.Fatal("expected error for tampered entry")
+	}
+}
+
+func TestUnsignedNode(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := enode.NewV4(&key.PublicKey, net.IP{127, 0, 0, 1}, 30100, 30100)
+	_, err = MakeTree(1, []*enode.Node{n}, nil)
+	if err == nil {
+		t.Fatal("expected error for node without signed record")
+	}
+}
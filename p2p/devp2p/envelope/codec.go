@@ -0,0 +1,135 @@
+package envelope
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// ContentType is the format of the payload
+type ContentType uint8
+
+const (
+	ContentRaw ContentType = iota
+	ContentRLP
+	ContentJSON
+)
+
+func (t ContentType) String() string {
+	codec, ok := lookupCodec(t)
+	if !ok {
+		return fmt.Sprintf("content(%d)", uint8(t))
+	}
+	return codec.Name()
+}
+
+// Codec encodes and decodes payloads of one content type
+type Codec interface {
+	ContentType() ContentType
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+var (
+	// the codecs we have out of the box
+	Raw  Codec = rawCodec{}
+	RLP  Codec = rlpCodec{}
+	JSON Codec = jsonCodec{}
+
+	codecsMu sync.RWMutex
+	codecs   = map[ContentType]Codec{
+		ContentRaw:  Raw,
+		ContentRLP:  RLP,
+		ContentJSON: JSON,
+	}
+)
+
+// RegisterCodec makes a codec for another content type available to Unmarshal
+func RegisterCodec(codec Codec) error {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, ok := codecs[codec.ContentType()]; ok {
+		return fmt.Errorf("content type %d already registered", codec.ContentType())
+	}
+	codecs[codec.ContentType()] = codec
+	return nil
+}
+
+func lookupCodec(t ContentType) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[t]
+	return c, ok
+}
+
+// the payload as is, for byte slices and strings
+type rawCodec struct{}
+
+func (rawCodec) ContentType() ContentType {
+	return ContentRaw
+}
+
+func (rawCodec) Name() string {
+	return "raw"
+}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("raw payload must be []byte or string, not %T", v)
+}
+
+func (rawCodec) Unmarshal(b []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = append((*v)[:0], b...)
+		return nil
+	case *string:
+		*v = string(b)
+		return nil
+	}
+	return fmt.Errorf("raw payload must be decoded to *[]byte or *string, not %T", v)
+}
+
+type rlpCodec struct{}
+
+func (rlpCodec) ContentType() ContentType {
+	return ContentRLP
+}
+
+func (rlpCodec) Name() string {
+	return "rlp"
+}
+
+func (rlpCodec) Marshal(v interface{}) ([]byte, error) {
+	return rlp.EncodeToBytes(v)
+}
+
+func (rlpCodec) Unmarshal(b []byte, v interface{}) error {
+	return rlp.DecodeBytes(b, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() ContentType {
+	return ContentJSON
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,307 @@
+// Package envelope is the wrapping the examples put around the payloads they send
+//
+// an envelope says what format the payload is in and whether it's compressed, so the receiver can decode it
+// without knowing beforehand which struct was sent, and the format can change without breaking older receivers
+//
+// the layout is:
+//
+//	magic        2 bytes, 0xe7 0x0e
+//	version      1 byte, major in the high four bits, minor in the low four bits
+//	header size  1 byte, the bytes of header that follow
+//	content type 1 byte
+//	compression  1 byte
+//	expiry       8 bytes since 1.1, big endian unix milliseconds after which the payload is stale, 0 for never
+//	...          header fields added by later minor versions
+//	payload      the rest
+//
+// the rules for evolving the format:
+//
+//   - a new minor version may only add header fields at the end of the header, and they must be safe to ignore
+//   - a decoder skips any header fields it doesn't know, using the header size, and so reads any minor version
+//   - anything else is a new major version, which decoders for the old major version refuse
+//   - new content types and compressions don't change the version; a decoder that doesn't know one
+//     can still read the header, but can't decode the payload
+//
+// the header fields of a minor version may be left out of an envelope of that version too, when the header size says so.
+// An envelope without an expiry is written with the header of 1.0, so it's no larger than before there was one
+package envelope
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// the version this package writes
+	MajorVersion = 1
+	MinorVersion = 1
+
+	// the header fields of version 1.0, after the header size
+	minHeaderSize = 2
+
+	// the header fields of version 1.1, with the expiry
+	expiryHeaderSize = minHeaderSize + 8
+
+	// the bytes before the header fields
+	prefixSize = 4
+)
+
+var (
+	magic = []byte{0xe7, 0x0e}
+
+	ErrNoEnvelope         = errors.New("not an envelope")
+	ErrUnknownMajor       = errors.New("unknown major version")
+	ErrUnknownCompression = errors.New("unknown compression")
+	ErrUnknownContentType = errors.New("unknown content type")
+	ErrExpired            = errors.New("envelope expired")
+
+	// MaxPayloadSize is the most bytes a compressed payload may decompress to
+	// a few hundred bytes of flate can decompress to many megabytes, so the size of the message says nothing about it
+	MaxPayloadSize = 16 * 1024 * 1024
+)
+
+// Compression of the payload
+type Compression uint8
+
+const (
+	CompressNone Compression = iota
+	CompressFlate
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressNone:
+		return "none"
+	case CompressFlate:
+		return "flate"
+	}
+	return fmt.Sprintf("compression(%d)", uint8(c))
+}
+
+// Envelope is a decoded envelope
+// the payload is kept compressed until it's asked for
+type Envelope struct {
+	Major       uint8
+	Minor       uint8
+	ContentType ContentType
+	Compression Compression
+	Expiry      time.Time // zero if the payload doesn't go stale
+	payload     []byte
+}
+
+// Encode puts the value in an envelope, encoded with the codec, and compressed if asked for
+func Encode(codec Codec, compression Compression, v interface{}) ([]byte, error) {
+	return encode(codec, compression, v, time.Time{})
+}
+
+// EncodeTTL is Encode for a payload that is stale after the ttl, which receivers and relays then drop
+// a ttl of 0 is no expiry
+func EncodeTTL(codec Codec, compression Compression, v interface{}, ttl time.Duration) ([]byte, error) {
+	var expiry time.Time
+	if ttl > 0 {
+		expiry = time.Now().Add(ttl)
+	}
+	return encode(codec, compression, v, expiry)
+}
+
+func encode(codec Codec, compression Compression, v interface{}, expiry time.Time) ([]byte, error) {
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	switch compression {
+	case CompressNone:
+	case CompressFlate:
+		var b bytes.Buffer
+		w, err := flate.NewWriter(&b, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		w.Write(payload)
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+		payload = b.Bytes()
+	default:
+		return nil, ErrUnknownCompression
+	}
+	headerSize := minHeaderSize
+	if !expiry.IsZero() {
+		headerSize = expiryHeaderSize
+	}
+	b := make([]byte, 0, prefixSize+headerSize+len(payload))
+	b = append(b, magic...)
+	b = append(b, MajorVersion<<4|MinorVersion, byte(headerSize), byte(codec.ContentType()), byte(compression))
+	if !expiry.IsZero() {
+		var ms [8]byte
+		binary.BigEndian.PutUint64(ms[:], uint64(expiry.UnixNano()/int64(time.Millisecond)))
+		b = append(b, ms[:]...)
+	}
+	return append(b, payload...), nil
+}
+
+// the compression Wrap uses
+var wrapCompression int32
+
+// UseCompression sets the compression of the envelopes made with Wrap, none to begin with
+// the receiver needs nothing for it, the compression is in the header
+func UseCompression(c Compression) {
+	atomic.StoreInt32(&wrapCompression, int32(c))
+}
+
+// UsingCompression tells the compression Wrap uses
+func UsingCompression() Compression {
+	return Compression(atomic.LoadInt32(&wrapCompression))
+}
+
+// the ttl Wrap gives the envelopes, in nanoseconds
+var wrapTTL int64
+
+// UseTTL sets the ttl of the envelopes made with Wrap, none to begin with
+func UseTTL(ttl time.Duration) {
+	atomic.StoreInt64(&wrapTTL, int64(ttl))
+}
+
+// UsingTTL tells the ttl Wrap gives the envelopes
+func UsingTTL() time.Duration {
+	return time.Duration(atomic.LoadInt64(&wrapTTL))
+}
+
+// Wrap puts the value in an envelope, compressed as set with UseCompression, and with the ttl set with UseTTL
+func Wrap(codec Codec, v interface{}) ([]byte, error) {
+	return EncodeTTL(codec, UsingCompression(), v, UsingTTL())
+}
+
+var (
+	// how far the clock of a sender may be behind ours, in nanoseconds
+	clockSkew = int64(DefaultClockSkew)
+
+	// the expired envelopes Decode refused
+	expiredCount uint64
+)
+
+// DefaultClockSkew is how long after its expiry an envelope is still taken, to begin with
+const DefaultClockSkew = time.Millisecond * 500
+
+// SetClockSkew sets how long after its expiry an envelope is still taken
+// the expiry is set by the clock of the sender, and the clocks of two nodes are never quite the same
+func SetClockSkew(skew time.Duration) {
+	atomic.StoreInt64(&clockSkew, int64(skew))
+}
+
+// ClockSkew tells how long after its expiry an envelope is still taken
+func ClockSkew() time.Duration {
+	return time.Duration(atomic.LoadInt64(&clockSkew))
+}
+
+// ExpiredCount returns the number of expired envelopes Decode refused, and so Unwrap and DecodeMsg, since the start
+func ExpiredCount() uint64 {
+	return atomic.LoadUint64(&expiredCount)
+}
+
+// Expired tells if the payload is stale at the time, allowing for the clock skew
+func (e *Envelope) Expired(now time.Time) bool {
+	return !e.Expiry.IsZero() && now.After(e.Expiry.Add(ClockSkew()))
+}
+
+// IsExpired tells if the error is that of an expired envelope
+func IsExpired(err error) bool {
+	return err == ErrExpired
+}
+
+// Decode reads the header of an envelope
+// the payload isn't decoded until Unmarshal or Payload is called
+//
+// an envelope past its expiry is refused with ErrExpired, and counted. A relay can decode the envelopes it forwards
+// to drop the stale ones too, it only reads the header
+func Decode(b []byte) (*Envelope, error) {
+	if len(b) < prefixSize || !bytes.Equal(b[:len(magic)], magic) {
+		return nil, ErrNoEnvelope
+	}
+	e := &Envelope{
+		Major: b[2] >> 4,
+		Minor: b[2] & 0x0f,
+	}
+	if e.Major != MajorVersion {
+		return nil, ErrUnknownMajor
+	}
+	headerSize := int(b[3])
+	if headerSize < minHeaderSize || len(b) < prefixSize+headerSize {
+		return nil, fmt.Errorf("header too short: %d bytes", headerSize)
+	}
+	e.ContentType = ContentType(b[prefixSize])
+	e.Compression = Compression(b[prefixSize+1])
+	if e.Minor >= 1 && headerSize >= expiryHeaderSize {
+		ms := binary.BigEndian.Uint64(b[prefixSize+minHeaderSize:])
+		if ms != 0 {
+			e.Expiry = time.Unix(0, int64(ms)*int64(time.Millisecond))
+		}
+	}
+	if e.Expired(time.Now()) {
+		atomic.AddUint64(&expiredCount, 1)
+		return nil, ErrExpired
+	}
+
+	// any header fields after ours are from a newer minor version, and are skipped
+	e.payload = b[prefixSize+headerSize:]
+	return e, nil
+}
+
+// Unwrap decodes the value in an envelope
+func Unwrap(b []byte, v interface{}) error {
+	e, err := Decode(b)
+	if err != nil {
+		return err
+	}
+	return e.Unmarshal(v)
+}
+
+// Payload returns the payload, decompressed
+func (e *Envelope) Payload() ([]byte, error) {
+	switch e.Compression {
+	case CompressNone:
+		return e.payload, nil
+	case CompressFlate:
+		r := flate.NewReader(bytes.NewReader(e.payload))
+		defer r.Close()
+		// reading one byte more than allowed tells a payload of the maximum size from a larger one
+		payload, err := ioutil.ReadAll(io.LimitReader(r, int64(MaxPayloadSize)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) > MaxPayloadSize {
+			return nil, &SizeError{What: "decompressed payload", Size: len(payload), Max: MaxPayloadSize}
+		}
+		return payload, nil
+	}
+	return nil, ErrUnknownCompression
+}
+
+// Unmarshal decodes the payload with the codec for its content type
+func (e *Envelope) Unmarshal(v interface{}) error {
+	codec, ok := lookupCodec(e.ContentType)
+	if !ok {
+		return ErrUnknownContentType
+	}
+	payload, err := e.Payload()
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(payload, v)
+}
+
+func (e *Envelope) String() string {
+	s := fmt.Sprintf("envelope v%d.%d %s %s %d bytes", e.Major, e.Minor, e.ContentType, e.Compression, len(e.payload))
+	if !e.Expiry.IsZero() {
+		s += fmt.Sprintf(" expires %s", e.Expiry.Format(time.RFC3339Nano))
+	}
+	return s
+}
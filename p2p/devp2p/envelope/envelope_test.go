@@ -0,0 +1,361 @@
+package envelope
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+type testMsg struct {
+	Pong    bool
+	Seq     uint
+	Content string
+}
+
+func TestRoundtrip(t *testing.T) {
+	in := &testMsg{Pong: true, Seq: 42, Content: "foobar"}
+	for _, codec := range []Codec{RLP, JSON} {
+		for _, compression := range []Compression{CompressNone, CompressFlate} {
+			b, err := Encode(codec, compression, in)
+			if err != nil {
+				t.Fatalf("%s %s: %v", codec.Name(), compression, err)
+			}
+			e, err := Decode(b)
+			if err != nil {
+				t.Fatalf("%s %s: %v", codec.Name(), compression, err)
+			}
+			if e.ContentType != codec.ContentType() || e.Compression != compression {
+				t.Fatalf("%s %s: wrong header %s", codec.Name(), compression, e)
+			}
+			var out testMsg
+			err = e.Unmarshal(&out)
+			if err != nil {
+				t.Fatalf("%s %s: %v", codec.Name(), compression, err)
+			}
+			if !reflect.DeepEqual(in, &out) {
+				t.Fatalf("%s %s: got %v, want %v", codec.Name(), compression, out, in)
+			}
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	b, err := Wrap(Raw, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	err = Unwrap(b, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "foo" {
+		t.Fatalf("got %q", s)
+	}
+	_, err = Wrap(Raw, &testMsg{})
+	if err == nil {
+		t.Fatal("raw codec took a struct")
+	}
+}
+
+// an envelope from a newer minor version, with a header field we don't know
+func TestNewerMinor(t *testing.T) {
+	b, err := Wrap(JSON, &testMsg{Seq: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var newer []byte
+	newer = append(newer, b[:prefixSize]...)
+	newer[2] = MajorVersion<<4 | (MinorVersion + 1)
+	newer[3] = minHeaderSize + 3
+	newer = append(newer, b[prefixSize:prefixSize+minHeaderSize]...)
+	newer = append(newer, 0xaa, 0xbb, 0xcc)
+	newer = append(newer, b[prefixSize+minHeaderSize:]...)
+
+	e, err := Decode(newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Minor != MinorVersion+1 {
+		t.Fatalf("wrong minor version %d", e.Minor)
+	}
+	var out testMsg
+	err = e.Unmarshal(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Seq != 1 {
+		t.Fatalf("got %v", out)
+	}
+}
+
+func TestRefuse(t *testing.T) {
+	b, err := Wrap(RLP, &testMsg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newer := append([]byte{}, b...)
+	newer[2] = (MajorVersion + 1) << 4
+	_, err = Decode(newer)
+	if err != ErrUnknownMajor {
+		t.Fatalf("newer major: got %v", err)
+	}
+
+	_, err = Decode([]byte("foobar"))
+	if err != ErrNoEnvelope {
+		t.Fatalf("no envelope: got %v", err)
+	}
+
+	// a content type we don't know still has a readable header
+	unknown := append([]byte{}, b...)
+	unknown[prefixSize] = 0xf0
+	e, err := Decode(unknown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.Unmarshal(&testMsg{})
+	if err != ErrUnknownContentType {
+		t.Fatalf("unknown content type: got %v", err)
+	}
+
+	unknown = append([]byte{}, b...)
+	unknown[prefixSize+1] = 0xf0
+	e, err = Decode(unknown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.Unmarshal(&testMsg{})
+	if err != ErrUnknownCompression {
+		t.Fatalf("unknown compression: got %v", err)
+	}
+}
+
+func TestSendMsg(t *testing.T) {
+	r, w := p2p.MsgPipe()
+	defer r.Close()
+	in := &testMsg{Seq: 7, Content: "bar"}
+	go func() {
+		err := Send(w, 0, RLP, in)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	msg, err := r.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out testMsg
+	err = DecodeMsg(msg, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, &out) {
+		t.Fatalf("got %v, want %v", out, in)
+	}
+}
+
+func TestCompresses(t *testing.T) {
+	in := bytes.Repeat([]byte("foo"), 1000)
+	plain, err := Encode(Raw, CompressNone, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := Encode(Raw, CompressFlate, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(plain) {
+		t.Fatalf("compressed %d bytes, plain %d bytes", len(compressed), len(plain))
+	}
+	var out []byte
+	err = Unwrap(compressed, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(in, out) {
+		t.Fatal("payload changed")
+	}
+}
+
+func TestUseCompression(t *testing.T) {
+	defer UseCompression(CompressNone)
+	for _, compression := range []Compression{CompressFlate, CompressNone} {
+		UseCompression(compression)
+		b, err := Wrap(RLP, &testMsg{Content: "foo"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := Decode(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Compression != compression {
+			t.Fatalf("got %s, want %s", e.Compression, compression)
+		}
+	}
+}
+
+// a small compressed payload that decompresses to more than is allowed
+func TestDecompressLimit(t *testing.T) {
+	defer func(max int) { MaxPayloadSize = max }(MaxPayloadSize)
+	MaxPayloadSize = 1024
+
+	b, err := Encode(Raw, CompressFlate, make([]byte, MaxPayloadSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []byte
+	err = Unwrap(b, &out)
+	if err != nil {
+		t.Fatalf("payload of the maximum size: %v", err)
+	}
+
+	b, err = Encode(Raw, CompressFlate, make([]byte, MaxPayloadSize*100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) > MaxPayloadSize {
+		t.Fatalf("compressed to %d bytes", len(b))
+	}
+	err = Unwrap(b, &out)
+	if !IsTooLarge(err) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestSendLimit(t *testing.T) {
+	r, w := p2p.MsgPipe()
+	defer r.Close()
+	small := &testMsg{Seq: 1, Content: "bar"}
+	large := &testMsg{Seq: 2, Content: string(bytes.Repeat([]byte("x"), 200))}
+
+	go func() {
+		err := SendLimit(w, 0, RLP, small, 100)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	msg, err := r.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out testMsg
+	err = DecodeMsgLimit(msg, 100, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(small, &out) {
+		t.Fatalf("got %v, want %v", out, small)
+	}
+
+	// refused before it's sent, nothing gets to the pipe
+	err = SendLimit(w, 0, RLP, large, 100)
+	if !IsTooLarge(err) {
+		t.Fatalf("send: got %v", err)
+	}
+
+	// a receiver with a lower limit than the sender
+	go func() {
+		err := Send(w, 0, RLP, large)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	msg, err = r.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DecodeMsgLimit(msg, 100, &out)
+	if !IsTooLarge(err) {
+		t.Fatalf("decode: got %v", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	defer SetClockSkew(ClockSkew())
+	SetClockSkew(0)
+
+	b, err := EncodeTTL(JSON, CompressNone, &testMsg{Seq: 1}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Expiry.IsZero() || e.Expiry.Before(time.Now()) {
+		t.Fatalf("wrong expiry %v", e.Expiry)
+	}
+	plain, err := Encode(JSON, CompressNone, &testMsg{Seq: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != len(plain)+expiryHeaderSize-minHeaderSize {
+		t.Fatalf("%d bytes with expiry, %d without", len(b), len(plain))
+	}
+
+	stale, err := encode(JSON, CompressNone, &testMsg{Seq: 2}, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := ExpiredCount()
+	var out testMsg
+	err = Unwrap(stale, &out)
+	if !IsExpired(err) {
+		t.Fatalf("got %v", err)
+	}
+	if ExpiredCount() != count+1 {
+		t.Fatalf("expired count %d, want %d", ExpiredCount(), count+1)
+	}
+
+	// a sender with a clock behind ours
+	SetClockSkew(time.Minute)
+	err = Unwrap(stale, &out)
+	if err != nil {
+		t.Fatalf("within the clock skew: %v", err)
+	}
+	if out.Seq != 2 {
+		t.Fatalf("got %v", out)
+	}
+}
+
+// a decoder of version 1.0 skips the expiry like any header field it doesn't know
+func TestExpiryOlderDecoder(t *testing.T) {
+	b, err := EncodeTTL(RLP, CompressNone, &testMsg{Seq: 3}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	older := append([]byte{}, b...)
+	older[2] = MajorVersion << 4
+	e, err := Decode(older)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Expiry.IsZero() {
+		t.Fatal("expiry read from a 1.0 envelope")
+	}
+	var out testMsg
+	if err := e.Unmarshal(&out); err != nil || out.Seq != 3 {
+		t.Fatalf("got %v, %v", out, err)
+	}
+}
+
+func TestUseTTL(t *testing.T) {
+	defer UseTTL(0)
+	UseTTL(time.Hour)
+	b, err := Wrap(Raw, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(e.Expiry); d < time.Minute*59 || d > time.Hour {
+		t.Fatalf("expires in %v", d)
+	}
+}
@@ -0,0 +1,65 @@
+package envelope
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// SizeError is the error of something larger than it may be
+// the size is as much as is known of it; for a decompressed payload that's where decompressing stopped
+type SizeError struct {
+	What string
+	Size int
+	Max  int
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("%s too large: %d bytes, at most %d", e.What, e.Size, e.Max)
+}
+
+// IsTooLarge tells if the error is a SizeError
+func IsTooLarge(err error) bool {
+	_, ok := err.(*SizeError)
+	return ok
+}
+
+// SendLimit is Send for a peer taking messages of at most max bytes, like a protocols.Spec with a MaxMsgSize
+//
+// the receiver drops a peer sending it a message that's too large, so a message that won't fit is refused here instead,
+// and the connection stays up. The size checked is that of the message as it goes out, the envelope encoded as rlp
+func SendLimit(w p2p.MsgWriter, code uint64, codec Codec, v interface{}, max int) error {
+	b, err := Wrap(codec, v)
+	if err != nil {
+		return err
+	}
+	err = CheckMsgSize(b, max)
+	if err != nil {
+		return err
+	}
+	return p2p.Send(w, code, b)
+}
+
+// CheckMsgSize tells if the value fits in a devp2p message of at most max bytes, encoded as rlp as p2p.Send does
+// it's for messages sent some other way than SendLimit, like those of a protocols.Peer
+func CheckMsgSize(v interface{}, max int) error {
+	size, _, err := rlp.EncodeToReader(v)
+	if err != nil {
+		return err
+	}
+	if size > max {
+		return &SizeError{What: "message", Size: size, Max: max}
+	}
+	return nil
+}
+
+// DecodeMsgLimit is DecodeMsg for messages of at most max bytes
+// a message that's too large is discarded without reading it, and so without holding all of it in memory
+func DecodeMsgLimit(msg p2p.Msg, max int, v interface{}) error {
+	if int(msg.Size) > max {
+		msg.Discard()
+		return &SizeError{What: "message", Size: int(msg.Size), Max: max}
+	}
+	return DecodeMsg(msg, v)
+}
@@ -0,0 +1,24 @@
+package envelope
+
+import (
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+// Send sends the value in an envelope as a devp2p message
+func Send(w p2p.MsgWriter, code uint64, codec Codec, v interface{}) error {
+	b, err := Wrap(codec, v)
+	if err != nil {
+		return err
+	}
+	return p2p.Send(w, code, b)
+}
+
+// DecodeMsg decodes the value in the envelope of a devp2p message sent with Send
+func DecodeMsg(msg p2p.Msg, v interface{}) error {
+	var b []byte
+	err := msg.Decode(&b)
+	if err != nil {
+		return err
+	}
+	return Unwrap(b, v)
+}
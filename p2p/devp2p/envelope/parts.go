@@ -0,0 +1,123 @@
+package envelope
+
+import (
+	"errors"
+)
+
+var (
+	ErrBadPart        = errors.New("bad part")
+	ErrTooManyPending = errors.New("too many messages pending")
+)
+
+// Part is a piece of a message too large to send in one
+// the parts of a message share its id, and can come in any order
+type Part struct {
+	ID    uint64
+	Index uint32
+	Total uint32
+	Data  []byte
+}
+
+// Split cuts the bytes into parts of at most size bytes
+// an empty message is one empty part, so the receiver still gets it
+func Split(id uint64, b []byte, size int) []*Part {
+	if size <= 0 {
+		panic("envelope: part size must be positive")
+	}
+	total := (len(b) + size - 1) / size
+	if total == 0 {
+		total = 1
+	}
+	parts := make([]*Part, total)
+	for i := range parts {
+		end := (i + 1) * size
+		if end > len(b) {
+			end = len(b)
+		}
+		parts[i] = &Part{
+			ID:    id,
+			Index: uint32(i),
+			Total: uint32(total),
+			Data:  b[i*size : end],
+		}
+	}
+	return parts
+}
+
+// Reassembler puts the parts of messages back together
+//
+// the parts come from a peer, who could say a message has any number of parts and never send the last,
+// so the messages it reassembles are at most maxSize bytes, and at most maxPending of them are incomplete at a time.
+// A message that grows too large is dropped with its parts
+type Reassembler struct {
+	maxSize    int
+	maxPending int
+	pending    map[uint64]*partial
+}
+
+type partial struct {
+	parts [][]byte
+	got   uint32
+	size  int
+}
+
+func NewReassembler(maxSize int, maxPending int) *Reassembler {
+	return &Reassembler{
+		maxSize:    maxSize,
+		maxPending: maxPending,
+		pending:    make(map[uint64]*partial),
+	}
+}
+
+// Add adds the part, and returns the message when it was the last part missing
+// a part already added is ignored
+func (r *Reassembler) Add(p *Part) ([]byte, error) {
+	if p.Total == 0 || p.Index >= p.Total {
+		return nil, ErrBadPart
+	}
+	// every part but the last of a message has at least one byte
+	if int64(p.Total)-1 > int64(r.maxSize) {
+		return nil, &SizeError{What: "reassembled message", Size: int(p.Total) - 1, Max: r.maxSize}
+	}
+	m, ok := r.pending[p.ID]
+	if !ok {
+		if len(r.pending) >= r.maxPending {
+			return nil, ErrTooManyPending
+		}
+		m = &partial{parts: make([][]byte, p.Total)}
+		r.pending[p.ID] = m
+	}
+	if int(p.Total) != len(m.parts) {
+		r.Drop(p.ID)
+		return nil, ErrBadPart
+	}
+	if m.parts[p.Index] != nil {
+		return nil, nil
+	}
+	m.size += len(p.Data)
+	if m.size > r.maxSize {
+		r.Drop(p.ID)
+		return nil, &SizeError{What: "reassembled message", Size: m.size, Max: r.maxSize}
+	}
+	m.parts[p.Index] = append([]byte{}, p.Data...)
+	m.got++
+	if m.got < p.Total {
+		return nil, nil
+	}
+	delete(r.pending, p.ID)
+	b := make([]byte, 0, m.size)
+	for _, part := range m.parts {
+		b = append(b, part...)
+	}
+	return b, nil
+}
+
+// Drop forgets the parts of the message, as when the peer is gone or took too long to send the rest
+func (r *Reassembler) Drop(id uint64) {
+	delete(r.pending, id)
+}
+
+// Pending returns how many messages are incomplete
+func (r *Reassembler) Pending() int {
+	return len(r.pending)
+}
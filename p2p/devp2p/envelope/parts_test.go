@@ -0,0 +1,79 @@
+package envelope
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParts(t *testing.T) {
+	in := bytes.Repeat([]byte("0123456789"), 10)
+	parts := Split(1, in, 30)
+	if len(parts) != 4 {
+		t.Fatalf("%d parts", len(parts))
+	}
+	r := NewReassembler(len(in), 1)
+
+	// out of order, and one of them twice
+	for _, i := range []int{3, 1, 1, 0} {
+		out, err := r.Add(parts[i])
+		if err != nil || out != nil {
+			t.Fatalf("part %d: got %v, %v", i, out, err)
+		}
+	}
+	out, err := r.Add(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(in, out) {
+		t.Fatal("message changed")
+	}
+	if r.Pending() != 0 {
+		t.Fatalf("%d pending", r.Pending())
+	}
+
+	parts = Split(2, nil, 30)
+	if len(parts) != 1 {
+		t.Fatalf("empty message in %d parts", len(parts))
+	}
+	out, err = r.Add(parts[0])
+	if err != nil || out == nil || len(out) != 0 {
+		t.Fatalf("empty message: got %v, %v", out, err)
+	}
+}
+
+func TestReassemblerLimits(t *testing.T) {
+	r := NewReassembler(50, 1)
+
+	_, err := r.Add(&Part{ID: 1, Index: 2, Total: 2})
+	if err != ErrBadPart {
+		t.Fatalf("index out of range: got %v", err)
+	}
+	_, err = r.Add(&Part{ID: 1, Index: 0, Total: 1000})
+	if !IsTooLarge(err) {
+		t.Fatalf("too many parts: got %v", err)
+	}
+
+	parts := Split(1, make([]byte, 60), 20)
+	for _, p := range parts[:2] {
+		if _, err := r.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = r.Add(&Part{ID: 2, Index: 0, Total: 2, Data: []byte{1}})
+	if err != ErrTooManyPending {
+		t.Fatalf("second message: got %v", err)
+	}
+	_, err = r.Add(parts[2])
+	if !IsTooLarge(err) {
+		t.Fatalf("too large: got %v", err)
+	}
+	if r.Pending() != 0 {
+		t.Fatal("message too large not dropped")
+	}
+
+	r.Add(&Part{ID: 3, Index: 0, Total: 2, Data: []byte{1}})
+	_, err = r.Add(&Part{ID: 3, Index: 0, Total: 3, Data: []byte{1}})
+	if err != ErrBadPart {
+		t.Fatalf("total changed: got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package envelope
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/protobuf/proto"
+)
+
+// the protobuf wire types we use
+const (
+	WireVarint  = 0
+	WireFixed64 = 1
+	WireBytes   = 2
+	WireFixed32 = 5
+)
+
+// whether messages with a protobuf encoding use it in their rlp frames
+var protobufFrames int32
+
+// UseProtobuf makes the messages implementing ProtoMessage encode as protobuf bytes inside an rlp frame
+// both sides of a connection must agree on this, it isn't negotiated
+func UseProtobuf(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&protobufFrames, v)
+}
+
+// UsingProtobuf tells whether protobuf frames are used
+func UsingProtobuf() bool {
+	return atomic.LoadInt32(&protobufFrames) == 1
+}
+
+// ProtoMessage is a message with a protobuf encoding, matching a definition in a .proto file
+// the encoding is written by hand with the protobuf wire helpers, so no code generation is needed
+type ProtoMessage interface {
+	MarshalProto() ([]byte, error)
+	UnmarshalProto(b []byte) error
+}
+
+// EncodeFrame writes the message for rlp
+// with protobuf frames it's an rlp string of the protobuf bytes, otherwise plain is rlp encoded as usual
+//
+// messages implement rlp.Encoder with it, where plain is the message converted to a type without the EncodeRLP method:
+//
+//	type fooMsgRLP FooMsg
+//
+//	func (m *FooMsg) EncodeRLP(w io.Writer) error {
+//		return envelope.EncodeFrame(w, m, (*fooMsgRLP)(m))
+//	}
+func EncodeFrame(w io.Writer, m ProtoMessage, plain interface{}) error {
+	if !UsingProtobuf() {
+		return rlp.Encode(w, plain)
+	}
+	b, err := m.MarshalProto()
+	if err != nil {
+		return err
+	}
+	return rlp.Encode(w, b)
+}
+
+// DecodeFrame reads a message written by EncodeFrame, for implementing rlp.Decoder
+func DecodeFrame(s *rlp.Stream, m ProtoMessage, plain interface{}) error {
+	if !UsingProtobuf() {
+		return s.Decode(plain)
+	}
+	b, err := s.Bytes()
+	if err != nil {
+		return err
+	}
+	return m.UnmarshalProto(b)
+}
+
+// ProtoFields calls f with each field in protobuf bytes
+// f reads the value of the fields it knows from the buffer, and returns false for the others, which are then skipped
+// this is what lets older readers accept messages with fields added later
+func ProtoFields(b []byte, f func(num int, wire int, buf *proto.Buffer) (bool, error)) error {
+	buf := proto.NewBuffer(b)
+	for len(buf.Unread()) > 0 {
+		key, err := buf.DecodeVarint()
+		if err != nil {
+			return err
+		}
+		num, wire := int(key>>3), int(key&7)
+		known, err := f(num, wire, buf)
+		if err != nil {
+			return fmt.Errorf("field %d: %v", num, err)
+		}
+		if known {
+			continue
+		}
+		switch wire {
+		case WireVarint:
+			_, err = buf.DecodeVarint()
+		case WireFixed64:
+			_, err = buf.DecodeFixed64()
+		case WireBytes:
+			_, err = buf.DecodeRawBytes(false)
+		case WireFixed32:
+			_, err = buf.DecodeFixed32()
+		default:
+			err = fmt.Errorf("unsupported wire type %d", wire)
+		}
+		if err != nil {
+			return fmt.Errorf("field %d: %v", num, err)
+		}
+	}
+	return nil
+}
+
+// ProtoKey writes the key of a field
+func ProtoKey(buf *proto.Buffer, num int, wire int) {
+	buf.EncodeVarint(uint64(num)<<3 | uint64(wire))
+}
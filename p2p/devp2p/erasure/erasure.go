@@ -0,0 +1,127 @@
+// Package erasure splits data into shares with Reed-Solomon coding, so that any k of the n shares give the data back
+//
+// the coding is systematic: the first k shares are the data itself, cut in k pieces of the same size, the last one padded,
+// and the other n-k are parity. A share is a row of the coding matrix times the pieces, byte by byte, over GF(2^8).
+// The matrix is a Vandermonde matrix times the inverse of its top k rows, which keeps any k of its rows invertible,
+// so the pieces come back from any k shares by inverting their rows. The shares don't tell if they were changed,
+// the caller checks the data it got back, with a hash it kept for one
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// the largest number of shares, each needs an element of the field of its own
+const MaxShares = 256
+
+var (
+	ErrTooFewShares = errors.New("too few shares")
+	ErrShareSize    = errors.New("shares of different sizes")
+)
+
+// Coder splits data into n shares, any k of which give it back
+type Coder struct {
+	k, n   int
+	matrix [][]byte // n rows of k
+}
+
+// New creates a coder of n shares, any k of which are needed
+func New(k, n int) (*Coder, error) {
+	if k < 1 || n < k || n > MaxShares {
+		return nil, fmt.Errorf("can't code %d of %d shares", k, n)
+	}
+	vandermonde := make([][]byte, n)
+	for r := range vandermonde {
+		vandermonde[r] = make([]byte, k)
+		for c := range vandermonde[r] {
+			vandermonde[r][c] = gfExp(byte(r), c)
+		}
+	}
+	top, err := invert(vandermonde[:k])
+	if err != nil {
+		return nil, err
+	}
+	return &Coder{
+		k:      k,
+		n:      n,
+		matrix: multiply(vandermonde, top),
+	}, nil
+}
+
+// Needed returns how many shares give the data back
+func (c *Coder) Needed() int {
+	return c.k
+}
+
+// Total returns how many shares the data is split into
+func (c *Coder) Total() int {
+	return c.n
+}
+
+// ShareSize returns the size of each share of data of the size
+func (c *Coder) ShareSize(size int) int {
+	return (size + c.k - 1) / c.k
+}
+
+// Encode splits the data into the shares, each of ShareSize bytes
+func (c *Coder) Encode(data []byte) [][]byte {
+	size := c.ShareSize(len(data))
+	shares := make([][]byte, c.n)
+	for i := 0; i < c.k; i++ {
+		shares[i] = make([]byte, size)
+		if i*size < len(data) {
+			copy(shares[i], data[i*size:])
+		}
+	}
+	for i := c.k; i < c.n; i++ {
+		shares[i] = c.combine(c.matrix[i], shares[:c.k], size)
+	}
+	return shares
+}
+
+// Decode gives back the data of the size from the shares, the missing ones nil
+// at least k of the n must be there
+func (c *Coder) Decode(shares [][]byte, size int) ([]byte, error) {
+	if len(shares) != c.n {
+		return nil, fmt.Errorf("%d shares, not %d", len(shares), c.n)
+	}
+	var rows [][]byte
+	var have [][]byte
+	for i, s := range shares {
+		if s == nil {
+			continue
+		}
+		if len(s) != c.ShareSize(size) {
+			return nil, ErrShareSize
+		}
+		rows = append(rows, c.matrix[i])
+		have = append(have, s)
+		if len(have) == c.k {
+			break
+		}
+	}
+	if len(have) < c.k {
+		return nil, ErrTooFewShares
+	}
+	decode, err := invert(rows)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, c.k*c.ShareSize(size))
+	for i := 0; i < c.k; i++ {
+		data = append(data, c.combine(decode[i], have, c.ShareSize(size))...)
+	}
+	return data[:size], nil
+}
+
+// the sum of the shares times the coefficients, byte by byte
+func (c *Coder) combine(coefficients []byte, shares [][]byte, size int) []byte {
+	out := make([]byte, size)
+	for j, s := range shares {
+		for b := range out {
+			out[b] ^= gfMul(coefficients[j], s[b])
+		}
+	}
+	return out
+}
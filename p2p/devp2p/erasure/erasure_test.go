@@ -0,0 +1,84 @@
+package erasure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+// every k of the n shares give the data back
+func TestAnyK(t *testing.T) {
+	for _, tt := range []struct{ k, n, size int }{
+		{3, 5, 1000},
+		{1, 3, 10}, // each share is all the data
+		{4, 4, 7},  // no parity, and the last share padded
+		{2, 6, 1},
+		{3, 5, 0},
+	} {
+		c, err := New(tt.k, tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, tt.size)
+		rand.Read(data)
+		shares := c.Encode(data)
+		if len(shares) != tt.n {
+			t.Fatalf("%d of %d: %d shares", tt.k, tt.n, len(shares))
+		}
+		// the first k are the data
+		if joined := bytes.Join(shares[:tt.k], nil); !bytes.Equal(joined[:len(data)], data) {
+			t.Fatalf("%d of %d: the first shares aren't the data", tt.k, tt.n)
+		}
+		// every subset of the shares with k of them
+		for mask := 0; mask < 1<<uint(tt.n); mask++ {
+			some := make([][]byte, tt.n)
+			count := 0
+			for i := range shares {
+				if mask&(1<<uint(i)) != 0 {
+					some[i] = shares[i]
+					count++
+				}
+			}
+			if count != tt.k {
+				continue
+			}
+			got, err := c.Decode(some, len(data))
+			if err != nil {
+				t.Fatalf("%d of %d, shares %b: %v", tt.k, tt.n, mask, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("%d of %d, shares %b: wrong data", tt.k, tt.n, mask)
+			}
+		}
+	}
+}
+
+func TestTooFew(t *testing.T) {
+	c, err := New(3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	shares := c.Encode(data)
+	shares[0], shares[2], shares[4] = nil, nil, nil
+	if _, err := c.Decode(shares, len(data)); err != ErrTooFewShares {
+		t.Fatalf("got %v", err)
+	}
+	shares = c.Encode(data)
+	shares[1] = shares[1][1:]
+	shares[0] = nil
+	if _, err := c.Decode(shares, len(data)); err != ErrShareSize {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct{ k, n int }{{0, 1}, {3, 2}, {1, MaxShares + 1}} {
+		if _, err := New(tt.k, tt.n); err == nil {
+			t.Fatalf("%d of %d accepted", tt.k, tt.n)
+		}
+	}
+	if _, err := New(128, MaxShares); err != nil {
+		t.Fatal(err)
+	}
+}
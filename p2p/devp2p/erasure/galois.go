@@ -0,0 +1,112 @@
+package erasure
+
+import (
+	"errors"
+)
+
+// the field is GF(2^8) with the polynomial x^8+x^4+x^3+x^2+1, the one of most Reed-Solomon codes, and 2 as generator
+const polynomial = 0x11d
+
+var errSingular = errors.New("singular matrix")
+
+var (
+	expTable [510]byte // doubled, so the sum of two logarithms needs no modulo
+	logTable [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= polynomial
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+255-int(logTable[b])]
+}
+
+// a to the power of n
+func gfExp(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])*n)%255]
+}
+
+func multiply(a, b [][]byte) [][]byte {
+	out := make([][]byte, len(a))
+	for r := range a {
+		out[r] = make([]byte, len(b[0]))
+		for c := range out[r] {
+			var v byte
+			for i := range b {
+				v ^= gfMul(a[r][i], b[i][c])
+			}
+			out[r][c] = v
+		}
+	}
+	return out
+}
+
+// the inverse of the square matrix, by gauss-jordan elimination
+func invert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	// the matrix with the identity on its right, which becomes the inverse
+	work := make([][]byte, n)
+	for r := range m {
+		work[r] = make([]byte, 2*n)
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+	for c := 0; c < n; c++ {
+		pivot := -1
+		for r := c; r < n; r++ {
+			if work[r][c] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errSingular
+		}
+		work[c], work[pivot] = work[pivot], work[c]
+		if v := work[c][c]; v != 1 {
+			for i := range work[c] {
+				work[c][i] = gfDiv(work[c][i], v)
+			}
+		}
+		for r := 0; r < n; r++ {
+			if r == c || work[r][c] == 0 {
+				continue
+			}
+			f := work[r][c]
+			for i := range work[r] {
+				work[r][i] ^= gfMul(f, work[c][i])
+			}
+		}
+	}
+	inv := make([][]byte, n)
+	for r := range work {
+		inv[r] = work[r][n:]
+	}
+	return inv, nil
+}
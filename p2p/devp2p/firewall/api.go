@@ -0,0 +1,105 @@
+package firewall
+
+import (
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// API changes the policy of a guard over rpc
+// every change is enforced on the peers already connected, and returns the number of them dropped
+type API struct {
+	guard *Guard
+}
+
+// NewAPI creates the api for the guard
+func NewAPI(guard *Guard) *API {
+	return &API{
+		guard: guard,
+	}
+}
+
+// APIs returns the api in the firewall namespace
+// it's not public, a node should only serve it on its IPC endpoint
+func APIs(guard *Guard) []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "firewall",
+			Version:   "1.0",
+			Service:   NewAPI(guard),
+			Public:    false,
+		},
+	}
+}
+
+// Config returns the config of the policy
+func (api *API) Config() Config {
+	return api.guard.Policy.Config()
+}
+
+// SetConfig replaces the config of the policy
+func (api *API) SetConfig(cfg Config) (int, error) {
+	return api.update(func(c *Config) {
+		*c = cfg
+	})
+}
+
+func (api *API) AllowID(id enode.ID) (int, error) {
+	return api.update(func(c *Config) {
+		c.AllowIDs = append(c.AllowIDs, id)
+	})
+}
+
+func (api *API) DenyID(id enode.ID) (int, error) {
+	return api.update(func(c *Config) {
+		c.DenyIDs = append(c.DenyIDs, id)
+	})
+}
+
+func (api *API) AllowNet(cidr string) (int, error) {
+	return api.update(func(c *Config) {
+		c.AllowNets = append(c.AllowNets, cidr)
+	})
+}
+
+func (api *API) DenyNet(cidr string) (int, error) {
+	return api.update(func(c *Config) {
+		c.DenyNets = append(c.DenyNets, cidr)
+	})
+}
+
+func (api *API) AllowField(f Field) (int, error) {
+	return api.update(func(c *Config) {
+		c.AllowFields = append(c.AllowFields, f)
+	})
+}
+
+func (api *API) DenyField(f Field) (int, error) {
+	return api.update(func(c *Config) {
+		c.DenyFields = append(c.DenyFields, f)
+	})
+}
+
+// Check tells whether the node at the url would be let in, and if not why
+// the empty string means it would
+func (api *API) Check(url string) (string, error) {
+	n, err := enode.ParseV4(url)
+	if err != nil {
+		return "", err
+	}
+	if err := api.guard.Policy.Check(n); err != nil {
+		return err.Error(), nil
+	}
+	return "", nil
+}
+
+// Rejected returns the nodes refused or dropped so far, with why
+func (api *API) Rejected() map[enode.ID]string {
+	return api.guard.Rejected()
+}
+
+func (api *API) update(f func(*Config)) (int, error) {
+	if err := api.guard.Policy.Update(f); err != nil {
+		return 0, err
+	}
+	return api.guard.Enforce(), nil
+}
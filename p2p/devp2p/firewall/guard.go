@@ -0,0 +1,115 @@
+package firewall
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// Guard holds a policy to the inbound peers of a server
+//
+// the p2p.Server has no say in who connects beyond its NetRestrict, so the guard is put in front of the protocols instead
+// a peer the policy refuses is disconnected before any protocol sees it
+// peers we dial ourselves are let through, we chose them
+//
+// the peers of the server in devp2p v4 have no record of their own, only what the connection tells
+// so the fields a policy can match for them are id, secp256k1, ip and tcp, with tcp the port they connected from
+type Guard struct {
+	Policy *Policy
+
+	mu       sync.Mutex
+	peers    map[enode.ID]*guardedPeer
+	rejected map[enode.ID]string
+}
+
+// a peer let in, with the number of its protocols running
+type guardedPeer struct {
+	*p2p.Peer
+	runs int
+}
+
+// NewGuard creates a guard for the policy
+func NewGuard(policy *Policy) *Guard {
+	return &Guard{
+		Policy:   policy,
+		peers:    make(map[enode.ID]*guardedPeer),
+		rejected: make(map[enode.ID]string),
+	}
+}
+
+// Protocols returns the protocols with the policy checked before they run
+// the server is given these instead of the protocols themselves
+func (g *Guard) Protocols(protos []p2p.Protocol) []p2p.Protocol {
+	var guarded []p2p.Protocol
+	for _, proto := range protos {
+		guarded = append(guarded, g.protocol(proto))
+	}
+	return guarded
+}
+
+func (g *Guard) protocol(proto p2p.Protocol) p2p.Protocol {
+	run := proto.Run
+	proto.Run = func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+		if p.Inbound() {
+			if err := g.Policy.Check(p.Node()); err != nil {
+				log.Debug("firewall refused peer", "peer", p.ID(), "addr", p.RemoteAddr(), "err", err)
+				g.mu.Lock()
+				g.rejected[p.ID()] = err.Error()
+				g.mu.Unlock()
+				return p2p.DiscUselessPeer
+			}
+			g.mu.Lock()
+			gp, ok := g.peers[p.ID()]
+			if !ok || gp.Peer != p {
+				gp = &guardedPeer{Peer: p}
+				g.peers[p.ID()] = gp
+			}
+			gp.runs++
+			g.mu.Unlock()
+			defer func() {
+				g.mu.Lock()
+				gp.runs--
+				if gp.runs == 0 && g.peers[p.ID()] == gp {
+					delete(g.peers, p.ID())
+				}
+				g.mu.Unlock()
+			}()
+		}
+		return run(p, rw)
+	}
+	return proto
+}
+
+// Enforce disconnects the inbound peers the policy no longer lets in
+// it's called after the config changes, which only applies to new peers until then
+// returns the number of peers disconnected
+func (g *Guard) Enforce() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	count := 0
+	for id, p := range g.peers {
+		err := g.Policy.Check(p.Node())
+		if err == nil {
+			continue
+		}
+		log.Debug("firewall dropped peer", "peer", id, "addr", p.RemoteAddr(), "err", err)
+		g.rejected[id] = err.Error()
+		p.Disconnect(p2p.DiscUselessPeer)
+		delete(g.peers, id)
+		count++
+	}
+	return count
+}
+
+// Rejected returns the nodes refused or dropped so far, with the reason for the last time
+func (g *Guard) Rejected() map[enode.ID]string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	rejected := make(map[enode.ID]string)
+	for id, reason := range g.rejected {
+		rejected[id] = reason
+	}
+	return rejected
+}
@@ -0,0 +1,181 @@
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Field matches an entry of a node record
+// the value is the rlp encoding of the entry, as it is in the record
+// without a value any node that has the entry matches
+type Field struct {
+	Key   string        `json:"key"`
+	Value hexutil.Bytes `json:"value,omitempty"`
+}
+
+func (f Field) String() string {
+	if len(f.Value) == 0 {
+		return f.Key
+	}
+	return fmt.Sprintf("%s=%s", f.Key, f.Value)
+}
+
+// Config is what a policy lets in and keeps out
+//
+// a node matching anything on a deny list is refused
+// if there is anything on the allow lists, a node must also match something there
+// with nothing on the allow lists every node not denied is let in
+type Config struct {
+	AllowIDs    []enode.ID `json:"allowIds,omitempty"`
+	DenyIDs     []enode.ID `json:"denyIds,omitempty"`
+	AllowNets   []string   `json:"allowNets,omitempty"` // in CIDR notation
+	DenyNets    []string   `json:"denyNets,omitempty"`
+	AllowFields []Field    `json:"allowFields,omitempty"`
+	DenyFields  []Field    `json:"denyFields,omitempty"`
+}
+
+// DeniedError tells why a node was refused
+type DeniedError struct {
+	ID     enode.ID
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("node %s denied: %s", e.ID.TerminalString(), e.Reason)
+}
+
+// Policy decides which nodes are let in
+// its config may be changed at any time
+type Policy struct {
+	mu        sync.RWMutex
+	cfg       Config
+	allowNets []*net.IPNet
+	denyNets  []*net.IPNet
+}
+
+// NewPolicy creates a policy with the config
+func NewPolicy(cfg Config) (*Policy, error) {
+	p := &Policy{}
+	if err := p.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Config returns a copy of the config in use
+func (p *Policy) Config() Config {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.cfg.copy()
+}
+
+func (c Config) copy() Config {
+	return Config{
+		AllowIDs:    append([]enode.ID(nil), c.AllowIDs...),
+		DenyIDs:     append([]enode.ID(nil), c.DenyIDs...),
+		AllowNets:   append([]string(nil), c.AllowNets...),
+		DenyNets:    append([]string(nil), c.DenyNets...),
+		AllowFields: append([]Field(nil), c.AllowFields...),
+		DenyFields:  append([]Field(nil), c.DenyFields...),
+	}
+}
+
+// SetConfig replaces the config
+// a config with an invalid net is refused, and the one in use is kept
+func (p *Policy) SetConfig(cfg Config) error {
+	return p.Update(func(c *Config) {
+		*c = cfg
+	})
+}
+
+// Update changes the config with the function, as one change
+// the function gets a copy of the config in use, which is kept if the changed one is invalid
+func (p *Policy) Update(f func(cfg *Config)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cfg := p.cfg.copy()
+	f(&cfg)
+	allowNets, err := parseNets(cfg.AllowNets)
+	if err != nil {
+		return err
+	}
+	denyNets, err := parseNets(cfg.DenyNets)
+	if err != nil {
+		return err
+	}
+	p.cfg = cfg
+	p.allowNets = allowNets
+	p.denyNets = denyNets
+	return nil
+}
+
+// Check returns nil if the node is let in, and a *DeniedError if not
+func (p *Policy) Check(n *enode.Node) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	id := n.ID()
+	ip := n.IP()
+
+	for _, deny := range p.cfg.DenyIDs {
+		if deny == id {
+			return &DeniedError{ID: id, Reason: "id on deny list"}
+		}
+	}
+	for _, deny := range p.denyNets {
+		if ip != nil && deny.Contains(ip) {
+			return &DeniedError{ID: id, Reason: fmt.Sprintf("ip %v in denied net %v", ip, deny)}
+		}
+	}
+	for _, deny := range p.cfg.DenyFields {
+		if matchField(n, deny) {
+			return &DeniedError{ID: id, Reason: fmt.Sprintf("record has denied field %v", deny)}
+		}
+	}
+
+	if len(p.cfg.AllowIDs) == 0 && len(p.allowNets) == 0 && len(p.cfg.AllowFields) == 0 {
+		return nil
+	}
+	for _, allow := range p.cfg.AllowIDs {
+		if allow == id {
+			return nil
+		}
+	}
+	for _, allow := range p.allowNets {
+		if ip != nil && allow.Contains(ip) {
+			return nil
+		}
+	}
+	for _, allow := range p.cfg.AllowFields {
+		if matchField(n, allow) {
+			return nil
+		}
+	}
+	return &DeniedError{ID: id, Reason: "not on allow lists"}
+}
+
+func matchField(n *enode.Node, f Field) bool {
+	var value rlp.RawValue
+	if err := n.Load(enr.WithEntry(f.Key, &value)); err != nil {
+		return false
+	}
+	return len(f.Value) == 0 || bytes.Equal(value, f.Value)
+}
+
+func parseNets(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,137 @@
+package firewall
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func testNode(t *testing.T, ip string) *enode.Node {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enode.NewV4(&key.PublicKey, net.ParseIP(ip), 30303, 30303)
+}
+
+func rlpValue(t *testing.T, v interface{}) hexutil.Bytes {
+	b, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPolicy(t *testing.T) {
+	a := testNode(t, "10.0.0.1")
+	b := testNode(t, "10.0.1.1")
+	c := testNode(t, "192.168.0.1")
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		allowed []*enode.Node
+		denied  []*enode.Node
+	}{
+		{
+			name:    "empty",
+			allowed: []*enode.Node{a, b, c},
+		},
+		{
+			name:    "deny id",
+			cfg:     Config{DenyIDs: []enode.ID{a.ID()}},
+			allowed: []*enode.Node{b, c},
+			denied:  []*enode.Node{a},
+		},
+		{
+			name:    "allow net",
+			cfg:     Config{AllowNets: []string{"10.0.0.0/8"}},
+			allowed: []*enode.Node{a, b},
+			denied:  []*enode.Node{c},
+		},
+		{
+			name:    "deny before allow",
+			cfg:     Config{AllowNets: []string{"10.0.0.0/8"}, DenyNets: []string{"10.0.1.0/24"}},
+			allowed: []*enode.Node{a},
+			denied:  []*enode.Node{b, c},
+		},
+		{
+			name:    "allow id or net",
+			cfg:     Config{AllowIDs: []enode.ID{c.ID()}, AllowNets: []string{"10.0.1.0/24"}},
+			allowed: []*enode.Node{b, c},
+			denied:  []*enode.Node{a},
+		},
+		{
+			name:    "deny field",
+			cfg:     Config{DenyFields: []Field{{Key: "ip", Value: rlpValue(t, net.ParseIP("192.168.0.1").To4())}}},
+			allowed: []*enode.Node{a, b},
+			denied:  []*enode.Node{c},
+		},
+		{
+			name:    "allow field present",
+			cfg:     Config{AllowFields: []Field{{Key: "tcp"}}},
+			allowed: []*enode.Node{a, b, c},
+		},
+		{
+			name:   "allow field missing",
+			cfg:    Config{AllowFields: []Field{{Key: "eth"}}},
+			denied: []*enode.Node{a, b, c},
+		},
+	}
+	for _, test := range tests {
+		p, err := NewPolicy(test.cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for _, n := range test.allowed {
+			if err := p.Check(n); err != nil {
+				t.Errorf("%s: %v denied: %v", test.name, n.IP(), err)
+			}
+		}
+		for _, n := range test.denied {
+			if err := p.Check(n); err == nil {
+				t.Errorf("%s: %v allowed", test.name, n.IP())
+			} else if _, ok := err.(*DeniedError); !ok {
+				t.Errorf("%s: %v: unexpected error %v", test.name, n.IP(), err)
+			}
+		}
+	}
+}
+
+func TestPolicyUpdate(t *testing.T) {
+	n := testNode(t, "10.0.0.1")
+	p, err := NewPolicy(Config{DenyIDs: []enode.ID{n.ID()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an invalid change is refused, and the config in use kept
+	err = p.Update(func(c *Config) {
+		c.DenyIDs = nil
+		c.AllowNets = append(c.AllowNets, "10.0.0.0/33")
+	})
+	if err == nil {
+		t.Fatal("invalid net accepted")
+	}
+	if err := p.Check(n); err == nil {
+		t.Fatal("config changed by invalid update")
+	}
+
+	// the config returned is a copy
+	cfg := p.Config()
+	cfg.DenyIDs[0] = enode.ID{}
+	if err := p.Check(n); err == nil {
+		t.Fatal("config changed through its copy")
+	}
+
+	if err := p.Update(func(c *Config) { c.DenyIDs = nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Check(n); err != nil {
+		t.Fatalf("denied after update: %v", err)
+	}
+}
@@ -0,0 +1,369 @@
+// Package heartbeat finds the peers that are still connected but no longer answer, and drops them
+//
+// devp2p pings its peers itself, but the ping is answered below the protocols, so a peer whose protocol is stuck,
+// in a deadlock or waiting on something that never comes, keeps answering it. The heartbeat is answered by the protocol's own reading:
+// the monitor wraps the protocols of a server the way the netquota meter does, with two more message codes, for a ping and a pong.
+// The pings of the peer are answered as the protocol reads its messages, so a peer that stops reading stops answering.
+//
+// A peer that misses as many pings in a row as the config says is dropped with p2p.DiscReadTimeout, and OnDead is called,
+// for the caller to dial it again. The same monitor runs on both sides
+package heartbeat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+var (
+	ErrDead = errors.New("peer not answering")
+)
+
+// Config is how often peers are pinged, and how many pings they may miss
+type Config struct {
+	Interval time.Duration `json:"interval"` // between pings, in nanoseconds over json
+	Misses   int           `json:"misses"`   // pings in a row a peer may leave unanswered before it's dropped
+	Jitter   float64       `json:"jitter"`   // the part of the interval the pings vary by, so the peers of a node aren't all pinged at once
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval: time.Second * 5,
+		Misses:   3,
+		Jitter:   0.2,
+	}
+}
+
+type Ping struct {
+	Seq uint64
+}
+
+type Pong struct {
+	Seq uint64
+}
+
+// Status is what the monitor knows of a peer
+type Status struct {
+	Connected bool          `json:"connected"`
+	LastSeen  time.Time     `json:"lastSeen"` // when anything was last heard from it
+	RTT       time.Duration `json:"rtt"`      // of the last ping answered
+	Missed    int           `json:"missed"`   // pings unanswered in a row
+	Evictions int           `json:"evictions"`
+}
+
+// Monitor pings the peers of the protocols it wraps
+type Monitor struct {
+	// called with the peers dropped for not answering, in a goroutine of its own, if set
+	OnDead func(n *enode.Node)
+
+	cfg   Config
+	mu    sync.Mutex
+	peers map[enode.ID]*Status
+	runs  map[enode.ID]int
+}
+
+func NewMonitor(cfg Config) *Monitor {
+	return &Monitor{
+		cfg:   cfg,
+		peers: make(map[enode.ID]*Status),
+		runs:  make(map[enode.ID]int),
+	}
+}
+
+func (m *Monitor) Config() Config {
+	return m.cfg
+}
+
+// Stats returns the status of all the peers seen, connected or not
+func (m *Monitor) Stats() map[enode.ID]Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make(map[enode.ID]Status)
+	for id, s := range m.peers {
+		stats[id] = *s
+	}
+	return stats
+}
+
+func (m *Monitor) update(id enode.ID, f func(s *Status)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.peers[id]
+	if !ok {
+		s = &Status{}
+		m.peers[id] = s
+	}
+	f(s)
+}
+
+func (m *Monitor) connected(id enode.ID, up bool) {
+	m.mu.Lock()
+	if up {
+		m.runs[id]++
+	} else {
+		m.runs[id]--
+	}
+	runs := m.runs[id]
+	m.mu.Unlock()
+	m.update(id, func(s *Status) {
+		s.Connected = runs > 0
+		if up {
+			s.Missed = 0
+		}
+	})
+}
+
+// the time to the next ping, the interval give or take the jitter
+func (m *Monitor) interval() time.Duration {
+	d := float64(m.cfg.Interval)
+	if m.cfg.Jitter > 0 {
+		d += d * m.cfg.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// Protocols returns the protocols with their peers pinged
+// each gets two more message codes, for the ping and the pong
+func (m *Monitor) Protocols(protos []p2p.Protocol) []p2p.Protocol {
+	var monitored []p2p.Protocol
+	for _, proto := range protos {
+		monitored = append(monitored, m.protocol(proto))
+	}
+	return monitored
+}
+
+func (m *Monitor) protocol(proto p2p.Protocol) p2p.Protocol {
+	run := proto.Run
+	pingCode := proto.Length
+	proto.Length += 2
+	proto.Run = func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+		m.connected(p.ID(), true)
+		defer m.connected(p.ID(), false)
+		brw := &beatRW{
+			rw:       rw,
+			monitor:  m,
+			id:       p.ID(),
+			pingCode: pingCode,
+			msgC:     make(chan p2p.Msg),
+			pingC:    make(chan uint64, 1),
+			pongC:    make(chan uint64, 1),
+			readC:    make(chan struct{}),
+			deadC:    make(chan struct{}),
+			quitC:    make(chan struct{}),
+		}
+		defer close(brw.quitC)
+		go brw.read()
+		go brw.write()
+		go brw.loop()
+
+		// the protocol may be stuck anywhere, so it's not waited for once the peer is dead
+		// returning closes the connection, and the protocol gets an error from its next read or write
+		runErrC := make(chan error, 1)
+		go func() {
+			runErrC <- run(p, brw)
+		}()
+		select {
+		case err := <-runErrC:
+			return err
+		case <-brw.deadC:
+			log.Debug("dropping peer not answering", "peer", p.ID(), "proto", proto.Name)
+			m.update(p.ID(), func(s *Status) {
+				s.Evictions++
+			})
+			if m.OnDead != nil {
+				go m.OnDead(p.Node())
+			}
+			return p2p.DiscReadTimeout
+		}
+	}
+	return proto
+}
+
+// answers the pings as the protocol reads its messages, and sends pings of its own
+type beatRW struct {
+	rw       p2p.MsgReadWriter
+	monitor  *Monitor
+	id       enode.ID
+	pingCode uint64 // the pong is the code after
+
+	msgC    chan p2p.Msg  // the messages of the protocol, taken when it reads
+	pingC   chan uint64   // the pings to send
+	pongC   chan uint64   // the pongs to send
+	readC   chan struct{} // closed when reading fails, with the error in readErr
+	readErr error
+	deadC   chan struct{} // closed when the peer has missed too many pings
+	quitC   chan struct{}
+
+	mu         sync.Mutex
+	heard      bool      // a pong or a message of the protocol since the last ping
+	delivering bool      // a message is waiting for the protocol to read it
+	pingSeq    uint64    // of the last ping sent
+	pingSent   time.Time // when it was sent
+}
+
+func (rw *beatRW) ReadMsg() (p2p.Msg, error) {
+	select {
+	case msg := <-rw.msgC:
+		return msg, nil
+	case <-rw.readC:
+		return p2p.Msg{}, rw.readErr
+	case <-rw.deadC:
+		return p2p.Msg{}, ErrDead
+	}
+}
+
+func (rw *beatRW) WriteMsg(msg p2p.Msg) error {
+	select {
+	case <-rw.deadC:
+		return ErrDead
+	default:
+	}
+	return rw.rw.WriteMsg(msg)
+}
+
+// reads the messages of the peer, answering its pings
+// a message of the protocol is held until the protocol reads it, and nothing more is read, or answered, meanwhile
+func (rw *beatRW) read() {
+	defer close(rw.readC)
+	for {
+		msg, err := rw.rw.ReadMsg()
+		if err != nil {
+			rw.readErr = err
+			return
+		}
+		// the pings of the peer are sent whatever its protocol is doing, they say nothing of it
+		now := time.Now()
+		if msg.Code != rw.pingCode {
+			rw.mu.Lock()
+			rw.heard = true
+			rw.mu.Unlock()
+			rw.monitor.update(rw.id, func(s *Status) {
+				s.LastSeen = now
+			})
+		}
+
+		switch msg.Code {
+		case rw.pingCode:
+			var ping Ping
+			if err := msg.Decode(&ping); err != nil {
+				rw.readErr = fmt.Errorf("invalid ping: %v", err)
+				return
+			}
+			// only the pong to the last ping matters, it replaces one not sent yet
+			select {
+			case <-rw.pongC:
+			default:
+			}
+			rw.pongC <- ping.Seq
+
+		case rw.pingCode + 1:
+			var pong Pong
+			if err := msg.Decode(&pong); err != nil {
+				rw.readErr = fmt.Errorf("invalid pong: %v", err)
+				return
+			}
+			rw.mu.Lock()
+			var rtt time.Duration
+			if pong.Seq == rw.pingSeq {
+				rtt = now.Sub(rw.pingSent)
+			}
+			rw.mu.Unlock()
+			if rtt > 0 {
+				rw.monitor.update(rw.id, func(s *Status) {
+					s.RTT = rtt
+				})
+			}
+
+		default:
+			rw.setDelivering(true)
+			select {
+			case rw.msgC <- msg:
+			case <-rw.quitC:
+				msg.Discard()
+				rw.readErr = io.EOF
+				return
+			}
+			rw.setDelivering(false)
+		}
+	}
+}
+
+func (rw *beatRW) setDelivering(delivering bool) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.delivering = delivering
+}
+
+// sends the pings and the pongs, apart from the loop and the reading
+// a peer that doesn't read doesn't take what's written to it either, and sooner or later writing to it blocks
+func (rw *beatRW) write() {
+	for {
+		var err error
+		select {
+		case seq := <-rw.pingC:
+			err = p2p.Send(rw.rw, rw.pingCode, &Ping{Seq: seq})
+		case seq := <-rw.pongC:
+			err = p2p.Send(rw.rw, rw.pingCode+1, &Pong{Seq: seq})
+		case <-rw.quitC:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// pings the peer every interval, and counts the pings it misses
+//
+// a ping is missed when nothing is heard from the peer before the next one; a message of the protocol will do as well as a pong.
+// While a message of the peer waits for our protocol to read it, we aren't reading either, and the peer may well have answered;
+// pings aren't counted as missed then, so a node that is slow itself doesn't drop its peers for it
+func (rw *beatRW) loop() {
+	timer := time.NewTimer(rw.monitor.interval())
+	defer timer.Stop()
+	var seq uint64
+	missed := 0
+	for {
+		select {
+		case <-timer.C:
+		case <-rw.readC:
+			return
+		case <-rw.quitC:
+			return
+		}
+
+		rw.mu.Lock()
+		if seq > 0 && !rw.heard && !rw.delivering {
+			missed++
+		} else if rw.heard {
+			missed = 0
+		}
+		rw.heard = false
+		seq++
+		rw.pingSeq = seq
+		rw.pingSent = time.Now()
+		rw.mu.Unlock()
+
+		rw.monitor.update(rw.id, func(s *Status) {
+			s.Missed = missed
+		})
+		if missed >= rw.monitor.cfg.Misses {
+			close(rw.deadC)
+			return
+		}
+
+		// a ping still waiting to be sent is as good as a new one
+		select {
+		case rw.pingC <- seq:
+		default:
+		}
+		timer.Reset(rw.monitor.interval())
+	}
+}
@@ -0,0 +1,138 @@
+package heartbeat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+var testConfig = Config{
+	Interval: time.Millisecond * 50,
+	Misses:   3,
+}
+
+// a protocol sending a message every half interval, and reading all it gets, unless it hangs and does neither
+func testProtocol(hangC chan struct{}) p2p.Protocol {
+	return p2p.Protocol{
+		Name:    "test",
+		Version: 1,
+		Length:  1,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			errC := make(chan error, 1)
+			go func() {
+				for {
+					if hangC != nil {
+						<-hangC
+					}
+					if err := p2p.Send(rw, 0, "tick"); err != nil {
+						errC <- err
+						return
+					}
+					time.Sleep(testConfig.Interval / 2)
+				}
+			}()
+			go func() {
+				for {
+					if hangC != nil {
+						<-hangC
+					}
+					msg, err := rw.ReadMsg()
+					if err != nil {
+						errC <- err
+						return
+					}
+					msg.Discard()
+				}
+			}()
+			return <-errC
+		},
+	}
+}
+
+type side struct {
+	monitor *Monitor
+	id      enode.ID
+	errC    chan error
+	deadC   chan enode.ID
+}
+
+// runs the protocol on both ends of a pipe, each with a monitor of its own
+func runPair(hangA, hangB chan struct{}) (a, b *side, close func()) {
+	rwA, rwB := p2p.MsgPipe()
+	a = &side{monitor: NewMonitor(testConfig), id: enode.ID{1}, errC: make(chan error, 1), deadC: make(chan enode.ID, 1)}
+	b = &side{monitor: NewMonitor(testConfig), id: enode.ID{2}, errC: make(chan error, 1), deadC: make(chan enode.ID, 1)}
+	for _, s := range []*side{a, b} {
+		deadC := s.deadC
+		s.monitor.OnDead = func(n *enode.Node) {
+			deadC <- n.ID()
+		}
+	}
+	protoA := a.monitor.Protocols([]p2p.Protocol{testProtocol(hangA)})[0]
+	protoB := b.monitor.Protocols([]p2p.Protocol{testProtocol(hangB)})[0]
+	go func() {
+		a.errC <- protoA.Run(p2p.NewPeer(b.id, "b", nil), rwA)
+	}()
+	go func() {
+		b.errC <- protoB.Run(p2p.NewPeer(a.id, "a", nil), rwB)
+	}()
+	return a, b, func() {
+		rwA.Close()
+		rwB.Close()
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	a, b, close := runPair(nil, nil)
+	defer close()
+
+	select {
+	case err := <-a.errC:
+		t.Fatalf("a returned %v", err)
+	case err := <-b.errC:
+		t.Fatalf("b returned %v", err)
+	case <-time.After(testConfig.Interval * 10):
+	}
+	for _, s := range []*side{a, b} {
+		for id, status := range s.monitor.Stats() {
+			if !status.Connected || status.Evictions != 0 || status.Missed != 0 || status.RTT == 0 {
+				t.Fatalf("status of %v: %+v", id, status)
+			}
+		}
+	}
+}
+
+func TestHung(t *testing.T) {
+	hangC := make(chan struct{})
+	a, b, close := runPair(nil, hangC)
+	defer close()
+
+	// b hangs, a drops it
+	select {
+	case err := <-a.errC:
+		if err != p2p.DiscReadTimeout {
+			t.Fatalf("a returned %v", err)
+		}
+	case err := <-b.errC:
+		t.Fatalf("b returned %v", err)
+	case <-time.After(testConfig.Interval * time.Duration(testConfig.Misses+3)):
+		t.Fatal("hung peer not dropped")
+	}
+	select {
+	case id := <-a.deadC:
+		if id != b.id {
+			t.Fatalf("OnDead called with %v", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnDead not called")
+	}
+	if status := a.monitor.Stats()[b.id]; status.Evictions != 1 {
+		t.Fatalf("status of b: %+v", status)
+	}
+
+	// and b, while it hung, doesn't hold a's silence against it
+	if status := b.monitor.Stats()[a.id]; status.Evictions != 0 {
+		t.Fatalf("status of a: %+v", status)
+	}
+}
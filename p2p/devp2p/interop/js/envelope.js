@@ -0,0 +1,89 @@
+// The envelope the Go examples put around their payloads, see p2p/devp2p/envelope.
+//
+//   magic        2 bytes, 0xe7 0x0e
+//   version      1 byte, major in the high four bits, minor in the low four bits
+//   header size  1 byte, the bytes of header that follow
+//   content type 1 byte
+//   compression  1 byte
+//   ...          header fields added by later minor versions
+//   payload      the rest
+'use strict';
+
+const zlib = require('zlib');
+
+const MAGIC = Buffer.from([0xe7, 0x0e]);
+const MAJOR_VERSION = 1;
+const MINOR_VERSION = 0;
+
+const CONTENT_RAW = 0;
+const CONTENT_RLP = 1;
+const CONTENT_JSON = 2;
+
+const COMPRESS_NONE = 0;
+const COMPRESS_FLATE = 1;
+
+// the header fields of version 1.0, after the header size
+const MIN_HEADER_SIZE = 2;
+const PREFIX_SIZE = 4;
+
+function wrap(contentType, payload, compression = COMPRESS_NONE) {
+  if (compression === COMPRESS_FLATE) {
+    // go's compress/flate is raw deflate, without the zlib header
+    payload = zlib.deflateRawSync(payload);
+  } else if (compression !== COMPRESS_NONE) {
+    throw new Error(`unknown compression ${compression}`);
+  }
+  const header = Buffer.from([MAJOR_VERSION << 4 | MINOR_VERSION, MIN_HEADER_SIZE, contentType, compression]);
+  return Buffer.concat([MAGIC, header, payload]);
+}
+
+// returns the content type and the decompressed payload
+function unwrap(b) {
+  if (b.length < PREFIX_SIZE || !b.subarray(0, 2).equals(MAGIC)) {
+    throw new Error('not an envelope');
+  }
+  if (b[2] >> 4 !== MAJOR_VERSION) {
+    throw new Error(`unknown major version ${b[2] >> 4}`);
+  }
+  const headerSize = b[3];
+  if (headerSize < MIN_HEADER_SIZE || b.length < PREFIX_SIZE + headerSize) {
+    throw new Error(`header too short: ${headerSize} bytes`);
+  }
+  const contentType = b[PREFIX_SIZE];
+  const compression = b[PREFIX_SIZE + 1];
+
+  // any header fields after ours are from a newer minor version, and are skipped
+  let payload = b.subarray(PREFIX_SIZE + headerSize);
+  if (compression === COMPRESS_FLATE) {
+    payload = zlib.inflateRawSync(payload);
+  } else if (compression !== COMPRESS_NONE) {
+    throw new Error(`unknown compression ${compression}`);
+  }
+  return { contentType, payload };
+}
+
+function wrapJSON(v) {
+  return wrap(CONTENT_JSON, Buffer.from(JSON.stringify(v)));
+}
+
+function unwrapJSON(b) {
+  const { contentType, payload } = unwrap(b);
+  if (contentType !== CONTENT_JSON) {
+    throw new Error(`content type ${contentType} is not json`);
+  }
+  return JSON.parse(payload.toString());
+}
+
+module.exports = {
+  MAJOR_VERSION,
+  MINOR_VERSION,
+  CONTENT_RAW,
+  CONTENT_RLP,
+  CONTENT_JSON,
+  COMPRESS_NONE,
+  COMPRESS_FLATE,
+  wrap,
+  unwrap,
+  wrapJSON,
+  unwrapJSON,
+};
@@ -0,0 +1,110 @@
+#!/usr/bin/env node
+// Reference pss client: talks to the Go side of the interop example (interop/main.go) over websocket rpc.
+//
+// It subscribes to the interop topic, sends messages in envelopes to the Go node, and checks that every one is answered.
+// The exit code is 0 when all answers came.
+//
+// It uses the WebSocket of the runtime, so it runs as is in browsers and node 22 or later, and in node 20 and 21
+// with --experimental-websocket:
+//
+//   node interop/js/pss_client.js ws://127.0.0.1:18543 5
+'use strict';
+
+const envelope = require('./envelope');
+
+const url = process.argv[2] || 'ws://127.0.0.1:18543';
+const count = parseInt(process.argv[3] || '3', 10);
+const timeout = 10000;
+
+// a json-rpc client on a websocket, with the subscription notifications passed to onNotification
+function rpcClient(ws, onNotification) {
+  let nextId = 1;
+  const pending = new Map();
+  ws.addEventListener('message', (event) => {
+    const msg = JSON.parse(event.data);
+    if (msg.method && msg.method.endsWith('_subscription')) {
+      onNotification(msg.params);
+      return;
+    }
+    const p = pending.get(msg.id);
+    if (!p) {
+      return;
+    }
+    pending.delete(msg.id);
+    if (msg.error) {
+      p.reject(new Error(`${p.method}: ${msg.error.message}`));
+    } else {
+      p.resolve(msg.result);
+    }
+  });
+  return (method, ...params) => new Promise((resolve, reject) => {
+    const id = nextId++;
+    pending.set(id, { method, resolve, reject });
+    ws.send(JSON.stringify({ jsonrpc: '2.0', id, method, params }));
+  });
+}
+
+function fail(msg) {
+  console.error(msg);
+  process.exit(1);
+}
+
+async function run(ws) {
+  const waiting = new Set();
+  let sub;
+  let done;
+  const finished = new Promise((resolve) => { done = resolve; });
+
+  const call = rpcClient(ws, (params) => {
+    if (params.subscription !== sub) {
+      return;
+    }
+    let reply;
+    try {
+      reply = envelope.unwrapJSON(Buffer.from(params.result.Msg.slice(2), 'hex'));
+    } catch (e) {
+      console.log(`bad message: ${e.message}`);
+      return;
+    }
+    if (!reply.reply || !waiting.has(reply.seq)) {
+      console.log(`unexpected message: ${JSON.stringify(reply)}`);
+      return;
+    }
+    waiting.delete(reply.seq);
+    console.log(`answer ${reply.seq} from ${reply.from}: ${reply.text}`);
+    if (waiting.size === 0) {
+      done();
+    }
+  });
+
+  const info = await call('interop_info');
+  if (info.envelope.split('.')[0] !== String(envelope.MAJOR_VERSION)) {
+    fail(`the node uses envelope ${info.envelope}, we know ${envelope.MAJOR_VERSION}.x`);
+  }
+  console.log(`topic ${info.topic}, sending to ${info.peer.slice(0, 18)}...`);
+
+  sub = await call('pss_subscribe', 'receive', info.topic, false, false);
+
+  for (let seq = 1; seq <= count; seq++) {
+    waiting.add(seq);
+    const b = envelope.wrapJSON({ seq, from: 'js', text: `hello ${seq}`, reply: false });
+    await call('pss_sendAsym', info.peer, info.topic, `0x${b.toString('hex')}`);
+    console.log(`sent ${seq}`);
+  }
+
+  const timer = setTimeout(done, timeout);
+  await finished;
+  clearTimeout(timer);
+  ws.close();
+  if (waiting.size > 0) {
+    fail(`no answer to ${[...waiting].join(', ')}`);
+  }
+  console.log(`all ${count} answered`);
+}
+
+if (typeof WebSocket === 'undefined') {
+  fail('no WebSocket in this runtime, use node 22 or later, or --experimental-websocket');
+}
+const ws = new WebSocket(url);
+ws.addEventListener('open', () => run(ws).catch((e) => fail(e.message)));
+ws.addEventListener('error', () => fail(`connect to ${url} failed`));
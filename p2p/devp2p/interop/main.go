@@ -0,0 +1,278 @@
+// the go side of the interop example: two pss nodes for clients in other languages to talk to over websockets
+//
+// the clients connect to the websocket rpc of the 'left' node. The 'right' node answers every message it gets on the
+// interop topic, so a client sends to the 'right' node and waits for the answers on its subscription on the 'left' one
+//
+// the interop_info rpc method gives the clients the topic and the public key to send to, so they don't need to set
+// anything up themselves. The messages are JSON in an envelope (see ../envelope), which is the framing the clients implement
+//
+// usage, from the directory with the examples:
+//
+//	go run interop/main.go
+//	python3 interop/python/pss_client.py ws://127.0.0.1:18543
+//	node interop/js/pss_client.js ws://127.0.0.1:18543
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm"
+	bzzapi "github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+
+	demo "../common"
+	"../envelope"
+)
+
+const (
+	topicName = "interop"
+)
+
+// the message the clients and the go side exchange
+type chatMsg struct {
+	Seq   uint   `json:"seq"`
+	From  string `json:"from"`
+	Text  string `json:"text"`
+	Reply bool   `json:"reply"`
+}
+
+// Info is what a client needs to know to talk to the go side
+type Info struct {
+	Topic    string `json:"topic"`    // the pss topic, hex
+	Peer     string `json:"peer"`     // public key of the 'right' node, for pss_sendAsym
+	Envelope string `json:"envelope"` // version of the envelope the messages are in
+	Content  string `json:"content"`  // content type of the payload in the envelope
+}
+
+// the compatibility endpoint, as the interop rpc namespace
+// the info is filled in once the nodes know each other, until then the clients get an error
+type interopService struct {
+	mu   sync.RWMutex
+	info *Info
+}
+
+func (self *interopService) setInfo(info *Info) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.info = info
+}
+
+func (self *interopService) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "interop",
+			Version:   "1.0",
+			Service:   &InteropAPI{svc: self},
+			Public:    true,
+		},
+	}
+}
+
+func (self *interopService) Protocols() []p2p.Protocol {
+	return nil
+}
+
+func (self *interopService) Start(srv *p2p.Server) error {
+	return nil
+}
+
+func (self *interopService) Stop() error {
+	return nil
+}
+
+type InteropAPI struct {
+	svc *interopService
+}
+
+// Info returns the topic and the key to send to
+func (api *InteropAPI) Info() (*Info, error) {
+	api.svc.mu.RLock()
+	defer api.svc.mu.RUnlock()
+	if api.svc.info == nil {
+		return nil, fmt.Errorf("not ready")
+	}
+	return api.svc.info, nil
+}
+
+func newService(bzzdir string, bzzport int, bzznetworkid uint64) func(ctx *node.ServiceContext) (node.Service, error) {
+	return func(ctx *node.ServiceContext) (node.Service, error) {
+
+		// get the private key, which stays the same across runs if the data directory is kept
+		// the swarm overlay address is derived from it
+		privkey, err := demo.LoadOrCreateKey(filepath.Join(bzzdir, "bzzkey"))
+		if err != nil {
+			demo.Log.Crit("private key load servicenode fail", "err", err)
+		}
+
+		// create necessary swarm params
+		bzzconfig := bzzapi.NewConfig()
+		bzzconfig.Path = bzzdir
+		demo.Conf.Pss.Apply(bzzconfig.Pss)
+		bzzconfig.Init(privkey)
+		bzzconfig.Port = fmt.Sprintf("%d", bzzport)
+
+		// shortcut to setting up a swarm node
+		return swarm.NewSwarm(bzzconfig, nil)
+	}
+}
+
+func main() {
+	defer demo.FlushLogs()
+
+	// the 'left' node is the one the clients connect to, so it serves rpc over websockets
+	// only the pss and interop apis are made available there
+	l_stack, err := demo.NewServiceNode(demo.Conf.P2PPort, 0, demo.Conf.WSPort, "pss", "interop")
+	if err != nil {
+		demo.Log.Crit(err.Error())
+	}
+	r_stack, err := demo.NewServiceNode(demo.Conf.P2PPort+1, 0, 0)
+	if err != nil {
+		demo.Log.Crit(err.Error())
+	}
+
+	// register the pss activated bzz services, and the interop service on the 'left' node
+	err = l_stack.Register(newService(l_stack.InstanceDir(), demo.Conf.BzzPort, demo.Conf.BzzNetworkId))
+	if err != nil {
+		demo.Log.Crit("servicenode 'left' pss register fail", "err", err)
+	}
+	interop := &interopService{}
+	err = l_stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		return interop, nil
+	})
+	if err != nil {
+		demo.Log.Crit("servicenode 'left' interop register fail", "err", err)
+	}
+	err = r_stack.Register(newService(r_stack.InstanceDir(), demo.Conf.BzzPort+1, demo.Conf.BzzNetworkId))
+	if err != nil {
+		demo.Log.Crit("servicenode 'right' pss register fail", "err", err)
+	}
+
+	// start the nodes
+	err = l_stack.Start()
+	if err != nil {
+		demo.Log.Crit("servicenode start failed", "err", err)
+	}
+	defer demo.RemoveDataDir(l_stack.DataDir())
+	err = r_stack.Start()
+	if err != nil {
+		demo.Log.Crit("servicenode start failed", "err", err)
+	}
+	defer demo.RemoveDataDir(r_stack.DataDir())
+
+	// connect the nodes
+	l_stack.Server().AddPeer(r_stack.Server().Self())
+
+	// get the rpc clients
+	l_rpcclient, err := l_stack.Attach()
+	if err != nil {
+		demo.Log.Crit("rpc attach fail", "err", err)
+	}
+	r_rpcclient, err := r_stack.Attach()
+	if err != nil {
+		demo.Log.Crit("rpc attach fail", "err", err)
+	}
+
+	// wait until the state of the swarm overlay network is ready
+	// the health check isn't available in all versions, so we fall back to waiting a bit
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = demo.WaitHealthy(ctx, 2, l_rpcclient, r_rpcclient)
+	if err != nil {
+		demo.Log.Warn("health check fail", "err", err)
+	}
+	time.Sleep(time.Second)
+
+	var topic string
+	err = l_rpcclient.Call(&topic, "pss_stringToTopic", topicName)
+	if err != nil {
+		demo.Log.Crit("pss string to topic fail", "err", err)
+	}
+
+	// make the nodes aware of each other's public keys, so they can send to each other asymmetrically
+	var l_bzzaddr, r_bzzaddr, l_pubkey, r_pubkey string
+	for _, c := range []struct {
+		client *rpc.Client
+		method string
+		result *string
+	}{
+		{l_rpcclient, "pss_baseAddr", &l_bzzaddr},
+		{r_rpcclient, "pss_baseAddr", &r_bzzaddr},
+		{l_rpcclient, "pss_getPublicKey", &l_pubkey},
+		{r_rpcclient, "pss_getPublicKey", &r_pubkey},
+	} {
+		err = c.client.Call(c.result, c.method)
+		if err != nil {
+			demo.Log.Crit("pss call fail", "method", c.method, "err", err)
+		}
+	}
+	err = l_rpcclient.Call(nil, "pss_setPeerPublicKey", r_pubkey, topic, r_bzzaddr)
+	if err != nil {
+		demo.Log.Crit("pss set pubkey fail", "err", err)
+	}
+	err = r_rpcclient.Call(nil, "pss_setPeerPublicKey", l_pubkey, topic, l_bzzaddr)
+	if err != nil {
+		demo.Log.Crit("pss set pubkey fail", "err", err)
+	}
+
+	// the 'right' node answers whatever it gets on the topic, to the key it came from
+	msgC := make(chan pss.APIMsg)
+	sub, err := r_rpcclient.Subscribe(context.Background(), "pss", msgC, "receive", topic, false, false)
+	if err != nil {
+		demo.Log.Crit("pss subscribe fail", "err", err)
+	}
+	defer sub.Unsubscribe()
+	go func() {
+		for inmsg := range msgC {
+			var in chatMsg
+			err := envelope.Unwrap(inmsg.Msg, &in)
+			if err != nil {
+				demo.Log.Error("unwrap message fail", "err", err)
+				continue
+			}
+			demo.Log.Info("pss received", "seq", in.Seq, "from", in.From, "text", in.Text)
+			out, err := envelope.Wrap(envelope.JSON, &chatMsg{
+				Seq:   in.Seq,
+				From:  "go",
+				Text:  fmt.Sprintf("got %q from %s", in.Text, in.From),
+				Reply: true,
+			})
+			if err != nil {
+				demo.Log.Error("wrap message fail", "err", err)
+				continue
+			}
+			err = r_rpcclient.Call(nil, "pss_sendAsym", inmsg.Key, topic, common.ToHex(out))
+			if err != nil {
+				demo.Log.Error("pss send fail", "err", err)
+			}
+		}
+	}()
+
+	interop.setInfo(&Info{
+		Topic:    topic,
+		Peer:     r_pubkey,
+		Envelope: fmt.Sprintf("%d.%d", envelope.MajorVersion, envelope.MinorVersion),
+		Content:  envelope.JSON.Name(),
+	})
+	demo.Log.Info("ready for clients", "ws", fmt.Sprintf("ws://%s", l_stack.WSEndpoint()), "topic", topic)
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+	<-sigC
+
+	// bring down the servicenodes
+	r_rpcclient.Close()
+	l_rpcclient.Close()
+	r_stack.Stop()
+	l_stack.Stop()
+}
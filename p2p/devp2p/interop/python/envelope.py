@@ -0,0 +1,75 @@
+"""The envelope the Go examples put around their payloads, see p2p/devp2p/envelope.
+
+    magic        2 bytes, 0xe7 0x0e
+    version      1 byte, major in the high four bits, minor in the low four bits
+    header size  1 byte, the bytes of header that follow
+    content type 1 byte
+    compression  1 byte
+    ...          header fields added by later minor versions
+    payload      the rest
+"""
+
+import json
+import zlib
+
+MAGIC = b"\xe7\x0e"
+MAJOR_VERSION = 1
+MINOR_VERSION = 0
+
+CONTENT_RAW = 0
+CONTENT_RLP = 1
+CONTENT_JSON = 2
+
+COMPRESS_NONE = 0
+COMPRESS_FLATE = 1
+
+# the header fields of version 1.0, after the header size
+MIN_HEADER_SIZE = 2
+PREFIX_SIZE = 4
+
+
+class EnvelopeError(Exception):
+    pass
+
+
+def wrap(content_type, payload, compression=COMPRESS_NONE):
+    if compression == COMPRESS_FLATE:
+        # go's compress/flate is raw deflate, without the zlib header
+        c = zlib.compressobj(wbits=-15)
+        payload = c.compress(payload) + c.flush()
+    elif compression != COMPRESS_NONE:
+        raise EnvelopeError("unknown compression %d" % compression)
+    header = bytes([MAJOR_VERSION << 4 | MINOR_VERSION, MIN_HEADER_SIZE, content_type, compression])
+    return MAGIC + header + payload
+
+
+def unwrap(b):
+    """Returns the content type and the decompressed payload."""
+    if len(b) < PREFIX_SIZE or b[:2] != MAGIC:
+        raise EnvelopeError("not an envelope")
+    if b[2] >> 4 != MAJOR_VERSION:
+        raise EnvelopeError("unknown major version %d" % (b[2] >> 4))
+    header_size = b[3]
+    if header_size < MIN_HEADER_SIZE or len(b) < PREFIX_SIZE + header_size:
+        raise EnvelopeError("header too short: %d bytes" % header_size)
+    content_type = b[PREFIX_SIZE]
+    compression = b[PREFIX_SIZE + 1]
+
+    # any header fields after ours are from a newer minor version, and are skipped
+    payload = b[PREFIX_SIZE + header_size:]
+    if compression == COMPRESS_FLATE:
+        payload = zlib.decompress(payload, -15)
+    elif compression != COMPRESS_NONE:
+        raise EnvelopeError("unknown compression %d" % compression)
+    return content_type, payload
+
+
+def wrap_json(v):
+    return wrap(CONTENT_JSON, json.dumps(v).encode())
+
+
+def unwrap_json(b):
+    content_type, payload = unwrap(b)
+    if content_type != CONTENT_JSON:
+        raise EnvelopeError("content type %d is not json" % content_type)
+    return json.loads(payload)
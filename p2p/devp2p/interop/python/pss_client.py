@@ -0,0 +1,67 @@
+#!/usr/bin/env python3
+"""Reference pss client: talks to the Go side of the interop example (interop/main.go) over websocket rpc.
+
+It subscribes to the interop topic, sends messages in envelopes to the Go node, and checks that every one is answered.
+The exit code is 0 when all answers came, so it can be used to check that the Go side is still usable from here.
+
+    python3 interop/python/pss_client.py ws://127.0.0.1:18543 -n 5
+"""
+
+import argparse
+import socket
+import sys
+import time
+
+import envelope
+import wsrpc
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("url", nargs="?", default="ws://127.0.0.1:18543", help="websocket rpc url of the node")
+    parser.add_argument("-n", type=int, default=3, help="messages to send")
+    parser.add_argument("-timeout", type=float, default=10, help="seconds to wait for the answers")
+    args = parser.parse_args()
+
+    client = wsrpc.Client(args.url)
+    info = client.call("interop_info")
+    if info["envelope"].split(".")[0] != str(envelope.MAJOR_VERSION):
+        sys.exit("the node uses envelope %s, we know %d.x" % (info["envelope"], envelope.MAJOR_VERSION))
+    print("topic %s, sending to %s..." % (info["topic"], info["peer"][:18]))
+
+    sub = client.subscribe("pss", "receive", info["topic"], False, False)
+
+    for seq in range(1, args.n + 1):
+        msg = {"seq": seq, "from": "python", "text": "hello %d" % seq, "reply": False}
+        b = envelope.wrap_json(msg)
+        client.call("pss_sendAsym", info["peer"], info["topic"], "0x" + b.hex())
+        print("sent %d" % seq)
+
+    waiting = set(range(1, args.n + 1))
+    deadline = time.time() + args.timeout
+    while waiting and time.time() < deadline:
+        try:
+            params = client.notification(deadline - time.time())
+        except socket.timeout:
+            break
+        if params["subscription"] != sub:
+            continue
+        try:
+            reply = envelope.unwrap_json(bytes.fromhex(params["result"]["Msg"][2:]))
+        except (envelope.EnvelopeError, ValueError) as e:
+            print("bad message: %s" % e)
+            continue
+        if not reply.get("reply") or reply.get("seq") not in waiting:
+            print("unexpected message: %s" % reply)
+            continue
+        waiting.discard(reply["seq"])
+        print("answer %d from %s: %s" % (reply["seq"], reply["from"], reply["text"]))
+
+    client.close()
+    if waiting:
+        sys.exit("no answer to %s" % sorted(waiting))
+    print("all %d answered" % args.n)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,145 @@
+"""A minimal JSON-RPC client over websockets, with nothing but the standard library.
+
+It does what the pss client needs and no more: text frames, ping, close, and subscription notifications.
+"""
+
+import base64
+import json
+import os
+import socket
+import struct
+from urllib.parse import urlparse
+
+OP_CONT = 0x0
+OP_TEXT = 0x1
+OP_CLOSE = 0x8
+OP_PING = 0x9
+OP_PONG = 0xA
+
+
+class RPCError(Exception):
+    pass
+
+
+class WebSocket:
+    def __init__(self, url, timeout=10):
+        u = urlparse(url)
+        if u.scheme != "ws":
+            raise ValueError("only ws:// urls are supported")
+        self.sock = socket.create_connection((u.hostname, u.port or 80), timeout=timeout)
+        key = base64.b64encode(os.urandom(16)).decode()
+        request = (
+            "GET %s HTTP/1.1\r\n"
+            "Host: %s:%d\r\n"
+            "Upgrade: websocket\r\n"
+            "Connection: Upgrade\r\n"
+            "Sec-WebSocket-Key: %s\r\n"
+            "Sec-WebSocket-Version: 13\r\n"
+            "Origin: http://localhost\r\n"
+            "\r\n"
+        ) % (u.path or "/", u.hostname, u.port or 80, key)
+        self.sock.sendall(request.encode())
+        response = b""
+        while b"\r\n\r\n" not in response:
+            chunk = self.sock.recv(1024)
+            if not chunk:
+                raise ConnectionError("connection closed during handshake")
+            response += chunk
+        status = response.split(b"\r\n", 1)[0]
+        if b" 101 " not in status:
+            raise ConnectionError("handshake refused: %s" % status.decode())
+        self.buf = response.split(b"\r\n\r\n", 1)[1]
+
+    def settimeout(self, timeout):
+        self.sock.settimeout(timeout)
+
+    def _read(self, n):
+        while len(self.buf) < n:
+            chunk = self.sock.recv(4096)
+            if not chunk:
+                raise ConnectionError("connection closed")
+            self.buf += chunk
+        b, self.buf = self.buf[:n], self.buf[n:]
+        return b
+
+    def _send_frame(self, opcode, payload):
+        # frames from the client must be masked
+        header = bytes([0x80 | opcode])
+        n = len(payload)
+        if n < 126:
+            header += bytes([0x80 | n])
+        elif n < 1 << 16:
+            header += bytes([0x80 | 126]) + struct.pack(">H", n)
+        else:
+            header += bytes([0x80 | 127]) + struct.pack(">Q", n)
+        mask = os.urandom(4)
+        masked = bytes(b ^ mask[i % 4] for i, b in enumerate(payload))
+        self.sock.sendall(header + mask + masked)
+
+    def send(self, text):
+        self._send_frame(OP_TEXT, text.encode())
+
+    def recv(self):
+        message = b""
+        while True:
+            b0, b1 = self._read(2)
+            fin, opcode = b0 & 0x80, b0 & 0x0F
+            n = b1 & 0x7F
+            if n == 126:
+                n = struct.unpack(">H", self._read(2))[0]
+            elif n == 127:
+                n = struct.unpack(">Q", self._read(8))[0]
+            payload = self._read(n)
+            if opcode == OP_PING:
+                self._send_frame(OP_PONG, payload)
+                continue
+            if opcode == OP_CLOSE:
+                raise ConnectionError("connection closed by the node")
+            if opcode in (OP_TEXT, OP_CONT):
+                message += payload
+                if fin:
+                    return message.decode()
+
+    def close(self):
+        try:
+            self._send_frame(OP_CLOSE, b"")
+        finally:
+            self.sock.close()
+
+
+class Client:
+    def __init__(self, url, timeout=10):
+        self.ws = WebSocket(url, timeout)
+        self.next_id = 1
+        self.notifications = []
+
+    def call(self, method, *params):
+        id = self.next_id
+        self.next_id += 1
+        self.ws.send(json.dumps({"jsonrpc": "2.0", "id": id, "method": method, "params": list(params)}))
+        while True:
+            msg = json.loads(self.ws.recv())
+            if msg.get("id") != id:
+                # notifications can come in before the response
+                if msg.get("method", "").endswith("_subscription"):
+                    self.notifications.append(msg["params"])
+                continue
+            if "error" in msg:
+                raise RPCError("%s: %s" % (method, msg["error"]["message"]))
+            return msg.get("result")
+
+    def subscribe(self, namespace, *params):
+        return self.call(namespace + "_subscribe", *params)
+
+    def notification(self, timeout):
+        """Returns the params of the next subscription notification, with the subscription id and result."""
+        if self.notifications:
+            return self.notifications.pop(0)
+        self.ws.settimeout(timeout)
+        while True:
+            msg = json.loads(self.ws.recv())
+            if msg.get("method", "").endswith("_subscription"):
+                return msg["params"]
+
+    def close(self):
+        self.ws.close()
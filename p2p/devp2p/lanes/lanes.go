@@ -0,0 +1,230 @@
+// Package lanes queues the messages for a peer in lanes by priority, so the urgent ones don't wait behind bulk data
+//
+// lane 0 is the most urgent, and a message is taken from a lane only when the lanes before it are empty.
+// That alone would starve the later lanes while the earlier ones are busy, so there are two limits on it:
+// after MaxBurst messages in a row from earlier lanes while a later one waits, the later one gets a message through,
+// and a message that waited MaxWait goes next, whatever its lane. Either is 0 for no limit
+package lanes
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrFull   = errors.New("lane full")
+	ErrClosed = errors.New("queue closed")
+	ErrLane   = errors.New("no such lane")
+)
+
+// Config is how the lanes of a queue share the peer
+type Config struct {
+	Capacity int           `json:"capacity"` // messages a lane holds, 0 for no limit
+	MaxBurst int           `json:"maxBurst"` // messages taken in a row from earlier lanes while a later one waits
+	MaxWait  time.Duration `json:"maxWait"`  // how long a message waits at most before it goes next, in nanoseconds over json
+}
+
+// Stats are the counts of a lane
+type Stats struct {
+	Queued   int           `json:"queued"` // waiting now
+	Pushed   uint64        `json:"pushed"`
+	Popped   uint64        `json:"popped"`
+	Dropped  uint64        `json:"dropped"`  // refused for a full lane
+	Promoted uint64        `json:"promoted"` // taken before earlier lanes, to keep them from starving this one
+	MaxWait  time.Duration `json:"maxWait"`  // the longest a message of the lane waited
+}
+
+type item struct {
+	msg    interface{}
+	queued time.Time
+}
+
+// Queue is the lanes of one peer
+// any number of goroutines push, one pops and sends
+type Queue struct {
+	// the clock the waits are timed with
+	Now func() time.Time
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	cfg    Config
+	lanes  [][]item
+	stats  []Stats
+	burst  int // messages taken in a row from earlier lanes while a later one waited
+	closed bool
+}
+
+// NewQueue creates a queue of n lanes
+func NewQueue(n int, cfg Config) *Queue {
+	q := &Queue{
+		Now:   time.Now,
+		cfg:   cfg,
+		lanes: make([][]item, n),
+		stats: make([]Stats, n),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Config returns how the lanes share the peer
+func (q *Queue) Config() Config {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.cfg
+}
+
+// SetConfig changes how the lanes share the peer, for the messages already queued too
+// a lane holding more than a lower capacity keeps them, and refuses more until it's below
+func (q *Queue) SetConfig(cfg Config) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.cfg = cfg
+	q.burst = 0
+}
+
+// Push queues the message in the lane
+func (q *Queue) Push(lane int, msg interface{}) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if lane < 0 || lane >= len(q.lanes) {
+		return ErrLane
+	}
+	if q.closed {
+		return ErrClosed
+	}
+	if q.cfg.Capacity > 0 && len(q.lanes[lane]) >= q.cfg.Capacity {
+		q.stats[lane].Dropped++
+		return ErrFull
+	}
+	q.lanes[lane] = append(q.lanes[lane], item{msg: msg, queued: q.Now()})
+	q.stats[lane].Pushed++
+	q.cond.Signal()
+	return nil
+}
+
+// Pop takes the next message, waiting for one if there is none
+// it returns the lane it came from and how long it waited, and false once the queue is closed
+func (q *Queue) Pop() (int, interface{}, time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.closed {
+			return 0, nil, 0, false
+		}
+		if lane, msg, waited, ok := q.next(); ok {
+			return lane, msg, waited, true
+		}
+		q.cond.Wait()
+	}
+}
+
+// TryPop is Pop without waiting, it returns false when the queue is empty
+func (q *Queue) TryPop() (int, interface{}, time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, nil, 0, false
+	}
+	return q.next()
+}
+
+// takes the next message
+// must be called with the lock held
+func (q *Queue) next() (int, interface{}, time.Duration, bool) {
+	now := q.Now()
+	lane := -1
+	promoted := false
+
+	// the message that waited too long goes first, the oldest of them if there are several
+	if q.cfg.MaxWait > 0 {
+		var oldest time.Time
+		for i, l := range q.lanes {
+			if len(l) > 0 && now.Sub(l[0].queued) >= q.cfg.MaxWait && (lane < 0 || l[0].queued.Before(oldest)) {
+				lane, oldest = i, l[0].queued
+			}
+		}
+	}
+	if lane < 0 {
+		for i, l := range q.lanes {
+			if len(l) > 0 {
+				lane = i
+				break
+			}
+		}
+	}
+	if lane < 0 {
+		return 0, nil, 0, false
+	}
+
+	// a later lane waiting gets a message through after a burst of the earlier ones
+	later := -1
+	for i := lane + 1; i < len(q.lanes); i++ {
+		if len(q.lanes[i]) > 0 {
+			later = i
+			break
+		}
+	}
+	if later < 0 {
+		q.burst = 0
+	} else if q.cfg.MaxBurst > 0 && q.burst >= q.cfg.MaxBurst {
+		lane = later
+		promoted = true
+		q.burst = 0
+	} else {
+		q.burst++
+	}
+	for i := 0; i < lane && !promoted; i++ {
+		if len(q.lanes[i]) > 0 {
+			promoted = true
+		}
+	}
+
+	it := q.lanes[lane][0]
+	q.lanes[lane][0] = item{}
+	q.lanes[lane] = q.lanes[lane][1:]
+	waited := now.Sub(it.queued)
+	st := &q.stats[lane]
+	st.Popped++
+	if promoted {
+		st.Promoted++
+	}
+	if waited > st.MaxWait {
+		st.MaxWait = waited
+	}
+	return lane, it.msg, waited, true
+}
+
+// Len returns the number of messages waiting in all lanes
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, l := range q.lanes {
+		n += len(l)
+	}
+	return n
+}
+
+// Stats returns the counts of the lanes
+func (q *Queue) Stats() []Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := make([]Stats, len(q.stats))
+	copy(stats, q.stats)
+	for i, l := range q.lanes {
+		stats[i].Queued = len(l)
+	}
+	return stats
+}
+
+// Close drops the messages waiting, and ends Pop
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	for i := range q.lanes {
+		q.lanes[i] = nil
+	}
+	q.cond.Broadcast()
+}
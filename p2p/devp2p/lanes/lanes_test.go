@@ -0,0 +1,145 @@
+package lanes
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2018, 11, 1, 12, 0, 0, 0, time.UTC)
+
+// a queue with a clock the test moves
+func newTestQueue(n int, cfg Config) (*Queue, *time.Time) {
+	now := testTime
+	q := NewQueue(n, cfg)
+	q.Now = func() time.Time {
+		return now
+	}
+	return q, &now
+}
+
+// pops all the messages, and returns the lanes they came from in order
+func drain(t *testing.T, q *Queue) []int {
+	var order []int
+	for {
+		lane, msg, _, ok := q.TryPop()
+		if !ok {
+			return order
+		}
+		if msg.(int) != lane {
+			t.Fatalf("message %v from lane %d", msg, lane)
+		}
+		order = append(order, lane)
+	}
+}
+
+func push(t *testing.T, q *Queue, lane int, n int) {
+	for i := 0; i < n; i++ {
+		if err := q.Push(lane, lane); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPriority(t *testing.T) {
+	q, _ := newTestQueue(3, Config{})
+	push(t, q, 2, 2)
+	push(t, q, 1, 2)
+	push(t, q, 0, 2)
+	if order := drain(t, q); !reflect.DeepEqual(order, []int{0, 0, 1, 1, 2, 2}) {
+		t.Fatalf("order %v", order)
+	}
+	for i, st := range q.Stats() {
+		if st.Pushed != 2 || st.Popped != 2 || st.Promoted != 0 {
+			t.Fatalf("lane %d: %+v", i, st)
+		}
+	}
+}
+
+func TestMaxBurst(t *testing.T) {
+	q, _ := newTestQueue(2, Config{MaxBurst: 2})
+	push(t, q, 1, 2)
+	push(t, q, 0, 5)
+	want := []int{0, 0, 1, 0, 0, 1, 0}
+	if order := drain(t, q); !reflect.DeepEqual(order, want) {
+		t.Fatalf("order %v, want %v", order, want)
+	}
+	if st := q.Stats()[1]; st.Promoted != 2 {
+		t.Fatalf("promoted %d, want 2", st.Promoted)
+	}
+
+	// the burst only counts while the later lane waits
+	push(t, q, 0, 3)
+	push(t, q, 1, 1)
+	push(t, q, 0, 1)
+	want = []int{0, 0, 1, 0, 0}
+	if order := drain(t, q); !reflect.DeepEqual(order, want) {
+		t.Fatalf("order %v, want %v", order, want)
+	}
+}
+
+func TestMaxWait(t *testing.T) {
+	q, now := newTestQueue(2, Config{MaxWait: time.Second})
+	push(t, q, 1, 1)
+	*now = now.Add(time.Millisecond * 500)
+	push(t, q, 0, 2)
+	if lane, _, _, _ := q.TryPop(); lane != 0 {
+		t.Fatalf("lane %d before the wait", lane)
+	}
+	*now = now.Add(time.Millisecond * 500)
+	lane, _, waited, _ := q.TryPop()
+	if lane != 1 || waited != time.Second {
+		t.Fatalf("lane %d after waiting %v", lane, waited)
+	}
+	st := q.Stats()
+	if st[1].Promoted != 1 || st[1].MaxWait != time.Second || st[0].Queued != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestFull(t *testing.T) {
+	q, _ := newTestQueue(2, Config{Capacity: 2})
+	push(t, q, 0, 2)
+	if err := q.Push(0, 0); err != ErrFull {
+		t.Fatalf("got %v", err)
+	}
+	// the other lane has room of its own
+	push(t, q, 1, 2)
+	if err := q.Push(2, 2); err != ErrLane {
+		t.Fatalf("got %v", err)
+	}
+	if st := q.Stats()[0]; st.Dropped != 1 || st.Queued != 2 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestClose(t *testing.T) {
+	q := NewQueue(2, Config{})
+	done := make(chan bool)
+	go func() {
+		_, _, _, ok := q.Pop()
+		done <- ok
+	}()
+	if err := q.Push(1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if !<-done {
+		t.Fatal("pop failed")
+	}
+	go func() {
+		_, _, _, ok := q.Pop()
+		done <- ok
+	}()
+	q.Close()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("pop after close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pop still waiting after close")
+	}
+	if err := q.Push(0, "bar"); err != ErrClosed {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+// Package mdns finds the nodes on the local network with multicast dns, without a bootnode or adding them by hand
+//
+// every node announces itself as an instance of a DNS-SD service on the mdns group, a PTR record from the
+// service to its instance and a TXT record of the instance with its enode and whatever else it tells, like its
+// pss key. It announces itself when it starts, every interval after, and when a node on the network asks who's
+// there, which is what it does itself when it starts. It tells the others it's leaving when it stops, with a ttl of 0.
+//
+// The ip in the enode a node announces is usually not one the others can reach it on, so the ip a node is taken
+// to be on is the one its packets come from. A node that hasn't announced itself for a while is taken to be gone.
+// Only ipv4 is spoken
+package mdns
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+const (
+	// ServiceName is the DNS-SD service the nodes of the examples announce themselves as
+	ServiceName = "_devp2p-demo._tcp.local"
+	// DefaultAddr is the mdns group and port
+	DefaultAddr = "224.0.0.251:5353"
+
+	// the txt key of the enode
+	txtEnode = "enode"
+)
+
+// Config is the service announced, and how often
+type Config struct {
+	Service  string        `json:"service"`  // the DNS-SD service name, nodes only find those announcing the same
+	Addr     string        `json:"addr"`     // the multicast group and port
+	Interval time.Duration `json:"interval"` // how often a node announces itself
+	Expiry   time.Duration `json:"expiry"`   // how long a node is kept after it last announced itself
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Service:  ServiceName,
+		Addr:     DefaultAddr,
+		Interval: time.Second * 10,
+		Expiry:   time.Second * 35,
+	}
+}
+
+// Peer is a node found on the local network
+type Peer struct {
+	Node     *enode.Node       `json:"enode"`
+	Info     map[string]string `json:"info,omitempty"` // the rest of its txt record
+	LastSeen time.Time         `json:"lastSeen"`
+}
+
+// Event is a node found, or gone
+type Event struct {
+	Peer Peer
+	Lost bool
+}
+
+// Discovery announces the node, and finds the others
+type Discovery struct {
+	cfg      Config
+	self     *enode.Node
+	instance string
+	txt      []string
+
+	group *net.UDPAddr
+	rconn *net.UDPConn // on the group, for what the others send
+	wconn *net.UDPConn // what we send goes from here, on the address of the interface
+
+	mu    sync.Mutex
+	peers map[enode.ID]*Peer
+	feed  event.Feed
+
+	Now   func() time.Time
+	quitC chan struct{}
+	wg    sync.WaitGroup
+}
+
+// New makes the discovery of the node, telling the others the info along with its enode
+// the keys and values of the info can't have '='
+func New(self *enode.Node, info map[string]string, cfg Config) *Discovery {
+	txt := []string{txtEnode + "=" + self.String()}
+	var keys []string
+	for k := range info {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		txt = append(txt, k+"="+info[k])
+	}
+	id := self.ID()
+	return &Discovery{
+		cfg:  cfg,
+		self: self,
+		// a label is at most 63 bytes, half the id is as unique as the whole here
+		instance: hex.EncodeToString(id[:16]) + "." + cfg.Service,
+		txt:      txt,
+		peers:    make(map[enode.ID]*Peer),
+		Now:      time.Now,
+		quitC:    make(chan struct{}),
+	}
+}
+
+// Start joins the group, asks who's there, and announces the node
+func (d *Discovery) Start() error {
+	var err error
+	if d.group, err = net.ResolveUDPAddr("udp4", d.cfg.Addr); err != nil {
+		return err
+	}
+	if d.rconn, err = net.ListenMulticastUDP("udp4", nil, d.group); err != nil {
+		return fmt.Errorf("join mdns group fail: %v", err)
+	}
+	if d.wconn, err = net.ListenUDP("udp4", &net.UDPAddr{}); err != nil {
+		d.rconn.Close()
+		return err
+	}
+	d.wg.Add(2)
+	go d.readLoop()
+	go d.loop()
+	return nil
+}
+
+// Stop tells the others the node is leaving
+func (d *Discovery) Stop() {
+	if err := d.send(d.announcement(0)); err != nil {
+		log.Debug("mdns goodbye fail", "err", err)
+	}
+	close(d.quitC)
+	d.rconn.Close()
+	d.wconn.Close()
+	d.wg.Wait()
+}
+
+// Peers is the nodes found, by their id
+func (d *Discovery) Peers() []Peer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var peers []Peer
+	for _, p := range d.peers {
+		peers = append(peers, *p)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Node.ID().String() < peers[j].Node.ID().String()
+	})
+	return peers
+}
+
+// SubscribeEvents tells of the nodes found and gone
+func (d *Discovery) SubscribeEvents(ch chan<- Event) event.Subscription {
+	return d.feed.Subscribe(ch)
+}
+
+func (d *Discovery) readLoop() {
+	defer d.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := d.rconn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-d.quitC:
+			default:
+				log.Warn("mdns read fail", "err", err)
+			}
+			return
+		}
+		if err := d.handle(buf[:n], from); err != nil {
+			log.Trace("bad mdns packet", "from", from, "err", err)
+		}
+	}
+}
+
+// asks, announces, and forgets the nodes not heard of
+func (d *Discovery) loop() {
+	defer d.wg.Done()
+	if err := d.send(d.query()); err != nil {
+		log.Warn("mdns query fail", "err", err)
+	}
+	if err := d.send(d.announcement(d.ttl())); err != nil {
+		log.Warn("mdns announce fail", "err", err)
+	}
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.send(d.announcement(d.ttl())); err != nil {
+				log.Warn("mdns announce fail", "err", err)
+			}
+			d.expire()
+		case <-d.quitC:
+			return
+		}
+	}
+}
+
+// the ttl of the records, in seconds, as long as we're kept
+func (d *Discovery) ttl() uint32 {
+	return uint32(d.cfg.Expiry / time.Second)
+}
+
+func (d *Discovery) send(m *message) error {
+	if d.wconn == nil {
+		return errors.New("mdns not started")
+	}
+	b, err := m.encode()
+	if err != nil {
+		return err
+	}
+	_, err = d.wconn.WriteToUDP(b, d.group)
+	return err
+}
+
+func (d *Discovery) query() *message {
+	return &message{questions: []question{{name: d.cfg.Service, qtype: dnsTypePTR}}}
+}
+
+func (d *Discovery) announcement(ttl uint32) *message {
+	return &message{
+		response: true,
+		records: []record{
+			{name: d.cfg.Service, rtype: dnsTypePTR, ttl: ttl, ptr: d.instance},
+			{name: d.instance, rtype: dnsTypeTXT, ttl: ttl, txt: d.txt},
+		},
+	}
+}
+
+// takes a packet from the address, answering the questions for the service and taking the instances of it announced
+func (d *Discovery) handle(b []byte, from *net.UDPAddr) error {
+	m, err := decodeMessage(b)
+	if err != nil {
+		return err
+	}
+	if !m.response {
+		for _, q := range m.questions {
+			if normalize(q.name) == normalize(d.cfg.Service) && (q.qtype == dnsTypePTR || q.qtype == dnsTypeANY) {
+				return d.send(d.announcement(d.ttl()))
+			}
+		}
+		return nil
+	}
+
+	// the instances the PTR records point to, and how long they're said to be there
+	instances := make(map[string]uint32)
+	for _, r := range m.records {
+		if r.rtype == dnsTypePTR && normalize(r.name) == normalize(d.cfg.Service) {
+			instances[normalize(r.ptr)] = r.ttl
+		}
+	}
+	for _, r := range m.records {
+		if r.rtype != dnsTypeTXT {
+			continue
+		}
+		name := normalize(r.name)
+		ttl, ok := instances[name]
+		if !ok || name == normalize(d.instance) {
+			continue
+		}
+		p, err := peerOf(r.txt, from.IP)
+		if err != nil {
+			return fmt.Errorf("instance %s: %v", name, err)
+		}
+		if ttl == 0 {
+			d.remove(p.Node.ID())
+		} else {
+			d.add(p)
+		}
+	}
+	return nil
+}
+
+// the peer of the txt record of an instance, on the ip the record came from
+func peerOf(txt []string, ip net.IP) (*Peer, error) {
+	p := &Peer{Info: make(map[string]string)}
+	var url string
+	for _, s := range txt {
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			continue
+		}
+		k, v := s[:i], s[i+1:]
+		if k == txtEnode {
+			url = v
+		} else {
+			p.Info[k] = v
+		}
+	}
+	if url == "" {
+		return nil, errors.New("no enode")
+	}
+	n, err := enode.ParseV4(url)
+	if err != nil {
+		return nil, err
+	}
+	if n.TCP() == 0 {
+		return nil, errors.New("enode without a tcp port")
+	}
+	p.Node = enode.NewV4(n.Pubkey(), ip, n.TCP(), 0)
+	return p, nil
+}
+
+func (d *Discovery) add(p *Peer) {
+	p.LastSeen = d.Now()
+	d.mu.Lock()
+	old, ok := d.peers[p.Node.ID()]
+	d.peers[p.Node.ID()] = p
+	d.mu.Unlock()
+	// a node that moved is found again, at its new address
+	if !ok || old.Node.String() != p.Node.String() {
+		log.Debug("mdns found node", "node", p.Node)
+		d.feed.Send(Event{Peer: *p})
+	}
+}
+
+func (d *Discovery) remove(id enode.ID) {
+	d.mu.Lock()
+	p, ok := d.peers[id]
+	delete(d.peers, id)
+	d.mu.Unlock()
+	if ok {
+		log.Debug("mdns lost node", "node", p.Node)
+		d.feed.Send(Event{Peer: *p, Lost: true})
+	}
+}
+
+func (d *Discovery) expire() {
+	var gone []enode.ID
+	d.mu.Lock()
+	for id, p := range d.peers {
+		if d.Now().Sub(p.LastSeen) > d.cfg.Expiry {
+			gone = append(gone, id)
+		}
+	}
+	d.mu.Unlock()
+	for _, id := range gone {
+		d.remove(id)
+	}
+}
@@ -0,0 +1,197 @@
+package mdns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func newTestNode(t *testing.T, port int) *enode.Node {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enode.NewV4(&key.PublicKey, net.IP{127, 0, 0, 1}, port, port)
+}
+
+func TestMessage(t *testing.T) {
+	m := &message{
+		response: true,
+		records: []record{
+			{name: ServiceName, rtype: dnsTypePTR, ttl: 35, ptr: "abc." + ServiceName},
+			{name: "abc." + ServiceName, rtype: dnsTypeTXT, ttl: 35, txt: []string{"enode=x", "pss=y"}},
+		},
+	}
+	b, err := m.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Fatalf("decoded %+v, want %+v", got, m)
+	}
+
+	q := &message{questions: []question{{name: ServiceName, qtype: dnsTypePTR}}}
+	if b, err = q.encode(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = decodeMessage(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, q) {
+		t.Fatalf("decoded %+v, want %+v", got, q)
+	}
+}
+
+// a response as a responder compresses it, the PTR record pointing at its name and its target pointing back at it too
+func TestMessageCompressed(t *testing.T) {
+	b := []byte{0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+	b, _ = appendName(b, "_x._tcp.local")
+	b = append(b, 0, dnsTypePTR, 0, dnsClassIN, 0, 0, 0, 10)
+	// "abc" and a pointer to the name at 12
+	b = append(b, 0, 6, 3, 'a', 'b', 'c', 0xc0, 12)
+	m, err := decodeMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.records) != 1 || m.records[0].ptr != "abc._x._tcp.local" {
+		t.Fatalf("records %+v", m.records)
+	}
+
+	// a name pointing at itself
+	loop := append([]byte{}, b[:12]...)
+	loop[7] = 0
+	loop[5] = 1
+	loop = append(loop, 0xc0, 12, 0, dnsTypePTR, 0, dnsClassIN)
+	if _, err := decodeMessage(loop); err != errBadName {
+		t.Fatalf("err %v, want %v", err, errBadName)
+	}
+	if _, err := decodeMessage(b[:len(b)-3]); err == nil {
+		t.Fatal("decoded cut packet")
+	}
+}
+
+func TestHandle(t *testing.T) {
+	cfg := DefaultConfig()
+	self := New(newTestNode(t, 30100), nil, cfg)
+	now := time.Now()
+	self.Now = func() time.Time { return now }
+	eventC := make(chan Event, 4)
+	sub := self.SubscribeEvents(eventC)
+	defer sub.Unsubscribe()
+
+	other := New(newTestNode(t, 30200), map[string]string{"pss": "02ab"}, cfg)
+	from := &net.UDPAddr{IP: net.IP{192, 168, 1, 7}, Port: 5353}
+	b, err := other.announcement(other.ttl()).encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := self.handle(b, from); err != nil {
+		t.Fatal(err)
+	}
+	e := <-eventC
+	if e.Lost || e.Peer.Node.ID() != other.self.ID() {
+		t.Fatalf("event %+v", e)
+	}
+	// reached on the address the packet is from, on the port of the enode
+	if !e.Peer.Node.IP().Equal(from.IP) || e.Peer.Node.TCP() != 30200 {
+		t.Fatalf("node %v", e.Peer.Node)
+	}
+	if e.Peer.Info["pss"] != "02ab" {
+		t.Fatalf("info %v", e.Peer.Info)
+	}
+
+	// heard of again, nothing new
+	if err := self.handle(b, from); err != nil {
+		t.Fatal(err)
+	}
+	// ourselves, not a peer
+	if b, err := self.announcement(self.ttl()).encode(); err != nil {
+		t.Fatal(err)
+	} else if err := self.handle(b, from); err != nil {
+		t.Fatal(err)
+	}
+	if peers := self.Peers(); len(peers) != 1 {
+		t.Fatalf("%d peers, want 1", len(peers))
+	}
+	select {
+	case e := <-eventC:
+		t.Fatalf("event %+v", e)
+	default:
+	}
+
+	// gone, saying so
+	if b, err = other.announcement(0).encode(); err != nil {
+		t.Fatal(err)
+	}
+	if err := self.handle(b, from); err != nil {
+		t.Fatal(err)
+	}
+	if e := <-eventC; !e.Lost {
+		t.Fatalf("event %+v", e)
+	}
+
+	// gone, not heard of
+	if b, err = other.announcement(other.ttl()).encode(); err != nil {
+		t.Fatal(err)
+	}
+	if err := self.handle(b, from); err != nil {
+		t.Fatal(err)
+	}
+	<-eventC
+	self.expire()
+	if peers := self.Peers(); len(peers) != 1 {
+		t.Fatalf("%d peers, want 1", len(peers))
+	}
+	now = now.Add(cfg.Expiry + time.Second)
+	self.expire()
+	if e := <-eventC; !e.Lost {
+		t.Fatalf("event %+v", e)
+	}
+	if peers := self.Peers(); len(peers) != 0 {
+		t.Fatalf("%d peers, want 0", len(peers))
+	}
+}
+
+// two nodes on the group, on a port of their own so as not to bother anything on the machine
+func TestDiscovery(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Addr = "224.0.0.251:25353"
+	cfg.Interval = time.Millisecond * 200
+	a := New(newTestNode(t, 30100), nil, cfg)
+	b := New(newTestNode(t, 30200), nil, cfg)
+	eventC := make(chan Event, 4)
+	sub := a.SubscribeEvents(eventC)
+	defer sub.Unsubscribe()
+	if err := a.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer a.Stop()
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-eventC:
+		if e.Lost || e.Peer.Node.ID() != b.self.ID() {
+			t.Fatalf("event %+v", e)
+		}
+	case <-time.After(time.Second * 2):
+		t.Skip("no multicast here")
+	}
+	b.Stop()
+	select {
+	case e := <-eventC:
+		if !e.Lost {
+			t.Fatalf("event %+v", e)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("no goodbye")
+	}
+}
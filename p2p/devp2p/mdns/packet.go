@@ -0,0 +1,230 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+const (
+	dnsHeaderLength = 12
+	dnsTypePTR      = 12
+	dnsTypeTXT      = 16
+	dnsTypeANY      = 255
+	dnsClassIN      = 1
+
+	// set on the class of a record, the record replaces what the others cached for the name
+	dnsClassCacheFlush = 0x8000
+
+	dnsFlagResponse      = 0x8000
+	dnsFlagAuthoritative = 0x0400
+
+	// the names in a packet may point back at names earlier in it, but not more times than this
+	maxPointers = 16
+	// the largest packet that's sent and taken, the most that goes over an ethernet without being cut up
+	maxPacketSize = 1500
+)
+
+var (
+	errShortPacket = errors.New("short dns packet")
+	errBadName     = errors.New("invalid name in dns packet")
+)
+
+// a question, only the name and the type matter here
+type question struct {
+	name  string
+	qtype uint16
+}
+
+// a record, with the rdata of the types we know read
+type record struct {
+	name  string
+	rtype uint16
+	ttl   uint32
+	ptr   string   // the name a PTR record points to
+	txt   []string // the strings of a TXT record
+}
+
+// an mdns query or response
+// mdns packets have no ids, and the responses are sent to everyone, so any response is taken for what it says
+type message struct {
+	response  bool
+	questions []question
+	records   []record // the answers and the additional records of a response, together
+}
+
+// encode makes the packet of the message, with the names written out in full
+func (m *message) encode() ([]byte, error) {
+	b := make([]byte, dnsHeaderLength, 512)
+	if m.response {
+		binary.BigEndian.PutUint16(b[2:], dnsFlagResponse|dnsFlagAuthoritative)
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.records)))
+	var err error
+	for _, q := range m.questions {
+		if b, err = appendName(b, q.name); err != nil {
+			return nil, err
+		}
+		b = appendUint16(b, q.qtype)
+		b = appendUint16(b, dnsClassIN)
+	}
+	for _, r := range m.records {
+		if b, err = appendName(b, r.name); err != nil {
+			return nil, err
+		}
+		b = appendUint16(b, r.rtype)
+		b = appendUint16(b, dnsClassIN|dnsClassCacheFlush)
+		b = append(b, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-4:], r.ttl)
+		var rdata []byte
+		switch r.rtype {
+		case dnsTypePTR:
+			if rdata, err = appendName(nil, r.ptr); err != nil {
+				return nil, err
+			}
+		case dnsTypeTXT:
+			for _, s := range r.txt {
+				if len(s) > 255 {
+					return nil, errors.New("txt string longer than 255 bytes")
+				}
+				rdata = append(rdata, byte(len(s)))
+				rdata = append(rdata, s...)
+			}
+		}
+		b = appendUint16(b, uint16(len(rdata)))
+		b = append(b, rdata...)
+	}
+	if len(b) > maxPacketSize {
+		return nil, errors.New("dns packet too large")
+	}
+	return b, nil
+}
+
+// decodeMessage reads a packet, the records of the types we don't know with their names only
+func decodeMessage(b []byte) (*message, error) {
+	if len(b) < dnsHeaderLength {
+		return nil, errShortPacket
+	}
+	m := &message{response: binary.BigEndian.Uint16(b[2:])&dnsFlagResponse != 0}
+	qdcount := int(binary.BigEndian.Uint16(b[4:]))
+	// the authority records are what a host is about to claim, which we don't care about, but they're in the way
+	rrcount := []int{int(binary.BigEndian.Uint16(b[6:])), int(binary.BigEndian.Uint16(b[8:])), int(binary.BigEndian.Uint16(b[10:]))}
+	offset := dnsHeaderLength
+	for i := 0; i < qdcount; i++ {
+		name, end, err := readName(b, offset)
+		if err != nil {
+			return nil, err
+		}
+		if end+4 > len(b) {
+			return nil, errShortPacket
+		}
+		m.questions = append(m.questions, question{name: name, qtype: binary.BigEndian.Uint16(b[end:])})
+		offset = end + 4
+	}
+	for section, n := range rrcount {
+		for i := 0; i < n; i++ {
+			name, end, err := readName(b, offset)
+			if err != nil {
+				return nil, err
+			}
+			if end+10 > len(b) {
+				return nil, errShortPacket
+			}
+			r := record{
+				name:  name,
+				rtype: binary.BigEndian.Uint16(b[end:]),
+				ttl:   binary.BigEndian.Uint32(b[end+4:]),
+			}
+			rdlen := int(binary.BigEndian.Uint16(b[end+8:]))
+			start := end + 10
+			if start+rdlen > len(b) {
+				return nil, errShortPacket
+			}
+			offset = start + rdlen
+			if section == 1 {
+				continue
+			}
+			switch r.rtype {
+			case dnsTypePTR:
+				// the name it points to may point back further into the packet
+				if r.ptr, _, err = readName(b, start); err != nil {
+					return nil, err
+				}
+			case dnsTypeTXT:
+				rdata := b[start:offset]
+				for len(rdata) > 0 {
+					n := int(rdata[0])
+					if 1+n > len(rdata) {
+						return nil, errShortPacket
+					}
+					r.txt = append(r.txt, string(rdata[1:1+n]))
+					rdata = rdata[1+n:]
+				}
+			}
+			m.records = append(m.records, r)
+		}
+	}
+	return m, nil
+}
+
+// reads the name at the offset, a list of labels prefixed by their length, ending with an empty one
+// or with a pointer to the rest of the name somewhere before, as the responders compress their packets
+// it returns the name and where what follows it starts
+func readName(packet []byte, offset int) (string, int, error) {
+	var labels []string
+	end := -1
+	for pointers := 0; ; {
+		if offset >= len(packet) {
+			return "", 0, errShortPacket
+		}
+		n := int(packet[offset])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case n&0xc0 == 0xc0:
+			if offset+2 > len(packet) {
+				return "", 0, errShortPacket
+			}
+			if pointers++; pointers > maxPointers {
+				return "", 0, errBadName
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(packet[offset:]) & 0x3fff)
+		case n > 63:
+			return "", 0, errBadName
+		default:
+			offset++
+			if offset+n > len(packet) {
+				return "", 0, errShortPacket
+			}
+			labels = append(labels, string(packet[offset:offset+n]))
+			offset += n
+		}
+	}
+}
+
+func appendName(b []byte, name string) ([]byte, error) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errBadName
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// names are case insensitive, and may or may not end with the root dot
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
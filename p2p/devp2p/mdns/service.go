@@ -0,0 +1,94 @@
+package mdns
+
+import (
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Service announces a node of a stack on the local network, and connects it to the nodes it finds
+type Service struct {
+	cfg  Config
+	info map[string]string
+	d    *Discovery
+
+	quitC chan struct{}
+	done  chan struct{}
+}
+
+// NewService makes the service, telling the others the info along with the enode of the node
+func NewService(cfg Config, info map[string]string) *Service {
+	return &Service{
+		cfg:   cfg,
+		info:  info,
+		quitC: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Discovery is the discovery of the service, once it's started
+func (s *Service) Discovery() *Discovery {
+	return s.d
+}
+
+func (s *Service) Protocols() []p2p.Protocol {
+	return nil
+}
+
+func (s *Service) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "mdns",
+			Version:   "1.0",
+			Service:   &API{s},
+			Public:    true,
+		},
+	}
+}
+
+func (s *Service) Start(srv *p2p.Server) error {
+	s.d = New(srv.Self(), s.info, s.cfg)
+	eventC := make(chan Event, 16)
+	sub := s.d.SubscribeEvents(eventC)
+	if err := s.d.Start(); err != nil {
+		sub.Unsubscribe()
+		return err
+	}
+	go func() {
+		defer close(s.done)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case e := <-eventC:
+				// a node gone is left to the server, which drops it when it's really gone
+				if !e.Lost {
+					log.Info("mdns peer found", "node", e.Peer.Node)
+					srv.AddPeer(e.Peer.Node)
+				}
+			case <-s.quitC:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *Service) Stop() error {
+	close(s.quitC)
+	<-s.done
+	s.d.Stop()
+	return nil
+}
+
+// API is the mdns namespace of the node's rpc
+type API struct {
+	s *Service
+}
+
+// Peers is the nodes found on the local network
+func (api *API) Peers() []Peer {
+	if api.s.d == nil {
+		return nil
+	}
+	return api.s.d.Peers()
+}
@@ -0,0 +1,57 @@
+package netquota
+
+import (
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// API shows the accounts of a meter, and changes its quota, over rpc
+type API struct {
+	meter *Meter
+}
+
+func NewAPI(meter *Meter) *API {
+	return &API{
+		meter: meter,
+	}
+}
+
+// APIs returns the api in the netquota namespace
+// changing the quota is for the operator of the node, so it's not public
+func APIs(meter *Meter) []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "netquota",
+			Version:   "1.0",
+			Service:   NewAPI(meter),
+			Public:    false,
+		},
+	}
+}
+
+func (api *API) Config() Config {
+	return api.meter.Config()
+}
+
+func (api *API) SetConfig(cfg Config) {
+	api.meter.SetConfig(cfg)
+}
+
+// Peers returns the accounts of all the peers seen
+func (api *API) Peers() map[enode.ID]Stats {
+	return api.meter.Stats()
+}
+
+// Peer returns the account of a peer, or nil if it has none
+func (api *API) Peer(id enode.ID) *Stats {
+	stats, ok := api.meter.Stats()[id]
+	if !ok {
+		return nil
+	}
+	return &stats
+}
+
+// Reset gives the peer its whole quota again
+func (api *API) Reset(id enode.ID) error {
+	return api.meter.Reset(id)
+}
@@ -0,0 +1,296 @@
+// Package netquota counts the bytes each peer sends and makes us send, and drops the peers going over their quota
+//
+// the meter wraps the protocols of a server the way the firewall guard does, so the protocols themselves don't change.
+// A peer nearing its quota is warned first, with a message of its own code after the codes of the protocol.
+// A peer running the wrapped protocol too sees the warning as it is, and can slow down before it's dropped
+package netquota
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+const (
+	Ingress = "ingress"
+	Egress  = "egress"
+)
+
+var (
+	ErrQuotaExceeded = errors.New("peer over its quota")
+)
+
+// Config is the quota every peer gets
+// the bytes are counted over a period, and start again from zero when it's over
+type Config struct {
+	Ingress uint64        `json:"ingress"` // bytes a peer may send us per period, 0 for no limit
+	Egress  uint64        `json:"egress"`  // bytes we send a peer per period
+	Period  time.Duration `json:"period"`  // in nanoseconds over json
+	WarnAt  float64       `json:"warnAt"`  // the part of the quota used when the peer is warned, 0 for no warnings
+}
+
+// Warning is the message a peer gets when it nears its quota
+type Warning struct {
+	Direction string // ingress or egress, as the sender of the warning counts them
+	Used      uint64
+	Quota     uint64
+	Reset     uint64 // milliseconds until the quota starts again
+}
+
+// Stats is what a peer has used
+type Stats struct {
+	Ingress      uint64    `json:"ingress"` // in the current period
+	Egress       uint64    `json:"egress"`
+	TotalIngress uint64    `json:"totalIngress"`
+	TotalEgress  uint64    `json:"totalEgress"`
+	PeriodStart  time.Time `json:"periodStart"`
+	WarningsSent int       `json:"warningsSent"`
+	WarningsGot  int       `json:"warningsGot"`
+	Disconnected bool      `json:"disconnected"` // dropped for going over the quota
+	Connected    bool      `json:"connected"`
+}
+
+type account struct {
+	Stats
+	warnedIn  bool
+	warnedOut bool
+	runs      int
+}
+
+// Meter keeps the accounts of the peers, and the quota they are held to
+type Meter struct {
+	// called with the warnings peers send us, if set
+	OnWarning func(id enode.ID, w *Warning)
+
+	// the clock the periods are timed with
+	Now func() time.Time
+
+	mu       sync.Mutex
+	cfg      Config
+	accounts map[enode.ID]*account
+}
+
+// NewMeter creates a meter holding peers to the config
+func NewMeter(cfg Config) *Meter {
+	return &Meter{
+		Now:      time.Now,
+		cfg:      cfg,
+		accounts: make(map[enode.ID]*account),
+	}
+}
+
+func (m *Meter) Config() Config {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cfg
+}
+
+// SetConfig changes the quota, for the current period too
+func (m *Meter) SetConfig(cfg Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg = cfg
+	for _, acc := range m.accounts {
+		acc.warnedIn = false
+		acc.warnedOut = false
+	}
+}
+
+// Stats returns the accounts of all the peers seen, connected or not
+func (m *Meter) Stats() map[enode.ID]Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make(map[enode.ID]Stats)
+	for id, acc := range m.accounts {
+		m.reset(acc)
+		stats[id] = acc.Stats
+	}
+	return stats
+}
+
+// Reset starts a new period for the peer now
+func (m *Meter) Reset(id enode.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	acc, ok := m.accounts[id]
+	if !ok {
+		return fmt.Errorf("unknown peer %v", id)
+	}
+	acc.PeriodStart = time.Time{}
+	m.reset(acc)
+	return nil
+}
+
+// starts a new period for the account if the last one is over
+// without a period the first one never ends
+func (m *Meter) reset(acc *account) {
+	now := m.Now()
+	if !acc.PeriodStart.IsZero() && (m.cfg.Period == 0 || now.Sub(acc.PeriodStart) < m.cfg.Period) {
+		return
+	}
+	acc.PeriodStart = now
+	acc.Ingress = 0
+	acc.Egress = 0
+	acc.warnedIn = false
+	acc.warnedOut = false
+}
+
+// counts size bytes to the peer
+// returns a warning to send it if it has just passed the warning level, and ErrQuotaExceeded if it's over the quota
+func (m *Meter) count(id enode.ID, direction string, size uint64) (*Warning, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	acc, ok := m.accounts[id]
+	if !ok {
+		acc = &account{}
+		m.accounts[id] = acc
+	}
+	m.reset(acc)
+
+	used, quota, warned := &acc.Ingress, m.cfg.Ingress, &acc.warnedIn
+	total := &acc.TotalIngress
+	if direction == Egress {
+		used, quota, warned = &acc.Egress, m.cfg.Egress, &acc.warnedOut
+		total = &acc.TotalEgress
+	}
+	*used += size
+	*total += size
+	if quota == 0 {
+		return nil, nil
+	}
+	if *used > quota {
+		acc.Disconnected = true
+		return nil, ErrQuotaExceeded
+	}
+	if m.cfg.WarnAt > 0 && !*warned && float64(*used) >= float64(quota)*m.cfg.WarnAt {
+		*warned = true
+		acc.WarningsSent++
+		var reset uint64
+		if m.cfg.Period > 0 {
+			reset = uint64(acc.PeriodStart.Add(m.cfg.Period).Sub(m.Now()) / time.Millisecond)
+		}
+		return &Warning{Direction: direction, Used: *used, Quota: quota, Reset: reset}, nil
+	}
+	return nil, nil
+}
+
+func (m *Meter) warned(id enode.ID, w *Warning) {
+	m.mu.Lock()
+	acc, ok := m.accounts[id]
+	if !ok {
+		acc = &account{}
+		m.accounts[id] = acc
+	}
+	acc.WarningsGot++
+	m.mu.Unlock()
+	if m.OnWarning != nil {
+		m.OnWarning(id, w)
+	}
+}
+
+func (m *Meter) connected(id enode.ID, up bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	acc, ok := m.accounts[id]
+	if !ok {
+		acc = &account{}
+		m.accounts[id] = acc
+	}
+	if up {
+		acc.runs++
+		acc.Disconnected = false
+	} else {
+		acc.runs--
+	}
+	acc.Connected = acc.runs > 0
+}
+
+// Protocols returns the protocols with their messages counted
+// each gets one more message code, for the warnings
+func (m *Meter) Protocols(protos []p2p.Protocol) []p2p.Protocol {
+	var metered []p2p.Protocol
+	for _, proto := range protos {
+		metered = append(metered, m.protocol(proto))
+	}
+	return metered
+}
+
+func (m *Meter) protocol(proto p2p.Protocol) p2p.Protocol {
+	run := proto.Run
+	warnCode := proto.Length
+	proto.Length++
+	proto.Run = func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+		m.connected(p.ID(), true)
+		defer m.connected(p.ID(), false)
+		return run(p, &meteredRW{
+			rw:       rw,
+			meter:    m,
+			id:       p.ID(),
+			warnCode: warnCode,
+			drop: func() {
+				log.Debug("dropping peer over quota", "peer", p.ID(), "proto", proto.Name)
+				p.Disconnect(p2p.DiscUselessPeer)
+			},
+		})
+	}
+	return proto
+}
+
+// counts the messages going through, and sends and takes in the warnings
+type meteredRW struct {
+	rw       p2p.MsgReadWriter
+	meter    *Meter
+	id       enode.ID
+	warnCode uint64
+
+	// disconnects the peer, whatever the protocol does with the error
+	drop func()
+}
+
+func (rw *meteredRW) ReadMsg() (p2p.Msg, error) {
+	for {
+		msg, err := rw.rw.ReadMsg()
+		if err != nil {
+			return msg, err
+		}
+		warning, err := rw.meter.count(rw.id, Ingress, uint64(msg.Size))
+		if err != nil {
+			msg.Discard()
+			rw.drop()
+			return p2p.Msg{}, err
+		}
+		if warning != nil {
+			if err := p2p.Send(rw.rw, rw.warnCode, warning); err != nil {
+				return p2p.Msg{}, err
+			}
+		}
+		if msg.Code != rw.warnCode {
+			return msg, nil
+		}
+		var w Warning
+		err = msg.Decode(&w)
+		if err != nil {
+			return p2p.Msg{}, fmt.Errorf("invalid quota warning: %v", err)
+		}
+		rw.meter.warned(rw.id, &w)
+	}
+}
+
+func (rw *meteredRW) WriteMsg(msg p2p.Msg) error {
+	warning, err := rw.meter.count(rw.id, Egress, uint64(msg.Size))
+	if err != nil {
+		rw.drop()
+		return err
+	}
+	if warning != nil {
+		if err := p2p.Send(rw.rw, rw.warnCode, warning); err != nil {
+			return err
+		}
+	}
+	return rw.rw.WriteMsg(msg)
+}
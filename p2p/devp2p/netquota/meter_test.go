@@ -0,0 +1,144 @@
+package netquota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+var testTime = time.Date(2018, 11, 1, 12, 0, 0, 0, time.UTC)
+
+// a meter with a clock the test moves
+func newTestMeter(cfg Config) (*Meter, *time.Time) {
+	now := testTime
+	m := NewMeter(cfg)
+	m.Now = func() time.Time {
+		return now
+	}
+	return m, &now
+}
+
+func TestCount(t *testing.T) {
+	m, now := newTestMeter(Config{Ingress: 100, Egress: 50, Period: time.Second, WarnAt: 0.5})
+	id := enode.ID{1}
+
+	// the warning comes once, when the peer passes half its quota
+	for i, want := range []bool{false, true, false} {
+		w, err := m.count(id, Ingress, 30)
+		if err != nil {
+			t.Fatalf("count %d: %v", i, err)
+		}
+		if (w != nil) != want {
+			t.Fatalf("count %d: warning %v, want %v", i, w, want)
+		}
+	}
+	if _, err := m.count(id, Ingress, 11); err != ErrQuotaExceeded {
+		t.Fatalf("over quota: got %v", err)
+	}
+
+	// egress has a quota, and a warning, of its own
+	if _, err := m.count(id, Egress, 50); err != nil {
+		t.Fatalf("egress: %v", err)
+	}
+
+	// a new period starts from zero, the totals go on
+	*now = now.Add(time.Second)
+	if _, err := m.count(id, Ingress, 90); err != nil {
+		t.Fatalf("new period: %v", err)
+	}
+	stats := m.Stats()[id]
+	if stats.Ingress != 90 || stats.Egress != 0 || stats.TotalIngress != 191 || stats.TotalEgress != 50 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.WarningsSent != 3 || !stats.Disconnected {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// and so does a reset one
+	if err := m.Reset(id); err != nil {
+		t.Fatal(err)
+	}
+	if stats := m.Stats()[id]; stats.Ingress != 0 {
+		t.Fatalf("after reset: %+v", stats)
+	}
+}
+
+func TestCountNoPeriod(t *testing.T) {
+	m, now := newTestMeter(Config{Ingress: 100})
+	id := enode.ID{1}
+	if _, err := m.count(id, Ingress, 100); err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(time.Hour)
+	if _, err := m.count(id, Ingress, 1); err != ErrQuotaExceeded {
+		t.Fatalf("expected %v, got %v", ErrQuotaExceeded, err)
+	}
+}
+
+// two metered ends of a pipe: the one over the quota is warned, then dropped
+func TestMeteredRW(t *testing.T) {
+	local, _ := newTestMeter(Config{Ingress: 100, WarnAt: 0.5})
+	remote, _ := newTestMeter(Config{})
+	warnC := make(chan *Warning, 1)
+	remote.OnWarning = func(id enode.ID, w *Warning) {
+		warnC <- w
+	}
+
+	localPipe, remotePipe := p2p.MsgPipe()
+	defer localPipe.Close()
+	dropped := false
+	lrw := &meteredRW{rw: localPipe, meter: local, id: enode.ID{2}, warnCode: 1, drop: func() { dropped = true }}
+	rrw := &meteredRW{rw: remotePipe, meter: remote, id: enode.ID{1}, warnCode: 1, drop: func() {}}
+
+	// the remote reads all the time, the warning comes in that way
+	readC := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := rrw.ReadMsg(); err != nil {
+				readC <- err
+				return
+			}
+		}
+	}()
+
+	// it sends two messages of 39 bytes, which take it past half its quota, and a third after the warning
+	// writing to a pipe blocks until the other end reads, so the order is always the same
+	var warning *Warning
+	sendC := make(chan error, 1)
+	go func() {
+		for i := 0; i < 2; i++ {
+			if err := p2p.Send(rrw, 0, make([]byte, 38)); err != nil {
+				sendC <- err
+				return
+			}
+		}
+		warning = <-warnC
+		sendC <- p2p.Send(rrw, 0, make([]byte, 38))
+	}()
+
+	for i := 0; i < 2; i++ {
+		msg, err := lrw.ReadMsg()
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		msg.Discard()
+	}
+	if _, err := lrw.ReadMsg(); err != ErrQuotaExceeded {
+		t.Fatalf("expected %v, got %v", ErrQuotaExceeded, err)
+	}
+	if !dropped {
+		t.Fatal("peer over quota not dropped")
+	}
+	if err := <-sendC; err != nil {
+		t.Fatal(err)
+	}
+	if warning.Direction != Ingress || warning.Quota != 100 || warning.Used != 78 {
+		t.Fatalf("unexpected warning %+v", warning)
+	}
+	localPipe.Close()
+	if err := <-readC; err != p2p.ErrPipeClosed {
+		t.Fatalf("remote: %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package peerstore
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+const (
+	defaultDialTimeout  = time.Second * 5
+	defaultProbeTimeout = time.Second * 2
+	dialCheckInterval   = time.Millisecond * 200
+)
+
+// Dialer keeps the records of the peers of a server, and dials the best of them
+//
+// the server dials the nodes added with AddPeer again and again, so a node that doesn't connect in time is removed and counted as a failed dial.
+// Before dialing, the dialer opens a tcp connection to the node, which tells whether it's there at all and how long it takes to reach it
+type Dialer struct {
+	MaxPeers     int           // the peers the dialer dials up to, connected ones included
+	DialTimeout  time.Duration // how long a dial may take to come to a connection
+	ProbeTimeout time.Duration
+
+	store *Store
+	srv   *p2p.Server
+
+	mu        sync.Mutex
+	dialing   map[enode.ID]*dial
+	connected map[enode.ID]*connection
+	sub       event.Subscription
+	quitC     chan struct{}
+	wg        sync.WaitGroup
+}
+
+type dial struct {
+	node  *enode.Node
+	since time.Time
+}
+
+type connection struct {
+	node  *enode.Node
+	since time.Time
+}
+
+// NewDialer creates a dialer for the server, with the records in the store
+func NewDialer(store *Store, srv *p2p.Server, maxPeers int) *Dialer {
+	return &Dialer{
+		MaxPeers:     maxPeers,
+		DialTimeout:  defaultDialTimeout,
+		ProbeTimeout: defaultProbeTimeout,
+		store:        store,
+		srv:          srv,
+		dialing:      make(map[enode.ID]*dial),
+		connected:    make(map[enode.ID]*connection),
+	}
+}
+
+// Start follows the peers of the server, which must be running
+func (d *Dialer) Start() {
+	eventC := make(chan *p2p.PeerEvent, 16)
+	d.sub = d.srv.SubscribeEvents(eventC)
+	d.quitC = make(chan struct{})
+	d.wg.Add(1)
+	go d.loop(eventC)
+}
+
+// Stop stops following the peers
+// the connections still up are counted as ended now, so their time isn't lost
+func (d *Dialer) Stop() {
+	d.sub.Unsubscribe()
+	close(d.quitC)
+	d.wg.Wait()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, c := range d.connected {
+		d.store.Disconnected(c.node, time.Since(c.since))
+		delete(d.connected, id)
+	}
+	for id, dl := range d.dialing {
+		d.srv.RemovePeer(dl.node)
+		delete(d.dialing, id)
+	}
+}
+
+func (d *Dialer) loop(eventC chan *p2p.PeerEvent) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(dialCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-eventC:
+			d.handleEvent(ev)
+		case <-ticker.C:
+			d.expire()
+		case err := <-d.sub.Err():
+			if err != nil {
+				log.Warn("peer store subscription fail", "err", err)
+			}
+			return
+		case <-d.quitC:
+			return
+		}
+	}
+}
+
+func (d *Dialer) handleEvent(ev *p2p.PeerEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch ev.Type {
+	case p2p.PeerEventTypeAdd:
+		// only the nodes the store knows are kept, as only they have an address to dial
+		var n *enode.Node
+		if dl, ok := d.dialing[ev.Peer]; ok {
+			n = dl.node
+			delete(d.dialing, ev.Peer)
+			d.store.Dialed(n, false)
+		} else if r, err := d.store.Get(ev.Peer); err == nil && r != nil {
+			n, _ = enode.ParseV4(r.Node)
+		}
+		if n == nil {
+			return
+		}
+		d.connected[ev.Peer] = &connection{node: n, since: time.Now()}
+		d.store.Connected(n)
+
+	case p2p.PeerEventTypeDrop:
+		c, ok := d.connected[ev.Peer]
+		if !ok {
+			return
+		}
+		delete(d.connected, ev.Peer)
+		d.store.Disconnected(c.node, time.Since(c.since))
+	}
+}
+
+// gives up on the dials that didn't come to a connection in time
+func (d *Dialer) expire() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, dl := range d.dialing {
+		if time.Since(dl.since) < d.DialTimeout {
+			continue
+		}
+		log.Debug("dial timed out", "node", id)
+		d.srv.RemovePeer(dl.node)
+		delete(d.dialing, id)
+		d.store.Dialed(dl.node, true)
+	}
+}
+
+// opens a tcp connection to the node, and returns how long it took
+func (d *Dialer) probe(n *enode.Node) (time.Duration, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%d", n.IP(), n.TCP()), d.ProbeTimeout)
+	if err != nil {
+		return 0, err
+	}
+	conn.Close()
+	return time.Since(start), nil
+}
+
+// Dial adds the nodes to the store, and dials the best the store knows until there are MaxPeers connected or being dialed
+// the nodes that aren't there when probed are counted as failed dials, and the next best are tried
+// returns the nodes dialed
+func (d *Dialer) Dial(nodes ...*enode.Node) ([]*enode.Node, error) {
+	for _, n := range nodes {
+		if err := d.store.Add(n); err != nil {
+			return nil, err
+		}
+	}
+	var dialed []*enode.Node
+	tried := make(map[enode.ID]bool)
+	tried[d.srv.Self().ID()] = true
+	for {
+		d.mu.Lock()
+		free := d.MaxPeers - len(d.dialing) - d.srv.PeerCount()
+		for id := range d.dialing {
+			tried[id] = true
+		}
+		d.mu.Unlock()
+		for _, p := range d.srv.Peers() {
+			tried[p.ID()] = true
+		}
+		if free <= 0 {
+			return dialed, nil
+		}
+		best, err := d.store.Best(free, tried)
+		if err != nil {
+			return dialed, err
+		}
+		if len(best) == 0 {
+			return dialed, nil
+		}
+		for _, n := range best {
+			tried[n.ID()] = true
+			latency, err := d.probe(n)
+			if err != nil {
+				log.Debug("probe fail", "node", n.ID(), "err", err)
+				d.store.Dialed(n, true)
+				continue
+			}
+			d.store.Reached(n, latency)
+			d.mu.Lock()
+			d.dialing[n.ID()] = &dial{node: n, since: time.Now()}
+			d.mu.Unlock()
+			d.srv.AddPeer(n)
+			dialed = append(dialed, n)
+		}
+	}
+}
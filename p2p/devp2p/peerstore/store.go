@@ -0,0 +1,246 @@
+// Package peerstore remembers how the peers of a node did, in a leveldb database, so the node can dial the good ones first when it starts again
+//
+// for every peer it keeps the dials and how many of them came to a connection, how long the connections lasted, and the time it took to reach the peer.
+// Score makes one number of it, and Best gives the peers with the highest.
+// The Dialer in this package keeps the records of a p2p.Server, and dials from them
+package peerstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+const (
+	// a peer whose connections last this long on average has the full score for uptime
+	uptimeTarget = time.Minute * 10
+
+	// the latency that halves the score
+	latencyHalf = time.Millisecond * 100
+
+	// a peer not seen for this long has its score halved
+	staleAge = time.Hour * 24 * 7
+
+	// how much a new latency measure counts in the average
+	latencyWeight = 0.3
+
+	dbCache   = 16
+	dbHandles = 16
+)
+
+var (
+	keyPrefix = []byte("peer:")
+)
+
+// Record is what the store knows of a peer
+type Record struct {
+	Node          string        `json:"node"`
+	Dials         uint64        `json:"dials"`        // dials we made
+	DialFailures  uint64        `json:"dialFailures"` // dials that didn't come to a connection
+	Connections   uint64        `json:"connections"`  // connections either way
+	Uptime        time.Duration `json:"uptime"`       // of all the connections, until they ended
+	Latency       time.Duration `json:"latency"`      // moving average of the time to reach the peer, 0 if never measured
+	LastConnected time.Time     `json:"lastConnected"`
+	LastSeen      time.Time     `json:"lastSeen"` // when the last connection ended, or the peer was last reached
+}
+
+func (r *Record) ID() (enode.ID, error) {
+	n, err := enode.ParseV4(r.Node)
+	if err != nil {
+		return enode.ID{}, err
+	}
+	return n.ID(), nil
+}
+
+// Score is how good a peer has been, from 0 up to 1
+//
+// it is the share of dials that worked, with one success and one failure counted for everyone so a new peer starts at a half,
+// times how long its connections last, a peer never connected counting as one whose connections last no time,
+// and less for the time it takes to reach it and for not having been seen in a while
+func (r *Record) Score(now time.Time) float64 {
+	success := float64(r.Dials-r.DialFailures+1) / float64(r.Dials+2)
+	uptime := 0.0
+	if r.Connections > 0 {
+		avg := r.Uptime / time.Duration(r.Connections)
+		uptime = float64(avg) / float64(uptimeTarget)
+		if uptime > 1 {
+			uptime = 1
+		}
+	}
+	score := success * (0.5 + 0.5*uptime)
+	score /= 1 + float64(r.Latency)/float64(latencyHalf)
+	if !r.LastSeen.IsZero() && now.Sub(r.LastSeen) > staleAge {
+		score /= 2
+	}
+	return score
+}
+
+// Store keeps the records in a leveldb database
+type Store struct {
+	db *ethdb.LDBDatabase
+	mu sync.Mutex
+
+	// the clock for the times in the records
+	Now func() time.Time
+}
+
+// Open opens the store in the directory, and creates it if it isn't there
+func Open(path string) (*Store, error) {
+	db, err := ethdb.NewLDBDatabase(path, dbCache, dbHandles)
+	if err != nil {
+		return nil, fmt.Errorf("open peer store: %v", err)
+	}
+	return &Store{
+		db:  db,
+		Now: time.Now,
+	}, nil
+}
+
+func (s *Store) Close() {
+	s.db.Close()
+}
+
+func key(id enode.ID) []byte {
+	return append(append([]byte{}, keyPrefix...), id[:]...)
+}
+
+// Get returns the record of the peer, nil if there is none
+func (s *Store) Get(id enode.ID) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(id)
+}
+
+func (s *Store) get(id enode.ID) (*Record, error) {
+	has, err := s.db.Has(key(id))
+	if err != nil || !has {
+		return nil, err
+	}
+	b, err := s.db.Get(key(id))
+	if err != nil {
+		return nil, err
+	}
+	var r Record
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *Store) put(id enode.ID, r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(key(id), b)
+}
+
+// changes the record of the node, creating it if there is none
+// the node's address in the record is updated too, the last one is the one to dial
+func (s *Store) update(n *enode.Node, f func(r *Record)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.get(n.ID())
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		r = &Record{}
+	}
+	r.Node = n.String()
+	f(r)
+	return s.put(n.ID(), r)
+}
+
+// Add adds the node if the store doesn't know it, or updates its address if it does
+func (s *Store) Add(n *enode.Node) error {
+	return s.update(n, func(r *Record) {})
+}
+
+// Dialed counts a dial of the node, and whether it failed
+func (s *Store) Dialed(n *enode.Node, failed bool) error {
+	return s.update(n, func(r *Record) {
+		r.Dials++
+		if failed {
+			r.DialFailures++
+		}
+	})
+}
+
+// Connected counts a connection to the node
+func (s *Store) Connected(n *enode.Node) error {
+	return s.update(n, func(r *Record) {
+		r.Connections++
+		r.LastConnected = s.Now()
+	})
+}
+
+// Disconnected adds the time the connection to the node lasted
+func (s *Store) Disconnected(n *enode.Node, uptime time.Duration) error {
+	return s.update(n, func(r *Record) {
+		r.Uptime += uptime
+		r.LastSeen = s.Now()
+	})
+}
+
+// Reached puts the time it took to reach the node in its average
+func (s *Store) Reached(n *enode.Node, latency time.Duration) error {
+	return s.update(n, func(r *Record) {
+		if r.Latency == 0 {
+			r.Latency = latency
+		} else {
+			r.Latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(r.Latency))
+		}
+		r.LastSeen = s.Now()
+	})
+}
+
+// All returns all the records
+func (s *Store) All() ([]*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []*Record
+	it := s.db.NewIteratorWithPrefix(keyPrefix)
+	defer it.Release()
+	for it.Next() {
+		var r Record
+		if err := json.Unmarshal(it.Value(), &r); err != nil {
+			return nil, err
+		}
+		records = append(records, &r)
+	}
+	return records, it.Error()
+}
+
+// Best returns up to count nodes with the highest scores, best first
+// the nodes in skip are left out
+func (s *Store) Best(count int, skip map[enode.ID]bool) ([]*enode.Node, error) {
+	records, err := s.All()
+	if err != nil {
+		return nil, err
+	}
+	now := s.Now()
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Score(now) > records[j].Score(now)
+	})
+	var nodes []*enode.Node
+	for _, r := range records {
+		if len(nodes) == count {
+			break
+		}
+		n, err := enode.ParseV4(r.Node)
+		if err != nil {
+			continue
+		}
+		if skip[n.ID()] {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,133 @@
+package peerstore
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func testNodes(t *testing.T, count int) []*enode.Node {
+	var nodes []*enode.Node
+	for i := 0; i < count; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, enode.NewV4(&key.PublicKey, net.IP{127, 0, 0, 1}, 30100+i, 0))
+	}
+	return nodes
+}
+
+func TestScore(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name          string
+		better, worse Record
+	}{
+		{
+			name:   "dials that worked",
+			better: Record{Dials: 4, DialFailures: 1},
+			worse:  Record{Dials: 4, DialFailures: 3},
+		},
+		{
+			name:   "new against failing",
+			better: Record{},
+			worse:  Record{Dials: 2, DialFailures: 2},
+		},
+		{
+			name:   "uptime",
+			better: Record{Dials: 2, Connections: 2, Uptime: time.Minute * 10},
+			worse:  Record{Dials: 2, Connections: 2, Uptime: time.Second},
+		},
+		{
+			name:   "latency",
+			better: Record{Dials: 1, Latency: time.Millisecond},
+			worse:  Record{Dials: 1, Latency: time.Millisecond * 300},
+		},
+		{
+			name:   "stale",
+			better: Record{Dials: 1, LastSeen: now.Add(-time.Hour)},
+			worse:  Record{Dials: 1, LastSeen: now.Add(-staleAge * 2)},
+		},
+	}
+	for _, test := range tests {
+		better, worse := test.better.Score(now), test.worse.Score(now)
+		if better <= worse {
+			t.Errorf("%s: %v not better than %v", test.name, better, worse)
+		}
+		if better > 1 || worse < 0 {
+			t.Errorf("%s: score out of range, %v and %v", test.name, better, worse)
+		}
+	}
+}
+
+// the records are kept when the store is closed, and the best come first when it's opened again
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peerstore-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers")
+
+	nodes := testNodes(t, 4)
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range nodes {
+		s.Add(n)
+	}
+	// 0 never connects, 1 connects but not for long, 2 stays, 3 is never dialed
+	s.Dialed(nodes[0], true)
+	s.Dialed(nodes[0], true)
+	for _, n := range nodes[1:3] {
+		s.Dialed(n, false)
+		s.Connected(n)
+		s.Reached(n, time.Millisecond)
+	}
+	s.Disconnected(nodes[1], time.Second)
+	s.Disconnected(nodes[2], time.Minute*5)
+	s.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	r, err := s.Get(nodes[2].ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == nil || r.Connections != 1 || r.Uptime != time.Minute*5 || r.Latency != time.Millisecond {
+		t.Fatalf("unexpected record %+v", r)
+	}
+
+	best, err := s.Best(4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*enode.Node{nodes[2], nodes[1], nodes[3], nodes[0]}
+	if len(best) != len(want) {
+		t.Fatalf("got %d nodes, want %d", len(best), len(want))
+	}
+	for i := range want {
+		if best[i].ID() != want[i].ID() {
+			t.Fatalf("node %d is %v, want %v", i, best[i].ID(), want[i].ID())
+		}
+	}
+
+	best, err = s.Best(1, map[enode.ID]bool{nodes[2].ID(): true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(best) != 1 || best[0].ID() != nodes[1].ID() {
+		t.Fatalf("with the best skipped got %v", best)
+	}
+}
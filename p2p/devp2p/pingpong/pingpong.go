@@ -0,0 +1,88 @@
+// Package pingpong is the message handling of the foo ping-pong protocol, apart from any server or connection
+//
+// a handler only needs a p2p.MsgReadWriter, so it can run on one end of a p2p.MsgPipe with the test on the other,
+// with no networking involved. The clock is a field, so the messages it sends are the same every run.
+// pingpong_test.go shows how to test a handler like this, and can be copied as a template
+package pingpong
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+
+	"../envelope"
+)
+
+const (
+	MaxMsgSize = 1024
+)
+
+// Msg is a ping, or a pong if Pong is set
+type Msg struct {
+	Pong    bool
+	Created time.Time
+}
+
+// Handler answers pings with pongs, and counts the pongs it gets
+type Handler struct {
+	Pongs int
+
+	// the time put in the pongs
+	Now func() time.Time
+}
+
+func NewHandler() *Handler {
+	return &Handler{
+		Now: time.Now,
+	}
+}
+
+// Run handles messages until reading fails or a message is refused
+func (h *Handler) Run(rw p2p.MsgReadWriter) error {
+	for {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return err
+		}
+		err = h.HandleMsg(rw, msg)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// HandleMsg handles one message, and writes the answer to w if there is one
+// an error means the peer sent something it shouldn't, and should be dropped
+func (h *Handler) HandleMsg(w p2p.MsgWriter, msg p2p.Msg) error {
+	defer msg.Discard()
+	if msg.Code != 0 {
+		return fmt.Errorf("unknown message code %d", msg.Code)
+	}
+	if msg.Size > MaxMsgSize {
+		return fmt.Errorf("message of %d bytes, max is %d", msg.Size, MaxMsgSize)
+	}
+	var in Msg
+	err := DecodeMsg(msg, &in)
+	if err != nil {
+		return fmt.Errorf("invalid message: %v", err)
+	}
+	if in.Pong {
+		h.Pongs++
+		return nil
+	}
+	return Send(w, &Msg{
+		Pong:    true,
+		Created: h.Now(),
+	})
+}
+
+// Send sends the message in the envelope the protocol uses
+func Send(w p2p.MsgWriter, m *Msg) error {
+	return envelope.Send(w, 0, envelope.JSON, m)
+}
+
+// DecodeMsg decodes a message sent with Send
+func DecodeMsg(msg p2p.Msg, m *Msg) error {
+	return envelope.DecodeMsg(msg, m)
+}
@@ -0,0 +1,153 @@
+package pingpong
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+
+	"../envelope"
+)
+
+// the clock of the handlers in the tests, so we know exactly what they send
+var testTime = time.Date(2018, 11, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestHandler() *Handler {
+	h := NewHandler()
+	h.Now = func() time.Time {
+		return testTime
+	}
+	return h
+}
+
+// the payload of a message as the protocol sends it
+func wrap(t *testing.T, v interface{}) []byte {
+	b, err := envelope.Wrap(envelope.JSON, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// a table driven test of a handler: each case sends one message and says what should come back
+//
+// the handler runs on one end of a p2p.MsgPipe, and the test plays the peer on the other end
+// writing to a pipe blocks until the other end has read the message, so the order of things is always the same
+func TestHandleMsg(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    uint64
+		payload interface{} // sent with p2p.Send
+		reply   *Msg        // the answer we expect, nil for none
+		pongs   int         // the pongs the handler has counted after
+		wantErr bool        // the handler refuses the message, and the peer would be dropped
+	}{
+		{
+			name:    "ping",
+			payload: wrap(t, &Msg{Created: testTime.Add(-time.Second)}),
+			reply:   &Msg{Pong: true, Created: testTime},
+		},
+		{
+			name:    "pong",
+			payload: wrap(t, &Msg{Pong: true}),
+			pongs:   1,
+		},
+		{
+			name:    "unknown code",
+			code:    1,
+			payload: wrap(t, &Msg{}),
+			wantErr: true,
+		},
+		{
+			name:    "not an envelope",
+			payload: []byte("foo"),
+			wantErr: true,
+		},
+		{
+			name:    "not a byte string",
+			payload: []uint{1, 2, 3},
+			wantErr: true,
+		},
+		{
+			name:    "too big",
+			payload: make([]byte, MaxMsgSize),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler()
+			rw, peer := p2p.MsgPipe()
+			defer rw.Close()
+
+			// the handler side
+			errC := make(chan error, 1)
+			go func() {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					errC <- err
+					return
+				}
+				errC <- h.HandleMsg(rw, msg)
+			}()
+
+			// the peer side
+			err := p2p.Send(peer, tt.code, tt.payload)
+			if err != nil {
+				t.Fatalf("send fail: %v", err)
+			}
+			if tt.reply != nil {
+				err = p2p.ExpectMsg(peer, 0, wrap(t, tt.reply))
+				if err != nil {
+					t.Fatalf("wrong reply: %v", err)
+				}
+			}
+
+			err = <-errC
+			if tt.wantErr && err == nil {
+				t.Fatal("message accepted")
+			} else if !tt.wantErr && err != nil {
+				t.Fatalf("message refused: %v", err)
+			}
+			if h.Pongs != tt.pongs {
+				t.Fatalf("counted %d pongs, want %d", h.Pongs, tt.pongs)
+			}
+		})
+	}
+}
+
+// a whole conversation with a running handler
+func TestRun(t *testing.T) {
+	h := newTestHandler()
+	rw, peer := p2p.MsgPipe()
+	errC := make(chan error, 1)
+	go func() {
+		errC <- h.Run(rw)
+	}()
+
+	for i := 0; i < 3; i++ {
+		err := Send(peer, &Msg{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p2p.ExpectMsg(peer, 0, wrap(t, &Msg{Pong: true, Created: testTime}))
+		if err != nil {
+			t.Fatalf("pong %d: %v", i, err)
+		}
+	}
+	err := Send(peer, &Msg{Pong: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// closing our end ends the handler
+	peer.Close()
+	err = <-errC
+	if err != p2p.ErrPipeClosed {
+		t.Fatalf("handler ended with %v", err)
+	}
+	if h.Pongs != 1 {
+		t.Fatalf("counted %d pongs, want 1", h.Pongs)
+	}
+}
@@ -0,0 +1,140 @@
+package pingpong
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"../common/registry"
+)
+
+// the protocol of the service
+const (
+	ProtocolName    = "pingpong"
+	ProtocolVersion = 1
+)
+
+// registered as "pingpong", for a stack put together from the registered services
+func init() {
+	registry.Register(ProtocolName, func(*node.ServiceContext) (node.Service, error) {
+		return NewService(), nil
+	})
+}
+
+// Service runs the protocol with every peer, a handler for each
+type Service struct {
+	mu    sync.Mutex
+	peers map[enode.ID]p2p.MsgWriter
+	pongs map[enode.ID]int
+}
+
+func NewService() *Service {
+	return &Service{
+		peers: make(map[enode.ID]p2p.MsgWriter),
+		pongs: make(map[enode.ID]int),
+	}
+}
+
+func (s *Service) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{
+		{
+			Name:    ProtocolName,
+			Version: ProtocolVersion,
+			Length:  1,
+			Run:     s.run,
+		},
+	}
+}
+
+func (s *Service) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: ProtocolName,
+			Version:   "1.0",
+			Service:   &API{s},
+			Public:    true,
+		},
+	}
+}
+
+func (s *Service) Start(srv *p2p.Server) error {
+	return nil
+}
+
+func (s *Service) Stop() error {
+	return nil
+}
+
+func (s *Service) run(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	id := p.ID()
+	s.mu.Lock()
+	s.peers[id] = rw
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.peers, id)
+		s.mu.Unlock()
+	}()
+
+	// the handler is the peer's alone, only its count is shared
+	h := NewHandler()
+	for {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return err
+		}
+		if err := h.HandleMsg(rw, msg); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.pongs[id] = h.Pongs
+		s.mu.Unlock()
+	}
+}
+
+// Ping sends a ping to all the peers, and returns how many it was sent to
+func (s *Service) Ping() int {
+	s.mu.Lock()
+	var peers []p2p.MsgWriter
+	for _, w := range s.peers {
+		peers = append(peers, w)
+	}
+	s.mu.Unlock()
+	sent := 0
+	for _, w := range peers {
+		if err := Send(w, &Msg{Created: time.Now()}); err == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// Pongs is the pongs got from each peer, by the terminal string of its id
+func (s *Service) Pongs() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pongs := make(map[string]int)
+	for id, n := range s.pongs {
+		pongs[id.TerminalString()] = n
+	}
+	return pongs
+}
+
+// API is the pingpong namespace of the node's rpc
+type API struct {
+	s *Service
+}
+
+// Ping sends a ping to all the peers, and returns how many it was sent to
+func (api *API) Ping() int {
+	return api.s.Ping()
+}
+
+// Pongs is the pongs got from each peer
+func (api *API) Pongs() map[string]int {
+	return api.s.Pongs()
+}
@@ -0,0 +1,133 @@
+// Package presence tells which of the contacts of a node are online, from the heartbeats they send it over pss
+//
+// every node sends a heartbeat to each of its contacts every interval, encrypted to the contact's key,
+// so only the contacts learn it's online. pss gives the handler the key that signed the heartbeat,
+// which is how the tracker knows whose it is. A contact that sent none for as long as the timeout is offline,
+// and one that stops its node says so in a last heartbeat, so it goes offline at once.
+//
+// The changes come as events, to subscribe to in the process or over the presence rpc namespace
+package presence
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotWatched = errors.New("not a contact")
+	ErrStale      = errors.New("heartbeat older than the last one")
+)
+
+// Config is how often heartbeats are sent, and how long a contact stays online without one
+type Config struct {
+	Interval time.Duration `json:"interval"` // between heartbeats, in nanoseconds over json
+	Timeout  time.Duration `json:"timeout"`  // a contact that sent no heartbeat for as long is offline
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval: time.Second * 10,
+		Timeout:  time.Second * 30,
+	}
+}
+
+// Presence is what the tracker knows of a contact, and the events are the same, when it changes
+type Presence struct {
+	Key      string    `json:"key"` // the public key of the contact, in hex, as pss gives it
+	Online   bool      `json:"online"`
+	LastSeen time.Time `json:"lastSeen"` // when its last heartbeat came, zero if none came yet
+	Left     bool      `json:"left"`     // went offline saying so, not missing heartbeats
+	seq      uint64
+}
+
+// Tracker keeps the presence of the contacts, from their heartbeats
+// the times are of the tracker's clock, the clock of the contact only orders its heartbeats
+type Tracker struct {
+	timeout time.Duration
+	mu      sync.Mutex
+	peers   map[string]*Presence
+}
+
+func NewTracker(timeout time.Duration) *Tracker {
+	return &Tracker{
+		timeout: timeout,
+		peers:   make(map[string]*Presence),
+	}
+}
+
+// Watch adds the contact, offline until it sends a heartbeat
+func (t *Tracker) Watch(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.peers[key]; !ok {
+		t.peers[key] = &Presence{Key: key}
+	}
+}
+
+func (t *Tracker) Unwatch(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.peers, key)
+}
+
+// Seen takes a heartbeat of the contact, with the seq it gave, and returns the event if the contact came online
+// or went offline, with a heartbeat saying it leaves. A heartbeat with a seq not above the last one is a replay, or came late
+func (t *Tracker) Seen(key string, seq uint64, leaving bool, at time.Time) (*Presence, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.peers[key]
+	if !ok {
+		return nil, ErrNotWatched
+	}
+	if seq <= p.seq {
+		return nil, ErrStale
+	}
+	p.seq = seq
+	p.LastSeen = at
+	if p.Online == !leaving {
+		return nil, nil
+	}
+	p.Online = !leaving
+	p.Left = leaving
+	e := *p
+	return &e, nil
+}
+
+// Expire takes the contacts that sent no heartbeat within the timeout offline, and returns the events
+func (t *Tracker) Expire(now time.Time) []Presence {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var events []Presence
+	for _, p := range t.peers {
+		if p.Online && now.Sub(p.LastSeen) > t.timeout {
+			p.Online = false
+			events = append(events, *p)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Key < events[j].Key })
+	return events
+}
+
+func (t *Tracker) Get(key string) (Presence, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.peers[key]
+	if !ok {
+		return Presence{}, ErrNotWatched
+	}
+	return *p, nil
+}
+
+// List returns the presence of all the contacts, by key
+func (t *Tracker) List() []Presence {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var list []Presence
+	for _, p := range t.peers {
+		list = append(list, *p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
@@ -0,0 +1,127 @@
+package presence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const testKey = "0x04aa"
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker(time.Second)
+	now := time.Now()
+	if _, err := tracker.Seen(testKey, 1, false, now); err != ErrNotWatched {
+		t.Fatalf("heartbeat of a stranger: %v", err)
+	}
+	tracker.Watch(testKey)
+	if p, err := tracker.Get(testKey); err != nil || p.Online {
+		t.Fatalf("online before a heartbeat: %v", err)
+	}
+
+	e, err := tracker.Seen(testKey, 1, false, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e == nil || !e.Online || !e.LastSeen.Equal(now) {
+		t.Fatalf("no online event: %v", e)
+	}
+	// still online, no event
+	if e, err := tracker.Seen(testKey, 2, false, now.Add(time.Millisecond*500)); err != nil || e != nil {
+		t.Fatalf("event %v, err %v", e, err)
+	}
+	if _, err := tracker.Seen(testKey, 2, false, now.Add(time.Millisecond*600)); err != ErrStale {
+		t.Fatalf("replayed heartbeat: %v", err)
+	}
+
+	if events := tracker.Expire(now.Add(time.Millisecond * 1400)); len(events) != 0 {
+		t.Fatalf("expired within the timeout: %v", events)
+	}
+	events := tracker.Expire(now.Add(time.Millisecond * 1600))
+	if len(events) != 1 || events[0].Online || events[0].Left {
+		t.Fatalf("not expired: %v", events)
+	}
+	if events := tracker.Expire(now.Add(time.Second * 2)); len(events) != 0 {
+		t.Fatalf("expired twice: %v", events)
+	}
+
+	// back, then gone saying so
+	if e, err := tracker.Seen(testKey, 3, false, now.Add(time.Second*2)); err != nil || e == nil || !e.Online {
+		t.Fatalf("not back: %v, %v", e, err)
+	}
+	if e, err := tracker.Seen(testKey, 4, true, now.Add(time.Second*3)); err != nil || e == nil || e.Online || !e.Left {
+		t.Fatalf("not left: %v, %v", e, err)
+	}
+	if list := tracker.List(); len(list) != 1 || list[0].Key != testKey {
+		t.Fatalf("list %v", list)
+	}
+	tracker.Unwatch(testKey)
+	if _, err := tracker.Get(testKey); err != ErrNotWatched {
+		t.Fatalf("unwatched contact: %v", err)
+	}
+}
+
+// the heartbeats the service takes come out as events over rpc
+func TestEvents(t *testing.T) {
+	s := NewService(nil, Config{Interval: time.Second, Timeout: time.Second * 3})
+	s.tracker.Watch(testKey)
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("presence", &API{s: s}); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+	eventC := make(chan Presence)
+	sub, err := client.Subscribe(context.Background(), "presence", eventC, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	beat := func(seq uint64, leaving bool) error {
+		data, err := rlp.EncodeToBytes(&heartbeat{Seq: seq, Leaving: leaving})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.handle(data, nil, true, testKey)
+	}
+	expect := func(online bool) {
+		select {
+		case e := <-eventC:
+			if e.Key != testKey || e.Online != online {
+				t.Fatalf("event %v, expected online %v", e, online)
+			}
+		case err := <-sub.Err():
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+		}
+	}
+
+	if err := beat(1, false); err != nil {
+		t.Fatal(err)
+	}
+	expect(true)
+	if err := beat(1, false); err != ErrStale {
+		t.Fatalf("replayed heartbeat: %v", err)
+	}
+	if err := s.handle([]byte{0xc2, 0x01, 0x80}, nil, false, "0x01"); err != ErrSymmetric {
+		t.Fatalf("heartbeat with a symmetric key: %v", err)
+	}
+	if err := beat(2, true); err != nil {
+		t.Fatal(err)
+	}
+	expect(false)
+
+	var list []Presence
+	if err := client.Call(&list, "presence_list"); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Online || !list[0].Left {
+		t.Fatalf("list %v", list)
+	}
+}
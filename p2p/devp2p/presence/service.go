@@ -0,0 +1,252 @@
+package presence
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+)
+
+const (
+	// how long the last heartbeats get to leave before pss stops
+	leaveGrace = time.Millisecond * 200
+)
+
+var (
+	// Topic is the pss topic of the heartbeats
+	Topic = pss.BytesToTopic([]byte("presence"))
+
+	ErrSymmetric = errors.New("heartbeat not signed")
+)
+
+type heartbeat struct {
+	Seq     uint64
+	Leaving bool
+}
+
+// Service wraps pss, and sends and takes the heartbeats
+//
+// it takes the place of pss as the service of the node, and adds the presence rpc namespace to its apis
+type Service struct {
+	*pss.Pss
+	cfg     Config
+	tracker *Tracker
+	feed    event.Feed
+
+	mu       sync.Mutex
+	contacts map[string]bool // the keys the heartbeats go to
+	seq      uint64
+	quitC    chan struct{}
+	wg       sync.WaitGroup
+	leave    sync.Once
+}
+
+func NewService(ps *pss.Pss, cfg Config) *Service {
+	return &Service{
+		Pss:      ps,
+		cfg:      cfg,
+		tracker:  NewTracker(cfg.Timeout),
+		contacts: make(map[string]bool),
+		// the seqs of a node go on from where they were when it restarts, as long as its clock does
+		seq:   uint64(time.Now().UnixNano()),
+		quitC: make(chan struct{}),
+	}
+}
+
+func (s *Service) Tracker() *Tracker {
+	return s.tracker
+}
+
+// Watch makes the key a contact: the heartbeats of the node go to it at the address, and its own are taken
+func (s *Service) Watch(pub *ecdsa.PublicKey, addr pss.PssAddress) error {
+	if err := s.SetPeerPublicKey(pub, Topic, &addr); err != nil {
+		return err
+	}
+	key := common.ToHex(crypto.FromECDSAPub(pub))
+	s.tracker.Watch(key)
+	s.mu.Lock()
+	s.contacts[key] = true
+	s.mu.Unlock()
+	return nil
+}
+
+// Unwatch drops the contact, it gets no more heartbeats, and sees the node offline once its timeout passes
+func (s *Service) Unwatch(key string) {
+	s.tracker.Unwatch(key)
+	s.mu.Lock()
+	delete(s.contacts, key)
+	s.mu.Unlock()
+}
+
+// SubscribeEvents sends the changes of presence to the channel
+func (s *Service) SubscribeEvents(ch chan<- Presence) event.Subscription {
+	return s.feed.Subscribe(ch)
+}
+
+func (s *Service) Start(srv *p2p.Server) error {
+	if err := s.Pss.Start(srv); err != nil {
+		return err
+	}
+	s.Register(&Topic, pss.NewHandler(s.handle))
+	s.wg.Add(1)
+	go s.loop()
+	return nil
+}
+
+// Leave stops the heartbeats, and tells the contacts the node leaves
+// the node stops its services in no set order, and the peers may be gone by the time Stop gets here,
+// so an app that means to stop calls it first
+func (s *Service) Leave() {
+	s.leave.Do(func() {
+		close(s.quitC)
+		s.wg.Wait()
+		s.beat(true)
+		time.Sleep(leaveGrace)
+	})
+}
+
+func (s *Service) Stop() error {
+	s.Leave()
+	return s.Pss.Stop()
+}
+
+func (s *Service) APIs() []rpc.API {
+	return append(s.Pss.APIs(), rpc.API{
+		Namespace: "presence",
+		Version:   "1.0",
+		Service:   &API{s: s},
+		Public:    true,
+	})
+}
+
+func (s *Service) loop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	s.beat(false)
+	for {
+		select {
+		case <-ticker.C:
+			s.beat(false)
+			for _, e := range s.tracker.Expire(time.Now()) {
+				s.feed.Send(e)
+			}
+		case <-s.quitC:
+			return
+		}
+	}
+}
+
+// sends a heartbeat to every contact
+func (s *Service) beat(leaving bool) {
+	s.mu.Lock()
+	s.seq++
+	data, err := rlp.EncodeToBytes(&heartbeat{Seq: s.seq, Leaving: leaving})
+	var keys []string
+	for key := range s.contacts {
+		keys = append(keys, key)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		log.Error("heartbeat encode fail", "err", err)
+		return
+	}
+	for _, key := range keys {
+		if err := s.SendAsym(key, Topic, data); err != nil {
+			log.Debug("heartbeat send fail", "to", key, "err", err)
+		}
+	}
+}
+
+// pss gives the key that signed the heartbeat as keyid, only those of contacts are taken
+func (s *Service) handle(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+	if !asymmetric {
+		return ErrSymmetric
+	}
+	var hb heartbeat
+	if err := rlp.DecodeBytes(msg, &hb); err != nil {
+		return err
+	}
+	e, err := s.tracker.Seen(keyid, hb.Seq, hb.Leaving, time.Now())
+	if err == ErrNotWatched {
+		// of a node that still has us among its contacts, while we dropped it
+		log.Trace("heartbeat of a stranger", "from", keyid)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if e != nil {
+		s.feed.Send(*e)
+	}
+	return nil
+}
+
+// API is the presence rpc namespace
+type API struct {
+	s *Service
+}
+
+// Watch makes the public key a contact, at the pss address
+func (api *API) Watch(pubkey hexutil.Bytes, addr hexutil.Bytes) error {
+	pub, err := crypto.UnmarshalPubkey(pubkey)
+	if err != nil {
+		return err
+	}
+	return api.s.Watch(pub, pss.PssAddress(addr))
+}
+
+func (api *API) Unwatch(pubkey hexutil.Bytes) {
+	api.s.Unwatch(common.ToHex(pubkey))
+}
+
+// Get returns the presence of the contact
+func (api *API) Get(pubkey hexutil.Bytes) (Presence, error) {
+	return api.s.tracker.Get(common.ToHex(pubkey))
+}
+
+func (api *API) List() []Presence {
+	return api.s.tracker.List()
+}
+
+// Leave tells the contacts the node leaves, and stops the heartbeats
+func (api *API) Leave() {
+	api.s.Leave()
+}
+
+// Events is the subscription to the changes of presence
+func (api *API) Events(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+	// subscribed before it returns, so no event after the call is missed
+	eventC := make(chan Presence, 16)
+	events := api.s.SubscribeEvents(eventC)
+	go func() {
+		defer events.Unsubscribe()
+		for {
+			select {
+			case e := <-eventC:
+				notifier.Notify(sub.ID, e)
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
@@ -0,0 +1,417 @@
+// Package pssharness measures how reliably pss delivers messages in a simulated network
+//
+// a run sends a stream of messages between random nodes, while nodes go down and come back up (churn),
+// and reports how many messages arrived, how many arrived more than once, and how long they took
+// the report can be checked against expectations, so the same run can be used as a test
+package pssharness
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/simulation"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	"github.com/ethereum/go-ethereum/swarm/state"
+)
+
+const (
+	// the full length of an overlay address
+	addressLength = 32
+
+	// the messages start with their sequence number, the rest is filler
+	payloadPrefix = "pssharness"
+)
+
+var (
+	topic = pss.BytesToTopic([]byte(payloadPrefix))
+
+	errTooFewNodes = errors.New("need at least two nodes")
+)
+
+// Config sets up a run
+type Config struct {
+	Nodes         int           // nodes in the simulated network
+	Messages      int           // messages to send
+	Rate          int           // messages sent per second
+	Luminosity    int           // bytes of the recipient's address revealed in the messages, 0 to 32
+	Timeout       time.Duration // how long to wait for messages still underway when all are sent
+	ChurnInterval time.Duration // how often a node goes down or comes back, 0 for no churn
+	ChurnMax      int           // at most this many nodes are down at the same time
+	HealthTimeout time.Duration // how long to wait for the network to be ready before sending
+	Seed          int64         // seed for picking nodes, 0 for time based
+}
+
+// DefaultConfig is a network of 50 nodes sending 1000 messages without churn
+// the rate is low enough for a single core to keep up, above that latency measures the cpu rather than the network
+func DefaultConfig() Config {
+	return Config{
+		Nodes:         50,
+		Messages:      1000,
+		Rate:          20,
+		Luminosity:    addressLength,
+		Timeout:       time.Second * 5,
+		ChurnMax:      5,
+		HealthTimeout: time.Second * 30,
+	}
+}
+
+// what we keep of each message sent
+type sentMsg struct {
+	recipient enode.ID
+	sent      time.Time
+	received  int
+}
+
+type delivery struct {
+	id       enode.ID
+	payload  []byte
+	received time.Time
+}
+
+// the state of a run
+type harness struct {
+	cfg Config
+	sim *simulation.Simulation
+	rnd *rand.Rand
+
+	mu        sync.Mutex
+	keys      map[enode.ID]*ecdsa.PrivateKey // pss keys, which stay the same when a node restarts
+	kads      map[enode.ID]*network.Kademlia // kademlias, shared between bzz and pss, and kept over restarts
+	pss       map[enode.ID]*pss.Pss          // the pss of the running instance of each node
+	up        map[enode.ID]bool              // the nodes that are running
+	down      []enode.ID                     // the nodes brought down by churn, oldest first
+	msgs      map[uint64]*sentMsg            // the messages sent, by sequence number
+	deliveryC chan delivery
+	quit      chan struct{} // closed when we stop counting deliveries
+
+	report *Report
+}
+
+// Run sets up the network, sends the messages and reports what happened to them
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.Nodes < 2 {
+		return nil, errTooFewNodes
+	}
+	if cfg.Luminosity < 0 || cfg.Luminosity > addressLength {
+		return nil, fmt.Errorf("luminosity must be 0 to %d", addressLength)
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	h := &harness{
+		cfg:       cfg,
+		rnd:       rand.New(rand.NewSource(cfg.Seed)),
+		keys:      make(map[enode.ID]*ecdsa.PrivateKey),
+		kads:      make(map[enode.ID]*network.Kademlia),
+		pss:       make(map[enode.ID]*pss.Pss),
+		up:        make(map[enode.ID]bool),
+		msgs:      make(map[uint64]*sentMsg),
+		deliveryC: make(chan delivery, 1024),
+		quit:      make(chan struct{}),
+		report:    &Report{Config: cfg},
+	}
+	h.sim = simulation.New(h.services())
+	defer h.sim.Close()
+
+	// the network starts as a ring, and the nodes find more peers through the hive
+	ids, err := h.sim.AddNodesAndConnectRing(cfg.Nodes)
+	if err != nil {
+		return nil, fmt.Errorf("create network fail: %v", err)
+	}
+	for _, id := range ids {
+		h.up[id] = true
+	}
+	healthctx, cancel := context.WithTimeout(ctx, cfg.HealthTimeout)
+	ill, err := h.sim.WaitTillHealthy(healthctx, network.NewKadParams().MinProxBinSize)
+	cancel()
+	if err != nil {
+		// we can still measure, but routing is worse than it could be
+		log.Warn("network not healthy", "ill", len(ill), "nodes", len(ids))
+		h.report.Unhealthy = len(ill)
+	}
+
+	// the deliveries are counted as they come
+	collectDone := make(chan struct{})
+	go func() {
+		defer close(collectDone)
+		for {
+			select {
+			case d := <-h.deliveryC:
+				h.receive(d)
+			case <-h.quit:
+				return
+			}
+		}
+	}()
+
+	started := time.Now()
+	churnctx, stopChurn := context.WithCancel(ctx)
+	churnDone := make(chan struct{})
+	go func() {
+		defer close(churnDone)
+		h.churn(churnctx)
+	}()
+
+	err = h.send(ctx)
+
+	// give the last messages time to arrive, and keep the churn going meanwhile
+	if err == nil {
+		select {
+		case <-time.After(cfg.Timeout):
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	stopChurn()
+	<-churnDone
+
+	// anything arriving later is too late to count
+	close(h.quit)
+	<-collectDone
+	h.report.Duration = time.Since(started)
+	if err != nil {
+		return nil, err
+	}
+	h.report.finish()
+	return h.report, nil
+}
+
+// the bzz and pss services of the simulated nodes
+// a restarted node gets new service instances, but keeps its kademlia and its pss key
+func (h *harness) services() map[string]simulation.ServiceFunc {
+	return map[string]simulation.ServiceFunc{
+		"bzz": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			addr := network.NewAddr(ctx.Config.Node())
+			kad := h.kademlia(ctx.Config.ID)
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+			config := &network.BzzConfig{
+				OverlayAddr:  addr.Over(),
+				UnderlayAddr: addr.Under(),
+				HiveParams:   network.NewHiveParams(),
+			}
+			return network.NewBzz(config, kad, state.NewInmemoryStore(), nil, nil), nil, nil
+		},
+		"pss": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			id := ctx.Config.ID
+			key, err := h.key(id)
+			if err != nil {
+				return nil, nil, err
+			}
+			params := pss.NewPssParams().WithPrivateKey(key)
+			ps, err := pss.NewPss(h.kademlia(id), params)
+			if err != nil {
+				return nil, nil, err
+			}
+			ps.Register(&topic, pss.NewHandler(func(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+				select {
+				case h.deliveryC <- delivery{id: id, payload: msg, received: time.Now()}:
+				case <-h.quit:
+				}
+				return nil
+			}))
+			h.mu.Lock()
+			h.pss[id] = ps
+			h.mu.Unlock()
+			return ps, nil, nil
+		},
+	}
+}
+
+func (h *harness) kademlia(id enode.ID) *network.Kademlia {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if k, ok := h.kads[id]; ok {
+		return k
+	}
+	h.kads[id] = network.NewKademlia(id[:], network.NewKadParams())
+	return h.kads[id]
+}
+
+func (h *harness) key(id enode.ID) (*ecdsa.PrivateKey, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if k, ok := h.keys[id]; ok {
+		return k, nil
+	}
+	k, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	h.keys[id] = k
+	return k, nil
+}
+
+// picks a running node other than the excluded one
+// must be called with the lock held
+func (h *harness) randomUp(exclude enode.ID) (enode.ID, bool) {
+	var ids []enode.ID
+	for id := range h.up {
+		if id != exclude {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return enode.ID{}, false
+	}
+	// map order is random, but not by our seed
+	sortIDs(ids)
+	return ids[h.rnd.Intn(len(ids))], true
+}
+
+// sends the messages at the configured rate
+func (h *harness) send(ctx context.Context) error {
+	interval := time.Second
+	if h.cfg.Rate > 0 {
+		interval = time.Second / time.Duration(h.cfg.Rate)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for seq := uint64(0); seq < uint64(h.cfg.Messages); seq++ {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		h.sendOne(seq)
+	}
+	return nil
+}
+
+func (h *harness) sendOne(seq uint64) {
+	h.mu.Lock()
+	from, ok := h.randomUp(enode.ID{})
+	var to enode.ID
+	if ok {
+		to, ok = h.randomUp(from)
+	}
+	if !ok {
+		h.report.SendErrors++
+		h.mu.Unlock()
+		return
+	}
+	sender := h.pss[from]
+	key := h.keys[to]
+	addr := pss.PssAddress(h.kads[to].BaseAddr()[:h.cfg.Luminosity])
+	h.msgs[seq] = &sentMsg{recipient: to, sent: time.Now()}
+	h.report.Sent++
+	h.mu.Unlock()
+
+	payload := make([]byte, 8, 8+len(payloadPrefix))
+	binary.BigEndian.PutUint64(payload, seq)
+	payload = append(payload, payloadPrefix...)
+
+	// the sender may go down while we do this, in which case the message is simply not delivered
+	err := sender.SetPeerPublicKey(&key.PublicKey, topic, &addr)
+	if err == nil {
+		err = sender.SendAsym(common.ToHex(crypto.FromECDSAPub(&key.PublicKey)), topic, payload)
+	}
+	if err != nil {
+		log.Debug("send fail", "seq", seq, "err", err)
+		h.mu.Lock()
+		h.report.SendErrors++
+		h.mu.Unlock()
+	}
+}
+
+func (h *harness) receive(d delivery) {
+	if len(d.payload) < 8 {
+		return
+	}
+	seq := binary.BigEndian.Uint64(d.payload)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	msg, ok := h.msgs[seq]
+	if !ok {
+		return
+	}
+	if d.id != msg.recipient {
+		h.report.Misdelivered++
+		return
+	}
+	msg.received++
+	if msg.received == 1 {
+		h.report.Delivered++
+		h.report.Latencies = append(h.report.Latencies, d.received.Sub(msg.sent))
+	} else {
+		h.report.Duplicates++
+	}
+}
+
+// takes a node down or brings one back up, every churn interval
+// nodes come back in the order they went down, and are connected to a random running node
+func (h *harness) churn(ctx context.Context) {
+	if h.cfg.ChurnInterval == 0 {
+		return
+	}
+	ticker := time.NewTicker(h.cfg.ChurnInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		h.mu.Lock()
+		bringUp := len(h.down) >= h.cfg.ChurnMax || (len(h.down) > 0 && h.rnd.Intn(2) == 0)
+		var id enode.ID
+		var ok bool
+		if bringUp {
+			id, h.down = h.down[0], h.down[1:]
+		} else {
+			id, ok = h.randomUp(enode.ID{})
+			if !ok || len(h.up) <= 2 {
+				h.mu.Unlock()
+				continue
+			}
+			delete(h.up, id)
+			h.down = append(h.down, id)
+		}
+		h.mu.Unlock()
+
+		if bringUp {
+			err := h.sim.StartNode(id)
+			if err == nil {
+				h.mu.Lock()
+				peer, ok := h.randomUp(id)
+				h.mu.Unlock()
+				if ok {
+					err = h.sim.Net.Connect(id, peer)
+				}
+			}
+			if err != nil {
+				log.Warn("churn start fail", "node", id, "err", err)
+				continue
+			}
+			h.mu.Lock()
+			h.up[id] = true
+			h.report.ChurnStarts++
+			h.mu.Unlock()
+			log.Debug("churn up", "node", id)
+		} else {
+			err := h.sim.StopNode(id)
+			if err != nil {
+				log.Warn("churn stop fail", "node", id, "err", err)
+				continue
+			}
+			h.mu.Lock()
+			h.report.ChurnStops++
+			h.mu.Unlock()
+			log.Debug("churn down", "node", id)
+		}
+	}
+}
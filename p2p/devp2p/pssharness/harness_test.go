@@ -0,0 +1,45 @@
+package pssharness
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDelivery(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Nodes = 16
+	cfg.Messages = 200
+	cfg.Timeout = time.Second * 2
+	report, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(report)
+	report.Assert(t, Expectation{
+		MinDeliveryRate:  0.95,
+		MaxDuplicateRate: 0.05,
+		MaxLatency:       time.Second,
+		NoMisdelivery:    true,
+	})
+}
+
+// the full size network with nodes coming and going
+// messages to nodes that are down are lost, so we expect less to arrive
+func TestDeliveryChurn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("long running")
+	}
+	cfg := DefaultConfig()
+	cfg.Messages = 400
+	cfg.ChurnInterval = time.Second
+	report, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(report)
+	report.Assert(t, Expectation{
+		MinDeliveryRate: 0.8,
+		NoMisdelivery:   true,
+	})
+}
@@ -0,0 +1,132 @@
+package pssharness
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// Report is what happened to the messages of a run
+type Report struct {
+	Config       Config
+	Sent         int             // messages sent
+	SendErrors   int             // messages pss refused to send, or that had no nodes to go between
+	Delivered    int             // messages that reached their recipient
+	Duplicates   int             // deliveries of messages that had already reached their recipient
+	Misdelivered int             // deliveries to a node that wasn't the recipient
+	ChurnStops   int             // nodes taken down during the run
+	ChurnStarts  int             // nodes brought back up during the run
+	Unhealthy    int             // nodes whose kademlia wasn't healthy when sending started
+	Latencies    []time.Duration // time from send to first delivery of each delivered message, sorted
+	Duration     time.Duration
+}
+
+func (r *Report) finish() {
+	sort.Slice(r.Latencies, func(i, j int) bool {
+		return r.Latencies[i] < r.Latencies[j]
+	})
+}
+
+// DeliveryRate is the share of the messages sent that reached their recipient
+func (r *Report) DeliveryRate() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Delivered) / float64(r.Sent)
+}
+
+// DuplicateRate is the number of duplicate deliveries per delivered message
+func (r *Report) DuplicateRate() float64 {
+	if r.Delivered == 0 {
+		return 0
+	}
+	return float64(r.Duplicates) / float64(r.Delivered)
+}
+
+// Percentile returns the latency that the given share (0 to 1) of the delivered messages were faster than
+func (r *Report) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(r.Latencies))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(r.Latencies) {
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+func (r *Report) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "nodes %d, luminosity %d, churn %d down / %d up, %d unhealthy at start, run %v\n", r.Config.Nodes, r.Config.Luminosity, r.ChurnStops, r.ChurnStarts, r.Unhealthy, r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(&b, "sent %d (%d errors), delivered %d (%.1f%%), duplicates %d (%.3f per delivery), misdelivered %d\n", r.Sent, r.SendErrors, r.Delivered, r.DeliveryRate()*100, r.Duplicates, r.DuplicateRate(), r.Misdelivered)
+	var lat []string
+	for _, p := range []float64{0.5, 0.9, 0.99, 1} {
+		lat = append(lat, fmt.Sprintf("p%g %v", p*100, r.Percentile(p).Round(time.Microsecond)))
+	}
+	fmt.Fprintf(&b, "latency %s", strings.Join(lat, ", "))
+	return b.String()
+}
+
+// Expectation is what a run should at least achieve
+// zero values are not checked
+type Expectation struct {
+	MinDeliveryRate   float64       // share of the messages that must be delivered
+	MaxDuplicateRate  float64       // duplicate deliveries allowed per delivered message
+	LatencyPercentile float64       // the latency percentile MaxLatency applies to, 0.99 if not set
+	MaxLatency        time.Duration // the latency the percentile must stay within
+	NoMisdelivery     bool          // no message may be delivered to the wrong node
+}
+
+// Check returns an error describing every expectation the report doesn't meet
+func (r *Report) Check(e Expectation) error {
+	var fails []string
+	if e.MinDeliveryRate > 0 && r.DeliveryRate() < e.MinDeliveryRate {
+		fails = append(fails, fmt.Sprintf("delivery rate %.3f below %.3f", r.DeliveryRate(), e.MinDeliveryRate))
+	}
+	if e.MaxDuplicateRate > 0 && r.DuplicateRate() > e.MaxDuplicateRate {
+		fails = append(fails, fmt.Sprintf("duplicate rate %.3f above %.3f", r.DuplicateRate(), e.MaxDuplicateRate))
+	}
+	if e.MaxLatency > 0 {
+		p := e.LatencyPercentile
+		if p == 0 {
+			p = 0.99
+		}
+		if r.Percentile(p) > e.MaxLatency {
+			fails = append(fails, fmt.Sprintf("p%g latency %v above %v", p*100, r.Percentile(p), e.MaxLatency))
+		}
+	}
+	if e.NoMisdelivery && r.Misdelivered > 0 {
+		fails = append(fails, fmt.Sprintf("%d messages delivered to the wrong node", r.Misdelivered))
+	}
+	if len(fails) > 0 {
+		return fmt.Errorf("%s", strings.Join(fails, "; "))
+	}
+	return nil
+}
+
+// TestingT is the part of *testing.T that Assert needs
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Assert fails the test if the report doesn't meet the expectation
+func (r *Report) Assert(t TestingT, e Expectation) {
+	t.Helper()
+	if err := r.Check(e); err != nil {
+		t.Errorf("pss delivery: %v\n%s", err, r)
+	}
+}
+
+func sortIDs(ids []enode.ID) {
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+}
@@ -0,0 +1,209 @@
+// Package psskeys keeps the keys registered with pss in a file, so a node that restarts can register them again
+//
+// pss holds the public keys of its peers, and the symmetric keys it shares with them, in memory only, and forgets them when it stops.
+// The store writes a key to its file when it registers it with pss, and Restore registers them all again after a restart,
+// without the out-of-band exchange that brought them in the first place.
+// pss gives a symmetric key a new id every time it's registered, so the store knows them by a name of the caller's choosing,
+// and SymKeyID gives the id of the current run
+package psskeys
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var (
+	ErrNoName = errors.New("symmetric key without a name")
+)
+
+// Caller makes the rpc calls to the pss API, an *rpc.Client will do
+type Caller interface {
+	Call(result interface{}, method string, args ...interface{}) error
+}
+
+// PeerKey is the public key of a peer, with the topic and address it's registered for
+type PeerKey struct {
+	PublicKey hexutil.Bytes `json:"publicKey"`
+	Topic     string        `json:"topic"`   // hex, as pss_stringToTopic gives it
+	Address   string        `json:"address"` // hex, the part of the peer's overlay address to send to
+}
+
+// ID is the id pss knows the key by, the one to send to with pss_sendAsym
+func (k *PeerKey) ID() string {
+	return k.PublicKey.String()
+}
+
+// SymKey is a symmetric key shared with a peer
+type SymKey struct {
+	Name    string `json:"name"`
+	Key     []byte `json:"key"` // base64 over json, the way pss_setSymmetricKey takes it
+	Topic   string `json:"topic"`
+	Address string `json:"address"`
+}
+
+// what's in the file
+type keys struct {
+	Peers []*PeerKey `json:"peers"`
+	Sym   []*SymKey  `json:"sym"`
+}
+
+// Store keeps the keys in a json file
+//
+// the file holds the symmetric keys in the clear, and is only readable by its owner
+type Store struct {
+	path string
+
+	mu     sync.Mutex
+	keys   keys
+	symIDs map[string]string // the ids pss gave the symmetric keys in this run, by name
+}
+
+// Open reads the keys in the file at path, if there is one
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:   path,
+		symIDs: make(map[string]string),
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read pss keys: %v", err)
+	}
+	if err := json.Unmarshal(b, &s.keys); err != nil {
+		return nil, fmt.Errorf("invalid pss keys in %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// writes the keys to a file next to the old one, and moves it over the old one, so a crash can't leave half a file
+func (s *Store) save() error {
+	b, err := json.MarshalIndent(&s.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("write pss keys: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write pss keys: %v", err)
+	}
+	return nil
+}
+
+// Peers returns the public keys in the store
+func (s *Store) Peers() []PeerKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var peers []PeerKey
+	for _, k := range s.keys.Peers {
+		peers = append(peers, *k)
+	}
+	return peers
+}
+
+// SymKeys returns the symmetric keys in the store
+func (s *Store) SymKeys() []SymKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sym []SymKey
+	for _, k := range s.keys.Sym {
+		sym = append(sym, *k)
+	}
+	return sym
+}
+
+// SymKeyID returns the id pss gave the symmetric key of that name in this run
+// false if the key wasn't registered since the node started, or isn't in the store
+func (s *Store) SymKeyID(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.symIDs[name]
+	return id, ok
+}
+
+// SetPeerPublicKey registers the public key with pss, and keeps it
+// a key already in the store for the same topic is replaced
+func (s *Store) SetPeerPublicKey(c Caller, key PeerKey) error {
+	if err := c.Call(nil, "pss_setPeerPublicKey", key.PublicKey, key.Topic, key.Address); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	replaced := false
+	for i, k := range s.keys.Peers {
+		if k.ID() == key.ID() && k.Topic == key.Topic {
+			s.keys.Peers[i] = &key
+			replaced = true
+		}
+	}
+	if !replaced {
+		s.keys.Peers = append(s.keys.Peers, &key)
+	}
+	return s.save()
+}
+
+// SetSymmetricKey registers the symmetric key with pss, and keeps it under its name
+// it returns the id pss gave it, a key already in the store with the same name is replaced
+func (s *Store) SetSymmetricKey(c Caller, key SymKey) (string, error) {
+	if key.Name == "" {
+		return "", ErrNoName
+	}
+	id, err := setSymmetricKey(c, &key)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.symIDs[key.Name] = id
+	replaced := false
+	for i, k := range s.keys.Sym {
+		if k.Name == key.Name {
+			s.keys.Sym[i] = &key
+			replaced = true
+		}
+	}
+	if !replaced {
+		s.keys.Sym = append(s.keys.Sym, &key)
+	}
+	return id, s.save()
+}
+
+// the key is added to the cache of pss, so the messages sent with it are decrypted too
+func setSymmetricKey(c Caller, key *SymKey) (string, error) {
+	var id string
+	err := c.Call(&id, "pss_setSymmetricKey", key.Key, key.Topic, key.Address, true)
+	return id, err
+}
+
+// Restore registers all the keys in the store with pss again
+// it's for a node that just started, the keys registered before are gone with the node that stopped
+func (s *Store) Restore(c Caller) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.keys.Peers {
+		if err := c.Call(nil, "pss_setPeerPublicKey", k.PublicKey, k.Topic, k.Address); err != nil {
+			return fmt.Errorf("restore public key %s: %v", k.ID(), err)
+		}
+	}
+	for _, k := range s.keys.Sym {
+		id, err := setSymmetricKey(c, k)
+		if err != nil {
+			return fmt.Errorf("restore symmetric key %q: %v", k.Name, err)
+		}
+		s.symIDs[k.Name] = id
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package psskeys
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// records the calls, and gives the symmetric keys ids the way pss does, new every time
+type testCaller struct {
+	prefix string
+	calls  []string
+	symIDs int
+}
+
+func (c *testCaller) Call(result interface{}, method string, args ...interface{}) error {
+	c.calls = append(c.calls, fmt.Sprintf("%s %v", method, args))
+	if method == "pss_setSymmetricKey" {
+		c.symIDs++
+		*result.(*string) = fmt.Sprintf("%s%d", c.prefix, c.symIDs)
+	}
+	return nil
+}
+
+func TestRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psskeys-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sub", "psskeys.json")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCaller{prefix: "first"}
+	peer := PeerKey{PublicKey: []byte{4, 1, 2}, Topic: "0x01020304", Address: "0xaa"}
+	if err := s.SetPeerPublicKey(c, peer); err != nil {
+		t.Fatal(err)
+	}
+	// the same key again replaces it
+	if err := s.SetPeerPublicKey(c, peer); err != nil {
+		t.Fatal(err)
+	}
+	id, err := s.SetSymmetricKey(c, SymKey{Name: "chat", Key: []byte{9, 9}, Topic: "0x01020304", Address: "0xbb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "first1" {
+		t.Fatalf("symmetric key id %q", id)
+	}
+	if _, err := s.SetSymmetricKey(c, SymKey{Key: []byte{1}}); err != ErrNoName {
+		t.Fatalf("key without a name: %v", err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("file mode %v", fi.Mode())
+	}
+
+	// what a node that restarts does
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peers := s.Peers(); len(peers) != 1 || peers[0].ID() != "0x040102" {
+		t.Fatalf("peers %+v", peers)
+	}
+	if _, ok := s.SymKeyID("chat"); ok {
+		t.Fatal("symmetric key id known before restoring")
+	}
+	c = &testCaller{prefix: "second"}
+	if err := s.Restore(c); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"pss_setPeerPublicKey [0x040102 0x01020304 0xaa]",
+		"pss_setSymmetricKey [[9 9] 0x01020304 0xbb true]",
+	}
+	if !reflect.DeepEqual(c.calls, want) {
+		t.Fatalf("calls %q, want %q", c.calls, want)
+	}
+	if id, ok := s.SymKeyID("chat"); !ok || id != "second1" {
+		t.Fatalf("symmetric key id %q %v", id, ok)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psskeys-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "psskeys.json")
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Fatal("no error opening an invalid file")
+	}
+}
@@ -1,7 +1,8 @@
 #!/bin/bash
 
+# the arguments are passed on to every example, e.g. ./runall.sh -golden golden
 for f in *.go; do
-	go run $f
+	go run $f "$@"
 	res=$?
 	if [ $res -gt 0 ]; then
 		echo $f returned $res
@@ -0,0 +1,79 @@
+package specdoc
+
+import (
+	"bytes"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// API describes the protocols of a node over rpc, as spec_describe and spec_markdown
+type API struct {
+	specs []*protocols.Spec
+}
+
+func NewAPI(specs ...*protocols.Spec) *API {
+	return &API{
+		specs: specs,
+	}
+}
+
+// Describe returns the message formats of all the protocols
+func (api *API) Describe() ([]*Protocol, error) {
+	var protos []*Protocol
+	for _, spec := range api.specs {
+		p, err := Describe(spec)
+		if err != nil {
+			return nil, err
+		}
+		protos = append(protos, p)
+	}
+	return protos, nil
+}
+
+// Markdown returns the message formats of all the protocols as markdown
+func (api *API) Markdown() (string, error) {
+	protos, err := api.Describe()
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = Markdown(&b, protos...)
+	return b.String(), err
+}
+
+// Service is a node.Service with nothing but the spec api
+// it can be registered on any node next to the services running the protocols
+type Service struct {
+	specs []*protocols.Spec
+}
+
+func NewService(specs ...*protocols.Spec) *Service {
+	return &Service{
+		specs: specs,
+	}
+}
+
+func (self *Service) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "spec",
+			Version:   "1.0",
+			Service:   NewAPI(self.specs...),
+			Public:    true,
+		},
+	}
+}
+
+func (self *Service) Protocols() []p2p.Protocol {
+	return nil
+}
+
+func (self *Service) Start(srv *p2p.Server) error {
+	return nil
+}
+
+func (self *Service) Stop() error {
+	return nil
+}
@@ -0,0 +1,83 @@
+package specdoc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Markdown writes the message formats of the protocols as markdown tables
+// nested fields are flattened, with the index of each field in its rlp list
+func Markdown(w io.Writer, protos ...*Protocol) error {
+	var b bytes.Buffer
+	for _, p := range protos {
+		fmt.Fprintf(&b, "## %s v%d\n\n", p.Name, p.Version)
+		if p.MaxMsgSize > 0 {
+			fmt.Fprintf(&b, "Maximum message size %d bytes.\n\n", p.MaxMsgSize)
+		}
+		for _, m := range p.Messages {
+			fmt.Fprintf(&b, "### %d: %s\n\n", m.Code, m.Name)
+			if m.Schema.CustomRLP {
+				fmt.Fprintf(&b, "The message has its own rlp encoding, the layout below is the default one.\n\n")
+			}
+			fmt.Fprintf(&b, "| RLP index | Field | JSON type | RLP | Notes |\n")
+			fmt.Fprintf(&b, "|---|---|---|---|---|\n")
+			rows(&b, "", "", m.Schema)
+			fmt.Fprintf(&b, "\n")
+		}
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func rows(b *bytes.Buffer, index string, name string, s *Schema) {
+	if s.Type == "object" {
+		for i, field := range s.PropertyOrder {
+			rows(b, join(index, fmt.Sprintf("%d", i)), join(name, field), s.Properties[field])
+		}
+		return
+	}
+	if name != "" {
+		fmt.Fprintf(b, "| %s | %s | %s | %s | %s |\n", index, name, jsonType(s), s.RLP, notes(s))
+	}
+	if s.Type == "array" && s.Items.Type == "object" {
+		rows(b, index+".n", name+"[]", s.Items)
+	}
+}
+
+func jsonType(s *Schema) string {
+	switch {
+	case s.Type == "array":
+		return "array of " + jsonType(s.Items)
+	case s.Format != "":
+		return fmt.Sprintf("%s (%s)", s.Type, s.Format)
+	case s.ContentEncoding != "":
+		return fmt.Sprintf("%s (%s)", s.Type, s.ContentEncoding)
+	}
+	return s.Type
+}
+
+func notes(s *Schema) string {
+	var n []string
+	if s.MinItems != nil && s.MaxItems != nil && *s.MinItems == *s.MaxItems {
+		n = append(n, fmt.Sprintf("%d items", *s.MinItems))
+	}
+	if s.Tail {
+		n = append(n, "takes the rest of the list")
+	}
+	if s.CustomRLP {
+		n = append(n, "own rlp encoding")
+	}
+	if s.Description != "" {
+		n = append(n, s.Description)
+	}
+	return strings.Join(n, ", ")
+}
+
+func join(prefix string, s string) string {
+	if prefix == "" {
+		return s
+	}
+	return prefix + "." + s
+}
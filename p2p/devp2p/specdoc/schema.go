@@ -0,0 +1,259 @@
+// Package specdoc describes the messages of a protocols.Spec, for people writing compatible clients in other languages
+//
+// each message gets a JSON Schema of its JSON form, which is what the RPC methods of the examples show,
+// with the RLP encoding of every field added as "x-rlp". The fields are listed in "propertyOrder" in the order
+// of the RLP list they're encoded in, since RLP has no field names
+package specdoc
+
+import (
+	"encoding"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	SchemaVersion = "http://json-schema.org/draft-07/schema#"
+)
+
+// the rlp encodings of a field
+const (
+	RLPUint   = "uint"
+	RLPBool   = "bool"
+	RLPString = "string"
+	RLPBytes  = "bytes"
+	RLPList   = "list"
+)
+
+var (
+	bigIntType        = reflect.TypeOf(big.Int{})
+	timeType          = reflect.TypeOf(time.Time{})
+	byteType          = reflect.TypeOf(byte(0))
+	rlpEncoderType    = reflect.TypeOf((*rlp.Encoder)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Protocol describes a protocols.Spec
+type Protocol struct {
+	Name       string     `json:"name"`
+	Version    uint       `json:"version"`
+	MaxMsgSize uint32     `json:"maxMsgSize"`
+	Messages   []*Message `json:"messages"`
+}
+
+// Message is a message of the protocol, with the code it's sent with
+type Message struct {
+	Code   uint64  `json:"code"`
+	Name   string  `json:"name"`
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the part of JSON Schema we need for describing messages
+type Schema struct {
+	Schema          string             `json:"$schema,omitempty"`
+	Title           string             `json:"title,omitempty"`
+	Description     string             `json:"description,omitempty"`
+	Type            string             `json:"type,omitempty"`
+	Format          string             `json:"format,omitempty"`
+	ContentEncoding string             `json:"contentEncoding,omitempty"`
+	Minimum         *int               `json:"minimum,omitempty"`
+	Maximum         *int               `json:"maximum,omitempty"`
+	Items           *Schema            `json:"items,omitempty"`
+	MinItems        *int               `json:"minItems,omitempty"`
+	MaxItems        *int               `json:"maxItems,omitempty"`
+	Properties      map[string]*Schema `json:"properties,omitempty"`
+	PropertyOrder   []string           `json:"propertyOrder,omitempty"`
+	Required        []string           `json:"required,omitempty"`
+
+	// how the value is encoded in rlp
+	RLP string `json:"x-rlp,omitempty"`
+
+	// the type has its own rlp encoding, which may not be the one shown
+	CustomRLP bool `json:"x-rlp-custom,omitempty"`
+
+	// the field takes the rest of the rlp list
+	Tail bool `json:"x-rlp-tail,omitempty"`
+}
+
+// Describe describes all messages of the spec
+func Describe(spec *protocols.Spec) (*Protocol, error) {
+	p := &Protocol{
+		Name:       spec.Name,
+		Version:    spec.Version,
+		MaxMsgSize: spec.MaxMsgSize,
+	}
+	for _, msg := range spec.Messages {
+		code, _ := spec.GetCode(msg)
+		typ := reflect.TypeOf(msg)
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		s, err := TypeSchema(typ)
+		if err != nil {
+			return nil, fmt.Errorf("message %d (%s): %v", code, typ.Name(), err)
+		}
+		s.Schema = SchemaVersion
+		s.Title = typ.Name()
+		p.Messages = append(p.Messages, &Message{
+			Code:   code,
+			Name:   typ.Name(),
+			Schema: s,
+		})
+	}
+	return p, nil
+}
+
+// TypeSchema makes the schema of a type
+// it fails for types rlp can't encode, like signed integers and maps
+func TypeSchema(typ reflect.Type) (*Schema, error) {
+	return typeSchema(typ, make(map[reflect.Type]bool))
+}
+
+func typeSchema(typ reflect.Type, parents map[reflect.Type]bool) (*Schema, error) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if parents[typ] {
+		return nil, fmt.Errorf("recursive type %s", typ)
+	}
+	s, err := kindSchema(typ, parents)
+	if err != nil {
+		return nil, err
+	}
+	s.CustomRLP = typ.Implements(rlpEncoderType) || reflect.PtrTo(typ).Implements(rlpEncoderType)
+	return s, nil
+}
+
+// the types with their own json and rlp encodings come first, then the rest by kind
+func kindSchema(typ reflect.Type, parents map[reflect.Type]bool) (*Schema, error) {
+	switch {
+	case typ == bigIntType:
+		return &Schema{Type: "integer", Minimum: intp(0), RLP: RLPUint}, nil
+	case typ == timeType:
+		return &Schema{
+			Type:        "string",
+			Format:      "date-time",
+			RLP:         RLPList,
+			Description: "rlp encodes it as an empty list, the value is lost",
+		}, nil
+	case typ.Kind() != reflect.Struct && (typ.Implements(textMarshalerType) || reflect.PtrTo(typ).Implements(textMarshalerType)):
+		enc := RLPBytes
+		if !isBytes(typ) {
+			s, err := basicSchema(typ)
+			if err != nil {
+				return nil, err
+			}
+			enc = s.RLP
+		}
+		return &Schema{Type: "string", RLP: enc, Description: fmt.Sprintf("text form of %s", typ)}, nil
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		return structSchema(typ, parents)
+	case reflect.Slice, reflect.Array:
+		if isBytes(typ) {
+			if typ.Kind() == reflect.Slice {
+				return &Schema{Type: "string", ContentEncoding: "base64", RLP: RLPBytes}, nil
+			}
+			// json makes byte arrays arrays of numbers
+			return &Schema{
+				Type:     "array",
+				Items:    &Schema{Type: "integer", Minimum: intp(0), Maximum: intp(255)},
+				MinItems: intp(typ.Len()),
+				MaxItems: intp(typ.Len()),
+				RLP:      RLPBytes,
+			}, nil
+		}
+		parents[typ] = true
+		defer delete(parents, typ)
+		items, err := typeSchema(typ.Elem(), parents)
+		if err != nil {
+			return nil, err
+		}
+		s := &Schema{Type: "array", Items: items, RLP: RLPList}
+		if typ.Kind() == reflect.Array {
+			s.MinItems = intp(typ.Len())
+			s.MaxItems = intp(typ.Len())
+		}
+		return s, nil
+	}
+	return basicSchema(typ)
+}
+
+func basicSchema(typ reflect.Type) (*Schema, error) {
+	switch typ.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean", RLP: RLPBool}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer", Minimum: intp(0), RLP: RLPUint}, nil
+	case reflect.String:
+		return &Schema{Type: "string", RLP: RLPString}, nil
+	}
+	return nil, fmt.Errorf("rlp can't encode %s", typ)
+}
+
+// the fields of a struct are an rlp list, in the order they're declared
+func structSchema(typ reflect.Type, parents map[reflect.Type]bool) (*Schema, error) {
+	parents[typ] = true
+	defer delete(parents, typ)
+	s := &Schema{
+		Type:       "object",
+		Properties: make(map[string]*Schema),
+		RLP:        RLPList,
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		var tail bool
+		switch f.Tag.Get("rlp") {
+		case "-":
+			continue
+		case "tail":
+			tail = true
+		}
+		name, ok := jsonName(f)
+		fs, err := typeSchema(f.Type, parents)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", f.Name, err)
+		}
+		fs.Tail = tail
+		s.Properties[name] = fs
+		s.PropertyOrder = append(s.PropertyOrder, name)
+		switch {
+		case !ok:
+			fs.Description = "only in rlp, json leaves it out"
+		case !strings.Contains(f.Tag.Get("json"), ",omitempty"):
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s, nil
+}
+
+// the name json uses for the field, and false if json leaves the field out
+func jsonName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return f.Name, false
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		return f.Name, true
+	}
+	return name, true
+}
+
+func isBytes(typ reflect.Type) bool {
+	return (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) && typ.Elem() == byteType
+}
+
+func intp(i int) *int {
+	return &i
+}
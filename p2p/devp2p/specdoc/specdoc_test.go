@@ -0,0 +1,138 @@
+package specdoc
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+)
+
+type testItem struct {
+	Key   string
+	Value []byte
+}
+
+type testMsg struct {
+	Id      [8]byte
+	Count   uint32
+	Ok      bool `json:"ok"`
+	Amount  *big.Int
+	Peer    enode.ID
+	Hidden  uint `json:"-"`
+	Skipped uint `rlp:"-"`
+	Items   []testItem
+	Rest    []string `rlp:"tail"`
+	private uint
+}
+
+type testOther struct {
+	Name string
+}
+
+type testBad struct {
+	N int
+}
+
+var testSpec = &protocols.Spec{
+	Name:       "test",
+	Version:    3,
+	MaxMsgSize: 1024,
+	Messages: []interface{}{
+		&testOther{},
+		&testMsg{},
+	},
+}
+
+func TestDescribe(t *testing.T) {
+	p, err := Describe(testSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "test" || p.Version != 3 || len(p.Messages) != 2 {
+		t.Fatalf("wrong protocol %v", p)
+	}
+	m := p.Messages[1]
+	if m.Code != 1 || m.Name != "testMsg" {
+		t.Fatalf("wrong message %d %s", m.Code, m.Name)
+	}
+
+	// the order of the rlp list, with the json names
+	order := []string{"Id", "Count", "ok", "Amount", "Peer", "Hidden", "Items", "Rest"}
+	if !reflect.DeepEqual(m.Schema.PropertyOrder, order) {
+		t.Fatalf("got order %v, want %v", m.Schema.PropertyOrder, order)
+	}
+	for _, name := range m.Schema.Required {
+		if name == "Hidden" {
+			t.Fatal("field left out of json is required")
+		}
+	}
+
+	for name, want := range map[string][2]string{
+		"Id":     {"array", RLPBytes},
+		"Count":  {"integer", RLPUint},
+		"ok":     {"boolean", RLPBool},
+		"Amount": {"integer", RLPUint},
+		"Peer":   {"string", RLPBytes},
+		"Items":  {"array", RLPList},
+		"Rest":   {"array", RLPList},
+	} {
+		s := m.Schema.Properties[name]
+		if s.Type != want[0] || s.RLP != want[1] {
+			t.Errorf("%s: got %s/%s, want %s/%s", name, s.Type, s.RLP, want[0], want[1])
+		}
+	}
+	if *m.Schema.Properties["Id"].MaxItems != 8 {
+		t.Error("wrong array length")
+	}
+	if !m.Schema.Properties["Rest"].Tail {
+		t.Error("tail not marked")
+	}
+	item := m.Schema.Properties["Items"].Items
+	if item.Properties["Value"].ContentEncoding != "base64" {
+		t.Errorf("wrong byte slice schema %v", item.Properties["Value"])
+	}
+
+	// and it's valid json
+	_, err = json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	_, err := Describe(&protocols.Spec{
+		Name:     "bad",
+		Messages: []interface{}{&testBad{}},
+	})
+	if err == nil {
+		t.Fatal("signed integer accepted")
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	p, err := Describe(testSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	err = Markdown(&b, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"## test v3",
+		"### 1: testMsg",
+		"| 1 | Count | integer | uint |  |",
+		"| 6.n.1 | Items[].Value | string (base64) | bytes |  |",
+		"| 7 | Rest | array of string | list | takes the rest of the list |",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing %q in\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package topology
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Writers are the output formats, by the extension of their files
+var Writers = map[string]func(io.Writer, *Graph, *Diff) error{
+	"dot":     WriteDot,
+	"graphml": WriteGraphML,
+}
+
+// Exporter writes the snapshots of a tracker to a directory
+//
+// the snapshots are numbered, topology-0001.dot, topology-0002.dot and so on, in each of the formats.
+// In diff mode, every snapshot after the first also has a diff file, topology-0002.diff.dot, showing the changes since the one before
+type Exporter struct {
+	tracker *Tracker
+	dir     string
+	formats []string
+	diff    bool
+
+	seq  int
+	prev *Graph
+}
+
+// NewExporter makes an exporter of the tracker's snapshots to the directory, which is made if it's not there
+func NewExporter(tracker *Tracker, dir string, formats []string, diff bool) (*Exporter, error) {
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("no output format")
+	}
+	for _, f := range formats {
+		if _, ok := Writers[f]; !ok {
+			return nil, fmt.Errorf("unknown output format %q", f)
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Exporter{
+		tracker: tracker,
+		dir:     dir,
+		formats: formats,
+		diff:    diff,
+	}, nil
+}
+
+// Write writes a snapshot of the tracker, and its diff with the one before, and returns the paths of the files written
+func (e *Exporter) Write() ([]string, error) {
+	g := e.tracker.Snapshot()
+	e.seq++
+	var paths []string
+	for _, f := range e.formats {
+		path := filepath.Join(e.dir, fmt.Sprintf("topology-%04d.%s", e.seq, f))
+		if err := writeFile(path, f, g, nil); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+		if !e.diff || e.prev == nil {
+			continue
+		}
+		path = filepath.Join(e.dir, fmt.Sprintf("topology-%04d.diff.%s", e.seq, f))
+		if err := writeFile(path, f, g, Compare(e.prev, g)); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	e.prev = g
+	return paths, nil
+}
+
+func writeFile(path string, format string, g *Graph, d *Diff) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Writers[format](f, g, d); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Run writes a snapshot now and at every interval, until quit is closed, and a last one then
+func (e *Exporter) Run(interval time.Duration, quitC <-chan struct{}) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := e.write(); err != nil {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-quitC:
+			_, err := e.write()
+			return err
+		}
+	}
+}
+
+func (e *Exporter) write() ([]string, error) {
+	paths, err := e.Write()
+	if err != nil {
+		return paths, fmt.Errorf("write topology snapshot fail: %v", err)
+	}
+	g := e.prev
+	log.Info("topology snapshot written", "seq", e.seq, "nodes", len(g.Nodes), "conns", len(g.Edges), "files", len(paths))
+	return paths, nil
+}
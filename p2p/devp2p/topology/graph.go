@@ -0,0 +1,264 @@
+// Package topology keeps the topology of a p2p network as it changes, from the events of a simulation or of live nodes,
+// and writes snapshots of it as graphviz dot or graphml, to look at how the connections of the network come and go offline
+//
+// a Tracker applies the events, and Snapshot copies the graph it has. Compare tells the nodes and connections added and removed
+// between two snapshots, which WriteDot and WriteGraphML mark when drawing the later one. The Exporter writes the snapshots of a
+// tracker to numbered files at intervals, and the diffs between them
+package topology
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+)
+
+// Node is a node of the network, by its hex node id
+type Node struct {
+	ID   string
+	Name string
+	Up   bool
+}
+
+// Label is what the node is shown as, its name or the start of its id
+func (n Node) Label() string {
+	if n.Name != "" {
+		return n.Name
+	}
+	if len(n.ID) > 8 {
+		return n.ID[:8]
+	}
+	return n.ID
+}
+
+// Edge is a connection between two nodes, whichever dialed, with the lesser id first
+type Edge struct {
+	A string
+	B string
+}
+
+// NewEdge makes the edge between the nodes
+func NewEdge(a, b string) Edge {
+	if b < a {
+		a, b = b, a
+	}
+	return Edge{A: a, B: b}
+}
+
+// Graph is the topology of the network at a time
+type Graph struct {
+	Time  time.Time
+	Nodes map[string]Node
+	Edges map[Edge]bool
+}
+
+// NewGraph makes an empty graph
+func NewGraph(t time.Time) *Graph {
+	return &Graph{
+		Time:  t,
+		Nodes: make(map[string]Node),
+		Edges: make(map[Edge]bool),
+	}
+}
+
+// SortedNodes gives the nodes by id, for the output to be the same for the same graph
+func (g *Graph) SortedNodes() []Node {
+	var nodes []Node
+	for _, n := range g.Nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// SortedEdges gives the edges by their ends
+func (g *Graph) SortedEdges() []Edge {
+	var edges []Edge
+	for e := range g.Edges {
+		edges = append(edges, e)
+	}
+	sortEdges(edges)
+	return edges
+}
+
+func sortEdges(edges []Edge) {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].A != edges[j].A {
+			return edges[i].A < edges[j].A
+		}
+		return edges[i].B < edges[j].B
+	})
+}
+
+// Tracker keeps the graph of a network up to date with its events
+//
+// a node going down takes its connections with it, as the events of the connections may not come, a live node
+// that's gone doesn't tell its peers dropped
+type Tracker struct {
+	mu    sync.Mutex
+	nodes map[string]Node
+	edges map[Edge]bool
+}
+
+// NewTracker makes a tracker of an empty network
+func NewTracker() *Tracker {
+	return &Tracker{
+		nodes: make(map[string]Node),
+		edges: make(map[Edge]bool),
+	}
+}
+
+// SetNode adds the node or changes whether it's up, an empty name keeps the one the node has
+func (t *Tracker) SetNode(id string, name string, up bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.setNode(id, name, up)
+}
+
+func (t *Tracker) setNode(id string, name string, up bool) {
+	n := t.nodes[id]
+	n.ID = id
+	if name != "" {
+		n.Name = name
+	}
+	n.Up = up
+	t.nodes[id] = n
+	if up {
+		return
+	}
+	for e := range t.edges {
+		if e.A == id || e.B == id {
+			delete(t.edges, e)
+		}
+	}
+}
+
+// SetEdge connects or disconnects the nodes, a node not known yet is added as up
+func (t *Tracker) SetEdge(a, b string, up bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.setEdge(a, b, up)
+}
+
+func (t *Tracker) setEdge(a, b string, up bool) {
+	e := NewEdge(a, b)
+	if !up {
+		delete(t.edges, e)
+		return
+	}
+	for _, id := range []string{a, b} {
+		if n, ok := t.nodes[id]; !ok || !n.Up {
+			t.setNode(id, "", true)
+		}
+	}
+	t.edges[e] = true
+}
+
+// ApplySim applies an event of a simulated network, messages are left out
+func (t *Tracker) ApplySim(ev *simulations.Event) {
+	switch ev.Type {
+	case simulations.EventTypeNode:
+		t.SetNode(ev.Node.ID().String(), ev.Node.Config.Name, ev.Node.Up)
+	case simulations.EventTypeConn:
+		t.SetEdge(ev.Conn.One.String(), ev.Conn.Other.String(), ev.Conn.Up)
+	}
+}
+
+// ApplyPeer applies a peer event of the live node with the id
+func (t *Tracker) ApplyPeer(self string, ev *p2p.PeerEvent) {
+	switch ev.Type {
+	case p2p.PeerEventTypeAdd:
+		t.SetEdge(self, ev.Peer.String(), true)
+	case p2p.PeerEventTypeDrop:
+		t.SetEdge(self, ev.Peer.String(), false)
+	}
+}
+
+// Snapshot copies the graph as it is now
+func (t *Tracker) Snapshot() *Graph {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g := NewGraph(time.Now())
+	for id, n := range t.nodes {
+		g.Nodes[id] = n
+	}
+	for e := range t.edges {
+		g.Edges[e] = true
+	}
+	return g
+}
+
+// Diff is what changed between two graphs
+type Diff struct {
+	From time.Time
+	To   time.Time
+
+	AddedNodes   []Node
+	RemovedNodes []Node
+	StartedNodes []Node // nodes that were there, down, and are up
+	StoppedNodes []Node
+	AddedEdges   []Edge
+	RemovedEdges []Edge
+}
+
+// Empty tells if nothing changed
+func (d *Diff) Empty() bool {
+	return len(d.AddedNodes)+len(d.RemovedNodes)+len(d.StartedNodes)+len(d.StoppedNodes)+len(d.AddedEdges)+len(d.RemovedEdges) == 0
+}
+
+// what happened to the node, for the outputs to mark it, empty if nothing
+func (d *Diff) nodeChange(id string) string {
+	for change, nodes := range map[string][]Node{
+		"added":   d.AddedNodes,
+		"removed": d.RemovedNodes,
+		"started": d.StartedNodes,
+		"stopped": d.StoppedNodes,
+	} {
+		for _, n := range nodes {
+			if n.ID == id {
+				return change
+			}
+		}
+	}
+	return ""
+}
+
+// Compare tells what changed from the graph prev to the graph cur, a nil prev is an empty graph
+func Compare(prev, cur *Graph) *Diff {
+	if prev == nil {
+		prev = NewGraph(time.Time{})
+	}
+	d := &Diff{
+		From: prev.Time,
+		To:   cur.Time,
+	}
+	for _, n := range cur.SortedNodes() {
+		was, ok := prev.Nodes[n.ID]
+		switch {
+		case !ok:
+			d.AddedNodes = append(d.AddedNodes, n)
+		case n.Up && !was.Up:
+			d.StartedNodes = append(d.StartedNodes, n)
+		case !n.Up && was.Up:
+			d.StoppedNodes = append(d.StoppedNodes, n)
+		}
+	}
+	for _, n := range prev.SortedNodes() {
+		if _, ok := cur.Nodes[n.ID]; !ok {
+			d.RemovedNodes = append(d.RemovedNodes, n)
+		}
+	}
+	for _, e := range cur.SortedEdges() {
+		if !prev.Edges[e] {
+			d.AddedEdges = append(d.AddedEdges, e)
+		}
+	}
+	for _, e := range prev.SortedEdges() {
+		if !cur.Edges[e] {
+			d.RemovedEdges = append(d.RemovedEdges, e)
+		}
+	}
+	return d
+}
@@ -0,0 +1,239 @@
+package topology
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const graphmlNS = "http://graphml.graphdrawing.org/xmlns"
+
+// the attributes of the changes in a diff, for dot
+var dotChanges = map[string]string{
+	"added":   `color="green3", penwidth=2`,
+	"removed": `color="red", style=dashed`,
+	"started": `color="green3"`,
+	"stopped": `color="orange"`,
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// summary of the graph for its title, with the changes if there's a diff
+func summary(g *Graph, d *Diff) string {
+	s := fmt.Sprintf("%s: %d nodes, %d connections", g.Time.Format(time.RFC3339), len(g.Nodes), len(g.Edges))
+	if d != nil {
+		s += fmt.Sprintf(" (+%d -%d since %s)", len(d.AddedEdges), len(d.RemovedEdges), d.From.Format("15:04:05"))
+	}
+	return s
+}
+
+// the nodes and edges to draw, those of the graph and those the diff removed
+func drawn(g *Graph, d *Diff) ([]Node, []Edge) {
+	nodes, edges := g.SortedNodes(), g.SortedEdges()
+	if d != nil {
+		nodes = append(nodes, d.RemovedNodes...)
+		edges = append(edges, d.RemovedEdges...)
+	}
+	return nodes, edges
+}
+
+func edgeChange(e Edge, d *Diff) string {
+	if d == nil {
+		return ""
+	}
+	for _, a := range d.AddedEdges {
+		if a == e {
+			return "added"
+		}
+	}
+	for _, r := range d.RemovedEdges {
+		if r == e {
+			return "removed"
+		}
+	}
+	return ""
+}
+
+func nodeChange(n Node, d *Diff) string {
+	if d == nil {
+		return ""
+	}
+	return d.nodeChange(n.ID)
+}
+
+// WriteDot writes the graph in the dot language of graphviz
+//
+// with a diff, those of its changes that lead to the graph are marked: the nodes and connections added in green,
+// the ones removed in dashed red, and the nodes stopped in orange. Nodes that are down are grey
+func WriteDot(w io.Writer, g *Graph, d *Diff) error {
+	nodes, edges := drawn(g, d)
+	var b strings.Builder
+	fmt.Fprintf(&b, "graph topology {\n")
+	fmt.Fprintf(&b, "\tlabel=%s;\n", dotQuote(summary(g, d)))
+	fmt.Fprintf(&b, "\tnode [shape=ellipse];\n")
+	for _, n := range nodes {
+		attrs := []string{"label=" + dotQuote(n.Label())}
+		if !n.Up {
+			attrs = append(attrs, `fontcolor="grey"`)
+		}
+		if c := nodeChange(n, d); c != "" {
+			attrs = append(attrs, dotChanges[c])
+		} else if !n.Up {
+			attrs = append(attrs, `color="grey"`)
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", dotQuote(n.ID), strings.Join(attrs, ", "))
+	}
+	for _, e := range edges {
+		fmt.Fprintf(&b, "\t%s -- %s", dotQuote(e.A), dotQuote(e.B))
+		if c := edgeChange(e, d); c != "" {
+			fmt.Fprintf(&b, " [%s]", dotChanges[c])
+		}
+		fmt.Fprintf(&b, ";\n")
+	}
+	fmt.Fprintf(&b, "}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type graphmlDoc struct {
+	XMLName xml.Name     `xml:"graphml"`
+	NS      string       `xml:"xmlns,attr"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   graphmlGraph `xml:"graph"`
+}
+
+type graphmlKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphmlGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Data        []graphmlData `xml:"data"`
+	Nodes       []graphmlNode `xml:"node"`
+	Edges       []graphmlEdge `xml:"edge"`
+}
+
+type graphmlNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphmlData `xml:"data"`
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+func dataValue(data []graphmlData, key string) string {
+	for _, d := range data {
+		if d.Key == key {
+			return d.Value
+		}
+	}
+	return ""
+}
+
+// WriteGraphML writes the graph as graphml, for tools like gephi, networkx or yed
+//
+// nodes have their name and whether they're up, the graph its time. With a diff, the nodes and edges have a change
+// attribute, added, removed, started, stopped or empty, and the removed ones are in it too
+func WriteGraphML(w io.Writer, g *Graph, d *Diff) error {
+	doc := graphmlDoc{
+		NS: graphmlNS,
+		Keys: []graphmlKey{
+			{ID: "time", For: "graph", Name: "time", Type: "string"},
+			{ID: "name", For: "node", Name: "name", Type: "string"},
+			{ID: "up", For: "node", Name: "up", Type: "boolean"},
+		},
+		Graph: graphmlGraph{
+			ID:          "topology",
+			EdgeDefault: "undirected",
+			Data:        []graphmlData{{Key: "time", Value: g.Time.Format(time.RFC3339Nano)}},
+		},
+	}
+	if d != nil {
+		doc.Keys = append(doc.Keys,
+			graphmlKey{ID: "since", For: "graph", Name: "since", Type: "string"},
+			graphmlKey{ID: "change", For: "all", Name: "change", Type: "string"},
+		)
+		doc.Graph.Data = append(doc.Graph.Data, graphmlData{Key: "since", Value: d.From.Format(time.RFC3339Nano)})
+	}
+	nodes, edges := drawn(g, d)
+	for _, n := range nodes {
+		gn := graphmlNode{ID: n.ID, Data: []graphmlData{
+			{Key: "name", Value: n.Name},
+			{Key: "up", Value: fmt.Sprint(n.Up)},
+		}}
+		if d != nil {
+			gn.Data = append(gn.Data, graphmlData{Key: "change", Value: nodeChange(n, d)})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gn)
+	}
+	for _, e := range edges {
+		ge := graphmlEdge{Source: e.A, Target: e.B}
+		if d != nil {
+			ge.Data = append(ge.Data, graphmlData{Key: "change", Value: edgeChange(e, d)})
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, ge)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ReadGraphML reads a graph written by WriteGraphML, to compare snapshots offline
+// the nodes and edges a diff marks removed are left out
+func ReadGraphML(r io.Reader) (*Graph, error) {
+	var doc graphmlDoc
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, dataValue(doc.Graph.Data, "time"))
+	if err != nil {
+		return nil, fmt.Errorf("graph time: %v", err)
+	}
+	g := NewGraph(t)
+	for _, n := range doc.Graph.Nodes {
+		if dataValue(n.Data, "change") == "removed" {
+			continue
+		}
+		g.Nodes[n.ID] = Node{
+			ID:   n.ID,
+			Name: dataValue(n.Data, "name"),
+			Up:   dataValue(n.Data, "up") == "true",
+		}
+	}
+	for _, e := range doc.Graph.Edges {
+		if dataValue(e.Data, "change") == "removed" {
+			continue
+		}
+		if _, ok := g.Nodes[e.Source]; !ok {
+			return nil, fmt.Errorf("edge to unknown node %s", e.Source)
+		}
+		if _, ok := g.Nodes[e.Target]; !ok {
+			return nil, fmt.Errorf("edge to unknown node %s", e.Target)
+		}
+		g.Edges[NewEdge(e.Source, e.Target)] = true
+	}
+	return g, nil
+}
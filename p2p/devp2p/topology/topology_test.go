@@ -0,0 +1,216 @@
+package topology
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+func simNode(id enode.ID, name string, up bool) *simulations.Event {
+	return &simulations.Event{
+		Type: simulations.EventTypeNode,
+		Node: &simulations.Node{Config: &adapters.NodeConfig{ID: id, Name: name}, Up: up},
+	}
+}
+
+func simConn(one, other enode.ID, up bool) *simulations.Event {
+	return &simulations.Event{
+		Type: simulations.EventTypeConn,
+		Conn: &simulations.Conn{One: one, Other: other, Up: up},
+	}
+}
+
+func TestTracker(t *testing.T) {
+	a, b, c := enode.ID{1}, enode.ID{2}, enode.ID{3}
+	tr := NewTracker()
+	for _, ev := range []*simulations.Event{
+		simNode(a, "a", true),
+		simNode(b, "b", true),
+		simNode(c, "c", true),
+		simConn(a, b, true),
+		simConn(c, b, true),
+		simConn(a, c, true),
+		simConn(a, c, false),
+		{Type: simulations.EventTypeMsg, Msg: &simulations.Msg{One: a, Other: b}},
+	} {
+		tr.ApplySim(ev)
+	}
+	g := tr.Snapshot()
+	if len(g.Nodes) != 3 || len(g.Edges) != 2 || !g.Edges[NewEdge(b.String(), a.String())] || !g.Edges[NewEdge(b.String(), c.String())] {
+		t.Fatalf("graph %v", g.Edges)
+	}
+
+	// a node going down takes its connections with it
+	tr.ApplySim(simNode(b, "", false))
+	g = tr.Snapshot()
+	if len(g.Edges) != 0 || g.Nodes[b.String()].Up || g.Nodes[b.String()].Name != "b" {
+		t.Fatalf("graph %v %v", g.Nodes, g.Edges)
+	}
+
+	// the peers of a live node, which may not be known yet
+	d := enode.ID{4}
+	tr.ApplyPeer(a.String(), &p2p.PeerEvent{Type: p2p.PeerEventTypeAdd, Peer: d})
+	tr.ApplyPeer(a.String(), &p2p.PeerEvent{Type: p2p.PeerEventTypeMsgRecv, Peer: c})
+	g = tr.Snapshot()
+	if !g.Edges[NewEdge(a.String(), d.String())] || !g.Nodes[d.String()].Up || len(g.Edges) != 1 {
+		t.Fatalf("graph %v %v", g.Nodes, g.Edges)
+	}
+	tr.ApplyPeer(d.String(), &p2p.PeerEvent{Type: p2p.PeerEventTypeDrop, Peer: a})
+	if g = tr.Snapshot(); len(g.Edges) != 0 {
+		t.Fatalf("edges %v", g.Edges)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tr := NewTracker()
+	tr.SetNode("a", "a", true)
+	tr.SetNode("b", "b", true)
+	tr.SetNode("c", "c", false)
+	tr.SetEdge("a", "b", true)
+	prev := tr.Snapshot()
+	if d := Compare(prev, tr.Snapshot()); !d.Empty() {
+		t.Fatalf("diff of the same graph %+v", d)
+	}
+
+	tr.SetNode("b", "", false)
+	tr.SetNode("c", "", true)
+	tr.SetEdge("a", "c", true)
+	tr.SetEdge("c", "d", true)
+	cur := tr.Snapshot()
+	delete(cur.Nodes, "a")
+	delete(cur.Edges, NewEdge("a", "c"))
+	d := Compare(prev, cur)
+	if len(d.AddedNodes) != 1 || d.AddedNodes[0].ID != "d" ||
+		len(d.RemovedNodes) != 1 || d.RemovedNodes[0].ID != "a" ||
+		len(d.StartedNodes) != 1 || d.StartedNodes[0].ID != "c" ||
+		len(d.StoppedNodes) != 1 || d.StoppedNodes[0].ID != "b" {
+		t.Fatalf("node changes %+v", d)
+	}
+	if len(d.AddedEdges) != 1 || d.AddedEdges[0] != NewEdge("d", "c") || len(d.RemovedEdges) != 1 || d.RemovedEdges[0] != NewEdge("a", "b") {
+		t.Fatalf("edge changes %v %v", d.AddedEdges, d.RemovedEdges)
+	}
+
+	// from nothing everything is added
+	if d := Compare(nil, prev); len(d.AddedNodes) != 3 || len(d.AddedEdges) != 1 {
+		t.Fatalf("diff from nothing %+v", d)
+	}
+}
+
+func testGraphs() (*Graph, *Graph) {
+	prev := NewGraph(time.Date(2018, 12, 1, 10, 0, 0, 0, time.UTC))
+	cur := NewGraph(prev.Time.Add(time.Second * 5))
+	for _, n := range []Node{{ID: "aa", Name: `node "a"`, Up: true}, {ID: "bb", Name: "b", Up: true}, {ID: "cc", Up: true}} {
+		prev.Nodes[n.ID] = n
+	}
+	prev.Edges[NewEdge("aa", "bb")] = true
+	prev.Edges[NewEdge("bb", "cc")] = true
+	cur.Nodes["aa"] = prev.Nodes["aa"]
+	cur.Nodes["bb"] = Node{ID: "bb", Name: "b"}
+	cur.Nodes["dd"] = Node{ID: "dd", Name: "d", Up: true}
+	cur.Edges[NewEdge("dd", "aa")] = true
+	return prev, cur
+}
+
+func TestWriteDot(t *testing.T) {
+	prev, cur := testGraphs()
+	var b bytes.Buffer
+	if err := WriteDot(&b, cur, Compare(prev, cur)); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		`"aa" [label="node \"a\""];`,
+		`"bb" [label="b", fontcolor="grey", color="orange"];`,
+		`"cc" [label="cc", color="red", style=dashed];`,
+		`"dd" [label="d", color="green3", penwidth=2];`,
+		`"aa" -- "dd" [color="green3", penwidth=2];`,
+		`"aa" -- "bb" [color="red", style=dashed];`,
+		`"bb" -- "cc" [color="red", style=dashed];`,
+		"3 nodes, 1 connections (+1 -2 since 10:00:00)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("no %s in\n%s", want, out)
+		}
+	}
+	// without a diff nothing is marked
+	b.Reset()
+	if err := WriteDot(&b, cur, nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "red") || strings.Contains(b.String(), `"cc"`) {
+		t.Fatalf("marks without diff\n%s", b.String())
+	}
+}
+
+func TestGraphML(t *testing.T) {
+	prev, cur := testGraphs()
+	for _, d := range []*Diff{nil, Compare(prev, cur)} {
+		var b bytes.Buffer
+		if err := WriteGraphML(&b, cur, d); err != nil {
+			t.Fatal(err)
+		}
+		if d != nil && !strings.Contains(b.String(), `<data key="change">removed</data>`) {
+			t.Fatalf("no removed in\n%s", b.String())
+		}
+		g, err := ReadGraphML(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := Compare(cur, g); !d.Empty() || !g.Time.Equal(cur.Time) || g.Nodes["aa"].Name != `node "a"` {
+			t.Fatalf("read back %+v, diff %+v", g, d)
+		}
+	}
+}
+
+func TestExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topology-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := NewExporter(NewTracker(), dir, []string{"png"}, false); err == nil {
+		t.Fatal("unknown format taken")
+	}
+
+	tr := NewTracker()
+	tr.SetEdge("aa", "bb", true)
+	e, err := NewExporter(tr, dir, []string{"dot", "graphml"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := e.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("first snapshot files %v", paths)
+	}
+	tr.SetEdge("aa", "bb", false)
+	tr.SetEdge("bb", "cc", true)
+	if paths, err = e.Write(); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range paths {
+		names = append(names, filepath.Base(p))
+	}
+	if strings.Join(names, " ") != "topology-0002.dot topology-0002.diff.dot topology-0002.graphml topology-0002.diff.graphml" {
+		t.Fatalf("second snapshot files %v", names)
+	}
+	diff, err := ioutil.ReadFile(filepath.Join(dir, "topology-0002.diff.dot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(diff), `"aa" -- "bb" [color="red", style=dashed];`) || !strings.Contains(string(diff), `"bb" -- "cc" [color="green3", penwidth=2];`) {
+		t.Fatalf("diff\n%s", diff)
+	}
+}
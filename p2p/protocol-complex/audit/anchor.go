@@ -0,0 +1,421 @@
+// Code generated - DO NOT EDIT.
+// This file is a generated binding and any manual changes will be lost.
+
+package audit
+
+import (
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var (
+	_ = big.NewInt
+	_ = strings.NewReader
+	_ = ethereum.NotFound
+	_ = abi.U256
+	_ = bind.Bind
+	_ = common.Big1
+	_ = types.BloomLookup
+	_ = event.NewSubscription
+)
+
+// AnchorABI is the input ABI used to generate the binding from.
+const AnchorABI = "[{\"constant\":false,\"inputs\":[{\"name\":\"root\",\"type\":\"bytes32\"}],\"name\":\"commit\",\"outputs\":[],\"payable\":false,\"stateMutability\":\"nonpayable\",\"type\":\"function\"},{\"constant\":true,\"inputs\":[{\"name\":\"index\",\"type\":\"uint256\"}],\"name\":\"roots\",\"outputs\":[{\"name\":\"\",\"type\":\"bytes32\"}],\"payable\":false,\"stateMutability\":\"view\",\"type\":\"function\"},{\"constant\":true,\"inputs\":[],\"name\":\"count\",\"outputs\":[{\"name\":\"\",\"type\":\"uint256\"}],\"payable\":false,\"stateMutability\":\"view\",\"type\":\"function\"},{\"constant\":true,\"inputs\":[],\"name\":\"owner\",\"outputs\":[{\"name\":\"\",\"type\":\"address\"}],\"payable\":false,\"stateMutability\":\"view\",\"type\":\"function\"},{\"anonymous\":false,\"inputs\":[{\"indexed\":true,\"name\":\"index\",\"type\":\"uint256\"},{\"indexed\":false,\"name\":\"root\",\"type\":\"bytes32\"}],\"name\":\"Committed\",\"type\":\"event\"}]"
+
+// AnchorBin is the compiled bytecode used for deploying new contracts.
+const AnchorBin = `3360005560e280600f6000396000f334630000005e576000357c010000000000000000000000000000000000000000000000000000000090048063f14fcbc8146300000063578063c2b40ae41463000000b257806306661abd1463000000c55780638da5cb5b1463000000cf575b600080fd5b600054331415630000005e576001548060005260043580602060002055600052807f68e0867601a98978930107aee7f425665e61edd70ca594c68ca5da9e81f84c2960206000a2600101600155005b60043560005260206000205463000000d9565b60015463000000d9565b60005463000000d9565b60005260206000f3`
+
+// DeployAnchor deploys a new Ethereum contract, binding an instance of Anchor to it.
+func DeployAnchor(auth *bind.TransactOpts, backend bind.ContractBackend) (common.Address, *types.Transaction, *Anchor, error) {
+	parsed, err := abi.JSON(strings.NewReader(AnchorABI))
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	address, tx, contract, err := bind.DeployContract(auth, parsed, common.FromHex(AnchorBin), backend)
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	return address, tx, &Anchor{AnchorCaller: AnchorCaller{contract: contract}, AnchorTransactor: AnchorTransactor{contract: contract}, AnchorFilterer: AnchorFilterer{contract: contract}}, nil
+}
+
+// Anchor is an auto generated Go binding around an Ethereum contract.
+type Anchor struct {
+	AnchorCaller     // Read-only binding to the contract
+	AnchorTransactor // Write-only binding to the contract
+	AnchorFilterer   // Log filterer for contract events
+}
+
+// AnchorCaller is an auto generated read-only Go binding around an Ethereum contract.
+type AnchorCaller struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// AnchorTransactor is an auto generated write-only Go binding around an Ethereum contract.
+type AnchorTransactor struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// AnchorFilterer is an auto generated log filtering Go binding around an Ethereum contract events.
+type AnchorFilterer struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// AnchorSession is an auto generated Go binding around an Ethereum contract,
+// with pre-set call and transact options.
+type AnchorSession struct {
+	Contract     *Anchor           // Generic contract binding to set the session for
+	CallOpts     bind.CallOpts     // Call options to use throughout this session
+	TransactOpts bind.TransactOpts // Transaction auth options to use throughout this session
+}
+
+// AnchorCallerSession is an auto generated read-only Go binding around an Ethereum contract,
+// with pre-set call options.
+type AnchorCallerSession struct {
+	Contract *AnchorCaller // Generic contract caller binding to set the session for
+	CallOpts bind.CallOpts // Call options to use throughout this session
+}
+
+// AnchorTransactorSession is an auto generated write-only Go binding around an Ethereum contract,
+// with pre-set transact options.
+type AnchorTransactorSession struct {
+	Contract     *AnchorTransactor // Generic contract transactor binding to set the session for
+	TransactOpts bind.TransactOpts // Transaction auth options to use throughout this session
+}
+
+// AnchorRaw is an auto generated low-level Go binding around an Ethereum contract.
+type AnchorRaw struct {
+	Contract *Anchor // Generic contract binding to access the raw methods on
+}
+
+// AnchorCallerRaw is an auto generated low-level read-only Go binding around an Ethereum contract.
+type AnchorCallerRaw struct {
+	Contract *AnchorCaller // Generic read-only contract binding to access the raw methods on
+}
+
+// AnchorTransactorRaw is an auto generated low-level write-only Go binding around an Ethereum contract.
+type AnchorTransactorRaw struct {
+	Contract *AnchorTransactor // Generic write-only contract binding to access the raw methods on
+}
+
+// NewAnchor creates a new instance of Anchor, bound to a specific deployed contract.
+func NewAnchor(address common.Address, backend bind.ContractBackend) (*Anchor, error) {
+	contract, err := bindAnchor(address, backend, backend, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &Anchor{AnchorCaller: AnchorCaller{contract: contract}, AnchorTransactor: AnchorTransactor{contract: contract}, AnchorFilterer: AnchorFilterer{contract: contract}}, nil
+}
+
+// NewAnchorCaller creates a new read-only instance of Anchor, bound to a specific deployed contract.
+func NewAnchorCaller(address common.Address, caller bind.ContractCaller) (*AnchorCaller, error) {
+	contract, err := bindAnchor(address, caller, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AnchorCaller{contract: contract}, nil
+}
+
+// NewAnchorTransactor creates a new write-only instance of Anchor, bound to a specific deployed contract.
+func NewAnchorTransactor(address common.Address, transactor bind.ContractTransactor) (*AnchorTransactor, error) {
+	contract, err := bindAnchor(address, nil, transactor, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AnchorTransactor{contract: contract}, nil
+}
+
+// NewAnchorFilterer creates a new log filterer instance of Anchor, bound to a specific deployed contract.
+func NewAnchorFilterer(address common.Address, filterer bind.ContractFilterer) (*AnchorFilterer, error) {
+	contract, err := bindAnchor(address, nil, nil, filterer)
+	if err != nil {
+		return nil, err
+	}
+	return &AnchorFilterer{contract: contract}, nil
+}
+
+// bindAnchor binds a generic wrapper to an already deployed contract.
+func bindAnchor(address common.Address, caller bind.ContractCaller, transactor bind.ContractTransactor, filterer bind.ContractFilterer) (*bind.BoundContract, error) {
+	parsed, err := abi.JSON(strings.NewReader(AnchorABI))
+	if err != nil {
+		return nil, err
+	}
+	return bind.NewBoundContract(address, parsed, caller, transactor, filterer), nil
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_Anchor *AnchorRaw) Call(opts *bind.CallOpts, result interface{}, method string, params ...interface{}) error {
+	return _Anchor.Contract.AnchorCaller.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_Anchor *AnchorRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _Anchor.Contract.AnchorTransactor.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_Anchor *AnchorRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _Anchor.Contract.AnchorTransactor.contract.Transact(opts, method, params...)
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_Anchor *AnchorCallerRaw) Call(opts *bind.CallOpts, result interface{}, method string, params ...interface{}) error {
+	return _Anchor.Contract.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_Anchor *AnchorTransactorRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _Anchor.Contract.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_Anchor *AnchorTransactorRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _Anchor.Contract.contract.Transact(opts, method, params...)
+}
+
+// Count is a free data retrieval call binding the contract method 0x06661abd.
+//
+// Solidity: function count() constant returns(uint256)
+func (_Anchor *AnchorCaller) Count(opts *bind.CallOpts) (*big.Int, error) {
+	var (
+		ret0 = new(*big.Int)
+	)
+	out := ret0
+	err := _Anchor.contract.Call(opts, out, "count")
+	return *ret0, err
+}
+
+// Count is a free data retrieval call binding the contract method 0x06661abd.
+//
+// Solidity: function count() constant returns(uint256)
+func (_Anchor *AnchorSession) Count() (*big.Int, error) {
+	return _Anchor.Contract.Count(&_Anchor.CallOpts)
+}
+
+// Count is a free data retrieval call binding the contract method 0x06661abd.
+//
+// Solidity: function count() constant returns(uint256)
+func (_Anchor *AnchorCallerSession) Count() (*big.Int, error) {
+	return _Anchor.Contract.Count(&_Anchor.CallOpts)
+}
+
+// Owner is a free data retrieval call binding the contract method 0x8da5cb5b.
+//
+// Solidity: function owner() constant returns(address)
+func (_Anchor *AnchorCaller) Owner(opts *bind.CallOpts) (common.Address, error) {
+	var (
+		ret0 = new(common.Address)
+	)
+	out := ret0
+	err := _Anchor.contract.Call(opts, out, "owner")
+	return *ret0, err
+}
+
+// Owner is a free data retrieval call binding the contract method 0x8da5cb5b.
+//
+// Solidity: function owner() constant returns(address)
+func (_Anchor *AnchorSession) Owner() (common.Address, error) {
+	return _Anchor.Contract.Owner(&_Anchor.CallOpts)
+}
+
+// Owner is a free data retrieval call binding the contract method 0x8da5cb5b.
+//
+// Solidity: function owner() constant returns(address)
+func (_Anchor *AnchorCallerSession) Owner() (common.Address, error) {
+	return _Anchor.Contract.Owner(&_Anchor.CallOpts)
+}
+
+// Roots is a free data retrieval call binding the contract method 0xc2b40ae4.
+//
+// Solidity: function roots(index uint256) constant returns(bytes32)
+func (_Anchor *AnchorCaller) Roots(opts *bind.CallOpts, index *big.Int) ([32]byte, error) {
+	var (
+		ret0 = new([32]byte)
+	)
+	out := ret0
+	err := _Anchor.contract.Call(opts, out, "roots", index)
+	return *ret0, err
+}
+
+// Roots is a free data retrieval call binding the contract method 0xc2b40ae4.
+//
+// Solidity: function roots(index uint256) constant returns(bytes32)
+func (_Anchor *AnchorSession) Roots(index *big.Int) ([32]byte, error) {
+	return _Anchor.Contract.Roots(&_Anchor.CallOpts, index)
+}
+
+// Roots is a free data retrieval call binding the contract method 0xc2b40ae4.
+//
+// Solidity: function roots(index uint256) constant returns(bytes32)
+func (_Anchor *AnchorCallerSession) Roots(index *big.Int) ([32]byte, error) {
+	return _Anchor.Contract.Roots(&_Anchor.CallOpts, index)
+}
+
+// Commit is a paid mutator transaction binding the contract method 0xf14fcbc8.
+//
+// Solidity: function commit(root bytes32) returns()
+func (_Anchor *AnchorTransactor) Commit(opts *bind.TransactOpts, root [32]byte) (*types.Transaction, error) {
+	return _Anchor.contract.Transact(opts, "commit", root)
+}
+
+// Commit is a paid mutator transaction binding the contract method 0xf14fcbc8.
+//
+// Solidity: function commit(root bytes32) returns()
+func (_Anchor *AnchorSession) Commit(root [32]byte) (*types.Transaction, error) {
+	return _Anchor.Contract.Commit(&_Anchor.TransactOpts, root)
+}
+
+// Commit is a paid mutator transaction binding the contract method 0xf14fcbc8.
+//
+// Solidity: function commit(root bytes32) returns()
+func (_Anchor *AnchorTransactorSession) Commit(root [32]byte) (*types.Transaction, error) {
+	return _Anchor.Contract.Commit(&_Anchor.TransactOpts, root)
+}
+
+// AnchorCommittedIterator is returned from FilterCommitted and is used to iterate over the raw logs and unpacked data for Committed events raised by the Anchor contract.
+type AnchorCommittedIterator struct {
+	Event *AnchorCommitted // Event containing the contract specifics and raw log
+
+	contract *bind.BoundContract // Generic contract to use for unpacking event data
+	event    string              // Event name to use for unpacking event data
+
+	logs chan types.Log        // Log channel receiving the found contract events
+	sub  ethereum.Subscription // Subscription for errors, completion and termination
+	done bool                  // Whether the subscription completed delivering logs
+	fail error                 // Occurred error to stop iteration
+}
+
+// Next advances the iterator to the subsequent event, returning whether there
+// are any more events found. In case of a retrieval or parsing error, false is
+// returned and Error() can be queried for the exact failure.
+func (it *AnchorCommittedIterator) Next() bool {
+	// If the iterator failed, stop iterating
+	if it.fail != nil {
+		return false
+	}
+	// If the iterator completed, deliver directly whatever's available
+	if it.done {
+		select {
+		case log := <-it.logs:
+			it.Event = new(AnchorCommitted)
+			if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+				it.fail = err
+				return false
+			}
+			it.Event.Raw = log
+			return true
+
+		default:
+			return false
+		}
+	}
+	// Iterator still in progress, wait for either a data or an error event
+	select {
+	case log := <-it.logs:
+		it.Event = new(AnchorCommitted)
+		if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+			it.fail = err
+			return false
+		}
+		it.Event.Raw = log
+		return true
+
+	case err := <-it.sub.Err():
+		it.done = true
+		it.fail = err
+		return it.Next()
+	}
+}
+
+// Error returns any retrieval or parsing error occurred during filtering.
+func (it *AnchorCommittedIterator) Error() error {
+	return it.fail
+}
+
+// Close terminates the iteration process, releasing any pending underlying
+// resources.
+func (it *AnchorCommittedIterator) Close() error {
+	it.sub.Unsubscribe()
+	return nil
+}
+
+// AnchorCommitted represents a Committed event raised by the Anchor contract.
+type AnchorCommitted struct {
+	Index *big.Int
+	Root  [32]byte
+	Raw   types.Log // Blockchain specific contextual infos
+}
+
+// FilterCommitted is a free log retrieval operation binding the contract event 0x68e0867601a98978930107aee7f425665e61edd70ca594c68ca5da9e81f84c29.
+//
+// Solidity: e Committed(index indexed uint256, root bytes32)
+func (_Anchor *AnchorFilterer) FilterCommitted(opts *bind.FilterOpts, index []*big.Int) (*AnchorCommittedIterator, error) {
+
+	var indexRule []interface{}
+	for _, indexItem := range index {
+		indexRule = append(indexRule, indexItem)
+	}
+
+	logs, sub, err := _Anchor.contract.FilterLogs(opts, "Committed", indexRule)
+	if err != nil {
+		return nil, err
+	}
+	return &AnchorCommittedIterator{contract: _Anchor.contract, event: "Committed", logs: logs, sub: sub}, nil
+}
+
+// WatchCommitted is a free log subscription operation binding the contract event 0x68e0867601a98978930107aee7f425665e61edd70ca594c68ca5da9e81f84c29.
+//
+// Solidity: e Committed(index indexed uint256, root bytes32)
+func (_Anchor *AnchorFilterer) WatchCommitted(opts *bind.WatchOpts, sink chan<- *AnchorCommitted, index []*big.Int) (event.Subscription, error) {
+
+	var indexRule []interface{}
+	for _, indexItem := range index {
+		indexRule = append(indexRule, indexItem)
+	}
+
+	logs, sub, err := _Anchor.contract.WatchLogs(opts, "Committed", indexRule)
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case log := <-logs:
+				// New log arrived, parse the event and forward to the user
+				event := new(AnchorCommitted)
+				if err := _Anchor.contract.UnpackLog(event, "Committed", log); err != nil {
+					return err
+				}
+				event.Raw = log
+
+				select {
+				case sink <- event:
+				case err := <-sub.Err():
+					return err
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
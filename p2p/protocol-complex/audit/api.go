@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type AuditAPI struct {
+	log *Log
+}
+
+// Proof returns the proof of the result with the id, to check against the root of its batch in the anchor contract
+func (self *AuditAPI) Proof(id hexutil.Bytes) (*Proof, error) {
+	return self.log.Prove(id)
+}
+
+// Anchor returns the address of the anchor contract
+func (self *AuditAPI) Anchor() common.Address {
+	return self.log.Address()
+}
+
+// TrieRoot returns the root of the trie of the results in the first count batches
+func (self *AuditAPI) TrieRoot(count uint64) (common.Hash, error) {
+	t, err := self.log.Trie(count)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return t.Root(), nil
+}
+
+// TrieProof returns the nodes on the way to the id in the trie of the results in the first count batches
+// they prove the result, or that there's no result with the id
+func (self *AuditAPI) TrieProof(id hexutil.Bytes, count uint64) (*TrieProof, error) {
+	t, err := self.log.Trie(count)
+	if err != nil {
+		return nil, err
+	}
+	return t.Prove(id)
+}
+
+// the calls a contract binding makes to read a contract, answered by the backend of the log
+// so the roots can be read with ethclient, as from any ethereum node
+// it only reads, and only from the latest block
+type ChainAPI struct {
+	backend bind.ContractCaller
+}
+
+type CallArgs struct {
+	From common.Address  `json:"from"`
+	To   *common.Address `json:"to"`
+	Data hexutil.Bytes   `json:"data"`
+}
+
+func (self *ChainAPI) Call(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber) (hexutil.Bytes, error) {
+	if blockNr != rpc.LatestBlockNumber {
+		return nil, fmt.Errorf("only the latest block is served")
+	}
+	return self.backend.CallContract(ctx, ethereum.CallMsg{From: args.From, To: args.To, Data: args.Data}, nil)
+}
+
+func (self *ChainAPI) GetCode(ctx context.Context, addr common.Address, blockNr rpc.BlockNumber) (hexutil.Bytes, error) {
+	if blockNr != rpc.LatestBlockNumber {
+		return nil, fmt.Errorf("only the latest block is served")
+	}
+	return self.backend.CodeAt(ctx, addr, nil)
+}
+
+// the proofs in the audit namespace, and the chain in the eth namespace
+func (self *Log) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "audit",
+			Version:   "1.0",
+			Service:   &AuditAPI{log: self},
+			Public:    true,
+		},
+		{
+			Namespace: "eth",
+			Version:   "1.0",
+			Service:   &ChainAPI{backend: self.backend},
+			Public:    true,
+		},
+	}
+}
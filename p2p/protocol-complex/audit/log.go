@@ -0,0 +1,260 @@
+package audit
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+
+	"../protocol"
+)
+
+var errUnknownResult = errors.New("no such result in the committed batches")
+
+// the chain the roots are committed to
+// a simulated backend mines a block on Commit
+type Backend interface {
+	bind.ContractBackend
+	bind.DeployBackend
+	Commit()
+}
+
+// a batch of records, and the transaction its root was committed in
+type Batch struct {
+	Number  uint64
+	Root    common.Hash
+	Tx      common.Hash
+	records []*Record
+	leaves  []common.Hash
+}
+
+// the results in the batch, in the order of the leaves
+func (self *Batch) Records() []*Record {
+	return self.records
+}
+
+// where a record is
+type location struct {
+	batch int
+	index int
+}
+
+// a log of the job results the demo service got, anchored on chain
+// the results are collected in batches, and the merkle root of each batch is committed to the anchor contract
+// anyone with a result and its proof can then check it was logged, against the root on chain
+type Log struct {
+	backend  Backend
+	opts     *bind.TransactOpts
+	anchor   *Anchor
+	address  common.Address
+	interval time.Duration
+
+	commitMu sync.Mutex // one commit at a time, so the batch numbers follow the count of the contract
+	mu       sync.Mutex
+	pending  []*Record
+	batches  []*Batch
+	ids      map[string]location // by result id
+	quitC    chan struct{}
+	wg       sync.WaitGroup
+}
+
+// deploys the anchor contract with the key, which is the only one allowed to commit to it
+func NewLog(backend Backend, key *ecdsa.PrivateKey, interval time.Duration) (*Log, error) {
+	opts := bind.NewKeyedTransactor(key)
+	address, tx, anchor, err := DeployAnchor(opts, backend)
+	if err != nil {
+		return nil, err
+	}
+	backend.Commit()
+	if _, err := bind.WaitDeployed(context.Background(), backend, tx); err != nil {
+		return nil, err
+	}
+	return &Log{
+		backend:  backend,
+		opts:     opts,
+		anchor:   anchor,
+		address:  address,
+		interval: interval,
+		ids:      make(map[string]location),
+		quitC:    make(chan struct{}),
+	}, nil
+}
+
+// the address of the anchor contract
+func (self *Log) Address() common.Address {
+	return self.address
+}
+
+// adds a result to the next batch
+// it's a service.SaveFunc, so the demo service can be given it directly
+func (self *Log) Save(nid []byte, id protocol.ID, difficulty uint8, data []byte, nonce []byte, hash []byte) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.pending = append(self.pending, &Record{
+		Node:       common.CopyBytes(nid),
+		Id:         common.CopyBytes(id[:]),
+		Difficulty: difficulty,
+		Data:       common.CopyBytes(data),
+		Nonce:      common.CopyBytes(nonce),
+		Hash:       common.CopyBytes(hash),
+	})
+}
+
+// commits a batch every interval, until stopped
+func (self *Log) Start() {
+	self.wg.Add(1)
+	go func() {
+		defer self.wg.Done()
+		ticker := time.NewTicker(self.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-self.quitC:
+				return
+			case <-ticker.C:
+			}
+			batch, err := self.Commit()
+			if err != nil {
+				log.Error("audit commit fail", "err", err)
+			} else if batch != nil {
+				log.Info("audit batch committed", "batch", batch.Number, "results", len(batch.records), "root", batch.Root.Hex())
+			}
+		}
+	}()
+}
+
+func (self *Log) Stop() {
+	close(self.quitC)
+	self.wg.Wait()
+}
+
+// commits the root of the results saved since the last batch
+// nothing is committed if there are none, and the batch returned is nil
+func (self *Log) Commit() (*Batch, error) {
+	self.commitMu.Lock()
+	defer self.commitMu.Unlock()
+
+	self.mu.Lock()
+	records := self.pending
+	self.pending = nil
+	number := uint64(len(self.batches))
+	self.mu.Unlock()
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	// if the commit fails the records go in the next batch
+	requeue := func() {
+		self.mu.Lock()
+		self.pending = append(records, self.pending...)
+		self.mu.Unlock()
+	}
+	batch := &Batch{
+		Number:  number,
+		records: records,
+	}
+	for _, r := range records {
+		batch.leaves = append(batch.leaves, r.Leaf())
+	}
+	batch.Root = merkleRoot(batch.leaves)
+	tx, err := self.anchor.Commit(self.opts, batch.Root)
+	if err != nil {
+		requeue()
+		return nil, err
+	}
+	self.backend.Commit()
+	receipt, err := bind.WaitMined(context.Background(), self.backend, tx)
+	if err != nil {
+		requeue()
+		return nil, err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		requeue()
+		return nil, fmt.Errorf("commit of batch %d failed in tx %s", number, tx.Hash().Hex())
+	}
+	batch.Tx = tx.Hash()
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for i, r := range records {
+		self.ids[r.Id.String()] = location{
+			batch: len(self.batches),
+			index: i,
+		}
+	}
+	self.batches = append(self.batches, batch)
+	return batch, nil
+}
+
+// the batches committed so far
+func (self *Log) Batches() []*Batch {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return append([]*Batch(nil), self.batches...)
+}
+
+// the proof of the result with the id, once its batch is committed
+func (self *Log) Prove(id []byte) (*Proof, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	loc, ok := self.ids[hexutil.Encode(id)]
+	if !ok {
+		return nil, errUnknownResult
+	}
+	batch := self.batches[loc.batch]
+	return &Proof{
+		Record:   batch.records[loc.index],
+		Batch:    batch.Number,
+		Index:    uint64(loc.index),
+		Siblings: merkleProof(batch.leaves, loc.index),
+	}, nil
+}
+
+// a trie of the results in the first count batches
+// the log goes on committing batches, so a trie root is only good with the count it was made with
+func (self *Log) Trie(count uint64) (*ResultTrie, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if count > uint64(len(self.batches)) {
+		return nil, fmt.Errorf("only %d batches committed", len(self.batches))
+	}
+	var records []*Record
+	for _, batch := range self.batches[:count] {
+		records = append(records, batch.records...)
+	}
+	return NewResultTrie(records)
+}
+
+// checks the proof against the root of its batch in the anchor contract of the log
+func (self *Log) Verify(proof *Proof) (common.Hash, error) {
+	return VerifyOnChain(self.backend, self.address, proof)
+}
+
+// checks the proof against the root of its batch in the anchor contract at the address, and returns the root
+// the caller can be the backend of the log, or an ethclient connected to a node of the same chain
+func VerifyOnChain(caller bind.ContractCaller, address common.Address, proof *Proof) (common.Hash, error) {
+	anchor, err := NewAnchorCaller(address, caller)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	root, err := anchor.Roots(nil, new(big.Int).SetUint64(proof.Batch))
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if root == (common.Hash{}) {
+		return common.Hash{}, fmt.Errorf("no root committed for batch %d", proof.Batch)
+	}
+	if !proof.Verify(root) {
+		return root, fmt.Errorf("result %s is not in batch %d, the proof leads to %x", proof.Record.Id, proof.Batch, proof.Root())
+	}
+	return root, nil
+}
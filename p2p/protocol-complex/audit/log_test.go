@@ -0,0 +1,178 @@
+package audit
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"../protocol"
+)
+
+// a log deployed by a funded key, with the other keys funded too
+func newTestLog(t *testing.T, others ...*ecdsa.PrivateKey) (*Log, *backends.SimulatedBackend) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alloc := core.GenesisAlloc{}
+	for _, k := range append(others, key) {
+		alloc[crypto.PubkeyToAddress(k.PublicKey)] = core.GenesisAccount{Balance: big.NewInt(1000000000000000000)}
+	}
+	backend := backends.NewSimulatedBackend(alloc, 10000000)
+	l, err := NewLog(backend, key, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, backend
+}
+
+// the roots land in the contract in order, and every result proves against the root of its batch
+func TestLogCommit(t *testing.T) {
+	l, backend := newTestLog(t)
+	anchor, err := NewAnchorCaller(l.Address(), backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if batch, err := l.Commit(); batch != nil || err != nil {
+		t.Fatalf("empty commit gave batch %v, err %v", batch, err)
+	}
+	var ids []protocol.ID
+	for b, size := range []int{3, 1, 4} {
+		for i := 0; i < size; i++ {
+			id := protocol.ID{byte(b), byte(i)}
+			l.Save([]byte("submitter"), id, 8, []byte("data"), []byte{byte(i)}, []byte{byte(b)})
+			ids = append(ids, id)
+		}
+		batch, err := l.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if batch.Number != uint64(b) {
+			t.Fatalf("batch %d committed as %d", b, batch.Number)
+		}
+		root, err := anchor.Roots(nil, big.NewInt(int64(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if common.Hash(root) != batch.Root {
+			t.Fatalf("batch %d: contract has root %x, committed %x", b, root, batch.Root)
+		}
+	}
+	count, err := anchor.Count(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.Uint64() != 3 {
+		t.Fatalf("contract has %d roots, expected 3", count)
+	}
+
+	for _, id := range ids {
+		proof, err := l.Prove(id[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := VerifyOnChain(backend, l.Address(), proof); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.Prove([]byte("unknown")); err != errUnknownResult {
+		t.Fatalf("proof of unknown result gave err %v", err)
+	}
+}
+
+// only the owner commits
+func TestAnchorOwner(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, backend := newTestLog(t, key)
+	anchor, err := NewAnchor(l.Address(), backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := anchor.Owner(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != l.opts.From {
+		t.Fatalf("owner is %x, expected %x", owner, l.opts.From)
+	}
+
+	// the gas limit is given, or the estimate would fail before the transaction is sent
+	opts := bind.NewKeyedTransactor(key)
+	opts.GasLimit = 100000
+	tx, err := anchor.Commit(opts, [32]byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+	receipt, err := backend.TransactionReceipt(context.Background(), tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != types.ReceiptStatusFailed {
+		t.Fatal("commit by someone else than the owner went through")
+	}
+	count, err := anchor.Count(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.Sign() != 0 {
+		t.Fatalf("contract has %d roots after a failed commit", count)
+	}
+}
+
+// a trie root holds for the batches it was made of, and doesn't know the results committed after
+func TestLogTrie(t *testing.T) {
+	l, _ := newTestLog(t)
+	first := protocol.ID{0, 1}
+	second := protocol.ID{1, 1}
+	l.Save([]byte("submitter"), first, 8, []byte("data"), []byte{1}, []byte{1})
+	if _, err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := l.Trie(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Save([]byte("submitter"), second, 8, []byte("data"), []byte{2}, []byte{2})
+	if _, err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Trie(3); err == nil {
+		t.Fatal("trie of more batches than committed")
+	}
+	after, err := l.Trie(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := l.Trie(1); again.Root() != before.Root() {
+		t.Fatalf("trie of the first batch changed from %x to %x", before.Root(), again.Root())
+	}
+
+	proof, err := after.Prove(second[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyTrieProof(after.Root(), proof); err != nil {
+		t.Fatal(err)
+	}
+	proof, err = before.Prove(second[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyTrieProof(before.Root(), proof); err != errUnknownResult {
+		t.Fatalf("result of the second batch in the trie of the first: expected %v, got %v", errUnknownResult, err)
+	}
+}
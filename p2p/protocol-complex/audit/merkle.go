@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// leaves and inner nodes are hashed with different prefixes, so an inner node can't pass for a record
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// a job result, as the submitter got it from the worker and checked it
+type Record struct {
+	Node       hexutil.Bytes `json:"node"` // the submitter
+	Id         hexutil.Bytes `json:"id"`
+	Difficulty uint8         `json:"difficulty"`
+	Data       hexutil.Bytes `json:"data"`
+	Nonce      hexutil.Bytes `json:"nonce"`
+	Hash       hexutil.Bytes `json:"hash"`
+}
+
+// the hash of the record in the tree
+func (self *Record) Leaf() common.Hash {
+	b, err := rlp.EncodeToBytes(self)
+	if err != nil {
+		panic(err) // only byte slices and an uint8, which always encode
+	}
+	return crypto.Keccak256Hash([]byte{leafPrefix}, b)
+}
+
+// the way from a record to the root of its batch
+// at each level the sibling is on the right if the bit of the index is 0, on the left if it's 1
+type Proof struct {
+	Record   *Record       `json:"record"`
+	Batch    uint64        `json:"batch"` // the index of the root in the anchor contract
+	Index    uint64        `json:"index"` // the position of the record in the batch
+	Siblings []common.Hash `json:"siblings"`
+}
+
+// the root the proof leads to
+func (self *Proof) Root() common.Hash {
+	h := self.Record.Leaf()
+	index := self.Index
+	for _, s := range self.Siblings {
+		if index&1 == 0 {
+			h = hashNode(h, s)
+		} else {
+			h = hashNode(s, h)
+		}
+		index >>= 1
+	}
+	return h
+}
+
+// true if the proof leads to the root
+// the index must be used up by the siblings, or the same proof would do for more than one position
+func (self *Proof) Verify(root common.Hash) bool {
+	if self.Record == nil || self.Index>>uint(len(self.Siblings)) != 0 {
+		return false
+	}
+	return self.Root() == root
+}
+
+// the merkle root of the leaves
+// a level with an odd number of nodes gets a zero hash at the end
+func merkleRoot(leaves []common.Hash) common.Hash {
+	if len(leaves) == 0 {
+		return common.Hash{}
+	}
+	level := leaves
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+// the siblings on the way from the leaf at index to the root
+func merkleProof(leaves []common.Hash, index int) []common.Hash {
+	var siblings []common.Hash
+	level := leaves
+	for len(level) > 1 {
+		if index^1 < len(level) {
+			siblings = append(siblings, level[index^1])
+		} else {
+			siblings = append(siblings, common.Hash{})
+		}
+		level = nextLevel(level)
+		index >>= 1
+	}
+	return siblings
+}
+
+func nextLevel(level []common.Hash) []common.Hash {
+	next := make([]common.Hash, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		var right common.Hash
+		if i+1 < len(level) {
+			right = level[i+1]
+		}
+		next = append(next, hashNode(level[i], right))
+	}
+	return next
+}
+
+func hashNode(left common.Hash, right common.Hash) common.Hash {
+	return crypto.Keccak256Hash([]byte{nodePrefix}, left[:], right[:])
+}
@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func testRecords(n int) ([]*Record, []common.Hash) {
+	var records []*Record
+	var leaves []common.Hash
+	for i := 0; i < n; i++ {
+		r := &Record{
+			Node:       []byte("submitter"),
+			Id:         []byte{byte(i), 0, 0, 0, 0, 0, 0, 0},
+			Difficulty: 8,
+			Data:       []byte(fmt.Sprintf("job %d", i)),
+			Nonce:      []byte{byte(i)},
+			Hash:       []byte{0xff, byte(i)},
+		}
+		records = append(records, r)
+		leaves = append(leaves, r.Leaf())
+	}
+	return records, leaves
+}
+
+// every record of batches of all sizes up to a few levels leads to the root
+func TestProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		records, leaves := testRecords(n)
+		root := merkleRoot(leaves)
+		for i, r := range records {
+			proof := &Proof{
+				Record:   r,
+				Index:    uint64(i),
+				Siblings: merkleProof(leaves, i),
+			}
+			if !proof.Verify(root) {
+				t.Fatalf("batch of %d: proof of record %d doesn't lead to the root", n, i)
+			}
+		}
+	}
+}
+
+func TestProofInvalid(t *testing.T) {
+	records, leaves := testRecords(5)
+	root := merkleRoot(leaves)
+	proof := func() *Proof {
+		return &Proof{
+			Record:   records[2],
+			Index:    2,
+			Siblings: merkleProof(leaves, 2),
+		}
+	}
+
+	p := proof()
+	p.Record = &Record{
+		Node:       records[2].Node,
+		Id:         records[2].Id,
+		Difficulty: records[2].Difficulty,
+		Data:       records[2].Data,
+		Nonce:      []byte{0x42},
+		Hash:       records[2].Hash,
+	}
+	if p.Verify(root) {
+		t.Fatal("proof verified with a changed record")
+	}
+
+	p = proof()
+	p.Index = 3
+	if p.Verify(root) {
+		t.Fatal("proof verified at another position")
+	}
+
+	// an index with more bits than levels would otherwise give the same root
+	p = proof()
+	p.Index += 1 << uint(len(p.Siblings))
+	if p.Verify(root) {
+		t.Fatal("proof verified with an index out of the batch")
+	}
+
+	p = proof()
+	p.Siblings = p.Siblings[1:]
+	if p.Verify(root) {
+		t.Fatal("proof verified with a sibling missing")
+	}
+
+	// an inner node isn't a record
+	p = proof()
+	if p.Verify(hashNode(leaves[2], leaves[3])) {
+		t.Fatal("proof verified against another root")
+	}
+}
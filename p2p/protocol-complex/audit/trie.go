@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// the results in a merkle patricia trie, the tree ethereum keeps its state in
+// unlike the batches, which prove a result by its position, the trie proves it by its id
+// and it can prove an id isn't there too
+type ResultTrie struct {
+	trie *trie.Trie
+}
+
+// a trie of the records, keyed by their ids
+// a record with the same id as an earlier one replaces it
+func NewResultTrie(records []*Record) (*ResultTrie, error) {
+	t, err := trie.New(common.Hash{}, trie.NewDatabase(ethdb.NewMemDatabase()))
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		value, err := rlp.EncodeToBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		t.Update(r.Id, value)
+	}
+	return &ResultTrie{trie: t}, nil
+}
+
+// the root hash, all a verifier needs to hold
+func (self *ResultTrie) Root() common.Hash {
+	return self.trie.Hash()
+}
+
+// the nodes on the way from the root to the id
+// they prove the record with the id, or that there is none
+func (self *ResultTrie) Prove(id []byte) (*TrieProof, error) {
+	proof := &TrieProof{
+		Id: common.CopyBytes(id),
+	}
+	if err := self.trie.Prove(id, 0, proof); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// the trie nodes of a proof, from the root down
+type TrieProof struct {
+	Id    hexutil.Bytes   `json:"id"`
+	Nodes []hexutil.Bytes `json:"nodes"`
+}
+
+// ethdb.Putter, for the trie to put the nodes in as it proves
+func (self *TrieProof) Put(key []byte, value []byte) error {
+	self.Nodes = append(self.Nodes, common.CopyBytes(value))
+	return nil
+}
+
+// the record the proof leads to from the root
+// the nodes are looked up by their hash, so a node not hashing to what its parent says is as good as missing
+// a proof that the id isn't in the trie returns errUnknownResult
+func VerifyTrieProof(root common.Hash, proof *TrieProof) (*Record, error) {
+	db := ethdb.NewMemDatabase()
+	for _, n := range proof.Nodes {
+		db.Put(crypto.Keccak256(n), n)
+	}
+	value, _, err := trie.VerifyProof(root, proof.Id, db)
+	if err != nil {
+		return nil, fmt.Errorf("trie proof of %s: %v", proof.Id, err)
+	}
+	if value == nil {
+		return nil, errUnknownResult
+	}
+	var r Record
+	if err := rlp.DecodeBytes(value, &r); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(r.Id, proof.Id) {
+		return nil, fmt.Errorf("trie proof of %s leads to record %s", proof.Id, r.Id)
+	}
+	return &r, nil
+}
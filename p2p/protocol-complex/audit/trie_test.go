@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"bytes"
+	"testing"
+)
+
+// every record is proven by its id, against the root alone
+func TestTrieProof(t *testing.T) {
+	records, _ := testRecords(20)
+	tr, err := NewResultTrie(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tr.Root()
+	for _, r := range records {
+		proof, err := tr.Prove(r.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := VerifyTrieProof(root, proof)
+		if err != nil {
+			t.Fatalf("record %s: %v", r.Id, err)
+		}
+		if got.Leaf() != r.Leaf() {
+			t.Fatalf("record %s: proof leads to %+v", r.Id, got)
+		}
+	}
+}
+
+func TestTrieProofInvalid(t *testing.T) {
+	records, _ := testRecords(20)
+	tr, err := NewResultTrie(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tr.Root()
+
+	// an id that isn't there is proven absent
+	proof, err := tr.Prove([]byte{0xee, 0, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyTrieProof(root, proof); err != errUnknownResult {
+		t.Fatalf("absent id: expected %v, got %v", errUnknownResult, err)
+	}
+
+	// a proof with a node changed, or one missing, or against another root, proves nothing
+	proof, err = tr.Prove(records[3].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := len(proof.Nodes) - 1
+	changed := &TrieProof{Id: proof.Id, Nodes: append(proof.Nodes[:last:last], bytes.Replace(proof.Nodes[last], []byte("job 3"), []byte("job X"), 1))}
+	if _, err := VerifyTrieProof(root, changed); err == nil {
+		t.Fatal("proof with a changed node verified")
+	}
+	missing := &TrieProof{Id: proof.Id, Nodes: proof.Nodes[:last]}
+	if _, err := VerifyTrieProof(root, missing); err == nil {
+		t.Fatal("proof with a node missing verified")
+	}
+	other, err := NewResultTrie(records[:10])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyTrieProof(other.Root(), proof); err == nil {
+		t.Fatal("proof verified against another root")
+	}
+}
@@ -0,0 +1,204 @@
+// Package bench sweeps the parameters of a simulation, and reports how each configuration performed
+//
+// A Sweep is lists of node counts, difficulty ranges and submit delays, and its configurations are all
+// the combinations of them. The simulation runs each, and makes a Result of how long it ran and how long
+// each job it got took. The results are written as csv, and plotted against the node count, one line
+// for each difficulty and delay.
+package bench
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is one combination of the parameters of a sweep
+type Config struct {
+	Nodes         int
+	MinDifficulty uint8
+	MaxDifficulty uint8
+	SubmitDelay   time.Duration
+}
+
+// the configurations that differ only in the node count are on the same line of a plot
+func (c Config) series() string {
+	return fmt.Sprintf("difficulty %d-%d, delay %v", c.MinDifficulty, c.MaxDifficulty, c.SubmitDelay)
+}
+
+func (c Config) String() string {
+	return fmt.Sprintf("nodes %d, %s", c.Nodes, c.series())
+}
+
+// Range is the difficulties of the jobs, from min to max
+type Range struct {
+	Min, Max uint8
+}
+
+// Sweep is the values each parameter takes
+type Sweep struct {
+	Nodes        []int
+	Difficulties []Range
+	Delays       []time.Duration
+}
+
+// ParseSweep makes a sweep of comma separated lists, like "3,5", "8-12,16" and "50ms,100ms"
+func ParseSweep(nodes, difficulties, delays string) (*Sweep, error) {
+	s := &Sweep{}
+	for _, v := range strings.Split(nodes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 2 {
+			return nil, fmt.Errorf("invalid node count %q, need a worker and a submitter at least", v)
+		}
+		s.Nodes = append(s.Nodes, n)
+	}
+	for _, v := range strings.Split(difficulties, ",") {
+		r, err := parseRange(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		s.Difficulties = append(s.Difficulties, r)
+	}
+	for _, v := range strings.Split(delays, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid submit delay %q", v)
+		}
+		s.Delays = append(s.Delays, d)
+	}
+	return s, nil
+}
+
+func parseRange(v string) (Range, error) {
+	parts := strings.SplitN(v, "-", 2)
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+	min, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil {
+		return Range{}, fmt.Errorf("invalid difficulty %q", v)
+	}
+	max, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil || min == 0 || max < min {
+		return Range{}, fmt.Errorf("invalid difficulty %q", v)
+	}
+	return Range{Min: uint8(min), Max: uint8(max)}, nil
+}
+
+// Configs is all the combinations of the values of the sweep, the node count changing fastest
+func (s *Sweep) Configs() []Config {
+	var configs []Config
+	for _, r := range s.Difficulties {
+		for _, d := range s.Delays {
+			for _, n := range s.Nodes {
+				configs = append(configs, Config{Nodes: n, MinDifficulty: r.Min, MaxDifficulty: r.Max, SubmitDelay: d})
+			}
+		}
+	}
+	return configs
+}
+
+// Result is how a configuration performed
+type Result struct {
+	Config
+	// how long the jobs were submitted for
+	Took time.Duration
+	// the jobs that got a result in that time
+	Jobs int
+	// jobs per second
+	Throughput float64
+	// from submitting a job to its result
+	MeanLatency time.Duration
+	P50Latency  time.Duration
+	P95Latency  time.Duration
+	MaxLatency  time.Duration
+}
+
+// NewResult is the result of a configuration that ran for took, with the latencies of the jobs done
+func NewResult(c Config, took time.Duration, latencies []time.Duration) *Result {
+	r := &Result{
+		Config: c,
+		Took:   took,
+		Jobs:   len(latencies),
+	}
+	if took > 0 {
+		r.Throughput = float64(r.Jobs) / took.Seconds()
+	}
+	if len(latencies) == 0 {
+		return r
+	}
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum time.Duration
+	for _, l := range sorted {
+		sum += l
+	}
+	r.MeanLatency = sum / time.Duration(len(sorted))
+	r.P50Latency = percentile(sorted, 50)
+	r.P95Latency = percentile(sorted, 95)
+	r.MaxLatency = sorted[len(sorted)-1]
+	return r
+}
+
+// the nearest rank of the sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+var header = []string{"nodes", "min_difficulty", "max_difficulty", "submit_delay_ms", "took_ms", "jobs", "throughput", "mean_ms", "p50_ms", "p95_ms", "max_ms"}
+
+// WriteCSV writes the results with a header, the durations in milliseconds and the throughput in jobs per second
+func WriteCSV(w io.Writer, results []*Result) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range results {
+		err := cw.Write([]string{
+			strconv.Itoa(r.Nodes),
+			strconv.Itoa(int(r.MinDifficulty)),
+			strconv.Itoa(int(r.MaxDifficulty)),
+			ms(r.SubmitDelay),
+			ms(r.Took),
+			strconv.Itoa(r.Jobs),
+			strconv.FormatFloat(r.Throughput, 'f', 3, 64),
+			ms(r.MeanLatency),
+			ms(r.P50Latency),
+			ms(r.P95Latency),
+			ms(r.MaxLatency),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func ms(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 1, 64)
+}
+
+// WriteTable writes the results aligned for a terminal
+func WriteTable(w io.Writer, results []*Result) error {
+	if _, err := fmt.Fprintf(w, "%5s %-10s %8s %6s %10s %10s %10s %10s\n", "nodes", "difficulty", "delay", "jobs", "jobs/s", "mean", "p95", "max"); err != nil {
+		return err
+	}
+	for _, r := range results {
+		_, err := fmt.Fprintf(w, "%5d %-10s %8v %6d %10.2f %10v %10v %10v\n",
+			r.Nodes, fmt.Sprintf("%d-%d", r.MinDifficulty, r.MaxDifficulty), r.SubmitDelay, r.Jobs, r.Throughput,
+			r.MeanLatency.Round(time.Millisecond), r.P95Latency.Round(time.Millisecond), r.MaxLatency.Round(time.Millisecond))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSweep(t *testing.T) {
+	s, err := ParseSweep("3, 5", "8-12,16", "50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := s.Configs()
+	if len(configs) != 4 {
+		t.Fatalf("%d configs, want 4", len(configs))
+	}
+	want := Config{Nodes: 5, MinDifficulty: 16, MaxDifficulty: 16, SubmitDelay: time.Millisecond * 50}
+	if configs[3] != want {
+		t.Fatalf("last config %v, want %v", configs[3], want)
+	}
+	if configs[0].Nodes != 3 || configs[1].Nodes != 5 || configs[0].MaxDifficulty != 12 {
+		t.Fatalf("configs %v", configs)
+	}
+
+	for _, c := range [][3]string{
+		{"1", "8", "50ms"},
+		{"3", "12-8", "50ms"},
+		{"3", "0", "50ms"},
+		{"3", "8", "soon"},
+	} {
+		if _, err := ParseSweep(c[0], c[1], c[2]); err == nil {
+			t.Fatalf("sweep %v parsed", c)
+		}
+	}
+}
+
+func TestNewResult(t *testing.T) {
+	var latencies []time.Duration
+	for i := 20; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	r := NewResult(Config{Nodes: 3}, time.Second*4, latencies)
+	if r.Jobs != 20 || r.Throughput != 5 {
+		t.Fatalf("jobs %d, throughput %v", r.Jobs, r.Throughput)
+	}
+	if r.MeanLatency != time.Microsecond*10500 || r.P50Latency != time.Millisecond*10 || r.P95Latency != time.Millisecond*19 || r.MaxLatency != time.Millisecond*20 {
+		t.Fatalf("latencies %v %v %v %v", r.MeanLatency, r.P50Latency, r.P95Latency, r.MaxLatency)
+	}
+	// the latencies given are left as they were
+	if latencies[0] != time.Millisecond*20 {
+		t.Fatal("latencies sorted in place")
+	}
+
+	r = NewResult(Config{Nodes: 3}, time.Second, nil)
+	if r.Jobs != 0 || r.Throughput != 0 || r.MaxLatency != 0 {
+		t.Fatalf("result of no jobs %+v", r)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	results := []*Result{
+		NewResult(Config{Nodes: 3, MinDifficulty: 8, MaxDifficulty: 12, SubmitDelay: time.Millisecond * 100}, time.Second*2, []time.Duration{time.Millisecond * 3, time.Millisecond * 5}),
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || len(records[1]) != len(header) {
+		t.Fatalf("records %v", records)
+	}
+	want := []string{"3", "8", "12", "100.0", "2000.0", "2", "1.000", "4.0", "3.0", "5.0", "5.0"}
+	for i, v := range want {
+		if records[1][i] != v {
+			t.Fatalf("%s is %s, want %s", header[i], records[1][i], v)
+		}
+	}
+}
+
+func TestPlot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := ParseSweep("2,3,4", "8,12", "50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []*Result
+	for _, c := range s.Configs() {
+		results = append(results, NewResult(c, time.Second, []time.Duration{time.Duration(c.Nodes*int(c.MaxDifficulty)) * time.Millisecond}))
+	}
+	for name, plot := range map[string]func([]*Result, string) error{
+		"throughput.png": PlotThroughput,
+		"latency.svg":    PlotLatency,
+	} {
+		file := filepath.Join(dir, name)
+		if err := plot(results, file); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if fi, err := os.Stat(file); err != nil || fi.Size() == 0 {
+			t.Fatalf("%s not written: %v", name, err)
+		}
+	}
+}
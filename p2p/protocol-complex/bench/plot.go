@@ -0,0 +1,61 @@
+package bench
+
+import (
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+const (
+	plotWidth  = 16 * vg.Centimeter
+	plotHeight = 10 * vg.Centimeter
+)
+
+// PlotThroughput plots the jobs per second against the node count, a line for each difficulty and delay
+// the format is that of the extension of the file, png, svg or pdf
+func PlotThroughput(results []*Result, file string) error {
+	return plotSeries(results, file, "throughput", "jobs/s", func(r *Result) float64 {
+		return r.Throughput
+	})
+}
+
+// PlotLatency plots the 95th percentile of the latency of the jobs against the node count
+func PlotLatency(results []*Result, file string) error {
+	return plotSeries(results, file, "latency, 95th percentile", "ms", func(r *Result) float64 {
+		return float64(r.P95Latency) / float64(time.Millisecond)
+	})
+}
+
+func plotSeries(results []*Result, file string, title string, unit string, value func(*Result) float64) error {
+	p, err := plot.New()
+	if err != nil {
+		return err
+	}
+	p.Title.Text = title
+	p.X.Label.Text = "nodes"
+	p.Y.Label.Text = unit
+	p.Y.Min = 0
+
+	// the series in the order they first appear
+	var names []string
+	points := make(map[string]plotter.XYs)
+	for _, r := range results {
+		s := r.series()
+		if _, ok := points[s]; !ok {
+			names = append(names, s)
+		}
+		points[s] = append(points[s], struct{ X, Y float64 }{X: float64(r.Nodes), Y: value(r)})
+	}
+	// the name of each line, then its points
+	var lines []interface{}
+	for _, s := range names {
+		lines = append(lines, s, points[s])
+	}
+	if err := plotutil.AddLinePoints(p, lines...); err != nil {
+		return err
+	}
+	return p.Save(plotWidth, plotHeight, file)
+}
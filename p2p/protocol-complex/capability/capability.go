@@ -0,0 +1,87 @@
+// Package capability negotiates what two peers of an application protocol can do
+//
+// Each side announces its Caps in its handshake: the version of the application protocol it speaks,
+// and a bitmap of the optional features it has. What the two of them can use is the lower version
+// and the features both have, which Negotiate computes.
+//
+// A feature is a bit number the protocol gives a meaning to, for good. A message added to the protocol goes
+// behind a new feature, and is only sent to peers that have it, so nodes that don't know the message yet
+// still work with the ones that do, without the devp2p version of the protocol changing
+package capability
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the number of features a bitmap holds
+const MaxFeatures = 64
+
+// Feature is the number of the bit of a feature in the bitmap
+type Feature uint8
+
+// Caps is what a peer announces in its handshake, or what two peers negotiated
+type Caps struct {
+	Version  uint32
+	Features uint64
+}
+
+// New returns the caps of the version with the features
+func New(version uint32, features ...Feature) Caps {
+	return Caps{Version: version}.With(features...)
+}
+
+// With returns the caps with the features added
+func (c Caps) With(features ...Feature) Caps {
+	for _, f := range features {
+		if f >= MaxFeatures {
+			panic(fmt.Sprintf("feature %d doesn't fit the bitmap", f))
+		}
+		c.Features |= 1 << f
+	}
+	return c
+}
+
+// Has tells if the feature is in the caps
+func (c Caps) Has(f Feature) bool {
+	return f < MaxFeatures && c.Features&(1<<f) != 0
+}
+
+// List returns the features in the caps, lowest first
+func (c Caps) List() (features []Feature) {
+	for f := Feature(0); f < MaxFeatures; f++ {
+		if c.Has(f) {
+			features = append(features, f)
+		}
+	}
+	return features
+}
+
+// Negotiate returns what the two sides can use: the lower of their versions, and the features they both have
+func Negotiate(ours Caps, theirs Caps) Caps {
+	c := Caps{
+		Version:  ours.Version,
+		Features: ours.Features & theirs.Features,
+	}
+	if theirs.Version < c.Version {
+		c.Version = theirs.Version
+	}
+	return c
+}
+
+// Names are the names of the features of a protocol, for logs and apis
+type Names map[Feature]string
+
+// Format returns the version and the names of the features in the caps
+// features without a name, which a newer peer may have, are given by their number
+func (n Names) Format(c Caps) string {
+	var names []string
+	for _, f := range c.List() {
+		if name, ok := n[f]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("#%d", f))
+		}
+	}
+	return fmt.Sprintf("v%d[%s]", c.Version, strings.Join(names, ","))
+}
@@ -0,0 +1,71 @@
+package capability
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	featureFoo Feature = iota
+	featureBar
+	featureBaz
+)
+
+var testNames = Names{
+	featureFoo: "foo",
+	featureBar: "bar",
+	featureBaz: "baz",
+}
+
+func TestNegotiate(t *testing.T) {
+	ours := New(3, featureFoo, featureBar, featureBaz)
+	theirs := New(2, featureBar, featureBaz, 40)
+	c := Negotiate(ours, theirs)
+	if c != Negotiate(theirs, ours) {
+		t.Fatalf("negotiation depends on the side: %v, %v", c, Negotiate(theirs, ours))
+	}
+	if c.Version != 2 {
+		t.Fatalf("version %d, want 2", c.Version)
+	}
+	if c.Has(featureFoo) || !c.Has(featureBar) || !c.Has(featureBaz) || c.Has(40) {
+		t.Fatalf("features %v, want bar and baz", c.List())
+	}
+	if !reflect.DeepEqual(c.List(), []Feature{featureBar, featureBaz}) {
+		t.Fatalf("list %v", c.List())
+	}
+}
+
+// a peer from before the features announces none, and gets none of them
+func TestNegotiateNone(t *testing.T) {
+	c := Negotiate(New(1, featureFoo, featureBar), Caps{})
+	if c.Features != 0 || c.Version != 0 {
+		t.Fatalf("got %v, want nothing", c)
+	}
+	if c.Has(MaxFeatures) {
+		t.Fatal("has a feature beyond the bitmap")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	s := testNames.Format(New(7, featureBaz, featureFoo, 63))
+	if s != "v7[foo,baz,#63]" {
+		t.Fatalf("got %s", s)
+	}
+}
+
+func TestCapsRLP(t *testing.T) {
+	in := New(5, featureFoo, 63)
+	b, err := rlp.EncodeToBytes(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Caps
+	if err := rlp.DecodeBytes(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Fatalf("got %v, want %v", out, in)
+	}
+}
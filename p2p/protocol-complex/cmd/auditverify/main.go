@@ -0,0 +1,113 @@
+// proves a job result was in the audit log of the demo, against the merkle root committed on chain
+//
+// the proof of the result comes from the audit log, the root from the anchor contract
+// the root is read with an ethclient, as it would be from any node of the chain, so the log only has to be trusted for the proof
+//
+// usage, with sim.go running with -audit:
+//
+//	go run main.go -rpc http://localhost:8889 -id 0x1234567890abcdef
+//
+// with -root the result is proven against the root of the trie of the results in the first -batches batches instead
+// the root is all the verifier holds, and the trie can also prove there's no result with the id
+//
+//	go run main.go -rpc http://localhost:8889 -id 0x1234567890abcdef -root 0x... -batches 9
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"../../audit"
+)
+
+var (
+	endpoint = flag.String("rpc", "http://localhost:8889", "rpc endpoint serving the audit log and its chain")
+	resultId = flag.String("id", "", "id of the job result to prove, in hex")
+	contract = flag.String("contract", "", "address of the anchor contract, asked from the audit log if not given")
+	trieRoot = flag.String("root", "", "root of the trie of the results to prove the result against, in place of the anchor contract")
+	batches  = flag.Uint64("batches", 0, "number of batches the trie of -root was made of")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	id, err := hexutil.Decode(*resultId)
+	if err != nil {
+		return fmt.Errorf("invalid result id '%s': %v", *resultId, err)
+	}
+	client, err := rpc.Dial(*endpoint)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if *trieRoot != "" {
+		return proveInTrie(client, id, common.HexToHash(*trieRoot), *batches)
+	}
+
+	var address common.Address
+	if *contract != "" {
+		address = common.HexToAddress(*contract)
+	} else if err := client.Call(&address, "audit_anchor"); err != nil {
+		return fmt.Errorf("anchor address fail: %v", err)
+	}
+	var proof audit.Proof
+	if err := client.Call(&proof, "audit_proof", hexutil.Bytes(id)); err != nil {
+		return fmt.Errorf("proof fail: %v", err)
+	}
+
+	r := proof.Record
+	fmt.Printf("result    %s by %s\n", r.Id, r.Node)
+	fmt.Printf("job       %s@%d\n", r.Data, r.Difficulty)
+	fmt.Printf("solution  nonce %s hash %s\n", r.Nonce, r.Hash)
+	fmt.Printf("leaf      %s\n", r.Leaf().Hex())
+	fmt.Printf("batch     %d, position %d\n", proof.Batch, proof.Index)
+	for i, s := range proof.Siblings {
+		side := "right"
+		if proof.Index>>uint(i)&1 == 1 {
+			side = "left"
+		}
+		fmt.Printf("sibling   %-5s %s\n", side, s.Hex())
+	}
+
+	root, err := audit.VerifyOnChain(ethclient.NewClient(client), address, &proof)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("root      %s, in contract %s\n", root.Hex(), address.Hex())
+	fmt.Println("result is in the audit log")
+	return nil
+}
+
+// the trie nodes come from the log, and each must hash to what the one above it says, up to the root we hold
+func proveInTrie(client *rpc.Client, id []byte, root common.Hash, count uint64) error {
+	var proof audit.TrieProof
+	if err := client.Call(&proof, "audit_trieProof", hexutil.Bytes(id), count); err != nil {
+		return fmt.Errorf("trie proof fail: %v", err)
+	}
+	for i, n := range proof.Nodes {
+		fmt.Printf("node %-4d %s, %d bytes\n", i, crypto.Keccak256Hash(n).Hex(), len(n))
+	}
+	r, err := audit.VerifyTrieProof(root, &proof)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("result    %s by %s\n", r.Id, r.Node)
+	fmt.Printf("job       %s@%d\n", r.Data, r.Difficulty)
+	fmt.Printf("solution  nonce %s hash %s\n", r.Nonce, r.Hash)
+	fmt.Printf("root      %s, of %d batches\n", root.Hex(), count)
+	fmt.Println("result is in the trie")
+	return nil
+}
@@ -0,0 +1,240 @@
+// generates kubernetes manifests for running the demo service as a network of nodes
+//
+// the nodes run as a StatefulSet, so they get stable names: <name>-0, <name>-1 ...
+// a headless service makes those names resolvable within the cluster
+// the keys for all the nodes are made up front, so we know all the enodes before anything is started
+// they are put in a configmap that every node reads its static peers from
+//
+// usage:
+//
+//	go run main.go -n 5 -bin main_pss -image myrepo/pssdemo
+//	kubectl apply -f k8s/
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	replicas  = flag.Int("n", 3, "number of nodes")
+	name      = flag.String("name", "pssdemo", "name of the statefulset and services")
+	namespace = flag.String("namespace", "default", "kubernetes namespace")
+	domain    = flag.String("domain", "cluster.local", "cluster dns domain")
+	image     = flag.String("image", "pssdemo", "docker image built from the protocol-complex Dockerfile")
+	binary    = flag.String("bin", "main", "binary to run in the image, main or main_pss")
+	workdir   = flag.String("workdir", "/home/bzz", "directory of the binary in the image")
+	port      = flag.Int("p", 30499, "p2p port")
+	httpport  = flag.Int("a", 8545, "http rpc port")
+	bzzport   = flag.Int("b", 8555, "bzz port")
+	loglevel  = flag.Int("l", 3, "loglevel of the nodes")
+	outdir    = flag.String("o", "k8s", "output directory")
+)
+
+type manifestNode struct {
+	Name  string
+	Key   string
+	Enode string
+}
+
+type manifestEnv struct {
+	Name      string
+	Namespace string
+	Image     string
+	Command   string
+	Replicas  int
+	Port      int
+	HTTPPort  int
+	BzzPort   int
+	Nodes     []*manifestNode
+}
+
+// the node keys are kept in a separate file, so the rest can be shared without giving away the identities
+var secretTemplate = template.Must(template.New("secret").Parse(`apiVersion: v1
+kind: Secret
+metadata:
+  name: {{.Name}}-keys
+  namespace: {{.Namespace}}
+type: Opaque
+stringData:
+{{- range .Nodes}}
+  {{.Name}}: {{.Key}}
+{{- end}}
+`))
+
+var manifestTemplate = template.Must(template.New("manifest").Parse(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{.Name}}-enodes
+  namespace: {{.Namespace}}
+data:
+  static-nodes: |
+{{- range .Nodes}}
+    {{.Enode}}
+{{- end}}
+---
+# headless service, gives every pod a dns entry <pod>.{{.Name}}
+# the addresses must be published before the pods are ready, or the nodes can't find each other when starting
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{.Name}}
+  namespace: {{.Namespace}}
+spec:
+  clusterIP: None
+  publishNotReadyAddresses: true
+  selector:
+    app: {{.Name}}
+  ports:
+    - name: p2p
+      port: {{.Port}}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{.Name}}-rpc
+  namespace: {{.Namespace}}
+spec:
+  selector:
+    app: {{.Name}}
+  ports:
+    - name: http
+      port: {{.HTTPPort}}
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: {{.Name}}
+  namespace: {{.Namespace}}
+spec:
+  serviceName: {{.Name}}
+  replicas: {{.Replicas}}
+  podManagementPolicy: Parallel
+  selector:
+    matchLabels:
+      app: {{.Name}}
+  template:
+    metadata:
+      labels:
+        app: {{.Name}}
+    spec:
+      containers:
+        - name: {{.Name}}
+          image: {{.Image}}
+          command: [{{.Command}}]
+          env:
+            - name: POD_NAME
+              valueFrom:
+                fieldRef:
+                  fieldPath: metadata.name
+          ports:
+            - name: p2p
+              containerPort: {{.Port}}
+            - name: http
+              containerPort: {{.HTTPPort}}
+            - name: bzz
+              containerPort: {{.BzzPort}}
+          volumeMounts:
+            - name: keys
+              mountPath: /keys
+              readOnly: true
+            - name: enodes
+              mountPath: /enodes
+              readOnly: true
+      volumes:
+        - name: keys
+          secret:
+            secretName: {{.Name}}-keys
+        - name: enodes
+          configMap:
+            name: {{.Name}}-enodes
+`))
+
+func writeTemplate(path string, tpl *template.Template, env *manifestEnv) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return tpl.Execute(f, env)
+}
+
+func main() {
+	flag.Parse()
+
+	if *replicas < 1 {
+		fmt.Fprintln(os.Stderr, "need at least one node (-n)")
+		os.Exit(1)
+	}
+
+	// the pod name is substituted by kubernetes, and picks the right key from the secret
+	args := []string{
+		filepath.Join(*workdir, *binary),
+		"-l", fmt.Sprintf("%d", *loglevel),
+		"-p", fmt.Sprintf("%d", *port),
+		"-a", fmt.Sprintf("0.0.0.0:%d", *httpport),
+		"-b", fmt.Sprintf("%d", *bzzport),
+		"-k", "/keys/$(POD_NAME)",
+		"-s", "/enodes/static-nodes",
+	}
+	for i, arg := range args {
+		args[i] = fmt.Sprintf("%q", arg)
+	}
+
+	env := &manifestEnv{
+		Name:      *name,
+		Namespace: *namespace,
+		Image:     *image,
+		Command:   strings.Join(args, ", "),
+		Replicas:  *replicas,
+		Port:      *port,
+		HTTPPort:  *httpport,
+		BzzPort:   *bzzport,
+	}
+
+	// the enodes use the pods' dns names
+	// the nodes resolve them to addresses when they start
+	for i := 0; i < *replicas; i++ {
+		privkey, err := crypto.GenerateKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "key generate fail: %v\n", err)
+			os.Exit(1)
+		}
+		n := &manifestNode{
+			Name: fmt.Sprintf("%s-%d", *name, i),
+			Key:  hex.EncodeToString(crypto.FromECDSA(privkey)),
+		}
+		pubkey := crypto.FromECDSAPub(&privkey.PublicKey)[1:]
+		host := fmt.Sprintf("%s.%s.%s.svc.%s", n.Name, *name, *namespace, *domain)
+		n.Enode = fmt.Sprintf("enode://%x@%s:%d", pubkey, host, *port)
+		env.Nodes = append(env.Nodes, n)
+	}
+
+	err := os.MkdirAll(*outdir, 0700)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "output dir create fail: %v\n", err)
+		os.Exit(1)
+	}
+	err = writeTemplate(filepath.Join(*outdir, *name+".yaml"), manifestTemplate, env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "write manifest fail: %v\n", err)
+		os.Exit(1)
+	}
+	err = writeTemplate(filepath.Join(*outdir, *name+"-keys.yaml"), secretTemplate, env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "write secret fail: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, n := range env.Nodes {
+		fmt.Printf("%s\t%s\n", n.Name, n.Enode)
+	}
+	fmt.Printf("wrote manifests to %s, deploy with 'kubectl apply -f %s'\n", *outdir, *outdir)
+}
@@ -11,30 +11,51 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/node"
 
+	"./peers"
+	"./protocol"
 	"./service"
+	"./service/pow"
 )
 
 const (
 	ipcName              = "pssdemo.ipc"
+	staticResolveTimeout = time.Second * 30
 	defaultMaxDifficulty = 23
 	defaultMaxJobs       = 3
 	defaultMaxTime       = time.Second
+	defaultCacheSize     = 1024
+	defaultQueueSize     = 8
+	defaultProgress      = time.Millisecond * 250
 )
 
 var (
 	loglevel = flag.Int("l", 3, "loglevel")
+	codec    = flag.String("codec", "rlp", "encoding of the protocol messages, rlp or protobuf; all nodes must use the same")
+	powName  = flag.String("pow", "sha1", "proof of work algorithm, sha1, sha3 or ethash-lite; all nodes must use the same")
 	port     = flag.Int("p", 30499, "p2p port")
 	bzzport  = flag.String("b", "8555", "bzz port")
 	enode    = flag.String("e", "", "enode to connect to")
 	httpapi  = flag.String("a", "localhost:8545", "http api")
+	nodekey  = flag.String("k", "", "node private key file")
+	static   = flag.String("s", "", "file with enodes to keep connected to, one per line")
+	conffile = flag.String("c", "", "json file with the config to apply at start, and again on SIGHUP (see service.DemoConfig)")
+	pw       pow.Pow // the proof of work algorithm chosen with -pow
 )
 
 func init() {
 	flag.Parse()
 	log.Root().SetHandler(log.CallerFileHandler(log.LvlFilterHandler(log.Lvl(*loglevel), (log.StreamHandler(os.Stderr, log.TerminalFormat(true))))))
+	if err := protocol.SetCodec(*codec); err != nil {
+		log.Crit("codec fail", "err", err)
+	}
+	var err error
+	if pw, err = pow.New(*powName); err != nil {
+		log.Crit("pow fail", "err", err)
+	}
 }
 
 func main() {
@@ -65,6 +86,34 @@ func main() {
 	}
 	cfg.DataDir = datadir
 
+	// when running in a cluster the node identity is handed to us, so the other nodes can know our enode in advance
+	if *nodekey != "" {
+		cfg.P2P.PrivateKey, err = crypto.LoadECDSA(*nodekey)
+		if err != nil {
+			log.Error("node key load fail", "err", err)
+			return
+		}
+	}
+
+	// peers may be given by name, and those names will resolve only when the peers are up
+	var staticurls []string
+	if *enode != "" {
+		staticurls = append(staticurls, *enode)
+	}
+	if *static != "" {
+		urls, err := peers.ReadStaticNodes(*static)
+		if err != nil {
+			log.Error("static nodes read fail", "err", err)
+			return
+		}
+		staticurls = append(staticurls, urls...)
+	}
+	cfg.P2P.StaticNodes, err = peers.ResolveStaticNodes(staticurls, staticResolveTimeout)
+	if err != nil {
+		log.Error("static nodes fail", "err", err)
+		return
+	}
+
 	stack, err := node.New(cfg)
 	if err != nil {
 		log.Error("node create fail", "err", err)
@@ -72,12 +121,26 @@ func main() {
 	}
 
 	// create the demo service and register it with the node stack
+	// we keep it, to reload its config
+	var svc *service.Demo
 	if err := stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
 		params := service.NewDemoParams(nil, nil)
+		params.Pow = pw
 		params.MaxJobs = defaultMaxJobs
 		params.MaxTimePerJob = defaultMaxTime
 		params.MaxDifficulty = defaultMaxDifficulty
-		return service.NewDemo(params)
+		params.CacheSize = defaultCacheSize
+		params.QueueSize = defaultQueueSize
+		params.ProgressInterval = defaultProgress
+		d, err := service.NewDemo(params)
+		if err != nil {
+			return nil, err
+		}
+		if err := reload(d); err != nil {
+			return nil, err
+		}
+		svc = d
+		return d, nil
 	}); err != nil {
 		log.Error(err.Error())
 		return
@@ -87,8 +150,27 @@ func main() {
 		return
 	}
 	defer stack.Stop()
-	sigC := make(chan os.Signal)
-	signal.Notify(sigC, syscall.SIGINT)
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGHUP)
+	for sig := range sigC {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if err := reload(svc); err != nil {
+			log.Error("config reload fail", "err", err)
+		}
+	}
+}
 
-	<-sigC
+// applies the config file given with -c, if any
+func reload(svc *service.Demo) error {
+	if *conffile == "" {
+		return nil
+	}
+	cfg, err := service.ReadDemoConfig(*conffile)
+	if err != nil {
+		return err
+	}
+	_, err = svc.Reload(cfg)
+	return err
 }
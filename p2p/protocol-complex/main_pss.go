@@ -17,27 +17,47 @@ import (
 	swarmapi "github.com/ethereum/go-ethereum/swarm/api"
 
 	"./bzz"
+	"./peers"
+	"./protocol"
 	"./service"
+	"./service/pow"
 )
 
 const (
 	ipcName              = "pssdemo.ipc"
+	staticResolveTimeout = time.Second * 30
 	defaultMaxDifficulty = 23
 	defaultMaxJobs       = 3
 	defaultMaxTime       = time.Second
+	defaultCacheSize     = 1024
+	defaultQueueSize     = 8
+	defaultProgress      = time.Millisecond * 250
 )
 
 var (
 	loglevel = flag.Int("l", 3, "loglevel")
+	codec    = flag.String("codec", "rlp", "encoding of the protocol messages, rlp or protobuf; all nodes must use the same")
+	powName  = flag.String("pow", "sha1", "proof of work algorithm, sha1, sha3 or ethash-lite; all nodes must use the same")
 	port     = flag.Int("p", 30499, "p2p port")
 	bzzport  = flag.String("b", "8555", "bzz port")
 	enode    = flag.String("e", "", "enode to connect to")
 	httpapi  = flag.String("a", "localhost:8545", "http api")
+	nodekey  = flag.String("k", "", "node private key file")
+	static   = flag.String("s", "", "file with enodes to keep connected to, one per line")
+	conffile = flag.String("c", "", "json file with the config to apply at start, and again on SIGHUP (see service.DemoConfig)")
+	pw       pow.Pow // the proof of work algorithm chosen with -pow
 )
 
 func init() {
 	flag.Parse()
 	log.Root().SetHandler(log.CallerFileHandler(log.LvlFilterHandler(log.Lvl(*loglevel), (log.StreamHandler(os.Stderr, log.TerminalFormat(true))))))
+	if err := protocol.SetCodec(*codec); err != nil {
+		log.Crit("codec fail", "err", err)
+	}
+	var err error
+	if pw, err = pow.New(*powName); err != nil {
+		log.Crit("pow fail", "err", err)
+	}
 }
 
 func main() {
@@ -68,6 +88,34 @@ func main() {
 	}
 	cfg.DataDir = datadir
 
+	// when running in a cluster the node identity is handed to us, so the other nodes can know our enode in advance
+	if *nodekey != "" {
+		cfg.P2P.PrivateKey, err = crypto.LoadECDSA(*nodekey)
+		if err != nil {
+			log.Error("node key load fail", "err", err)
+			return
+		}
+	}
+
+	// peers may be given by name, and those names will resolve only when the peers are up
+	var staticurls []string
+	if *enode != "" {
+		staticurls = append(staticurls, *enode)
+	}
+	if *static != "" {
+		urls, err := peers.ReadStaticNodes(*static)
+		if err != nil {
+			log.Error("static nodes read fail", "err", err)
+			return
+		}
+		staticurls = append(staticurls, urls...)
+	}
+	cfg.P2P.StaticNodes, err = peers.ResolveStaticNodes(staticurls, staticResolveTimeout)
+	if err != nil {
+		log.Error("static nodes fail", "err", err)
+		return
+	}
+
 	stack, err := node.New(cfg)
 	if err != nil {
 		log.Error("node create fail", "err", err)
@@ -77,20 +125,32 @@ func main() {
 	// create the demo service, but now we don't register it directly
 	// so we avoid the protocol running on the direct connected peers
 	params := service.NewDemoParams(nil, nil)
+	params.Pow = pw
 	params.MaxJobs = defaultMaxJobs
 	params.MaxTimePerJob = defaultMaxTime
 	params.MaxDifficulty = defaultMaxDifficulty
+	params.CacheSize = defaultCacheSize
+	params.QueueSize = defaultQueueSize
+	params.ProgressInterval = defaultProgress
 	svc, err := service.NewDemo(params)
 	if err != nil {
 		log.Error(err.Error())
 		return
 	}
+	if err := reload(svc); err != nil {
+		log.Error("config fail", "err", err)
+		return
+	}
 
 	// create the pss service that wraps the demo protocol
-	privkey, err := crypto.GenerateKey()
-	if err != nil {
-		log.Error(err.Error())
-		return
+	// the swarm overlay address is derived from the node key if we were given one
+	privkey := cfg.P2P.PrivateKey
+	if privkey == nil {
+		privkey, err = crypto.GenerateKey()
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
 	}
 
 	bzzCfg := swarmapi.NewConfig()
@@ -123,7 +183,27 @@ func main() {
 		return
 	}
 	defer stack.Stop()
-	sigC := make(chan os.Signal)
-	signal.Notify(sigC, syscall.SIGINT)
-	<-sigC
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGHUP)
+	for sig := range sigC {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if err := reload(svc); err != nil {
+			log.Error("config reload fail", "err", err)
+		}
+	}
+}
+
+// applies the config file given with -c, if any
+func reload(svc *service.Demo) error {
+	if *conffile == "" {
+		return nil
+	}
+	cfg, err := service.ReadDemoConfig(*conffile)
+	if err != nil {
+		return err
+	}
+	_, err = svc.Reload(cfg)
+	return err
 }
@@ -0,0 +1,117 @@
+package peers
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+const (
+	resolveInterval = time.Second
+
+	// stands in for the host when checking an enode url before its name is resolved
+	placeholderIP = "127.0.0.1"
+)
+
+// ResolveEnode parses an enode url where the host part may be a hostname instead of an ip
+//
+// enode urls only accept ip addresses, but in a cluster (like kubernetes)
+// we only know the names of the other nodes up front; the addresses are assigned when they are scheduled
+func ResolveEnode(rawurl string) (*enode.Node, error) {
+	u, host, port, err := parseEnode(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) == nil {
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("no addresses for host %s", host)
+		}
+		u.Host = net.JoinHostPort(addrs[0], port)
+	}
+	return enode.ParseV4(u.String())
+}
+
+// checks the enode url, all but whether its host resolves, and splits out the host and port
+func parseEnode(rawurl string) (*url.URL, string, string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, "", "", err
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("invalid host: %v", err)
+	}
+	check := *u
+	check.Host = net.JoinHostPort(placeholderIP, port)
+	if _, err := enode.ParseV4(check.String()); err != nil {
+		return nil, "", "", err
+	}
+	return u, host, port, nil
+}
+
+// ReadStaticNodes reads enode urls from a file, one per line
+// empty lines and lines starting with # are skipped
+func ReadStaticNodes(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var urls []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// ResolveStaticNodes resolves the enode urls, retrying the ones whose names don't resolve until the timeout expires
+//
+// the other nodes may not exist yet when we start, and then their names don't resolve
+// the nodes that still can't be resolved when the timeout expires are left out
+// a malformed url won't get any better, so it's an error at once
+func ResolveStaticNodes(urls []string, timeout time.Duration) ([]*enode.Node, error) {
+	for _, u := range urls {
+		if _, _, _, err := parseEnode(u); err != nil {
+			return nil, fmt.Errorf("invalid enode %s: %v", u, err)
+		}
+	}
+	var nodes []*enode.Node
+	deadline := time.Now().Add(timeout)
+	for {
+		var pending []string
+		for _, u := range urls {
+			n, err := ResolveEnode(u)
+			if err != nil {
+				log.Debug("resolve enode fail", "enode", u, "err", err)
+				pending = append(pending, u)
+				continue
+			}
+			nodes = append(nodes, n)
+		}
+		urls = pending
+		if len(urls) == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(resolveInterval)
+	}
+	for _, u := range urls {
+		log.Warn("could not resolve static node", "enode", u)
+	}
+	return nodes, nil
+}
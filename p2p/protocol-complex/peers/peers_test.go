@@ -0,0 +1,44 @@
+package peers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestResolveStaticNodes(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := fmt.Sprintf("%x", crypto.FromECDSAPub(&key.PublicKey)[1:])
+	urls := []string{
+		"enode://" + id + "@127.0.0.1:30100",
+		"enode://" + id + "@localhost:30101",
+	}
+	nodes, err := ResolveStaticNodes(urls, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[1].TCP() != 30101 {
+		t.Fatalf("nodes %v", nodes)
+	}
+
+	// malformed urls fail at once, they aren't retried until the timeout
+	for _, u := range []string{
+		"enode://" + id[:100] + "@localhost:30100",
+		"enode://" + id + "@localhost",
+		"enode://" + id + "@localhost:port",
+		"%zz",
+	} {
+		start := time.Now()
+		if _, err := ResolveStaticNodes(append(urls, u), time.Minute); err == nil {
+			t.Fatalf("%s resolved", u)
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("%s retried", u)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Package profiling captures cpu and allocation profiles of the nodes of a simulation
+//
+// The nodes of the sim adapter all run in the one process, and a cpu profile is of the whole process.
+// The demo service labels its goroutines with the node and its role, see Labels, so the samples of
+// the profile can be told apart. A Capture profiles the process while it runs, and when stopped splits
+// the cpu profile into one for each node and one for each role, named after the labels:
+//
+//	cpu.pprof                  the whole process
+//	cpu-worker.pprof           all the workers
+//	cpu-worker-node_3a1f.pprof the one worker
+//	allocs.pprof               the allocations of the whole process, which can't be labelled
+//
+// Each can be looked at with go tool pprof, as any other profile.
+package profiling
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// the labels of the goroutines of a node
+const (
+	LabelNode = "node"
+	LabelRole = "role"
+)
+
+// Labels is the profiler labels of the goroutines of a node, for service.DemoParams.ProfileLabels
+func Labels(node, role string) map[string]string {
+	return map[string]string{
+		LabelNode: node,
+		LabelRole: role,
+	}
+}
+
+// Capture is a cpu profile being taken
+type Capture struct {
+	dir string
+	buf bytes.Buffer
+}
+
+// Start starts the cpu profile, the files are written to dir when it is stopped
+// only one can run at a time in a process
+func Start(dir string) (*Capture, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Capture{dir: dir}
+	if err := pprof.StartCPUProfile(&c.buf); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Stop stops the cpu profile, writes it, split by node and role, and the allocation profile,
+// and returns the names of the files written
+func (c *Capture) Stop() ([]string, error) {
+	pprof.StopCPUProfile()
+	cpu := filepath.Join(c.dir, "cpu.pprof")
+	if err := ioutil.WriteFile(cpu, c.buf.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	files := []string{cpu}
+
+	p, err := profile.Parse(bytes.NewReader(c.buf.Bytes()))
+	if err != nil {
+		return files, fmt.Errorf("parse cpu profile: %v", err)
+	}
+	split, err := Split(p, c.dir, "cpu")
+	files = append(files, split...)
+	if err != nil {
+		return files, err
+	}
+
+	allocs := filepath.Join(c.dir, "allocs.pprof")
+	var buf bytes.Buffer
+	if err := pprof.Lookup("allocs").WriteTo(&buf, 0); err != nil {
+		return files, err
+	}
+	if err := ioutil.WriteFile(allocs, buf.Bytes(), 0644); err != nil {
+		return files, err
+	}
+	return append(files, allocs), nil
+}
+
+// Split writes the samples of each role to prefix-role.pprof, and of each node to prefix-role-node.pprof, in dir
+// the samples of the goroutines not labelled with a role, of the simulation itself, are left out
+func Split(p *profile.Profile, dir string, prefix string) ([]string, error) {
+	roles := make(map[string]bool)
+	nodes := make(map[string]map[string]bool) // of each role
+	for _, s := range p.Sample {
+		role, node := label(s, LabelRole), label(s, LabelNode)
+		if role == "" {
+			continue
+		}
+		if !roles[role] {
+			roles[role] = true
+			nodes[role] = make(map[string]bool)
+		}
+		if node != "" {
+			nodes[role][node] = true
+		}
+	}
+	if len(roles) == 0 {
+		return nil, errors.New("no labelled samples in the profile")
+	}
+
+	var files []string
+	for _, role := range sorted(roles) {
+		file := filepath.Join(dir, fmt.Sprintf("%s-%s.pprof", prefix, safe(role)))
+		if err := writeFiltered(p, file, LabelRole, role); err != nil {
+			return files, err
+		}
+		files = append(files, file)
+		for _, node := range sorted(nodes[role]) {
+			file := filepath.Join(dir, fmt.Sprintf("%s-%s-%s.pprof", prefix, safe(role), safe(node)))
+			if err := writeFiltered(p, file, LabelNode, node); err != nil {
+				return files, err
+			}
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// writes a copy of the profile with only the samples labelled with the key and value
+func writeFiltered(p *profile.Profile, file string, key, value string) error {
+	q := p.Copy()
+	q.FilterSamplesByTag(func(s *profile.Sample) bool {
+		return label(s, key) == value
+	}, nil)
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return q.Write(f)
+}
+
+func label(s *profile.Sample, key string) string {
+	if values := s.Label[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func sorted(set map[string]bool) []string {
+	var keys []string
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// the label as part of a file name
+func safe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator || r == ' ' {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,103 @@
+package profiling
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// hashes until stopped, on a goroutine with the labels
+func burn(labels map[string]string, stop chan struct{}, wg *sync.WaitGroup) {
+	var args []string
+	for k, v := range labels {
+		args = append(args, k, v)
+	}
+	wg.Add(1)
+	go pprof.Do(context.Background(), pprof.Labels(args...), func(context.Context) {
+		defer wg.Done()
+		sum := sha256.Sum256(nil)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			sum = sha256.Sum256(sum[:])
+		}
+	})
+}
+
+func TestCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Start(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	burn(Labels("w1", "worker"), stop, &wg)
+	burn(Labels("s1", "submitter"), stop, &wg)
+	burn(Labels("s2", "submitter"), stop, &wg)
+	time.Sleep(time.Millisecond * 500)
+	close(stop)
+	wg.Wait()
+
+	files, err := c.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cpu.pprof", "cpu-submitter.pprof", "cpu-submitter-s1.pprof", "cpu-submitter-s2.pprof", "cpu-worker.pprof", "cpu-worker-w1.pprof", "allocs.pprof"}
+	if len(files) != len(want) {
+		t.Fatalf("files %v, want %v", files, want)
+	}
+	for i, name := range want {
+		if files[i] != filepath.Join(dir, name) {
+			t.Fatalf("file %d is %s, want %s", i, files[i], name)
+		}
+	}
+
+	// a node's profile has its samples only
+	f, err := os.Open(filepath.Join(dir, "cpu-submitter-s1.pprof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Sample) == 0 {
+		t.Fatal("no samples of s1")
+	}
+	for _, s := range p.Sample {
+		if label(s, LabelNode) != "s1" {
+			t.Fatalf("sample of %v in the profile of s1", s.Label)
+		}
+	}
+}
+
+func TestSplitUnlabelled(t *testing.T) {
+	p := &profile.Profile{Sample: []*profile.Sample{{Value: []int64{1}}}}
+	if _, err := Split(p, os.TempDir(), "cpu"); err == nil {
+		t.Fatal("profile without labels split")
+	}
+}
+
+func TestSafe(t *testing.T) {
+	if s := safe("node 1/a"); s != "node_1_a" {
+		t.Fatalf("safe name %s", s)
+	}
+}
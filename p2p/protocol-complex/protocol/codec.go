@@ -0,0 +1,404 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/protobuf/proto"
+
+	"../capability"
+)
+
+// the encodings of the protocol messages
+const (
+	CodecRLP = iota
+	CodecProtobuf
+)
+
+// the protobuf wire types we use
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var (
+	codec int32 = CodecRLP
+
+	errIDLength = errors.New("id must be 8 bytes")
+)
+
+// SetCodec chooses the encoding of the messages, "rlp" or "protobuf"
+//
+// with protobuf, the messages are sent as their protobuf encoding (see demo.proto) in an rlp string
+// the devp2p and pss framing stays the same, only the message content changes
+// the encoding isn't negotiated, so all nodes must use the same
+func SetCodec(name string) error {
+	switch name {
+	case "rlp":
+		atomic.StoreInt32(&codec, CodecRLP)
+	case "protobuf":
+		atomic.StoreInt32(&codec, CodecProtobuf)
+	default:
+		return fmt.Errorf("unknown codec '%s'", name)
+	}
+	return nil
+}
+
+// a message with a protobuf encoding
+type protoMessage interface {
+	marshalProto() []byte
+	unmarshalProto(b []byte) error
+}
+
+// plain is the message converted to a type without EncodeRLP, to get the default rlp encoding
+func encodeFrame(w io.Writer, m protoMessage, plain interface{}) error {
+	if atomic.LoadInt32(&codec) == CodecRLP {
+		return rlp.Encode(w, plain)
+	}
+	return rlp.Encode(w, m.marshalProto())
+}
+
+func decodeFrame(s *rlp.Stream, m protoMessage, plain interface{}) error {
+	if atomic.LoadInt32(&codec) == CodecRLP {
+		return s.Decode(plain)
+	}
+	b, err := s.Bytes()
+	if err != nil {
+		return err
+	}
+	return m.unmarshalProto(b)
+}
+
+func protoKey(buf *proto.Buffer, num int, wire int) {
+	buf.EncodeVarint(uint64(num)<<3 | uint64(wire))
+}
+
+// proto3 leaves out fields with default values
+func protoUint(buf *proto.Buffer, num int, v uint64) {
+	if v != 0 {
+		protoKey(buf, num, wireVarint)
+		buf.EncodeVarint(v)
+	}
+}
+
+func protoBytes(buf *proto.Buffer, num int, v []byte) {
+	if len(v) > 0 {
+		protoKey(buf, num, wireBytes)
+		buf.EncodeRawBytes(v)
+	}
+}
+
+// calls f with the number of each field and a buffer positioned at its value
+// f reads the value of the fields it knows and returns false for the others, which are then skipped
+// this is what lets older nodes accept messages with fields added later
+func protoFields(b []byte, f func(num int, wire int, buf *proto.Buffer) (bool, error)) error {
+	buf := proto.NewBuffer(b)
+	for len(buf.Unread()) > 0 {
+		key, err := buf.DecodeVarint()
+		if err != nil {
+			return err
+		}
+		num, wire := int(key>>3), int(key&7)
+		known, err := f(num, wire, buf)
+		if err != nil {
+			return fmt.Errorf("field %d: %v", num, err)
+		}
+		if known {
+			continue
+		}
+		switch wire {
+		case wireVarint:
+			_, err = buf.DecodeVarint()
+		case wireBytes:
+			_, err = buf.DecodeRawBytes(false)
+		case wireFixed64:
+			_, err = buf.DecodeFixed64()
+		case wireFixed32:
+			_, err = buf.DecodeFixed32()
+		default:
+			err = fmt.Errorf("unsupported wire type %d", wire)
+		}
+		if err != nil {
+			return fmt.Errorf("field %d: %v", num, err)
+		}
+	}
+	return nil
+}
+
+func decodeID(buf *proto.Buffer, id *ID) error {
+	b, err := buf.DecodeRawBytes(false)
+	if err != nil {
+		return err
+	}
+	if len(b) != len(id) {
+		return errIDLength
+	}
+	copy(id[:], b)
+	return nil
+}
+
+func decodeBytes(buf *proto.Buffer) ([]byte, error) {
+	return buf.DecodeRawBytes(true)
+}
+
+// the message types converted to, for the default rlp encoding
+type (
+	skillsRLP   Skills
+	statusRLP   Status
+	requestRLP  Request
+	resultRLP   Result
+	cancelRLP   Cancel
+	progressRLP Progress
+)
+
+func (m *Skills) EncodeRLP(w io.Writer) error {
+	return encodeFrame(w, m, (*skillsRLP)(m))
+}
+
+func (m *Skills) DecodeRLP(s *rlp.Stream) error {
+	return decodeFrame(s, m, (*skillsRLP)(m))
+}
+
+func (m *Skills) marshalProto() []byte {
+	buf := proto.NewBuffer(nil)
+	protoUint(buf, 1, uint64(m.Difficulty))
+	protoUint(buf, 2, uint64(m.MaxSize))
+	protoUint(buf, 3, uint64(m.MinDifficulty))
+	protoUint(buf, 4, uint64(m.Capacity))
+	protoUint(buf, 5, uint64(m.Version))
+	protoBytes(buf, 6, marshalCaps(m.Caps))
+	return buf.Bytes()
+}
+
+func (m *Skills) unmarshalProto(b []byte) error {
+	*m = Skills{}
+	return protoFields(b, func(num int, wire int, buf *proto.Buffer) (bool, error) {
+		var v uint64
+		var err error
+		switch {
+		case num == 1 && wire == wireVarint:
+			v, err = buf.DecodeVarint()
+			m.Difficulty = uint8(v)
+		case num == 2 && wire == wireVarint:
+			v, err = buf.DecodeVarint()
+			m.MaxSize = uint16(v)
+		case num == 3 && wire == wireVarint:
+			v, err = buf.DecodeVarint()
+			m.MinDifficulty = uint8(v)
+		case num == 4 && wire == wireVarint:
+			v, err = buf.DecodeVarint()
+			m.Capacity = uint16(v)
+		case num == 5 && wire == wireVarint:
+			v, err = buf.DecodeVarint()
+			m.Version = uint32(v)
+		case num == 6 && wire == wireBytes:
+			var b []byte
+			b, err = buf.DecodeRawBytes(false)
+			if err == nil {
+				m.Caps, err = unmarshalCaps(b)
+			}
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func marshalCaps(c capability.Caps) []byte {
+	buf := proto.NewBuffer(nil)
+	protoUint(buf, 1, uint64(c.Version))
+	protoUint(buf, 2, c.Features)
+	return buf.Bytes()
+}
+
+func unmarshalCaps(b []byte) (c capability.Caps, err error) {
+	err = protoFields(b, func(num int, wire int, buf *proto.Buffer) (bool, error) {
+		var v uint64
+		var err error
+		switch {
+		case num == 1 && wire == wireVarint:
+			v, err = buf.DecodeVarint()
+			c.Version = uint32(v)
+		case num == 2 && wire == wireVarint:
+			c.Features, err = buf.DecodeVarint()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	return c, err
+}
+
+func (m *Status) EncodeRLP(w io.Writer) error {
+	return encodeFrame(w, m, (*statusRLP)(m))
+}
+
+func (m *Status) DecodeRLP(s *rlp.Stream) error {
+	return decodeFrame(s, m, (*statusRLP)(m))
+}
+
+func (m *Status) marshalProto() []byte {
+	buf := proto.NewBuffer(nil)
+	protoBytes(buf, 1, m.Id[:])
+	protoUint(buf, 2, uint64(m.Code))
+	return buf.Bytes()
+}
+
+func (m *Status) unmarshalProto(b []byte) error {
+	*m = Status{}
+	return protoFields(b, func(num int, wire int, buf *proto.Buffer) (bool, error) {
+		switch {
+		case num == 1 && wire == wireBytes:
+			return true, decodeID(buf, &m.Id)
+		case num == 2 && wire == wireVarint:
+			v, err := buf.DecodeVarint()
+			m.Code = uint8(v)
+			return true, err
+		}
+		return false, nil
+	})
+}
+
+func (m *Request) EncodeRLP(w io.Writer) error {
+	return encodeFrame(w, m, (*requestRLP)(m))
+}
+
+func (m *Request) DecodeRLP(s *rlp.Stream) error {
+	return decodeFrame(s, m, (*requestRLP)(m))
+}
+
+func (m *Request) marshalProto() []byte {
+	buf := proto.NewBuffer(nil)
+	protoBytes(buf, 1, m.Id[:])
+	protoBytes(buf, 2, m.Data)
+	protoUint(buf, 3, uint64(m.Difficulty))
+	protoUint(buf, 4, uint64(m.Timeout))
+	return buf.Bytes()
+}
+
+func (m *Request) unmarshalProto(b []byte) error {
+	*m = Request{}
+	return protoFields(b, func(num int, wire int, buf *proto.Buffer) (bool, error) {
+		var err error
+		switch {
+		case num == 1 && wire == wireBytes:
+			err = decodeID(buf, &m.Id)
+		case num == 2 && wire == wireBytes:
+			m.Data, err = decodeBytes(buf)
+		case num == 3 && wire == wireVarint:
+			var v uint64
+			v, err = buf.DecodeVarint()
+			m.Difficulty = uint8(v)
+		case num == 4 && wire == wireVarint:
+			var v uint64
+			v, err = buf.DecodeVarint()
+			m.Timeout = uint32(v)
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (m *Result) EncodeRLP(w io.Writer) error {
+	return encodeFrame(w, m, (*resultRLP)(m))
+}
+
+func (m *Result) DecodeRLP(s *rlp.Stream) error {
+	return decodeFrame(s, m, (*resultRLP)(m))
+}
+
+func (m *Result) marshalProto() []byte {
+	buf := proto.NewBuffer(nil)
+	protoBytes(buf, 1, m.Id[:])
+	protoBytes(buf, 2, m.Nonce)
+	protoBytes(buf, 3, m.Hash)
+	return buf.Bytes()
+}
+
+func (m *Result) unmarshalProto(b []byte) error {
+	*m = Result{}
+	return protoFields(b, func(num int, wire int, buf *proto.Buffer) (bool, error) {
+		var err error
+		switch {
+		case num == 1 && wire == wireBytes:
+			err = decodeID(buf, &m.Id)
+		case num == 2 && wire == wireBytes:
+			m.Nonce, err = decodeBytes(buf)
+		case num == 3 && wire == wireBytes:
+			m.Hash, err = decodeBytes(buf)
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (m *Cancel) EncodeRLP(w io.Writer) error {
+	return encodeFrame(w, m, (*cancelRLP)(m))
+}
+
+func (m *Cancel) DecodeRLP(s *rlp.Stream) error {
+	return decodeFrame(s, m, (*cancelRLP)(m))
+}
+
+func (m *Cancel) marshalProto() []byte {
+	buf := proto.NewBuffer(nil)
+	protoBytes(buf, 1, m.Id[:])
+	return buf.Bytes()
+}
+
+func (m *Cancel) unmarshalProto(b []byte) error {
+	*m = Cancel{}
+	return protoFields(b, func(num int, wire int, buf *proto.Buffer) (bool, error) {
+		if num == 1 && wire == wireBytes {
+			return true, decodeID(buf, &m.Id)
+		}
+		return false, nil
+	})
+}
+
+func (m *Progress) EncodeRLP(w io.Writer) error {
+	return encodeFrame(w, m, (*progressRLP)(m))
+}
+
+func (m *Progress) DecodeRLP(s *rlp.Stream) error {
+	return decodeFrame(s, m, (*progressRLP)(m))
+}
+
+func (m *Progress) marshalProto() []byte {
+	buf := proto.NewBuffer(nil)
+	protoBytes(buf, 1, m.Id[:])
+	protoUint(buf, 2, m.Hashes)
+	protoUint(buf, 3, uint64(m.Elapsed))
+	protoUint(buf, 4, uint64(m.Eta))
+	return buf.Bytes()
+}
+
+func (m *Progress) unmarshalProto(b []byte) error {
+	*m = Progress{}
+	return protoFields(b, func(num int, wire int, buf *proto.Buffer) (bool, error) {
+		var v uint64
+		var err error
+		switch {
+		case num == 1 && wire == wireBytes:
+			err = decodeID(buf, &m.Id)
+		case num == 2 && wire == wireVarint:
+			m.Hashes, err = buf.DecodeVarint()
+		case num == 3 && wire == wireVarint:
+			v, err = buf.DecodeVarint()
+			m.Elapsed = uint32(v)
+		case num == 4 && wire == wireVarint:
+			v, err = buf.DecodeVarint()
+			m.Eta = uint32(v)
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
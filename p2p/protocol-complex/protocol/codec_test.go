@@ -0,0 +1,129 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var testMessages = []interface{}{
+	&Skills{Difficulty: 23, MaxSize: 1024, MinDifficulty: 8, Capacity: 3, Version: 300, Caps: Caps},
+	&Status{Id: ID{1, 2, 3, 4, 5, 6, 7, 8}, Code: StatusBusy},
+	&Request{Id: ID{8, 7, 6, 5, 4, 3, 2, 1}, Data: []byte("the quick brown fox jumps over the lazy dog"), Difficulty: 16, Timeout: 2500},
+	&Result{Id: ID{1, 1, 2, 3, 5, 8, 13, 21}, Nonce: []byte{0, 0, 0, 0, 0, 1, 0x2a, 0xff}, Hash: make([]byte, 20)},
+	&Cancel{Id: ID{2, 4, 6, 8}},
+	&Progress{Id: ID{3, 1, 4, 1, 5, 9, 2, 6}, Hashes: 1 << 40, Elapsed: 12000, Eta: 3500},
+}
+
+func withCodec(t testing.TB, name string) func() {
+	err := SetCodec(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		SetCodec("rlp")
+	}
+}
+
+func TestCodecRoundtrip(t *testing.T) {
+	for _, name := range []string{"rlp", "protobuf"} {
+		reset := withCodec(t, name)
+		for _, in := range testMessages {
+			b, err := rlp.EncodeToBytes(in)
+			if err != nil {
+				t.Fatalf("%s %T: %v", name, in, err)
+			}
+			out := reflect.New(reflect.TypeOf(in).Elem()).Interface()
+			err = rlp.DecodeBytes(b, out)
+			if err != nil {
+				t.Fatalf("%s %T: %v", name, in, err)
+			}
+			if !reflect.DeepEqual(in, out) {
+				t.Fatalf("%s: got %v, want %v", name, out, in)
+			}
+		}
+		reset()
+	}
+}
+
+// the rlp encoding must be what it was before the messages had their own EncodeRLP
+func TestCodecPlainRLP(t *testing.T) {
+	in := &Request{Id: ID{1}, Data: []byte{2}, Difficulty: 3, Timeout: 4}
+	b, err := rlp.EncodeToBytes(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := rlp.EncodeToBytes([]interface{}{in.Id, in.Data, in.Difficulty, in.Timeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(b, want) {
+		t.Fatalf("got %x, want %x", b, want)
+	}
+}
+
+// a newer version of the message with a field we don't know
+func TestCodecUnknownField(t *testing.T) {
+	in := &Skills{Difficulty: 5, MaxSize: 300}
+	b := in.marshalProto()
+	b = append(b, 7<<3|wireBytes, 3, 'f', 'o', 'o', 8<<3|wireVarint, 0x96, 0x01)
+	var out Skills
+	err := out.unmarshalProto(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != *in {
+		t.Fatalf("got %v, want %v", out, *in)
+	}
+}
+
+func TestCodecBadID(t *testing.T) {
+	b := []byte{1<<3 | wireBytes, 2, 1, 2}
+	var out Status
+	if err := out.unmarshalProto(b); err == nil {
+		t.Fatal("accepted short id")
+	}
+}
+
+func benchmarkEncode(b *testing.B, name string) {
+	defer withCodec(b, name)()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, m := range testMessages {
+			if _, err := rlp.EncodeToBytes(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func benchmarkDecode(b *testing.B, name string) {
+	defer withCodec(b, name)()
+	var encoded [][]byte
+	size := 0
+	for _, m := range testMessages {
+		e, err := rlp.EncodeToBytes(m)
+		if err != nil {
+			b.Fatal(err)
+		}
+		encoded = append(encoded, e)
+		size += len(e)
+	}
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j, m := range testMessages {
+			out := reflect.New(reflect.TypeOf(m).Elem()).Interface()
+			if err := rlp.DecodeBytes(encoded[j], out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkEncodeRLP(b *testing.B)      { benchmarkEncode(b, "rlp") }
+func BenchmarkEncodeProtobuf(b *testing.B) { benchmarkEncode(b, "protobuf") }
+func BenchmarkDecodeRLP(b *testing.B)      { benchmarkDecode(b, "rlp") }
+func BenchmarkDecodeProtobuf(b *testing.B) { benchmarkDecode(b, "protobuf") }
@@ -11,10 +11,12 @@ import (
 // this is because devp2p doesn't let us know about which peer is the sender
 type DemoPeer struct {
 	*protocols.Peer
-	skillsHandler  func(*Skills, *protocols.Peer) error
-	statusHandler  func(*Status, *protocols.Peer) error
-	requestHandler func(*Request, *protocols.Peer) error
-	resultHandler  func(*Result, *protocols.Peer) error
+	skillsHandler   func(*Skills, *protocols.Peer) error
+	statusHandler   func(*Status, *protocols.Peer) error
+	requestHandler  func(*Request, *protocols.Peer) error
+	resultHandler   func(*Result, *protocols.Peer) error
+	cancelHandler   func(*Cancel, *protocols.Peer) error
+	progressHandler func(*Progress, *protocols.Peer) error
 }
 
 // Dispatcher for incoming messages
@@ -31,5 +33,11 @@ func (self *DemoPeer) Handle(ctx context.Context, msg interface{}) error {
 	if typ, ok := msg.(*Result); ok {
 		return self.resultHandler(typ, self.Peer)
 	}
+	if typ, ok := msg.(*Cancel); ok {
+		return self.cancelHandler(typ, self.Peer)
+	}
+	if typ, ok := msg.(*Progress); ok {
+		return self.progressHandler(typ, self.Peer)
+	}
 	return errors.New("unknown message type")
 }
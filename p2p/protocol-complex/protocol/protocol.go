@@ -3,9 +3,12 @@ package protocol
 import (
 	"errors"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/protocols"
+
+	"../capability"
 )
 
 // enumeration used in status messages
@@ -14,6 +17,8 @@ const (
 	StatusBusy
 	StatusAreYouKidding
 	StatusGaveup
+	StatusCached  // the result that follows comes from the cache, no work was done for it
+	StatusExpired // the job wasn't done within the timeout of the request
 )
 
 // which hashes a hasher node offers
@@ -22,26 +27,115 @@ const (
 )
 
 // variables shared between p2p.Protocol and protocols.Spec
+//
+// the version stays as it is from now on, messages are added behind a feature
+// the message codes up to protoLength are taken, so the codes of the protocols after this one don't move when messages are added
 const (
 	protoName    = "demo"
-	protoVersion = 1
+	protoVersion = 5
 	protoMax     = 2048
+	protoLength  = 16
+)
+
+// the optional features of the protocol, announced in Skills
+// a feature keeps its number for good, a new one takes the next
+const (
+	FeatureCancel   capability.Feature = iota // takes Cancel messages
+	FeatureProgress                           // takes Progress messages
+	FeatureCached                             // takes the StatusCached before a Result from the cache
+	FeatureExpiry                             // heeds the Timeout of requests, and takes StatusExpired
+)
+
+// the version of the messages, within the protocol version
+const capsVersion = 1
+
+var (
+	// Caps is what this implementation of the protocol announces
+	Caps = capability.New(capsVersion, FeatureCancel, FeatureProgress, FeatureCached, FeatureExpiry)
+
+	FeatureNames = capability.Names{
+		FeatureCancel:   "cancel",
+		FeatureProgress: "progress",
+		FeatureCached:   "cached",
+		FeatureExpiry:   "expiry",
+	}
 )
 
+// PeerCaps is what we negotiated with a peer, with an accessor for every feature
+//
+// a peer that hasn't announced its skills yet has none of the features
+type PeerCaps capability.Caps
+
+// NegotiateCaps returns what we can use with a peer that announced the caps
+func NegotiateCaps(ours capability.Caps, theirs capability.Caps) PeerCaps {
+	return PeerCaps(capability.Negotiate(ours, theirs))
+}
+
+// Cancel tells if the peer may be sent Cancel messages
+func (self PeerCaps) Cancel() bool {
+	return capability.Caps(self).Has(FeatureCancel)
+}
+
+// Progress tells if the peer may be sent Progress messages
+func (self PeerCaps) Progress() bool {
+	return capability.Caps(self).Has(FeatureProgress)
+}
+
+// Cached tells if the peer may be sent StatusCached
+func (self PeerCaps) Cached() bool {
+	return capability.Caps(self).Has(FeatureCached)
+}
+
+// Expiry tells if the peer heeds request timeouts and may be sent StatusExpired
+func (self PeerCaps) Expiry() bool {
+	return capability.Caps(self).Has(FeatureExpiry)
+}
+
+func (self PeerCaps) String() string {
+	return FeatureNames.Format(capability.Caps(self))
+}
+
 type ID [8]byte
 
+// MarshalText encodes the id as 0x prefixed hex, for the json apis
+func (self ID) MarshalText() ([]byte, error) {
+	return hexutil.Bytes(self[:]).MarshalText()
+}
+
+func (self *ID) UnmarshalText(input []byte) error {
+	return hexutil.UnmarshalFixedText("ID", input, self[:])
+}
+
 // Skills is a protocol message type
 //
 // It is an asynchronous handshake message, signaling the state the node is in.
-// The message may be issued at any time, and receiving peers should behave accordingly
-// towards the node:
+// A node sends it when it connects to a peer, and again to all its peers whenever its skills change.
+// Receiving peers should behave accordingly towards the node:
 //
 // Difficulty > 0 means it's open for hashing, and what the max difficulty is.
 //
-// MaxSize tells how many bytes can accompany one data submission
+// MaxSize tells how many bytes can accompany one data submission.
+//
+// MinDifficulty is the lowest difficulty it will take jobs for.
+//
+// Capacity is how many jobs it will work on at the same time. Requests beyond that are answered with StatusBusy
+//
+// Caps is the version of the messages the node speaks and the features it has, see PeerCaps.
+// A node only sends a peer the messages of the features both of them have
+//
+// Version increases every time the node's skills change, so an announcement that arrives late can be told apart
 type Skills struct {
-	Difficulty uint8
-	MaxSize    uint16
+	Difficulty    uint8
+	MaxSize       uint16
+	MinDifficulty uint8
+	Capacity      uint16
+	Version       uint32
+	Caps          capability.Caps
+}
+
+// Covers tells if the node announcing the skills takes jobs of the given difficulty
+func (self *Skills) Covers(difficulty uint8) bool {
+	return self.Difficulty > 0 && difficulty >= self.MinDifficulty && difficulty <= self.Difficulty
 }
 
 // Status is a protocol message type
@@ -56,11 +150,36 @@ type Status struct {
 
 // Request is a protocol message type
 //
-// It is used by nodes to request a hashing job
+// It is used by nodes to request a hashing job.
+//
+// Timeout is how many milliseconds after receiving the request the worker may spend on it, 0 for as long as it likes.
+// The worker gives up on the job when the timeout passes, and answers with StatusExpired
 type Request struct {
 	Id         ID
 	Data       []byte
 	Difficulty uint8
+	Timeout    uint32
+}
+
+// Cancel is a protocol message type
+//
+// It is used by nodes to tell a worker they no longer want the result of a job they requested.
+// The worker stops working on it, and doesn't answer
+type Cancel struct {
+	Id ID
+}
+
+// Progress is a protocol message type
+//
+// It is used by workers to tell how far along they are with a long job, every so often until they're done.
+//
+// Hashes is how many hashes were tried, Elapsed the milliseconds since the work started,
+// and Eta the milliseconds the rest of the work is expected to take at the current hash rate, 0 if any moment now
+type Progress struct {
+	Id      ID
+	Hashes  uint64
+	Elapsed uint32
+	Eta     uint32
 }
 
 // Result is a protocol message type
@@ -73,11 +192,14 @@ type Result struct {
 }
 
 var (
+	// new messages go at the end, behind a feature
 	Messages = []interface{}{
 		&Skills{},
 		&Status{},
 		&Request{},
 		&Result{},
+		&Cancel{},
+		&Progress{},
 	}
 
 	Spec = &protocols.Spec{
@@ -93,13 +215,17 @@ var (
 // This implementation holds a callback function thats called upon a successful connection
 // Any logic needed to be performed in the context of the protocol's service should be put there
 type DemoProtocol struct {
-	Protocol       p2p.Protocol
-	SkillsHandler  func(*Skills, *protocols.Peer) error
-	StatusHandler  func(*Status, *protocols.Peer) error
-	RequestHandler func(*Request, *protocols.Peer) error
-	ResultHandler  func(*Result, *protocols.Peer) error
-	handler        func(interface{}) error
-	runHook        func(*protocols.Peer) error
+	Protocol        p2p.Protocol
+	SkillsHandler   func(*Skills, *protocols.Peer) error
+	StatusHandler   func(*Status, *protocols.Peer) error
+	RequestHandler  func(*Request, *protocols.Peer) error
+	ResultHandler   func(*Result, *protocols.Peer) error
+	CancelHandler   func(*Cancel, *protocols.Peer) error
+	ProgressHandler func(*Progress, *protocols.Peer) error
+	// called when the peer is gone, if set
+	DropHook func(*protocols.Peer)
+	handler  func(interface{}) error
+	runHook  func(*protocols.Peer) error
 }
 
 func NewDemoProtocol(runHook func(*protocols.Peer) error) (*DemoProtocol, error) {
@@ -107,7 +233,7 @@ func NewDemoProtocol(runHook func(*protocols.Peer) error) (*DemoProtocol, error)
 		Protocol: p2p.Protocol{
 			Name:    protoName,
 			Version: protoVersion,
-			Length:  4,
+			Length:  protoLength,
 		},
 		runHook: runHook,
 	}
@@ -129,6 +255,12 @@ func (self *DemoProtocol) Init() error {
 	if self.ResultHandler == nil {
 		return errors.New("missing response handler")
 	}
+	if self.CancelHandler == nil {
+		return errors.New("missing cancel handler")
+	}
+	if self.ProgressHandler == nil {
+		return errors.New("missing progress handler")
+	}
 	self.Protocol.Run = self.Run
 	return nil
 }
@@ -139,13 +271,22 @@ func (self *DemoProtocol) Init() error {
 func (self *DemoProtocol) Run(p *p2p.Peer, rw p2p.MsgReadWriter) error {
 	pp := protocols.NewPeer(p, rw, Spec)
 	log.Info("running demo protocol on peer", "peer", pp, "self", self)
-	go self.runHook(pp)
+	// the hook must not block, it is run before the messages so the peer is known when they come
+	if err := self.runHook(pp); err != nil {
+		return err
+	}
 	dp := &DemoPeer{
-		Peer:           pp,
-		skillsHandler:  self.SkillsHandler,
-		statusHandler:  self.StatusHandler,
-		requestHandler: self.RequestHandler,
-		resultHandler:  self.ResultHandler,
+		Peer:            pp,
+		skillsHandler:   self.SkillsHandler,
+		statusHandler:   self.StatusHandler,
+		requestHandler:  self.RequestHandler,
+		resultHandler:   self.ResultHandler,
+		cancelHandler:   self.CancelHandler,
+		progressHandler: self.ProgressHandler,
+	}
+	err := pp.Run(dp.Handle)
+	if self.DropHook != nil {
+		self.DropHook(pp)
 	}
-	return pp.Run(dp.Handle)
+	return err
 }
@@ -1,6 +1,13 @@
 package service
 
 import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+
 	"../protocol"
 )
 
@@ -15,7 +22,69 @@ func newDemoAPI(s *Demo) *DemoAPI {
 }
 
 func (self *DemoAPI) Submit(data []byte, difficulty uint8) (protocol.ID, error) {
-	return self.service.submitRequest(data, difficulty)
+	return self.service.submitRequest(data, difficulty, 0)
+}
+
+// SubmitJob sends a job to one of the workers, and returns its status to poll with JobStatus
+// the optional timeout is how many milliseconds the worker may spend on it
+func (self *DemoAPI) SubmitJob(data hexutil.Bytes, difficulty uint8, timeout *uint32) (*JobInfo, error) {
+	var d time.Duration
+	if timeout != nil {
+		d = time.Duration(*timeout) * time.Millisecond
+	}
+	id, err := self.service.submitRequest(data, difficulty, d)
+	if err != nil {
+		return nil, err
+	}
+	return self.JobStatus(id)
+}
+
+// JobStatus returns what we know about a job we submitted
+func (self *DemoAPI) JobStatus(id protocol.ID) (*JobInfo, error) {
+	info := self.service.submits.GetInfo(id)
+	if info == nil {
+		return nil, fmt.Errorf("unknown job %x", id)
+	}
+	return info, nil
+}
+
+// CancelJob tells the worker of a pending job we no longer want its result
+func (self *DemoAPI) CancelJob(id protocol.ID) error {
+	return self.service.cancelJob(id)
+}
+
+// Progress subscribes to the progress reports of the workers on the jobs we submitted
+func (self *DemoAPI) Progress(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+	progressC := make(chan JobProgress, 16)
+	feedSub := self.service.progress.Subscribe(progressC)
+	go func() {
+		defer feedSub.Unsubscribe()
+		for {
+			select {
+			case progress := <-progressC:
+				notifier.Notify(sub.ID, progress)
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// ListJobs returns the jobs we submitted that match the filter, oldest first
+// only the latest jobs are remembered
+func (self *DemoAPI) ListJobs(filter *JobFilter) []*JobInfo {
+	if filter == nil {
+		filter = &JobFilter{}
+	}
+	return self.service.submits.List(filter)
 }
 
 func (self *DemoAPI) Stop() error {
@@ -23,9 +92,55 @@ func (self *DemoAPI) Stop() error {
 	return nil
 }
 
-func (self *DemoAPI) SetDifficulty(d uint8) error {
-	self.service.mu.Lock()
-	defer self.service.mu.Unlock()
-	self.service.maxDifficulty = d
+// SetDifficulty changes the difficulties the node takes jobs for, and announces it to the peers
+func (self *DemoAPI) SetDifficulty(min uint8, max uint8) error {
+	if max > 0 && min > max {
+		return fmt.Errorf("min difficulty %d above max %d", min, max)
+	}
+	self.service.setDifficulty(min, max)
 	return nil
 }
+
+// Config returns the values of the config the node runs with now
+func (self *DemoAPI) Config() *DemoConfig {
+	return self.service.Config()
+}
+
+// Reload changes the values given in the config while the node runs, and returns the names of those that changed
+func (self *DemoAPI) Reload(cfg DemoConfig) ([]string, error) {
+	return self.service.Reload(&cfg)
+}
+
+// CacheStats returns the size and hit rate of the job result cache
+func (self *DemoAPI) CacheStats() CacheStats {
+	return self.service.cache.Stats()
+}
+
+// SchedulerStats returns how the job slots were shared between the peers submitting jobs
+func (self *DemoAPI) SchedulerStats() SchedulerStats {
+	return self.service.sched.Stats()
+}
+
+// PeerSkills returns the skills the peers have announced, by peer id
+func (self *DemoAPI) PeerSkills() map[string]*protocol.Skills {
+	self.service.mu.RLock()
+	defer self.service.mu.RUnlock()
+	skills := make(map[string]*protocol.Skills)
+	for p, st := range self.service.peers {
+		if st.skills != nil {
+			skills[p.ID().String()] = st.skills
+		}
+	}
+	return skills
+}
+
+// PeerCaps returns the version and features negotiated with each peer, by peer id
+func (self *DemoAPI) PeerCaps() map[string]string {
+	self.service.mu.RLock()
+	defer self.service.mu.RUnlock()
+	caps := make(map[string]string)
+	for p, st := range self.service.peers {
+		caps[p.ID().String()] = st.caps.String()
+	}
+	return caps
+}
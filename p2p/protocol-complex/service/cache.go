@@ -0,0 +1,104 @@
+package service
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"sync"
+)
+
+// a job is identified by its content, the same data at the same difficulty always gives the same result
+type cacheKey [sha1.Size]byte
+
+func newCacheKey(data []byte, difficulty uint8) (key cacheKey) {
+	h := sha1.New()
+	h.Write(data)
+	h.Write([]byte{difficulty})
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	nonce []byte
+	hash  []byte
+}
+
+// CacheStats tells how well the result cache is doing
+type CacheStats struct {
+	Size      int    `json:"size"`
+	Capacity  int    `json:"capacity"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// jobCache remembers the results of the latest jobs, and forgets the least recently used when full
+type jobCache struct {
+	capacity int                        // amount of results to remember, 0 remembers none
+	entries  *list.List                 // most recently used first
+	idx      map[cacheKey]*list.Element // index to look up entries by
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+
+	mu sync.Mutex
+}
+
+func newJobCache(capacity int) *jobCache {
+	return &jobCache{
+		capacity: capacity,
+		entries:  list.New(),
+		idx:      make(map[cacheKey]*list.Element),
+	}
+}
+
+// returns the nonce and hash of a job done before, or nil if we don't have it
+func (self *jobCache) Get(key cacheKey) (nonce []byte, hash []byte) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	e, ok := self.idx[key]
+	if !ok {
+		self.misses++
+		return nil, nil
+	}
+	self.hits++
+	self.entries.MoveToFront(e)
+	entry := e.Value.(*cacheEntry)
+	return entry.nonce, entry.hash
+}
+
+func (self *jobCache) Put(key cacheKey, nonce []byte, hash []byte) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.capacity == 0 {
+		return
+	}
+	if e, ok := self.idx[key]; ok {
+		self.entries.MoveToFront(e)
+		return
+	}
+	if self.entries.Len() >= self.capacity {
+		oldest := self.entries.Back()
+		self.entries.Remove(oldest)
+		delete(self.idx, oldest.Value.(*cacheEntry).key)
+		self.evictions++
+	}
+	self.idx[key] = self.entries.PushFront(&cacheEntry{
+		key:   key,
+		nonce: nonce,
+		hash:  hash,
+	})
+}
+
+func (self *jobCache) Stats() CacheStats {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return CacheStats{
+		Size:      self.entries.Len(),
+		Capacity:  self.capacity,
+		Hits:      self.hits,
+		Misses:    self.misses,
+		Evictions: self.evictions,
+	}
+}
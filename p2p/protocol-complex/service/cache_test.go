@@ -0,0 +1,48 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestJobCache(t *testing.T) {
+	c := newJobCache(2)
+	keys := []cacheKey{
+		newCacheKey(testData, 1),
+		newCacheKey(testData, 2),
+		newCacheKey(testData[1:], 1),
+	}
+	for i, key := range keys[:2] {
+		c.Put(key, []byte{byte(i)}, []byte{byte(i), byte(i)})
+	}
+
+	// using the first makes the second the least recently used, which goes when the third comes
+	nonce, hash := c.Get(keys[0])
+	if !bytes.Equal(nonce, []byte{0}) || !bytes.Equal(hash, []byte{0, 0}) {
+		t.Fatalf("got nonce %x hash %x for first job", nonce, hash)
+	}
+	c.Put(keys[2], []byte{2}, []byte{2, 2})
+	if _, hash := c.Get(keys[1]); hash != nil {
+		t.Fatal("least recently used job not evicted")
+	}
+	for _, key := range []cacheKey{keys[0], keys[2]} {
+		if _, hash := c.Get(key); hash == nil {
+			t.Fatalf("job %x evicted", key)
+		}
+	}
+
+	stats := c.Stats()
+	want := CacheStats{Size: 2, Capacity: 2, Hits: 3, Misses: 1, Evictions: 1}
+	if stats != want {
+		t.Fatalf("got stats %+v, want %+v", stats, want)
+	}
+}
+
+func TestJobCacheDisabled(t *testing.T) {
+	c := newJobCache(0)
+	key := newCacheKey(testData, 1)
+	c.Put(key, []byte{1}, []byte{1})
+	if _, hash := c.Get(key); hash != nil {
+		t.Fatal("cache without capacity kept a job")
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+
+	"../protocol"
+)
+
+// a job that can't finish is given up exactly when the job time has passed on the clock
+func TestJobTimeout(t *testing.T) {
+	clock := &mclock.Simulated{}
+	params := NewDemoParams(nil, nil)
+	params.MaxDifficulty = 128
+	params.MaxJobs = 1
+	params.MaxTimePerJob = time.Minute
+	params.Clock = clock
+	s, err := NewDemo(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	p := newPeer(protocol.Spec)
+
+	id := protocol.ID{1}
+	err = s.requestHandlerLocked(&protocol.Request{
+		Id:         id,
+		Data:       testData,
+		Difficulty: 128,
+	}, p.Peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not a moment too soon
+	clock.WaitForTimers(1)
+	clock.Run(time.Minute - time.Nanosecond)
+	if clock.ActiveTimers() != 1 {
+		t.Fatalf("job timed out early")
+	}
+
+	clock.Run(time.Nanosecond)
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := &protocol.Status{}
+	if err := decodeMsg(msg, status); err != nil {
+		t.Fatal(err)
+	} else if status.Id != id || status.Code != protocol.StatusGaveup {
+		t.Fatalf("expected StatusGaveup (%d) for %x, got %d for %x", protocol.StatusGaveup, id, status.Code, status.Id)
+	}
+}
+
+// results that haven't been acknowledged are passed to the sink when they expire
+func TestResultExpiry(t *testing.T) {
+	clock := &mclock.Simulated{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sinkC := make(chan interface{}, 1)
+	results := newResultStore(ctx, func(data interface{}) {
+		sinkC <- data
+	}, clock)
+
+	id := protocol.ID{1}
+	res := &protocol.Result{Id: id}
+	results.Put(id, res)
+	results.Start()
+
+	clock.WaitForTimers(1)
+	clock.Run(defaultResultsReleaseDelay - time.Nanosecond)
+	if clock.ActiveTimers() != 1 || results.Count() != 1 {
+		t.Fatalf("result expired early")
+	}
+
+	clock.Run(time.Nanosecond)
+	select {
+	case data := <-sinkC:
+		if data != res {
+			t.Fatalf("sink got %v, expected %v", data, res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expired result not passed to sink")
+	}
+	if results.Count() != 0 {
+		t.Fatalf("expired result still stored")
+	}
+}
+
+// a peer that doesn't read the status of a request too hard holds up neither the other handlers, nor its own for longer than
+// the status send timeout on the clock
+func TestTooHardStatusTimeout(t *testing.T) {
+	clock := &mclock.Simulated{}
+	params := NewDemoParams(nil, nil)
+	params.MaxDifficulty = 8
+	params.Clock = clock
+	s, err := NewDemo(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	p := newPeer(protocol.Spec)
+	errC := make(chan error, 1)
+	go func() {
+		errC <- s.requestHandlerLocked(&protocol.Request{
+			Data:       testData,
+			Difficulty: 9,
+		}, p.Peer)
+	}()
+
+	// the status is stuck in the pipe, and the lock is free
+	clock.WaitForTimers(1)
+	lockedC := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		s.mu.Unlock()
+		close(lockedC)
+	}()
+	select {
+	case <-lockedC:
+	case <-time.After(time.Second):
+		t.Fatal("lock held while sending the status")
+	}
+
+	// not a moment too soon
+	clock.Run(statusSendTimeout - time.Nanosecond)
+	select {
+	case <-errC:
+		t.Fatal("handler gave up on the status early")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	clock.Run(time.Nanosecond)
+	select {
+	case err := <-errC:
+		if err == nil {
+			t.Fatal("too hard request taken")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler still waiting for the status to be sent")
+	}
+}
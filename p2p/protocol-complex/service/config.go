@@ -0,0 +1,115 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// DemoConfig is the part of the params of a Demo that can change while it runs, see Reload
+//
+// the fields left out stay as they are
+type DemoConfig struct {
+	SubmitDelay   *uint32 `json:"submitDelay,omitempty"` // milliseconds between the jobs we submit by ourselves
+	MaxJobs       *int    `json:"maxJobs,omitempty"`
+	MinDifficulty *uint8  `json:"minDifficulty,omitempty"`
+	MaxDifficulty *uint8  `json:"maxDifficulty,omitempty"`
+	FanOut        *int    `json:"fanOut,omitempty"` // workers each job we submit is sent to at once
+}
+
+// ReadDemoConfig reads a config from a json file
+func ReadDemoConfig(path string) (*DemoConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &DemoConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Config returns the values the service runs with now
+func (self *Demo) Config() *DemoConfig {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	delay := uint32(self.submitDelay / time.Millisecond)
+	maxJobs := self.maxJobs
+	min, max := self.minDifficulty, self.maxDifficulty
+	fanOut := self.fanOut
+	return &DemoConfig{
+		SubmitDelay:   &delay,
+		MaxJobs:       &maxJobs,
+		MinDifficulty: &min,
+		MaxDifficulty: &max,
+		FanOut:        &fanOut,
+	}
+}
+
+// Reload applies the config without restarting, and returns the names of the values it changed
+//
+// the peers are told about new difficulties and job slots with our skills, like on setDifficulty.
+// Jobs that are running keep running when there are fewer slots, and more slots start the jobs waiting in the queues right away.
+// A new submit delay counts from the next job we submit, and a new fan out from the next job too
+func (self *Demo) Reload(cfg *DemoConfig) ([]string, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	min, max := self.minDifficulty, self.maxDifficulty
+	if cfg.MinDifficulty != nil {
+		min = *cfg.MinDifficulty
+	}
+	if cfg.MaxDifficulty != nil {
+		max = *cfg.MaxDifficulty
+	}
+	if max > 0 && min > max {
+		return nil, fmt.Errorf("min difficulty %d above max %d", min, max)
+	}
+	if cfg.MaxJobs != nil && *cfg.MaxJobs < 0 {
+		return nil, fmt.Errorf("negative max jobs %d", *cfg.MaxJobs)
+	}
+	if cfg.FanOut != nil && *cfg.FanOut < 0 {
+		return nil, fmt.Errorf("negative fan out %d", *cfg.FanOut)
+	}
+
+	var changed []string
+	if cfg.SubmitDelay != nil {
+		delay := time.Duration(*cfg.SubmitDelay) * time.Millisecond
+		if delay != self.submitDelay {
+			self.submitDelay = delay
+			changed = append(changed, "submitDelay")
+		}
+	}
+	if cfg.FanOut != nil && *cfg.FanOut != self.fanOut {
+		self.fanOut = *cfg.FanOut
+		changed = append(changed, "fanOut")
+	}
+	skillsChanged := false
+	if cfg.MaxJobs != nil && *cfg.MaxJobs != self.maxJobs {
+		self.maxJobs = *cfg.MaxJobs
+		changed = append(changed, "maxJobs")
+		skillsChanged = true
+	}
+	if min != self.minDifficulty {
+		self.minDifficulty = min
+		changed = append(changed, "minDifficulty")
+		skillsChanged = true
+	}
+	if max != self.maxDifficulty {
+		self.maxDifficulty = max
+		changed = append(changed, "maxDifficulty")
+		skillsChanged = true
+	}
+	if skillsChanged {
+		self.announceSkills()
+		self.schedule()
+	}
+	if len(changed) > 0 {
+		log.Info("reloaded config", "changed", changed)
+	}
+	return changed, nil
+}
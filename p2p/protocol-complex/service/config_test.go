@@ -0,0 +1,60 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// a config file gives only the values to change, and a reload changes only those that differ
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "demo-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "demo.json")
+	if err := ioutil.WriteFile(path, []byte(`{"submitDelay": 250, "maxDifficulty": 8}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ReadDemoConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxJobs != nil || cfg.MinDifficulty != nil {
+		t.Fatalf("values not in the file are set: %+v", cfg)
+	}
+
+	d := newTestDemo(t, 16, 3, time.Second)
+	defer d.Stop()
+	changed, err := d.Reload(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 2 || changed[0] != "submitDelay" || changed[1] != "maxDifficulty" {
+		t.Fatalf("unexpected changes %v", changed)
+	}
+	now := d.Config()
+	if *now.SubmitDelay != 250 || *now.MaxDifficulty != 8 || *now.MaxJobs != 3 || d.submitDelay != time.Millisecond*250 {
+		t.Fatalf("unexpected config after reload %+v", d.Config())
+	}
+	if d.skillsVersion != 1 {
+		t.Fatalf("skills version %d after reload, want 1", d.skillsVersion)
+	}
+
+	// again changes nothing, and announces nothing
+	changed, err = d.Reload(cfg)
+	if err != nil || len(changed) != 0 || d.skillsVersion != 1 {
+		t.Fatalf("reload of the same config changed %v, err %v", changed, err)
+	}
+
+	min := uint8(9)
+	if _, err := d.Reload(&DemoConfig{MinDifficulty: &min}); err == nil {
+		t.Fatal("min difficulty above max accepted")
+	}
+	if d.minDifficulty != 0 {
+		t.Fatal("rejected config applied")
+	}
+}
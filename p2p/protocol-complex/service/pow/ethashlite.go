@@ -0,0 +1,97 @@
+package pow
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// DefaultDatasetSize is the amount of items in the dataset, 1MB of them
+	DefaultDatasetSize = 1 << 14
+
+	itemSize       = 64 // the size of a keccak512 hash
+	datasetSeed    = "ethash-lite"
+	datasetRounds  = 2  // mixing rounds when generating the dataset
+	datasetLookups = 32 // dataset items mixed into each hash
+)
+
+// EthashLite is a memory hard proof of work in the spirit of ethash
+//
+// Every hash mixes in items from a dataset at places that depend on the data and the nonce, so mining
+// needs the whole dataset at hand. Unlike ethash's dataset of gigabytes, this one is fixed and small enough
+// to generate in a blink. A bigger dataset makes mining slower, and needs more memory
+//
+// All nodes must use the same dataset size
+type EthashLite struct {
+	dataset [][]byte
+}
+
+func NewEthashLite(size int) *EthashLite {
+	return &EthashLite{
+		dataset: generateDataset(size),
+	}
+}
+
+func (self *EthashLite) Name() string {
+	return "ethash-lite"
+}
+
+func (self *EthashLite) Mine(ctx context.Context, data []byte, difficulty uint8, hashes *uint64) ([]byte, []byte, error) {
+	return search(ctx, difficulty, hashes, func(nonce []byte) []byte {
+		return self.hash(data, nonce)
+	})
+}
+
+func (self *EthashLite) Verify(data []byte, nonce []byte, hash []byte, difficulty uint8) bool {
+	return bytes.Equal(hash, self.hash(data, nonce)) && meets(hash, difficulty)
+}
+
+// a chain of keccak512 hashes from a fixed seed, mixed a couple of times like ethash mixes its cache
+func generateDataset(size int) [][]byte {
+	dataset := make([][]byte, size)
+	dataset[0] = crypto.Keccak512([]byte(datasetSeed))
+	for i := 1; i < size; i++ {
+		dataset[i] = crypto.Keccak512(dataset[i-1])
+	}
+	item := make([]byte, itemSize)
+	for r := 0; r < datasetRounds; r++ {
+		for i := 0; i < size; i++ {
+			prev := dataset[(i-1+size)%size]
+			other := dataset[binary.LittleEndian.Uint32(dataset[i])%uint32(size)]
+			for j := range item {
+				item[j] = prev[j] ^ other[j]
+			}
+			dataset[i] = crypto.Keccak512(item)
+		}
+	}
+	return dataset
+}
+
+// the hashimoto loop of ethash, on the small dataset
+func (self *EthashLite) hash(data []byte, nonce []byte) []byte {
+	seed := crypto.Keccak512(data, nonce)
+	mix := make([]uint32, itemSize/4)
+	for i := range mix {
+		mix[i] = binary.LittleEndian.Uint32(seed[i*4:])
+	}
+	size := uint32(len(self.dataset))
+	for i := 0; i < datasetLookups; i++ {
+		item := self.dataset[fnv(uint32(i)^mix[0], mix[i%len(mix)])%size]
+		for j := range mix {
+			mix[j] = fnv(mix[j], binary.LittleEndian.Uint32(item[j*4:]))
+		}
+	}
+	digest := make([]byte, itemSize)
+	for i, m := range mix {
+		binary.LittleEndian.PutUint32(digest[i*4:], m)
+	}
+	return crypto.Keccak256(seed, digest)
+}
+
+// the non-associative mixing function of ethash
+func fnv(a, b uint32) uint32 {
+	return a*0x01000193 ^ b
+}
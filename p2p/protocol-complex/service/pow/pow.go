@@ -0,0 +1,82 @@
+package pow
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+)
+
+// NonceSize is the size of the nonces all algorithms search
+const NonceSize = 8
+
+// Pow is a proof of work algorithm the demo service mines with
+//
+// The difficulty of a job is the amount of trailing zero bits the hash of its data and nonce must have.
+// The algorithm isn't negotiated, so all nodes must use the same
+type Pow interface {
+	Name() string
+
+	// Mine searches a nonce for which the hash of the data and the nonce meets the difficulty, until the context is done
+	// hashes, if not nil, is kept up to date with how many hashes were tried, for reading atomically while mining
+	Mine(ctx context.Context, data []byte, difficulty uint8, hashes *uint64) (nonce []byte, hash []byte, err error)
+
+	// Verify checks the hash is the one of the data and the nonce, and meets the difficulty
+	Verify(data []byte, nonce []byte, hash []byte, difficulty uint8) bool
+}
+
+// New returns the algorithm by name, "sha1", "sha3" or "ethash-lite"
+func New(name string) (Pow, error) {
+	switch name {
+	case "sha1":
+		return NewSha1(), nil
+	case "sha3":
+		return NewSha3(), nil
+	case "ethash-lite":
+		return NewEthashLite(DefaultDatasetSize), nil
+	}
+	return nil, fmt.Errorf("unknown pow '%s'", name)
+}
+
+// tells if the hash has at least difficulty trailing zero bits
+func meets(hash []byte, difficulty uint8) bool {
+	d := int(difficulty)
+	if d > len(hash)*8 {
+		return false
+	}
+	for i := len(hash) - 1; d > 0; i-- {
+		mask := byte(0xff)
+		if d < 8 {
+			mask = byte(1<<uint(d)) - 1
+		}
+		if hash[i]&mask != 0 {
+			return false
+		}
+		d -= 8
+	}
+	return true
+}
+
+// tries all the nonces in order with the hash function, checking the context every so often
+func search(ctx context.Context, difficulty uint8, hashes *uint64, hash func(nonce []byte) []byte) ([]byte, []byte, error) {
+	nonce := make([]byte, NonceSize)
+	for n := uint64(0); ; n++ {
+		if n%1024 == 0 {
+			if hashes != nil {
+				atomic.StoreUint64(hashes, n)
+			}
+			select {
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			default:
+			}
+		}
+		binary.BigEndian.PutUint64(nonce, n)
+		if h := hash(nonce); meets(h, difficulty) {
+			if hashes != nil {
+				atomic.StoreUint64(hashes, n+1)
+			}
+			return nonce, h, nil
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package pow
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var testData = []byte("the quick brown fox jumps over the lazy dog")
+
+func testPows(t *testing.T) []Pow {
+	var pows []Pow
+	for _, name := range []string{"sha1", "sha3", "ethash-lite"} {
+		p, err := New(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Name() != name {
+			t.Fatalf("got pow %s for %s", p.Name(), name)
+		}
+		pows = append(pows, p)
+	}
+	return pows
+}
+
+func TestMineVerify(t *testing.T) {
+	for _, p := range testPows(t) {
+		nonce, hash, err := p.Mine(context.Background(), testData, 10, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", p.Name(), err)
+		}
+		if len(nonce) != NonceSize {
+			t.Fatalf("%s: nonce of %d bytes", p.Name(), len(nonce))
+		}
+		if !p.Verify(testData, nonce, hash, 10) {
+			t.Fatalf("%s: mined hash %x doesn't verify", p.Name(), hash)
+		}
+		if p.Verify(testData[1:], nonce, hash, 10) {
+			t.Fatalf("%s: hash verifies for other data", p.Name())
+		}
+		// the hash meets a difficulty by chance, but not all of them
+		if p.Verify(testData, nonce, hash, uint8(len(hash)*8-1)) {
+			t.Fatalf("%s: hash %x meets the highest difficulty", p.Name(), hash)
+		}
+
+		// mining is deterministic
+		again, _, err := p.Mine(context.Background(), testData, 10, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", p.Name(), err)
+		}
+		if string(again) != string(nonce) {
+			t.Fatalf("%s: mined nonce %x, then %x", p.Name(), nonce, again)
+		}
+	}
+}
+
+// the hashes tried can be followed while mining, and are all counted in the end
+func TestMineHashes(t *testing.T) {
+	for _, p := range testPows(t) {
+		var hashes uint64
+		_, _, err := p.Mine(context.Background(), testData, 12, &hashes)
+		if err != nil {
+			t.Fatalf("%s: %v", p.Name(), err)
+		}
+		var n uint64
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		p.Mine(ctx, testData, 128, &n)
+		cancel()
+		if hashes == 0 || n == 0 {
+			t.Fatalf("%s: no hashes counted, %d mining to the end, %d until cancelled", p.Name(), hashes, n)
+		}
+	}
+}
+
+func TestMineCancel(t *testing.T) {
+	for _, p := range testPows(t) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		_, _, err := p.Mine(ctx, testData, 128, nil)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("%s: expected deadline exceeded, got %v", p.Name(), err)
+		}
+	}
+}
+
+func TestMeets(t *testing.T) {
+	for i, tc := range []struct {
+		hash       []byte
+		difficulty uint8
+		want       bool
+	}{
+		{[]byte{0xff, 0x00}, 8, true},
+		{[]byte{0xff, 0x00}, 9, false},
+		{[]byte{0xfe, 0x00}, 9, true},
+		{[]byte{0x01, 0x10}, 4, true},
+		{[]byte{0x01, 0x10}, 5, false},
+		{[]byte{0x00, 0x00}, 16, true},
+		{[]byte{0x00, 0x00}, 17, false},
+	} {
+		if got := meets(tc.hash, tc.difficulty); got != tc.want {
+			t.Fatalf("case %d: %x at difficulty %d: got %v", i, tc.hash, tc.difficulty, got)
+		}
+	}
+}
+
+func TestUnknown(t *testing.T) {
+	if _, err := New("scrypt"); err == nil {
+		t.Fatal("got a pow for an unknown name")
+	}
+}
@@ -0,0 +1,49 @@
+package pow
+
+import (
+	"context"
+	"sync/atomic"
+
+	"../minipow"
+)
+
+// Sha1 is the sha1 difficulty search of the minipow package, the algorithm the demo started out with
+type Sha1 struct{}
+
+func NewSha1() *Sha1 {
+	return &Sha1{}
+}
+
+func (self *Sha1) Name() string {
+	return "sha1"
+}
+
+func (self *Sha1) Mine(ctx context.Context, data []byte, difficulty uint8, hashes *uint64) ([]byte, []byte, error) {
+	// minipow mines with the nonce in the last bytes of the data
+	// it answers once more after it's told to quit, so the channel has room for that
+	resultC := make(chan []byte, 1)
+	quitC := make(chan struct{})
+	workData := make([]byte, len(data)+NonceSize)
+	copy(workData, data)
+
+	// minipow tells about every hash it tries
+	var count func([]byte, []byte)
+	if hashes != nil {
+		count = func([]byte, []byte) {
+			atomic.AddUint64(hashes, 1)
+		}
+	}
+	go minipow.Mine(workData, int(difficulty), resultC, quitC, count)
+
+	select {
+	case <-ctx.Done():
+		close(quitC)
+		return nil, nil, ctx.Err()
+	case hash := <-resultC:
+		return workData[len(data):], hash, nil
+	}
+}
+
+func (self *Sha1) Verify(data []byte, nonce []byte, hash []byte, difficulty uint8) bool {
+	return minipow.Check(hash, data, nonce) && meets(hash, difficulty)
+}
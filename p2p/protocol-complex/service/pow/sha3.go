@@ -0,0 +1,31 @@
+package pow
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Sha3 searches for a keccak256 hash of the data and the nonce that meets the difficulty
+//
+// It does the same as Sha1 with the hash ethereum uses, and the longer hash allows higher difficulties
+type Sha3 struct{}
+
+func NewSha3() *Sha3 {
+	return &Sha3{}
+}
+
+func (self *Sha3) Name() string {
+	return "sha3"
+}
+
+func (self *Sha3) Mine(ctx context.Context, data []byte, difficulty uint8, hashes *uint64) ([]byte, []byte, error) {
+	return search(ctx, difficulty, hashes, func(nonce []byte) []byte {
+		return crypto.Keccak256(data, nonce)
+	})
+}
+
+func (self *Sha3) Verify(data []byte, nonce []byte, hash []byte, difficulty uint8) bool {
+	return bytes.Equal(hash, crypto.Keccak256(data, nonce)) && meets(hash, difficulty)
+}
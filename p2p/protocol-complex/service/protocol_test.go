@@ -0,0 +1,988 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	p2ptest "github.com/ethereum/go-ethereum/p2p/testing"
+
+	"../capability"
+	"../protocol"
+	"./pow"
+)
+
+// The tests in this file are an executable specification of the demo protocol
+//
+// The node under test runs the protocol of a Demo service, and is connected to a mock peer.
+// Each exchange says what the mock peer sends (triggers) and what it must get back (expects), to the byte.
+
+var testData = []byte("the quick brown fox jumps over the lazy dog")
+
+func newTestDemo(t *testing.T, maxDifficulty uint8, maxJobs int, maxTimePerJob time.Duration) *Demo {
+	params := NewDemoParams(nil, nil)
+	params.Id = make([]byte, 8)
+	params.MaxDifficulty = maxDifficulty
+	params.MaxJobs = maxJobs
+	params.MaxTimePerJob = maxTimePerJob
+	d, err := NewDemo(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// starts the protocol of the service on a node with one mock peer
+func newProtocolTester(t *testing.T, d *Demo) (*p2ptest.ProtocolTester, enode.ID) {
+	id := adapters.RandomNodeConfig().ID
+	tester := p2ptest.NewProtocolTester(t, id, 1, d.Protocol().Run)
+	return tester, tester.Nodes[0].ID()
+}
+
+func msgCode(t *testing.T, msg interface{}) uint64 {
+	code, ok := protocol.Spec.GetCode(msg)
+	if !ok {
+		t.Fatalf("no code for message %T", msg)
+	}
+	return code
+}
+
+func trigger(t *testing.T, peer enode.ID, msg interface{}) p2ptest.Trigger {
+	return p2ptest.Trigger{
+		Code: msgCode(t, msg),
+		Msg:  msg,
+		Peer: peer,
+	}
+}
+
+func expect(t *testing.T, peer enode.ID, msg interface{}) p2ptest.Expect {
+	return p2ptest.Expect{
+		Code: msgCode(t, msg),
+		Msg:  msg,
+		Peer: peer,
+	}
+}
+
+// the skills are handled asynchronously, waits for the peer to become a worker
+func waitWorker(t *testing.T, d *Demo, difficulty uint8) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		d.mu.RLock()
+		worker := d.getNextWorker(difficulty)
+		d.mu.RUnlock()
+		if worker != nil {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatal("peer not registered as worker")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// the result a worker should come up with, mining is deterministic
+func expectedResult(t *testing.T, id protocol.ID, data []byte, difficulty uint8) *protocol.Result {
+	return expectedPowResult(t, pow.NewSha1(), id, data, difficulty)
+}
+
+func expectedPowResult(t *testing.T, pw pow.Pow, id protocol.ID, data []byte, difficulty uint8) *protocol.Result {
+	j, err := doJob(context.Background(), pw, data, difficulty, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &protocol.Result{
+		Id:    id,
+		Nonce: j.Nonce,
+		Hash:  j.Hash,
+	}
+}
+
+// a worker announces its skills, and answers requests with results
+func TestProtocolWorker(t *testing.T) {
+	d := newTestDemo(t, 8, 3, time.Second)
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	id := protocol.ID{1}
+	err := tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Difficulty: 8, Capacity: 3, Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "request",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: id, Data: testData, Difficulty: 4}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, expectedResult(t, id, testData, 4)),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a worker that has all the jobs it can take says it's busy, and gives up on jobs that take too long
+func TestProtocolWorkerBusy(t *testing.T) {
+	d := newTestDemo(t, 128, 1, time.Millisecond*500)
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	err := tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Difficulty: 128, Capacity: 1, Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "second request while busy",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: protocol.ID{1}, Data: testData, Difficulty: 128}),
+				trigger(t, peer, &protocol.Request{Id: protocol.ID{2}, Data: testData, Difficulty: 128}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Status{Id: protocol.ID{2}, Code: protocol.StatusBusy}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "first request times out",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Status{Id: protocol.ID{1}, Code: protocol.StatusGaveup}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a worker announces its new skills when they change, and takes the jobs they cover
+func TestProtocolSkillsChange(t *testing.T) {
+	d := newTestDemo(t, 8, 3, time.Second)
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	err := tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Difficulty: 8, Capacity: 3, Caps: protocol.Caps}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = newDemoAPI(d).SetDifficulty(2, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := protocol.ID{1}
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on change",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Difficulty: 16, MinDifficulty: 2, Capacity: 3, Version: 1, Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "request within the new skills",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: id, Data: testData, Difficulty: 12}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, expectedResult(t, id, testData, 12)),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a worker announces the skills a reload changes, and starts the queued jobs the new job slots make room for
+func TestProtocolReload(t *testing.T) {
+	d := newTestDemo(t, 128, 1, time.Minute)
+	d.sched = newScheduler(1)
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	err := tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Difficulty: 128, Capacity: 1, Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "a long job and one queued",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: protocol.ID{1}, Data: testData, Difficulty: 128}),
+				trigger(t, peer, &protocol.Request{Id: protocol.ID{2}, Data: testData, Difficulty: 4}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		st := d.sched.Stats().Submitters[peer.String()]
+		if st != nil && st.Queued == 1 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("job not queued")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	maxJobs := 2
+	min := uint8(2)
+	changed, err := newDemoAPI(d).Reload(DemoConfig{MaxJobs: &maxJobs, MinDifficulty: &min})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 2 || changed[0] != "maxJobs" || changed[1] != "minDifficulty" {
+		t.Fatalf("unexpected changes %v", changed)
+	}
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on reload, and the queued job",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Difficulty: 128, MinDifficulty: 2, Capacity: 2, Version: 1, Caps: protocol.Caps}),
+				expect(t, peer, expectedResult(t, protocol.ID{2}, testData, 4)),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a worker answers a job it has done before from its cache, and says so
+func TestProtocolCache(t *testing.T) {
+	d := newTestDemo(t, 8, 3, time.Second)
+	d.cache = newJobCache(4)
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	first := expectedResult(t, protocol.ID{1}, testData, 4)
+	again := expectedResult(t, protocol.ID{2}, testData, 4)
+	err := tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Skills{Caps: protocol.Caps}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Difficulty: 8, Capacity: 3, Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "first request",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: first.Id, Data: testData, Difficulty: 4}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, first),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "same job again",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: again.Id, Data: testData, Difficulty: 4}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Status{Id: again.Id, Code: protocol.StatusCached}),
+				expect(t, peer, again),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := newDemoAPI(d).CacheStats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Size != 1 {
+		t.Fatalf("unexpected cache stats %+v", stats)
+	}
+}
+
+// a request harder than the worker's skills is a protocol violation, and the peer is told so and dropped
+// a worker gives up on a job when the timeout of the request passes, even if it would work on it for longer
+func TestProtocolExpired(t *testing.T) {
+	d := newTestDemo(t, 128, 1, time.Minute)
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	err := tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Skills{Caps: protocol.Caps}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Difficulty: 128, Capacity: 1, Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "request expires",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: protocol.ID{1}, Data: testData, Difficulty: 128, Timeout: 100}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Status{Id: protocol.ID{1}, Code: protocol.StatusExpired}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "the job is off the books",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: protocol.ID{2}, Data: testData, Difficulty: 4}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, expectedResult(t, protocol.ID{2}, testData, 4)),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a cancelled job is dropped without an answer, and makes room for the next one
+func TestProtocolCancel(t *testing.T) {
+	d := newTestDemo(t, 128, 1, time.Minute)
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	err := tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Difficulty: 128, Capacity: 1, Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "request, cancel and request again",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: protocol.ID{1}, Data: testData, Difficulty: 128}),
+				trigger(t, peer, &protocol.Cancel{Id: protocol.ID{1}}),
+				trigger(t, peer, &protocol.Request{Id: protocol.ID{2}, Data: testData, Difficulty: 4}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, expectedResult(t, protocol.ID{2}, testData, 4)),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.currentJobs != 0 || len(d.jobs) != 0 {
+		t.Fatalf("jobs left after cancel: current %d, running %d", d.currentJobs, len(d.jobs))
+	}
+}
+
+// a worker with all its job slots taken queues jobs, and starts on them when a slot frees up
+func TestProtocolQueue(t *testing.T) {
+	d := newTestDemo(t, 128, 1, time.Minute)
+	d.sched = newScheduler(1)
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	err := tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Skills{Caps: protocol.Caps}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Difficulty: 128, Capacity: 1, Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "queue full",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: protocol.ID{1}, Data: testData, Difficulty: 128, Timeout: 200}),
+				trigger(t, peer, &protocol.Request{Id: protocol.ID{2}, Data: testData, Difficulty: 4}),
+				trigger(t, peer, &protocol.Request{Id: protocol.ID{3}, Data: testData, Difficulty: 4}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Status{Id: protocol.ID{3}, Code: protocol.StatusBusy}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "queued job runs when the first expires",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Status{Id: protocol.ID{1}, Code: protocol.StatusExpired}),
+				expect(t, peer, expectedResult(t, protocol.ID{2}, testData, 4)),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := newDemoAPI(d).SchedulerStats().Submitters[peer.String()]
+	if st == nil || st.Started != 2 || st.Busy != 1 || st.Queued != 0 {
+		t.Fatalf("unexpected scheduler stats %+v", st)
+	}
+}
+
+// a peer that announces none of the features is only sent the messages it knows
+// it gets no StatusCached before a result from the cache, and a job that expires is given up on
+func TestProtocolOlderPeer(t *testing.T) {
+	d := newTestDemo(t, 128, 1, time.Minute)
+	d.cache = newJobCache(4)
+	d.progressEvery = time.Millisecond * 10
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	first := expectedResult(t, protocol.ID{1}, testData, 4)
+	again := expectedResult(t, protocol.ID{2}, testData, 4)
+	err := tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Skills{Caps: capability.Caps{Version: 1}}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Difficulty: 128, Capacity: 1, Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "first request",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: first.Id, Data: testData, Difficulty: 4}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, first),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "same job again, no cached status",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: again.Id, Data: testData, Difficulty: 4}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, again),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "request expires, no progress",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: protocol.ID{3}, Data: testData, Difficulty: 128, Timeout: 100}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Status{Id: protocol.ID{3}, Code: protocol.StatusGaveup}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for p, st := range d.peers {
+		if p.ID() != peer {
+			continue
+		}
+		if st.caps.Cancel() || st.caps.Progress() || st.caps.Cached() || st.caps.Expiry() {
+			t.Fatalf("negotiated %v with a peer without features", st.caps)
+		}
+	}
+}
+
+// a worker mines with the algorithm it's given
+func TestProtocolPow(t *testing.T) {
+	pw := pow.NewEthashLite(1024)
+	params := NewDemoParams(nil, nil)
+	params.Id = make([]byte, 8)
+	params.MaxDifficulty = 8
+	params.MaxJobs = 1
+	params.MaxTimePerJob = time.Second
+	params.Pow = pw
+	d, err := NewDemo(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	id := protocol.ID{1}
+	result := expectedPowResult(t, pw, id, testData, 8)
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Difficulty: 8, Capacity: 1, Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "request",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: id, Data: testData, Difficulty: 8}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, result),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkJob(pow.NewSha1(), result.Hash, testData, result.Nonce, 8) {
+		t.Fatal("result of another pow checks out")
+	}
+}
+
+func TestProtocolWorkerTooHard(t *testing.T) {
+	d := newTestDemo(t, 8, 3, time.Second)
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	err := tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Difficulty: 8, Capacity: 3, Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "too hard request",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Request{Id: protocol.ID{1}, Data: testData, Difficulty: 9}),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Status{Id: protocol.ID{1}, Code: protocol.StatusAreYouKidding}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tester.TestDisconnected(&p2ptest.Disconnect{
+		Peer:  peer,
+		Error: errors.New("Message handler error: (msg code 2): too hard!"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a moocher sends requests to the peers that announce skills, and thanks them for correct results
+func TestProtocolMoocher(t *testing.T) {
+	savedC := make(chan []byte, 1)
+	params := NewDemoParams(nil, func(nid []byte, mid protocol.ID, difficulty uint8, data []byte, nonce []byte, hash []byte) {
+		savedC <- hash
+	})
+	params.Id = make([]byte, 8)
+	params.SubmitDelay = time.Hour // we submit ourselves, so we know what is sent
+	params.SubmitDataSize = len(testData)
+	params.MaxSubmitDifficulty = 8
+	d, err := NewDemo(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "peer announces skills",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Skills{Difficulty: 8, Capacity: 1, Caps: protocol.Caps}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitWorker(t, d, 4)
+
+	id, err := d.submitRequest(testData, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := expectedResult(t, id, testData, 4)
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "request",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Request{Id: id, Data: testData, Difficulty: 4}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "result",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, result),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Status{Id: id, Code: protocol.StatusThanksABunch}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case hash := <-savedC:
+		if !checkJob(d.pow, hash, testData, result.Nonce, 4) {
+			t.Fatalf("saved wrong hash %x", hash)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("result not saved")
+	}
+
+	// the submitter can follow its jobs
+	job, err := newDemoAPI(d).JobStatus(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != JobDone || job.Worker != peer.String() || !checkJob(d.pow, job.Hash, testData, job.Nonce, job.Difficulty) {
+		t.Fatalf("unexpected job status %+v", job)
+	}
+}
+
+// a submitter passes the timeout of a job on to the worker, and can cancel the job
+func TestProtocolMoocherCancel(t *testing.T) {
+	params := NewDemoParams(nil, nil)
+	params.Id = make([]byte, 8)
+	params.SubmitDelay = time.Hour // we submit ourselves, so we know what is sent
+	d, err := NewDemo(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "peer announces skills",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Skills{Difficulty: 128, Capacity: 1, Caps: protocol.Caps}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitWorker(t, d, 128)
+
+	api := newDemoAPI(d)
+	timeout := uint32(250)
+	job, err := api.SubmitJob(testData, 128, &timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := job.Id
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "request with timeout",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Request{Id: id, Data: testData, Difficulty: 128, Timeout: 250}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := api.CancelJob(id); err != nil {
+		t.Fatal(err)
+	}
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "cancel",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Cancel{Id: id}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err = api.JobStatus(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != JobCancelled {
+		t.Fatalf("expected job %s, got %s", JobCancelled, job.State)
+	}
+	if err := api.CancelJob(id); err == nil {
+		t.Fatal("cancelled a job twice")
+	}
+	// the worker is free again for the next job
+	d.mu.RLock()
+	worker := d.getNextWorker(128)
+	d.mu.RUnlock()
+	if worker == nil {
+		t.Fatal("worker still counted busy after cancel")
+	}
+}
+
+// a submitter passes the progress the worker reports on to its subscribers, and keeps the last one
+func TestProtocolMoocherProgress(t *testing.T) {
+	params := NewDemoParams(nil, nil)
+	params.Id = make([]byte, 8)
+	params.SubmitDelay = time.Hour // we submit ourselves, so we know what is sent
+	d, err := NewDemo(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	tester, peer := newProtocolTester(t, d)
+	defer tester.Stop()
+
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Skills{Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "peer announces skills",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, &protocol.Skills{Difficulty: 32, Capacity: 1, Caps: protocol.Caps}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitWorker(t, d, 32)
+
+	progressC := make(chan JobProgress, 1)
+	sub := d.progress.Subscribe(progressC)
+	defer sub.Unsubscribe()
+
+	id, err := d.submitRequest(testData, 32, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := &protocol.Progress{Id: id, Hashes: 1 << 20, Elapsed: 1000, Eta: 4095000}
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "request",
+			Expects: []p2ptest.Expect{
+				expect(t, peer, &protocol.Request{Id: id, Data: testData, Difficulty: 32}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "progress",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, peer, report),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := JobProgress{Id: id, Worker: peer.String(), Hashes: report.Hashes, Elapsed: report.Elapsed, Eta: report.Eta}
+	select {
+	case got := <-progressC:
+		if got != want {
+			t.Fatalf("got progress %+v, want %+v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no progress")
+	}
+	job, err := newDemoAPI(d).JobStatus(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Progress == nil || *job.Progress != want {
+		t.Fatalf("job status has progress %+v, want %+v", job.Progress, want)
+	}
+}
+
+// a submitter fanning out sends a job to all its workers, takes the first correct result and cancels the others
+// a result that comes after is thanked for and counted, and the job only fails when all the workers fail it
+func TestProtocolMoocherFanOut(t *testing.T) {
+	params := NewDemoParams(nil, nil)
+	params.Id = make([]byte, 8)
+	params.SubmitDelay = time.Hour // we submit ourselves, so we know what is sent
+	params.FanOut = 2
+	d, err := NewDemo(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	tester := p2ptest.NewProtocolTester(t, adapters.RandomNodeConfig().ID, 2, d.Protocol().Run)
+	defer tester.Stop()
+	a, b := tester.Nodes[0].ID(), tester.Nodes[1].ID()
+
+	skills := &protocol.Skills{Difficulty: 8, Capacity: 1, Caps: protocol.Caps}
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "skills on connect",
+			Expects: []p2ptest.Expect{
+				expect(t, a, &protocol.Skills{Caps: protocol.Caps}),
+				expect(t, b, &protocol.Skills{Caps: protocol.Caps}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "peers announce skills",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, a, skills),
+				trigger(t, b, skills),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		d.mu.RLock()
+		workers := d.getNextWorkers(4, 2)
+		d.mu.RUnlock()
+		if len(workers) == 2 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("peers not registered as workers")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	id, err := d.submitRequest(testData, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &protocol.Request{Id: id, Data: testData, Difficulty: 4}
+	result := expectedResult(t, id, testData, 4)
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "request to both",
+			Expects: []p2ptest.Expect{
+				expect(t, a, req),
+				expect(t, b, req),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "first result wins",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, a, result),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, a, &protocol.Status{Id: id, Code: protocol.StatusThanksABunch}),
+				expect(t, b, &protocol.Cancel{Id: id}),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "duplicate result",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, b, result),
+			},
+			Expects: []p2ptest.Expect{
+				expect(t, b, &protocol.Status{Id: id, Code: protocol.StatusThanksABunch}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := newDemoAPI(d)
+	job, err := api.JobStatus(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != JobDone || job.Worker != a.String() || len(job.Workers) != 2 || job.Duplicates != 1 {
+		t.Fatalf("unexpected job status %+v", job)
+	}
+
+	// one worker giving up leaves the job to the other
+	id, err = d.submitRequest(testData, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = &protocol.Request{Id: id, Data: testData, Difficulty: 4}
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "request to both again",
+			Expects: []p2ptest.Expect{
+				expect(t, a, req),
+				expect(t, b, req),
+			},
+		},
+		p2ptest.Exchange{
+			Label: "first gives up",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, a, &protocol.Status{Id: id, Code: protocol.StatusGaveup}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(time.Second)
+	for len(d.submits.Waiting(id)) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("gave up status not handled")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if job, _ := api.JobStatus(id); job.State != JobPending {
+		t.Fatalf("job %s after one worker gave up", job.State)
+	}
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "second is busy",
+			Triggers: []p2ptest.Trigger{
+				trigger(t, b, &protocol.Status{Id: id, Code: protocol.StatusBusy}),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(time.Second)
+	for {
+		if job, _ := api.JobStatus(id); job.State == JobBusy {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("job %s after both workers failed it", job.State)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
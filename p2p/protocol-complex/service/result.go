@@ -5,6 +5,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common/mclock"
+
 	"../protocol"
 )
 
@@ -18,7 +20,7 @@ type ResultSinkFunc func(data interface{})
 type resultEntry struct {
 	*protocol.Result
 	prid    protocol.ID // was result.ID?
-	expires time.Time
+	expires mclock.AbsTime
 }
 
 // TODO: revert to normal map instead of sync.Map
@@ -30,18 +32,20 @@ type resultStore struct {
 	capacity     int            // amount of results possible to store
 	releaseDelay time.Duration  // time before a result expires and should be passed to sinkFunc
 	sinkFunc     ResultSinkFunc // callback to pass data to when result has expired
+	clock        mclock.Clock
 
 	mu  sync.RWMutex
 	ctx context.Context
 }
 
-func newResultStore(ctx context.Context, sinkFunc ResultSinkFunc) *resultStore {
+func newResultStore(ctx context.Context, sinkFunc ResultSinkFunc, clock mclock.Clock) *resultStore {
 	return &resultStore{
 		entries: make([]*resultEntry, defaultResultsCapacity),
 		//idx:          make(map[protocol.ID]int),
 		releaseDelay: defaultResultsReleaseDelay,
 		capacity:     defaultResultsCapacity,
 		sinkFunc:     sinkFunc,
+		clock:        clock,
 		ctx:          ctx,
 	}
 }
@@ -55,7 +59,7 @@ func (self *resultStore) Put(id protocol.ID, res *protocol.Result) bool {
 	self.entries[self.counter] = &resultEntry{
 		Result:  res,
 		prid:    id,
-		expires: time.Now().Add(self.releaseDelay),
+		expires: self.clock.Now().Add(self.releaseDelay),
 	}
 	self.idx.Store(id, self.counter)
 	self.counter++
@@ -111,12 +115,10 @@ func (self *resultStore) full() bool {
 func (self *resultStore) Start() {
 	go func() {
 		for {
-			timer := time.NewTimer(self.releaseDelay)
 			select {
 			case <-self.ctx.Done():
-				timer.Stop()
 				return
-			case <-timer.C:
+			case <-self.clock.After(self.releaseDelay):
 			}
 			self.prune()
 		}
@@ -126,12 +128,13 @@ func (self *resultStore) Start() {
 // TODO: this procedure needs priority control, so it doesn't block for too long
 func (self *resultStore) prune() {
 	i := 0
+	now := self.clock.Now()
 	self.idx.Range(func(k interface{}, n interface{}) bool {
 		i++
 		prid := k.(protocol.ID)
 		self.mu.Lock()
 		e := self.entries[n.(int)]
-		if e.expires.Before(time.Now()) {
+		if e.expires <= now {
 			self.del(prid)
 			if self.sinkFunc != nil {
 				self.sinkFunc(e.Result)
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+
+	"../protocol"
+)
+
+// a job waiting for a free job slot
+type queuedJob struct {
+	msg      *protocol.Request
+	peer     *protocols.Peer
+	received mclock.AbsTime
+}
+
+// the request timeout counts from when the request came in, not from when we start on it
+func (self *queuedJob) expired(now mclock.AbsTime) bool {
+	return self.msg.Timeout > 0 && time.Duration(now-self.received) >= time.Duration(self.msg.Timeout)*time.Millisecond
+}
+
+// SubmitterStats tells how one submitter's jobs fared in the queue
+type SubmitterStats struct {
+	Queued  int     `json:"queued"`  // jobs waiting right now
+	Started uint64  `json:"started"` // jobs taken on, right away or from the queue
+	Busy    uint64  `json:"busy"`    // requests turned away because the submitter's queue was full
+	Expired uint64  `json:"expired"` // jobs that expired while waiting
+	MaxWait float64 `json:"maxWait"` // longest time a job waited in the queue, in seconds
+}
+
+// SchedulerStats tells how the job slots were shared between the submitters, by peer id
+type SchedulerStats struct {
+	QueueSize  int                        `json:"queueSize"`
+	Submitters map[string]*SubmitterStats `json:"submitters"`
+}
+
+// scheduler keeps a queue of waiting jobs for each submitter, and hands out free job slots to them in turn
+//
+// No submitter waits for more than one job of each of the others, however many jobs they send,
+// so a peer that floods the worker can't starve the rest
+type scheduler struct {
+	queueSize int                                 // jobs a submitter may have waiting, 0 queues none
+	queues    map[*protocols.Peer][]*queuedJob    // the waiting jobs of each submitter, oldest first
+	order     []*protocols.Peer                   // the submitters with waiting jobs, next to be served first
+	stats     map[*protocols.Peer]*SubmitterStats // kept for as long as we know the submitter
+
+	mu sync.Mutex
+}
+
+func newScheduler(queueSize int) *scheduler {
+	return &scheduler{
+		queueSize: queueSize,
+		queues:    make(map[*protocols.Peer][]*queuedJob),
+		stats:     make(map[*protocols.Peer]*SubmitterStats),
+	}
+}
+
+// must be called with the lock held
+func (self *scheduler) submitter(p *protocols.Peer) *SubmitterStats {
+	st, ok := self.stats[p]
+	if !ok {
+		st = &SubmitterStats{}
+		self.stats[p] = st
+	}
+	return st
+}
+
+// queues a job, returns false if the submitter has too many waiting already
+func (self *scheduler) Push(job *queuedJob) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	st := self.submitter(job.peer)
+	q := self.queues[job.peer]
+	if len(q) >= self.queueSize {
+		st.Busy++
+		return false
+	}
+	if len(q) == 0 {
+		self.order = append(self.order, job.peer)
+	}
+	self.queues[job.peer] = append(q, job)
+	st.Queued++
+	return true
+}
+
+// takes the oldest job of the submitter whose turn it is, or nil if none are waiting
+//
+// the caller starts the job, or answers it if it expired while waiting
+func (self *scheduler) Pop(now mclock.AbsTime) *queuedJob {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if len(self.order) == 0 {
+		return nil
+	}
+	p := self.order[0]
+	self.order = self.order[1:]
+	q := self.queues[p]
+	job := q[0]
+	if len(q) == 1 {
+		delete(self.queues, p)
+	} else {
+		self.queues[p] = q[1:]
+		self.order = append(self.order, p)
+	}
+
+	st := self.submitter(p)
+	st.Queued--
+	if job.expired(now) {
+		st.Expired++
+		return job
+	}
+	if wait := time.Duration(now - job.received).Seconds(); wait > st.MaxWait {
+		st.MaxWait = wait
+	}
+	st.Started++
+	return job
+}
+
+// counts a job that was started without waiting
+func (self *scheduler) Started(p *protocols.Peer) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.submitter(p).Started++
+}
+
+// takes a waiting job out of the queue, returns false if it isn't there
+func (self *scheduler) Remove(p *protocols.Peer, id protocol.ID) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	q := self.queues[p]
+	for i, job := range q {
+		if job.msg.Id != id {
+			continue
+		}
+		self.queues[p] = append(q[:i:i], q[i+1:]...)
+		self.submitter(p).Queued--
+		if len(self.queues[p]) == 0 {
+			delete(self.queues, p)
+			for j, o := range self.order {
+				if o == p {
+					self.order = append(self.order[:j:j], self.order[j+1:]...)
+					break
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (self *scheduler) Stats() SchedulerStats {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	stats := SchedulerStats{
+		QueueSize:  self.queueSize,
+		Submitters: make(map[string]*SubmitterStats),
+	}
+	for p, st := range self.stats {
+		s := *st
+		stats.Submitters[p.ID().String()] = &s
+	}
+	return stats
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+
+	"../protocol"
+)
+
+func newTestPeer(id byte) *protocols.Peer {
+	return protocols.NewPeer(p2p.NewPeer(enode.ID{id}, "test", nil), nil, protocol.Spec)
+}
+
+func newQueuedJob(p *protocols.Peer, id byte, timeout uint32, received mclock.AbsTime) *queuedJob {
+	return &queuedJob{
+		msg:      &protocol.Request{Id: protocol.ID{id}, Timeout: timeout},
+		peer:     p,
+		received: received,
+	}
+}
+
+// a submitter flooding the queue gets its turn like the others, no more
+func TestSchedulerRoundRobin(t *testing.T) {
+	s := newScheduler(3)
+	a, b, c := newTestPeer(1), newTestPeer(2), newTestPeer(3)
+	for i := byte(1); i <= 4; i++ {
+		ok := s.Push(newQueuedJob(a, 10+i, 0, 0))
+		if i <= 3 && !ok {
+			t.Fatalf("job %d of a not queued", i)
+		} else if i > 3 && ok {
+			t.Fatal("queued beyond the queue size")
+		}
+	}
+	s.Push(newQueuedJob(b, 21, 0, 0))
+	s.Push(newQueuedJob(c, 31, 0, 0))
+	s.Push(newQueuedJob(b, 22, 0, 0))
+
+	want := []byte{11, 21, 31, 12, 22, 13}
+	for i, id := range want {
+		job := s.Pop(mclock.AbsTime(time.Duration(i+1) * time.Second))
+		if job == nil {
+			t.Fatalf("queue empty after %d jobs", i)
+		}
+		if job.msg.Id != (protocol.ID{id}) {
+			t.Fatalf("job %d: got %x, want %x", i, job.msg.Id[0], id)
+		}
+	}
+	if job := s.Pop(0); job != nil {
+		t.Fatalf("unexpected job %x", job.msg.Id)
+	}
+
+	stats := s.Stats()
+	for p, want := range map[*protocols.Peer]SubmitterStats{
+		a: {Started: 3, Busy: 1, MaxWait: 6},
+		b: {Started: 2, MaxWait: 5},
+		c: {Started: 1, MaxWait: 3},
+	} {
+		if got := stats.Submitters[p.ID().String()]; got == nil || *got != want {
+			t.Fatalf("peer %x: got stats %+v, want %+v", p.ID().Bytes()[:1], got, want)
+		}
+	}
+}
+
+// a job whose request timed out while it waited is handed out as expired
+func TestSchedulerExpired(t *testing.T) {
+	s := newScheduler(2)
+	a := newTestPeer(1)
+	s.Push(newQueuedJob(a, 1, 100, 0))
+	s.Push(newQueuedJob(a, 2, 0, 0))
+
+	now := mclock.AbsTime(100 * time.Millisecond)
+	if job := s.Pop(now); job == nil || !job.expired(now) {
+		t.Fatal("job with passed timeout not expired")
+	}
+	if job := s.Pop(now); job == nil || job.expired(now) {
+		t.Fatal("job without timeout expired")
+	}
+	st := s.Stats().Submitters[a.ID().String()]
+	if st.Expired != 1 || st.Started != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestSchedulerRemove(t *testing.T) {
+	s := newScheduler(2)
+	a, b := newTestPeer(1), newTestPeer(2)
+	s.Push(newQueuedJob(a, 1, 0, 0))
+	s.Push(newQueuedJob(b, 2, 0, 0))
+	s.Push(newQueuedJob(b, 3, 0, 0))
+
+	if !s.Remove(a, protocol.ID{1}) {
+		t.Fatal("queued job not removed")
+	}
+	if s.Remove(a, protocol.ID{2}) {
+		t.Fatal("removed a job of another peer")
+	}
+	if !s.Remove(b, protocol.ID{2}) {
+		t.Fatal("queued job not removed")
+	}
+	if job := s.Pop(0); job == nil || job.msg.Id != (protocol.ID{3}) {
+		t.Fatal("remaining job not handed out")
+	}
+	if job := s.Pop(0); job != nil {
+		t.Fatalf("unexpected job %x", job.msg.Id)
+	}
+}
@@ -4,19 +4,46 @@ import (
 	"context"
 	"crypto/sha1"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
+	"runtime/pprof"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/protocols"
 	"github.com/ethereum/go-ethereum/rpc"
 
+	"../capability"
 	"../protocol"
+	"./pow"
 )
 
+var errNoWorker = errors.New("no worker takes jobs of this difficulty")
+
+// how long a request too hard for us waits for its status to be sent, before the peer is dropped anyway
+const statusSendTimeout = time.Second
+
+// what we know about a connected peer
+type peerState struct {
+	skills  *protocol.Skills  // the last skills the peer announced, nil until it does
+	caps    protocol.PeerCaps // what we negotiated from the skills, none of the features until they come
+	pending int               // requests sent to the peer that haven't been answered yet
+}
+
+// a job we're working on, as the peer that requested it knows it
+type jobKey struct {
+	peer *protocols.Peer
+	id   protocol.ID
+}
+
 // TODO: Change the id to sha1(peerid|data|submits.lastid), so moocher can find it in resource updates later
 // Demo implements the node.Service interface
 type Demo struct {
@@ -26,21 +53,42 @@ type Demo struct {
 	running bool
 
 	// worker mode params
-	maxJobs       int           // maximum number of simultaneous hashing jobs the node will accept
-	currentJobs   int           // how many jobs currently executing
-	maxDifficulty uint8         // the maximum difficulty of jobs this node will handle
-	maxTimePerJob time.Duration // maximum time one hashing job will run
+	maxJobs       int               // maximum number of simultaneous hashing jobs the node will accept
+	currentJobs   int               // how many jobs currently executing
+	maxDifficulty uint8             // the maximum difficulty of jobs this node will handle
+	minDifficulty uint8             // the minimum difficulty of jobs this node will handle
+	maxTimePerJob time.Duration     // maximum time one hashing job will run
+	skillsVersion uint32            // increased every time the skills we announce change
+	jobs          map[jobKey]func() // cancels the jobs currently executing
+	sched         *scheduler        // the jobs waiting for a free slot
+	progressEvery time.Duration     // how often we tell the submitter how far along a job is, 0 never
 
 	// moocher mode params
-	workers             map[*protocols.Peer]uint8 // an address book of hasher peers for nodes that send requests
+	peers               map[*protocols.Peer]*peerState // an address book of the connected peers, with the skills they announced
 	submitDelay         time.Duration
+	submitTimeout       time.Duration
 	submitDataSize      int
 	minSubmitDifficulty uint8
 	maxSubmitDifficulty uint8
+	fanOut              int // workers each job we submit is sent to at once, the first correct result is taken
+
+	submits  *submitStore
+	progress event.Feed // the progress reports on the jobs we submitted, for the rpc subscriptions
+	results  *resultStore
+	cache    *jobCache
+	save     SaveFunc
 
-	submits *submitStore
-	results *resultStore
-	save    SaveFunc
+	// the proof of work algorithm jobs are mined and checked with
+	pow pow.Pow
+
+	// all timing goes through the clock, so tests can use a simulated one
+	clock mclock.Clock
+
+	// the version and features we announce in our skills
+	caps capability.Caps
+
+	// key and value pairs our goroutines are labelled with in cpu profiles, none if empty
+	labels []string
 
 	// internal stuff
 	protocol *p2p.Protocol
@@ -54,14 +102,24 @@ type SaveFunc func(nid []byte, mid protocol.ID, difficulty uint8, data []byte, n
 type DemoParams struct {
 	Id                  []byte
 	MaxDifficulty       uint8
+	MinDifficulty       uint8
 	MaxJobs             int
 	MaxTimePerJob       time.Duration
 	SubmitDelay         time.Duration
+	SubmitTimeout       time.Duration // how long workers may take for the jobs we submit, 0 leaves it to them
 	SubmitDataSize      int
 	MaxSubmitDifficulty uint8
 	MinSubmitDifficulty uint8
 	ResultSink          ResultSinkFunc
 	Save                SaveFunc
+	Clock               mclock.Clock      // defaults to the system clock
+	CacheSize           int               // amount of job results to keep for answering the same job again, 0 for none
+	Pow                 pow.Pow           // defaults to the sha1 search, all nodes must use the same
+	QueueSize           int               // jobs each submitter may have waiting when all job slots are taken, 0 answers them busy
+	ProgressInterval    time.Duration     // how often to report the progress of a job to the submitter, 0 for never
+	Caps                capability.Caps   // the features to announce, defaults to all of protocol.Caps; less makes the node act like an older one
+	FanOut              int               // workers each job we submit is sent to at once, 0 for one
+	ProfileLabels       map[string]string // labels of the goroutines of the node in cpu profiles, to tell the nodes of a process apart
 }
 
 func NewDemoParams(sinkFunc ResultSinkFunc, saveFunc SaveFunc) *DemoParams {
@@ -72,24 +130,54 @@ func NewDemoParams(sinkFunc ResultSinkFunc, saveFunc SaveFunc) *DemoParams {
 }
 
 func NewDemo(params *DemoParams) (*Demo, error) {
+	clock := params.Clock
+	if clock == nil {
+		clock = mclock.System{}
+	}
+	pw := params.Pow
+	if pw == nil {
+		pw = pow.NewSha1()
+	}
+	caps := params.Caps
+	if caps == (capability.Caps{}) {
+		caps = protocol.Caps
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	d := &Demo{
 		id:                  params.Id,
 		running:             true,
 		maxJobs:             params.MaxJobs,
 		maxDifficulty:       params.MaxDifficulty,
+		minDifficulty:       params.MinDifficulty,
 		maxTimePerJob:       params.MaxTimePerJob,
 		submitDelay:         params.SubmitDelay,
+		submitTimeout:       params.SubmitTimeout,
 		submitDataSize:      params.SubmitDataSize,
 		maxSubmitDifficulty: params.MaxSubmitDifficulty,
 		minSubmitDifficulty: params.MinSubmitDifficulty,
-		workers:             make(map[*protocols.Peer]uint8),
-		submits:             newSubmitStore(),
-		results:             newResultStore(ctx, params.ResultSink),
+		fanOut:              params.FanOut,
+		jobs:                make(map[jobKey]func()),
+		sched:               newScheduler(params.QueueSize),
+		progressEvery:       params.ProgressInterval,
+		peers:               make(map[*protocols.Peer]*peerState),
+		submits:             newSubmitStore(clock),
+		results:             newResultStore(ctx, params.ResultSink, clock),
+		cache:               newJobCache(params.CacheSize),
 		save:                params.Save,
+		pow:                 pw,
+		clock:               clock,
+		caps:                caps,
 		ctx:                 ctx,
 		cancel:              cancel,
 	}
+	keys := make([]string, 0, len(params.ProfileLabels))
+	for k := range params.ProfileLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		d.labels = append(d.labels, k, params.ProfileLabels[k])
+	}
 	if err := d.initProtocol(); err != nil {
 		return nil, err
 	}
@@ -120,6 +208,9 @@ func (self *Demo) initProtocol() error {
 	proto.StatusHandler = self.statusHandlerLocked
 	proto.RequestHandler = self.requestHandlerLocked
 	proto.ResultHandler = self.resultHandlerLocked
+	proto.CancelHandler = self.cancelHandlerLocked
+	proto.ProgressHandler = self.progressHandler
+	proto.DropHook = self.dropPeer
 	if err := proto.Init(); err != nil {
 		return fmt.Errorf("can't init demo protocol")
 	}
@@ -140,6 +231,13 @@ func (self *Demo) Protocols() (protos []p2p.Protocol) {
 }
 
 func (self *Demo) Start(srv *p2p.Server) error {
+	// the goroutines started here take the labels with them
+	if len(self.labels) > 0 {
+		pprof.Do(context.Background(), pprof.Labels(self.labels...), func(context.Context) {
+			self.results.Start()
+		})
+		return nil
+	}
 	self.results.Start()
 	return nil
 }
@@ -152,31 +250,37 @@ func (self *Demo) Stop() error {
 
 // The protocol code provides Hook to run when protocol starts on a peer
 func (self *Demo) Run(p *protocols.Peer) error {
-	self.mu.RLock()
+	// the hook runs on the goroutine that handles the messages of the peer, and the jobs and submits are started from it
+	if len(self.labels) > 0 {
+		pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels(self.labels...)))
+	}
+	self.mu.Lock()
 	log.Info("run protocol hook", "peer", p, "difficulty", self.maxDifficulty)
-	self.mu.RUnlock()
+	self.peers[p] = &peerState{}
+	skills := self.skills()
+	self.mu.Unlock()
 
 	go func(self *Demo, p *protocols.Peer) {
-		self.mu.RLock()
-		maxdifficulty := self.maxDifficulty
-		self.mu.RUnlock()
-		p.Send(context.TODO(),
-			&protocol.Skills{
-				Difficulty: maxdifficulty,
-			},
-		)
-		if maxdifficulty > 0 {
+		p.Send(context.TODO(), skills)
+		if skills.Difficulty > 0 {
 			return
 		}
-		data := make([]byte, self.submitDataSize)
-		tick := time.NewTicker(self.submitDelay)
 		for {
+			// the delay may be changed by a reload
+			self.mu.RLock()
+			delay := self.submitDelay
+			_, connected := self.peers[p]
+			self.mu.RUnlock()
+			if !connected {
+				return
+			}
 			select {
 			case <-self.ctx.Done():
-				tick.Stop()
 				return
-			case <-tick.C:
+			case <-self.clock.After(delay):
 			}
+			// the submit store keeps the data to check the result against, so each job needs its own
+			data := make([]byte, self.submitDataSize)
 			_, err := rand.Read(data)
 			if err != nil {
 				return
@@ -184,9 +288,14 @@ func (self *Demo) Run(p *protocols.Peer) error {
 			self.mu.RLock()
 			difficulty := rand.Intn(int(self.maxSubmitDifficulty-self.minSubmitDifficulty)) + int(self.minSubmitDifficulty)
 			self.mu.RUnlock()
-			prid, err := self.submitRequest(data, uint8(difficulty))
-			if err != nil {
-				return
+			prid, err := self.submitRequest(data, uint8(difficulty), self.submitTimeout)
+			if err == errNoWorker {
+				log.Debug("no worker for job", "nid", fmt.Sprintf("%x", self.id[:8]), "difficulty", difficulty)
+				continue
+			} else if err != nil {
+				// the worker may have dropped before we knew, the next job goes to another
+				log.Debug("submit fail", "nid", fmt.Sprintf("%x", self.id[:8]), "err", err)
+				continue
 			}
 			log.Debug("submitted job", "nid", fmt.Sprintf("%x", self.id[:8]), "prid", fmt.Sprintf("%x", prid))
 		}
@@ -195,44 +304,223 @@ func (self *Demo) Run(p *protocols.Peer) error {
 	return nil
 }
 
+// forgets the peer, so no more jobs are sent to it
+func (self *Demo) dropPeer(p *protocols.Peer) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	delete(self.peers, p)
+	log.Debug("peer dropped", "peer", p, "peers", len(self.peers))
+}
+
+// the skills we announce to our peers
+// must be called with the lock held
+func (self *Demo) skills() *protocol.Skills {
+	return &protocol.Skills{
+		Difficulty:    self.maxDifficulty,
+		MinDifficulty: self.minDifficulty,
+		Capacity:      uint16(self.maxJobs),
+		Version:       self.skillsVersion,
+		Caps:          self.caps,
+	}
+}
+
+// what we negotiated with the peer
+// must be called with the lock held
+func (self *Demo) peerCaps(p *protocols.Peer) protocol.PeerCaps {
+	if st, ok := self.peers[p]; ok {
+		return st.caps
+	}
+	return protocol.PeerCaps{}
+}
+
+// changes the difficulties we take jobs for, and tells all our peers
+func (self *Demo) setDifficulty(min uint8, max uint8) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if min == self.minDifficulty && max == self.maxDifficulty {
+		return
+	}
+	self.minDifficulty = min
+	self.maxDifficulty = max
+	self.announceSkills()
+}
+
+// tells all our peers our skills changed
+// must be called with the lock held
+func (self *Demo) announceSkills() {
+	self.skillsVersion++
+	skills := self.skills()
+	for p := range self.peers {
+		go p.Send(context.TODO(), skills)
+	}
+	log.Debug("announced skills", "skills", skills, "peers", len(self.peers))
+}
+
+// the peer with the least unanswered requests among those taking jobs of the difficulty and not full
+// must be called with the lock held
 func (self *Demo) getNextWorker(difficulty uint8) *protocols.Peer {
-	for p, d := range self.workers {
-		if d >= difficulty {
-			return p
+	workers := self.getNextWorkers(difficulty, 1)
+	if len(workers) == 0 {
+		return nil
+	}
+	return workers[0]
+}
+
+// up to n of the peers taking jobs of the difficulty and not full, those with the least unanswered requests first
+// must be called with the lock held
+func (self *Demo) getNextWorkers(difficulty uint8, n int) []*protocols.Peer {
+	var workers []*protocols.Peer
+	for p, st := range self.peers {
+		if st.skills == nil || !st.skills.Covers(difficulty) || st.pending >= int(st.skills.Capacity) {
+			continue
 		}
+		workers = append(workers, p)
 	}
-	return nil
+	sort.SliceStable(workers, func(i, j int) bool {
+		return self.peers[workers[i]].pending < self.peers[workers[j]].pending
+	})
+	if len(workers) > n {
+		workers = workers[:n]
+	}
+	return workers
+}
+
+// the connected peers of the ids
+// must be called with the lock held
+func (self *Demo) peersByID(ids []string) []*protocols.Peer {
+	var peers []*protocols.Peer
+	for p := range self.peers {
+		for _, id := range ids {
+			if p.ID().String() == id {
+				peers = append(peers, p)
+				break
+			}
+		}
+	}
+	return peers
 }
 
-func (self *Demo) submitRequest(data []byte, difficulty uint8) (protocol.ID, error) {
+// sends the job to as many workers as we fan out to, or as there are if fewer
+// the job is only refused when it made it to none of them
+func (self *Demo) submitRequest(data []byte, difficulty uint8, timeout time.Duration) (protocol.ID, error) {
 	self.mu.Lock()
-	p := self.getNextWorker(difficulty)
-	if p == nil {
-		return protocol.ID{}, fmt.Errorf("Couldn't find any workers for difficulty %d", difficulty)
+	fanOut := self.fanOut
+	if fanOut < 1 {
+		fanOut = 1
+	}
+	workers := self.getNextWorkers(difficulty, fanOut)
+	if len(workers) == 0 {
+		self.mu.Unlock()
+		return protocol.ID{}, errNoWorker
 	}
 	id := newID(data, self.submits.IncSerial())
+	ids := make([]string, len(workers))
+	for i, p := range workers {
+		self.peers[p].pending++
+		ids[i] = p.ID().String()
+	}
 	self.mu.Unlock()
-	//go func(id protocol.ID) {
 	req := &protocol.Request{
 		Id:         id,
 		Data:       data,
 		Difficulty: difficulty,
+		Timeout:    uint32(timeout / time.Millisecond),
+	}
+	// stored before sending, so it's there when the answer comes
+	if err := self.submits.Put(req, id, ids...); err != nil {
+		log.Error("submits put fail", "err", err)
 	}
-	err := p.Send(context.TODO(), req)
-	if err == nil {
-		if err := self.submits.Put(req, id); err != nil {
-			log.Error("submits put fail", "err", err)
+	var sent int
+	var err error
+	for _, p := range workers {
+		if e := p.Send(context.TODO(), req); e != nil {
+			err = e
+			self.submits.Unsent(id, p.ID().String())
+			self.mu.Lock()
+			self.answered(p)
+			self.mu.Unlock()
+			continue
 		}
+		sent++
+	}
+	if sent > 0 {
+		return id, nil
 	}
-	//}(id)
 	return id, err
 }
 
+// tells the workers we no longer want the result of a job we submitted
+func (self *Demo) cancelJob(id protocol.ID) error {
+	info := self.submits.GetInfo(id)
+	if info == nil {
+		return fmt.Errorf("unknown job %x", id)
+	}
+	if info.State != JobPending {
+		return fmt.Errorf("job %x is %s already", id, info.State)
+	}
+	self.mu.Lock()
+	workers := self.peersByID(self.submits.Waiting(id))
+	if len(workers) == 0 {
+		self.mu.Unlock()
+		return fmt.Errorf("worker of job %x is gone", id)
+	}
+	self.submits.SetState(id, JobCancelled)
+	cancel := self.cancelWorkers(id, workers)
+	self.mu.Unlock()
+	var err error
+	for _, p := range cancel {
+		if e := p.Send(context.TODO(), &protocol.Cancel{Id: id}); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// takes the workers of a job off the books, and returns those to send the Cancel to
+// a worker that doesn't know the message works on, and the result is ignored when it comes
+// must be called with the lock held
+func (self *Demo) cancelWorkers(id protocol.ID, workers []*protocols.Peer) []*protocols.Peer {
+	var cancel []*protocols.Peer
+	for _, p := range workers {
+		self.answered(p)
+		caps := self.peerCaps(p)
+		if !caps.Cancel() {
+			log.Debug("worker can't cancel", "id", fmt.Sprintf("%x", id), "caps", caps)
+			continue
+		}
+		cancel = append(cancel, p)
+	}
+	return cancel
+}
+
+// a request we sent to the peer has been answered, one way or another
+// must be called with the lock held
+func (self *Demo) answered(p *protocols.Peer) {
+	st, ok := self.peers[p]
+	if ok && st.pending > 0 {
+		st.pending--
+	}
+}
+
 func (self *Demo) skillsHandlerLocked(msg *protocol.Skills, p *protocols.Peer) error {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 	log.Trace("have skills type", "msg", msg, "peer", p)
-	self.workers[p] = msg.Difficulty
+	st, ok := self.peers[p]
+	if !ok {
+		st = &peerState{}
+		self.peers[p] = st
+	}
+	if st.skills != nil && msg.Version < st.skills.Version {
+		log.Debug("ignored old skills", "version", msg.Version, "have", st.skills.Version, "peer", p)
+		return nil
+	}
+	st.skills = msg
+	caps := protocol.NegotiateCaps(self.caps, msg.Caps)
+	if caps != st.caps {
+		log.Debug("negotiated caps", "caps", caps, "peer", p)
+	}
+	st.caps = caps
 	return nil
 }
 
@@ -242,6 +530,24 @@ func (self *Demo) statusHandlerLocked(msg *protocol.Status, p *protocols.Peer) e
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
+	// a job fanned out only fails when all its workers do
+	worker := p.ID().String()
+	if msg.Code != protocol.StatusThanksABunch && msg.Code != protocol.StatusCached && self.submits.IsWaiting(msg.Id, worker) {
+		self.answered(p)
+	}
+	switch msg.Code {
+	case protocol.StatusBusy:
+		self.submits.Failed(msg.Id, worker, JobBusy)
+	case protocol.StatusAreYouKidding:
+		self.submits.Failed(msg.Id, worker, JobRejected)
+	case protocol.StatusGaveup:
+		self.submits.Failed(msg.Id, worker, JobGaveup)
+	case protocol.StatusCached:
+		self.submits.SetCached(msg.Id)
+	case protocol.StatusExpired:
+		self.submits.Failed(msg.Id, worker, JobExpired)
+	}
+
 	switch msg.Code {
 	case protocol.StatusThanksABunch:
 		if self.IsWorker() {
@@ -257,12 +563,22 @@ func (self *Demo) statusHandlerLocked(msg *protocol.Status, p *protocols.Peer) e
 		if self.IsWorker() {
 			return nil
 		}
-		log.Debug("we sent wrong difficulty or it changed. please implement adjusting it")
+		log.Debug("we sent wrong difficulty, the peer's skills changed before we heard about it")
 	case protocol.StatusGaveup:
 		if self.IsWorker() {
 			return nil
 		}
 		log.Debug("peer gave up on the job. please implement how to select someone else for the job")
+	case protocol.StatusCached:
+		if self.IsWorker() {
+			return nil
+		}
+		log.Debug("peer had the result cached", "id", fmt.Sprintf("%x", msg.Id))
+	case protocol.StatusExpired:
+		if self.IsWorker() {
+			return nil
+		}
+		log.Debug("job expired before the peer was done", "id", fmt.Sprintf("%x", msg.Id))
 	}
 
 	return nil
@@ -270,12 +586,70 @@ func (self *Demo) statusHandlerLocked(msg *protocol.Status, p *protocols.Peer) e
 
 func (self *Demo) requestHandlerLocked(msg *protocol.Request, p *protocols.Peer) error {
 
+	self.mu.RLock()
+	tooHard := self.maxDifficulty < msg.Difficulty || self.minDifficulty > msg.Difficulty
+	self.mu.RUnlock()
+	if tooHard {
+		// the peer is dropped when we return the error, so the status is sent first
+		// without the lock, so a slow peer doesn't hold up the other handlers, and for so long only
+		errC := make(chan error, 1)
+		go func() {
+			errC <- p.Send(
+				context.TODO(),
+				&protocol.Status{
+					Id:   msg.Id,
+					Code: protocol.StatusAreYouKidding,
+				},
+			)
+		}()
+		select {
+		case err := <-errC:
+			if err != nil {
+				log.Debug("send too hard status fail", "id", fmt.Sprintf("%x", msg.Id), "err", err)
+			}
+		case <-self.clock.After(statusSendTimeout):
+			log.Debug("send too hard status timed out", "id", fmt.Sprintf("%x", msg.Id))
+		}
+		return fmt.Errorf("too hard!")
+	}
+
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
 	log.Trace("have request type", "msg", msg, "currentjobs", self.currentJobs, "ourdifficulty", self.maxDifficulty, "peer", p)
 
+	// the same job done before is answered right away
+	key := newCacheKey(msg.Data, msg.Difficulty)
+	if nonce, hash := self.cache.Get(key); hash != nil && !self.results.IsFull() {
+		res := &protocol.Result{
+			Id:    msg.Id,
+			Nonce: nonce,
+			Hash:  hash,
+		}
+		self.results.Put(msg.Id, res)
+		cached := self.peerCaps(p).Cached()
+		go func() {
+			if cached {
+				p.Send(context.TODO(),
+					&protocol.Status{
+						Id:   msg.Id,
+						Code: protocol.StatusCached,
+					},
+				)
+			}
+			p.Send(context.TODO(), res)
+		}()
+		log.Debug("cached job", "id", fmt.Sprintf("%x", msg.Id))
+		return nil
+	}
+
+	// when all job slots are taken the job waits its turn, if there's room in the submitter's queue
+	now := self.clock.Now()
 	if self.currentJobs >= self.maxJobs || self.results.IsFull() {
+		if self.sched.Push(&queuedJob{msg: msg, peer: p, received: now}) {
+			log.Debug("queued job", "id", fmt.Sprintf("%x", msg.Id), "peer", p.ID().TerminalString())
+			return nil
+		}
 		go p.Send(context.TODO(),
 			&protocol.Status{
 				Id:   msg.Id,
@@ -285,35 +659,96 @@ func (self *Demo) requestHandlerLocked(msg *protocol.Request, p *protocols.Peer)
 		log.Error("Too busy!")
 		return nil
 	}
+	self.sched.Started(p)
+	self.startJob(msg, p, now)
+	return nil
+}
 
-	if self.maxDifficulty < msg.Difficulty {
-		go p.Send(
-			context.TODO(),
-			&protocol.Status{
-				Id:   msg.Id,
-				Code: protocol.StatusAreYouKidding,
-			},
-		)
-		return fmt.Errorf("too hard!")
+// gives the free job slots to the jobs waiting in the queues
+// must be called with the lock held
+func (self *Demo) schedule() {
+	for self.currentJobs < self.maxJobs && !self.results.IsFull() {
+		now := self.clock.Now()
+		job := self.sched.Pop(now)
+		if job == nil {
+			return
+		}
+		if job.expired(now) {
+			go job.peer.Send(
+				context.TODO(),
+				&protocol.Status{
+					Id:   job.msg.Id,
+					Code: self.expiredCode(job.peer),
+				},
+			)
+			log.Debug("expired in queue", "id", fmt.Sprintf("%x", job.msg.Id))
+			continue
+		}
+		self.startJob(job.msg, job.peer, job.received)
+	}
+}
+
+// takes a job slot and works on the job
+// must be called with the lock held
+func (self *Demo) startJob(msg *protocol.Request, p *protocols.Peer, received mclock.AbsTime) {
+	key := newCacheKey(msg.Data, msg.Difficulty)
+
+	// the requester may want the job sooner than we'd give up on it
+	timeout := self.maxTimePerJob
+	expires := false
+	if msg.Timeout > 0 {
+		left := time.Duration(msg.Timeout)*time.Millisecond - time.Duration(self.clock.Now()-received)
+		if left < timeout {
+			timeout = left
+			expires = true
+		}
 	}
+	ctx, cancel := self.withTimeout(self.ctx, timeout)
+	job := jobKey{p, msg.Id}
+	self.jobs[job] = cancel
 	self.currentJobs++
+	progress := self.progressEvery > 0 && self.peerCaps(p).Progress()
+	expiredCode := self.expiredCode(p)
 
 	go func(msg *protocol.Request) {
-		ctx, cancel := context.WithTimeout(self.ctx, self.maxTimePerJob)
 		defer cancel()
 
 		log.Debug("took job", "id", fmt.Sprintf("%x", msg.Id), "peer", p.ID().TerminalString)
-		j, err := doJob(ctx, msg.Data, msg.Difficulty)
+		var hashes uint64
+		doneC := make(chan struct{})
+		if progress {
+			go self.reportProgress(p, msg, &hashes, doneC)
+		}
+		j, err := doJob(ctx, self.pow, msg.Data, msg.Difficulty, &hashes)
+		close(doneC)
+
+		// a cancelled job has been taken off the books by the cancel handler already
+		self.mu.Lock()
+		_, ours := self.jobs[job]
+		if ours {
+			delete(self.jobs, job)
+			self.currentJobs--
+			self.schedule()
+		}
+		self.mu.Unlock()
 
 		if err != nil {
+			if !ours {
+				log.Debug("cancelled job", "id", fmt.Sprintf("%x", msg.Id))
+				return
+			}
+			code := uint8(protocol.StatusGaveup)
+			if expires {
+				code = expiredCode
+			}
 			go p.Send(
 				context.TODO(),
 				&protocol.Status{
 					Id:   msg.Id,
-					Code: protocol.StatusGaveup,
+					Code: code,
 				},
 			)
-			log.Debug("too long!")
+			log.Debug("too long!", "expired", expires)
 			return
 		}
 
@@ -324,31 +759,120 @@ func (self *Demo) requestHandlerLocked(msg *protocol.Request, p *protocols.Peer)
 		}
 
 		self.results.Put(msg.Id, res)
-		self.mu.Lock()
-		self.currentJobs--
-		self.mu.Unlock()
+		self.cache.Put(key, j.Nonce, j.Hash)
 
 		go p.Send(context.TODO(), res)
 
 		log.Debug("finished job", "id", fmt.Sprintf("%x", msg.Id), "nonce", j.Nonce, "hash", j.Hash)
 	}(msg)
+}
+
+// the status telling the peer its request timed out, which a peer that doesn't know StatusExpired takes for giving up
+// must be called with the lock held
+func (self *Demo) expiredCode(p *protocols.Peer) uint8 {
+	if self.peerCaps(p).Expiry() {
+		return protocol.StatusExpired
+	}
+	return protocol.StatusGaveup
+}
+
+// tells the submitter how far along the job is, every progress interval until done is closed
+func (self *Demo) reportProgress(p *protocols.Peer, msg *protocol.Request, hashes *uint64, doneC chan struct{}) {
+	start := self.clock.Now()
+	// a difficulty of d takes 2^d hashes on average
+	expected := math.Exp2(float64(msg.Difficulty))
+	for {
+		select {
+		case <-doneC:
+			return
+		case <-self.clock.After(self.progressEvery):
+		}
+		n := atomic.LoadUint64(hashes)
+		elapsed := time.Duration(self.clock.Now() - start)
+		var eta float64
+		if n > 0 && float64(n) < expected {
+			eta = (expected - float64(n)) / float64(n) * float64(elapsed)
+		}
+		p.Send(context.TODO(), &protocol.Progress{
+			Id:      msg.Id,
+			Hashes:  n,
+			Elapsed: millis(float64(elapsed)),
+			Eta:     millis(eta),
+		})
+	}
+}
+
+// the nanoseconds in whole milliseconds, as far as they fit
+// the eta of a hard job is way beyond what a time.Duration holds
+func millis(ns float64) uint32 {
+	ms := ns / float64(time.Millisecond)
+	if ms < 0 {
+		return 0
+	} else if ms > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(ms)
+}
+
+// the peer no longer wants the result of a job it requested
+func (self *Demo) cancelHandlerLocked(msg *protocol.Cancel, p *protocols.Peer) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	log.Trace("have cancel type", "msg", msg, "peer", p)
+
+	job := jobKey{p, msg.Id}
+	cancel, ok := self.jobs[job]
+	if !ok && self.sched.Remove(p, msg.Id) {
+		log.Debug("cancelled queued job", "id", fmt.Sprintf("%x", msg.Id))
+		return nil
+	} else if !ok {
+		log.Debug("cancel for job we're not working on", "id", fmt.Sprintf("%x", msg.Id))
+		return nil // it may have finished while the cancel was on its way
+	}
+	delete(self.jobs, job)
+	self.currentJobs--
+	cancel()
+	self.schedule()
+	return nil
+}
 
+// a worker tells how far along a job we submitted is
+func (self *Demo) progressHandler(msg *protocol.Progress, p *protocols.Peer) error {
+	log.Trace("have progress type", "msg", msg, "peer", p)
+	if !self.submits.IsPending(msg.Id) {
+		return nil // it may come after the result
+	}
+	progress := JobProgress{
+		Id:      msg.Id,
+		Worker:  p.ID().String(),
+		Hashes:  msg.Hashes,
+		Elapsed: msg.Elapsed,
+		Eta:     msg.Eta,
+	}
+	self.submits.SetProgress(msg.Id, &progress)
+	self.progress.Send(progress)
 	return nil
 }
 
 func (self *Demo) resultHandlerLocked(msg *protocol.Result, p *protocols.Peer) error {
-	self.mu.RLock()
-	defer self.mu.RUnlock()
+	self.mu.Lock()
+	defer self.mu.Unlock()
 	if self.maxDifficulty > 0 {
 		log.Trace("ignored result type", "msg", msg)
 	}
 	log.Trace("got result type", "msg", msg, "peer", p)
 
-	if !self.submits.Have(msg.Id) {
+	worker := p.ID().String()
+	if self.submits.IsDuplicate(msg.Id, worker) {
+		return self.duplicateResult(msg, p)
+	}
+	if !self.submits.IsWaiting(msg.Id, worker) {
 		log.Debug("stale or fake request id", "id", fmt.Sprintf("%x", msg.Id))
 		return nil // in case it's stale not fake don't punish the peer
 	}
-	if !checkJob(msg.Hash, self.submits.GetData(msg.Id), msg.Nonce) {
+	self.answered(p)
+	if !checkJob(self.pow, msg.Hash, self.submits.GetData(msg.Id), msg.Nonce, self.submits.GetDifficulty(msg.Id)) {
+		self.submits.Failed(msg.Id, worker, JobInvalid)
 		return fmt.Errorf("Got incorrect result job %x from %s", msg.Id, p.ID())
 	}
 	go p.Send(
@@ -358,16 +882,59 @@ func (self *Demo) resultHandlerLocked(msg *protocol.Result, p *protocols.Peer) e
 			Code: protocol.StatusThanksABunch,
 		},
 	)
-	self.save(self.id, msg.Id, self.submits.GetDifficulty(msg.Id), self.submits.GetData(msg.Id), msg.Nonce, msg.Hash)
+	// the first correct result wins, the other workers of the job can stop
+	others := self.submits.SetResult(msg.Id, worker, msg.Nonce, msg.Hash)
+	for _, o := range self.cancelWorkers(msg.Id, self.peersByID(others)) {
+		go o.Send(context.TODO(), &protocol.Cancel{Id: msg.Id})
+	}
+	if len(others) > 0 {
+		log.Debug("cancelled other workers", "id", fmt.Sprintf("%x", msg.Id), "workers", len(others))
+	}
+	if self.save != nil {
+		self.save(self.id, msg.Id, self.submits.GetDifficulty(msg.Id), self.submits.GetData(msg.Id), msg.Nonce, msg.Hash)
+	}
+	return nil
+}
+
+// a worker of a job fanned out sends its result after we took another
+// it may have been done before the Cancel got there, or not know the message. A correct result is thanked for,
+// so the worker can let go of it, and counted, but the first one stays; one that doesn't check out is punished like any other
+// must be called with the lock held
+func (self *Demo) duplicateResult(msg *protocol.Result, p *protocols.Peer) error {
+	if !checkJob(self.pow, msg.Hash, self.submits.GetData(msg.Id), msg.Nonce, self.submits.GetDifficulty(msg.Id)) {
+		return fmt.Errorf("Got incorrect duplicate result job %x from %s", msg.Id, p.ID())
+	}
+	self.submits.AddDuplicate(msg.Id)
+	log.Debug("duplicate result", "id", fmt.Sprintf("%x", msg.Id), "peer", p.ID().TerminalString())
+	go p.Send(
+		context.TODO(),
+		&protocol.Status{
+			Id:   msg.Id,
+			Code: protocol.StatusThanksABunch,
+		},
+	)
 	return nil
 }
 
+// like context.WithTimeout, but the timeout is measured on the service's clock
+func (self *Demo) withTimeout(parent context.Context, d time.Duration) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-self.clock.After(d):
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
 func newID(data []byte, nonce uint64) (id protocol.ID) {
 	c := make([]byte, 8)
 	binary.LittleEndian.PutUint64(c, nonce)
 	h := sha1.New()
 	h.Write(data)
-	h.Sum(c)
+	h.Write(c)
 	copy(id[:], h.Sum(nil)[:8])
 	return id
 }
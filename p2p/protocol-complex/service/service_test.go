@@ -5,15 +5,18 @@ import (
 	"context"
 	"crypto/sha1"
 	"math/rand"
+	"runtime/pprof"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p"
-	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/rlp"
 
 	"../protocol"
+	"./pow"
 )
 
 func init() {
@@ -26,10 +29,10 @@ type testPeer struct {
 }
 
 func newPeer(s *protocols.Spec) *testPeer {
-	var nid [discover.NodeIDBits / 8]byte
+	var nid enode.ID
 	lrw, rwr := p2p.MsgPipe()
 	p := protocols.NewPeer(
-		p2p.NewPeer(discover.NodeID(nid), "testpeer", []p2p.Cap{}),
+		p2p.NewPeer(nid, "testpeer", []p2p.Cap{}),
 		lrw,
 		s,
 	)
@@ -39,10 +42,19 @@ func newPeer(s *protocols.Spec) *testPeer {
 	}
 }
 
+// messages sent by protocols.Peer come wrapped, with the actual message as payload
+func decodeMsg(msg p2p.Msg, val interface{}) error {
+	var wmsg protocols.WrappedMsg
+	if err := msg.Decode(&wmsg); err != nil {
+		return err
+	}
+	return rlp.DecodeBytes(wmsg.Payload, val)
+}
+
 func TestRequestHandler(t *testing.T) {
 
 	// make service and peer
-	s := NewDemoService(8, 3, time.Millisecond*500)
+	s := newTestDemo(t, 8, 3, time.Millisecond*500)
 	p := newPeer(protocol.Spec)
 
 	// generate data for work
@@ -53,7 +65,7 @@ func TestRequestHandler(t *testing.T) {
 	}
 
 	// inject easy request, should complete well within a second
-	s.requestHandler(&protocol.Request{
+	s.requestHandlerLocked(&protocol.Request{
 		Data:       data,
 		Difficulty: 2,
 	}, p.Peer)
@@ -61,20 +73,28 @@ func TestRequestHandler(t *testing.T) {
 	// get the response
 	rlpmsg, _ := p.rw.ReadMsg()
 	resultmsg := &protocol.Result{}
-	if err := rlpmsg.Decode(resultmsg); err != nil {
+	if err := decodeMsg(rlpmsg, resultmsg); err != nil {
 		t.Fatal(err.Error())
 	}
 
 	// inject too high difficulty
-	s.requestHandler(&protocol.Request{
+	// the handler waits for the status to be sent before it returns, and the pipe for it to be read
+	statusC := make(chan p2p.Msg, 1)
+	go func() {
+		rlpmsg, _ := p.rw.ReadMsg()
+		statusC <- rlpmsg
+	}()
+	if err := s.requestHandlerLocked(&protocol.Request{
 		Data:       data,
 		Difficulty: 9,
-	}, p.Peer)
+	}, p.Peer); err == nil {
+		t.Fatal("too hard request taken")
+	}
 
 	// get the response
-	rlpmsg, _ = p.rw.ReadMsg()
+	rlpmsg = <-statusC
 	statusmsg := &protocol.Status{}
-	if err := rlpmsg.Decode(statusmsg); err != nil {
+	if err := decodeMsg(rlpmsg, statusmsg); err != nil {
 		t.Fatal(err.Error())
 	} else if statusmsg.Code != protocol.StatusAreYouKidding {
 		t.Fatalf("Expected StatusGaveup (%d), got %d", protocol.StatusAreYouKidding, statusmsg.Code)
@@ -85,21 +105,21 @@ func TestRequestHandler(t *testing.T) {
 
 	// start three jobs (maxjobs)
 	for i := 0; i < 4; i++ {
-		go s.requestHandler(&protocol.Request{
+		go s.requestHandlerLocked(&protocol.Request{
 			Data:       data,
 			Difficulty: 128,
 		}, p.Peer)
 	}
 
 	rlpmsg, _ = p.rw.ReadMsg()
-	if err := rlpmsg.Decode(statusmsg); err != nil {
+	if err := decodeMsg(rlpmsg, statusmsg); err != nil {
 		t.Fatal(err.Error())
 	} else if statusmsg.Code != protocol.StatusBusy {
 		t.Fatalf("Expected StatusBusy (%d), got %d", protocol.StatusBusy, statusmsg.Code)
 	}
 
 	rlpmsg, _ = p.rw.ReadMsg()
-	if err := rlpmsg.Decode(statusmsg); err != nil {
+	if err := decodeMsg(rlpmsg, statusmsg); err != nil {
 		t.Fatal(err.Error())
 	} else if statusmsg.Code != protocol.StatusGaveup {
 		t.Fatalf("Expected StatusGaveup (%d), got %d", protocol.StatusGaveup, statusmsg.Code)
@@ -109,6 +129,97 @@ func TestRequestHandler(t *testing.T) {
 
 }
 
+// a worker reports the progress of a long job every progress interval, until it gives up
+func TestProgress(t *testing.T) {
+	s := newTestDemo(t, 128, 1, time.Millisecond*500)
+	defer s.Stop()
+	s.progressEvery = time.Millisecond * 100
+	p := newPeer(protocol.Spec)
+	// only peers that announced the feature get progress reports
+	s.skillsHandlerLocked(&protocol.Skills{Caps: protocol.Caps}, p.Peer)
+
+	id := protocol.ID{1}
+	s.requestHandlerLocked(&protocol.Request{
+		Id:         id,
+		Data:       []byte("foo"),
+		Difficulty: 128,
+	}, p.Peer)
+
+	var reports []*protocol.Progress
+	for {
+		msg, err := p.rw.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Code == 1 {
+			status := &protocol.Status{}
+			if err := decodeMsg(msg, status); err != nil {
+				t.Fatal(err)
+			} else if status.Code != protocol.StatusGaveup {
+				t.Fatalf("Expected StatusGaveup (%d), got %d", protocol.StatusGaveup, status.Code)
+			}
+			break
+		}
+		progress := &protocol.Progress{}
+		if err := decodeMsg(msg, progress); err != nil {
+			t.Fatal(err)
+		}
+		reports = append(reports, progress)
+	}
+
+	if len(reports) < 3 {
+		t.Fatalf("expected at least 3 progress reports, got %d", len(reports))
+	}
+	for i, r := range reports {
+		if r.Id != id {
+			t.Fatalf("report %d: id %x", i, r.Id)
+		}
+		if r.Eta == 0 {
+			t.Fatalf("report %d: no eta for a job that won't end", i)
+		}
+		if i > 0 && (r.Hashes < reports[i-1].Hashes || r.Elapsed <= reports[i-1].Elapsed) {
+			t.Fatalf("report %d: %+v doesn't follow %+v", i, r, reports[i-1])
+		}
+	}
+}
+
+// requests go to the least busy of the peers whose skills cover the difficulty
+func TestGetNextWorker(t *testing.T) {
+	s := newTestDemo(t, 0, 0, time.Second)
+	defer s.Stop()
+
+	states := []*peerState{
+		{skills: &protocol.Skills{Difficulty: 8, Capacity: 2}, pending: 1},
+		{skills: &protocol.Skills{Difficulty: 16, MinDifficulty: 8, Capacity: 2}},
+		{skills: &protocol.Skills{Difficulty: 24, MinDifficulty: 16, Capacity: 1}, pending: 1},
+		{skills: &protocol.Skills{}},
+		{},
+	}
+	peers := make([]*protocols.Peer, len(states))
+	for i, st := range states {
+		peers[i] = newPeer(protocol.Spec).Peer
+		s.peers[peers[i]] = st
+	}
+
+	for _, tt := range []struct {
+		difficulty uint8
+		worker     int // index of the peer, -1 for none
+	}{
+		{difficulty: 4, worker: 0},
+		{difficulty: 8, worker: 1},
+		{difficulty: 12, worker: 1},
+		{difficulty: 20, worker: -1}, // full
+		{difficulty: 32, worker: -1},
+	} {
+		p := s.getNextWorker(tt.difficulty)
+		if tt.worker < 0 && p != nil {
+			t.Errorf("difficulty %d: expected no worker", tt.difficulty)
+		} else if tt.worker >= 0 && p != peers[tt.worker] {
+			t.Errorf("difficulty %d: expected peer %d", tt.difficulty, tt.worker)
+		}
+	}
+}
+
 func TestJob(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
@@ -123,7 +234,7 @@ func TestJob(t *testing.T) {
 	}
 
 	// mine
-	j, err := doJob(ctx, data, 8)
+	j, err := doJob(ctx, pow.NewSha1(), data, 8, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -142,3 +253,24 @@ func TestJob(t *testing.T) {
 		t.Fatalf("hash mismatch, expected %x, got %x (check data %x)", result, j.Hash, checkData)
 	}
 }
+
+// the goroutines the node starts are labelled, so its samples can be told apart in a cpu profile of the process
+func TestProfileLabels(t *testing.T) {
+	params := NewDemoParams(nil, nil)
+	params.ProfileLabels = map[string]string{"node": "labelled-node", "role": "worker"}
+	d, err := NewDemo(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"node":"labelled-node", "role":"worker"`)) {
+		t.Fatalf("no labelled goroutine in\n%s", buf.String())
+	}
+}
@@ -3,6 +3,10 @@ package service
 import (
 	"fmt"
 	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/mclock"
 
 	"../protocol"
 )
@@ -11,28 +15,96 @@ const (
 	defaultSubmitsCapacity = 1000
 )
 
+// the states of a job we submitted
+const (
+	JobPending   = "pending"   // sent to a worker, waiting for the answer
+	JobDone      = "done"      // we got a correct result
+	JobBusy      = "busy"      // the worker had no room for it
+	JobRejected  = "rejected"  // the worker doesn't take jobs of its difficulty
+	JobGaveup    = "gaveup"    // the worker didn't finish it in time
+	JobInvalid   = "invalid"   // the worker sent a result that doesn't check out
+	JobExpired   = "expired"   // the worker didn't finish it within the timeout we gave
+	JobCancelled = "cancelled" // we told the worker we no longer want it
+)
+
+// JobInfo is what we know about a job we submitted
+type JobInfo struct {
+	Id         protocol.ID   `json:"id"`
+	Difficulty uint8         `json:"difficulty"`
+	Size       int           `json:"size"`    // of the data
+	Worker     string        `json:"worker"`  // id of the peer the job was sent to, or whose result we took
+	Workers    []string      `json:"workers"` // ids of all the peers the job was sent to
+	State      string        `json:"state"`
+	Cached     bool          `json:"cached"` // the worker answered from its cache
+	Nonce      hexutil.Bytes `json:"nonce,omitempty"`
+	Hash       hexutil.Bytes `json:"hash,omitempty"`
+	Elapsed    uint32        `json:"elapsed,omitempty"`    // milliseconds from sending the job to its result
+	Duplicates int           `json:"duplicates,omitempty"` // correct results that came after the one we took
+	Progress   *JobProgress  `json:"progress,omitempty"`   // the last progress the worker reported
+}
+
+// JobProgress is how far along the worker says a job is, times in milliseconds
+type JobProgress struct {
+	Id      protocol.ID `json:"id"`
+	Worker  string      `json:"worker"`
+	Hashes  uint64      `json:"hashes"`
+	Elapsed uint32      `json:"elapsed"`
+	Eta     uint32      `json:"eta"` // 0 if any moment now
+}
+
+// JobFilter selects jobs in demo_listJobs, empty fields match all
+type JobFilter struct {
+	State  string `json:"state"`
+	Worker string `json:"worker"`
+}
+
+func (self *JobFilter) match(info *JobInfo) bool {
+	if self.State != "" && self.State != info.State {
+		return false
+	}
+	if self.Worker == "" {
+		return true
+	}
+	for _, w := range info.Workers {
+		if w == self.Worker {
+			return true
+		}
+	}
+	return false
+}
+
+type submitEntry struct {
+	*protocol.Request
+	info    JobInfo
+	sent    mclock.AbsTime
+	waiting map[string]bool // the workers we still wait for an answer from
+}
+
 type submitStore struct {
 	serial uint64 // last request id sent from this node
 
 	// handle submits
-	entries  []*protocol.Request               // a wrapping array cache of requests used to retrieve the request data on a result response
-	cursor   int                               // the current write position on the wrapping array cache
-	idx      map[protocol.ID]*protocol.Request // index to look up the request cache though a request id
-	capacity int                               // size of request cache (wrap threshold)
+	entries  []*submitEntry               // a wrapping array cache of requests used to retrieve the request data on a result response
+	cursor   int                          // the current write position on the wrapping array cache
+	idx      map[protocol.ID]*submitEntry // index to look up the request cache though a request id
+	capacity int                          // size of request cache (wrap threshold)
+	clock    mclock.Clock                 // times the jobs from sending to the result
 
 	mu sync.RWMutex
 }
 
-func newSubmitStore() *submitStore {
+func newSubmitStore(clock mclock.Clock) *submitStore {
 	return &submitStore{
-		entries:  make([]*protocol.Request, defaultSubmitsCapacity),
-		idx:      make(map[protocol.ID]*protocol.Request),
+		entries:  make([]*submitEntry, defaultSubmitsCapacity),
+		idx:      make(map[protocol.ID]*submitEntry),
 		capacity: defaultSubmitsCapacity,
+		clock:    clock,
 	}
 }
 
 // add submits to entry cache
-func (self *submitStore) Put(req *protocol.Request, id protocol.ID) error {
+// a job fanned out is sent to all the workers at once, and waits for an answer from each
+func (self *submitStore) Put(req *protocol.Request, id protocol.ID, workers ...string) error {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 	if _, ok := self.idx[id]; ok {
@@ -44,11 +116,71 @@ func (self *submitStore) Put(req *protocol.Request, id protocol.ID) error {
 	if self.entries[self.cursor] != nil {
 		delete(self.idx, self.entries[self.cursor].Id)
 	}
-	self.entries[self.cursor] = req
-	self.idx[id] = req
+	entry := &submitEntry{
+		Request: req,
+		info: JobInfo{
+			Id:         id,
+			Difficulty: req.Difficulty,
+			Size:       len(req.Data),
+			Worker:     workers[0],
+			Workers:    workers,
+			State:      JobPending,
+		},
+		sent:    self.clock.Now(),
+		waiting: make(map[string]bool),
+	}
+	for _, w := range workers {
+		entry.waiting[w] = true
+	}
+	self.entries[self.cursor] = entry
+	self.idx[id] = entry
 	return nil
 }
 
+// forgets the worker of a job that never made it to it, and the job if it made it to none
+func (self *submitStore) Unsent(id protocol.ID, worker string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	entry, ok := self.idx[id]
+	if !ok {
+		return
+	}
+	delete(entry.waiting, worker)
+	var workers []string
+	for _, w := range entry.info.Workers {
+		if w != worker {
+			workers = append(workers, w)
+		}
+	}
+	if len(workers) == 0 {
+		self.del(id)
+		return
+	}
+	entry.info.Workers = workers
+	entry.info.Worker = workers[0]
+}
+
+// removes a submit that never made it to the worker
+func (self *submitStore) Del(id protocol.ID) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.del(id)
+}
+
+func (self *submitStore) del(id protocol.ID) {
+	entry, ok := self.idx[id]
+	if !ok {
+		return
+	}
+	delete(self.idx, id)
+	for i, e := range self.entries {
+		if e == entry {
+			self.entries[i] = nil
+			return
+		}
+	}
+}
+
 func (self *submitStore) Have(id protocol.ID) bool {
 	self.mu.RLock()
 	defer self.mu.RUnlock()
@@ -60,6 +192,61 @@ func (self *submitStore) have(id protocol.ID) bool {
 	return ok
 }
 
+// tells if we're still waiting for the answer to a job
+func (self *submitStore) IsPending(id protocol.ID) bool {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	return self.have(id) && self.idx[id].info.State == JobPending
+}
+
+// tells if we're still waiting for the answer of the worker to a job
+func (self *submitStore) IsWaiting(id protocol.ID, worker string) bool {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	return self.have(id) && self.idx[id].info.State == JobPending && self.idx[id].waiting[worker]
+}
+
+// the workers we're still waiting for the answer to a job from
+func (self *submitStore) Waiting(id protocol.ID) []string {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	if !self.have(id) {
+		return nil
+	}
+	var workers []string
+	for w := range self.idx[id].waiting {
+		workers = append(workers, w)
+	}
+	return workers
+}
+
+// tells if the result of the worker comes after we took the result of another one for the job
+func (self *submitStore) IsDuplicate(id protocol.ID, worker string) bool {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	if !self.have(id) {
+		return false
+	}
+	info := &self.idx[id].info
+	if info.State != JobDone || info.Worker == worker {
+		return false
+	}
+	for _, w := range info.Workers {
+		if w == worker {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *submitStore) AddDuplicate(id protocol.ID) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.have(id) {
+		self.idx[id].info.Duplicates++
+	}
+}
+
 func (self *submitStore) GetData(id protocol.ID) []byte {
 	self.mu.Lock()
 	defer self.mu.Unlock()
@@ -78,6 +265,101 @@ func (self *submitStore) GetDifficulty(id protocol.ID) uint8 {
 	return 0
 }
 
+// records what became of a job, whatever the workers still working on it answer
+func (self *submitStore) SetState(id protocol.ID, state string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.have(id) {
+		self.idx[id].info.State = state
+		self.idx[id].waiting = make(map[string]bool)
+	}
+}
+
+// records that the worker won't answer the job with a result, and why
+// the job only takes the state when none of its workers is left to answer
+func (self *submitStore) Failed(id protocol.ID, worker string, state string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if !self.have(id) {
+		return
+	}
+	entry := self.idx[id]
+	if entry.info.State != JobPending || !entry.waiting[worker] {
+		return
+	}
+	delete(entry.waiting, worker)
+	if len(entry.waiting) == 0 {
+		entry.info.State = state
+	}
+}
+
+func (self *submitStore) SetCached(id protocol.ID) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.have(id) {
+		self.idx[id].info.Cached = true
+	}
+}
+
+// takes the result of the worker for the job, and returns the other workers still working on it
+func (self *submitStore) SetResult(id protocol.ID, worker string, nonce []byte, hash []byte) []string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if !self.have(id) {
+		return nil
+	}
+	entry := self.idx[id]
+	var others []string
+	for w := range entry.waiting {
+		if w != worker {
+			others = append(others, w)
+		}
+	}
+	entry.waiting = make(map[string]bool)
+	info := &entry.info
+	info.State = JobDone
+	info.Worker = worker
+	info.Nonce = nonce
+	info.Hash = hash
+	info.Elapsed = uint32(time.Duration(self.clock.Now()-entry.sent) / time.Millisecond)
+	return others
+}
+
+func (self *submitStore) SetProgress(id protocol.ID, progress *JobProgress) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.have(id) {
+		self.idx[id].info.Progress = progress
+	}
+}
+
+// returns a copy of what we know about the job, or nil if we don't know it
+func (self *submitStore) GetInfo(id protocol.ID) *JobInfo {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	if !self.have(id) {
+		return nil
+	}
+	info := self.idx[id].info
+	return &info
+}
+
+// returns the jobs matching the filter, oldest first
+func (self *submitStore) List(filter *JobFilter) []*JobInfo {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	jobs := []*JobInfo{}
+	for i := 1; i <= self.capacity; i++ {
+		entry := self.entries[(self.cursor+i)%self.capacity]
+		if entry == nil || !filter.match(&entry.info) {
+			continue
+		}
+		info := entry.info
+		jobs = append(jobs, &info)
+	}
+	return jobs
+}
+
 func (self *submitStore) IncSerial() uint64 {
 	self.mu.Lock()
 	defer self.mu.Unlock()
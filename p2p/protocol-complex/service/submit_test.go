@@ -0,0 +1,96 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+
+	"../protocol"
+)
+
+// the store remembers the latest jobs and what became of them
+func TestSubmitStore(t *testing.T) {
+	s := newSubmitStore(mclock.System{})
+	s.capacity = 3
+	s.entries = make([]*submitEntry, s.capacity)
+
+	var ids []protocol.ID
+	for i := 0; i < 4; i++ {
+		id := protocol.ID{byte(i)}
+		worker := "a"
+		if i%2 == 1 {
+			worker = "b"
+		}
+		err := s.Put(&protocol.Request{Id: id, Data: testData, Difficulty: 1}, id, worker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if s.Have(ids[0]) {
+		t.Fatal("oldest job not forgotten")
+	}
+	s.SetResult(ids[1], "b", []byte{1}, []byte{2})
+	s.SetState(ids[2], JobBusy)
+	s.Del(ids[3])
+
+	jobs := s.List(&JobFilter{})
+	if len(jobs) != 2 || jobs[0].Id != ids[1] || jobs[1].Id != ids[2] {
+		t.Fatalf("unexpected jobs %v", jobs)
+	}
+	if jobs[0].State != JobDone || jobs[1].State != JobBusy {
+		t.Fatalf("unexpected states %s, %s", jobs[0].State, jobs[1].State)
+	}
+	jobs = s.List(&JobFilter{State: JobBusy, Worker: "a"})
+	if len(jobs) != 1 || jobs[0].Id != ids[2] {
+		t.Fatalf("unexpected filtered jobs %v", jobs)
+	}
+
+	// what is handed out is a copy
+	info := s.GetInfo(ids[2])
+	info.State = JobDone
+	if s.GetInfo(ids[2]).State != JobBusy {
+		t.Fatal("job info changed from outside the store")
+	}
+}
+
+// a job fanned out takes the first result, and waits for all its workers before it fails
+func TestSubmitStoreFanOut(t *testing.T) {
+	clock := &mclock.Simulated{}
+	s := newSubmitStore(clock)
+	id := protocol.ID{1}
+	if err := s.Put(&protocol.Request{Id: id, Data: testData, Difficulty: 1}, id, "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	s.Unsent(id, "c")
+	s.Failed(id, "a", JobGaveup)
+	if !s.IsPending(id) || s.IsWaiting(id, "a") || !s.IsWaiting(id, "b") {
+		t.Fatalf("job settled with a worker left: %+v", s.GetInfo(id))
+	}
+	s.Failed(id, "b", JobBusy)
+	if info := s.GetInfo(id); info.State != JobBusy || len(info.Workers) != 2 {
+		t.Fatalf("unexpected job %+v", info)
+	}
+
+	id = protocol.ID{2}
+	if err := s.Put(&protocol.Request{Id: id, Data: testData, Difficulty: 1}, id, "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	s.Failed(id, "c", JobInvalid)
+	clock.Run(time.Millisecond * 1500)
+	others := s.SetResult(id, "b", []byte{1}, []byte{2})
+	if len(others) != 1 || others[0] != "a" {
+		t.Fatalf("other workers %v, want a", others)
+	}
+	if s.IsDuplicate(id, "b") || !s.IsDuplicate(id, "a") || !s.IsDuplicate(id, "c") || s.IsDuplicate(id, "d") {
+		t.Fatal("wrong duplicates")
+	}
+	info := s.GetInfo(id)
+	if info.State != JobDone || info.Worker != "b" || info.Elapsed != 1500 {
+		t.Fatalf("unexpected job %+v", info)
+	}
+	if jobs := s.List(&JobFilter{Worker: "a"}); len(jobs) != 2 {
+		t.Fatalf("jobs of a worker %v", jobs)
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"sync"
 
-	"./minipow"
+	"./pow"
 )
 
 var (
@@ -17,34 +17,23 @@ type job struct {
 	Nonce []byte
 }
 
-func doJob(ctx context.Context, rawData []byte, difficulty uint8) (*job, error) {
-	resultC := make(chan []byte)
-	quitC := make(chan struct{})
-
-	workData := make([]byte, len(rawData)+8)
-	copy(workData, rawData)
-
-	go minipow.Mine(workData, int(difficulty), resultC, quitC, nil)
-
-	var r []byte
-	select {
-	case <-ctx.Done():
-		quitC <- struct{}{}
-		return nil, ctx.Err()
-	case r = <-resultC:
+func doJob(ctx context.Context, pw pow.Pow, rawData []byte, difficulty uint8, hashes *uint64) (*job, error) {
+	nonce, hash, err := pw.Mine(ctx, rawData, difficulty, hashes)
+	if err != nil {
+		return nil, err
 	}
 
 	j := &job{
 		Data:  rawData,
-		Nonce: workData[len(workData)-8:],
-		Hash:  r,
+		Nonce: nonce,
+		Hash:  hash,
 	}
 	return j, nil
 }
 
-func checkJob(hash []byte, data []byte, nonce []byte) bool {
+func checkJob(pw pow.Pow, hash []byte, data []byte, nonce []byte, difficulty uint8) bool {
 	if hash == nil || data == nil || nonce == nil {
 		return false
 	}
-	return minipow.Check(hash, data, nonce)
+	return pw.Verify(data, nonce, hash, difficulty)
 }
@@ -2,25 +2,40 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"math/big"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/simulations"
 	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
 
 	colorable "github.com/mattn/go-colorable"
 
+	"./audit"
+	"./bench"
+	"./profiling"
 	"./protocol"
 	"./resource"
 	"./service"
+	"./service/pow"
+	"./steps"
 )
 
 const (
@@ -29,19 +44,39 @@ const (
 	defaultSubmitDelay     = time.Millisecond * 100
 	defaultDataSize        = 32
 	defaultMaxTime         = time.Second * 10
-	defaultSimDuration     = time.Second * 5
+	minResults             = 5 // the results each submitter must have got before the run ends
 	defaultMaxJobs         = 100
+	defaultCacheSize       = 1024
+	defaultQueueSize       = 8
+	defaultWorkerJobs      = 4 // few enough for the submitters to compete for them
+	defaultProgress        = time.Second
+	minFairness            = 0.5 // least share of jobs started for one submitter, relative to the submitter served most
 	defaultResourceApiHost = "http://localhost:8500"
 )
 
 var (
 	loglevel      = flag.Bool("v", false, "loglevel")
+	codec         = flag.String("codec", "rlp", "encoding of the protocol messages, rlp or protobuf; all nodes must use the same")
+	powName       = flag.String("pow", "sha1", "proof of work algorithm, sha1, sha3 or ethash-lite; all nodes must use the same")
 	useResource   = flag.Bool("r", false, "use resource sink")
 	ensAddr       = flag.String("e", "", "ens name to post resource update")
+	auditInterval = flag.Duration("audit", 0, "commit the merkle root of the job results to a contract on a simulated chain this often, 0 for never")
+	auditRPC      = flag.String("audit-rpc", "localhost:8889", "http rpc address the audit log and its chain are served on, for cmd/auditverify")
+	benchMode     = flag.Bool("bench", false, "sweep the node count, difficulty and submit delay, and report the throughput and latency of each configuration")
+	benchNodes    = flag.String("bench-nodes", "3,5,8", "node counts to sweep with -bench, comma separated")
+	benchDiff     = flag.String("bench-difficulty", "8-12,12-16", "difficulty ranges to sweep with -bench, comma separated")
+	benchDelay    = flag.String("bench-delay", "50ms,100ms", "submit delays to sweep with -bench, comma separated")
+	benchDuration = flag.Duration("bench-duration", time.Second*10, "how long each configuration submits jobs for with -bench")
+	benchOut      = flag.String("bench-out", "bench-results", "directory the csv and plots of -bench are written to")
+	profileDir    = flag.String("profile", "", "directory to write cpu and allocation profiles of a load phase to, split by node and role; none if empty")
+	profileTime   = flag.Duration("profile-duration", time.Second*10, "how long the load phase of -profile lasts")
 	maxDifficulty uint8
 	minDifficulty uint8
 	maxTime       time.Duration
+	submitDelay   time.Duration
 	maxJobs       int
+	pw            pow.Pow    // the proof of work algorithm chosen with -pow
+	auditLog      *audit.Log // the log of the results the submitters got, with -audit
 )
 
 func init() {
@@ -50,16 +85,32 @@ func init() {
 		log.PrintOrigins(true)
 		log.Root().SetHandler(log.LvlFilterHandler(log.LvlDebug, log.StreamHandler(colorable.NewColorableStderr(), log.TerminalFormat(true))))
 	}
+	if err := protocol.SetCodec(*codec); err != nil {
+		log.Crit("codec fail", "err", err)
+	}
+	var err error
+	if pw, err = pow.New(*powName); err != nil {
+		log.Crit("pow fail", "err", err)
+	}
 
 	maxDifficulty = defaultMaxDifficulty
 	minDifficulty = defaultMinDifficulty
 	maxTime = defaultMaxTime
+	submitDelay = defaultSubmitDelay
 	maxJobs = defaultMaxJobs
 
 	adapters.RegisterServices(newServices())
 }
 
 func main() {
+	if *benchMode {
+		if err := runBench(); err != nil {
+			log.Error("bench fail", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	a := adapters.NewSimAdapter(newServices())
 
 	n := simulations.NewNetwork(a, &simulations.NetworkConfig{
@@ -79,110 +130,385 @@ func main() {
 		nids = append(nids, nod.ID())
 	}
 
-	// TODO: need better assertion for network readiness
-	n.StartAll()
-	for i, nid := range nids {
-		if i == 0 {
-			continue
+	// the results are logged before the nodes start to get them
+	if *auditInterval > 0 {
+		var err error
+		auditLog, err = newAuditLog()
+		if err != nil {
+			log.Error("audit log fail", "err", err)
+			return
 		}
-		n.Connect(nids[0], nid)
+		auditLog.Start()
+		defer auditLog.Stop()
 	}
 
+	n.StartAll()
 	go http.ListenAndServe(":8888", simulations.NewServer(n))
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-
-	quitC := make(chan struct{})
-	trigger := make(chan enode.ID)
-	events := make(chan *simulations.Event)
-	sub := n.Events().Subscribe(events)
-	// event sink on quit
-	defer func() {
-		sub.Unsubscribe()
-		close(quitC)
-		select {
-		case <-events:
-		default:
+	// one of the submitters shows what the worker tells about the long jobs
+	if err := followProgress(n.GetNode(nids[1])); err != nil {
+		log.Error("progress subscription fail", "err", err)
+	}
+
+	// the other nodes are created without difficulty, so they tell their peers they don't take jobs
+	// they learn from the skills the worker announced which jobs they can send it, and send them until they are stopped
+	log.Info("appointed worker node", "node", nids[0].String())
+	runner := steps.NewRunner(n)
+	_, err := runner.Run(context.Background(),
+		&steps.Step{
+			Name:   "connect",
+			Action: steps.ConnectAll(nids),
+			Nodes:  steps.All(nids),
+			Check:  steps.WaitHealthy(len(nids) - 1),
+		},
+		&steps.Step{
+			Name:  "submit",
+			Nodes: steps.All(nids[1:]),
+			Check: steps.ExpectCountAtLeast(minResults, steps.CallCount("demo_listJobs", &service.JobFilter{State: service.JobDone})),
+		},
+	)
+	if err != nil {
+		log.Error(err.Error())
+	}
+
+	// the cpu profile is taken while the submitters keep the worker busy
+	if *profileDir != "" {
+		if err := captureProfiles(runner); err != nil {
+			log.Error("profile fail", "err", err)
 		}
-		return
-	}()
+	}
+
+	// the worker's job slots must have been shared evenly between the submitters
+	if err := checkFairness(n.GetNode(nids[0]), nids[1:]); err != nil {
+		log.Error("fairness check fail", "err", err)
+	}
+
+	// the same job submitted twice is only worked on once, the second time the worker answers from its cache
+	if err := showCache(n.GetNode(nids[1]), n.GetNode(nids[0])); err != nil {
+		log.Error("cache demo fail", "err", err)
+	}
 
-	action := func(ctx context.Context) error {
-		for i, nid := range nids {
-			if i == 0 {
-				log.Info("appointed worker node", "node", nid.String())
-				go func(nid enode.ID) {
-					trigger <- nid
-				}(nid)
-				continue
+	// the results are all in the audit log, and any of them can be proven against the root on chain
+	if auditLog != nil {
+		if err := showAudit(auditLog); err != nil {
+			log.Error("audit demo fail", "err", err)
+		}
+	}
+	_, err = runner.Run(context.Background(), &steps.Step{Name: "stop submitters", Action: steps.StopFraction(nids[1:], 1, nil)})
+	if err != nil {
+		log.Error(err.Error())
+	}
+	sigC := make(chan os.Signal)
+	signal.Notify(sigC, syscall.SIGINT)
+
+	<-sigC
+
+	return
+}
+
+// the nodes run on in the load phase as they did until now
+func captureProfiles(runner *steps.Runner) error {
+	capture, err := profiling.Start(*profileDir)
+	if err != nil {
+		return err
+	}
+	_, err = runner.Run(context.Background(), &steps.Step{
+		Name: "load",
+		Action: func(ctx context.Context, _ *simulations.Network) error {
+			select {
+			case <-time.After(*profileTime):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-			client, err := n.GetNode(nid).Client()
-			if err != nil {
+		},
+		Timeout: *profileTime + time.Minute,
+	})
+	files, stopErr := capture.Stop()
+	if err != nil {
+		return err
+	}
+	if stopErr != nil {
+		return stopErr
+	}
+	for _, file := range files {
+		fmt.Fprintf(os.Stdout, "PROFILE >> %s\n", file)
+	}
+	fmt.Fprintf(os.Stdout, "PROFILE >> look at one with: go tool pprof -top %s\n", files[len(files)-2])
+	return nil
+}
+
+func followProgress(submitter *simulations.Node) error {
+	client, err := submitter.Client()
+	if err != nil {
+		return err
+	}
+	progressC := make(chan service.JobProgress)
+	sub, err := client.Subscribe(context.Background(), "demo", progressC, "progress")
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case p := <-progressC:
+				log.Info("job progress", "id", fmt.Sprintf("%x", p.Id), "hashes", p.Hashes, "elapsed", time.Duration(p.Elapsed)*time.Millisecond, "eta", time.Duration(p.Eta)*time.Millisecond)
+			case err := <-sub.Err():
+				if err != nil {
+					log.Warn("progress subscription ended", "err", err)
+				}
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func checkFairness(worker *simulations.Node, submitters []enode.ID) error {
+	client, err := worker.Client()
+	if err != nil {
+		return err
+	}
+	var stats service.SchedulerStats
+	err = client.Call(&stats, "demo_schedulerStats")
+	if err != nil {
+		return err
+	}
+	var least, most uint64
+	for i, nid := range submitters {
+		st, ok := stats.Submitters[nid.String()]
+		if !ok {
+			return fmt.Errorf("no jobs from submitter %s", nid.TerminalString())
+		}
+		log.Info("submitter", "nid", nid.TerminalString(), "started", st.Started, "busy", st.Busy, "expired", st.Expired, "maxwait", st.MaxWait)
+		if i == 0 || st.Started < least {
+			least = st.Started
+		}
+		if st.Started > most {
+			most = st.Started
+		}
+	}
+	if float64(least) < float64(most)*minFairness {
+		return fmt.Errorf("unfair share of jobs: least %d, most %d", least, most)
+	}
+	log.Info("fair share of jobs", "least", least, "most", most)
+	return nil
+}
+
+func showCache(moocher *simulations.Node, worker *simulations.Node) error {
+	client, err := moocher.Client()
+	if err != nil {
+		return err
+	}
+	data := hexutil.Bytes("the same job twice")
+	for i := 0; i < 2; i++ {
+		// the worker's job slots are shared with the other submitters, so it may take a few tries
+		var job service.JobInfo
+		for try := 0; ; try++ {
+			err = client.Call(&job, "demo_submitJob", data, defaultMinDifficulty)
+			if err == nil {
+				break
+			} else if try == 50 {
 				return err
 			}
-			err = client.Call(nil, "demo_setDifficulty", 0)
+			time.Sleep(time.Millisecond * 100)
+		}
+		// and it may wait in the worker's queue for a while
+		for try := 0; job.State == service.JobPending && try < 50; try++ {
+			time.Sleep(time.Millisecond * 100)
+			err = client.Call(&job, "demo_jobStatus", job.Id)
 			if err != nil {
 				return err
 			}
+		}
+		log.Info("submitted job", "id", fmt.Sprintf("%x", job.Id), "state", job.State, "cached", job.Cached)
+	}
 
-			go func(nid enode.ID) {
-				timer := time.NewTimer(defaultSimDuration)
-				for {
-					select {
-					case <-events:
-						continue
-					case <-quitC:
-						return
-					case <-ctx.Done():
-						return
-					case <-timer.C:
-					}
-					log.Debug("stop sending", "node", nid)
-					trigger <- nid
-					return
-				}
-			}(nid)
+	client, err = worker.Client()
+	if err != nil {
+		return err
+	}
+	var stats service.CacheStats
+	err = client.Call(&stats, "demo_cacheStats")
+	if err != nil {
+		return err
+	}
+	log.Info("worker cache", "size", stats.Size, "hits", stats.Hits, "misses", stats.Misses, "evictions", stats.Evictions)
+	return nil
+}
+
+// the audit log commits to a simulated chain, with a key funded for it
+func newAuditLog() (*audit.Log, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		crypto.PubkeyToAddress(key.PublicKey): {Balance: big.NewInt(params.Ether)},
+	}, 10000000)
+	return audit.NewLog(backend, key, *auditInterval)
+}
+
+// commits the results not committed yet, and proves the last of them against its root in the anchor contract
+// the log is then served over rpc, so cmd/auditverify can prove any of them until the sim is stopped
+func showAudit(l *audit.Log) error {
+	if _, err := l.Commit(); err != nil {
+		return err
+	}
+	batches := l.Batches()
+	if len(batches) == 0 {
+		return errors.New("no results logged")
+	}
+	var results int
+	for _, batch := range batches {
+		results += len(batch.Records())
+	}
+	records := batches[len(batches)-1].Records()
+	last := records[len(records)-1]
+	proof, err := l.Prove(last.Id)
+	if err != nil {
+		return err
+	}
+	root, err := l.Verify(proof)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "AUDIT >> %d results in %d batches, anchored in %s\n", results, len(batches), l.Address().Hex())
+	fmt.Fprintf(os.Stdout, "AUDIT >> %s proven at %d of batch %d, root %s\n", last.Id, proof.Index, proof.Batch, root.Hex())
+
+	// the same result, proven by its id in the trie of the results so far
+	// whoever checks only needs the root of the trie
+	t, err := l.Trie(uint64(len(batches)))
+	if err != nil {
+		return err
+	}
+	trieProof, err := t.Prove(last.Id)
+	if err != nil {
+		return err
+	}
+	if _, err := audit.VerifyTrieProof(t.Root(), trieProof); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "AUDIT >> %s proven in the trie of the results of %d batches with %d nodes, root %s\n", last.Id, len(batches), len(trieProof.Nodes), t.Root().Hex())
+
+	server := rpc.NewServer()
+	for _, api := range l.APIs() {
+		if err := server.RegisterName(api.Namespace, api.Service); err != nil {
+			return err
 		}
-		return nil
 	}
-	check := func(ctx context.Context, nid enode.ID) (bool, error) {
-		select {
-		case <-ctx.Done():
-		default:
+	listener, err := net.Listen("tcp", *auditRPC)
+	if err != nil {
+		return err
+	}
+	go http.Serve(listener, server)
+	fmt.Fprintf(os.Stdout, "AUDIT >> prove any result with: go run cmd/auditverify/main.go -rpc http://%s -id %s\n", listener.Addr(), last.Id)
+	fmt.Fprintf(os.Stdout, "AUDIT >> or against the trie root alone with: go run cmd/auditverify/main.go -rpc http://%s -id %s -root %s -batches %d\n", listener.Addr(), last.Id, t.Root().Hex(), len(batches))
+	return nil
+}
+
+// runs each configuration of the sweep on a network of its own, one after the other so they don't compete for the cpu,
+// and writes the throughput and latency of each as a table, a csv and plots
+func runBench() error {
+	sweep, err := bench.ParseSweep(*benchNodes, *benchDiff, *benchDelay)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*benchOut, 0755); err != nil {
+		return err
+	}
+	var results []*bench.Result
+	for i, c := range sweep.Configs() {
+		r, err := benchConfig(i, c)
+		if err != nil {
+			return fmt.Errorf("%v: %v", c, err)
 		}
-		log.Warn("ok", "nid", nid)
-		return true, nil
+		log.Info("bench config done", "config", c, "jobs", r.Jobs, "throughput", r.Throughput, "p95", r.P95Latency)
+		results = append(results, r)
 	}
 
-	ctx, cancel = context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-	sim := simulations.NewSimulation(n)
-	step := sim.Run(ctx, &simulations.Step{
-		Action:  action,
-		Trigger: trigger,
-		Expect: &simulations.Expectation{
-			Nodes: nids,
-			Check: check,
-		},
+	if err := bench.WriteTable(os.Stdout, results); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(*benchOut, "bench.csv"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := bench.WriteCSV(f, results); err != nil {
+		return err
+	}
+	if err := bench.PlotThroughput(results, filepath.Join(*benchOut, "throughput.png")); err != nil {
+		return err
+	}
+	if err := bench.PlotLatency(results, filepath.Join(*benchOut, "latency.png")); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "BENCH >> wrote bench.csv, throughput.png and latency.png to %s\n", *benchOut)
+	return nil
+}
+
+// the first node is the worker, the others submit jobs to it for the duration
+// the jobs done before the nodes were all connected are left out
+func benchConfig(i int, c bench.Config) (*bench.Result, error) {
+	minDifficulty, maxDifficulty, submitDelay = c.MinDifficulty, c.MaxDifficulty, c.SubmitDelay
+	n, nids, err := steps.NewSimNetwork(fmt.Sprintf("bench-%d", i), newServices(), "demo", c.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	defer n.Shutdown()
+	_, err = steps.NewRunner(n).Run(context.Background(), &steps.Step{
+		Name:   "connect",
+		Action: steps.ConnectAll(nids),
+		Nodes:  steps.All(nids),
+		Check:  steps.WaitHealthy(len(nids) - 1),
 	})
-	if step.Error != nil {
-		log.Error(step.Error.Error())
+	if err != nil {
+		return nil, err
 	}
-	for i, nid := range nids {
-		if i == 0 {
-			continue
-		}
-		log.Debug("stopping node", "nid", nid)
-		n.Stop(nid)
 
+	type key struct {
+		nid enode.ID
+		id  protocol.ID
 	}
-	sigC := make(chan os.Signal)
-	signal.Notify(sigC, syscall.SIGINT)
+	before := make(map[key]bool)
+	for _, nid := range nids[1:] {
+		jobs, err := doneJobs(n.GetNode(nid))
+		if err != nil {
+			return nil, err
+		}
+		for _, job := range jobs {
+			before[key{nid, job.Id}] = true
+		}
+	}
+	start := time.Now()
+	time.Sleep(*benchDuration)
+	took := time.Since(start)
 
-	<-sigC
+	var latencies []time.Duration
+	for _, nid := range nids[1:] {
+		jobs, err := doneJobs(n.GetNode(nid))
+		if err != nil {
+			return nil, err
+		}
+		for _, job := range jobs {
+			if !before[key{nid, job.Id}] {
+				latencies = append(latencies, time.Duration(job.Elapsed)*time.Millisecond)
+			}
+		}
+	}
+	return bench.NewResult(c, took, latencies), nil
+}
 
-	return
+func doneJobs(submitter *simulations.Node) ([]*service.JobInfo, error) {
+	client, err := submitter.Client()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*service.JobInfo
+	err = client.Call(&jobs, "demo_listJobs", &service.JobFilter{State: service.JobDone})
+	return jobs, err
 }
 
 func newServices() adapters.Services {
@@ -201,23 +527,40 @@ func newServices() adapters.Services {
 				sinkFunc = resourceapi.ResourceSinkFunc()
 			}
 			params := service.NewDemoParams(sinkFunc, saveFunc)
+			params.Pow = pw
 			params.MaxJobs = maxJobs
 			params.MaxTimePerJob = maxTime
+			role := "submitter"
 			if !haveWorker {
+				role = "worker"
 				params.MaxDifficulty = maxDifficulty
+				params.MinDifficulty = minDifficulty
+				params.CacheSize = defaultCacheSize
+				params.QueueSize = defaultQueueSize
+				params.MaxJobs = defaultWorkerJobs
+				params.ProgressInterval = defaultProgress
 				haveWorker = true
 			}
-			params.SubmitDelay = defaultSubmitDelay
+			params.SubmitDelay = submitDelay
 			params.SubmitDataSize = defaultDataSize
-			params.MaxSubmitDifficulty = defaultMaxDifficulty
-			params.MinSubmitDifficulty = defaultMinDifficulty
+			params.MaxSubmitDifficulty = maxDifficulty
+			params.MinSubmitDifficulty = minDifficulty
 
 			params.Id = node.Config.ID[:]
+			if *profileDir != "" {
+				params.ProfileLabels = profiling.Labels("node_"+node.Config.ID.TerminalString(), role)
+			}
 			return service.NewDemo(params)
 		},
 	}
 }
 
 func saveFunc(nid []byte, id protocol.ID, difficulty uint8, data []byte, nonce []byte, hash []byte) {
-	fmt.Fprintf(os.Stdout, "RESULT >> %x/%x : %x@%d|%x => %x\n", nid[:8], id, data, difficulty, nonce, hash)
+	// printing each would slow down the benchmark
+	if !*benchMode {
+		fmt.Fprintf(os.Stdout, "RESULT >> %x/%x : %x@%d|%x => %x\n", nid[:8], id, data, difficulty, nonce, hash)
+	}
+	if auditLog != nil {
+		auditLog.Save(nid, id, difficulty, data, nonce, hash)
+	}
 }
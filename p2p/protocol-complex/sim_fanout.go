@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	colorable "github.com/mattn/go-colorable"
+
+	"./protocol"
+	"./service"
+	"./service/pow"
+)
+
+const (
+	defaultWorkers    = 4
+	defaultDataSize   = 32
+	defaultMaxTime    = time.Second * 30
+	defaultJobTimeout = time.Second * 30 // how long we wait for the answer to one job
+)
+
+var (
+	loglevel   = flag.Bool("v", false, "loglevel")
+	codec      = flag.String("codec", "rlp", "encoding of the protocol messages, rlp or protobuf; all nodes must use the same")
+	powName    = flag.String("pow", "sha1", "proof of work algorithm, sha1, sha3 or ethash-lite; all nodes must use the same")
+	workers    = flag.Int("workers", defaultWorkers, "number of workers")
+	fanOut     = flag.Int("fanout", 3, "workers each job is sent to, compared with one")
+	jobs       = flag.Int("jobs", 20, "jobs submitted with each fan out, one after the other")
+	difficulty = flag.Int("difficulty", 18, "difficulty of the jobs")
+	hashRate   = flag.Float64("rate", 1e6, "hashes a second of the fastest worker, the next does half, the one after a third and so on")
+	pw         pow.Pow  // the proof of work algorithm chosen with -pow
+	submitter  enode.ID // the node submitting the jobs, the others work on them
+	rates      = make(map[enode.ID]float64)
+)
+
+func init() {
+	flag.Parse()
+	if *loglevel {
+		log.PrintOrigins(true)
+		log.Root().SetHandler(log.LvlFilterHandler(log.LvlDebug, log.StreamHandler(colorable.NewColorableStderr(), log.TerminalFormat(true))))
+	}
+	if err := protocol.SetCodec(*codec); err != nil {
+		log.Crit("codec fail", "err", err)
+	}
+	var err error
+	if pw, err = pow.New(*powName); err != nil {
+		log.Crit("pow fail", "err", err)
+	}
+}
+
+// a submitter connected to all the workers sends them the same jobs, first each to one, then each to several
+// the workers mine at different rates, and the hashes a proof of work takes vary a lot from job to job,
+// so the fastest of several workers is well ahead of a single one
+func main() {
+	if *fanOut < 2 || *fanOut > *workers {
+		log.Crit("fan out must be between 2 and the number of workers", "fanout", *fanOut, "workers", *workers)
+	}
+	a := adapters.NewSimAdapter(newServices())
+	n := simulations.NewNetwork(a, &simulations.NetworkConfig{
+		ID:             "protocol-demo-fanout",
+		DefaultService: "demo",
+	})
+	defer n.Shutdown()
+
+	var nids []enode.ID
+	for i := 0; i <= *workers; i++ {
+		nod, err := n.NewNodeWithConfig(adapters.RandomNodeConfig())
+		if err != nil {
+			log.Crit("create node fail", "err", err)
+		}
+		nids = append(nids, nod.ID())
+		if i > 0 {
+			rates[nod.ID()] = *hashRate / float64(i)
+		}
+	}
+	submitter = nids[0]
+	if err := n.StartAll(); err != nil {
+		log.Crit("start fail", "err", err)
+	}
+	for _, nid := range nids[1:] {
+		if err := n.Connect(nids[0], nid); err != nil {
+			log.Crit("connect fail", "err", err)
+		}
+	}
+
+	client, err := n.GetNode(nids[0]).Client()
+	if err != nil {
+		log.Crit("rpc client fail", "err", err)
+	}
+	if err := waitWorkers(client, *workers); err != nil {
+		log.Crit("workers fail", "err", err)
+	}
+
+	fmt.Fprintf(os.Stdout, "%-8s %-6s %-9s %-9s %-9s %-9s %s\n", "fanout", "jobs", "mean", "p50", "p90", "max", "duplicates")
+	for _, k := range []int{1, *fanOut} {
+		var changed []string
+		if err := client.Call(&changed, "demo_reload", service.DemoConfig{FanOut: &k}); err != nil {
+			log.Crit("reload fail", "err", err)
+		}
+		elapsed, duplicates, err := submitJobs(client, *jobs, uint8(*difficulty))
+		if err != nil {
+			log.Crit("submit fail", "fanout", k, "err", err)
+		}
+		var total time.Duration
+		for _, e := range elapsed {
+			total += e
+		}
+		fmt.Fprintf(os.Stdout, "%-8d %-6d %-9s %-9s %-9s %-9s %d\n", k, len(elapsed), total/time.Duration(len(elapsed)),
+			percentile(elapsed, 0.5), percentile(elapsed, 0.9), percentile(elapsed, 1), duplicates)
+	}
+}
+
+// the submitter learns about the workers from their skills, which come after connecting
+func waitWorkers(client *rpc.Client, count int) error {
+	for try := 0; try < 50; try++ {
+		var skills map[string]*protocol.Skills
+		if err := client.Call(&skills, "demo_peerSkills"); err != nil {
+			return err
+		}
+		have := 0
+		for _, s := range skills {
+			if s.Covers(uint8(*difficulty)) {
+				have++
+			}
+		}
+		if have == count {
+			return nil
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	return fmt.Errorf("workers didn't announce their skills")
+}
+
+// submits the jobs one after the other, and returns the time from sending each to its result, sorted
+// the duplicates are the correct results that came after the one taken, before the cancel got to the worker
+func submitJobs(client *rpc.Client, count int, difficulty uint8) ([]time.Duration, int, error) {
+	var elapsed []time.Duration
+	var done []protocol.ID
+	for i := 0; i < count; i++ {
+		data := make([]byte, defaultDataSize)
+		rand.Read(data)
+		var job service.JobInfo
+		if err := client.Call(&job, "demo_submitJob", hexutil.Bytes(data), difficulty); err != nil {
+			return nil, 0, err
+		}
+		deadline := time.Now().Add(defaultJobTimeout)
+		for job.State == service.JobPending && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 10)
+			if err := client.Call(&job, "demo_jobStatus", job.Id); err != nil {
+				return nil, 0, err
+			}
+		}
+		if job.State != service.JobDone {
+			return nil, 0, fmt.Errorf("job %x %s", job.Id, job.State)
+		}
+		log.Info("job done", "id", fmt.Sprintf("%x", job.Id), "workers", len(job.Workers), "elapsed", job.Elapsed)
+		elapsed = append(elapsed, time.Duration(job.Elapsed)*time.Millisecond)
+		done = append(done, job.Id)
+	}
+
+	// the late results of the last jobs may still be on their way
+	time.Sleep(time.Millisecond * 200)
+	duplicates := 0
+	for _, id := range done {
+		var job service.JobInfo
+		if err := client.Call(&job, "demo_jobStatus", id); err != nil {
+			return nil, 0, err
+		}
+		duplicates += job.Duplicates
+	}
+	sort.Slice(elapsed, func(i, j int) bool { return elapsed[i] < elapsed[j] })
+	return elapsed, duplicates, nil
+}
+
+// the time at the part of the way through the sorted times
+func percentile(d []time.Duration, p float64) time.Duration {
+	return d[int(float64(len(d)-1)*p)]
+}
+
+// a proof of work at a hash rate of its own, as if each worker mined on a machine of its own
+// the sim's workers share its cpu, so several mining at once would only slow each other down.
+// The hashes are tried as fast as the cpu does, and the answer is held back until they'd have taken at the rate
+type ratedPow struct {
+	pow.Pow
+	rate float64 // hashes a second
+}
+
+func (self *ratedPow) Mine(ctx context.Context, data []byte, difficulty uint8, hashes *uint64) ([]byte, []byte, error) {
+	start := time.Now()
+	var n uint64
+	nonce, hash, err := self.Pow.Mine(ctx, data, difficulty, &n)
+	if hashes != nil {
+		atomic.AddUint64(hashes, n)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	wait := time.Duration(float64(n)/self.rate*float64(time.Second)) - time.Since(start)
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case <-time.After(wait):
+	}
+	return nonce, hash, nil
+}
+
+// the first node submits, and takes no jobs itself; the others take one job at a time, of any difficulty, at their hash rate
+func newServices() adapters.Services {
+	return adapters.Services{
+		"demo": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			params := service.NewDemoParams(nil, nil)
+			params.Id = ctx.Config.ID[:]
+			params.Pow = pw
+			params.SubmitDelay = time.Hour // the jobs are all submitted over rpc
+			if ctx.Config.ID != submitter {
+				params.MaxDifficulty = 255
+				params.MaxJobs = 1
+				params.MaxTimePerJob = defaultMaxTime
+				params.Pow = &ratedPow{Pow: pw, rate: rates[ctx.Config.ID]}
+			}
+			return service.NewDemo(params)
+		},
+	}
+}
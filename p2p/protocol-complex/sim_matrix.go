@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+
+	colorable "github.com/mattn/go-colorable"
+
+	"./protocol"
+	"./service"
+	"./service/pow"
+	"./steps"
+)
+
+const (
+	defaultSubmitDelay = time.Millisecond * 100
+	defaultDataSize    = 32
+	defaultMaxTime     = time.Second * 10
+	defaultMaxJobs     = 4
+	defaultQueueSize   = 8
+)
+
+var (
+	loglevel     = flag.Bool("v", false, "loglevel")
+	codec        = flag.String("codec", "rlp", "encoding of the protocol messages, rlp or protobuf; all nodes must use the same")
+	powName      = flag.String("pow", "sha1", "proof of work algorithm, sha1, sha3 or ethash-lite; all nodes must use the same")
+	topologies   = flag.String("topology", "ring,star,full", "topologies to run, comma separated")
+	churns       = flag.String("churn", "0,0.5", "shares of the workers stopped once the nodes are connected, comma separated")
+	difficulties = flag.String("difficulty", "8-12,12-16", "ranges of difficulty of the jobs, comma separated")
+	nodeCount    = flag.Int("n", 6, "nodes in each network, every other one a worker")
+	minResults   = flag.Int("results", 3, "results each submitter must get for the scenario to pass")
+	parallel     = flag.Int("parallel", 0, "scenarios run at the same time, all of them if 0")
+	stepTimeout  = flag.Duration("timeout", time.Second*60, "how long a step of a scenario may take")
+	seed         = flag.Int64("seed", 0, "seed for picking the workers to stop (default time based)")
+	pw           pow.Pow // the proof of work algorithm chosen with -pow
+)
+
+func init() {
+	flag.Parse()
+	if *loglevel {
+		log.PrintOrigins(true)
+		log.Root().SetHandler(log.LvlFilterHandler(log.LvlDebug, log.StreamHandler(colorable.NewColorableStderr(), log.TerminalFormat(true))))
+	}
+	if err := protocol.SetCodec(*codec); err != nil {
+		log.Crit("codec fail", "err", err)
+	}
+	var err error
+	if pw, err = pow.New(*powName); err != nil {
+		log.Crit("pow fail", "err", err)
+	}
+}
+
+// a scenario of the matrix
+type cell struct {
+	topology string
+	churn    float64
+	min, max uint8
+}
+
+func (c cell) String() string {
+	return fmt.Sprintf("%s/churn=%g/difficulty=%d-%d", c.topology, c.churn, c.min, c.max)
+}
+
+// runs every combination of topology, churn and difficulty on a network of its own, all at the same time,
+// and tells which of them failed. The exit code is 1 if any did, so it can run in scripts
+func main() {
+	cells, err := matrix()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	var scenarios []*steps.Scenario
+	tallies := make([]int, len(cells))
+	for i, c := range cells {
+		scenarios = append(scenarios, newScenario(c, rand.New(rand.NewSource(*seed+int64(i))), &tallies[i]))
+	}
+	fmt.Fprintf(os.Stdout, "running %d scenarios of %d nodes, seed %d\n", len(scenarios), *nodeCount, *seed)
+	results := steps.RunScenarios(context.Background(), scenarios, *parallel)
+
+	fmt.Fprintf(os.Stdout, "%-36s %-6s %-10s %-8s %s\n", "scenario", "result", "took", "results", "error")
+	for i, r := range results {
+		state, reason := "ok", ""
+		if r.Err != nil {
+			state, reason = "FAIL", r.Err.Error()
+		}
+		fmt.Fprintf(os.Stdout, "%-36s %-6s %-10s %-8d %s\n", r.Name, state, r.Took.Round(time.Millisecond), tallies[i], reason)
+	}
+	if failed := steps.Failed(results); len(failed) > 0 {
+		fmt.Fprintf(os.Stdout, "%d of %d scenarios failed\n", len(failed), len(results))
+		os.Exit(1)
+	}
+}
+
+func matrix() ([]cell, error) {
+	var cells []cell
+	for _, t := range strings.Split(*topologies, ",") {
+		if _, ok := connectors[t]; !ok {
+			return nil, fmt.Errorf("unknown topology %q", t)
+		}
+		for _, ch := range strings.Split(*churns, ",") {
+			churn, err := strconv.ParseFloat(ch, 64)
+			if err != nil || churn < 0 || churn > 1 {
+				return nil, fmt.Errorf("invalid churn %q", ch)
+			}
+			for _, d := range strings.Split(*difficulties, ",") {
+				min, max, err := parseRange(d)
+				if err != nil {
+					return nil, err
+				}
+				cells = append(cells, cell{topology: t, churn: churn, min: min, max: max})
+			}
+		}
+	}
+	return cells, nil
+}
+
+func parseRange(s string) (uint8, uint8, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+	min, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid difficulty %q", s)
+	}
+	max, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil || max < min || min == 0 {
+		return 0, 0, fmt.Errorf("invalid difficulty %q", s)
+	}
+	return uint8(min), uint8(max), nil
+}
+
+// the first node is the hub of the star, and a worker
+var connectors = map[string]func([]enode.ID) steps.Action{
+	"ring": steps.ConnectRing,
+	"star": steps.ConnectStar,
+	"full": steps.ConnectAll,
+}
+
+// the nodes at even places are workers, the others submit jobs to the workers they are connected to
+// in a ring every submitter is between two workers
+func newScenario(c cell, rnd *rand.Rand, tally *int) *steps.Scenario {
+	name := c.String()
+	return &steps.Scenario{
+		Name:    name,
+		Timeout: *stepTimeout,
+		Network: func() (*simulations.Network, []enode.ID, error) {
+			return steps.NewSimNetwork(name, newServices(name, c), "demo", *nodeCount)
+		},
+		Steps: func(ids []enode.ID) []*steps.Step {
+			var workers, submitters []enode.ID
+			for i, id := range ids {
+				if i%2 == 0 {
+					workers = append(workers, id)
+				} else {
+					submitters = append(submitters, id)
+				}
+			}
+			done := steps.CallCount("demo_listJobs", &service.JobFilter{State: service.JobDone})
+			return []*steps.Step{
+				{
+					Name:   "connect",
+					Action: connectors[c.topology](ids),
+					Nodes:  steps.All(ids),
+					Check:  steps.WaitHealthy(1),
+				},
+				// the hub of the star stays, or there would be nothing left to test
+				{
+					Name:   "churn",
+					Action: steps.StopFraction(workers[1:], c.churn, rnd),
+				},
+				{
+					Name:  "submit",
+					Nodes: withWorker(submitters, workers),
+					Check: steps.ExpectCountAtLeast(*minResults, done),
+				},
+				{
+					Name: "tally",
+					Action: func(ctx context.Context, net *simulations.Network) error {
+						for _, id := range steps.Up(submitters)(net) {
+							client, err := net.GetNode(id).Client()
+							if err != nil {
+								return err
+							}
+							n, err := done(ctx, client)
+							if err != nil {
+								return err
+							}
+							*tally += n
+						}
+						return nil
+					},
+				},
+			}
+		},
+	}
+}
+
+// the submitters which are up and connected to a worker that is up; the others have no one to send jobs to
+func withWorker(submitters, workers []enode.ID) steps.NodeSet {
+	return func(net *simulations.Network) []enode.ID {
+		var ids []enode.ID
+		up := steps.Up(workers)(net)
+		for _, s := range steps.Up(submitters)(net) {
+			for _, w := range up {
+				if conn := net.GetConn(s, w); conn != nil && conn.Up {
+					ids = append(ids, s)
+					break
+				}
+			}
+		}
+		return ids
+	}
+}
+
+// the services share nothing with those of the other scenarios
+// the place of a node is the number at the end of its name, given by steps.NewSimNetwork
+func newServices(network string, c cell) adapters.Services {
+	return adapters.Services{
+		"demo": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			i, err := strconv.Atoi(strings.TrimPrefix(ctx.Config.Name, network+"-"))
+			if err != nil {
+				return nil, fmt.Errorf("node %s not of network %s", ctx.Config.Name, network)
+			}
+			params := service.NewDemoParams(nil, nil)
+			params.Id = ctx.Config.ID[:]
+			params.Pow = pw
+			params.MaxTimePerJob = defaultMaxTime
+			if i%2 == 0 {
+				params.MinDifficulty = c.min
+				params.MaxDifficulty = c.max
+				params.MaxJobs = defaultMaxJobs
+				params.QueueSize = defaultQueueSize
+			}
+			params.SubmitDelay = defaultSubmitDelay
+			params.SubmitDataSize = defaultDataSize
+			params.MinSubmitDifficulty = c.min
+			params.MaxSubmitDifficulty = c.max
+			return service.NewDemo(params)
+		},
+	}
+}
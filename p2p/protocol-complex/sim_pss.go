@@ -28,6 +28,7 @@ import (
 	"./protocol"
 	"./resource"
 	"./service"
+	"./service/pow"
 )
 
 const (
@@ -38,11 +39,15 @@ const (
 	defaultMaxTime       = time.Second * 15
 	defaultSimDuration   = time.Second * 1
 	defaultMaxJobs       = 100
+	defaultCacheSize     = 1024
+	defaultQueueSize     = 8
 	//defaultResourceApiHost = "http://localhost:8500"
 )
 
 var (
 	loglevel = flag.Bool("v", false, "loglevel")
+	codec    = flag.String("codec", "rlp", "encoding of the protocol messages, rlp or protobuf; all nodes must use the same")
+	powName  = flag.String("pow", "sha1", "proof of work algorithm, sha1, sha3 or ethash-lite; all nodes must use the same")
 	//useResource   = flag.Bool("r", false, "use resource sink")
 	ensAddr       = flag.String("e", "", "ens name to post resource updates")
 	maxDifficulty uint8
@@ -50,6 +55,7 @@ var (
 	maxTime       time.Duration
 	maxJobs       int
 	privateKeys   map[enode.ID]*ecdsa.PrivateKey
+	pw            pow.Pow // the proof of work algorithm chosen with -pow
 )
 
 func init() {
@@ -58,6 +64,13 @@ func init() {
 		log.PrintOrigins(true)
 		log.Root().SetHandler(log.LvlFilterHandler(log.LvlDebug, log.StreamHandler(colorable.NewColorableStderr(), log.TerminalFormat(true))))
 	}
+	if err := protocol.SetCodec(*codec); err != nil {
+		log.Crit("codec fail", "err", err)
+	}
+	var err error
+	if pw, err = pow.New(*powName); err != nil {
+		log.Crit("pow fail", "err", err)
+	}
 
 	maxDifficulty = defaultMaxDifficulty
 	minDifficulty = defaultMinDifficulty
@@ -140,16 +153,8 @@ func main() {
 				}(nid)
 				continue
 			}
-			client, err := n.GetNode(nid).Client()
-			if err != nil {
-				return err
-			}
-
-			err = client.Call(nil, "demo_setDifficulty", 0)
-			if err != nil {
-				return err
-			}
-
+			// the other nodes are created without difficulty, so they tell their peers they don't take jobs
+			// they learn from the skills the worker announced which jobs they can send it
 			go func(nid enode.ID) {
 				timer := time.NewTimer(defaultSimDuration)
 				for {
@@ -283,10 +288,14 @@ func newServices() adapters.Services {
 			//				sinkFunc = resourceapi.ResourceSinkFunc()
 			//			}
 			params := service.NewDemoParams(sinkFunc, saveFunc)
+			params.Pow = pw
 			params.MaxJobs = maxJobs
 			params.MaxTimePerJob = maxTime
 			if !haveWorker {
 				params.MaxDifficulty = maxDifficulty
+				params.MinDifficulty = minDifficulty
+				params.CacheSize = defaultCacheSize
+				params.QueueSize = defaultQueueSize
 				haveWorker = true
 			}
 			params.SubmitDelay = defaultSubmitDelay
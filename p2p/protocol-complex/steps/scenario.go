@@ -0,0 +1,139 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+// Scenario is steps run on a network of their own
+// the network is made for the scenario and shut down after it, so scenarios can run at the same time
+// without sharing anything, as long as the services of their nodes don't share anything either
+type Scenario struct {
+	Name string
+	// makes the network, with its nodes started
+	Network func() (*simulations.Network, []enode.ID, error)
+	// the steps to run on the nodes of the network
+	Steps func(ids []enode.ID) []*Step
+	// how long a step may take, unless it says otherwise, the default of the runner if 0
+	Timeout time.Duration
+}
+
+// ScenarioResult is how the scenario went
+type ScenarioResult struct {
+	Name string
+	// the results of the steps that were run, the failed one last
+	Steps []*simulations.StepResult
+	// why the scenario failed, nil if it passed
+	Err  error
+	Took time.Duration
+}
+
+// Run makes the network of the scenario, runs its steps, and shuts the network down
+func (s *Scenario) Run(ctx context.Context) *ScenarioResult {
+	result := &ScenarioResult{Name: s.Name}
+	start := time.Now()
+	defer func() { result.Took = time.Since(start) }()
+
+	net, ids, err := s.Network()
+	if err != nil {
+		result.Err = fmt.Errorf("network: %v", err)
+		return result
+	}
+	defer net.Shutdown()
+	runner := NewRunner(net)
+	runner.log = log.New("scenario", s.Name)
+	if s.Timeout != 0 {
+		runner.Timeout = s.Timeout
+	}
+	result.Steps, result.Err = runner.Run(ctx, s.Steps(ids)...)
+	return result
+}
+
+// RunScenarios runs the scenarios, up to parallel of them at the same time, or all of them if it is 0
+// the results are in the order of the scenarios
+func RunScenarios(ctx context.Context, scenarios []*Scenario, parallel int) []*ScenarioResult {
+	if parallel <= 0 || parallel > len(scenarios) {
+		parallel = len(scenarios)
+	}
+	results := make([]*ScenarioResult, len(scenarios))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, s := range scenarios {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, s *Scenario) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = s.Run(ctx)
+		}(i, s)
+	}
+	wg.Wait()
+	return results
+}
+
+// Failed is the results of the scenarios that failed
+func Failed(results []*ScenarioResult) []*ScenarioResult {
+	var failed []*ScenarioResult
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// NodeConfig is the config of a node of the sim adapter
+// adapters.RandomNodeConfig takes a free tcp port of the host for each node, which the sim adapter doesn't use,
+// and which many networks made at the same time could run out of
+func NodeConfig(name string) (*adapters.NodeConfig, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	id := enode.PubkeyToIDV4(&key.PublicKey)
+	if name == "" {
+		name = fmt.Sprintf("node_%s", id.String())
+	}
+	return &adapters.NodeConfig{
+		ID:              id,
+		Name:            name,
+		PrivateKey:      key,
+		EnableMsgEvents: true,
+	}, nil
+}
+
+// NewSimNetwork makes a network of the sim adapter with count nodes running the service, and starts them
+// the nodes talk over pipes in memory, so no port of the host is taken
+func NewSimNetwork(id string, services adapters.Services, service string, count int) (*simulations.Network, []enode.ID, error) {
+	net := simulations.NewNetwork(adapters.NewSimAdapter(services), &simulations.NetworkConfig{
+		ID:             id,
+		DefaultService: service,
+	})
+	var ids []enode.ID
+	for i := 0; i < count; i++ {
+		config, err := NodeConfig(fmt.Sprintf("%s-%d", id, i))
+		if err != nil {
+			net.Shutdown()
+			return nil, nil, err
+		}
+		node, err := net.NewNodeWithConfig(config)
+		if err != nil {
+			net.Shutdown()
+			return nil, nil, err
+		}
+		ids = append(ids, node.ID())
+	}
+	if err := net.StartAll(); err != nil {
+		net.Shutdown()
+		return nil, nil, err
+	}
+	return net, ids, nil
+}
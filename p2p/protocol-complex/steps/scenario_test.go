@@ -0,0 +1,110 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+var counterServices = adapters.Services{
+	"counter": func(*adapters.ServiceContext) (node.Service, error) {
+		return &counterService{}, nil
+	},
+}
+
+func TestConnectRingStar(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		connect func([]enode.ID) Action
+		// the least peers of all the nodes, and of the first
+		peers, first int
+	}{
+		{"ring", ConnectRing, 2, 2},
+		{"star", ConnectStar, 1, 3},
+	} {
+		net, ids, err := NewSimNetwork(c.name, counterServices, "counter", 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewRunner(net).Run(context.Background(),
+			&Step{Name: "connect", Action: c.connect(ids), Nodes: All(ids), Check: WaitHealthy(c.peers)},
+			&Step{Name: "first", Nodes: All(ids[:1]), Check: WaitHealthy(c.first)},
+		)
+		net.Shutdown()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+	}
+}
+
+// the scenarios run on networks of their own, no more than parallel at once, and the failed one is told apart
+func TestRunScenarios(t *testing.T) {
+	var running, most int32
+	var scenarios []*Scenario
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("scenario%d", i)
+		fail := i == 2
+		scenarios = append(scenarios, &Scenario{
+			Name: name,
+			Network: func() (*simulations.Network, []enode.ID, error) {
+				return NewSimNetwork(name, counterServices, "counter", 3)
+			},
+			Steps: func(ids []enode.ID) []*Step {
+				min := 0
+				if fail {
+					min = 1
+				}
+				return []*Step{
+					{Name: "connect", Action: ConnectRing(ids), Nodes: All(ids), Check: WaitHealthy(2)},
+					{Name: "hold", Action: func(context.Context, *simulations.Network) error {
+						n := atomic.AddInt32(&running, 1)
+						for {
+							m := atomic.LoadInt32(&most)
+							if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+								break
+							}
+						}
+						time.Sleep(time.Millisecond * 200)
+						atomic.AddInt32(&running, -1)
+						return nil
+					}},
+					{Name: "count", Nodes: All(ids), Check: ExpectCountAtLeast(min, CallCount("counter_get")), Timeout: time.Millisecond * 300},
+				}
+			},
+		})
+	}
+
+	results := RunScenarios(context.Background(), scenarios, 2)
+	if len(results) != len(scenarios) {
+		t.Fatalf("%d results", len(results))
+	}
+	for i, r := range results {
+		if r.Name != scenarios[i].Name {
+			t.Fatalf("result %d of %s", i, r.Name)
+		}
+	}
+	failed := Failed(results)
+	if len(failed) != 1 || failed[0].Name != "scenario2" || len(failed[0].Steps) != 3 {
+		t.Fatalf("failed %+v", failed)
+	}
+	if most != 2 {
+		t.Fatalf("%d scenarios at once, want 2", most)
+	}
+}
+
+func TestNodeConfig(t *testing.T) {
+	config, err := NodeConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != 0 || config.ID != enode.PubkeyToIDV4(&config.PrivateKey.PublicKey) {
+		t.Fatalf("config %+v", config)
+	}
+}
@@ -0,0 +1,335 @@
+// Package steps has the actions and checks simulation scenarios are made of
+//
+// A step of a p2p/simulations scenario is an action, and an expectation that each of a set of nodes
+// is checked against until all of them pass. The simulations package leaves it to the scenario
+// to say when a node should be checked, with node ids sent on a trigger channel, and the scenarios
+// ended up each with a trigger loop and an action and check of their own.
+//
+// Here a Step is the action, the nodes and the check only. The Runner polls the check of each node
+// until it passes, and runs the steps one after the other, so a scenario is a list of steps made of
+// the actions and checks of this package, or of its own where none fit:
+//
+//	runner := steps.NewRunner(net)
+//	err := runner.Run(ctx,
+//		&steps.Step{Name: "connect", Action: steps.ConnectAll(ids), Nodes: steps.All(ids), Check: steps.WaitHealthy(len(ids) - 1)},
+//		&steps.Step{Name: "churn", Action: steps.StopFraction(ids, 0.2, rnd)},
+//		&steps.Step{Name: "jobs", Nodes: steps.Up(ids), Check: steps.ExpectCountAtLeast(3, steps.CallCount("demo_listJobs", filter))},
+//	)
+package steps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	defaultPoll        = time.Millisecond * 100
+	defaultStepTimeout = time.Second * 30
+)
+
+// Action changes the network, or makes its nodes do something
+type Action func(ctx context.Context, net *simulations.Network) error
+
+// Check tells whether the node meets the expectation yet
+// an error ends the step, where the node may still pass later it should return false without one
+type Check func(ctx context.Context, net *simulations.Network, id enode.ID) (bool, error)
+
+// NodeSet is the nodes a check is for, taken when the action of the step is done
+type NodeSet func(net *simulations.Network) []enode.ID
+
+// Counter counts something on a node over its rpc client
+type Counter func(ctx context.Context, client *rpc.Client) (int, error)
+
+// Step is an action, and a check all the nodes of the set must pass after it
+// without an action the step only waits, without nodes or a check it is done when the action is
+type Step struct {
+	Name   string
+	Action Action
+	Nodes  NodeSet
+	Check  Check
+	// how long the step may take, the timeout of the runner if 0
+	Timeout time.Duration
+}
+
+// Runner runs steps on a simulated network
+type Runner struct {
+	net *simulations.Network
+	sim *simulations.Simulation
+	log log.Logger
+
+	// how often a node that didn't pass yet is checked again
+	Poll time.Duration
+	// how long a step may take, unless it says otherwise
+	Timeout time.Duration
+}
+
+func NewRunner(net *simulations.Network) *Runner {
+	return &Runner{
+		net:     net,
+		sim:     simulations.NewSimulation(net),
+		log:     log.Root(),
+		Poll:    defaultPoll,
+		Timeout: defaultStepTimeout,
+	}
+}
+
+// Run runs the steps in order, and stops at the first that fails
+// it returns the results of the steps that were run, the failed one last
+func (r *Runner) Run(ctx context.Context, steps ...*Step) ([]*simulations.StepResult, error) {
+	var results []*simulations.StepResult
+	for i, step := range steps {
+		name := step.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i)
+		}
+		result := r.run(ctx, step)
+		results = append(results, result)
+		if result.Error != nil {
+			return results, fmt.Errorf("step %s: %v", name, result.Error)
+		}
+		r.log.Info("step done", "step", name, "nodes", len(result.Passes), "took", result.FinishedAt.Sub(result.StartedAt))
+	}
+	return results, nil
+}
+
+func (r *Runner) run(ctx context.Context, step *Step) *simulations.StepResult {
+	timeout := step.Timeout
+	if timeout == 0 {
+		timeout = r.Timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// the nodes are only known once the action is done, the expectation is filled in then
+	// and the trigger polls them until the step is over
+	expect := &simulations.Expectation{
+		Check: func(ctx context.Context, id enode.ID) (bool, error) {
+			if step.Check == nil {
+				return true, nil
+			}
+			return step.Check(ctx, r.net, id)
+		},
+	}
+	trigger := make(chan enode.ID)
+	done := make(chan struct{})
+	defer close(done)
+	action := func(ctx context.Context) error {
+		if step.Action != nil {
+			if err := step.Action(ctx, r.net); err != nil {
+				return err
+			}
+		}
+		if step.Nodes != nil {
+			expect.Nodes = step.Nodes(r.net)
+		}
+		go r.poll(expect.Nodes, trigger, done)
+		return nil
+	}
+	return r.sim.Run(ctx, &simulations.Step{
+		Action:  action,
+		Trigger: trigger,
+		Expect:  expect,
+	})
+}
+
+// sends the nodes to be checked on the trigger, all of them every poll, until the step is done
+func (r *Runner) poll(ids []enode.ID, trigger chan enode.ID, done chan struct{}) {
+	ticker := time.NewTicker(r.Poll)
+	defer ticker.Stop()
+	for {
+		for _, id := range ids {
+			select {
+			case trigger <- id:
+			case <-done:
+				return
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// All is the nodes given
+func All(ids []enode.ID) NodeSet {
+	return func(*simulations.Network) []enode.ID {
+		return ids
+	}
+}
+
+// Up is those of the nodes given that are up
+func Up(ids []enode.ID) NodeSet {
+	return func(net *simulations.Network) []enode.ID {
+		var up []enode.ID
+		for _, id := range ids {
+			if node := net.GetNode(id); node != nil && node.Up {
+				up = append(up, id)
+			}
+		}
+		return up
+	}
+}
+
+// ConnectAll connects each of the nodes to each of the others, skipping the ones connected already
+func ConnectAll(ids []enode.ID) Action {
+	return func(ctx context.Context, net *simulations.Network) error {
+		for i, one := range ids {
+			for _, other := range ids[i+1:] {
+				if err := connect(net, one, other); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// ConnectRing connects each of the nodes to the next, and the last to the first
+func ConnectRing(ids []enode.ID) Action {
+	return func(ctx context.Context, net *simulations.Network) error {
+		for i, one := range ids {
+			other := ids[(i+1)%len(ids)]
+			// two nodes are a line, the way back is the same connection
+			if one == other || (len(ids) == 2 && i == 1) {
+				continue
+			}
+			if err := connect(net, one, other); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ConnectStar connects the first of the nodes to each of the others
+func ConnectStar(ids []enode.ID) Action {
+	return func(ctx context.Context, net *simulations.Network) error {
+		for _, other := range ids[1:] {
+			if err := connect(net, ids[0], other); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func connect(net *simulations.Network, one, other enode.ID) error {
+	if conn := net.GetConn(one, other); conn != nil && conn.Up {
+		return nil
+	}
+	return net.Connect(one, other)
+}
+
+// StopFraction stops that share of the nodes which are up, rounded up, picked at random
+// rnd may be nil for the default source
+func StopFraction(ids []enode.ID, fraction float64, rnd *rand.Rand) Action {
+	return func(ctx context.Context, net *simulations.Network) error {
+		if fraction < 0 || fraction > 1 {
+			return fmt.Errorf("fraction %v not between 0 and 1", fraction)
+		}
+		up := Up(ids)(net)
+		perm := rand.Perm
+		if rnd != nil {
+			perm = rnd.Perm
+		}
+		count := int(math.Ceil(float64(len(up)) * fraction))
+		for _, i := range perm(len(up))[:count] {
+			log.Debug("stopping node", "id", up[i])
+			if err := net.Stop(up[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// SendN has each of the nodes send n times, with its rpc client, i counting from 0 to n-1
+func SendN(ids []enode.ID, n int, send func(ctx context.Context, client *rpc.Client, i int) error) Action {
+	return func(ctx context.Context, net *simulations.Network) error {
+		for _, id := range ids {
+			client, err := nodeClient(net, id)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < n; i++ {
+				if err := send(ctx, client, i); err != nil {
+					return fmt.Errorf("send %d from %s: %v", i, id.TerminalString(), err)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// WaitHealthy passes when the node has at least that many peers
+// the peers are those of the node's p2p server, so it works for the nodes of the sim adapter only
+func WaitHealthy(minPeers int) Check {
+	return func(ctx context.Context, net *simulations.Network, id enode.ID) (bool, error) {
+		node := net.GetNode(id)
+		if node == nil {
+			return false, fmt.Errorf("unknown node %s", id.TerminalString())
+		}
+		if !node.Up {
+			return false, nil
+		}
+		server, ok := node.Node.(interface{ Server() *p2p.Server })
+		if !ok {
+			return false, errors.New("node has no p2p server to count the peers of")
+		}
+		return server.Server().PeerCount() >= minPeers, nil
+	}
+}
+
+// ExpectCountAtLeast passes when the count on the node is at least min
+func ExpectCountAtLeast(min int, count Counter) Check {
+	return func(ctx context.Context, net *simulations.Network, id enode.ID) (bool, error) {
+		client, err := nodeClient(net, id)
+		if err != nil {
+			return false, err
+		}
+		n, err := count(ctx, client)
+		if err != nil {
+			return false, fmt.Errorf("count on %s: %v", id.TerminalString(), err)
+		}
+		return n >= min, nil
+	}
+}
+
+// CallCount counts with an rpc method which returns a number, or a list to count the items of
+func CallCount(method string, args ...interface{}) Counter {
+	return func(ctx context.Context, client *rpc.Client) (int, error) {
+		var result interface{}
+		if err := client.CallContext(ctx, &result, method, args...); err != nil {
+			return 0, err
+		}
+		switch v := result.(type) {
+		case float64:
+			return int(v), nil
+		case []interface{}:
+			return len(v), nil
+		case nil:
+			return 0, nil
+		}
+		return 0, fmt.Errorf("%s returned %T, not a number or a list", method, result)
+	}
+}
+
+func nodeClient(net *simulations.Network, id enode.ID) (*rpc.Client, error) {
+	node := net.GetNode(id)
+	if node == nil {
+		return nil, fmt.Errorf("unknown node %s", id.TerminalString())
+	}
+	return node.Client()
+}
@@ -0,0 +1,178 @@
+package steps
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// a service with a protocol that does nothing, so the nodes have something to connect with,
+// and a counter to count up over rpc
+type counterService struct {
+	count int64
+}
+
+func (s *counterService) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{
+		{
+			Name:    "idle",
+			Version: 1,
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				_, err := rw.ReadMsg()
+				return err
+			},
+		},
+	}
+}
+
+func (s *counterService) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "counter",
+			Version:   "1.0",
+			Service:   &CounterAPI{s},
+			Public:    true,
+		},
+	}
+}
+
+func (s *counterService) Start(*p2p.Server) error { return nil }
+func (s *counterService) Stop() error             { return nil }
+
+type CounterAPI struct {
+	s *counterService
+}
+
+func (api *CounterAPI) Add(n int64) {
+	atomic.AddInt64(&api.s.count, n)
+}
+
+func (api *CounterAPI) Get() int64 {
+	return atomic.LoadInt64(&api.s.count)
+}
+
+// the values up to the count, for a method returning a list
+func (api *CounterAPI) List() []int64 {
+	var list []int64
+	for i := int64(0); i < atomic.LoadInt64(&api.s.count); i++ {
+		list = append(list, i)
+	}
+	return list
+}
+
+func newTestNetwork(t *testing.T, count int) (*simulations.Network, []enode.ID) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"counter": func(*adapters.ServiceContext) (node.Service, error) {
+			return &counterService{}, nil
+		},
+	})
+	net := simulations.NewNetwork(adapter, &simulations.NetworkConfig{DefaultService: "counter"})
+	var ids []enode.ID
+	for i := 0; i < count; i++ {
+		n, err := net.NewNodeWithConfig(adapters.RandomNodeConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := net.Start(n.ID()); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, n.ID())
+	}
+	return net, ids
+}
+
+func TestConnectAll(t *testing.T) {
+	net, ids := newTestNetwork(t, 4)
+	defer net.Shutdown()
+	runner := NewRunner(net)
+	connect := &Step{Name: "connect", Action: ConnectAll(ids), Nodes: All(ids), Check: WaitHealthy(len(ids) - 1)}
+	results, err := runner.Run(context.Background(), connect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0].Passes) != len(ids) {
+		t.Fatalf("results %+v", results)
+	}
+	// again, with all the connections there already
+	if _, err := runner.Run(context.Background(), connect); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendN(t *testing.T) {
+	net, ids := newTestNetwork(t, 3)
+	defer net.Shutdown()
+	send := SendN(ids, 5, func(ctx context.Context, client *rpc.Client, i int) error {
+		return client.CallContext(ctx, nil, "counter_add", i)
+	})
+	// 0+1+2+3+4 on each node
+	_, err := NewRunner(net).Run(context.Background(),
+		&Step{Name: "send", Action: send, Nodes: All(ids), Check: ExpectCountAtLeast(10, CallCount("counter_get"))},
+		&Step{Name: "list", Nodes: All(ids), Check: ExpectCountAtLeast(10, CallCount("counter_list"))},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a check that doesn't pass ends the step at its timeout, and the steps after it aren't run
+func TestTimeout(t *testing.T) {
+	net, ids := newTestNetwork(t, 2)
+	defer net.Shutdown()
+	runner := NewRunner(net)
+	runner.Poll = time.Millisecond * 10
+	ran := false
+	results, err := runner.Run(context.Background(),
+		&Step{Name: "never", Nodes: All(ids), Check: ExpectCountAtLeast(1, CallCount("counter_get")), Timeout: time.Millisecond * 200},
+		&Step{Name: "after", Action: func(context.Context, *simulations.Network) error {
+			ran = true
+			return nil
+		}},
+	)
+	if err == nil || !strings.Contains(err.Error(), "never") {
+		t.Fatalf("error %v", err)
+	}
+	if len(results) != 1 || ran {
+		t.Fatalf("%d results, after ran %v", len(results), ran)
+	}
+}
+
+func TestStopFraction(t *testing.T) {
+	net, ids := newTestNetwork(t, 5)
+	defer net.Shutdown()
+	rnd := rand.New(rand.NewSource(1))
+	var up []enode.ID
+	_, err := NewRunner(net).Run(context.Background(),
+		&Step{Name: "stop", Action: StopFraction(ids, 0.3, rnd)},
+		&Step{Name: "up", Action: func(ctx context.Context, net *simulations.Network) error {
+			up = Up(ids)(net)
+			return nil
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0.3 of 5 is rounded up to 2
+	if len(up) != 3 {
+		t.Fatalf("%d nodes up, want 3", len(up))
+	}
+	if err := StopFraction(ids, 1.5, nil)(context.Background(), net); err == nil {
+		t.Fatal("fraction over 1 taken")
+	}
+	if err := StopFraction(ids, 1, nil)(context.Background(), net); err != nil {
+		t.Fatal(err)
+	}
+	if up := Up(ids)(net); len(up) != 0 {
+		t.Fatalf("%d nodes up after stopping all", len(up))
+	}
+}
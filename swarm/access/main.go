@@ -0,0 +1,286 @@
+// restricts access to content in swarm by password, by key and by a list of grantees, and shows who gets in
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/swarm"
+	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/api/client"
+)
+
+var (
+	portFlag    = flag.Int("port", 8542, "port of the http gateway of the first node, the others use the ports after it")
+	verboseFlag = flag.Bool("v", false, "print debug output")
+)
+
+var (
+	secret   = []byte("the treasure is buried under the third palm tree")
+	password = "open sesame"
+)
+
+func init() {
+	flag.Parse()
+	if *verboseFlag {
+		log.Root().SetHandler(log.CallerFileHandler(log.LvlFilterHandler(log.LvlDebug, log.StreamHandler(os.Stderr, log.TerminalFormat(false)))))
+	}
+}
+
+// a swarm node and the key its api decrypts with
+type bzzNode struct {
+	name    string
+	stack   *node.Node
+	key     *ecdsa.PrivateKey
+	gateway string
+}
+
+// the publisher uploads, the grantee is given access, and the outsider isn't
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	datadir, err := ioutil.TempDir("", "swarm-access")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(datadir)
+
+	var nodes []*bzzNode
+	for i, name := range []string{"publisher", "grantee", "outsider"} {
+		n, err := newNode(name, datadir, *portFlag+i)
+		if err != nil {
+			return fmt.Errorf("node %s fail: %v", name, err)
+		}
+		defer n.stack.Stop()
+		nodes = append(nodes, n)
+	}
+	publisher, grantee, outsider := nodes[0], nodes[1], nodes[2]
+
+	// the content has to travel from the publisher to the others, so they're all connected
+	for _, n := range nodes[1:] {
+		n.stack.Server().AddPeer(publisher.stack.Server().Self())
+	}
+	outsider.stack.Server().AddPeer(grantee.stack.Server().Self())
+	for _, n := range nodes {
+		if err := waitGateway(n.gateway, time.Second*10); err != nil {
+			return err
+		}
+	}
+
+	// the content is uploaded encrypted, in a manifest that is encrypted too
+	// the reference to the manifest is the hash and the key to decrypt it with, whoever has it can read the content
+	bzz := client.NewClient(publisher.gateway)
+	ref, err := bzz.UploadRaw(bytes.NewReader(secret), int64(len(secret)), true)
+	if err != nil {
+		return fmt.Errorf("upload fail: %v", err)
+	}
+	ref, err = bzz.UploadManifest(&api.Manifest{
+		Entries: []api.ManifestEntry{
+			{
+				Hash:        ref,
+				ContentType: "text/plain",
+				Size:        int64(len(secret)),
+			},
+		},
+	}, true)
+	if err != nil {
+		return fmt.Errorf("manifest upload fail: %v", err)
+	}
+	fmt.Printf("encrypted content has reference %s...\n", ref[:32])
+
+	// the access controlled manifests give out the reference encrypted in turn, with a key only the ones with access can make
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	// by password, the key is derived from it with scrypt and the salt
+	sessionKey, ae, err := api.DoPassword(nil, password, salt)
+	if err != nil {
+		return err
+	}
+	passRoot, err := uploadAccess(bzz, ref, sessionKey, ae)
+	if err != nil {
+		return err
+	}
+
+	// by key, the key is the shared secret of the publisher key and the one of the grantee
+	// the publisher public key is in the manifest, so the grantee can make the shared secret with its own private key
+	pub := hex.EncodeToString(crypto.CompressPubkey(&grantee.key.PublicKey))
+	sessionKey, ae, err = api.DoPK(nil, publisher.key, pub, salt)
+	if err != nil {
+		return err
+	}
+	pkRoot, err := uploadAccess(bzz, ref, sessionKey, ae)
+	if err != nil {
+		return err
+	}
+
+	// by a list of grantees, the access control trie
+	// the content key is encrypted with the session key of each grantee, and the act manifest has an entry for each
+	// the path of an entry is the hash of the session key, so only a grantee knows where its own entry is
+	accessKey, ae, act, err := api.DoACT(nil, publisher.key, salt, []string{pub}, nil)
+	if err != nil {
+		return err
+	}
+	ae.Act, err = bzz.UploadManifest(act, false)
+	if err != nil {
+		return fmt.Errorf("act upload fail: %v", err)
+	}
+	actRoot, err := uploadAccess(bzz, ref, accessKey, ae)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%-10s %-10s %-14s %s\n", "access", "node", "credentials", "answer")
+	for _, c := range []struct {
+		access      string
+		root        string
+		n           *bzzNode
+		credentials string
+	}{
+		{"password", passRoot, grantee, password},
+		{"password", passRoot, outsider, "not sesame"},
+		{"password", passRoot, outsider, ""},
+		{"password", passRoot, outsider, password},
+		{"key", pkRoot, grantee, ""},
+		{"key", pkRoot, outsider, ""},
+		{"act", actRoot, publisher, ""},
+		{"act", actRoot, grantee, ""},
+		{"act", actRoot, outsider, ""},
+	} {
+		status, body, err := get(c.n.gateway, c.root, c.credentials, time.Second*20)
+		if err != nil {
+			return err
+		}
+		answer := fmt.Sprintf("%d %s", status, http.StatusText(status))
+		if status == http.StatusOK {
+			if !bytes.Equal(body, secret) {
+				return fmt.Errorf("%s got the wrong content with %s access", c.n.name, c.access)
+			}
+			answer += fmt.Sprintf(", '%s'", body)
+		}
+		credentials := "none"
+		if c.credentials != "" {
+			credentials = fmt.Sprintf("'%s'", c.credentials)
+		}
+		fmt.Printf("%-10s %-10s %-14s %s\n", c.access, c.n.name, credentials, answer)
+	}
+	return nil
+}
+
+// uploads the manifest that gives out the reference encrypted with the key
+func uploadAccess(bzz *client.Client, ref string, key []byte, ae *api.AccessEntry) (string, error) {
+	m, err := api.GenerateAccessControlManifest(nil, ref, key, ae)
+	if err != nil {
+		return "", err
+	}
+	root, err := bzz.UploadManifest(m, false)
+	if err != nil {
+		return "", fmt.Errorf("%s access manifest upload fail: %v", ae.Type, err)
+	}
+	fmt.Printf("%-4s access manifest %s\n", ae.Type, root)
+	return root, nil
+}
+
+// a node with nothing but swarm, which doesn't look for peers
+// the swarm api decrypts with the node key
+func newNode(name string, datadir string, port int) (*bzzNode, error) {
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	stack, err := node.New(&node.Config{
+		DataDir: fmt.Sprintf("%s/%s", datadir, name),
+		P2P: p2p.Config{
+			PrivateKey:  privkey,
+			ListenAddr:  "127.0.0.1:0",
+			MaxPeers:    10,
+			NoDiscovery: true,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bzzconfig := api.NewConfig()
+	bzzconfig.Path = stack.InstanceDir()
+	bzzconfig.Init(privkey)
+	bzzconfig.Port = fmt.Sprintf("%d", port)
+	err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		return swarm.NewSwarm(bzzconfig, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := stack.Start(); err != nil {
+		return nil, err
+	}
+	return &bzzNode{
+		name:    name,
+		stack:   stack,
+		key:     privkey,
+		gateway: fmt.Sprintf("http://localhost:%d", port),
+	}, nil
+}
+
+// the http gateway is started in the background, so we wait until it answers
+func waitGateway(gateway string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		res, err := http.Get(gateway + "/bzz-raw:/")
+		if err == nil {
+			res.Body.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("gateway %s not up: %v", gateway, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+// gets the content of the access controlled manifest, with the password as credentials if given
+// the chunks may not have reached the node yet, so not found is tried again until the timeout
+func get(gateway string, root string, credentials string, timeout time.Duration) (int, []byte, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/bzz:/%s/", gateway, root), nil)
+		if err != nil {
+			return 0, nil, err
+		}
+		if credentials != "" {
+			req.SetBasicAuth("", credentials)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0, nil, err
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return 0, nil, err
+		}
+		if res.StatusCode != http.StatusNotFound || time.Now().After(deadline) {
+			return res.StatusCode, body, nil
+		}
+		time.Sleep(time.Millisecond * 500)
+	}
+}
@@ -0,0 +1,155 @@
+// chunks a payload the way swarm does, and puts it back together from the chunks by hand
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/chunk"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+var (
+	sizeFlag    = flag.Int("size", 600000, "size of random payload, if no file is given")
+	fileFlag    = flag.String("f", "", "file to chunk instead of random data")
+	showFlag    = flag.Int("show", 2, "children shown of each node in the tree, the rest are summarized")
+	verboseFlag = flag.Bool("v", false, "print debug output")
+)
+
+func init() {
+	flag.Parse()
+	if *verboseFlag {
+		log.Root().SetHandler(log.CallerFileHandler(log.LvlFilterHandler(log.LvlDebug, log.StreamHandler(os.Stderr, log.TerminalFormat(false)))))
+	}
+}
+
+// what we find walking the chunk tree
+type walker struct {
+	store     storage.ChunkStore
+	validator *storage.ContentAddressValidator
+	refSize   int
+	data      []byte // the payload, reassembled from the leaves in order
+	chunks    int
+	leaves    int
+	depth     int
+}
+
+func main() {
+	var data []byte
+	var err error
+	if *fileFlag != "" {
+		data, err = ioutil.ReadFile(*fileFlag)
+	} else {
+		data = make([]byte, *sizeFlag)
+		_, err = rand.Read(data)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+
+	// the chunks are kept in memory only, the store doesn't care what they are
+	store := storage.NewMemStore(storage.NewDefaultStoreParams(), nil)
+	defer store.Close()
+
+	// the hasher store gives each chunk its address, the bmt hash of its span and payload
+	// the tree splitter cuts the payload in chunks, and the references to them in chunks again, until there's one
+	hasher := storage.MakeHashFunc(storage.BMTHash)
+	putter := storage.NewHasherStore(store, hasher, false)
+	root, wait, err := storage.TreeSplit(ctx, bytes.NewReader(data), int64(len(data)), putter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "split fail:", err)
+		os.Exit(1)
+	}
+	if err := wait(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "store fail:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("payload of %d bytes has root hash %s\n\n", len(data), root.Hex())
+
+	// walk the tree from the root, checking every chunk against its address
+	w := &walker{
+		store:     store,
+		validator: storage.NewContentAddressValidator(hasher),
+		refSize:   int(putter.RefSize()),
+	}
+	if err := w.walk(ctx, root, 0, 0, 1, true); err != nil {
+		fmt.Fprintln(os.Stderr, "walk fail:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\n%d chunks, %d of them leaves, tree depth %d\n", w.chunks, w.leaves, w.depth)
+	if !bytes.Equal(w.data, data) {
+		fmt.Fprintln(os.Stderr, "payload reassembled from the leaves differs")
+		os.Exit(1)
+	}
+	fmt.Println("payload reassembled from the leaves matches")
+
+	// what the high level api does, for comparison
+	reader := storage.TreeJoin(ctx, root, putter, 0)
+	joined, err := ioutil.ReadAll(reader)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "join fail:", err)
+		os.Exit(1)
+	}
+	if !bytes.Equal(joined, data) {
+		fmt.Fprintln(os.Stderr, "payload joined by swarm differs")
+		os.Exit(1)
+	}
+	fmt.Println("payload joined by swarm matches")
+}
+
+// a chunk is 8 bytes of span, the little endian size of the data under it, and at most 4096 bytes of payload
+// if the span is bigger than a chunk, the payload is the references to the chunks below, otherwise it's data
+// only some children of the nodes shown are shown in turn
+func (self *walker) walk(ctx context.Context, addr storage.Address, depth int, index int, siblings int, visible bool) error {
+	ch, err := self.store.Get(ctx, addr)
+	if err != nil {
+		return fmt.Errorf("chunk %s: %v", addr.Hex(), err)
+	}
+	if !self.validator.Validate(addr, ch.Data()) {
+		return fmt.Errorf("chunk %s doesn't hash to its address", addr.Hex())
+	}
+	self.chunks++
+	if depth > self.depth {
+		self.depth = depth
+	}
+
+	span := binary.LittleEndian.Uint64(ch.SpanBytes())
+	payload := ch.Payload()
+	show := visible && (index < *showFlag || index == siblings-1)
+	indent := strings.Repeat("  ", depth)
+	if visible && !show && index == *showFlag {
+		fmt.Printf("%s... %d more\n", indent, siblings-*showFlag-1)
+	}
+
+	if span <= chunk.DefaultSize {
+		self.leaves++
+		self.data = append(self.data, payload...)
+		if show {
+			fmt.Printf("%sleaf %s span %d\n", indent, addr.Hex()[:16], span)
+		}
+		return nil
+	}
+
+	refs := len(payload) / self.refSize
+	if show {
+		fmt.Printf("%snode %s span %d, %d children\n", indent, addr.Hex()[:16], span, refs)
+	}
+	for i := 0; i < refs; i++ {
+		ref := storage.Address(payload[i*self.refSize : (i+1)*self.refSize])
+		if err := self.walk(ctx, ref, depth+1, i, refs, show); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+// publishes a site to swarm under an ens name on a simulated chain, and browses it by name from another node
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/contracts/ens"
+	"github.com/ethereum/go-ethereum/contracts/ens/contract"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm"
+	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/api/client"
+)
+
+var (
+	portFlag    = flag.Int("port", 8542, "port of the http gateway of the publisher, the reader uses the port after it")
+	nameFlag    = flag.String("name", "dapp.eth", "ens name to publish the site under, a name in the eth domain")
+	verboseFlag = flag.Bool("v", false, "print debug output")
+)
+
+func init() {
+	flag.Parse()
+	if *verboseFlag {
+		log.Root().SetHandler(log.CallerFileHandler(log.LvlFilterHandler(log.LvlDebug, log.StreamHandler(os.Stderr, log.TerminalFormat(false)))))
+	}
+}
+
+// a file of the site, at the path it's served on
+type file struct {
+	path        string
+	contentType string
+	data        []byte
+}
+
+// the site as first published, and the release after it
+var releases = [][]file{
+	{
+		{"index.html", "text/html; charset=utf-8", []byte(`<html><head><link rel="stylesheet" href="style.css"></head><body>my dapp, release 1</body></html>`)},
+		{"style.css", "text/css; charset=utf-8", []byte("body { font-family: sans-serif; }")},
+		{"docs/intro.html", "text/html; charset=utf-8", []byte("<html><body>how to use the dapp</body></html>")},
+	},
+	{
+		{"index.html", "text/html; charset=utf-8", []byte(`<html><head><link rel="stylesheet" href="style.css"></head><body>my dapp, release 2</body></html>`)},
+		{"style.css", "text/css; charset=utf-8", []byte("body { font-family: serif; }")},
+		{"docs/intro.html", "text/html; charset=utf-8", []byte("<html><body>how to use the dapp</body></html>")},
+	},
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	name := *nameFlag
+	if !strings.HasSuffix(name, ".eth") || strings.Count(name, ".") != 1 {
+		return fmt.Errorf("name %s is not in the eth domain", name)
+	}
+	datadir, err := ioutil.TempDir("", "swarm-ens")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(datadir)
+
+	// the chain is simulated, it mines a block when we tell it to
+	// the owner of the name is funded in the genesis block
+	owner, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	ownerAddr := crypto.PubkeyToAddress(owner.PublicKey)
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		ownerAddr: {Balance: new(big.Int).Mul(big.NewInt(10), big.NewInt(params.Ether))},
+	}, 10000000)
+	registry, ensAddr, err := register(backend, owner, name)
+	if err != nil {
+		return err
+	}
+
+	// the swarm node asks an ethereum node over rpc, the simulated chain is served to it as one
+	chainURL, closeChain, err := serveChain(backend)
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+
+	// the publisher uploads, the reader only knows the name
+	publisher, err := newNode("publisher", datadir, *portFlag, nil)
+	if err != nil {
+		return fmt.Errorf("node publisher fail: %v", err)
+	}
+	defer publisher.Stop()
+	reader, err := newNode("reader", datadir, *portFlag+1, []string{fmt.Sprintf("eth:%s@%s", ensAddr.Hex(), chainURL)})
+	if err != nil {
+		return fmt.Errorf("node reader fail: %v", err)
+	}
+	defer reader.Stop()
+	reader.Server().AddPeer(publisher.Server().Self())
+
+	publisherGateway := fmt.Sprintf("http://localhost:%d", *portFlag)
+	readerGateway := fmt.Sprintf("http://localhost:%d", *portFlag+1)
+	for _, gateway := range []string{publisherGateway, readerGateway} {
+		if err := waitGateway(gateway, time.Second*10); err != nil {
+			return err
+		}
+	}
+	bzz := client.NewClient(publisherGateway)
+
+	for i, site := range releases {
+		fmt.Printf("\nrelease %d\n", i+1)
+
+		// a new release is a new manifest, with a new hash
+		root, err := upload(bzz, site)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("uploaded manifest %s\n", root)
+
+		// the name is pointed at it by setting the content hash in the resolver of the name
+		// the reader sees it as soon as the transaction is mined
+		tx, err := registry.SetContentHash(name, common.HexToHash(root))
+		if err != nil {
+			return fmt.Errorf("set content hash fail: %v", err)
+		}
+		backend.Commit()
+		fmt.Printf("content hash of %s set in tx %s\n", name, tx.Hash().Hex())
+
+		// the bzz-hash scheme gives the hash a name resolves to, without fetching anything
+		status, resolved, err := get(readerGateway, "bzz-hash://"+name+"/", time.Second*20)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusOK || string(resolved) != root {
+			return fmt.Errorf("%s resolves to '%s' (%d), expected %s", name, resolved, status, root)
+		}
+		fmt.Printf("%s resolves to %s on the reader\n", name, resolved)
+
+		// the pages are fetched by name, the chunks come from the publisher
+		// the empty path is the index entry of the manifest
+		for _, f := range append(site, file{"", site[0].contentType, site[0].data}) {
+			uri := fmt.Sprintf("bzz://%s/%s", name, f.path)
+			status, body, err := get(readerGateway, uri, time.Second*20)
+			if err != nil {
+				return err
+			}
+			if status != http.StatusOK || !bytes.Equal(body, f.data) {
+				return fmt.Errorf("%s: status %d, content '%s'", uri, status, body)
+			}
+			fmt.Printf("%-32s %d %s\n", uri, status, body)
+		}
+	}
+	return nil
+}
+
+// deploys ens, registers the name, and gives it a resolver
+// ens is a tree of names, each owned by someone, and a name is registered by the owner of the name above it
+func register(backend *backends.SimulatedBackend, key *ecdsa.PrivateKey, name string) (*ens.ENS, common.Address, error) {
+	opts := bind.NewKeyedTransactor(key)
+
+	// the registry, with a first come first served registrar owning the root
+	ensAddr, registry, err := ens.DeployENS(opts, backend)
+	if err != nil {
+		return nil, ensAddr, fmt.Errorf("deploy ens fail: %v", err)
+	}
+	backend.Commit()
+
+	// the root registrar gives eth to whoever asks first, which is us
+	// we hand it to a registrar of its own, which does the same for the names in eth
+	if _, err := registry.Register("eth"); err != nil {
+		return nil, ensAddr, fmt.Errorf("register eth fail: %v", err)
+	}
+	ethRegistrar, _, _, err := contract.DeployFIFSRegistrar(opts, backend, ensAddr, ens.EnsNode("eth"))
+	if err != nil {
+		return nil, ensAddr, fmt.Errorf("deploy eth registrar fail: %v", err)
+	}
+	backend.Commit()
+	if _, err := registry.SetOwner(ens.EnsNode("eth"), ethRegistrar); err != nil {
+		return nil, ensAddr, fmt.Errorf("set eth owner fail: %v", err)
+	}
+	backend.Commit()
+
+	// the name is ours now, but doesn't resolve to anything before it has a resolver
+	// the public resolver lets the owner of a name set its content hash
+	if _, err := registry.Register(name); err != nil {
+		return nil, ensAddr, fmt.Errorf("register %s fail: %v", name, err)
+	}
+	resolverAddr, _, _, err := contract.DeployPublicResolver(opts, backend, ensAddr)
+	if err != nil {
+		return nil, ensAddr, fmt.Errorf("deploy resolver fail: %v", err)
+	}
+	backend.Commit()
+	if _, err := registry.SetResolver(ens.EnsNode(name), resolverAddr); err != nil {
+		return nil, ensAddr, fmt.Errorf("set resolver fail: %v", err)
+	}
+	backend.Commit()
+
+	nameOwner, err := registry.Owner(ens.EnsNode(name))
+	if err != nil {
+		return nil, ensAddr, err
+	}
+	fmt.Printf("ens at %s, %s owned by %s, resolver at %s\n", ensAddr.Hex(), name, nameOwner.Hex(), resolverAddr.Hex())
+	return registry, ensAddr, nil
+}
+
+// uploads the files of the site, and a manifest giving them their paths
+func upload(bzz *client.Client, site []file) (string, error) {
+	manifest := &api.Manifest{}
+	for _, f := range site {
+		hash, err := bzz.UploadRaw(bytes.NewReader(f.data), int64(len(f.data)), false)
+		if err != nil {
+			return "", fmt.Errorf("upload %s fail: %v", f.path, err)
+		}
+		manifest.Entries = append(manifest.Entries, api.ManifestEntry{
+			Hash:        hash,
+			Path:        f.path,
+			ContentType: f.contentType,
+			Size:        int64(len(f.data)),
+		})
+	}
+	index := manifest.Entries[0]
+	index.Path = ""
+	manifest.Entries = append(manifest.Entries, index)
+	root, err := bzz.UploadManifest(manifest, false)
+	if err != nil {
+		return "", fmt.Errorf("manifest upload fail: %v", err)
+	}
+	return root, nil
+}
+
+// the calls the ens client of swarm makes, answered by the simulated chain
+// it only reads, and only from the latest block
+// the rpc server only takes exported types, hence the capitals
+type ChainAPI struct {
+	backend *backends.SimulatedBackend
+}
+
+type CallArgs struct {
+	From common.Address  `json:"from"`
+	To   *common.Address `json:"to"`
+	Data hexutil.Bytes   `json:"data"`
+}
+
+func (self *ChainAPI) Call(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber) (hexutil.Bytes, error) {
+	if blockNr != rpc.LatestBlockNumber {
+		return nil, fmt.Errorf("only the latest block is served")
+	}
+	return self.backend.CallContract(ctx, ethereum.CallMsg{From: args.From, To: args.To, Data: args.Data}, nil)
+}
+
+func (self *ChainAPI) GetCode(ctx context.Context, addr common.Address, blockNr rpc.BlockNumber) (hexutil.Bytes, error) {
+	if blockNr != rpc.LatestBlockNumber {
+		return nil, fmt.Errorf("only the latest block is served")
+	}
+	return self.backend.CodeAt(ctx, addr, nil)
+}
+
+// serves the chain over http json rpc, in the eth namespace like an ethereum node does
+func serveChain(backend *backends.SimulatedBackend) (string, func(), error) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &ChainAPI{backend: backend}); err != nil {
+		return "", nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	go http.Serve(listener, server)
+	return "http://" + listener.Addr().String(), func() {
+		listener.Close()
+		server.Stop()
+	}, nil
+}
+
+// a node with nothing but swarm, which doesn't look for peers
+// names are resolved with the ens apis given
+func newNode(name string, datadir string, port int, ensAPIs []string) (*node.Node, error) {
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	stack, err := node.New(&node.Config{
+		DataDir: fmt.Sprintf("%s/%s", datadir, name),
+		P2P: p2p.Config{
+			PrivateKey:  privkey,
+			ListenAddr:  "127.0.0.1:0",
+			MaxPeers:    10,
+			NoDiscovery: true,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bzzconfig := api.NewConfig()
+	bzzconfig.Path = stack.InstanceDir()
+	bzzconfig.Init(privkey)
+	bzzconfig.Port = fmt.Sprintf("%d", port)
+	bzzconfig.EnsAPIs = ensAPIs
+	err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		return swarm.NewSwarm(bzzconfig, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := stack.Start(); err != nil {
+		return nil, err
+	}
+	return stack, nil
+}
+
+// the http gateway is started in the background, so we wait until it answers
+func waitGateway(gateway string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		res, err := http.Get(gateway + "/bzz-raw:/")
+		if err == nil {
+			res.Body.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("gateway %s not up: %v", gateway, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+// gets a bzz:// uri through the gateway, which takes it as bzz:/<name>/<path>
+// the chunks may not have reached the node yet, so not found is tried again until the timeout
+func get(gateway string, rawuri string, timeout time.Duration) (int, []byte, error) {
+	uri, err := api.Parse(rawuri)
+	if err != nil {
+		return 0, nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		res, err := http.Get(gateway + "/" + uri.String())
+		if err != nil {
+			return 0, nil, err
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return 0, nil, err
+		}
+		if res.StatusCode != http.StatusNotFound || time.Now().After(deadline) {
+			return res.StatusCode, body, nil
+		}
+		time.Sleep(time.Millisecond * 500)
+	}
+}
@@ -0,0 +1,220 @@
+// builds a website manifest by hand, and checks an embedded swarm node serves its paths
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/swarm"
+	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/api/client"
+)
+
+var (
+	portFlag    = flag.Int("port", 8542, "port of the http gateway of the embedded swarm node")
+	verboseFlag = flag.Bool("v", false, "print debug output")
+)
+
+func init() {
+	flag.Parse()
+	if *verboseFlag {
+		log.Root().SetHandler(log.CallerFileHandler(log.LvlFilterHandler(log.LvlDebug, log.StreamHandler(os.Stderr, log.TerminalFormat(false)))))
+	}
+}
+
+// a file of the site, at the path it's served on
+type file struct {
+	path        string
+	contentType string
+	data        []byte
+}
+
+var site = []file{
+	{"index.html", "text/html; charset=utf-8", []byte(`<html><head><link rel="stylesheet" href="assets/style.css"></head><body><img src="assets/img/logo.svg"><a href="docs/guide/intro.html">guide</a></body></html>`)},
+	{"assets/style.css", "text/css; charset=utf-8", []byte("body { font-family: sans-serif; }")},
+	{"assets/app.js", "application/javascript", []byte("console.log('hello swarm')")},
+	{"assets/img/logo.svg", "image/svg+xml", []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="16" height="16"><circle cx="8" cy="8" r="8"/></svg>`)},
+	{"docs/guide/intro.html", "text/html; charset=utf-8", []byte("<html><body>read the swarm guide</body></html>")},
+	{"docs/guide/advanced.html", "text/html; charset=utf-8", []byte("<html><body>then read it again</body></html>")},
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	datadir, err := ioutil.TempDir("", "swarm-manifest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(datadir)
+
+	stack, err := newNode(datadir, *portFlag)
+	if err != nil {
+		return fmt.Errorf("node fail: %v", err)
+	}
+	defer stack.Stop()
+
+	gateway := fmt.Sprintf("http://localhost:%d", *portFlag)
+	if err := waitGateway(gateway, time.Second*10); err != nil {
+		return err
+	}
+	bzz := client.NewClient(gateway)
+
+	// the files are uploaded as they are, swarm only knows their hash
+	// the manifest is what gives them paths and content types
+	manifest := &api.Manifest{}
+	for _, f := range site {
+		hash, err := bzz.UploadRaw(bytes.NewReader(f.data), int64(len(f.data)), false)
+		if err != nil {
+			return fmt.Errorf("upload %s fail: %v", f.path, err)
+		}
+		fmt.Printf("uploaded %-26s %s\n", f.path, hash)
+		manifest.Entries = append(manifest.Entries, api.ManifestEntry{
+			Hash:        hash,
+			Path:        f.path,
+			ContentType: f.contentType,
+			Size:        int64(len(f.data)),
+			ModTime:     time.Now(),
+		})
+	}
+
+	// the entry with the empty path is served for the root of the manifest
+	// a path with a trailing slash does the same for a directory, the gateway has no index files of its own
+	manifest.Entries = append(manifest.Entries, index("", manifest.Entries[0]), index("docs/guide/", manifest.Entries[4]))
+
+	// the manifest is json uploaded like any other content
+	// the node turns its flat list of paths into a trie when it reads it
+	root, err := bzz.UploadManifest(manifest, false)
+	if err != nil {
+		return fmt.Errorf("manifest upload fail: %v", err)
+	}
+	fmt.Printf("\nmanifest of %d entries has hash %s\n\n", len(manifest.Entries), root)
+
+	// every path resolves to its file, with the content type of its entry
+	for _, f := range site {
+		if err := expect(gateway, root, f.path, http.StatusOK, f.contentType, f.data); err != nil {
+			return err
+		}
+	}
+	if err := expect(gateway, root, "", http.StatusOK, site[0].contentType, site[0].data); err != nil {
+		return err
+	}
+	if err := expect(gateway, root, "docs/guide/", http.StatusOK, site[4].contentType, site[4].data); err != nil {
+		return err
+	}
+
+	// paths that lead nowhere are not found
+	if err := expect(gateway, root, "assets/missing.css", http.StatusNotFound, "", nil); err != nil {
+		return err
+	}
+
+	// the manifest can be listed like a directory
+	list, err := bzz.List(root, "assets/", "")
+	if err != nil {
+		return fmt.Errorf("list fail: %v", err)
+	}
+	fmt.Println("\nlisting of assets/")
+	for _, prefix := range list.CommonPrefixes {
+		fmt.Printf("  %s\n", prefix)
+	}
+	for _, entry := range list.Entries {
+		fmt.Printf("  %-24s %s\n", entry.Path, entry.ContentType)
+	}
+	return nil
+}
+
+// an entry serving the same file as another one, on another path
+func index(path string, entry api.ManifestEntry) api.ManifestEntry {
+	entry.Path = path
+	return entry
+}
+
+// a node with nothing but swarm, which doesn't look for peers
+func newNode(datadir string, port int) (*node.Node, error) {
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	stack, err := node.New(&node.Config{
+		DataDir: datadir,
+		P2P: p2p.Config{
+			PrivateKey:  privkey,
+			ListenAddr:  "127.0.0.1:0",
+			NoDiscovery: true,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bzzconfig := api.NewConfig()
+	bzzconfig.Path = datadir
+	bzzconfig.Init(privkey)
+	bzzconfig.Port = fmt.Sprintf("%d", port)
+	err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		return swarm.NewSwarm(bzzconfig, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := stack.Start(); err != nil {
+		return nil, err
+	}
+	return stack, nil
+}
+
+// the http gateway is started in the background, so we wait until it answers
+func waitGateway(gateway string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		res, err := http.Get(gateway + "/bzz-raw:/")
+		if err == nil {
+			res.Body.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("gateway %s not up: %v", gateway, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+// gets the path from the manifest through the gateway, and checks the answer
+// the content type and data are only checked if given
+func expect(gateway string, root string, path string, status int, contentType string, data []byte) error {
+	url := fmt.Sprintf("%s/bzz:/%s/%s", gateway, root, path)
+	res, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != status {
+		return fmt.Errorf("/%s: status %d, expected %d", path, res.StatusCode, status)
+	}
+	if contentType != "" && res.Header.Get("Content-Type") != contentType {
+		return fmt.Errorf("/%s: content type '%s', expected '%s'", path, res.Header.Get("Content-Type"), contentType)
+	}
+	if data != nil && !bytes.Equal(body, data) {
+		return fmt.Errorf("/%s: content differs", path)
+	}
+	fmt.Printf("/%-26s %d %s\n", path, res.StatusCode, res.Header.Get("Content-Type"))
+	return nil
+}
@@ -0,0 +1,282 @@
+// shows what the stream protocol does to sync content between two swarm nodes
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/simulation"
+	"github.com/ethereum/go-ethereum/swarm/network/stream"
+	"github.com/ethereum/go-ethereum/swarm/state"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+var (
+	sizeFlag    = flag.Int("size", 100000, "size of random payload uploaded to the first node")
+	timeoutFlag = flag.Duration("timeout", time.Second*30, "how long to wait for the second node to have all chunks")
+	verboseFlag = flag.Bool("v", false, "print debug output")
+)
+
+func init() {
+	flag.Parse()
+	lvl := log.LvlInfo
+	if *verboseFlag {
+		lvl = log.LvlDebug
+	}
+	log.Root().SetHandler(log.CallerFileHandler(log.LvlFilterHandler(lvl, log.StreamHandler(os.Stderr, log.TerminalFormat(false)))))
+
+	// metrics made as the nodes run are counted from here on
+	// the ones the packages made when they were loaded stay disabled, that takes the -metrics flag geth has
+	metrics.Enabled = true
+}
+
+// the stream messages a node got, by type
+// the hook sees every message after it's decoded, like swap accounting does
+type tap struct {
+	name       string
+	mu         sync.Mutex
+	subscribed int // streams the peer subscribed to, one for each proximity order bin and the retrieve requests
+	offered    int // hashes offered to the node
+	wanted     int // of the hashes the node offered, the ones the peer didn't have
+	synced     int // chunks delivered because they were wanted
+	requested  int // chunks asked for outright
+}
+
+func (self *tap) Send(peer *protocols.Peer, size uint32, msg interface{}) error {
+	return nil
+}
+
+func (self *tap) Receive(peer *protocols.Peer, size uint32, msg interface{}) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	switch msg := msg.(type) {
+	case *stream.SubscribeMsg:
+		self.subscribed++
+		log.Info("subscribe", "node", self.name, "stream", msg.Stream)
+	case *stream.OfferedHashesMsg:
+		n := len(msg.Hashes) / stream.HashSize
+		self.offered += n
+		log.Info("offered hashes", "node", self.name, "stream", msg.Stream, "from", msg.From, "to", msg.To, "hashes", n)
+	case *stream.WantedHashesMsg:
+		n := countBits(msg.Want)
+		self.wanted += n
+		log.Info("wanted hashes", "node", self.name, "stream", msg.Stream, "hashes", n)
+	case *stream.ChunkDeliveryMsgSyncing:
+		self.synced++
+		log.Debug("chunk synced", "node", self.name, "addr", msg.Addr)
+	case *stream.RetrieveRequestMsg:
+		self.requested++
+		log.Info("chunk requested", "node", self.name, "addr", msg.Addr)
+	}
+	return nil
+}
+
+// what we need to know about each node
+type simNode struct {
+	tap        *tap
+	localStore *storage.LocalStore
+	fileStore  *storage.FileStore
+}
+
+func newServices(datadir string) (map[string]simulation.ServiceFunc, func(enode.ID) *simNode) {
+	var mu sync.Mutex
+	nodes := make(map[enode.ID]*simNode)
+	getNode := func(id enode.ID) *simNode {
+		mu.Lock()
+		defer mu.Unlock()
+		return nodes[id]
+	}
+
+	return map[string]simulation.ServiceFunc{
+		"bzz": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, func(), error) {
+			addr := network.NewAddr(ctx.Config.Node())
+			kad := network.NewKademlia(addr.Over(), network.NewKadParams())
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+
+			// the chunk store, as a swarm node sets it up
+			// the net store asks peers for chunks the local store doesn't have
+			params := storage.NewDefaultLocalStoreParams()
+			params.Init(fmt.Sprintf("%s/%s", datadir, ctx.Config.ID.TerminalString()))
+			params.BaseKey = addr.Over()
+			localStore, err := storage.NewLocalStore(params, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			netStore, err := storage.NewNetStore(localStore, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			delivery := stream.NewDelivery(kad, netStore)
+			netStore.NewNetFetcherFunc = network.NewFetcherFactory(delivery.RequestFromPeers, true).New
+
+			// syncing subscribes to the peers as soon as they're in the kademlia table
+			registry := stream.NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &stream.RegistryOptions{
+				Syncing:         stream.SyncingAutoSubscribe,
+				Retrieval:       stream.RetrievalEnabled,
+				SyncUpdateDelay: time.Second,
+			}, nil)
+			n := &simNode{
+				tap:        &tap{name: ctx.Config.ID.TerminalString()},
+				localStore: localStore,
+				fileStore:  storage.NewFileStore(netStore, storage.NewFileStoreParams()),
+			}
+			registry.GetSpec().Hook = n.tap
+			mu.Lock()
+			nodes[ctx.Config.ID] = n
+			mu.Unlock()
+
+			// the stream protocol runs on the bzz connection to the peer, after the bzz handshake
+			config := &network.BzzConfig{
+				OverlayAddr:  addr.Over(),
+				UnderlayAddr: addr.Under(),
+				HiveParams:   network.NewHiveParams(),
+			}
+			bzz := network.NewBzz(config, kad, state.NewInmemoryStore(), registry.GetSpec(), registry.Run)
+			cleanup := func() {
+				registry.Close()
+				netStore.Close()
+			}
+			return bzz, cleanup, nil
+		},
+	}, getNode
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	datadir, err := ioutil.TempDir("", "swarm-sync")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(datadir)
+
+	services, getNode := newServices(datadir)
+	sim := simulation.New(services)
+	defer sim.Close()
+	ids, err := sim.AddNodesAndConnectChain(2)
+	if err != nil {
+		return fmt.Errorf("create network fail: %v", err)
+	}
+	uploader, syncer := getNode(ids[0]), getNode(ids[1])
+	log.Info("nodes", "uploader", uploader.tap.name, "syncer", syncer.tap.name)
+
+	// the content goes into the uploader's store only
+	ctx := context.Background()
+	data := make([]byte, *sizeFlag)
+	rand.Read(data)
+	root, wait, err := uploader.fileStore.Store(ctx, bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		return fmt.Errorf("upload fail: %v", err)
+	}
+	if err := wait(ctx); err != nil {
+		return fmt.Errorf("upload fail: %v", err)
+	}
+	chunks, err := allChunks(uploader.localStore)
+	if err != nil {
+		return err
+	}
+	log.Info("uploaded", "root", root, "size", len(data), "chunks", len(chunks))
+
+	// the syncer's local store gets the chunks, and nobody asks for them
+	// the local store never asks the network, unlike the net store
+	deadline := time.Now().Add(*timeoutFlag)
+	for {
+		missing := 0
+		for _, addr := range chunks {
+			if _, err := syncer.localStore.Get(ctx, addr); err != nil {
+				missing++
+			}
+		}
+		if missing == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d of %d chunks not synced", missing, len(chunks))
+		}
+		time.Sleep(time.Millisecond * 250)
+	}
+
+	// so the content can be read from the syncer's store alone
+	reader, _ := storage.NewFileStore(syncer.localStore, storage.NewFileStoreParams()).Retrieve(ctx, root)
+	synced, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("read from synced store fail: %v", err)
+	}
+	if !bytes.Equal(synced, data) {
+		return fmt.Errorf("content read from the synced store differs")
+	}
+	log.Info("content synced", "root", root)
+
+	fmt.Printf("\n%-10s %-14s %-10s %-10s %-10s %s\n", "node", "subscriptions", "offered", "wanted", "synced", "requested")
+	for i, n := range []*simNode{uploader, syncer} {
+		role := []string{"uploader", "syncer"}[i]
+		n.tap.mu.Lock()
+		fmt.Printf("%-10s %-14d %-10d %-10d %-10d %d\n", role, n.tap.subscribed, n.tap.offered, n.tap.wanted, n.tap.synced, n.tap.requested)
+		requested := n.tap.requested
+		n.tap.mu.Unlock()
+		if requested > 0 {
+			return fmt.Errorf("%s was asked for %d chunks, they should have come by syncing alone", role, requested)
+		}
+	}
+
+	// the same, as counted by the stream package, for both nodes together
+	fmt.Printf("\n%-40s %s\n", "metric", "count")
+	var names []string
+	metrics.DefaultRegistry.Each(func(name string, i interface{}) {
+		if _, ok := i.(metrics.Counter); ok && strings.HasPrefix(name, "peer.handle") {
+			names = append(names, name)
+		}
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%-40s %d\n", name, metrics.DefaultRegistry.Get(name).(metrics.Counter).Count())
+	}
+	return nil
+}
+
+// the addresses of all chunks in the store, bin by bin
+func allChunks(localStore *storage.LocalStore) ([]storage.Address, error) {
+	var addrs []storage.Address
+	for po := 0; po <= storage.MaxPO; po++ {
+		err := localStore.Iterator(0, math.MaxUint64, uint8(po), func(addr storage.Address, _ uint64) bool {
+			addrs = append(addrs, addr)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return addrs, nil
+}
+
+func countBits(b []byte) int {
+	n := 0
+	for _, c := range b {
+		for ; c != 0; c &= c - 1 {
+			n++
+		}
+	}
+	return n
+}